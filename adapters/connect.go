@@ -0,0 +1,78 @@
+package adapters
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "persistence-layer/utils"
+    "time"
+)
+
+// RetryPolicy controls how adapter constructors establish their initial connection.
+type RetryPolicy struct {
+    MaxWait        time.Duration // Total time allowed for the backend to become reachable.
+    AttemptTimeout time.Duration // Upper bound for a single connection attempt.
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+    // Lazy returns the adapter even if the backend never became reachable within MaxWait;
+    // the client connects on first use instead of failing startup.
+    Lazy bool
+}
+
+// DefaultRetryPolicy waits up to 30 seconds for a backend with exponential backoff between attempts.
+var DefaultRetryPolicy = RetryPolicy{
+    MaxWait:        30 * time.Second,
+    AttemptTimeout: 5 * time.Second,
+    InitialBackoff: 500 * time.Millisecond,
+    MaxBackoff:     5 * time.Second,
+}
+
+// connectWithRetry calls connect until it succeeds or the policy's MaxWait elapses. Each attempt's
+// context is bounded by AttemptTimeout and the remaining startup budget. When the policy is lazy,
+// the final error is logged and swallowed so the adapter can be returned unconnected.
+func connectWithRetry(backend string, policy RetryPolicy, connect func(ctx context.Context) error) error {
+    if policy.MaxWait <= 0 {
+        policy.MaxWait = DefaultRetryPolicy.MaxWait
+    }
+    if policy.AttemptTimeout <= 0 {
+        policy.AttemptTimeout = DefaultRetryPolicy.AttemptTimeout
+    }
+    if policy.InitialBackoff <= 0 {
+        policy.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+    }
+    if policy.MaxBackoff < policy.InitialBackoff {
+        policy.MaxBackoff = policy.InitialBackoff
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), policy.MaxWait)
+    defer cancel()
+
+    backoff := policy.InitialBackoff
+    for attempt := 1; ; attempt++ {
+        attemptCtx, cancelAttempt := context.WithTimeout(ctx, policy.AttemptTimeout)
+        err := connect(attemptCtx)
+        cancelAttempt()
+        if err == nil {
+            return nil
+        }
+        utils.LogError(err, map[string]interface{}{"operation": "Connect", "backend": backend, "attempt": attempt})
+
+        // Full jitter keeps a fleet of restarting instances from retrying in lockstep.
+        sleep := time.Duration(rand.Int63n(int64(backoff)) + 1)
+        select {
+        case <-ctx.Done():
+            err = fmt.Errorf("%s not reachable after %s: %w", backend, policy.MaxWait, err)
+            if policy.Lazy {
+                utils.LogError(err, map[string]interface{}{"operation": "Connect", "backend": backend, "lazy": true})
+                return nil
+            }
+            return err
+        case <-time.After(sleep):
+        }
+
+        backoff *= 2
+        if backoff > policy.MaxBackoff {
+            backoff = policy.MaxBackoff
+        }
+    }
+}
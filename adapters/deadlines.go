@@ -0,0 +1,45 @@
+package adapters
+
+import (
+    "context"
+    "time"
+)
+
+// Deadlines are the default deadlines of a backend's calls, applied when the caller's context has
+// none, so one slow backend can't hold up a request indefinitely. Zero leaves calls of that kind
+// unbounded.
+type Deadlines struct {
+    Read  time.Duration
+    Write time.Duration
+}
+
+type unboundedKey struct{}
+
+// WithoutDeadlines returns a context whose calls run without the default Deadlines of a backend
+// and without the default SQL statement timeout, for migrations and admin jobs whose statements
+// legitimately run long. A timeout set with WithStatementTimeout still applies.
+func WithoutDeadlines(ctx context.Context) context.Context {
+    return context.WithValue(ctx, unboundedKey{}, true)
+}
+
+// unbounded reports whether ctx was returned by WithoutDeadlines.
+func unbounded(ctx context.Context) bool {
+    v, _ := ctx.Value(unboundedKey{}).(bool)
+    return v
+}
+
+// bound returns ctx with the read or write deadline unless it already has one, and the function
+// releasing it.
+func (d Deadlines) bound(ctx context.Context, write bool) (context.Context, context.CancelFunc) {
+    timeout := d.Read
+    if write {
+        timeout = d.Write
+    }
+    if timeout <= 0 || unbounded(ctx) {
+        return ctx, func() {}
+    }
+    if _, ok := ctx.Deadline(); ok {
+        return ctx, func() {}
+    }
+    return context.WithTimeout(ctx, timeout)
+}
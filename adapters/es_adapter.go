@@ -5,27 +5,177 @@ import (
     "context"
     "encoding/json"
     "errors"
+    "fmt"
+    "io"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
 
     "github.com/elastic/go-elasticsearch/v8"
     "github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
 type ESAdapter struct {
-    client *elasticsearch.Client
-    ctx    context.Context
+    client    *elasticsearch.Client
+    ctx       context.Context
+    flavor    string
+    deadlines Deadlines
 }
 
-// NewESAdapter initializes a new Elasticsearch adapter with a given URI.
-func NewESAdapter(uri string) *ESAdapter {
-    cfg := elasticsearch.Config{Addresses: []string{uri}}
-    client, err := elasticsearch.NewClient(cfg)
+// ESOptions configures the Elasticsearch client beyond the node addresses.
+type ESOptions struct {
+    Addresses []string
+    // Flavor is FlavorElasticsearch (default) or FlavorOpenSearch, e.g. for Amazon OpenSearch
+    // Service.
+    Flavor string
+    // MaxRetries bounds retries of requests failing with 429, 502, 503 or 504 or a network error;
+    // each retry may go to another node. Defaults to 3.
+    MaxRetries int
+    // RetryBackoff is the delay before the first retry, doubled per attempt up to MaxRetryBackoff,
+    // with jitter. Defaults to 100ms and 5s.
+    RetryBackoff    time.Duration
+    MaxRetryBackoff time.Duration
+    // RequestTimeout bounds each attempt of a request, including reading the response; zero waits
+    // indefinitely.
+    RequestTimeout time.Duration
+    // SniffOnStart replaces Addresses with the nodes the cluster reports at startup, and
+    // SniffInterval refreshes that list periodically. Leave both off behind a load balancer or on
+    // hosted clusters whose nodes aren't directly reachable.
+    SniffOnStart  bool
+    SniffInterval time.Duration
+}
+
+// esRetryStatuses are the responses worth retrying on another attempt or node.
+var esRetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// NewESAdapter initializes a new Elasticsearch adapter with a given URI, pinging the cluster according to policy.
+// Several nodes may be given as a comma-separated list.
+func NewESAdapter(uri string, policy RetryPolicy) (*ESAdapter, error) {
+    return NewESAdapterWithOptions(ESOptions{Addresses: ESAddresses(uri)}, policy)
+}
+
+// ESAddresses splits a comma-separated list of node URIs.
+func ESAddresses(uri string) []string {
+    var addresses []string
+    for _, address := range strings.Split(uri, ",") {
+        if address = strings.TrimSpace(address); address != "" {
+            addresses = append(addresses, address)
+        }
+    }
+    return addresses
+}
+
+// NewESAdapterWithOptions initializes an Elasticsearch adapter over several nodes with retries,
+// backoff, request timeouts and node sniffing, pinging the cluster according to policy.
+func NewESAdapterWithOptions(opts ESOptions, policy RetryPolicy) (*ESAdapter, error) {
+    switch opts.Flavor {
+    case "":
+        opts.Flavor = FlavorElasticsearch
+    case FlavorElasticsearch, FlavorOpenSearch:
+    default:
+        return nil, fmt.Errorf("unknown search engine flavor %q", opts.Flavor)
+    }
+    client, err := elasticsearch.NewClient(esConfig(opts))
+    if err != nil {
+        return nil, fmt.Errorf("failed to create Elasticsearch client: %w", err)
+    }
+
+    err = connectWithRetry("elasticsearch", policy, func(ctx context.Context) error {
+        res, err := client.Ping(client.Ping.WithContext(ctx))
+        if err != nil {
+            return err
+        }
+        defer res.Body.Close()
+        if res.IsError() {
+            return errors.New("error pinging Elasticsearch: " + res.String())
+        }
+        return nil
+    })
     if err != nil {
-        panic("Failed to connect to Elasticsearch")
+        return nil, fmt.Errorf("failed to connect to Elasticsearch: %w", err)
     }
     return &ESAdapter{
         client: client,
         ctx:    context.Background(),
+        flavor: opts.Flavor,
+    }, nil
+}
+
+func esConfig(opts ESOptions) elasticsearch.Config {
+    maxRetries := opts.MaxRetries
+    if maxRetries <= 0 {
+        maxRetries = 3
+    }
+    initial, max := opts.RetryBackoff, opts.MaxRetryBackoff
+    if initial <= 0 {
+        initial = 100 * time.Millisecond
     }
+    if max <= 0 {
+        max = 5 * time.Second
+    }
+    cfg := elasticsearch.Config{
+        Addresses:     opts.Addresses,
+        RetryOnStatus: esRetryStatuses,
+        MaxRetries:    maxRetries,
+        RetryBackoff: func(attempt int) time.Duration {
+            backoff := initial << uint(attempt-1)
+            if backoff > max || backoff <= 0 {
+                backoff = max
+            }
+            // Full jitter keeps clients from retrying in lockstep after a node restart.
+            return time.Duration(rand.Int63n(int64(backoff)) + 1)
+        },
+        DiscoverNodesOnStart:  opts.SniffOnStart,
+        DiscoverNodesInterval: opts.SniffInterval,
+    }
+    var transport http.RoundTripper = http.DefaultTransport
+    if opts.RequestTimeout > 0 {
+        transport = &timeoutTransport{next: transport, timeout: opts.RequestTimeout}
+    }
+    if opts.Flavor == FlavorOpenSearch {
+        transport = &productCheckTransport{next: transport}
+    }
+    cfg.Transport = transport
+    return cfg
+}
+
+// timeoutTransport bounds each HTTP attempt, including reading its body, so a hung node fails over
+// to the next one instead of stalling the request.
+type timeoutTransport struct {
+    next    http.RoundTripper
+    timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+    res, err := t.next.RoundTrip(req.WithContext(ctx))
+    if err != nil {
+        cancel()
+        return nil, err
+    }
+    res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+    return res, nil
+}
+
+type cancelOnClose struct {
+    io.ReadCloser
+    cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+    err := c.ReadCloser.Close()
+    c.cancel()
+    return err
+}
+
+// SetDeadlines bounds searches by d.Read and the indexing, updates and deletes of documents by
+// d.Write, retries included. Operations by query, index management and snapshots are left
+// unbounded. Call it once, at startup.
+func (e *ESAdapter) SetDeadlines(d Deadlines) error {
+    e.deadlines = d
+    return nil
 }
 
 // IndexDocument indexes a model into Elasticsearch.
@@ -36,7 +186,7 @@ func (e *ESAdapter) IndexDocument(index string, model interface{}) error {
     }
 
     // Use reflection to extract the ID field from the model.
-    id, err := extractID(model)
+    id, err := DocumentID(model)
     if err != nil {
         return err
     }
@@ -48,7 +198,9 @@ func (e *ESAdapter) IndexDocument(index string, model interface{}) error {
         Refresh:    "true",
     }
 
-    res, err := req.Do(e.ctx, e.client)
+    ctx, cancel := e.deadlines.bound(e.ctx, true)
+    defer cancel()
+    res, err := req.Do(ctx, e.client)
     if err != nil {
         return err
     }
@@ -68,7 +220,7 @@ func (e *ESAdapter) UpdateDocument(index string, model interface{}) error {
         return err
     }
 
-    id, err := extractID(model)
+    id, err := DocumentID(model)
     if err != nil {
         return err
     }
@@ -80,7 +232,9 @@ func (e *ESAdapter) UpdateDocument(index string, model interface{}) error {
         Refresh:    "true",
     }
 
-    res, err := req.Do(e.ctx, e.client)
+    ctx, cancel := e.deadlines.bound(e.ctx, true)
+    defer cancel()
+    res, err := req.Do(ctx, e.client)
     if err != nil {
         return err
     }
@@ -93,18 +247,21 @@ func (e *ESAdapter) UpdateDocument(index string, model interface{}) error {
     return nil
 }
 
-// Search executes a search query in Elasticsearch.
+// Search executes a search query in Elasticsearch. Counting hits is left to the query's
+// track_total_hits: Elasticsearch counts up to 10000 when it is unset, so only queries that ask
+// for an exact total pay for one, as ORM.SearchPage does by CountStrategy.
 func (e *ESAdapter) Search(index string, query map[string]interface{}, result interface{}) error {
     body, err := json.Marshal(query)
     if err != nil {
         return err
     }
 
+    ctx, cancel := e.deadlines.bound(e.ctx, false)
+    defer cancel()
     res, err := e.client.Search(
-        e.client.Search.WithContext(e.ctx),
         e.client.Search.WithIndex(index),
         e.client.Search.WithBody(bytes.NewReader(body)),
-        e.client.Search.WithTrackTotalHits(true),
+        e.client.Search.WithContext(ctx),
     )
     if err != nil {
         return err
@@ -118,9 +275,25 @@ func (e *ESAdapter) Search(index string, query map[string]interface{}, result in
     return json.NewDecoder(res.Body).Decode(result)
 }
 
+// Aggregate runs aggs over the documents of index matching query, a query clause such as
+// {"term": {"status": "active"}} (nil matches all), and decodes the response's aggregations into
+// result, usually an *Aggregations.
+func (e *ESAdapter) Aggregate(index string, query map[string]interface{}, aggs map[string]interface{}, result interface{}) error {
+    var response struct {
+        Aggregations json.RawMessage `json:"aggregations"`
+    }
+    if err := e.Search(index, aggregateBody(query, aggs), &response); err != nil {
+        return err
+    }
+    if len(response.Aggregations) == 0 {
+        return errors.New("error executing aggregation: response has no aggregations")
+    }
+    return json.Unmarshal(response.Aggregations, result)
+}
+
 // DeleteDocument removes a document from Elasticsearch.
 func (e *ESAdapter) DeleteDocument(index string, model interface{}) error {
-    id, err := extractID(model)
+    id, err := DocumentID(model)
     if err != nil {
         return err
     }
@@ -131,7 +304,9 @@ func (e *ESAdapter) DeleteDocument(index string, model interface{}) error {
         Refresh:    "true",
     }
 
-    res, err := req.Do(e.ctx, e.client)
+    ctx, cancel := e.deadlines.bound(e.ctx, true)
+    defer cancel()
+    res, err := req.Do(ctx, e.client)
     if err != nil {
         return err
     }
@@ -144,12 +319,54 @@ func (e *ESAdapter) DeleteDocument(index string, model interface{}) error {
     return nil
 }
 
-// extractID extracts the ID as a string from a model struct.
-func extractID(model interface{}) (string, error) {
+// EnsureIndex creates index with the given mappings unless it already exists.
+func (e *ESAdapter) EnsureIndex(index string, mapping map[string]interface{}) error {
+    res, err := e.client.Indices.Exists([]string{index}, e.client.Indices.Exists.WithContext(e.ctx))
+    if err != nil {
+        return err
+    }
+    res.Body.Close()
+    if res.StatusCode == 200 {
+        return nil
+    }
+
+    request := map[string]interface{}{"mappings": mapping}
+    if e.flavor == FlavorOpenSearch {
+        var knn bool
+        if request["mappings"], knn = openSearchMapping(mapping); knn {
+            request["settings"] = map[string]interface{}{"index": map[string]interface{}{"knn": true}}
+        }
+    }
+    body, err := json.Marshal(request)
+    if err != nil {
+        return err
+    }
+    res, err = e.client.Indices.Create(index,
+        e.client.Indices.Create.WithContext(e.ctx),
+        e.client.Indices.Create.WithBody(bytes.NewReader(body)),
+    )
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return errors.New("error creating index: " + res.String())
+    }
+
+    return nil
+}
+
+// DocumentID extracts the ID as a string from a model struct, using GetKey for string-keyed models
+// and GetID otherwise.
+func DocumentID(model interface{}) (string, error) {
+    if m, ok := model.(interface{ GetKey() string }); ok {
+        return m.GetKey(), nil
+    }
     if m, ok := model.(interface{ GetID() uint64 }); ok {
-        return string(m.GetID()), nil
+        return strconv.FormatUint(m.GetID(), 10), nil
     }
-    return "", errors.New("model does not have a GetID method")
+    return "", errors.New("model does not have a GetID or GetKey method")
 }
 
 // Close is a placeholder for compatibility but doesn't need to close anything for Elasticsearch.
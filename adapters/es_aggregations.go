@@ -0,0 +1,226 @@
+package adapters
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// Aggregations is the "aggregations" object of an Elasticsearch response, keyed by aggregation
+// name. Use Terms, DateHistogram and Range to decode one aggregation into typed buckets:
+//
+//     var aggs adapters.Aggregations
+//     err := store.Aggregate("products", nil, map[string]interface{}{
+//         "by_category": adapters.TermsAgg("category", 20),
+//         "by_price":    adapters.RangeAgg("price", adapters.AggRange{To: adapters.Bound(10)}, adapters.AggRange{From: adapters.Bound(10)}),
+//     }, &aggs)
+//     categories, err := aggs.Terms("by_category")
+type Aggregations map[string]json.RawMessage
+
+// TermsBucket is one bucket of a terms aggregation.
+type TermsBucket struct {
+    Key         interface{}  `json:"key"`
+    KeyAsString string       `json:"key_as_string,omitempty"`
+    DocCount    int64        `json:"doc_count"`
+    Sub         Aggregations `json:"-"` // Sub-aggregations of the bucket.
+}
+
+// TermsResult is the result of a terms aggregation.
+type TermsResult struct {
+    DocCountErrorUpperBound int64         `json:"doc_count_error_upper_bound"`
+    SumOtherDocCount        int64         `json:"sum_other_doc_count"`
+    Buckets                 []TermsBucket `json:"buckets"`
+}
+
+// DateHistogramBucket is one bucket of a date_histogram aggregation. Key is the start of the
+// bucket in epoch milliseconds.
+type DateHistogramBucket struct {
+    Key         int64        `json:"key"`
+    KeyAsString string       `json:"key_as_string"`
+    DocCount    int64        `json:"doc_count"`
+    Sub         Aggregations `json:"-"`
+}
+
+// Time returns the start of the bucket.
+func (b DateHistogramBucket) Time() time.Time {
+    return time.UnixMilli(b.Key).UTC()
+}
+
+// RangeBucket is one bucket of a range aggregation. From and To are nil for open ends.
+type RangeBucket struct {
+    Key      string       `json:"key"`
+    From     *float64     `json:"from,omitempty"`
+    To       *float64     `json:"to,omitempty"`
+    DocCount int64        `json:"doc_count"`
+    Sub      Aggregations `json:"-"`
+}
+
+// AggRange is one range of RangeAgg, like RangeBucket. A nil From or To leaves that end open; set
+// Key to name the bucket.
+type AggRange struct {
+    Key  string
+    From *float64
+    To   *float64
+}
+
+// Bound returns a pointer to v, for the ends of an AggRange.
+func Bound(v float64) *float64 {
+    return &v
+}
+
+// Terms decodes the terms aggregation name.
+func (a Aggregations) Terms(name string) (*TermsResult, error) {
+    var raw struct {
+        DocCountErrorUpperBound int64             `json:"doc_count_error_upper_bound"`
+        SumOtherDocCount        int64             `json:"sum_other_doc_count"`
+        Buckets                 []json.RawMessage `json:"buckets"`
+    }
+    if err := a.decode(name, &raw); err != nil {
+        return nil, err
+    }
+    result := &TermsResult{DocCountErrorUpperBound: raw.DocCountErrorUpperBound, SumOtherDocCount: raw.SumOtherDocCount}
+    for _, data := range raw.Buckets {
+        var bucket TermsBucket
+        sub, err := decodeBucket(data, &bucket)
+        if err != nil {
+            return nil, err
+        }
+        bucket.Sub = sub
+        result.Buckets = append(result.Buckets, bucket)
+    }
+    return result, nil
+}
+
+// DateHistogram decodes the date_histogram aggregation name.
+func (a Aggregations) DateHistogram(name string) ([]DateHistogramBucket, error) {
+    var raw struct {
+        Buckets []json.RawMessage `json:"buckets"`
+    }
+    if err := a.decode(name, &raw); err != nil {
+        return nil, err
+    }
+    buckets := make([]DateHistogramBucket, 0, len(raw.Buckets))
+    for _, data := range raw.Buckets {
+        var bucket DateHistogramBucket
+        sub, err := decodeBucket(data, &bucket)
+        if err != nil {
+            return nil, err
+        }
+        bucket.Sub = sub
+        buckets = append(buckets, bucket)
+    }
+    return buckets, nil
+}
+
+// Range decodes the range aggregation name. Keyed range aggregations are not supported.
+func (a Aggregations) Range(name string) ([]RangeBucket, error) {
+    var raw struct {
+        Buckets []json.RawMessage `json:"buckets"`
+    }
+    if err := a.decode(name, &raw); err != nil {
+        return nil, err
+    }
+    buckets := make([]RangeBucket, 0, len(raw.Buckets))
+    for _, data := range raw.Buckets {
+        var bucket RangeBucket
+        sub, err := decodeBucket(data, &bucket)
+        if err != nil {
+            return nil, err
+        }
+        bucket.Sub = sub
+        buckets = append(buckets, bucket)
+    }
+    return buckets, nil
+}
+
+// Value decodes a single-value metric aggregation (avg, sum, min, max, cardinality, ...). It
+// returns nil when the metric has no value, e.g. the average of no documents.
+func (a Aggregations) Value(name string) (*float64, error) {
+    var raw struct {
+        Value *float64 `json:"value"`
+    }
+    if err := a.decode(name, &raw); err != nil {
+        return nil, err
+    }
+    return raw.Value, nil
+}
+
+func (a Aggregations) decode(name string, dest interface{}) error {
+    data, ok := a[name]
+    if !ok {
+        return fmt.Errorf("aggregation %q not found in response", name)
+    }
+    return json.Unmarshal(data, dest)
+}
+
+// bucketFields are the keys of a bucket that are not sub-aggregations.
+var bucketFields = map[string]bool{"key": true, "key_as_string": true, "doc_count": true, "from": true, "from_as_string": true, "to": true, "to_as_string": true}
+
+// decodeBucket decodes a bucket into dest and returns its sub-aggregations.
+func decodeBucket(data json.RawMessage, dest interface{}) (Aggregations, error) {
+    if err := json.Unmarshal(data, dest); err != nil {
+        return nil, err
+    }
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return nil, err
+    }
+    sub := Aggregations{}
+    for k, v := range fields {
+        if !bucketFields[k] {
+            sub[k] = v
+        }
+    }
+    return sub, nil
+}
+
+// TermsAgg builds a terms aggregation over field returning at most size buckets.
+func TermsAgg(field string, size int) map[string]interface{} {
+    return map[string]interface{}{"terms": map[string]interface{}{"field": field, "size": size}}
+}
+
+// DateHistogramAgg builds a date_histogram aggregation over field. Calendar units (minute, hour,
+// day, week, month, quarter, year) use calendar_interval; anything else, e.g. "90m", is a
+// fixed_interval.
+func DateHistogramAgg(field, interval string) map[string]interface{} {
+    kind := "fixed_interval"
+    switch interval {
+    case "minute", "1m", "hour", "1h", "day", "1d", "week", "1w", "month", "1M", "quarter", "1q", "year", "1y":
+        kind = "calendar_interval"
+    }
+    return map[string]interface{}{"date_histogram": map[string]interface{}{"field": field, kind: interval, "min_doc_count": 0}}
+}
+
+// RangeAgg builds a range aggregation over field.
+func RangeAgg(field string, ranges ...AggRange) map[string]interface{} {
+    specs := make([]map[string]interface{}, 0, len(ranges))
+    for _, r := range ranges {
+        spec := map[string]interface{}{}
+        if r.Key != "" {
+            spec["key"] = r.Key
+        }
+        if r.From != nil {
+            spec["from"] = *r.From
+        }
+        if r.To != nil {
+            spec["to"] = *r.To
+        }
+        specs = append(specs, spec)
+    }
+    return map[string]interface{}{"range": map[string]interface{}{"field": field, "ranges": specs}}
+}
+
+// WithSubAggs nests aggs under the aggregation agg, e.g. an average price per category.
+func WithSubAggs(agg map[string]interface{}, aggs map[string]interface{}) map[string]interface{} {
+    agg["aggs"] = aggs
+    return agg
+}
+
+// aggregateBody builds the request body of Aggregate: no hits, only aggregations over the
+// documents matching query.
+func aggregateBody(query, aggs map[string]interface{}) map[string]interface{} {
+    if query == nil {
+        query = map[string]interface{}{"match_all": map[string]interface{}{}}
+    }
+    return map[string]interface{}{"size": 0, "query": query, "aggs": aggs}
+}
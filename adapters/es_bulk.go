@@ -0,0 +1,93 @@
+package adapters
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+)
+
+// BulkIndex indexes models into index with one _bulk request. Documents are keyed by DocumentID;
+// the first per-document failure is returned after the whole batch has been attempted.
+func (e *ESAdapter) BulkIndex(index string, models []interface{}) error {
+    if len(models) == 0 {
+        return nil
+    }
+    var body bytes.Buffer
+    encoder := json.NewEncoder(&body)
+    for _, model := range models {
+        id, err := DocumentID(model)
+        if err != nil {
+            return err
+        }
+        if err := encoder.Encode(map[string]interface{}{"index": map[string]interface{}{"_index": index, "_id": id}}); err != nil {
+            return err
+        }
+        if err := encoder.Encode(model); err != nil {
+            return err
+        }
+    }
+
+    ctx, cancel := e.deadlines.bound(e.ctx, true)
+    defer cancel()
+    res, err := e.client.Bulk(bytes.NewReader(body.Bytes()), e.client.Bulk.WithContext(ctx))
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return errors.New("error bulk indexing: " + res.String())
+    }
+    var response struct {
+        Errors bool `json:"errors"`
+        Items  []map[string]struct {
+            ID     string          `json:"_id"`
+            Status int             `json:"status"`
+            Error  json.RawMessage `json:"error"`
+        } `json:"items"`
+    }
+    if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+        return err
+    }
+    if !response.Errors {
+        return nil
+    }
+    failed := 0
+    var first error
+    for _, item := range response.Items {
+        for _, result := range item {
+            if len(result.Error) > 0 {
+                failed++
+                if first == nil {
+                    first = fmt.Errorf("document %s: %s", result.ID, result.Error)
+                }
+            }
+        }
+    }
+    return fmt.Errorf("error bulk indexing: %d of %d documents failed, first: %w", failed, len(models), first)
+}
+
+// PointAlias atomically moves alias to index, removing it from any other index, so readers switch
+// from an old index to a rebuilt one without a gap.
+func (e *ESAdapter) PointAlias(alias, index string) error {
+    body, err := json.Marshal(map[string]interface{}{
+        "actions": []interface{}{
+            map[string]interface{}{"remove": map[string]interface{}{"index": "*", "alias": alias, "must_exist": false}},
+            map[string]interface{}{"add": map[string]interface{}{"index": index, "alias": alias}},
+        },
+    })
+    if err != nil {
+        return err
+    }
+    res, err := e.client.Indices.UpdateAliases(bytes.NewReader(body), e.client.Indices.UpdateAliases.WithContext(e.ctx))
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return errors.New("error updating alias: " + res.String())
+    }
+    return nil
+}
@@ -0,0 +1,86 @@
+package adapters
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+)
+
+// setFieldsScript assigns every entry of params to the document source.
+const setFieldsScript = "for (entry in params.entrySet()) { ctx._source[entry.getKey()] = entry.getValue() }"
+
+// byQueryResponse is the part of a delete/update-by-query response the adapter reports.
+type byQueryResponse struct {
+    Deleted  int64             `json:"deleted"`
+    Updated  int64             `json:"updated"`
+    Failures []json.RawMessage `json:"failures"`
+}
+
+// DeleteByQuery removes every document of index matching query, a query clause such as
+// {"term": {"tenant_id": "acme"}}, and returns how many were deleted. Version conflicts with
+// concurrent writes are skipped rather than aborting the request.
+func (e *ESAdapter) DeleteByQuery(index string, query map[string]interface{}) (int64, error) {
+    body, err := json.Marshal(map[string]interface{}{"query": query})
+    if err != nil {
+        return 0, err
+    }
+    res, err := e.client.DeleteByQuery([]string{index}, bytes.NewReader(body),
+        e.client.DeleteByQuery.WithContext(e.ctx),
+        e.client.DeleteByQuery.WithConflicts("proceed"),
+        e.client.DeleteByQuery.WithRefresh(true),
+    )
+    if err != nil {
+        return 0, err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return 0, errors.New("error deleting by query: " + res.String())
+    }
+    var response byQueryResponse
+    if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+        return 0, err
+    }
+    if len(response.Failures) > 0 {
+        return response.Deleted, errors.New("error deleting by query: " + string(response.Failures[0]))
+    }
+    return response.Deleted, nil
+}
+
+// UpdateByQuery sets fields on every document of index matching query and returns how many were
+// updated, e.g. renaming a category across all its products.
+func (e *ESAdapter) UpdateByQuery(index string, query map[string]interface{}, fields map[string]interface{}) (int64, error) {
+    body, err := json.Marshal(map[string]interface{}{
+        "query": query,
+        "script": map[string]interface{}{
+            "source": setFieldsScript,
+            "lang":   "painless",
+            "params": fields,
+        },
+    })
+    if err != nil {
+        return 0, err
+    }
+    res, err := e.client.UpdateByQuery([]string{index},
+        e.client.UpdateByQuery.WithContext(e.ctx),
+        e.client.UpdateByQuery.WithBody(bytes.NewReader(body)),
+        e.client.UpdateByQuery.WithConflicts("proceed"),
+        e.client.UpdateByQuery.WithRefresh(true),
+    )
+    if err != nil {
+        return 0, err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return 0, errors.New("error updating by query: " + res.String())
+    }
+    var response byQueryResponse
+    if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+        return 0, err
+    }
+    if len(response.Failures) > 0 {
+        return response.Updated, errors.New("error updating by query: " + string(response.Failures[0]))
+    }
+    return response.Updated, nil
+}
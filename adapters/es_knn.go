@@ -0,0 +1,38 @@
+package adapters
+
+// knnCandidatesPerResult is how many candidates each shard considers per requested neighbour;
+// more candidates trade latency for recall.
+const knnCandidatesPerResult = 10
+
+// KnnSearch returns the k documents of index whose dense_vector field is nearest to vector,
+// restricted to documents matching filter, a query clause (nil for none). Hits are decoded into
+// result like Search, usually an *SearchResponse, with the similarity as _score.
+func (e *ESAdapter) KnnSearch(index, field string, vector []float32, k int, filter map[string]interface{}, result interface{}) error {
+    if e.flavor == FlavorOpenSearch {
+        return e.Search(index, openSearchKnnQuery(field, vector, k, filter), result)
+    }
+    knn := map[string]interface{}{
+        "field":          field,
+        "query_vector":   vector,
+        "k":              k,
+        "num_candidates": knnCandidates(k),
+    }
+    if filter != nil {
+        knn["filter"] = filter
+    }
+    return e.Search(index, map[string]interface{}{"knn": knn, "size": k}, result)
+}
+
+func knnCandidates(k int) int {
+    n := k * knnCandidatesPerResult
+    if n < 100 {
+        n = 100
+    }
+    if n > 10000 {
+        n = 10000
+    }
+    if n < k {
+        n = k
+    }
+    return n
+}
@@ -0,0 +1,81 @@
+package adapters
+
+import (
+    "fmt"
+    "strconv"
+)
+
+// QueryFields normalises the body of a field query clause, {"field": value} or
+// {"field": {"query"|"value": value}}, to field -> value.
+func QueryFields(body interface{}) map[string]interface{} {
+    fields := map[string]interface{}{}
+    m, _ := body.(map[string]interface{})
+    for field, value := range m {
+        if opts, ok := value.(map[string]interface{}); ok {
+            if q, ok := opts["query"]; ok {
+                value = q
+            } else if v, ok := opts["value"]; ok {
+                value = v
+            }
+        }
+        fields[field] = value
+    }
+    return fields
+}
+
+// QueryClauses normalises an occurrence of a bool query, a single clause or a list of them, to a
+// list of clauses.
+func QueryClauses(value interface{}) []map[string]interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        return []map[string]interface{}{v}
+    case []interface{}:
+        var out []map[string]interface{}
+        for _, item := range v {
+            if m, ok := item.(map[string]interface{}); ok {
+                out = append(out, m)
+            }
+        }
+        return out
+    case []map[string]interface{}:
+        return v
+    }
+    return nil
+}
+
+// ShouldMatch returns how many of the should clauses of a bool query a document must match: its
+// minimum_should_match, a count or a negative count of clauses that may be missed, or else 1 when
+// the query has should clauses but no must or filter ones, and 0 otherwise. Percentages are not
+// supported.
+func ShouldMatch(b map[string]interface{}) (int, error) {
+    should := len(QueryClauses(b["should"]))
+    var minimum int
+    switch v := b["minimum_should_match"].(type) {
+    case nil:
+        if should > 0 && len(QueryClauses(b["must"])) == 0 && len(QueryClauses(b["filter"])) == 0 {
+            return 1, nil
+        }
+        return 0, nil
+    case int:
+        minimum = v
+    case int64:
+        minimum = int(v)
+    case float64:
+        minimum = int(v)
+    case string:
+        n, err := strconv.Atoi(v)
+        if err != nil {
+            return 0, fmt.Errorf("minimum_should_match %q is not supported", v)
+        }
+        minimum = n
+    default:
+        return 0, fmt.Errorf("minimum_should_match %v is not supported", v)
+    }
+    if minimum < 0 {
+        minimum += should
+        if minimum < 0 {
+            minimum = 0
+        }
+    }
+    return minimum, nil
+}
@@ -0,0 +1,80 @@
+package adapters
+
+import (
+    "encoding/json"
+)
+
+// SearchResponse is the decoded response of Search, for callers that don't need a custom shape.
+// Degraded is set when the ORM answered from SQL because Elasticsearch was unavailable.
+type SearchResponse struct {
+    Degraded bool `json:"degraded"`
+    Hits     struct {
+        Total struct {
+            Value    int64  `json:"value"`
+            Relation string `json:"relation"`
+        } `json:"total"`
+        Hits []SearchHit `json:"hits"`
+    } `json:"hits"`
+}
+
+// SearchHit is one matching document. Highlight holds the annotated fragments per field when the
+// query asked for them with WithHighlight.
+type SearchHit struct {
+    Index     string              `json:"_index"`
+    ID        string              `json:"_id"`
+    Score     float64             `json:"_score"`
+    Source    json.RawMessage     `json:"_source"`
+    Highlight map[string][]string `json:"highlight,omitempty"`
+}
+
+// Decode unmarshals the document source into dest.
+func (h SearchHit) Decode(dest interface{}) error {
+    return json.Unmarshal(h.Source, dest)
+}
+
+// Fragments returns the highlighted fragments of field, or nil when nothing matched in it.
+func (h SearchHit) Fragments(field string) []string {
+    return h.Highlight[field]
+}
+
+// Highlight configures the fragments returned with each hit. Matched terms are wrapped in PreTag
+// and PostTag, "<em>" and "</em>" by default.
+type Highlight struct {
+    Fields            []string
+    PreTag            string
+    PostTag           string
+    FragmentSize      int // Characters per fragment; Elasticsearch defaults to 100.
+    NumberOfFragments int // Fragments per field; 0 returns the whole field highlighted.
+}
+
+// WithHighlight adds the highlight section to a search body:
+//
+//     query := adapters.WithHighlight(map[string]interface{}{
+//         "query": map[string]interface{}{"match": map[string]interface{}{"body": "gopher"}},
+//     }, adapters.Highlight{Fields: []string{"body"}, NumberOfFragments: 3})
+//     var res adapters.SearchResponse
+//     err := o.Search("posts", query, &res)
+//     snippets := res.Hits.Hits[0].Fragments("body")
+func WithHighlight(query map[string]interface{}, h Highlight) map[string]interface{} {
+    fields := make(map[string]interface{}, len(h.Fields))
+    for _, field := range h.Fields {
+        opts := map[string]interface{}{"number_of_fragments": h.NumberOfFragments}
+        if h.FragmentSize > 0 {
+            opts["fragment_size"] = h.FragmentSize
+        }
+        fields[field] = opts
+    }
+    preTag, postTag := h.PreTag, h.PostTag
+    if preTag == "" {
+        preTag = "<em>"
+    }
+    if postTag == "" {
+        postTag = "</em>"
+    }
+    query["highlight"] = map[string]interface{}{
+        "pre_tags":  []string{preTag},
+        "post_tags": []string{postTag},
+        "fields":    fields,
+    }
+    return query
+}
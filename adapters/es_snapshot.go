@@ -0,0 +1,106 @@
+package adapters
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "persistence-layer/utils"
+    "strings"
+)
+
+// Snapshot takes a snapshot named snapshot of indices, all of them when empty, into repository, a
+// snapshot repository registered with the cluster, e.g. one backed by S3. It waits for completion.
+func (e *ESAdapter) Snapshot(repository, snapshot string, indices []string) error {
+    request := map[string]interface{}{"include_global_state": false}
+    if len(indices) > 0 {
+        request["indices"] = strings.Join(indices, ",")
+    }
+    body, err := json.Marshal(request)
+    if err != nil {
+        return err
+    }
+    res, err := e.client.Snapshot.Create(repository, snapshot,
+        e.client.Snapshot.Create.WithContext(e.ctx),
+        e.client.Snapshot.Create.WithBody(bytes.NewReader(body)),
+        e.client.Snapshot.Create.WithWaitForCompletion(true),
+    )
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return errors.New("error creating snapshot: " + res.String())
+    }
+    return nil
+}
+
+// RestoreSnapshot restores indices, all of them when empty, from a snapshot taken by Snapshot.
+// Existing indices of the same names are closed first so the restore replaces them, and opened
+// again when the restore fails, so a failed restore leaves them searchable as they were.
+func (e *ESAdapter) RestoreSnapshot(repository, snapshot string, indices []string) error {
+    if len(indices) > 0 {
+        res, err := e.client.Indices.Close(indices,
+            e.client.Indices.Close.WithContext(e.ctx),
+            e.client.Indices.Close.WithIgnoreUnavailable(true),
+        )
+        if err != nil {
+            return err
+        }
+        defer res.Body.Close()
+        if res.IsError() {
+            e.reopen(indices)
+            return errors.New("error closing indices for restore: " + res.String())
+        }
+    }
+
+    err := e.restore(repository, snapshot, indices)
+    if err != nil && len(indices) > 0 {
+        e.reopen(indices)
+    }
+    return err
+}
+
+// restore restores indices from a snapshot, waiting for it to complete.
+func (e *ESAdapter) restore(repository, snapshot string, indices []string) error {
+    request := map[string]interface{}{"include_global_state": false}
+    if len(indices) > 0 {
+        request["indices"] = strings.Join(indices, ",")
+    }
+    body, err := json.Marshal(request)
+    if err != nil {
+        return err
+    }
+    res, err := e.client.Snapshot.Restore(repository, snapshot,
+        e.client.Snapshot.Restore.WithContext(e.ctx),
+        e.client.Snapshot.Restore.WithBody(bytes.NewReader(body)),
+        e.client.Snapshot.Restore.WithWaitForCompletion(true),
+    )
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return errors.New("error restoring snapshot: " + res.String())
+    }
+    return nil
+}
+
+// reopen opens indices closed for a restore that did not happen, logging a failure: the indices
+// then stay closed until opened by hand.
+func (e *ESAdapter) reopen(indices []string) {
+    res, err := e.client.Indices.Open(indices,
+        e.client.Indices.Open.WithContext(e.ctx),
+        e.client.Indices.Open.WithIgnoreUnavailable(true),
+    )
+    if err == nil {
+        defer res.Body.Close()
+        if res.IsError() {
+            err = errors.New(res.String())
+        }
+    }
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "RestoreSnapshot", "indices": indices, "note": "indices left closed"})
+    }
+}
@@ -0,0 +1,55 @@
+package adapters
+
+import (
+    "encoding/json"
+    "errors"
+)
+
+// Suggestion is one completion returned by Suggest.
+type Suggestion struct {
+    Text   string          `json:"text"`
+    ID     string          `json:"_id"`
+    Score  float64         `json:"_score"`
+    Source json.RawMessage `json:"_source"`
+}
+
+// Decode unmarshals the suggested document into dest.
+func (s Suggestion) Decode(dest interface{}) error {
+    return json.Unmarshal(s.Source, dest)
+}
+
+// Suggest returns up to size completions of prefix from the completion field of index. Map the
+// field with `search:"completion"`, or add a completion sub-field to a text field with
+// `search:"text,suggest"` and pass "<field>.suggest".
+func (e *ESAdapter) Suggest(index, field, prefix string, size int) ([]Suggestion, error) {
+    query := map[string]interface{}{
+        "_source": true,
+        "suggest": map[string]interface{}{
+            "completion": map[string]interface{}{
+                "prefix": prefix,
+                "completion": map[string]interface{}{
+                    "field":           field,
+                    "size":            size,
+                    "skip_duplicates": true,
+                },
+            },
+        },
+    }
+    var response struct {
+        Suggest map[string][]struct {
+            Options []Suggestion `json:"options"`
+        } `json:"suggest"`
+    }
+    if err := e.Search(index, query, &response); err != nil {
+        return nil, err
+    }
+    entries, ok := response.Suggest["completion"]
+    if !ok {
+        return nil, errors.New("error executing suggest: response has no suggestions")
+    }
+    suggestions := []Suggestion{}
+    for _, entry := range entries {
+        suggestions = append(suggestions, entry.Options...)
+    }
+    return suggestions, nil
+}
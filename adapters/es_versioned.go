@@ -0,0 +1,80 @@
+package adapters
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// IndexVersioned indexes the model with an external version, e.g. the ID of the event carrying
+// it. Elasticsearch rejects a version that isn't above the stored document's, so redelivered or
+// reordered writes are suppressed; it reports false for them.
+func (e *ESAdapter) IndexVersioned(index string, model interface{}, version int64) (bool, error) {
+    body, err := json.Marshal(model)
+    if err != nil {
+        return false, err
+    }
+    id, err := DocumentID(model)
+    if err != nil {
+        return false, err
+    }
+    v := int(version)
+    req := esapi.IndexRequest{
+        Index:       index,
+        DocumentID:  id,
+        Body:        bytes.NewReader(body),
+        Version:     &v,
+        VersionType: "external",
+        Refresh:     "true",
+    }
+    ctx, cancel := e.deadlines.bound(e.ctx, true)
+    defer cancel()
+    res, err := req.Do(ctx, e.client)
+    if err != nil {
+        return false, err
+    }
+    defer res.Body.Close()
+    if res.StatusCode == http.StatusConflict {
+        return false, nil
+    }
+    if res.IsError() {
+        return false, errors.New("error indexing document: " + res.String())
+    }
+    return true, nil
+}
+
+// DeleteVersioned deletes the model's document unless it was written with a version at or above
+// version. Deleting a missing document counts as applied.
+func (e *ESAdapter) DeleteVersioned(index string, model interface{}, version int64) (bool, error) {
+    id, err := DocumentID(model)
+    if err != nil {
+        return false, err
+    }
+    v := int(version)
+    req := esapi.DeleteRequest{
+        Index:       index,
+        DocumentID:  id,
+        Version:     &v,
+        VersionType: "external",
+        Refresh:     "true",
+    }
+    ctx, cancel := e.deadlines.bound(e.ctx, true)
+    defer cancel()
+    res, err := req.Do(ctx, e.client)
+    if err != nil {
+        return false, err
+    }
+    defer res.Body.Close()
+    switch {
+    case res.StatusCode == http.StatusConflict:
+        return false, nil
+    case res.StatusCode == http.StatusNotFound:
+        return true, nil
+    case res.IsError():
+        return false, errors.New("error deleting document: " + res.String())
+    }
+    return true, nil
+}
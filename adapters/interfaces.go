@@ -0,0 +1,235 @@
+package adapters
+
+import (
+    "context"
+    "io"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// SQLStore is the relational backend used by the ORM. SQLAdapter is the production implementation.
+type SQLStore interface {
+    GetDB() *gorm.DB
+    WithContext(ctx context.Context) SQLStore
+    Create(model interface{}) error
+    Read(id uint, model interface{}) error
+    ReadByKey(key interface{}, model interface{}) error
+    ReadWith(key interface{}, model interface{}, preloads ...string) error
+    ReadMany(keys []interface{}, dest interface{}) error
+    Preload(dest interface{}, preloads ...string) error
+    ReadForUpdate(id uint, model interface{}) error
+    ClaimBatch(dest interface{}, limit int, query string, args ...interface{}) error
+    Update(model interface{}) (int64, error)
+    Delete(id uint, model interface{}) (int64, error)
+    DeleteByKey(key interface{}, model interface{}) (int64, error)
+    BeginTransaction() (SQLStore, error)
+    Commit() error
+    Rollback() error
+    SavePoint(name string) error
+    RollbackTo(name string) error
+    RawQuery(query string, params []interface{}, dest interface{}) error
+    Exec(query string, params []interface{}) (int64, error)
+    Close() error
+}
+
+// ConditionalReader is implemented by SQL stores that can narrow ReadMany with column conditions,
+// e.g. to the records of their owner, so that the conditions apply in the query rather than to its
+// results.
+type ConditionalReader interface {
+    ReadManyWhere(keys []interface{}, conditions map[string]interface{}, dest interface{}) error
+}
+
+// ReplicationTracker is implemented by SQL stores that report replication positions, letting the
+// ORM tell whether a replica has caught up with a session's writes.
+type ReplicationTracker interface {
+    ReplicationPosition() (string, error)
+    CaughtUp(position string) (bool, error)
+}
+
+// StatementLimiter is implemented by SQL stores that can bound the run time of their statements.
+type StatementLimiter interface {
+    EnableStatementTimeouts(def time.Duration) error
+}
+
+// DeadlineSetter is implemented by stores whose calls can be given default Deadlines.
+type DeadlineSetter interface {
+    SetDeadlines(d Deadlines) error
+}
+
+// MongoStore is the document backend used by the ORM. MongoAdapter is the production implementation.
+// Update and Delete report the number of documents the filter matched, 0 or 1.
+type MongoStore interface {
+    Create(collection string, model interface{}) error
+    Read(collection string, filter map[string]interface{}, result interface{}) error
+    Update(collection string, filter map[string]interface{}, update interface{}) (int64, error)
+    Delete(collection string, filter map[string]interface{}) (int64, error)
+    Disconnect()
+}
+
+// FindOneAndUpdateOptions controls DocumentUpdater.FindOneAndUpdate.
+type FindOneAndUpdateOptions struct {
+    // Upsert inserts a document built from the equality conditions of the filter and the update
+    // when none matches.
+    Upsert bool
+    // ReturnNew decodes the document as it is after the update rather than before. Without it, an
+    // upserted document has no previous state and mongo.ErrNoDocuments is returned.
+    ReturnNew bool
+    // Sort picks the document to update when several match, by field names with a "-" prefix for
+    // descending order, e.g. []string{"-priority", "created_at"}.
+    Sort []string
+}
+
+// DocumentUpdater is implemented by document stores that can update a document and read it back
+// atomically, e.g. to increment a counter or claim a job without another caller interleaving.
+type DocumentUpdater interface {
+    // FindOneAndUpdate applies update, an update document with operators such as
+    // {"$inc": {"hits": 1}}, to the first document matching filter and decodes it into result. It
+    // returns mongo.ErrNoDocuments when no document matched and none was upserted.
+    FindOneAndUpdate(collection string, filter map[string]interface{}, update interface{}, opts FindOneAndUpdateOptions, result interface{}) error
+}
+
+// DocumentReplacer is implemented by document stores that can replace a document in a single
+// write, inserting it when none matches with upsert, so readers never see it missing.
+type DocumentReplacer interface {
+    ReplaceOne(collection string, filter map[string]interface{}, doc interface{}, upsert bool) error
+}
+
+// DocumentPruner is implemented by document stores that can list the values a field takes across a
+// collection and delete the documents whose field takes one of a set of values, e.g. to remove the
+// rows of a rebuilt view that are no longer computed.
+type DocumentPruner interface {
+    FieldValues(collection, field string) ([]interface{}, error)
+    DeleteIn(collection, field string, values []interface{}) (int64, error)
+}
+
+// CollectionExporter is implemented by document stores that can dump and reload whole collections,
+// e.g. for backups.
+type CollectionExporter interface {
+    Collections() ([]string, error)
+    ExportCollection(collection string, w io.Writer) (int64, error)
+    ImportCollection(collection string, r io.Reader, replace bool) (int64, error)
+}
+
+// CacheStore is the cache backend used by the ORM. RedisAdapter is the production implementation.
+type CacheStore interface {
+    SetWithTTL(key string, value interface{}, ttl time.Duration) error
+    // Get returns utils.ErrCacheMiss when key is missing or expired, leaving dest untouched.
+    Get(key string, dest interface{}) error
+    GetMany(keys []string) (map[string][]byte, error)
+    Delete(key string) error
+    DeleteByPattern(pattern string) (int64, error)
+    Exists(key string) (bool, error)
+    ZAdd(key string, members ...ScoredMember) error
+    ZIncrBy(key, member string, by float64) (float64, error)
+    ZRange(key string, start, stop int64, desc bool) ([]ScoredMember, error)
+    ZRangeByScore(key string, min, max float64, desc bool, limit int64) ([]ScoredMember, error)
+    ZRank(key, member string, desc bool) (int64, bool, error)
+    ZRem(key string, members ...string) error
+    ZRemRangeByRank(key string, start, stop int64) (int64, error)
+    ZRemRangeByScore(key string, min, max float64) (int64, error)
+    ZCard(key string) (int64, error)
+    HSet(key string, fields map[string]string, ttl time.Duration) error
+    HReplace(key string, fields map[string]string, ttl time.Duration) error
+    HGetAll(key string) (map[string]string, error)
+    HIncrBy(key, field string, by int64) (int64, error)
+    HDel(key string, fields ...string) error
+    IncrBy(key string, by int64) (int64, error)
+    DecrBy(key string, by int64) (int64, error)
+    RenameNX(key, newKey string) (bool, error)
+    Close() error
+}
+
+// StreamStore is implemented by cache backends that support Redis Streams with consumer groups,
+// as used by TaskQueue in the orm package.
+type StreamStore interface {
+    XAdd(stream string, values map[string]string, maxLen int64) (string, error)
+    XGroupCreate(stream, group string) error
+    XReadGroup(stream, group, consumer string, count int64, block time.Duration) ([]StreamMessage, error)
+    XClaimStale(stream, group, consumer string, minIdle time.Duration, count int64) ([]StreamMessage, error)
+    XAck(stream, group string, ids ...string) error
+}
+
+// RateLimiter is implemented by cache backends that can limit request rates across processes.
+type RateLimiter interface {
+    Allow(key string, limit int64, window time.Duration) (RateLimit, error)
+}
+
+// UsageCounter is implemented by cache backends that can account usage against limits across
+// processes.
+type UsageCounter interface {
+    ReserveUsage(key string, ttl time.Duration, deltas ...UsageDelta) (UsageReservation, error)
+}
+
+// GuardedCache is implemented by cache backends that can write an entry on condition that a guard
+// counter, bumped whenever the entry is invalidated, still holds the value read before the entry's
+// data was loaded, so a slow reload can't cache data older than a concurrent write.
+type GuardedCache interface {
+    BumpGuard(key string, ttl time.Duration) error
+    SetIfGuard(key string, value interface{}, ttl time.Duration, guard string, expected int64) (bool, error)
+}
+
+// ExpiryNotifier is implemented by cache backends that report expired keys.
+type ExpiryNotifier interface {
+    SubscribeExpired(ctx context.Context, handle func(key string)) error
+}
+
+// SearchStore is the search backend used by the ORM. ESAdapter is the production implementation.
+type SearchStore interface {
+    IndexDocument(index string, model interface{}) error
+    BulkIndex(index string, models []interface{}) error
+    UpdateDocument(index string, model interface{}) error
+    Search(index string, query map[string]interface{}, result interface{}) error
+    Aggregate(index string, query map[string]interface{}, aggs map[string]interface{}, result interface{}) error
+    Suggest(index, field, prefix string, size int) ([]Suggestion, error)
+    KnnSearch(index, field string, vector []float32, k int, filter map[string]interface{}, result interface{}) error
+    DeleteDocument(index string, model interface{}) error
+    DeleteByQuery(index string, query map[string]interface{}) (int64, error)
+    UpdateByQuery(index string, query map[string]interface{}, fields map[string]interface{}) (int64, error)
+    EnsureIndex(index string, mapping map[string]interface{}) error
+    PointAlias(alias, index string) error
+    Close() error
+}
+
+// VersionedIndexer is implemented by search stores that write documents conditionally on an
+// external version, reporting false for writes suppressed because the document already has the
+// same or a later version, e.g. a redelivered event.
+type VersionedIndexer interface {
+    IndexVersioned(index string, model interface{}, version int64) (bool, error)
+    DeleteVersioned(index string, model interface{}, version int64) (bool, error)
+}
+
+// Snapshotter is implemented by search stores that can snapshot indices into a repository of the
+// cluster and restore them.
+type Snapshotter interface {
+    Snapshot(repository, snapshot string, indices []string) error
+    RestoreSnapshot(repository, snapshot string, indices []string) error
+}
+
+// Compile-time checks that the concrete adapters satisfy their interfaces.
+var (
+    _ SQLStore           = (*SQLAdapter)(nil)
+    _ SQLStore           = (*FailoverSQLAdapter)(nil)
+    _ ReplicationTracker = (*SQLAdapter)(nil)
+    _ StatementLimiter   = (*SQLAdapter)(nil)
+    _ StatementLimiter   = (*FailoverSQLAdapter)(nil)
+    _ MongoStore         = (*MongoAdapter)(nil)
+    _ CollectionExporter = (*MongoAdapter)(nil)
+    _ DocumentUpdater    = (*MongoAdapter)(nil)
+    _ DocumentPruner     = (*MongoAdapter)(nil)
+    _ DocumentReplacer   = (*MongoAdapter)(nil)
+    _ CacheStore         = (*RedisAdapter)(nil)
+    _ StreamStore        = (*RedisAdapter)(nil)
+    _ RateLimiter        = (*RedisAdapter)(nil)
+    _ UsageCounter       = (*RedisAdapter)(nil)
+    _ ExpiryNotifier     = (*RedisAdapter)(nil)
+    _ GuardedCache       = (*RedisAdapter)(nil)
+    _ DeadlineSetter     = (*SQLAdapter)(nil)
+    _ DeadlineSetter     = (*FailoverSQLAdapter)(nil)
+    _ DeadlineSetter     = (*MongoAdapter)(nil)
+    _ DeadlineSetter     = (*RedisAdapter)(nil)
+    _ DeadlineSetter     = (*ESAdapter)(nil)
+    _ SearchStore        = (*ESAdapter)(nil)
+    _ Snapshotter        = (*ESAdapter)(nil)
+    _ VersionedIndexer   = (*ESAdapter)(nil)
+)
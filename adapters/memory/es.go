@@ -0,0 +1,324 @@
+package memory
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "persistence-layer/adapters"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// ESAdapter is an in-memory adapters.SearchStore for unit tests. Search understands match_all,
+// ids, match, term, terms, range, wildcard, geo_distance, geo_shape (polygons) and bool
+// (must/filter/must_not/should) queries, highlights match terms and answers in the Elasticsearch
+// response shape.
+type ESAdapter struct {
+    mu       sync.Mutex
+    indices  map[string]map[string]map[string]interface{}
+    versions map[string]int64 // External versions by index and document ID, kept after deletes.
+}
+
+var (
+    _ adapters.SearchStore      = (*ESAdapter)(nil)
+    _ adapters.VersionedIndexer = (*ESAdapter)(nil)
+)
+
+// NewESAdapter creates an empty in-memory search index.
+func NewESAdapter() *ESAdapter {
+    return &ESAdapter{indices: make(map[string]map[string]map[string]interface{}), versions: make(map[string]int64)}
+}
+
+// IndexDocument stores the model under its DocumentID, replacing any previous version.
+func (e *ESAdapter) IndexDocument(index string, model interface{}) error {
+    id, err := adapters.DocumentID(model)
+    if err != nil {
+        return err
+    }
+    source, err := toSource(model)
+    if err != nil {
+        return err
+    }
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if e.indices[index] == nil {
+        e.indices[index] = make(map[string]map[string]interface{})
+    }
+    e.indices[index][id] = source
+    return nil
+}
+
+// UpdateDocument merges the model's fields into an existing document.
+func (e *ESAdapter) UpdateDocument(index string, model interface{}) error {
+    id, err := adapters.DocumentID(model)
+    if err != nil {
+        return err
+    }
+    fields, err := toSource(model)
+    if err != nil {
+        return err
+    }
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    doc, ok := e.indices[index][id]
+    if !ok {
+        return errors.New("error updating document: document_missing_exception")
+    }
+    for k, v := range fields {
+        doc[k] = v
+    }
+    return nil
+}
+
+// Search evaluates the query against every document of the index and decodes the hits into result.
+func (e *ESAdapter) Search(index string, query map[string]interface{}, result interface{}) error {
+    clause, _ := query["query"].(map[string]interface{})
+
+    e.mu.Lock()
+    ids := make([]string, 0, len(e.indices[index]))
+    for id := range e.indices[index] {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+
+    hits := []map[string]interface{}{}
+    for _, id := range ids {
+        doc := e.indices[index][id]
+        ok, err := matchesQuery(clause, id, doc)
+        if err != nil {
+            e.mu.Unlock()
+            return err
+        }
+        if ok {
+            hit := map[string]interface{}{"_index": index, "_id": id, "_score": 1.0, "_source": doc}
+            if spec, ok := query["highlight"].(map[string]interface{}); ok {
+                if h := highlight(spec, clause, doc); h != nil {
+                    hit["highlight"] = h
+                }
+            }
+            hits = append(hits, hit)
+        }
+    }
+    e.mu.Unlock()
+
+    total := len(hits)
+    from, size := intParam(query["from"], 0), intParam(query["size"], 10)
+    if from > len(hits) {
+        from = len(hits)
+    }
+    hits = hits[from:]
+    if size < len(hits) {
+        hits = hits[:size]
+    }
+
+    // track_total_hits counts every hit when true, none when false, or up to a number, 10000
+    // when unset as in Elasticsearch.
+    found := map[string]interface{}{"hits": hits}
+    switch track := query["track_total_hits"].(type) {
+    case bool:
+        if track {
+            found["total"] = map[string]interface{}{"value": total, "relation": "eq"}
+        }
+    default:
+        if limit := intParam(track, 10000); total > limit {
+            found["total"] = map[string]interface{}{"value": limit, "relation": "gte"}
+        } else {
+            found["total"] = map[string]interface{}{"value": total, "relation": "eq"}
+        }
+    }
+    response := map[string]interface{}{
+        "took":      0,
+        "timed_out": false,
+        "hits":      found,
+    }
+    data, err := json.Marshal(response)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, result)
+}
+
+// DeleteDocument removes the model's document from the index.
+func (e *ESAdapter) DeleteDocument(index string, model interface{}) error {
+    id, err := adapters.DocumentID(model)
+    if err != nil {
+        return err
+    }
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    delete(e.indices[index], id)
+    return nil
+}
+
+// IndexVersioned stores the model like IndexDocument unless the document was written with the
+// same or a later external version.
+func (e *ESAdapter) IndexVersioned(index string, model interface{}, version int64) (bool, error) {
+    id, err := adapters.DocumentID(model)
+    if err != nil {
+        return false, err
+    }
+    if !e.claimVersion(index, id, version) {
+        return false, nil
+    }
+    return true, e.IndexDocument(index, model)
+}
+
+// DeleteVersioned deletes the model's document unless it was written with the same or a later
+// external version.
+func (e *ESAdapter) DeleteVersioned(index string, model interface{}, version int64) (bool, error) {
+    id, err := adapters.DocumentID(model)
+    if err != nil {
+        return false, err
+    }
+    if !e.claimVersion(index, id, version) {
+        return false, nil
+    }
+    return true, e.DeleteDocument(index, model)
+}
+
+// claimVersion records version for the document unless it already has the same or a later one.
+func (e *ESAdapter) claimVersion(index, id string, version int64) bool {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    key := index + "/" + id
+    if current, ok := e.versions[key]; ok && current >= version {
+        return false
+    }
+    e.versions[key] = version
+    return true
+}
+
+// EnsureIndex creates an empty index unless it exists. Mappings are not enforced.
+func (e *ESAdapter) EnsureIndex(index string, mapping map[string]interface{}) error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if e.indices[index] == nil {
+        e.indices[index] = make(map[string]map[string]interface{})
+    }
+    return nil
+}
+
+// Close is a no-op.
+func (e *ESAdapter) Close() error {
+    return nil
+}
+
+func toSource(model interface{}) (map[string]interface{}, error) {
+    data, err := json.Marshal(model)
+    if err != nil {
+        return nil, err
+    }
+    var source map[string]interface{}
+    err = json.Unmarshal(data, &source)
+    return source, err
+}
+
+// matchesQuery evaluates the supported subset of the query DSL against a document and its id. A
+// nil clause matches everything.
+func matchesQuery(clause map[string]interface{}, id string, doc map[string]interface{}) (bool, error) {
+    for kind, body := range clause {
+        switch kind {
+        case "match_all":
+        case "match":
+            for field, value := range adapters.QueryFields(body) {
+                if !matchesText(doc[field], value) {
+                    return false, nil
+                }
+            }
+        case "term":
+            for field, value := range adapters.QueryFields(body) {
+                if fmt.Sprint(doc[field]) != fmt.Sprint(value) {
+                    return false, nil
+                }
+            }
+        case "ids":
+            b, _ := body.(map[string]interface{})
+            if !containsValue(b["values"], id) {
+                return false, nil
+            }
+        case "terms":
+            for field, values := range adapters.QueryFields(body) {
+                if !containsValue(values, doc[field]) {
+                    return false, nil
+                }
+            }
+        case "range":
+            for field, bounds := range adapters.QueryFields(body) {
+                if !inRange(doc[field], bounds) {
+                    return false, nil
+                }
+            }
+        case "wildcard":
+            for field, pattern := range adapters.QueryFields(body) {
+                if !matchesWildcard(fmt.Sprint(doc[field]), fmt.Sprint(pattern)) {
+                    return false, nil
+                }
+            }
+        case "geo_distance", "geo_shape":
+            ok, err := matchesGeo(kind, body, doc)
+            if err != nil || !ok {
+                return false, err
+            }
+        case "bool":
+            b, _ := body.(map[string]interface{})
+            for _, occur := range []string{"must", "filter"} {
+                for _, sub := range adapters.QueryClauses(b[occur]) {
+                    ok, err := matchesQuery(sub, id, doc)
+                    if err != nil || !ok {
+                        return false, err
+                    }
+                }
+            }
+            for _, sub := range adapters.QueryClauses(b["must_not"]) {
+                ok, err := matchesQuery(sub, id, doc)
+                if err != nil || ok {
+                    return false, err
+                }
+            }
+            required, err := adapters.ShouldMatch(b)
+            if err != nil {
+                return false, err
+            }
+            matched := 0
+            for _, sub := range adapters.QueryClauses(b["should"]) {
+                ok, err := matchesQuery(sub, id, doc)
+                if err != nil {
+                    return false, err
+                }
+                if ok {
+                    matched++
+                }
+            }
+            if matched < required {
+                return false, nil
+            }
+        default:
+            return false, fmt.Errorf("query clause %q is not supported by the in-memory search adapter", kind)
+        }
+    }
+    return true, nil
+}
+
+// matchesText reports whether any whitespace-separated term of the query occurs in the field.
+func matchesText(field, query interface{}) bool {
+    text := strings.ToLower(fmt.Sprint(field))
+    for _, term := range strings.Fields(strings.ToLower(fmt.Sprint(query))) {
+        if strings.Contains(text, term) {
+            return true
+        }
+    }
+    return false
+}
+
+func intParam(value interface{}, def int) int {
+    switch v := value.(type) {
+    case int:
+        return v
+    case int64:
+        return int(v)
+    case float64:
+        return int(v)
+    }
+    return def
+}
@@ -0,0 +1,274 @@
+package memory
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "persistence-layer/adapters"
+    "sort"
+    "time"
+)
+
+// Aggregate evaluates aggs over the documents matching query. It understands terms, range,
+// date_histogram (calendar day/month/year or fixed durations such as "1h") and the avg, sum, min,
+// max and value_count metrics, including nested aggs.
+func (e *ESAdapter) Aggregate(index string, query map[string]interface{}, aggs map[string]interface{}, result interface{}) error {
+    e.mu.Lock()
+    var docs []map[string]interface{}
+    for id, doc := range e.indices[index] {
+        ok, err := matchesQuery(query, id, doc)
+        if err != nil {
+            e.mu.Unlock()
+            return err
+        }
+        if ok {
+            docs = append(docs, doc)
+        }
+    }
+    e.mu.Unlock()
+
+    response, err := aggregate(aggs, docs)
+    if err != nil {
+        return err
+    }
+    data, err := json.Marshal(response)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, result)
+}
+
+func aggregate(aggs map[string]interface{}, docs []map[string]interface{}) (map[string]interface{}, error) {
+    out := make(map[string]interface{}, len(aggs))
+    for name, spec := range aggs {
+        body, _ := spec.(map[string]interface{})
+        sub, _ := body["aggs"].(map[string]interface{})
+        for kind, params := range body {
+            if kind == "aggs" {
+                continue
+            }
+            p, _ := params.(map[string]interface{})
+            field, _ := p["field"].(string)
+            var (
+                value interface{}
+                err   error
+            )
+            switch kind {
+            case "terms":
+                value, err = termsAgg(field, intParam(p["size"], 10), sub, docs)
+            case "range":
+                value, err = rangeAgg(field, adapters.QueryClauses(p["ranges"]), sub, docs)
+            case "date_histogram":
+                value, err = dateHistogramAgg(field, p, sub, docs)
+            case "avg", "sum", "min", "max", "value_count":
+                value = metricAgg(kind, field, docs)
+            default:
+                err = fmt.Errorf("aggregation %q is not supported by the in-memory search adapter", kind)
+            }
+            if err != nil {
+                return nil, err
+            }
+            out[name] = value
+        }
+    }
+    return out, nil
+}
+
+// bucket returns the bucket body for docs, with their sub-aggregations.
+func bucket(fields map[string]interface{}, sub map[string]interface{}, docs []map[string]interface{}) (map[string]interface{}, error) {
+    fields["doc_count"] = len(docs)
+    if len(sub) == 0 {
+        return fields, nil
+    }
+    nested, err := aggregate(sub, docs)
+    if err != nil {
+        return nil, err
+    }
+    for k, v := range nested {
+        fields[k] = v
+    }
+    return fields, nil
+}
+
+func termsAgg(field string, size int, sub map[string]interface{}, docs []map[string]interface{}) (interface{}, error) {
+    groups := map[string][]map[string]interface{}{}
+    keys := map[string]interface{}{}
+    for _, doc := range docs {
+        values := doc[field]
+        list, ok := values.([]interface{})
+        if !ok {
+            list = []interface{}{values}
+        }
+        for _, v := range list {
+            if v == nil {
+                continue
+            }
+            k := fmt.Sprint(v)
+            groups[k] = append(groups[k], doc)
+            keys[k] = v
+        }
+    }
+    names := make([]string, 0, len(groups))
+    for k := range groups {
+        names = append(names, k)
+    }
+    // Elasticsearch orders by descending count, then ascending key.
+    sort.Slice(names, func(i, j int) bool {
+        if len(groups[names[i]]) != len(groups[names[j]]) {
+            return len(groups[names[i]]) > len(groups[names[j]])
+        }
+        return names[i] < names[j]
+    })
+    other := 0
+    buckets := []map[string]interface{}{}
+    for i, k := range names {
+        if i >= size {
+            other += len(groups[k])
+            continue
+        }
+        b, err := bucket(map[string]interface{}{"key": keys[k]}, sub, groups[k])
+        if err != nil {
+            return nil, err
+        }
+        buckets = append(buckets, b)
+    }
+    return map[string]interface{}{"doc_count_error_upper_bound": 0, "sum_other_doc_count": other, "buckets": buckets}, nil
+}
+
+func rangeAgg(field string, ranges []map[string]interface{}, sub map[string]interface{}, docs []map[string]interface{}) (interface{}, error) {
+    buckets := []map[string]interface{}{}
+    for _, r := range ranges {
+        from, hasFrom := number(r["from"])
+        to, hasTo := number(r["to"])
+        var matched []map[string]interface{}
+        for _, doc := range docs {
+            v, ok := number(doc[field])
+            if ok && (!hasFrom || v >= from) && (!hasTo || v < to) {
+                matched = append(matched, doc)
+            }
+        }
+        key, _ := r["key"].(string)
+        fields := map[string]interface{}{}
+        if hasFrom {
+            fields["from"] = from
+        }
+        if hasTo {
+            fields["to"] = to
+        }
+        if key == "" {
+            key = rangeKey(fields["from"], hasFrom) + "-" + rangeKey(fields["to"], hasTo)
+        }
+        fields["key"] = key
+        b, err := bucket(fields, sub, matched)
+        if err != nil {
+            return nil, err
+        }
+        buckets = append(buckets, b)
+    }
+    return map[string]interface{}{"buckets": buckets}, nil
+}
+
+func rangeKey(v interface{}, ok bool) string {
+    if !ok {
+        return "*"
+    }
+    return fmt.Sprintf("%.1f", v)
+}
+
+func dateHistogramAgg(field string, p map[string]interface{}, sub map[string]interface{}, docs []map[string]interface{}) (interface{}, error) {
+    interval, _ := p["calendar_interval"].(string)
+    if interval == "" {
+        interval, _ = p["fixed_interval"].(string)
+    }
+    truncate, err := truncation(interval)
+    if err != nil {
+        return nil, err
+    }
+    groups := map[int64][]map[string]interface{}{}
+    for _, doc := range docs {
+        s, _ := doc[field].(string)
+        t, err := time.Parse(time.RFC3339Nano, s)
+        if err != nil {
+            continue
+        }
+        start := truncate(t.UTC()).UnixMilli()
+        groups[start] = append(groups[start], doc)
+    }
+    starts := make([]int64, 0, len(groups))
+    for k := range groups {
+        starts = append(starts, k)
+    }
+    sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+    buckets := []map[string]interface{}{}
+    for _, start := range starts {
+        key := time.UnixMilli(start).UTC().Format("2006-01-02T15:04:05.000Z")
+        b, err := bucket(map[string]interface{}{"key": start, "key_as_string": key}, sub, groups[start])
+        if err != nil {
+            return nil, err
+        }
+        buckets = append(buckets, b)
+    }
+    return map[string]interface{}{"buckets": buckets}, nil
+}
+
+func truncation(interval string) (func(time.Time) time.Time, error) {
+    switch interval {
+    case "day", "1d":
+        return func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC) }, nil
+    case "month", "1M":
+        return func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC) }, nil
+    case "year", "1y":
+        return func(t time.Time) time.Time { return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC) }, nil
+    }
+    d, err := time.ParseDuration(interval)
+    if err != nil || d <= 0 {
+        return nil, fmt.Errorf("date_histogram interval %q is not supported by the in-memory search adapter", interval)
+    }
+    return func(t time.Time) time.Time { return t.Truncate(d) }, nil
+}
+
+func metricAgg(kind, field string, docs []map[string]interface{}) interface{} {
+    var values []float64
+    count := 0
+    for _, doc := range docs {
+        if doc[field] != nil {
+            count++
+        }
+        if v, ok := number(doc[field]); ok {
+            values = append(values, v)
+        }
+    }
+    if kind == "value_count" {
+        return map[string]interface{}{"value": count}
+    }
+    if len(values) == 0 {
+        if kind == "sum" {
+            return map[string]interface{}{"value": 0}
+        }
+        return map[string]interface{}{"value": nil}
+    }
+    sum, min, max := 0.0, values[0], values[0]
+    for _, v := range values {
+        sum += v
+        min, max = math.Min(min, v), math.Max(max, v)
+    }
+    result := map[string]float64{"sum": sum, "avg": sum / float64(len(values)), "min": min, "max": max}[kind]
+    return map[string]interface{}{"value": result}
+}
+
+// number converts JSON numbers and numeric strings (as Decimal fields are encoded) to float64.
+func number(value interface{}) (float64, bool) {
+    switch v := value.(type) {
+    case float64:
+        return v, true
+    case int:
+        return float64(v), true
+    case int64:
+        return float64(v), true
+    case string:
+        var f float64
+        _, err := fmt.Sscan(v, &f)
+        return f, err == nil
+    }
+    return 0, false
+}
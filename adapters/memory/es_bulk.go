@@ -0,0 +1,23 @@
+package memory
+
+// BulkIndex indexes every model, stopping at the first failure.
+func (e *ESAdapter) BulkIndex(index string, models []interface{}) error {
+    for _, model := range models {
+        if err := e.IndexDocument(index, model); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// PointAlias makes alias share the documents of index, so reads and writes through either name
+// see the same data.
+func (e *ESAdapter) PointAlias(alias, index string) error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if e.indices[index] == nil {
+        e.indices[index] = make(map[string]map[string]interface{})
+    }
+    e.indices[alias] = e.indices[index]
+    return nil
+}
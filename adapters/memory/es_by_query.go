@@ -0,0 +1,43 @@
+package memory
+
+// DeleteByQuery removes the documents matching query.
+func (e *ESAdapter) DeleteByQuery(index string, query map[string]interface{}) (int64, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    var deleted int64
+    for id, doc := range e.indices[index] {
+        ok, err := matchesQuery(query, id, doc)
+        if err != nil {
+            return deleted, err
+        }
+        if ok {
+            delete(e.indices[index], id)
+            deleted++
+        }
+    }
+    return deleted, nil
+}
+
+// UpdateByQuery sets fields on the documents matching query.
+func (e *ESAdapter) UpdateByQuery(index string, query map[string]interface{}, fields map[string]interface{}) (int64, error) {
+    source, err := toSource(fields)
+    if err != nil {
+        return 0, err
+    }
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    var updated int64
+    for id, doc := range e.indices[index] {
+        ok, err := matchesQuery(query, id, doc)
+        if err != nil {
+            return updated, err
+        }
+        if ok {
+            for k, v := range source {
+                doc[k] = v
+            }
+            updated++
+        }
+    }
+    return updated, nil
+}
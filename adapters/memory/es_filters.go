@@ -0,0 +1,137 @@
+package memory
+
+import (
+    "encoding/json"
+    "fmt"
+    "path"
+    "persistence-layer/types"
+    "strconv"
+    "strings"
+)
+
+func containsValue(values interface{}, value interface{}) bool {
+    data, _ := json.Marshal(values)
+    var list []interface{}
+    if err := json.Unmarshal(data, &list); err != nil {
+        return false
+    }
+    for _, v := range list {
+        if fmt.Sprint(v) == fmt.Sprint(value) {
+            return true
+        }
+    }
+    return false
+}
+
+// inRange evaluates gt/gte/lt/lte bounds numerically when both sides are numbers and as strings
+// otherwise, which orders RFC 3339 dates correctly.
+func inRange(value interface{}, bounds interface{}) bool {
+    b, _ := bounds.(map[string]interface{})
+    for op, bound := range b {
+        var cmp int
+        x, xok := number(value)
+        y, yok := number(bound)
+        switch {
+        case xok && yok && x < y:
+            cmp = -1
+        case xok && yok && x > y:
+            cmp = 1
+        case xok && yok:
+        default:
+            cmp = strings.Compare(fmt.Sprint(value), fmt.Sprint(bound))
+        }
+        switch op {
+        case "gt":
+            if cmp <= 0 {
+                return false
+            }
+        case "gte":
+            if cmp < 0 {
+                return false
+            }
+        case "lt":
+            if cmp >= 0 {
+                return false
+            }
+        case "lte":
+            if cmp > 0 {
+                return false
+            }
+        }
+    }
+    return value != nil
+}
+
+func matchesWildcard(value, pattern string) bool {
+    ok, _ := path.Match(pattern, value)
+    return ok
+}
+
+// matchesGeo evaluates geo_distance and polygon geo_shape queries against a {"lat", "lon"} field.
+func matchesGeo(kind string, body interface{}, doc map[string]interface{}) (bool, error) {
+    params, _ := body.(map[string]interface{})
+    for field, spec := range params {
+        if field == "distance" || field == "relation" {
+            continue
+        }
+        point, ok := geoPoint(doc[field])
+        if !ok {
+            return false, nil
+        }
+        if kind == "geo_distance" {
+            center, _ := geoPoint(spec)
+            meters, err := parseDistance(fmt.Sprint(params["distance"]))
+            if err != nil {
+                return false, err
+            }
+            if point.DistanceTo(center) > meters {
+                return false, nil
+            }
+            continue
+        }
+        var shape struct {
+            Shape struct {
+                Type        string        `json:"type"`
+                Coordinates [][][]float64 `json:"coordinates"`
+            } `json:"shape"`
+        }
+        data, _ := json.Marshal(spec)
+        if err := json.Unmarshal(data, &shape); err != nil || !strings.EqualFold(shape.Shape.Type, "polygon") || len(shape.Shape.Coordinates) == 0 {
+            return false, fmt.Errorf("geo_shape %s is not supported by the in-memory search adapter", data)
+        }
+        var polygon []types.GeoPoint
+        for _, c := range shape.Shape.Coordinates[0] {
+            polygon = append(polygon, types.NewGeoPoint(c[1], c[0]))
+        }
+        if !point.Within(polygon) {
+            return false, nil
+        }
+    }
+    return true, nil
+}
+
+func geoPoint(value interface{}) (types.GeoPoint, bool) {
+    m, ok := value.(map[string]interface{})
+    if !ok {
+        return types.GeoPoint{}, false
+    }
+    lat, latOK := number(m["lat"])
+    lon, lonOK := number(m["lon"])
+    return types.NewGeoPoint(lat, lon), latOK && lonOK
+}
+
+// parseDistance reads distances such as "500m" or "2km".
+func parseDistance(s string) (float64, error) {
+    unit := 1.0
+    switch {
+    case strings.HasSuffix(s, "km"):
+        s, unit = strings.TrimSuffix(s, "km"), 1000
+    case strings.HasSuffix(s, "m"):
+        s = strings.TrimSuffix(s, "m")
+    }
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid distance %q", s)
+    }
+    return f * unit, nil
+}
@@ -0,0 +1,87 @@
+package memory
+
+import (
+    "fmt"
+    "persistence-layer/adapters"
+    "strings"
+)
+
+// highlight returns the highlight section of a hit for the fields requested in spec, wrapping the
+// terms of the query's match clauses. Each field is returned whole as a single fragment.
+func highlight(spec map[string]interface{}, clause map[string]interface{}, doc map[string]interface{}) map[string][]string {
+    fields, _ := spec["fields"].(map[string]interface{})
+    preTag, postTag := firstTag(spec["pre_tags"], "<em>"), firstTag(spec["post_tags"], "</em>")
+    terms := matchTerms(clause, map[string][]string{})
+    out := map[string][]string{}
+    for field := range fields {
+        text, ok := doc[field].(string)
+        if !ok || len(terms[field]) == 0 {
+            continue
+        }
+        if marked, ok := mark(text, terms[field], preTag, postTag); ok {
+            out[field] = []string{marked}
+        }
+    }
+    if len(out) == 0 {
+        return nil
+    }
+    return out
+}
+
+// matchTerms collects the lower-cased terms of every match clause, by field.
+func matchTerms(clause map[string]interface{}, terms map[string][]string) map[string][]string {
+    for kind, body := range clause {
+        switch kind {
+        case "match":
+            for field, value := range adapters.QueryFields(body) {
+                terms[field] = append(terms[field], strings.Fields(strings.ToLower(fmt.Sprint(value)))...)
+            }
+        case "bool":
+            b, _ := body.(map[string]interface{})
+            for _, occur := range []string{"must", "filter", "should"} {
+                for _, sub := range adapters.QueryClauses(b[occur]) {
+                    matchTerms(sub, terms)
+                }
+            }
+        }
+    }
+    return terms
+}
+
+// mark wraps every case-insensitive occurrence of terms in text.
+func mark(text string, terms []string, preTag, postTag string) (string, bool) {
+    lower := strings.ToLower(text)
+    var b strings.Builder
+    found := false
+    for i := 0; i < len(text); {
+        matched := 0
+        for _, term := range terms {
+            if len(term) > matched && strings.HasPrefix(lower[i:], term) {
+                matched = len(term)
+            }
+        }
+        if matched == 0 {
+            b.WriteByte(text[i])
+            i++
+            continue
+        }
+        found = true
+        b.WriteString(preTag + text[i:i+matched] + postTag)
+        i += matched
+    }
+    return b.String(), found
+}
+
+func firstTag(value interface{}, def string) string {
+    switch v := value.(type) {
+    case []string:
+        if len(v) > 0 {
+            return v[0]
+        }
+    case []interface{}:
+        if len(v) > 0 {
+            return fmt.Sprint(v[0])
+        }
+    }
+    return def
+}
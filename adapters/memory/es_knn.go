@@ -0,0 +1,62 @@
+package memory
+
+import (
+    "encoding/json"
+    "persistence-layer/types"
+    "sort"
+)
+
+// KnnSearch ranks every document matching filter by the cosine similarity of its field to vector,
+// scored (1 + cosine) / 2 as Elasticsearch does, and returns the best k.
+func (e *ESAdapter) KnnSearch(index, field string, vector []float32, k int, filter map[string]interface{}, result interface{}) error {
+    type scored struct {
+        id    string
+        score float64
+        doc   map[string]interface{}
+    }
+    e.mu.Lock()
+    var candidates []scored
+    for id, doc := range e.indices[index] {
+        ok, err := matchesQuery(filter, id, doc)
+        if err != nil {
+            e.mu.Unlock()
+            return err
+        }
+        values, isList := doc[field].([]interface{})
+        if !ok || !isList || len(values) != len(vector) {
+            continue
+        }
+        v := make(types.Vector, len(values))
+        for i, x := range values {
+            f, _ := number(x)
+            v[i] = float32(f)
+        }
+        candidates = append(candidates, scored{id, (1 + v.Cosine(vector)) / 2, doc})
+    }
+    e.mu.Unlock()
+
+    sort.Slice(candidates, func(i, j int) bool {
+        if candidates[i].score != candidates[j].score {
+            return candidates[i].score > candidates[j].score
+        }
+        return candidates[i].id < candidates[j].id
+    })
+    if k < len(candidates) {
+        candidates = candidates[:k]
+    }
+    hits := make([]map[string]interface{}, 0, len(candidates))
+    for _, c := range candidates {
+        hits = append(hits, map[string]interface{}{"_index": index, "_id": c.id, "_score": c.score, "_source": c.doc})
+    }
+    response := map[string]interface{}{
+        "hits": map[string]interface{}{
+            "total": map[string]interface{}{"value": len(hits), "relation": "eq"},
+            "hits":  hits,
+        },
+    }
+    data, err := json.Marshal(response)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, result)
+}
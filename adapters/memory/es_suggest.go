@@ -0,0 +1,43 @@
+package memory
+
+import (
+    "encoding/json"
+    "persistence-layer/adapters"
+    "sort"
+    "strings"
+)
+
+// Suggest returns the documents whose field starts with prefix, case-insensitively, ordered by
+// text. A "<field>.suggest" sub-field reads the parent field.
+func (e *ESAdapter) Suggest(index, field, prefix string, size int) ([]adapters.Suggestion, error) {
+    field = strings.TrimSuffix(field, ".suggest")
+    prefix = strings.ToLower(prefix)
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    suggestions := []adapters.Suggestion{}
+    seen := map[string]bool{}
+    ids := make([]string, 0, len(e.indices[index]))
+    for id := range e.indices[index] {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    for _, id := range ids {
+        doc := e.indices[index][id]
+        text, ok := doc[field].(string)
+        if !ok || !strings.HasPrefix(strings.ToLower(text), prefix) || seen[text] {
+            continue
+        }
+        source, err := json.Marshal(doc)
+        if err != nil {
+            return nil, err
+        }
+        seen[text] = true
+        suggestions = append(suggestions, adapters.Suggestion{Text: text, ID: id, Score: 1, Source: source})
+    }
+    sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].Text < suggestions[j].Text })
+    if size < len(suggestions) {
+        suggestions = suggestions[:size]
+    }
+    return suggestions, nil
+}
@@ -0,0 +1,379 @@
+package memory
+
+import (
+    "fmt"
+    "persistence-layer/adapters"
+    "reflect"
+    "strings"
+    "sync"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoAdapter is an in-memory adapters.MongoStore for unit tests. Filters support equality on
+// top-level fields only.
+type MongoAdapter struct {
+    mu          sync.Mutex
+    collections map[string][]bson.M
+}
+
+var (
+    _ adapters.MongoStore       = (*MongoAdapter)(nil)
+    _ adapters.DocumentUpdater  = (*MongoAdapter)(nil)
+    _ adapters.DocumentPruner   = (*MongoAdapter)(nil)
+    _ adapters.DocumentReplacer = (*MongoAdapter)(nil)
+)
+
+// NewMongoAdapter creates an empty in-memory document store.
+func NewMongoAdapter() *MongoAdapter {
+    return &MongoAdapter{collections: make(map[string][]bson.M)}
+}
+
+// Create appends a document to the collection.
+func (m *MongoAdapter) Create(collection string, model interface{}) error {
+    doc, err := toDocument(model)
+    if err != nil {
+        return err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.collections[collection] = append(m.collections[collection], doc)
+    return nil
+}
+
+// Read decodes the first document matching the filter, returning mongo.ErrNoDocuments when none does.
+func (m *MongoAdapter) Read(collection string, filter map[string]interface{}, result interface{}) error {
+    want, err := toDocument(filter)
+    if err != nil {
+        return err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, doc := range m.collections[collection] {
+        if matchesDocument(doc, want) {
+            data, err := bson.Marshal(doc)
+            if err != nil {
+                return err
+            }
+            return bson.Unmarshal(data, result)
+        }
+    }
+    return mongo.ErrNoDocuments
+}
+
+// Update sets the given fields on the first document matching the filter and returns the number
+// of documents matched.
+func (m *MongoAdapter) Update(collection string, filter map[string]interface{}, update interface{}) (int64, error) {
+    want, err := toDocument(filter)
+    if err != nil {
+        return 0, err
+    }
+    fields, err := toDocument(update)
+    if err != nil {
+        return 0, err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, doc := range m.collections[collection] {
+        if matchesDocument(doc, want) {
+            for k, v := range fields {
+                doc[k] = v
+            }
+            return 1, nil
+        }
+    }
+    return 0, nil
+}
+
+// Delete removes the first document matching the filter and returns the number of documents
+// deleted.
+func (m *MongoAdapter) Delete(collection string, filter map[string]interface{}) (int64, error) {
+    want, err := toDocument(filter)
+    if err != nil {
+        return 0, err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    docs := m.collections[collection]
+    for i, doc := range docs {
+        if matchesDocument(doc, want) {
+            m.collections[collection] = append(docs[:i], docs[i+1:]...)
+            return 1, nil
+        }
+    }
+    return 0, nil
+}
+
+// FindOneAndUpdate applies the $set, $setOnInsert, $inc and $unset operators of update to the first
+// document matching filter, in opts.Sort order, and decodes it into result. Other operators fail.
+func (m *MongoAdapter) FindOneAndUpdate(collection string, filter map[string]interface{}, update interface{}, opts adapters.FindOneAndUpdateOptions, result interface{}) error {
+    want, err := toDocument(filter)
+    if err != nil {
+        return err
+    }
+    ops, err := toDocument(update)
+    if err != nil {
+        return err
+    }
+    for op := range ops {
+        switch op {
+        case "$set", "$setOnInsert", "$inc", "$unset":
+        default:
+            return fmt.Errorf("update operator %s is not supported by the in-memory mongo adapter", op)
+        }
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var target bson.M
+    for _, doc := range m.collections[collection] {
+        if matchesDocument(doc, want) && (target == nil || sortsBefore(doc, target, opts.Sort)) {
+            target = doc
+        }
+    }
+    var before bson.M
+    if target != nil {
+        before = copyDocument(target)
+    } else {
+        if !opts.Upsert {
+            return mongo.ErrNoDocuments
+        }
+        target = upsertSeed(want)
+        applyOperator(target, ops["$setOnInsert"], func(_, v interface{}) interface{} { return v })
+        m.collections[collection] = append(m.collections[collection], target)
+    }
+    applyOperator(target, ops["$set"], func(_, v interface{}) interface{} { return v })
+    applyOperator(target, ops["$inc"], increment)
+    if unset, ok := ops["$unset"].(bson.M); ok {
+        for k := range unset {
+            delete(target, k)
+        }
+    }
+
+    doc := target
+    if !opts.ReturnNew {
+        if before == nil {
+            return mongo.ErrNoDocuments
+        }
+        doc = before
+    }
+    data, err := bson.Marshal(doc)
+    if err != nil {
+        return err
+    }
+    return bson.Unmarshal(data, result)
+}
+
+// ReplaceOne replaces the first document matching the filter by doc, appending doc when none
+// matches and upsert is set.
+func (m *MongoAdapter) ReplaceOne(collection string, filter map[string]interface{}, doc interface{}, upsert bool) error {
+    want, err := toDocument(filter)
+    if err != nil {
+        return err
+    }
+    replacement, err := toDocument(doc)
+    if err != nil {
+        return err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i, existing := range m.collections[collection] {
+        if matchesDocument(existing, want) {
+            m.collections[collection][i] = replacement
+            return nil
+        }
+    }
+    if upsert {
+        m.collections[collection] = append(m.collections[collection], replacement)
+    }
+    return nil
+}
+
+// FieldValues returns the value field takes in every document of the collection that has it.
+func (m *MongoAdapter) FieldValues(collection, field string) ([]interface{}, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var values []interface{}
+    for _, doc := range m.collections[collection] {
+        if value, ok := doc[field]; ok {
+            values = append(values, value)
+        }
+    }
+    return values, nil
+}
+
+// DeleteIn removes every document of the collection whose field is one of values.
+func (m *MongoAdapter) DeleteIn(collection, field string, values []interface{}) (int64, error) {
+    wanted := make([]bson.M, len(values))
+    for i, value := range values {
+        doc, err := toDocument(bson.M{field: value})
+        if err != nil {
+            return 0, err
+        }
+        wanted[i] = doc
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var kept []bson.M
+    var deleted int64
+    for _, doc := range m.collections[collection] {
+        match := false
+        for _, want := range wanted {
+            if matchesDocument(doc, want) {
+                match = true
+                break
+            }
+        }
+        if match {
+            deleted++
+            continue
+        }
+        kept = append(kept, doc)
+    }
+    m.collections[collection] = kept
+    return deleted, nil
+}
+
+// Disconnect is a no-op.
+func (m *MongoAdapter) Disconnect() {}
+
+func copyDocument(doc bson.M) bson.M {
+    out := make(bson.M, len(doc))
+    for k, v := range doc {
+        out[k] = v
+    }
+    return out
+}
+
+// upsertSeed returns the fields an upsert inserts from filter, as Mongo does: those it matches by
+// equality, including {"$eq": value}, but neither operator conditions such as {"$gt": 1} nor
+// top-level operators such as $or.
+func upsertSeed(filter bson.M) bson.M {
+    out := make(bson.M, len(filter))
+    for k, v := range filter {
+        if strings.HasPrefix(k, "$") {
+            continue
+        }
+        if cond, ok := v.(bson.M); ok && isOperatorDocument(cond) {
+            if eq, ok := cond["$eq"]; ok && len(cond) == 1 {
+                out[k] = eq
+            }
+            continue
+        }
+        out[k] = v
+    }
+    return out
+}
+
+// isOperatorDocument reports whether the keys of doc are query operators, e.g. {"$gt": 1}.
+func isOperatorDocument(doc bson.M) bool {
+    for k := range doc {
+        if strings.HasPrefix(k, "$") {
+            return true
+        }
+    }
+    return false
+}
+
+// applyOperator sets each field of fields, an operator's argument, to apply(current, value).
+func applyOperator(doc bson.M, fields interface{}, apply func(current, value interface{}) interface{}) {
+    if fields, ok := fields.(bson.M); ok {
+        for k, v := range fields {
+            doc[k] = apply(doc[k], v)
+        }
+    }
+}
+
+// increment adds delta to current like $inc, treating a missing field as 0.
+func increment(current, delta interface{}) interface{} {
+    switch d := delta.(type) {
+    case int32:
+        if c, ok := current.(int32); ok {
+            return c + d
+        }
+        if c, ok := current.(int64); ok {
+            return c + int64(d)
+        }
+    case int64:
+        if c, ok := current.(int64); ok {
+            return c + d
+        }
+        if c, ok := current.(int32); ok {
+            return int64(c) + d
+        }
+    }
+    if current == nil {
+        return delta
+    }
+    c, _ := toFloat(current)
+    f, _ := toFloat(delta)
+    return c + f
+}
+
+// sortsBefore reports whether doc precedes other in the order of sort, as in
+// adapters.FindOneAndUpdateOptions. Numbers and strings are compared; other values are equal.
+func sortsBefore(doc, other bson.M, sort []string) bool {
+    for _, field := range sort {
+        name := strings.TrimPrefix(field, "-")
+        c := compareValues(doc[name], other[name])
+        if name != field {
+            c = -c
+        }
+        if c != 0 {
+            return c < 0
+        }
+    }
+    return false
+}
+
+func compareValues(a, b interface{}) int {
+    if x, ok := toFloat(a); ok {
+        if y, ok := toFloat(b); ok {
+            switch {
+            case x < y:
+                return -1
+            case x > y:
+                return 1
+            }
+            return 0
+        }
+    }
+    x, okA := a.(string)
+    y, okB := b.(string)
+    if okA && okB {
+        return strings.Compare(x, y)
+    }
+    return 0
+}
+
+func toFloat(v interface{}) (float64, bool) {
+    switch n := v.(type) {
+    case int32:
+        return float64(n), true
+    case int64:
+        return float64(n), true
+    case float64:
+        return n, true
+    }
+    return 0, false
+}
+
+// toDocument round-trips a value through BSON so documents and filters compare with the same types.
+func toDocument(value interface{}) (bson.M, error) {
+    data, err := bson.Marshal(value)
+    if err != nil {
+        return nil, err
+    }
+    var doc bson.M
+    err = bson.Unmarshal(data, &doc)
+    return doc, err
+}
+
+func matchesDocument(doc, filter bson.M) bool {
+    for k, v := range filter {
+        if !reflect.DeepEqual(doc[k], v) {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+    "errors"
+    "persistence-layer/adapters"
+    "testing"
+
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+type testDocument struct {
+    ID   uint64 `bson:"_id"`
+    Name string `bson:"name"`
+}
+
+func TestMongoAdapterCounts(t *testing.T) {
+    m := NewMongoAdapter()
+    if err := m.Create("docs", &testDocument{ID: 1, Name: "first"}); err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+
+    if n, err := m.Update("docs", map[string]interface{}{"_id": uint64(1)}, map[string]interface{}{"name": "renamed"}); err != nil || n != 1 {
+        t.Fatalf("Update = %d, %v; want 1, nil", n, err)
+    }
+    var doc testDocument
+    if err := m.Read("docs", map[string]interface{}{"_id": uint64(1)}, &doc); err != nil || doc.Name != "renamed" {
+        t.Fatalf("Read = %+v, %v; want the renamed document", doc, err)
+    }
+    if n, err := m.Update("docs", map[string]interface{}{"_id": uint64(2)}, map[string]interface{}{"name": "missing"}); err != nil || n != 0 {
+        t.Fatalf("Update of a missing document = %d, %v; want 0, nil", n, err)
+    }
+
+    if n, err := m.Delete("docs", map[string]interface{}{"_id": uint64(1)}); err != nil || n != 1 {
+        t.Fatalf("Delete = %d, %v; want 1, nil", n, err)
+    }
+    if n, err := m.Delete("docs", map[string]interface{}{"_id": uint64(1)}); err != nil || n != 0 {
+        t.Fatalf("second Delete = %d, %v; want 0, nil", n, err)
+    }
+    if err := m.Read("docs", map[string]interface{}{"_id": uint64(1)}, &doc); !errors.Is(err, mongo.ErrNoDocuments) {
+        t.Fatalf("Read after delete = %v; want mongo.ErrNoDocuments", err)
+    }
+}
+
+func TestMongoAdapterUpsertSeedsEqualityConditions(t *testing.T) {
+    m := NewMongoAdapter()
+    filter := map[string]interface{}{
+        "name": map[string]interface{}{"$eq": "seeded"},
+        "rank": map[string]interface{}{"$gt": 1},
+        "$or":  []interface{}{map[string]interface{}{"name": "other"}},
+    }
+    update := map[string]interface{}{"$setOnInsert": map[string]interface{}{"_id": uint64(7)}}
+    var doc map[string]interface{}
+    if err := m.FindOneAndUpdate("docs", filter, update, adapters.FindOneAndUpdateOptions{Upsert: true, ReturnNew: true}, &doc); err != nil {
+        t.Fatalf("FindOneAndUpdate: %v", err)
+    }
+    if doc["name"] != "seeded" {
+        t.Fatalf("upserted name = %v, want the $eq value", doc["name"])
+    }
+    if _, ok := doc["rank"]; ok {
+        t.Fatalf("upserted document holds the $gt condition: %v", doc)
+    }
+    if _, ok := doc["$or"]; ok {
+        t.Fatalf("upserted document holds the $or operator: %v", doc)
+    }
+}
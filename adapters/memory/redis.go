@@ -0,0 +1,122 @@
+package memory
+
+import (
+    "encoding/json"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "sync"
+    "time"
+)
+
+type cacheEntry struct {
+    value     []byte
+    expiresAt time.Time // Zero means the entry never expires.
+}
+
+// RedisAdapter is an in-memory adapters.CacheStore for unit tests with the same cache-miss
+// semantics as the Redis adapter.
+type RedisAdapter struct {
+    mu      sync.Mutex
+    entries map[string]cacheEntry
+    zsets   map[string]map[string]float64
+    hashes  map[string]hashEntry
+    streams map[string]*stream
+    windows map[string][]time.Time
+    now     func() time.Time
+}
+
+var (
+    _ adapters.CacheStore   = (*RedisAdapter)(nil)
+    _ adapters.StreamStore  = (*RedisAdapter)(nil)
+    _ adapters.RateLimiter  = (*RedisAdapter)(nil)
+    _ adapters.UsageCounter = (*RedisAdapter)(nil)
+    _ adapters.GuardedCache = (*RedisAdapter)(nil)
+)
+
+// NewRedisAdapter creates an empty in-memory cache.
+func NewRedisAdapter() *RedisAdapter {
+    return &RedisAdapter{entries: make(map[string]cacheEntry), zsets: make(map[string]map[string]float64), hashes: make(map[string]hashEntry), streams: make(map[string]*stream), windows: make(map[string][]time.Time), now: time.Now}
+}
+
+// SetWithTTL stores the JSON encoding of value. A zero TTL keeps the entry forever.
+func (r *RedisAdapter) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return err
+    }
+    entry := cacheEntry{value: data}
+    if ttl > 0 {
+        entry.expiresAt = r.now().Add(ttl)
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.entries[key] = entry
+    return nil
+}
+
+// Get unmarshals the cached value into dest. A missing or expired key leaves dest untouched and
+// returns utils.ErrCacheMiss.
+func (r *RedisAdapter) Get(key string, dest interface{}) error {
+    entry, ok := r.lookup(key)
+    if !ok {
+        return utils.ErrCacheMiss
+    }
+    return json.Unmarshal(entry.value, dest)
+}
+
+// GetMany returns the stored JSON of every live key among keys.
+func (r *RedisAdapter) GetMany(keys []string) (map[string][]byte, error) {
+    hits := make(map[string][]byte, len(keys))
+    for _, key := range keys {
+        if entry, ok := r.lookup(key); ok {
+            hits[key] = entry.value
+        }
+    }
+    return hits, nil
+}
+
+// Delete removes a key.
+func (r *RedisAdapter) Delete(key string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    delete(r.entries, key)
+    delete(r.zsets, key)
+    delete(r.hashes, key)
+    delete(r.streams, key)
+    return nil
+}
+
+// Exists reports whether a live entry, sorted set, hash or stream exists for the key.
+func (r *RedisAdapter) Exists(key string) (bool, error) {
+    if _, ok := r.lookup(key); ok {
+        return true, nil
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return len(r.zsets[key]) > 0 || len(r.hash(key)) > 0 || r.streams[key] != nil, nil
+}
+
+// Close is a no-op.
+func (r *RedisAdapter) Close() error {
+    return nil
+}
+
+// lookup returns the live entry for key, evicting it if it has expired.
+func (r *RedisAdapter) lookup(key string) (cacheEntry, bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.live(key)
+}
+
+// live is lookup for callers holding r.mu.
+func (r *RedisAdapter) live(key string) (cacheEntry, bool) {
+    entry, ok := r.entries[key]
+    if !ok {
+        return cacheEntry{}, false
+    }
+    if !entry.expiresAt.IsZero() && !r.now().Before(entry.expiresAt) {
+        delete(r.entries, key)
+        return cacheEntry{}, false
+    }
+    return entry, true
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+    "fmt"
+    "strconv"
+)
+
+// IncrBy adds by to the integer at key, keeping its TTL, failing like Redis when it holds
+// something else.
+func (r *RedisAdapter) IncrBy(key string, by int64) (int64, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    entry, _ := r.live(key)
+    var n int64
+    if entry.value != nil {
+        var err error
+        if n, err = strconv.ParseInt(string(entry.value), 10, 64); err != nil {
+            return 0, fmt.Errorf("ERR value is not an integer or out of range")
+        }
+    }
+    n += by
+    entry.value = []byte(strconv.FormatInt(n, 10))
+    r.entries[key] = entry
+    return n, nil
+}
+
+// DecrBy subtracts by from the integer at key.
+func (r *RedisAdapter) DecrBy(key string, by int64) (int64, error) {
+    return r.IncrBy(key, -by)
+}
+
+// RenameNX moves whatever is stored at key to newKey unless newKey exists.
+func (r *RedisAdapter) RenameNX(key, newKey string) (bool, error) {
+    if exists, _ := r.Exists(newKey); exists {
+        return false, nil
+    }
+    if exists, _ := r.Exists(key); !exists {
+        return false, nil
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if entry, ok := r.entries[key]; ok {
+        r.entries[newKey] = entry
+        delete(r.entries, key)
+    }
+    if set, ok := r.zsets[key]; ok {
+        r.zsets[newKey] = set
+        delete(r.zsets, key)
+    }
+    if hash, ok := r.hashes[key]; ok {
+        r.hashes[newKey] = hash
+        delete(r.hashes, key)
+    }
+    if s, ok := r.streams[key]; ok {
+        r.streams[newKey] = s
+        delete(r.streams, key)
+    }
+    return true, nil
+}
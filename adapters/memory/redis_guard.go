@@ -0,0 +1,46 @@
+package memory
+
+import (
+    "encoding/json"
+    "strconv"
+    "time"
+)
+
+// BumpGuard increments the guard counter at key and gives it a TTL of ttl.
+func (r *RedisAdapter) BumpGuard(key string, ttl time.Duration) error {
+    if _, err := r.IncrBy(key, 1); err != nil {
+        return err
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    entry := r.entries[key]
+    entry.expiresAt = r.now().Add(ttl)
+    r.entries[key] = entry
+    return nil
+}
+
+// SetIfGuard stores the JSON encoding of value like SetWithTTL if the guard counter at guard, 0
+// when missing, still equals expected.
+func (r *RedisAdapter) SetIfGuard(key string, value interface{}, ttl time.Duration, guard string, expected int64) (bool, error) {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return false, err
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var current int64
+    if entry, ok := r.live(guard); ok {
+        if current, err = strconv.ParseInt(string(entry.value), 10, 64); err != nil {
+            return false, err
+        }
+    }
+    if current != expected {
+        return false, nil
+    }
+    entry := cacheEntry{value: data}
+    if ttl > 0 {
+        entry.expiresAt = r.now().Add(ttl)
+    }
+    r.entries[key] = entry
+    return true, nil
+}
@@ -0,0 +1,108 @@
+package memory
+
+import (
+    "fmt"
+    "strconv"
+    "time"
+)
+
+type hashEntry struct {
+    fields    map[string]string
+    expiresAt time.Time // Zero means the hash never expires.
+}
+
+// HSet sets fields of the hash at key and, when ttl is positive, resets its expiry.
+func (r *RedisAdapter) HSet(key string, fields map[string]string, ttl time.Duration) error {
+    if len(fields) == 0 {
+        return nil
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    entry := r.hashes[key]
+    if r.hash(key) == nil {
+        entry = hashEntry{fields: make(map[string]string)}
+    }
+    for field, value := range fields {
+        entry.fields[field] = value
+    }
+    if ttl > 0 {
+        entry.expiresAt = r.now().Add(ttl)
+    }
+    r.hashes[key] = entry
+    return nil
+}
+
+// HReplace replaces the hash at key with fields and, when ttl is positive, sets its expiry.
+func (r *RedisAdapter) HReplace(key string, fields map[string]string, ttl time.Duration) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    delete(r.hashes, key)
+    if len(fields) == 0 {
+        return nil
+    }
+    entry := hashEntry{fields: make(map[string]string, len(fields))}
+    for field, value := range fields {
+        entry.fields[field] = value
+    }
+    if ttl > 0 {
+        entry.expiresAt = r.now().Add(ttl)
+    }
+    r.hashes[key] = entry
+    return nil
+}
+
+// HGetAll returns a copy of every field of the live hash at key.
+func (r *RedisAdapter) HGetAll(key string) (map[string]string, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    out := map[string]string{}
+    for field, value := range r.hash(key) {
+        out[field] = value
+    }
+    return out, nil
+}
+
+// HIncrBy adds by to the integer in field, failing like Redis when it holds something else.
+func (r *RedisAdapter) HIncrBy(key, field string, by int64) (int64, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    fields := r.hash(key)
+    if fields == nil {
+        fields = make(map[string]string)
+        r.hashes[key] = hashEntry{fields: fields}
+    }
+    var n int64
+    if current, ok := fields[field]; ok {
+        var err error
+        if n, err = strconv.ParseInt(current, 10, 64); err != nil {
+            return 0, fmt.Errorf("ERR hash value is not an integer")
+        }
+    }
+    n += by
+    fields[field] = strconv.FormatInt(n, 10)
+    return n, nil
+}
+
+// HDel removes fields from the hash at key.
+func (r *RedisAdapter) HDel(key string, fields ...string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, field := range fields {
+        delete(r.hash(key), field)
+    }
+    return nil
+}
+
+// hash returns the fields of the live hash at key, evicting it if it has expired. The caller
+// holds r.mu.
+func (r *RedisAdapter) hash(key string) map[string]string {
+    entry, ok := r.hashes[key]
+    if !ok {
+        return nil
+    }
+    if !entry.expiresAt.IsZero() && !r.now().Before(entry.expiresAt) {
+        delete(r.hashes, key)
+        return nil
+    }
+    return entry.fields
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+    "regexp"
+    "strings"
+)
+
+// DeleteByPattern deletes every key matching the Redis glob pattern and returns how many it
+// deleted.
+func (r *RedisAdapter) DeleteByPattern(pattern string) (int64, error) {
+    re, err := globPattern(pattern)
+    if err != nil {
+        return 0, err
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    matched := map[string]bool{}
+    for key := range r.entries {
+        if _, live := r.live(key); live && re.MatchString(key) {
+            matched[key] = true
+        }
+    }
+    for key := range r.zsets {
+        if re.MatchString(key) {
+            matched[key] = true
+        }
+    }
+    for key := range r.hashes {
+        if r.hash(key) != nil && re.MatchString(key) {
+            matched[key] = true
+        }
+    }
+    for key := range r.streams {
+        if re.MatchString(key) {
+            matched[key] = true
+        }
+    }
+    for key := range matched {
+        delete(r.entries, key)
+        delete(r.zsets, key)
+        delete(r.hashes, key)
+        delete(r.streams, key)
+    }
+    return int64(len(matched)), nil
+}
+
+// globPattern translates a Redis glob pattern (*, ?, [...] and \ escapes) into a regexp.
+func globPattern(pattern string) (*regexp.Regexp, error) {
+    var b strings.Builder
+    b.WriteString("^")
+    for i := 0; i < len(pattern); i++ {
+        switch c := pattern[i]; c {
+        case '*':
+            b.WriteString(".*")
+        case '?':
+            b.WriteString(".")
+        case '[':
+            end := strings.IndexByte(pattern[i:], ']')
+            if end < 0 {
+                b.WriteString(`\[`)
+                continue
+            }
+            class := pattern[i+1 : i+end]
+            if strings.HasPrefix(class, "^") {
+                class = "^" + regexp.QuoteMeta(class[1:])
+            } else {
+                class = regexp.QuoteMeta(class)
+            }
+            b.WriteString("[" + class + "]")
+            i += end
+        case '\\':
+            if i+1 < len(pattern) {
+                i++
+                b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+            }
+        default:
+            b.WriteString(regexp.QuoteMeta(string(c)))
+        }
+    }
+    b.WriteString("$")
+    return regexp.Compile(b.String())
+}
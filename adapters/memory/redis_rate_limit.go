@@ -0,0 +1,32 @@
+package memory
+
+import (
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "time"
+)
+
+// Allow is a sliding-window limiter with the semantics of the Redis adapter's.
+func (r *RedisAdapter) Allow(key string, limit int64, window time.Duration) (adapters.RateLimit, error) {
+    if limit <= 0 || window <= 0 {
+        return adapters.RateLimit{}, fmt.Errorf("%w: rate limit of %d per %s", utils.ErrInvalidValue, limit, window)
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    now := r.now()
+    requests := r.windows[key]
+    for len(requests) > 0 && !requests[0].After(now.Add(-window)) {
+        requests = requests[1:]
+    }
+    if int64(len(requests)) >= limit {
+        r.windows[key] = requests
+        retry := time.Duration(0)
+        if len(requests) > 0 {
+            retry = requests[0].Add(window).Sub(now)
+        }
+        return adapters.RateLimit{RetryAfter: retry}, nil
+    }
+    r.windows[key] = append(requests, now)
+    return adapters.RateLimit{Allowed: true, Remaining: limit - int64(len(requests)) - 1}, nil
+}
@@ -0,0 +1,164 @@
+package memory
+
+import (
+    "persistence-layer/adapters"
+    "sort"
+)
+
+// ZAdd adds members to the sorted set at key, replacing the scores of existing ones.
+func (r *RedisAdapter) ZAdd(key string, members ...adapters.ScoredMember) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    set := r.zset(key)
+    for _, m := range members {
+        set[m.Member] = m.Score
+    }
+    return nil
+}
+
+// ZIncrBy adds by to the score of member and returns the new score.
+func (r *RedisAdapter) ZIncrBy(key, member string, by float64) (float64, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    set := r.zset(key)
+    set[member] += by
+    return set[member], nil
+}
+
+// ZRange returns the members ranked start to stop inclusive, with Redis's negative rank semantics.
+func (r *RedisAdapter) ZRange(key string, start, stop int64, desc bool) ([]adapters.ScoredMember, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    sorted := r.sorted(key, desc)
+    from, to, ok := rankRange(start, stop, len(sorted))
+    if !ok {
+        return []adapters.ScoredMember{}, nil
+    }
+    return sorted[from : to+1], nil
+}
+
+// ZRangeByScore returns up to limit members scored between min and max inclusive; 0 returns all.
+func (r *RedisAdapter) ZRangeByScore(key string, min, max float64, desc bool, limit int64) ([]adapters.ScoredMember, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    out := []adapters.ScoredMember{}
+    for _, m := range r.sorted(key, desc) {
+        if m.Score < min || m.Score > max {
+            continue
+        }
+        if limit > 0 && int64(len(out)) == limit {
+            break
+        }
+        out = append(out, m)
+    }
+    return out, nil
+}
+
+// ZRank returns the 0-based rank of member, or false when it is not in the set.
+func (r *RedisAdapter) ZRank(key, member string, desc bool) (int64, bool, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for i, m := range r.sorted(key, desc) {
+        if m.Member == member {
+            return int64(i), true, nil
+        }
+    }
+    return 0, false, nil
+}
+
+// ZRem removes members from the sorted set at key.
+func (r *RedisAdapter) ZRem(key string, members ...string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, m := range members {
+        delete(r.zsets[key], m)
+    }
+    return nil
+}
+
+// ZRemRangeByRank removes the members ranked start to stop by ascending score.
+func (r *RedisAdapter) ZRemRangeByRank(key string, start, stop int64) (int64, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    sorted := r.sorted(key, false)
+    from, to, ok := rankRange(start, stop, len(sorted))
+    if !ok {
+        return 0, nil
+    }
+    for _, m := range sorted[from : to+1] {
+        delete(r.zsets[key], m.Member)
+    }
+    return int64(to - from + 1), nil
+}
+
+// ZRemRangeByScore removes the members scored between min and max inclusive.
+func (r *RedisAdapter) ZRemRangeByScore(key string, min, max float64) (int64, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var removed int64
+    for member, score := range r.zsets[key] {
+        if score >= min && score <= max {
+            delete(r.zsets[key], member)
+            removed++
+        }
+    }
+    return removed, nil
+}
+
+// ZCard returns the number of members of the sorted set at key.
+func (r *RedisAdapter) ZCard(key string) (int64, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return int64(len(r.zsets[key])), nil
+}
+
+// zset returns the sorted set at key, creating it. The caller holds r.mu.
+func (r *RedisAdapter) zset(key string) map[string]float64 {
+    set, ok := r.zsets[key]
+    if !ok {
+        set = make(map[string]float64)
+        r.zsets[key] = set
+    }
+    return set
+}
+
+// sorted returns the members of the set at key ordered as Redis does: by score, then by member.
+// The caller holds r.mu.
+func (r *RedisAdapter) sorted(key string, desc bool) []adapters.ScoredMember {
+    out := make([]adapters.ScoredMember, 0, len(r.zsets[key]))
+    for member, score := range r.zsets[key] {
+        out = append(out, adapters.ScoredMember{Member: member, Score: score})
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Score != out[j].Score {
+            return out[i].Score < out[j].Score
+        }
+        return out[i].Member < out[j].Member
+    })
+    if desc {
+        for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+            out[i], out[j] = out[j], out[i]
+        }
+    }
+    return out
+}
+
+// rankRange resolves Redis rank bounds, which may count from the end, against a set of n members.
+func rankRange(start, stop int64, n int) (int, int, bool) {
+    if start < 0 {
+        start += int64(n)
+    }
+    if stop < 0 {
+        stop += int64(n)
+    }
+    if start < 0 {
+        start = 0
+    }
+    if stop >= int64(n) {
+        stop = int64(n) - 1
+    }
+    if start > stop {
+        return 0, 0, false
+    }
+    return int(start), int(stop), true
+}
@@ -0,0 +1,157 @@
+package memory
+
+import (
+    "fmt"
+    "persistence-layer/adapters"
+    "time"
+)
+
+type stream struct {
+    entries []adapters.StreamMessage
+    lastMs  int64
+    seq     int64
+    groups  map[string]*streamGroup
+}
+
+type streamGroup struct {
+    next    int // Index of the first entry not yet delivered to the group.
+    pending map[string]*pendingEntry
+}
+
+type pendingEntry struct {
+    consumer    string
+    deliveredAt time.Time
+    deliveries  int64
+}
+
+// XAdd appends an entry with a Redis-style "<ms>-<seq>" ID, trimming to exactly maxLen entries.
+func (r *RedisAdapter) XAdd(name string, values map[string]string, maxLen int64) (string, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    s := r.stream(name)
+    ms := r.now().UnixMilli()
+    if ms <= s.lastMs {
+        ms = s.lastMs
+        s.seq++
+    } else {
+        s.lastMs, s.seq = ms, 0
+    }
+    id := fmt.Sprintf("%d-%d", ms, s.seq)
+    copied := make(map[string]string, len(values))
+    for k, v := range values {
+        copied[k] = v
+    }
+    s.entries = append(s.entries, adapters.StreamMessage{ID: id, Values: copied})
+    if maxLen > 0 && int64(len(s.entries)) > maxLen {
+        drop := len(s.entries) - int(maxLen)
+        s.entries = s.entries[drop:]
+        for _, g := range s.groups {
+            g.next -= drop
+            if g.next < 0 {
+                g.next = 0
+            }
+        }
+    }
+    return id, nil
+}
+
+// XGroupCreate creates a group reading the stream from its first entry, unless it exists.
+func (r *RedisAdapter) XGroupCreate(name, group string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    s := r.stream(name)
+    if _, ok := s.groups[group]; !ok {
+        s.groups[group] = &streamGroup{pending: make(map[string]*pendingEntry)}
+    }
+    return nil
+}
+
+// XReadGroup delivers up to count new entries to consumer, polling for up to block when there are
+// none.
+func (r *RedisAdapter) XReadGroup(name, group, consumer string, count int64, block time.Duration) ([]adapters.StreamMessage, error) {
+    deadline := time.Now().Add(block)
+    for {
+        messages, err := r.readGroup(name, group, consumer, count)
+        if err != nil || len(messages) > 0 || !time.Now().Before(deadline) {
+            return messages, err
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func (r *RedisAdapter) readGroup(name, group, consumer string, count int64) ([]adapters.StreamMessage, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    g, err := r.group(name, group)
+    if err != nil {
+        return nil, err
+    }
+    s := r.streams[name]
+    var out []adapters.StreamMessage
+    for g.next < len(s.entries) && (count <= 0 || int64(len(out)) < count) {
+        m := s.entries[g.next]
+        g.next++
+        g.pending[m.ID] = &pendingEntry{consumer: consumer, deliveredAt: r.now(), deliveries: 1}
+        out = append(out, m)
+    }
+    return out, nil
+}
+
+// XClaimStale transfers entries pending for at least minIdle to consumer, oldest first.
+func (r *RedisAdapter) XClaimStale(name, group, consumer string, minIdle time.Duration, count int64) ([]adapters.StreamMessage, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    g, err := r.group(name, group)
+    if err != nil {
+        return nil, err
+    }
+    var out []adapters.StreamMessage
+    for _, m := range r.streams[name].entries {
+        if count > 0 && int64(len(out)) >= count {
+            break
+        }
+        p, ok := g.pending[m.ID]
+        if !ok || r.now().Sub(p.deliveredAt) < minIdle {
+            continue
+        }
+        p.consumer, p.deliveredAt = consumer, r.now()
+        p.deliveries++
+        m.Deliveries = p.deliveries
+        out = append(out, m)
+    }
+    return out, nil
+}
+
+// XAck removes entries from the group's pending list.
+func (r *RedisAdapter) XAck(name, group string, ids ...string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    g, err := r.group(name, group)
+    if err != nil {
+        return err
+    }
+    for _, id := range ids {
+        delete(g.pending, id)
+    }
+    return nil
+}
+
+// stream returns the stream at key, creating it. The caller holds r.mu.
+func (r *RedisAdapter) stream(key string) *stream {
+    s, ok := r.streams[key]
+    if !ok {
+        s = &stream{groups: make(map[string]*streamGroup)}
+        r.streams[key] = s
+    }
+    return s
+}
+
+// group returns a consumer group, failing like Redis when it doesn't exist. The caller holds r.mu.
+func (r *RedisAdapter) group(key, name string) (*streamGroup, error) {
+    if s, ok := r.streams[key]; ok {
+        if g, ok := s.groups[name]; ok {
+            return g, nil
+        }
+    }
+    return nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, name)
+}
@@ -0,0 +1,54 @@
+package memory
+
+import (
+    "persistence-layer/adapters"
+    "strconv"
+    "time"
+)
+
+// ReserveUsage applies deltas to the counters of the hash at key if none of them would exceed its
+// limit, as the Redis adapter's script does.
+func (r *RedisAdapter) ReserveUsage(key string, ttl time.Duration, deltas ...adapters.UsageDelta) (adapters.UsageReservation, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    counters, sizes := r.usageHash(key, ttl), r.usageHash(key+":sizes", ttl)
+    changes := make([]int64, len(deltas))
+    for i, d := range deltas {
+        by := d.By
+        if d.Member != "" {
+            old, _ := strconv.ParseInt(sizes[d.Field+":"+d.Member], 10, 64)
+            by -= old
+        }
+        used, _ := strconv.ParseInt(counters[d.Field], 10, 64)
+        if by > 0 && d.Limit > 0 && used+by > d.Limit {
+            return adapters.UsageReservation{Field: d.Field, Used: used}, nil
+        }
+        changes[i] = by
+    }
+    for i, d := range deltas {
+        used, _ := strconv.ParseInt(counters[d.Field], 10, 64)
+        counters[d.Field] = strconv.FormatInt(used+changes[i], 10)
+        switch {
+        case d.Member == "":
+        case d.By == 0:
+            delete(sizes, d.Field+":"+d.Member)
+        default:
+            sizes[d.Field+":"+d.Member] = strconv.FormatInt(d.By, 10)
+        }
+    }
+    return adapters.UsageReservation{Applied: true}, nil
+}
+
+// usageHash returns the fields of the live hash at key, creating it with ttl if it is missing.
+// The caller holds r.mu.
+func (r *RedisAdapter) usageHash(key string, ttl time.Duration) map[string]string {
+    if fields := r.hash(key); fields != nil {
+        return fields
+    }
+    entry := hashEntry{fields: make(map[string]string)}
+    if ttl > 0 {
+        entry.expiresAt = r.now().Add(ttl)
+    }
+    r.hashes[key] = entry
+    return entry.fields
+}
@@ -0,0 +1,384 @@
+package memory
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "persistence-layer/adapters"
+    "reflect"
+    "sync"
+
+    "gorm.io/gorm"
+)
+
+var (
+    errRawSQLUnsupported  = errors.New("raw SQL is not supported by the in-memory SQL adapter")
+    errPreloadUnsupported = errors.New("preloading associations is not supported by the in-memory SQL adapter")
+)
+
+// sqlStore holds the committed rows of every table, keyed by model type name and formatted ID.
+type sqlStore struct {
+    mu     sync.Mutex
+    tables map[string]map[string][]byte
+    nextID map[string]uint64
+}
+
+// SQLAdapter is an in-memory adapters.SQLStore for unit tests. Rows are stored as JSON keyed by the
+// model's type name and its ID field; transactions buffer their writes until Commit.
+type SQLAdapter struct {
+    store   *sqlStore
+    inTx    bool
+    pending map[string]map[string][]byte // A nil row marks a pending delete.
+    saved   map[string]map[string]map[string][]byte
+}
+
+var _ adapters.SQLStore = (*SQLAdapter)(nil)
+
+// NewSQLAdapter creates an empty in-memory SQL store.
+func NewSQLAdapter() *SQLAdapter {
+    return &SQLAdapter{
+        store: &sqlStore{
+            tables: make(map[string]map[string][]byte),
+            nextID: make(map[string]uint64),
+        },
+    }
+}
+
+// GetDB returns nil; there is no underlying database connection. The ORM features built on gorm
+// itself, such as associations, backups and webhooks, fail with an error against this adapter.
+func (s *SQLAdapter) GetDB() *gorm.DB {
+    return nil
+}
+
+// WithContext returns the adapter itself; the in-memory store does not block.
+func (s *SQLAdapter) WithContext(ctx context.Context) adapters.SQLStore {
+    return s
+}
+
+// Create stores a new record. Like gorm it runs the model's BeforeCreate hook, then assigns the next
+// ID when an integer ID field is still zero.
+func (s *SQLAdapter) Create(model interface{}) error {
+    table, err := tableName(model)
+    if err != nil {
+        return err
+    }
+    if hook, ok := model.(interface{ BeforeCreate(*gorm.DB) error }); ok {
+        if err := hook.BeforeCreate(nil); err != nil {
+            return err
+        }
+    }
+    field, err := idField(model)
+    if err != nil {
+        return err
+    }
+    if field.Kind() != reflect.String && field.IsZero() {
+        s.store.mu.Lock()
+        s.store.nextID[table]++
+        id := s.store.nextID[table]
+        s.store.mu.Unlock()
+        if field.CanUint() {
+            field.SetUint(id)
+        } else {
+            field.SetInt(int64(id))
+        }
+    }
+    if field.IsZero() {
+        return fmt.Errorf("model %T has an empty key", model)
+    }
+    return s.write(table, rowKey(field.Interface()), model)
+}
+
+// Read loads the record with the given ID into model, returning gorm.ErrRecordNotFound when missing.
+func (s *SQLAdapter) Read(id uint, model interface{}) error {
+    return s.ReadByKey(id, model)
+}
+
+// ReadByKey loads the record with the given key into model, returning gorm.ErrRecordNotFound when missing.
+func (s *SQLAdapter) ReadByKey(key interface{}, model interface{}) error {
+    table, err := tableName(model)
+    if err != nil {
+        return err
+    }
+    row, ok := s.row(table, rowKey(key))
+    if !ok {
+        return gorm.ErrRecordNotFound
+    }
+    return json.Unmarshal(row, model)
+}
+
+// ReadForUpdate behaves like Read; the in-memory store has no row locks.
+func (s *SQLAdapter) ReadForUpdate(id uint, model interface{}) error {
+    return s.Read(id, model)
+}
+
+// ClaimBatch is not supported by the in-memory adapter because it cannot evaluate SQL conditions.
+func (s *SQLAdapter) ClaimBatch(dest interface{}, limit int, query string, args ...interface{}) error {
+    return errRawSQLUnsupported
+}
+
+// ReadMany appends the records with the given keys to dest, a pointer to a slice of models,
+// skipping missing keys.
+func (s *SQLAdapter) ReadMany(keys []interface{}, dest interface{}) error {
+    slice := reflect.ValueOf(dest)
+    if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+        return fmt.Errorf("dest must be a pointer to a slice, got %T", dest)
+    }
+    elemType := slice.Elem().Type().Elem()
+    structType := elemType
+    if structType.Kind() == reflect.Ptr {
+        structType = structType.Elem()
+    }
+    for _, key := range keys {
+        record := reflect.New(structType)
+        if err := s.ReadByKey(key, record.Interface()); err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                continue
+            }
+            return err
+        }
+        if elemType.Kind() != reflect.Ptr {
+            record = record.Elem()
+        }
+        slice.Elem().Set(reflect.Append(slice.Elem(), record))
+    }
+    return nil
+}
+
+// ReadWith behaves like ReadByKey when no associations are requested; the in-memory store has no
+// relations to preload.
+func (s *SQLAdapter) ReadWith(key interface{}, model interface{}, preloads ...string) error {
+    if len(preloads) > 0 {
+        return errPreloadUnsupported
+    }
+    return s.ReadByKey(key, model)
+}
+
+// Preload is not supported by the in-memory adapter unless no associations are requested.
+func (s *SQLAdapter) Preload(dest interface{}, preloads ...string) error {
+    if len(preloads) > 0 {
+        return errPreloadUnsupported
+    }
+    return nil
+}
+
+// Update saves the record, inserting it when its ID is zero like SQLAdapter.Update. A record whose
+// ID has no row is not written and counts 0 rows.
+func (s *SQLAdapter) Update(model interface{}) (int64, error) {
+    field, err := idField(model)
+    if err != nil {
+        return 0, err
+    }
+    if field.IsZero() {
+        if err := s.Create(model); err != nil {
+            return 0, err
+        }
+        return 1, nil
+    }
+    table, err := tableName(model)
+    if err != nil {
+        return 0, err
+    }
+    id := rowKey(field.Interface())
+    if _, found := s.row(table, id); !found {
+        return 0, nil
+    }
+    if err := s.write(table, id, model); err != nil {
+        return 0, err
+    }
+    return 1, nil
+}
+
+// Delete removes the record with the given ID. Deleting a missing record is not an error; it
+// deletes no rows.
+func (s *SQLAdapter) Delete(id uint, model interface{}) (int64, error) {
+    return s.DeleteByKey(id, model)
+}
+
+// DeleteByKey removes the record with the given key. Soft delete is not emulated; the row is removed.
+func (s *SQLAdapter) DeleteByKey(key interface{}, model interface{}) (int64, error) {
+    table, err := tableName(model)
+    if err != nil {
+        return 0, err
+    }
+    id := rowKey(key)
+    _, found := s.row(table, id)
+    if s.inTx {
+        s.pendingTable(table)[id] = nil
+    } else {
+        s.store.mu.Lock()
+        delete(s.store.tables[table], id)
+        s.store.mu.Unlock()
+    }
+    if !found {
+        return 0, nil
+    }
+    return 1, nil
+}
+
+// BeginTransaction returns an adapter whose writes are only visible to others after Commit.
+func (s *SQLAdapter) BeginTransaction() (adapters.SQLStore, error) {
+    return &SQLAdapter{
+        store:   s.store,
+        inTx:    true,
+        pending: make(map[string]map[string][]byte),
+        saved:   make(map[string]map[string]map[string][]byte),
+    }, nil
+}
+
+// Commit applies the buffered writes of the transaction.
+func (s *SQLAdapter) Commit() error {
+    if !s.inTx {
+        return gorm.ErrInvalidTransaction
+    }
+    s.store.mu.Lock()
+    defer s.store.mu.Unlock()
+    for table, rows := range s.pending {
+        for id, row := range rows {
+            if row == nil {
+                delete(s.store.tables[table], id)
+                continue
+            }
+            if s.store.tables[table] == nil {
+                s.store.tables[table] = make(map[string][]byte)
+            }
+            s.store.tables[table][id] = row
+        }
+    }
+    s.inTx = false
+    s.pending = nil
+    s.saved = nil
+    return nil
+}
+
+// Rollback discards the buffered writes of the transaction.
+func (s *SQLAdapter) Rollback() error {
+    if !s.inTx {
+        return gorm.ErrInvalidTransaction
+    }
+    s.inTx = false
+    s.pending = nil
+    s.saved = nil
+    return nil
+}
+
+// SavePoint snapshots the buffered writes of the transaction under name.
+func (s *SQLAdapter) SavePoint(name string) error {
+    if !s.inTx {
+        return gorm.ErrInvalidTransaction
+    }
+    s.saved[name] = copyPending(s.pending)
+    return nil
+}
+
+// RollbackTo restores the buffered writes snapshotted by SavePoint.
+func (s *SQLAdapter) RollbackTo(name string) error {
+    if !s.inTx {
+        return gorm.ErrInvalidTransaction
+    }
+    snapshot, ok := s.saved[name]
+    if !ok {
+        return fmt.Errorf("savepoint %q does not exist", name)
+    }
+    s.pending = copyPending(snapshot)
+    return nil
+}
+
+// RawQuery is not supported by the in-memory adapter.
+func (s *SQLAdapter) RawQuery(query string, params []interface{}, dest interface{}) error {
+    return errRawSQLUnsupported
+}
+
+// Exec is not supported by the in-memory adapter.
+func (s *SQLAdapter) Exec(query string, params []interface{}) (int64, error) {
+    return 0, errRawSQLUnsupported
+}
+
+// Close is a no-op.
+func (s *SQLAdapter) Close() error {
+    return nil
+}
+
+// row returns the visible row for the ID, preferring uncommitted writes of the current transaction.
+func (s *SQLAdapter) row(table, id string) ([]byte, bool) {
+    if s.inTx {
+        if row, ok := s.pending[table][id]; ok {
+            return row, row != nil
+        }
+    }
+    s.store.mu.Lock()
+    defer s.store.mu.Unlock()
+    row, ok := s.store.tables[table][id]
+    return row, ok
+}
+
+func (s *SQLAdapter) write(table, id string, model interface{}) error {
+    row, err := json.Marshal(model)
+    if err != nil {
+        return err
+    }
+    if s.inTx {
+        s.pendingTable(table)[id] = row
+        return nil
+    }
+    s.store.mu.Lock()
+    defer s.store.mu.Unlock()
+    if s.store.tables[table] == nil {
+        s.store.tables[table] = make(map[string][]byte)
+    }
+    s.store.tables[table][id] = row
+    return nil
+}
+
+func (s *SQLAdapter) pendingTable(table string) map[string][]byte {
+    if s.pending[table] == nil {
+        s.pending[table] = make(map[string][]byte)
+    }
+    return s.pending[table]
+}
+
+func copyPending(pending map[string]map[string][]byte) map[string]map[string][]byte {
+    out := make(map[string]map[string][]byte, len(pending))
+    for table, rows := range pending {
+        out[table] = make(map[string][]byte, len(rows))
+        for id, row := range rows {
+            out[table][id] = row
+        }
+    }
+    return out
+}
+
+// tableName uses the model's struct type name as its table.
+func tableName(model interface{}) (string, error) {
+    t := reflect.TypeOf(model)
+    for t != nil && t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t == nil || t.Kind() != reflect.Struct {
+        return "", fmt.Errorf("model must be a pointer to a struct, got %T", model)
+    }
+    return t.Name(), nil
+}
+
+// idField returns the settable ID field of a model, which may be an integer or a string.
+func idField(model interface{}) (reflect.Value, error) {
+    v := reflect.ValueOf(model)
+    if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+        return reflect.Value{}, fmt.Errorf("model must be a pointer to a struct, got %T", model)
+    }
+    field := v.Elem().FieldByName("ID")
+    if !field.IsValid() {
+        return reflect.Value{}, fmt.Errorf("model %T has no ID field", model)
+    }
+    switch field.Kind() {
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+        reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.String:
+        return field, nil
+    }
+    return reflect.Value{}, fmt.Errorf("model %T has an ID field that is neither an integer nor a string", model)
+}
+
+// rowKey formats a key so that equal IDs of different integer types map to the same row.
+func rowKey(key interface{}) string {
+    return fmt.Sprint(key)
+}
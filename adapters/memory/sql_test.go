@@ -0,0 +1,79 @@
+package memory
+
+import (
+    "errors"
+    "testing"
+
+    "gorm.io/gorm"
+)
+
+type testRecord struct {
+    ID   uint64 `json:"id"`
+    Name string `json:"name"`
+}
+
+func TestSQLAdapterCreateReadUpdateDelete(t *testing.T) {
+    s := NewSQLAdapter()
+    if s.GetDB() != nil {
+        t.Fatal("GetDB() != nil; the in-memory adapter has no gorm connection")
+    }
+    record := &testRecord{Name: "first"}
+    if err := s.Create(record); err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    if record.ID != 1 {
+        t.Fatalf("Create assigned ID %d, want 1", record.ID)
+    }
+
+    record.Name = "renamed"
+    if rows, err := s.Update(record); err != nil || rows != 1 {
+        t.Fatalf("Update = %d, %v; want 1, nil", rows, err)
+    }
+    var read testRecord
+    if err := s.ReadByKey(record.ID, &read); err != nil || read.Name != "renamed" {
+        t.Fatalf("ReadByKey = %+v, %v; want the renamed record", read, err)
+    }
+    if rows, err := s.Update(&testRecord{ID: 42, Name: "missing"}); err != nil || rows != 0 {
+        t.Fatalf("Update of a missing record = %d, %v; want 0, nil", rows, err)
+    }
+
+    if rows, err := s.DeleteByKey(record.ID, &testRecord{}); err != nil || rows != 1 {
+        t.Fatalf("DeleteByKey = %d, %v; want 1, nil", rows, err)
+    }
+    if err := s.ReadByKey(record.ID, &read); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Fatalf("ReadByKey after delete = %v; want gorm.ErrRecordNotFound", err)
+    }
+}
+
+func TestSQLAdapterTransactions(t *testing.T) {
+    s := NewSQLAdapter()
+    tx, err := s.BeginTransaction()
+    if err != nil {
+        t.Fatalf("BeginTransaction: %v", err)
+    }
+    record := &testRecord{Name: "pending"}
+    if err := tx.Create(record); err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    var read testRecord
+    if err := s.ReadByKey(record.ID, &read); !errors.Is(err, gorm.ErrRecordNotFound) {
+        t.Fatalf("uncommitted write is visible outside the transaction: %v", err)
+    }
+    if err := tx.Commit(); err != nil {
+        t.Fatalf("Commit: %v", err)
+    }
+    if err := s.ReadByKey(record.ID, &read); err != nil {
+        t.Fatalf("committed write is not visible: %v", err)
+    }
+
+    tx, _ = s.BeginTransaction()
+    if _, err := tx.DeleteByKey(record.ID, &testRecord{}); err != nil {
+        t.Fatalf("DeleteByKey: %v", err)
+    }
+    if err := tx.Rollback(); err != nil {
+        t.Fatalf("Rollback: %v", err)
+    }
+    if err := s.ReadByKey(record.ID, &read); err != nil {
+        t.Fatalf("rolled back delete removed the record: %v", err)
+    }
+}
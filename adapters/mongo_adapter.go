@@ -2,55 +2,161 @@ package adapters
 
 import (
     "context"
+    "fmt"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
+    "strings"
     "time"
 )
 
 type MongoAdapter struct {
-    client *mongo.Client
-    ctx    context.Context
+    client    *mongo.Client
+    ctx       context.Context
+    deadlines Deadlines
 }
 
-// NewMongoAdapter initializes a new MongoAdapter with a given URI.
-func NewMongoAdapter(uri string) *MongoAdapter {
+// NewMongoAdapter initializes a new MongoAdapter with a given URI, pinging the server according to policy.
+func NewMongoAdapter(uri string, policy RetryPolicy) (*MongoAdapter, error) {
     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
     client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
     if err != nil {
-        panic("Failed to connect to MongoDB")
+        return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
     }
-    return &MongoAdapter{client: client, ctx: context.TODO()}
+
+    err = connectWithRetry("mongo", policy, func(ctx context.Context) error {
+        return client.Ping(ctx, nil)
+    })
+    if err != nil {
+        _ = client.Disconnect(context.Background())
+        return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+    }
+    return &MongoAdapter{client: client, ctx: context.TODO()}, nil
+}
+
+// SetDeadlines bounds reads by d.Read and inserts, updates and deletes by d.Write. Call it once, at
+// startup.
+func (m *MongoAdapter) SetDeadlines(d Deadlines) error {
+    m.deadlines = d
+    return nil
 }
 
 // Create inserts a new document into a MongoDB collection.
 func (m *MongoAdapter) Create(collection string, model interface{}) error {
     col := m.client.Database("app_db").Collection(collection)
-    _, err := col.InsertOne(m.ctx, model)
+    ctx, cancel := m.deadlines.bound(m.ctx, true)
+    defer cancel()
+    _, err := col.InsertOne(ctx, model)
     return err
 }
 
 // Read retrieves a document from a MongoDB collection using a filter.
 func (m *MongoAdapter) Read(collection string, filter map[string]interface{}, result interface{}) error {
     col := m.client.Database("app_db").Collection(collection)
-    return col.FindOne(m.ctx, filter).Decode(result)
+    ctx, cancel := m.deadlines.bound(m.ctx, false)
+    defer cancel()
+    return col.FindOne(ctx, filter).Decode(result)
 }
 
-// Update modifies an existing document in a MongoDB collection using a filter.
-func (m *MongoAdapter) Update(collection string, filter map[string]interface{}, update interface{}) error {
+// Update modifies an existing document in a MongoDB collection using a filter and returns the
+// number of documents matched, 0 when none did.
+func (m *MongoAdapter) Update(collection string, filter map[string]interface{}, update interface{}) (int64, error) {
     col := m.client.Database("app_db").Collection(collection)
-    _, err := col.UpdateOne(m.ctx, filter, bson.M{"$set": update})
-    return err
+    ctx, cancel := m.deadlines.bound(m.ctx, true)
+    defer cancel()
+    res, err := col.UpdateOne(ctx, filter, bson.M{"$set": update})
+    if err != nil {
+        return 0, err
+    }
+    return res.MatchedCount, nil
+}
+
+// Delete removes a document from a MongoDB collection using a filter and returns the number of
+// documents deleted, 0 when none matched.
+func (m *MongoAdapter) Delete(collection string, filter map[string]interface{}) (int64, error) {
+    col := m.client.Database("app_db").Collection(collection)
+    ctx, cancel := m.deadlines.bound(m.ctx, true)
+    defer cancel()
+    res, err := col.DeleteOne(ctx, filter)
+    if err != nil {
+        return 0, err
+    }
+    return res.DeletedCount, nil
+}
+
+// FindOneAndUpdate atomically updates the first document matching filter and decodes it into
+// result, as it was before the update unless opts.ReturnNew is set.
+func (m *MongoAdapter) FindOneAndUpdate(collection string, filter map[string]interface{}, update interface{}, opts FindOneAndUpdateOptions, result interface{}) error {
+    col := m.client.Database("app_db").Collection(collection)
+    ctx, cancel := m.deadlines.bound(m.ctx, true)
+    defer cancel()
+    findOpts := options.FindOneAndUpdate().SetUpsert(opts.Upsert)
+    if opts.ReturnNew {
+        findOpts.SetReturnDocument(options.After)
+    }
+    if len(opts.Sort) > 0 {
+        sort := make(bson.D, len(opts.Sort))
+        for i, field := range opts.Sort {
+            if name := strings.TrimPrefix(field, "-"); name != field {
+                sort[i] = bson.E{Key: name, Value: -1}
+            } else {
+                sort[i] = bson.E{Key: field, Value: 1}
+            }
+        }
+        findOpts.SetSort(sort)
+    }
+    return col.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(result)
 }
 
-// Delete removes a document from a MongoDB collection using a filter.
-func (m *MongoAdapter) Delete(collection string, filter map[string]interface{}) error {
+// ReplaceOne replaces the first document matching filter by doc, inserting doc when none matches
+// and upsert is set.
+func (m *MongoAdapter) ReplaceOne(collection string, filter map[string]interface{}, doc interface{}, upsert bool) error {
     col := m.client.Database("app_db").Collection(collection)
-    _, err := col.DeleteOne(m.ctx, filter)
+    ctx, cancel := m.deadlines.bound(m.ctx, true)
+    defer cancel()
+    _, err := col.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(upsert))
     return err
 }
 
+// FieldValues returns the value field takes in every document of the collection that has it, read
+// through a cursor so large collections aren't bound by the size of a single reply.
+func (m *MongoAdapter) FieldValues(collection, field string) ([]interface{}, error) {
+    col := m.client.Database("app_db").Collection(collection)
+    ctx, cancel := m.deadlines.bound(m.ctx, false)
+    defer cancel()
+    projection := bson.M{field: 1}
+    if field != "_id" {
+        projection["_id"] = 0
+    }
+    cursor, err := col.Find(ctx, bson.M{field: bson.M{"$exists": true}}, options.Find().SetProjection(projection))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    var values []interface{}
+    for cursor.Next(ctx) {
+        var doc bson.M
+        if err := cursor.Decode(&doc); err != nil {
+            return nil, err
+        }
+        values = append(values, doc[field])
+    }
+    return values, cursor.Err()
+}
+
+// DeleteIn removes every document of the collection whose field is one of values.
+func (m *MongoAdapter) DeleteIn(collection, field string, values []interface{}) (int64, error) {
+    col := m.client.Database("app_db").Collection(collection)
+    ctx, cancel := m.deadlines.bound(m.ctx, true)
+    defer cancel()
+    res, err := col.DeleteMany(ctx, bson.M{field: bson.M{"$in": values}})
+    if err != nil {
+        return 0, err
+    }
+    return res.DeletedCount, nil
+}
+
 // Disconnect closes the MongoDB connection.
 func (m *MongoAdapter) Disconnect() {
     _ = m.client.Disconnect(m.ctx)
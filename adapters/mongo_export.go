@@ -0,0 +1,82 @@
+package adapters
+
+import (
+    "bufio"
+    "io"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+const importBatchSize = 500
+
+// Collections lists the collections of the database.
+func (m *MongoAdapter) Collections() ([]string, error) {
+    return m.client.Database("app_db").ListCollectionNames(m.ctx, bson.D{})
+}
+
+// ExportCollection writes every document of collection to w as newline-delimited canonical
+// Extended JSON, which keeps BSON types such as ObjectIDs and dates intact, and returns how many
+// were written.
+func (m *MongoAdapter) ExportCollection(collection string, w io.Writer) (int64, error) {
+    cursor, err := m.client.Database("app_db").Collection(collection).Find(m.ctx, bson.D{})
+    if err != nil {
+        return 0, err
+    }
+    defer cursor.Close(m.ctx)
+
+    var n int64
+    for cursor.Next(m.ctx) {
+        line, err := bson.MarshalExtJSON(cursor.Current, true, false)
+        if err != nil {
+            return n, err
+        }
+        if _, err := w.Write(append(line, '\n')); err != nil {
+            return n, err
+        }
+        n++
+    }
+    return n, cursor.Err()
+}
+
+// ImportCollection inserts the documents written by ExportCollection into collection, after
+// deleting its documents when replace is set, and returns how many were inserted.
+func (m *MongoAdapter) ImportCollection(collection string, r io.Reader, replace bool) (int64, error) {
+    col := m.client.Database("app_db").Collection(collection)
+    if replace {
+        if _, err := col.DeleteMany(m.ctx, bson.D{}); err != nil {
+            return 0, err
+        }
+    }
+
+    var n int64
+    batch := make([]interface{}, 0, importBatchSize)
+    flush := func() error {
+        if len(batch) == 0 {
+            return nil
+        }
+        if _, err := col.InsertMany(m.ctx, batch); err != nil {
+            return err
+        }
+        n += int64(len(batch))
+        batch = batch[:0]
+        return nil
+    }
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // Documents are at most 16MB.
+    for scanner.Scan() {
+        var doc bson.D
+        if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+            return n, err
+        }
+        batch = append(batch, doc)
+        if len(batch) == importBatchSize {
+            if err := flush(); err != nil {
+                return n, err
+            }
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return n, err
+    }
+    return n, flush()
+}
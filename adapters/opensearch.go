@@ -0,0 +1,82 @@
+package adapters
+
+import (
+    "net/http"
+)
+
+// Search engine flavors for ESOptions.Flavor.
+const (
+    FlavorElasticsearch = "elasticsearch"
+    FlavorOpenSearch    = "opensearch"
+)
+
+// productCheckTransport makes OpenSearch responses pass the Elasticsearch client's product check,
+// which rejects clusters that don't answer with X-Elastic-Product. The REST APIs the adapter uses
+// are otherwise compatible; the differences (kNN query and vector mapping) are handled by the
+// adapter according to its flavor.
+type productCheckTransport struct {
+    next http.RoundTripper
+}
+
+func (t *productCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    res, err := t.next.RoundTrip(req)
+    if err == nil && res.Header.Get("X-Elastic-Product") == "" {
+        res.Header.Set("X-Elastic-Product", "Elasticsearch")
+    }
+    return res, err
+}
+
+// openSearchSpaces maps dense_vector similarities to OpenSearch k-NN space types.
+var openSearchSpaces = map[string]string{
+    "cosine":            "cosinesimil",
+    "l2_norm":           "l2",
+    "dot_product":       "innerproduct",
+    "max_inner_product": "innerproduct",
+}
+
+// openSearchMapping rewrites dense_vector fields as knn_vector, reporting whether any was found so
+// the index can be created with k-NN enabled.
+func openSearchMapping(mapping map[string]interface{}) (map[string]interface{}, bool) {
+    properties, _ := mapping["properties"].(map[string]interface{})
+    if properties == nil {
+        return mapping, false
+    }
+    out := make(map[string]interface{}, len(mapping))
+    for k, v := range mapping {
+        out[k] = v
+    }
+    rewritten := make(map[string]interface{}, len(properties))
+    knn := false
+    for name, field := range properties {
+        f, _ := field.(map[string]interface{})
+        if f["type"] != "dense_vector" {
+            rewritten[name] = field
+            continue
+        }
+        knn = true
+        vector := map[string]interface{}{"type": "knn_vector", "dimension": f["dims"]}
+        if similarity, ok := f["similarity"].(string); ok {
+            vector["method"] = map[string]interface{}{
+                "name":       "hnsw",
+                "engine":     "lucene",
+                "space_type": openSearchSpaces[similarity],
+            }
+        }
+        rewritten[name] = vector
+    }
+    out["properties"] = rewritten
+    return out, knn
+}
+
+// openSearchKnnQuery is the OpenSearch form of a kNN search: a knn query clause rather than the
+// top-level knn section of Elasticsearch.
+func openSearchKnnQuery(field string, vector []float32, k int, filter map[string]interface{}) map[string]interface{} {
+    params := map[string]interface{}{"vector": vector, "k": k}
+    if filter != nil {
+        params["filter"] = filter
+    }
+    return map[string]interface{}{
+        "size":  k,
+        "query": map[string]interface{}{"knn": map[string]interface{}{field: params}},
+    }
+}
@@ -3,27 +3,176 @@ package adapters
 import (
     "context"
     "encoding/json"
+    "errors"
+    "fmt"
+    "persistence-layer/utils"
+    "strings"
+    "sync"
     "time"
 
     "github.com/go-redis/redis/v8"
 )
 
+const (
+    scanBatchSize            = 500
+    defaultMaxPatternDeletes = 100000
+)
+
+// ErrDeleteCapReached is returned by DeleteByPattern when more keys match than it may delete in
+// one call; the keys deleted so far stay deleted, so calling it again continues.
+var ErrDeleteCapReached = errors.New("pattern matches more keys than the delete cap")
+
 type RedisAdapter struct {
     client *redis.Client
     ctx    context.Context
+    prefix string
+
+    maxPatternDeletes int64
+
+    scriptsMu sync.RWMutex
+    scripts   map[string]*redis.Script
+}
+
+// RedisOptions namespaces the keys of a RedisAdapter, so several apps, environments or tenants can
+// share a server, and versions them, so entries cached before a deploy that changes the shape of
+// cached structs are ignored instead of failing to unmarshal.
+type RedisOptions struct {
+    // Namespace parts such as the app, environment and tenant name, joined with ":"; empty parts
+    // are skipped.
+    Namespace []string
+    // SchemaVersion is bumped whenever cached structs change incompatibly. Entries of older
+    // versions are never read again and expire with their TTL.
+    SchemaVersion int
+    // MaxPatternDeletes caps the keys one DeleteByPattern call deletes. Defaults to 100000.
+    MaxPatternDeletes int64
+}
+
+// KeyPrefix returns the prefix of every key, e.g. "shop:prod:v3:", or "" without a namespace or
+// version.
+func (o RedisOptions) KeyPrefix() string {
+    var parts []string
+    for _, part := range o.Namespace {
+        if part != "" {
+            parts = append(parts, part)
+        }
+    }
+    if o.SchemaVersion > 0 {
+        parts = append(parts, fmt.Sprintf("v%d", o.SchemaVersion))
+    }
+    if len(parts) == 0 {
+        return ""
+    }
+    return strings.Join(parts, ":") + ":"
+}
+
+// NewRedisAdapter creates a new instance of RedisAdapter, pinging the server according to policy.
+func NewRedisAdapter(uri string, policy RetryPolicy) (*RedisAdapter, error) {
+    return NewRedisAdapterWithOptions(uri, policy, RedisOptions{})
 }
 
-// NewRedisAdapter creates a new instance of RedisAdapter.
-func NewRedisAdapter(uri string) *RedisAdapter {
+// NewRedisAdapterWithOptions creates a RedisAdapter whose keys are prefixed as opts describes. The
+// prefix is transparent to callers: methods take and return unprefixed keys.
+func NewRedisAdapterWithOptions(uri string, policy RetryPolicy, opts RedisOptions) (*RedisAdapter, error) {
     opt, err := redis.ParseURL(uri)
     if err != nil {
-        panic("Failed to parse Redis URI")
+        return nil, fmt.Errorf("failed to parse Redis URI: %w", err)
     }
 
     client := redis.NewClient(opt)
-    return &RedisAdapter{
-        client: client,
-        ctx:    context.Background(),
+    err = connectWithRetry("redis", policy, func(ctx context.Context) error {
+        return client.Ping(ctx).Err()
+    })
+    if err != nil {
+        _ = client.Close()
+        return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+    }
+    r := &RedisAdapter{
+        client:  client,
+        ctx:     context.Background(),
+        prefix:  opts.KeyPrefix(),
+        scripts: make(map[string]*redis.Script, len(builtinScripts)),
+
+        maxPatternDeletes: opts.MaxPatternDeletes,
+    }
+    if r.maxPatternDeletes <= 0 {
+        r.maxPatternDeletes = defaultMaxPatternDeletes
+    }
+    for name, source := range builtinScripts {
+        r.scripts[name] = redis.NewScript(source)
+    }
+    if err := r.LoadScripts(); err != nil {
+        _ = client.Close()
+        return nil, err
+    }
+    return r, nil
+}
+
+// SetDeadlines bounds commands by d.Read or d.Write, by whether they modify data. Blocking
+// commands such as XREADGROUP are left to the block duration they are given. Call it once, at
+// startup.
+func (r *RedisAdapter) SetDeadlines(d Deadlines) error {
+    r.client.AddHook(redisDeadlines{deadlines: d})
+    return nil
+}
+
+// redisBlocking are the commands that wait for data by design.
+var redisBlocking = map[string]bool{
+    "blpop": true, "brpop": true, "brpoplpush": true, "blmove": true, "bzpopmin": true,
+    "bzpopmax": true, "xread": true, "xreadgroup": true, "wait": true,
+}
+
+// redisReads are the read-only commands the adapter sends; every other command counts as a write.
+var redisReads = map[string]bool{
+    "get": true, "mget": true, "exists": true, "scan": true, "ttl": true, "pttl": true,
+    "zrange": true, "zrevrange": true, "zrangebyscore": true, "zrevrangebyscore": true,
+    "zrank": true, "zrevrank": true, "zcard": true, "hgetall": true, "ping": true,
+}
+
+type redisDeadlineKey struct{}
+
+// redisDeadlines is the hook bounding commands whose context has no deadline.
+type redisDeadlines struct {
+    deadlines Deadlines
+}
+
+func (h redisDeadlines) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+    if redisBlocking[cmd.Name()] {
+        return ctx, nil
+    }
+    return h.bound(ctx, !redisReads[cmd.Name()]), nil
+}
+
+func (h redisDeadlines) AfterProcess(ctx context.Context, _ redis.Cmder) error {
+    releaseRedisDeadline(ctx)
+    return nil
+}
+
+func (h redisDeadlines) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+    write := false
+    for _, cmd := range cmds {
+        if redisBlocking[cmd.Name()] {
+            return ctx, nil
+        }
+        write = write || !redisReads[cmd.Name()]
+    }
+    return h.bound(ctx, write), nil
+}
+
+func (h redisDeadlines) AfterProcessPipeline(ctx context.Context, _ []redis.Cmder) error {
+    releaseRedisDeadline(ctx)
+    return nil
+}
+
+// bound returns ctx with a deadline, carrying the function releasing it for the After hooks.
+func (h redisDeadlines) bound(ctx context.Context, write bool) context.Context {
+    bounded, cancel := h.deadlines.bound(ctx, write)
+    return context.WithValue(bounded, redisDeadlineKey{}, cancel)
+}
+
+// releaseRedisDeadline releases the deadline set by redisDeadlines.bound.
+func releaseRedisDeadline(ctx context.Context) {
+    if cancel, ok := ctx.Value(redisDeadlineKey{}).(context.CancelFunc); ok {
+        cancel()
     }
 }
 
@@ -34,15 +183,16 @@ func (r *RedisAdapter) SetWithTTL(key string, value interface{}, ttl time.Durati
         return err
     }
 
-    return r.client.Set(r.ctx, key, jsonData, ttl).Err()
+    return r.client.Set(r.ctx, r.prefix+key, jsonData, ttl).Err()
 }
 
-// Get retrieves a value from Redis and unmarshals it into the specified interface.
+// Get retrieves a value from Redis and unmarshals it into the specified interface. It returns
+// utils.ErrCacheMiss when the key does not exist.
 func (r *RedisAdapter) Get(key string, dest interface{}) error {
-    val, err := r.client.Get(r.ctx, key).Result()
+    val, err := r.client.Get(r.ctx, r.prefix+key).Result()
     if err != nil {
         if err == redis.Nil {
-            return nil // Key does not exist, return nil to indicate a cache miss
+            return utils.ErrCacheMiss
         }
         return err
     }
@@ -50,14 +200,72 @@ func (r *RedisAdapter) Get(key string, dest interface{}) error {
     return json.Unmarshal([]byte(val), dest)
 }
 
+// GetMany fetches several keys with a single MGET and returns the raw JSON of each hit. Missing keys
+// are absent from the result.
+func (r *RedisAdapter) GetMany(keys []string) (map[string][]byte, error) {
+    hits := make(map[string][]byte, len(keys))
+    if len(keys) == 0 {
+        return hits, nil
+    }
+    prefixed := make([]string, len(keys))
+    for i, key := range keys {
+        prefixed[i] = r.prefix + key
+    }
+    values, err := r.client.MGet(r.ctx, prefixed...).Result()
+    if err != nil {
+        return nil, err
+    }
+    for i, value := range values {
+        if s, ok := value.(string); ok {
+            hits[keys[i]] = []byte(s)
+        }
+    }
+    return hits, nil
+}
+
+// DeleteByPattern deletes the keys matching the glob pattern, e.g. "product:*", and returns how
+// many it deleted. It walks the keyspace with SCAN and unlinks keys in batches, so unlike KEYS it
+// never blocks the server, and stops with ErrDeleteCapReached after MaxPatternDeletes keys. Keys
+// written during the walk may be missed.
+func (r *RedisAdapter) DeleteByPattern(pattern string) (int64, error) {
+    var (
+        cursor  uint64
+        deleted int64
+    )
+    for {
+        keys, next, err := r.client.Scan(r.ctx, cursor, r.prefix+pattern, scanBatchSize).Result()
+        if err != nil {
+            return deleted, err
+        }
+        capped := false
+        if room := r.maxPatternDeletes - deleted; int64(len(keys)) > room {
+            keys, capped = keys[:room], true
+        }
+        if len(keys) > 0 {
+            n, err := r.client.Unlink(r.ctx, keys...).Result()
+            deleted += n
+            if err != nil {
+                return deleted, err
+            }
+        }
+        if capped {
+            return deleted, ErrDeleteCapReached
+        }
+        if next == 0 {
+            return deleted, nil
+        }
+        cursor = next
+    }
+}
+
 // Delete removes a key from Redis.
 func (r *RedisAdapter) Delete(key string) error {
-    return r.client.Del(r.ctx, key).Err()
+    return r.client.Del(r.ctx, r.prefix+key).Err()
 }
 
 // Exists checks if a key exists in Redis.
 func (r *RedisAdapter) Exists(key string) (bool, error) {
-    count, err := r.client.Exists(r.ctx, key).Result()
+    count, err := r.client.Exists(r.ctx, r.prefix+key).Result()
     if err != nil {
         return false, err
     }
@@ -131,7 +339,7 @@ func (r *RedisAdapter) Close() error {
 //     user.Email = updatedUser.Email
 
 //     // Update in SQL database
-//     err = s.orm.Update(user)
+//     _, err = s.orm.Update(user)
 //     if err != nil {
 //         return err
 //     }
@@ -148,7 +356,7 @@ func (r *RedisAdapter) Close() error {
 
 // func (s *UserService) DeleteUser(id uint) error {
 //     var user models.User
-//     err := s.orm.Delete(id, &user)
+//     _, err := s.orm.Delete(id, &user)
 //     if err != nil {
 //         return err
 //     }
@@ -0,0 +1,22 @@
+package adapters
+
+// IncrBy atomically adds by to the integer at key, a missing key counting as 0, and returns the new
+// value. The key keeps its TTL.
+func (r *RedisAdapter) IncrBy(key string, by int64) (int64, error) {
+    return r.client.IncrBy(r.ctx, r.prefix+key, by).Result()
+}
+
+// DecrBy atomically subtracts by from the integer at key and returns the new value.
+func (r *RedisAdapter) DecrBy(key string, by int64) (int64, error) {
+    return r.client.DecrBy(r.ctx, r.prefix+key, by).Result()
+}
+
+// RenameNX atomically renames key to newKey unless newKey exists. It reports false when newKey
+// exists or key does not.
+func (r *RedisAdapter) RenameNX(key, newKey string) (bool, error) {
+    ok, err := r.client.RenameNX(r.ctx, r.prefix+key, r.prefix+newKey).Result()
+    if err != nil && err.Error() == "ERR no such key" {
+        return false, nil
+    }
+    return ok, err
+}
@@ -0,0 +1,66 @@
+package adapters
+
+import (
+    "time"
+
+    "github.com/go-redis/redis/v8"
+)
+
+// HSet sets fields of the hash at key, leaving its other fields as they are, and, when ttl is
+// positive, resets the hash's expiry in the same transaction.
+func (r *RedisAdapter) HSet(key string, fields map[string]string, ttl time.Duration) error {
+    if len(fields) == 0 {
+        return nil
+    }
+    values := make(map[string]interface{}, len(fields))
+    for field, value := range fields {
+        values[field] = value
+    }
+    _, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+        pipe.HSet(r.ctx, r.prefix+key, values)
+        if ttl > 0 {
+            pipe.Expire(r.ctx, r.prefix+key, ttl)
+        }
+        return nil
+    })
+    return err
+}
+
+// HReplace replaces the hash at key with fields, dropping the fields it had, and, when ttl is
+// positive, sets its expiry, all in one transaction.
+func (r *RedisAdapter) HReplace(key string, fields map[string]string, ttl time.Duration) error {
+    values := make(map[string]interface{}, len(fields))
+    for field, value := range fields {
+        values[field] = value
+    }
+    _, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+        pipe.Del(r.ctx, r.prefix+key)
+        if len(values) > 0 {
+            pipe.HSet(r.ctx, r.prefix+key, values)
+            if ttl > 0 {
+                pipe.Expire(r.ctx, r.prefix+key, ttl)
+            }
+        }
+        return nil
+    })
+    return err
+}
+
+// HGetAll returns every field of the hash at key; a missing key yields an empty map.
+func (r *RedisAdapter) HGetAll(key string) (map[string]string, error) {
+    return r.client.HGetAll(r.ctx, r.prefix+key).Result()
+}
+
+// HIncrBy adds by to the integer stored in field of the hash at key and returns the new value.
+// A missing field counts as 0.
+func (r *RedisAdapter) HIncrBy(key, field string, by int64) (int64, error) {
+    return r.client.HIncrBy(r.ctx, r.prefix+key, field, by).Result()
+}
+
+// HDel removes fields from the hash at key.
+func (r *RedisAdapter) HDel(key string, fields ...string) error {
+    if len(fields) == 0 {
+        return nil
+    }
+    return r.client.HDel(r.ctx, r.prefix+key, fields...).Err()
+}
@@ -0,0 +1,54 @@
+package adapters
+
+import (
+    "context"
+    "fmt"
+    "strings"
+)
+
+// EnableExpiryNotifications turns on the expired-key events of the server's keyspace
+// notifications, keeping any event classes already enabled. Managed services that disable CONFIG
+// need the notify-keyspace-events parameter set to include "Ex" in their console instead.
+func (r *RedisAdapter) EnableExpiryNotifications() error {
+    res, err := r.client.ConfigGet(r.ctx, "notify-keyspace-events").Result()
+    if err != nil {
+        return err
+    }
+    flags := ""
+    if len(res) == 2 {
+        flags, _ = res[1].(string)
+    }
+    for _, flag := range []string{"E", "x"} {
+        if !strings.Contains(flags, flag) && !(flag == "x" && strings.Contains(flags, "A")) {
+            flags += flag
+        }
+    }
+    return r.client.ConfigSet(r.ctx, "notify-keyspace-events", flags).Err()
+}
+
+// SubscribeExpired calls handle, one at a time, with the key of every entry of the adapter's
+// namespace that expires, until the context is cancelled. The server only publishes the events
+// when keyspace notifications include "Ex"; see EnableExpiryNotifications. Events are delivered at
+// most once: those published while no subscriber is connected are lost.
+func (r *RedisAdapter) SubscribeExpired(ctx context.Context, handle func(key string)) error {
+    channel := fmt.Sprintf("__keyevent@%d__:expired", r.client.Options().DB)
+    sub := r.client.PSubscribe(ctx, channel)
+    defer sub.Close()
+    if _, err := sub.Receive(ctx); err != nil {
+        return err
+    }
+    messages := sub.Channel()
+    for {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case msg, ok := <-messages:
+            if !ok {
+                return nil
+            }
+            if key := msg.Payload; strings.HasPrefix(key, r.prefix) {
+                handle(strings.TrimPrefix(key, r.prefix))
+            }
+        }
+    }
+}
@@ -0,0 +1,35 @@
+package adapters
+
+import (
+    "fmt"
+    "persistence-layer/utils"
+    "time"
+)
+
+// RateLimit is the decision of a rate limiter for one request.
+type RateLimit struct {
+    Allowed    bool
+    Remaining  int64         // Requests left in the current window after this one.
+    RetryAfter time.Duration // When denied, how long until a request would be allowed.
+}
+
+// Allow reports whether a request under key is within limit requests per sliding window, and
+// counts it if so. The check and the update run atomically in the ScriptRateLimit script. A limit
+// or window that isn't positive fails with utils.ErrInvalidValue.
+func (r *RedisAdapter) Allow(key string, limit int64, window time.Duration) (RateLimit, error) {
+    if limit <= 0 || window <= 0 {
+        return RateLimit{}, fmt.Errorf("%w: rate limit of %d per %s", utils.ErrInvalidValue, limit, window)
+    }
+    res, err := r.RunScript(ScriptRateLimit, []string{key}, window.Milliseconds(), limit, utils.NewUUIDv7())
+    if err != nil {
+        return RateLimit{}, err
+    }
+    reply, _ := res.([]interface{})
+    if len(reply) != 3 {
+        return RateLimit{}, fmt.Errorf("unexpected reply from script %q: %v", ScriptRateLimit, res)
+    }
+    allowed, _ := reply[0].(int64)
+    remaining, _ := reply[1].(int64)
+    retry, _ := reply[2].(int64)
+    return RateLimit{Allowed: allowed == 1, Remaining: remaining, RetryAfter: time.Duration(retry) * time.Millisecond}, nil
+}
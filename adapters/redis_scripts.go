@@ -0,0 +1,227 @@
+package adapters
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+)
+
+// Names of the scripts every RedisAdapter registers.
+const (
+    ScriptRateLimit       = "rate_limit"
+    ScriptLockExtend      = "lock_extend"
+    ScriptLockRelease     = "lock_release"
+    ScriptGetOrSetVersion = "get_or_set_version"
+    ScriptReserveUsage    = "reserve_usage"
+    ScriptBumpGuard       = "bump_guard"
+    ScriptSetIfGuard      = "set_if_guard"
+)
+
+// builtinScripts back the adapter's atomic operations.
+var builtinScripts = map[string]string{
+    // KEYS[1] holds the millisecond timestamps of the requests of the last window; ARGV is the
+    // window in ms, the limit and a unique member. The server's clock keeps processes in step.
+    ScriptRateLimit: `
+local now = redis.call('TIME')
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', nowMs - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count < limit then
+    redis.call('ZADD', KEYS[1], nowMs, ARGV[3])
+    redis.call('PEXPIRE', KEYS[1], window)
+    return {1, limit - count - 1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] == nil then
+    return {0, 0, window}
+end
+return {0, 0, tonumber(oldest[2]) + window - nowMs}
+`,
+    // Extends the lock at KEYS[1] to ARGV[2] ms if it is still held with token ARGV[1].
+    ScriptLockExtend: `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`,
+    // Deletes the lock at KEYS[1] if it is still held with token ARGV[1].
+    ScriptLockRelease: `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    return redis.call('DEL', KEYS[1])
+end
+return 0
+`,
+    // Stores ARGV[2] with version ARGV[1] and a TTL of ARGV[3] ms in the hash KEYS[1] unless it
+    // holds the same or a newer version, and returns {written, stored data}.
+    ScriptGetOrSetVersion: `
+local current = redis.call('HGET', KEYS[1], 'version')
+if current and tonumber(current) >= tonumber(ARGV[1]) then
+    return {0, redis.call('HGET', KEYS[1], 'data')}
+end
+redis.call('HSET', KEYS[1], 'version', ARGV[1], 'data', ARGV[2])
+if tonumber(ARGV[3]) > 0 then
+    redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return {1, ARGV[2]}
+`,
+    // Applies the deltas ARGV[2..] (field, by, limit, member) to the counters in the hash KEYS[1]
+    // unless one would exceed its limit, keeping the sizes of members in KEYS[2], and returns
+    // {applied, refused field, its value}. ARGV[1] is a TTL in ms set when the hashes are created.
+    ScriptReserveUsage: `
+local deltas = {}
+for i = 2, #ARGV, 4 do
+    local field, by, limit, member = ARGV[i], tonumber(ARGV[i + 1]), tonumber(ARGV[i + 2]), ARGV[i + 3]
+    if member ~= '' then
+        by = by - tonumber(redis.call('HGET', KEYS[2], field .. ':' .. member) or '0')
+    end
+    local used = tonumber(redis.call('HGET', KEYS[1], field) or '0')
+    if by > 0 and limit > 0 and used + by > limit then
+        return {0, field, used}
+    end
+    deltas[#deltas + 1] = {field, by, member, tonumber(ARGV[i + 1])}
+end
+for _, d in ipairs(deltas) do
+    redis.call('HINCRBY', KEYS[1], d[1], d[2])
+    if d[3] ~= '' then
+        if d[4] == 0 then
+            redis.call('HDEL', KEYS[2], d[1] .. ':' .. d[3])
+        else
+            redis.call('HSET', KEYS[2], d[1] .. ':' .. d[3], d[4])
+        end
+    end
+end
+local ttl = tonumber(ARGV[1])
+if ttl > 0 then
+    for _, key in ipairs(KEYS) do
+        if redis.call('PTTL', key) == -1 then
+            redis.call('PEXPIRE', key, ttl)
+        end
+    end
+end
+return {1, '', 0}
+`,
+    // Increments the counter KEYS[1] and sets its TTL to ARGV[1] ms.
+    ScriptBumpGuard: `
+local n = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return n
+`,
+    // Stores ARGV[2] at KEYS[1] with a TTL of ARGV[3] ms if the counter KEYS[2], missing meaning 0,
+    // still equals ARGV[1].
+    ScriptSetIfGuard: `
+if tonumber(redis.call('GET', KEYS[2]) or '0') ~= tonumber(ARGV[1]) then
+    return 0
+end
+if tonumber(ARGV[3]) > 0 then
+    redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+    redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`,
+}
+
+// RegisterScript adds a Lua script under name, replacing any script of that name, and loads it
+// into the server's script cache. Scripts are run with RunScript.
+func (r *RedisAdapter) RegisterScript(name, source string) error {
+    script := redis.NewScript(source)
+    r.scriptsMu.Lock()
+    r.scripts[name] = script
+    r.scriptsMu.Unlock()
+    return script.Load(r.ctx, r.client).Err()
+}
+
+// LoadScripts loads every registered script into the server's script cache, e.g. after a failover
+// to a replica that has never seen them. RunScript reloads missing scripts on its own, so this only
+// saves the first call a round trip.
+func (r *RedisAdapter) LoadScripts() error {
+    r.scriptsMu.RLock()
+    defer r.scriptsMu.RUnlock()
+    for name, script := range r.scripts {
+        if err := script.Load(r.ctx, r.client).Err(); err != nil {
+            return fmt.Errorf("failed to load Redis script %q: %w", name, err)
+        }
+    }
+    return nil
+}
+
+// RunScript runs the registered script name by its SHA with EVALSHA, falling back to EVAL when
+// the server doesn't have it cached. Keys get the adapter's key prefix.
+func (r *RedisAdapter) RunScript(name string, keys []string, args ...interface{}) (interface{}, error) {
+    r.scriptsMu.RLock()
+    script, ok := r.scripts[name]
+    r.scriptsMu.RUnlock()
+    if !ok {
+        return nil, fmt.Errorf("redis script %q is not registered", name)
+    }
+    prefixed := make([]string, len(keys))
+    for i, key := range keys {
+        prefixed[i] = r.prefix + key
+    }
+    res, err := script.Run(r.ctx, r.client, prefixed, args...).Result()
+    if err == redis.Nil {
+        return nil, nil
+    }
+    return res, err
+}
+
+// TryLock acquires the lock at key for ttl unless it is held, identifying the holder by token, a
+// value unique to the caller such as a UUID.
+func (r *RedisAdapter) TryLock(key, token string, ttl time.Duration) (bool, error) {
+    return r.client.SetNX(r.ctx, r.prefix+key, token, ttl).Result()
+}
+
+// ExtendLock resets the TTL of the lock at key if token still holds it, reporting false when the
+// lock expired or was taken over.
+func (r *RedisAdapter) ExtendLock(key, token string, ttl time.Duration) (bool, error) {
+    res, err := r.RunScript(ScriptLockExtend, []string{key}, token, ttl.Milliseconds())
+    return res == int64(1), err
+}
+
+// Unlock releases the lock at key if token still holds it.
+func (r *RedisAdapter) Unlock(key, token string) (bool, error) {
+    res, err := r.RunScript(ScriptLockRelease, []string{key}, token)
+    return res == int64(1), err
+}
+
+// GetOrSetVersion caches the JSON of value at key with version unless the entry holds the same or
+// a newer version, so a slow writer can't replace fresh data with stale data. It reports whether
+// value was written and returns the JSON now cached.
+func (r *RedisAdapter) GetOrSetVersion(key string, version int64, value interface{}, ttl time.Duration) ([]byte, bool, error) {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return nil, false, err
+    }
+    res, err := r.RunScript(ScriptGetOrSetVersion, []string{key}, version, data, ttl.Milliseconds())
+    if err != nil {
+        return nil, false, err
+    }
+    reply, _ := res.([]interface{})
+    if len(reply) != 2 {
+        return nil, false, fmt.Errorf("unexpected reply from script %q: %v", ScriptGetOrSetVersion, res)
+    }
+    stored, _ := reply[1].(string)
+    return []byte(stored), reply[0] == int64(1), nil
+}
+
+// BumpGuard increments the guard counter at key, giving it a TTL of ttl, so that SetIfGuard calls
+// expecting its previous value are refused.
+func (r *RedisAdapter) BumpGuard(key string, ttl time.Duration) error {
+    _, err := r.RunScript(ScriptBumpGuard, []string{key}, ttl.Milliseconds())
+    return err
+}
+
+// SetIfGuard caches the JSON of value at key like SetWithTTL if the guard counter at guard, 0 when
+// missing, still equals expected, and reports whether it did.
+func (r *RedisAdapter) SetIfGuard(key string, value interface{}, ttl time.Duration, guard string, expected int64) (bool, error) {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return false, err
+    }
+    res, err := r.RunScript(ScriptSetIfGuard, []string{key, guard}, expected, data, ttl.Milliseconds())
+    return res == int64(1), err
+}
@@ -0,0 +1,133 @@
+package adapters
+
+import (
+    "math"
+    "strconv"
+
+    "github.com/go-redis/redis/v8"
+)
+
+// ScoredMember is a member of a sorted set with its score.
+type ScoredMember struct {
+    Member string  `json:"member"`
+    Score  float64 `json:"score"`
+}
+
+// ZAdd adds members to the sorted set at key, replacing the scores of existing ones.
+func (r *RedisAdapter) ZAdd(key string, members ...ScoredMember) error {
+    if len(members) == 0 {
+        return nil
+    }
+    zs := make([]*redis.Z, len(members))
+    for i, m := range members {
+        zs[i] = &redis.Z{Score: m.Score, Member: m.Member}
+    }
+    return r.client.ZAdd(r.ctx, r.prefix+key, zs...).Err()
+}
+
+// ZIncrBy adds by to the score of member, adding it with score by if absent, and returns the new
+// score.
+func (r *RedisAdapter) ZIncrBy(key, member string, by float64) (float64, error) {
+    return r.client.ZIncrBy(r.ctx, r.prefix+key, by, member).Result()
+}
+
+// ZRange returns the members ranked start to stop inclusive, by ascending score or by descending
+// score when desc is set. Negative ranks count from the end, so 0, -1 returns the whole set.
+func (r *RedisAdapter) ZRange(key string, start, stop int64, desc bool) ([]ScoredMember, error) {
+    var zs []redis.Z
+    var err error
+    if desc {
+        zs, err = r.client.ZRevRangeWithScores(r.ctx, r.prefix+key, start, stop).Result()
+    } else {
+        zs, err = r.client.ZRangeWithScores(r.ctx, r.prefix+key, start, stop).Result()
+    }
+    if err != nil {
+        return nil, err
+    }
+    return scoredMembers(zs), nil
+}
+
+// ZRangeByScore returns up to limit members scored between min and max inclusive, ordered as by
+// ZRange. A limit of 0 returns them all; math.Inf bounds are open-ended.
+func (r *RedisAdapter) ZRangeByScore(key string, min, max float64, desc bool, limit int64) ([]ScoredMember, error) {
+    opt := &redis.ZRangeBy{Min: scoreBound(min), Max: scoreBound(max), Count: limit}
+    var zs []redis.Z
+    var err error
+    if desc {
+        zs, err = r.client.ZRevRangeByScoreWithScores(r.ctx, r.prefix+key, opt).Result()
+    } else {
+        zs, err = r.client.ZRangeByScoreWithScores(r.ctx, r.prefix+key, opt).Result()
+    }
+    if err != nil {
+        return nil, err
+    }
+    return scoredMembers(zs), nil
+}
+
+// ZRank returns the 0-based rank of member by ascending score, or by descending score when desc is
+// set. The boolean is false when member is not in the set.
+func (r *RedisAdapter) ZRank(key, member string, desc bool) (int64, bool, error) {
+    var rank int64
+    var err error
+    if desc {
+        rank, err = r.client.ZRevRank(r.ctx, r.prefix+key, member).Result()
+    } else {
+        rank, err = r.client.ZRank(r.ctx, r.prefix+key, member).Result()
+    }
+    if err == redis.Nil {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return rank, true, nil
+}
+
+// ZRem removes members from the sorted set at key.
+func (r *RedisAdapter) ZRem(key string, members ...string) error {
+    if len(members) == 0 {
+        return nil
+    }
+    values := make([]interface{}, len(members))
+    for i, m := range members {
+        values[i] = m
+    }
+    return r.client.ZRem(r.ctx, r.prefix+key, values...).Err()
+}
+
+// ZRemRangeByRank removes the members ranked start to stop by ascending score and returns how
+// many were removed. ZRemRangeByRank(key, 0, -(n+1)) keeps only the n highest scored members.
+func (r *RedisAdapter) ZRemRangeByRank(key string, start, stop int64) (int64, error) {
+    return r.client.ZRemRangeByRank(r.ctx, r.prefix+key, start, stop).Result()
+}
+
+// ZRemRangeByScore removes the members scored between min and max inclusive and returns how many
+// were removed.
+func (r *RedisAdapter) ZRemRangeByScore(key string, min, max float64) (int64, error) {
+    return r.client.ZRemRangeByScore(r.ctx, r.prefix+key, scoreBound(min), scoreBound(max)).Result()
+}
+
+// ZCard returns the number of members of the sorted set at key.
+func (r *RedisAdapter) ZCard(key string) (int64, error) {
+    return r.client.ZCard(r.ctx, r.prefix+key).Result()
+}
+
+func scoredMembers(zs []redis.Z) []ScoredMember {
+    out := make([]ScoredMember, len(zs))
+    for i, z := range zs {
+        member, _ := z.Member.(string)
+        out[i] = ScoredMember{Member: member, Score: z.Score}
+    }
+    return out
+}
+
+// scoreBound formats a score for a range command, mapping infinities to "-inf" and "+inf".
+func scoreBound(score float64) string {
+    switch {
+    case math.IsInf(score, -1):
+        return "-inf"
+    case math.IsInf(score, 1):
+        return "+inf"
+    }
+    return strconv.FormatFloat(score, 'f', -1, 64)
+}
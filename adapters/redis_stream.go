@@ -0,0 +1,118 @@
+package adapters
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/go-redis/redis/v8"
+)
+
+// StreamMessage is an entry of a Redis stream. Deliveries counts how often it has been handed to a
+// consumer of the group, including the current delivery; it is only set on claimed entries.
+type StreamMessage struct {
+    ID         string
+    Values     map[string]string
+    Deliveries int64
+}
+
+// XAdd appends an entry to stream, creating it, and returns the entry's ID. With maxLen positive
+// the stream is trimmed to roughly that many entries.
+func (r *RedisAdapter) XAdd(stream string, values map[string]string, maxLen int64) (string, error) {
+    fields := make(map[string]interface{}, len(values))
+    for k, v := range values {
+        fields[k] = v
+    }
+    args := &redis.XAddArgs{Stream: r.prefix + stream, Values: fields}
+    if maxLen > 0 {
+        args.MaxLen, args.Approx = maxLen, true
+    }
+    return r.client.XAdd(r.ctx, args).Result()
+}
+
+// XGroupCreate creates a consumer group reading stream from its first entry, creating the stream
+// if needed. An existing group is left as it is.
+func (r *RedisAdapter) XGroupCreate(stream, group string) error {
+    err := r.client.XGroupCreateMkStream(r.ctx, r.prefix+stream, group, "0").Err()
+    if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+        return nil
+    }
+    return err
+}
+
+// XReadGroup delivers up to count entries of stream not yet delivered to group to consumer,
+// waiting up to block for one to arrive. The entries stay pending until acknowledged with XAck.
+func (r *RedisAdapter) XReadGroup(stream, group, consumer string, count int64, block time.Duration) ([]StreamMessage, error) {
+    streams, err := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
+        Group:    group,
+        Consumer: consumer,
+        Streams:  []string{r.prefix + stream, ">"},
+        Count:    count,
+        Block:    block,
+    }).Result()
+    if err == redis.Nil {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var out []StreamMessage
+    for _, s := range streams {
+        out = append(out, streamMessages(s.Messages, nil)...)
+    }
+    return out, nil
+}
+
+// XClaimStale transfers to consumer up to count entries pending in group for at least minIdle,
+// i.e. delivered to a consumer that neither acknowledged them nor, presumably, is still alive.
+func (r *RedisAdapter) XClaimStale(stream, group, consumer string, minIdle time.Duration, count int64) ([]StreamMessage, error) {
+    pending, err := r.client.XPendingExt(r.ctx, &redis.XPendingExtArgs{
+        Stream: r.prefix + stream,
+        Group:  group,
+        Idle:   minIdle,
+        Start:  "-",
+        End:    "+",
+        Count:  count,
+    }).Result()
+    if err != nil || len(pending) == 0 {
+        return nil, err
+    }
+    ids := make([]string, len(pending))
+    deliveries := make(map[string]int64, len(pending))
+    for i, p := range pending {
+        ids[i] = p.ID
+        deliveries[p.ID] = p.RetryCount + 1
+    }
+    // MinIdle makes Redis skip entries another consumer claimed since XPENDING.
+    messages, err := r.client.XClaim(r.ctx, &redis.XClaimArgs{
+        Stream:   r.prefix + stream,
+        Group:    group,
+        Consumer: consumer,
+        MinIdle:  minIdle,
+        Messages: ids,
+    }).Result()
+    if err != nil {
+        return nil, err
+    }
+    return streamMessages(messages, deliveries), nil
+}
+
+// XAck acknowledges entries of stream processed by group, removing them from its pending list.
+func (r *RedisAdapter) XAck(stream, group string, ids ...string) error {
+    if len(ids) == 0 {
+        return nil
+    }
+    return r.client.XAck(r.ctx, r.prefix+stream, group, ids...).Err()
+}
+
+func streamMessages(messages []redis.XMessage, deliveries map[string]int64) []StreamMessage {
+    out := make([]StreamMessage, len(messages))
+    for i, m := range messages {
+        values := make(map[string]string, len(m.Values))
+        for k, v := range m.Values {
+            values[k] = fmt.Sprint(v)
+        }
+        out[i] = StreamMessage{ID: m.ID, Values: values, Deliveries: deliveries[m.ID]}
+    }
+    return out
+}
@@ -0,0 +1,47 @@
+package adapters
+
+import (
+    "fmt"
+    "time"
+)
+
+// UsageDelta changes one counter of a usage hash by By unless that takes it over Limit. With
+// Member set, By is instead the new size of Member, e.g. a cache key, and the counter changes by
+// the difference from Member's previous size.
+type UsageDelta struct {
+    Field  string
+    By     int64
+    Limit  int64 // 0 is unlimited. Decreases are never refused.
+    Member string
+}
+
+// UsageReservation is the outcome of ReserveUsage.
+type UsageReservation struct {
+    Applied bool
+    Field   string // The counter that would have exceeded its limit when not applied,
+    Used    int64  // and its value.
+}
+
+// ReserveUsage applies deltas to the counters of the hash at key if none of them would exceed its
+// limit, all or none of them, atomically in the ScriptReserveUsage script, so concurrent callers
+// in any process can't overshoot a limit together. The sizes of members are kept in the hash at
+// key+":sizes". A positive ttl expires both hashes that long after they are created, for counters
+// of a time window.
+func (r *RedisAdapter) ReserveUsage(key string, ttl time.Duration, deltas ...UsageDelta) (UsageReservation, error) {
+    args := []interface{}{ttl.Milliseconds()}
+    for _, d := range deltas {
+        args = append(args, d.Field, d.By, d.Limit, d.Member)
+    }
+    res, err := r.RunScript(ScriptReserveUsage, []string{key, key + ":sizes"}, args...)
+    if err != nil {
+        return UsageReservation{}, err
+    }
+    reply, _ := res.([]interface{})
+    if len(reply) != 3 {
+        return UsageReservation{}, fmt.Errorf("unexpected reply from script %q: %v", ScriptReserveUsage, res)
+    }
+    applied, _ := reply[0].(int64)
+    field, _ := reply[1].(string)
+    used, _ := reply[2].(int64)
+    return UsageReservation{Applied: applied == 1, Field: field, Used: used}, nil
+}
@@ -1,10 +1,18 @@
 package adapters
 
 import (
+    "context"
+    "fmt"
+    gomysql "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "gorm.io/gorm/logger"
+    "persistence-layer/utils"
+    "reflect"
     "strings"
+    "time"
 )
 
 type SQLAdapter struct {
@@ -12,32 +20,107 @@ type SQLAdapter struct {
 }
 
 // NewSQLAdapter initializes a new SQLAdapter with a given DSN and optionally a database type.
-// dbType can be "postgres" or "mysql", with "postgres" as the default.
-func NewSQLAdapter(dsn string, dbType string) *SQLAdapter {
+// dbType can be "postgres" or "mysql", with "postgres" as the default. The connection is retried
+// according to policy, each attempt bounded by its AttemptTimeout, and an error is returned once
+// the startup budget is exhausted.
+func NewSQLAdapter(dsn string, dbType string, policy RetryPolicy) (*SQLAdapter, error) {
     var db *gorm.DB
-    var err error
+    err := connectWithRetry("sql", policy, func(ctx context.Context) error {
+        // Open the pool without touching the server, then ping it within the attempt's deadline;
+        // the pool of a failed attempt is closed rather than left to leak.
+        opened, err := gorm.Open(sqlDialector(dsn, dbType, true), &gorm.Config{DisableAutomaticPing: true, Logger: redactingLogger{logger.Default}})
+        if err != nil {
+            return err
+        }
+        pool, err := opened.DB()
+        if err != nil {
+            return err
+        }
+        if err := pool.PingContext(ctx); err != nil {
+            pool.Close()
+            return err
+        }
+        if strings.EqualFold(dbType, "mysql") {
+            // The server answers, so the dialector can read its version over the same pool.
+            opened, err = gorm.Open(mysql.New(mysql.Config{Conn: pool}), &gorm.Config{DisableAutomaticPing: true, Logger: redactingLogger{logger.Default}})
+            if err != nil {
+                pool.Close()
+                return err
+            }
+        }
+        db = opened
+        return nil
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to connect to SQL database: %w", err)
+    }
+
+    if db == nil {
+        // Lazy startup: open the pool without touching the server; it connects on first use.
+        db, err = gorm.Open(sqlDialector(dsn, dbType, true), &gorm.Config{DisableAutomaticPing: true, Logger: redactingLogger{logger.Default}})
+        if err != nil {
+            return nil, fmt.Errorf("failed to open SQL database: %w", err)
+        }
+    }
+
+    return &SQLAdapter{db: db}, nil
+}
+
+// redactingLogger is gorm's default logger with the parameters and errors of the statements it logs,
+// those that fail or run slow, redacted under the utils.ParamLogMode like the ORM's own logs.
+type redactingLogger struct {
+    logger.Interface
+}
 
-    // Choose the driver based on dbType
+func (l redactingLogger) LogMode(level logger.LogLevel) logger.Interface {
+    return redactingLogger{l.Interface.LogMode(level)}
+}
+
+// ParamsFilter implements gorm.ParamsFilter, which gorm applies to the values it interpolates
+// into logged statements.
+func (l redactingLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+    return sql, utils.RedactParams(params)
+}
+
+func (l redactingLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+    l.Interface.Trace(ctx, begin, fc, utils.RedactError(err))
+}
+
+// sqlDialector chooses the driver based on dbType. lazy skips the MySQL server version query that
+// would otherwise require a live connection.
+func sqlDialector(dsn string, dbType string, lazy bool) gorm.Dialector {
     switch strings.ToLower(dbType) {
     case "mysql":
-        db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+        return mysql.New(mysql.Config{DSN: foundRowsDSN(dsn), SkipInitializeWithVersion: lazy})
     case "postgres":
         fallthrough // Use postgres as the default
     default:
-        db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+        return postgres.Open(dsn)
     }
+}
 
+// foundRowsDSN makes MySQL count the rows an UPDATE matched rather than those it changed, like
+// Postgres does, so saving a record unchanged isn't mistaken for saving a missing one. DSNs that
+// don't parse are left for the driver to reject.
+func foundRowsDSN(dsn string) string {
+    cfg, err := gomysql.ParseDSN(dsn)
     if err != nil {
-        panic("Failed to connect to SQL database: " + err.Error())
+        return dsn
     }
-
-    return &SQLAdapter{db: db}
+    cfg.ClientFoundRows = true
+    return cfg.FormatDSN()
 }
+
 // Create inserts a new record into the database.
 func (g *SQLAdapter) GetDB() *gorm.DB {
     return g.db
 }
 
+// WithContext returns an adapter whose statements run with the given context.
+func (g *SQLAdapter) WithContext(ctx context.Context) SQLStore {
+    return &SQLAdapter{db: g.db.WithContext(ctx)}
+}
+
 // Create inserts a new record into the database.
 func (g *SQLAdapter) Create(model interface{}) error {
     return g.db.Create(model).Error
@@ -48,18 +131,124 @@ func (g *SQLAdapter) Read(id uint, model interface{}) error {
     return g.db.First(model, "id = ?", id).Error
 }
 
-// Update modifies an existing record in the database.
-func (g *SQLAdapter) Update(model interface{}) error {
-    return g.db.Save(model).Error
+// ReadByKey retrieves a record by a primary key of any type, such as a UUID string.
+func (g *SQLAdapter) ReadByKey(key interface{}, model interface{}) error {
+    return g.db.First(model, "id = ?", key).Error
+}
+
+// ReadMany retrieves the records with the given keys into dest, a pointer to a slice of models, in one
+// query. Missing keys are skipped and the order of dest is unspecified.
+func (g *SQLAdapter) ReadMany(keys []interface{}, dest interface{}) error {
+    return g.db.Find(dest, "id IN ?", keys).Error
+}
+
+// ReadManyWhere is ReadMany restricted to the records matching conditions, column -> value.
+func (g *SQLAdapter) ReadManyWhere(keys []interface{}, conditions map[string]interface{}, dest interface{}) error {
+    return g.db.Where(conditions).Find(dest, "id IN ?", keys).Error
 }
 
-// Delete removes a record by ID from the database.
-func (g *SQLAdapter) Delete(id uint, model interface{}) error {
-    return g.db.Delete(model, "id = ?", id).Error
+// ReadWith retrieves a record by key together with the named associations, e.g. "Comments" or
+// "Comments.Author". Each association is loaded with one additional query.
+func (g *SQLAdapter) ReadWith(key interface{}, model interface{}, preloads ...string) error {
+    return g.preload(preloads).First(model, "id = ?", key).Error
+}
+
+// Preload loads the named associations into records already read into dest, a pointer to a model or
+// to a slice of models, such as the result of RawQuery. The records are re-read by primary key
+// together with their associations; the order of a slice is kept.
+func (g *SQLAdapter) Preload(dest interface{}, preloads ...string) error {
+    if len(preloads) == 0 {
+        return nil
+    }
+    stmt := &gorm.Statement{DB: g.db}
+    if err := stmt.Parse(dest); err != nil {
+        return err
+    }
+    pk := stmt.Schema.PrioritizedPrimaryField
+    if pk == nil {
+        return fmt.Errorf("model %s has no primary key", stmt.Schema.Name)
+    }
+    ctx := g.db.Statement.Context
+
+    rv := reflect.Indirect(reflect.ValueOf(dest))
+    if rv.Kind() != reflect.Slice {
+        key, _ := pk.ValueOf(ctx, rv)
+        return g.preload(preloads).First(dest, pk.DBName+" = ?", key).Error
+    }
+    if rv.Len() == 0 {
+        return nil
+    }
+
+    keys := make([]interface{}, rv.Len())
+    for i := range keys {
+        keys[i], _ = pk.ValueOf(ctx, reflect.Indirect(rv.Index(i)))
+    }
+    reloaded := reflect.New(rv.Type())
+    if err := g.preload(preloads).Where(pk.DBName+" IN ?", keys).Find(reloaded.Interface()).Error; err != nil {
+        return err
+    }
+    byKey := make(map[string]reflect.Value, reloaded.Elem().Len())
+    for i := 0; i < reloaded.Elem().Len(); i++ {
+        record := reloaded.Elem().Index(i)
+        key, _ := pk.ValueOf(ctx, reflect.Indirect(record))
+        byKey[fmt.Sprint(key)] = record
+    }
+    for i, key := range keys {
+        if record, ok := byKey[fmt.Sprint(key)]; ok {
+            rv.Index(i).Set(record)
+        }
+    }
+    return nil
+}
+
+func (g *SQLAdapter) preload(preloads []string) *gorm.DB {
+    db := g.db
+    for _, association := range preloads {
+        db = db.Preload(association)
+    }
+    return db
+}
+
+// ReadForUpdate retrieves a record by ID and locks its row until the transaction ends
+// (SELECT ... FOR UPDATE). It only holds the lock when called on a transactional adapter.
+func (g *SQLAdapter) ReadForUpdate(id uint, model interface{}) error {
+    return g.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(model, "id = ?", id).Error
+}
+
+// ClaimBatch locks up to limit rows matching query into dest, skipping rows already locked by
+// other transactions (SELECT ... FOR UPDATE SKIP LOCKED), so concurrent workers claim disjoint rows.
+// Rows are claimed in ID order.
+func (g *SQLAdapter) ClaimBatch(dest interface{}, limit int, query string, args ...interface{}) error {
+    return g.db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+        Where(query, args...).
+        Order("id").
+        Limit(limit).
+        Find(dest).Error
+}
+
+// Update modifies an existing record in the database and returns the number of rows written: 0
+// when no row has its ID. Unlike a bare Save it doesn't insert a record with an unknown ID; a record
+// without an ID is still inserted.
+func (g *SQLAdapter) Update(model interface{}) (int64, error) {
+    result := g.db.Select("*").Save(model)
+    return result.RowsAffected, result.Error
+}
+
+// Delete removes a record by ID from the database and returns the number of rows deleted.
+func (g *SQLAdapter) Delete(id uint, model interface{}) (int64, error) {
+    result := g.db.Delete(model, "id = ?", id)
+    return result.RowsAffected, result.Error
+}
+
+// DeleteByKey removes a record by a primary key of any type, such as a UUID string, and returns the
+// number of rows deleted.
+func (g *SQLAdapter) DeleteByKey(key interface{}, model interface{}) (int64, error) {
+    result := g.db.Delete(model, "id = ?", key)
+    return result.RowsAffected, result.Error
 }
 
 // BeginTransaction starts a new transaction and returns a new SQLAdapter instance with the transactional DB.
-func (g *SQLAdapter) BeginTransaction() (*SQLAdapter, error) {
+func (g *SQLAdapter) BeginTransaction() (SQLStore, error) {
     tx := g.db.Begin()
     if tx.Error != nil {
         return nil, tx.Error
@@ -77,11 +266,28 @@ func (g *SQLAdapter) Rollback() error {
     return g.db.Rollback().Error
 }
 
+// SavePoint marks a savepoint within the transaction.
+func (g *SQLAdapter) SavePoint(name string) error {
+    return g.db.SavePoint(name).Error
+}
+
+// RollbackTo undoes the work done in the transaction since the named savepoint.
+func (g *SQLAdapter) RollbackTo(name string) error {
+    return g.db.RollbackTo(name).Error
+}
+
 // RawQuery executes a raw SQL query and scans the result into the provided destination.
 func (g *SQLAdapter) RawQuery(query string, params []interface{}, dest interface{}) error {
     return g.db.Raw(query, params...).Scan(dest).Error
 }
 
+// Exec runs a raw SQL statement that returns no rows, such as an UPDATE or DELETE, and returns the
+// number of rows it affected.
+func (g *SQLAdapter) Exec(query string, params []interface{}) (int64, error) {
+    result := g.db.Exec(query, params...)
+    return result.RowsAffected, result.Error
+}
+
 // Close terminates the database connection.
 func (g *SQLAdapter) Close() error {
     db, err := g.db.DB()
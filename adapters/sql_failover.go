@@ -0,0 +1,476 @@
+package adapters
+
+import (
+    "context"
+    "fmt"
+    "persistence-layer/utils"
+    "sync"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Roles of the database a FailoverSQLAdapter is using.
+const (
+    FailoverPrimary = "primary"
+    FailoverStandby = "standby"
+)
+
+// FailoverOptions tunes the health checks of a FailoverSQLAdapter.
+type FailoverOptions struct {
+    CheckInterval time.Duration // Between health checks; defaults to 5 seconds.
+    CheckTimeout  time.Duration // Per ping; defaults to 2 seconds.
+    FailAfter     int           // Failed checks of the primary before failing over; defaults to 3.
+    RecoverAfter  int           // Passed checks of the primary before failing back; defaults to 3.
+    // StandbyWritable lets writes through to the standby, e.g. a replica promoted by the database's
+    // own tooling. Otherwise the adapter is read-only while failed over and writes fail with
+    // utils.ErrReadOnly instead of reaching the replica. The writes the standby took are not on the
+    // primary, so failing back is then left to the operator (see Failback), once they have been
+    // carried over.
+    StandbyWritable bool
+    // DrainTimeout bounds how long the pool of a replaced primary stays open for the queries and
+    // transactions still using it; defaults to 30 seconds.
+    DrainTimeout time.Duration
+}
+
+// FailoverStatus describes the state of a FailoverSQLAdapter, e.g. for metrics.
+type FailoverStatus struct {
+    Active          string    `json:"active"`
+    ReadOnly        bool      `json:"read_only"`
+    PrimaryHealthy  bool      `json:"primary_healthy"`
+    Since           time.Time `json:"since"` // When Active last changed.
+    Failovers       int64     `json:"failovers"`
+    Failbacks       int64     `json:"failbacks"`
+    // FailbackPending is set while the primary has recovered but the standby took writes, so the
+    // adapter waits for Failback.
+    FailbackPending bool      `json:"failback_pending"`
+}
+
+// failoverState is shared by a FailoverSQLAdapter and the copies returned by WithContext.
+type failoverState struct {
+    dsn    string
+    dbType string
+    opts   FailoverOptions
+
+    mu        sync.RWMutex
+    primary   *SQLAdapter
+    standby   *SQLAdapter
+    status    FailoverStatus
+    failures  int            // Consecutive failed checks of the primary.
+    successes int            // Consecutive passed checks of the primary while failed over.
+    timeouts  *time.Duration // Default statement timeout, once EnableStatementTimeouts was called.
+    deadlines *Deadlines     // Default deadlines, once SetDeadlines was called.
+
+    stop     chan struct{}
+    stopOnce sync.Once
+}
+
+// FailoverSQLAdapter is a SQLStore over a primary and a standby database. It pings the primary
+// every CheckInterval and switches to the standby after FailAfter failed checks, if the standby
+// answers, then back to the primary after RecoverAfter passed checks, reconnecting to it with a
+// fresh connection pool; a writable standby is only left through Failback. Transactions stay on
+// the database they began on. Every switch is logged and counted in Status.
+type FailoverSQLAdapter struct {
+    state *failoverState
+    ctx   context.Context
+}
+
+// NewFailoverSQLAdapter connects to the primary and standby databases, both with dbType as in
+// NewSQLAdapter, and starts the health checks. Neither database has to be reachable at startup; the
+// adapter starts on whichever answers the first check, preferring the primary.
+func NewFailoverSQLAdapter(primaryDSN, standbyDSN, dbType string, policy RetryPolicy, opts FailoverOptions) (*FailoverSQLAdapter, error) {
+    if opts.CheckInterval <= 0 {
+        opts.CheckInterval = 5 * time.Second
+    }
+    if opts.CheckTimeout <= 0 {
+        opts.CheckTimeout = 2 * time.Second
+    }
+    if opts.FailAfter <= 0 {
+        opts.FailAfter = 3
+    }
+    if opts.RecoverAfter <= 0 {
+        opts.RecoverAfter = 3
+    }
+    if opts.DrainTimeout <= 0 {
+        opts.DrainTimeout = 30 * time.Second
+    }
+    policy.Lazy = true
+    primary, err := NewSQLAdapter(primaryDSN, dbType, policy)
+    if err != nil {
+        return nil, err
+    }
+    standby, err := NewSQLAdapter(standbyDSN, dbType, policy)
+    if err != nil {
+        _ = primary.Close()
+        return nil, fmt.Errorf("standby: %w", err)
+    }
+
+    s := &failoverState{
+        dsn:     primaryDSN,
+        dbType:  dbType,
+        opts:    opts,
+        primary: primary,
+        standby: standby,
+        status:  FailoverStatus{Active: FailoverPrimary, PrimaryHealthy: true, Since: time.Now()},
+        stop:    make(chan struct{}),
+    }
+    if !s.ping(primary) && s.ping(standby) {
+        s.failures = opts.FailAfter
+        s.switchTo(FailoverStandby, "primary unreachable at startup")
+    }
+    go s.run()
+    return &FailoverSQLAdapter{state: s}, nil
+}
+
+// EnableStatementTimeouts enables statement timeouts, as SQLAdapter.EnableStatementTimeouts does, on
+// both databases and on the primary's reconnections.
+func (f *FailoverSQLAdapter) EnableStatementTimeouts(def time.Duration) error {
+    s := f.state
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if err := s.primary.EnableStatementTimeouts(def); err != nil {
+        return err
+    }
+    if err := s.standby.EnableStatementTimeouts(def); err != nil {
+        return err
+    }
+    s.timeouts = &def
+    return nil
+}
+
+// SetDeadlines sets default deadlines, as SQLAdapter.SetDeadlines does, on both databases and on
+// the primary's reconnections.
+func (f *FailoverSQLAdapter) SetDeadlines(d Deadlines) error {
+    s := f.state
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if err := s.primary.SetDeadlines(d); err != nil {
+        return err
+    }
+    if err := s.standby.SetDeadlines(d); err != nil {
+        return err
+    }
+    s.deadlines = &d
+    return nil
+}
+
+// Status returns the current state of the adapter.
+func (f *FailoverSQLAdapter) Status() FailoverStatus {
+    f.state.mu.RLock()
+    defer f.state.mu.RUnlock()
+    return f.state.status
+}
+
+func (s *failoverState) run() {
+    ticker := time.NewTicker(s.opts.CheckInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-s.stop:
+            return
+        case <-ticker.C:
+            s.check()
+        }
+    }
+}
+
+// check pings the primary and fails over or back when a threshold is reached.
+func (s *failoverState) check() {
+    s.mu.RLock()
+    primary, active := s.primary, s.status.Active
+    s.mu.RUnlock()
+
+    healthy := s.ping(primary)
+    s.mu.Lock()
+    s.status.PrimaryHealthy = healthy
+    if healthy {
+        s.failures = 0
+        s.successes++
+    } else {
+        s.failures++
+        s.successes = 0
+    }
+    failures, successes := s.failures, s.successes
+    s.mu.Unlock()
+
+    switch {
+    case active == FailoverPrimary && failures >= s.opts.FailAfter:
+        if !s.ping(s.standby) {
+            utils.LogError(fmt.Errorf("primary and standby databases unreachable"), map[string]interface{}{"operation": "SQL Failover", "failures": failures})
+            return
+        }
+        s.switchTo(FailoverStandby, "primary failed health checks")
+    case active == FailoverStandby && successes >= s.opts.RecoverAfter && s.opts.StandbyWritable:
+        s.mu.Lock()
+        pending := s.status.FailbackPending
+        s.status.FailbackPending = true
+        s.mu.Unlock()
+        if !pending {
+            utils.LogWarn("Primary database recovered; the standby took writes, so fail back manually once they are on the primary", map[string]interface{}{"operation": "SQL Failback"})
+        }
+    case active == FailoverStandby && successes >= s.opts.RecoverAfter:
+        if err := s.failback(); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SQL Failback"})
+        }
+    }
+}
+
+// Failback switches back to the primary database, for a writable standby once the writes it took
+// have been carried over to the primary. It does nothing while the primary is in use.
+func (f *FailoverSQLAdapter) Failback() error {
+    if f.Status().Active == FailoverPrimary {
+        return nil
+    }
+    return f.state.failback()
+}
+
+// failback reconnects to the primary with a fresh pool, dropping connections that may have been
+// left broken by the outage, and switches back to it.
+func (s *failoverState) failback() error {
+    fresh, err := NewSQLAdapter(s.dsn, s.dbType, RetryPolicy{MaxWait: s.opts.CheckTimeout, AttemptTimeout: s.opts.CheckTimeout, Lazy: true})
+    if err == nil && !s.ping(fresh) {
+        _ = fresh.Close()
+        err = fmt.Errorf("primary database unreachable")
+    }
+    if err != nil {
+        return err
+    }
+    s.mu.Lock()
+    if s.timeouts != nil {
+        if err := fresh.EnableStatementTimeouts(*s.timeouts); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SQL Failback"})
+        }
+    }
+    if s.deadlines != nil {
+        if err := fresh.SetDeadlines(*s.deadlines); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SQL Failback"})
+        }
+    }
+    old := s.primary
+    s.primary = fresh
+    s.mu.Unlock()
+    s.switchTo(FailoverPrimary, "primary recovered")
+    go s.drain(old)
+    return nil
+}
+
+// drain closes the pool of a replaced primary once none of its connections is in use, so the
+// queries and transactions that began on it finish first, or after DrainTimeout.
+func (s *failoverState) drain(old *SQLAdapter) {
+    if db, err := old.GetDB().DB(); err == nil {
+        deadline := time.Now().Add(s.opts.DrainTimeout)
+        for db.Stats().InUse > 0 && time.Now().Before(deadline) {
+            time.Sleep(100 * time.Millisecond)
+        }
+    }
+    _ = old.Close()
+}
+
+func (s *failoverState) switchTo(role, reason string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.status.Active == role {
+        return
+    }
+    s.status.Active = role
+    s.status.Since = time.Now()
+    s.status.ReadOnly = role == FailoverStandby && !s.opts.StandbyWritable
+    s.status.FailbackPending = false
+    s.successes = 0
+    fields := map[string]interface{}{"operation": "SQL Failover", "active": role, "reason": reason, "read_only": s.status.ReadOnly}
+    if role == FailoverStandby {
+        s.status.Failovers++
+        utils.LogError(fmt.Errorf("failed over to the standby database: %s", reason), fields)
+    } else {
+        s.status.Failbacks++
+        utils.LogInfo("Failed back to the primary database", fields)
+    }
+}
+
+func (s *failoverState) ping(adapter *SQLAdapter) bool {
+    db, err := adapter.GetDB().DB()
+    if err != nil {
+        return false
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), s.opts.CheckTimeout)
+    defer cancel()
+    return db.PingContext(ctx) == nil
+}
+
+// current returns the adapter in use, bound to the adapter's context.
+func (f *FailoverSQLAdapter) current() SQLStore {
+    f.state.mu.RLock()
+    adapter := f.state.primary
+    if f.state.status.Active == FailoverStandby {
+        adapter = f.state.standby
+    }
+    f.state.mu.RUnlock()
+    if f.ctx != nil {
+        return adapter.WithContext(f.ctx)
+    }
+    return adapter
+}
+
+// writable returns the adapter in use, or utils.ErrReadOnly while failed over to a read-only
+// standby.
+func (f *FailoverSQLAdapter) writable() (SQLStore, error) {
+    if f.Status().ReadOnly {
+        return nil, fmt.Errorf("%w: failed over to the standby database", utils.ErrReadOnly)
+    }
+    return f.current(), nil
+}
+
+// GetDB returns the gorm handle of the database in use. Writes through it are not guarded while
+// the adapter is read-only.
+func (f *FailoverSQLAdapter) GetDB() *gorm.DB {
+    return f.current().GetDB()
+}
+
+// WithContext returns an adapter whose statements run with the given context.
+func (f *FailoverSQLAdapter) WithContext(ctx context.Context) SQLStore {
+    return &FailoverSQLAdapter{state: f.state, ctx: ctx}
+}
+
+// Create inserts a new record into the database in use.
+func (f *FailoverSQLAdapter) Create(model interface{}) error {
+    db, err := f.writable()
+    if err != nil {
+        return err
+    }
+    return db.Create(model)
+}
+
+// Read retrieves a record by ID.
+func (f *FailoverSQLAdapter) Read(id uint, model interface{}) error {
+    return f.current().Read(id, model)
+}
+
+// ReadByKey retrieves a record by a primary key of any type.
+func (f *FailoverSQLAdapter) ReadByKey(key interface{}, model interface{}) error {
+    return f.current().ReadByKey(key, model)
+}
+
+// ReadWith retrieves a record by key together with the named associations.
+func (f *FailoverSQLAdapter) ReadWith(key interface{}, model interface{}, preloads ...string) error {
+    return f.current().ReadWith(key, model, preloads...)
+}
+
+// ReadMany retrieves the records with the given keys.
+func (f *FailoverSQLAdapter) ReadMany(keys []interface{}, dest interface{}) error {
+    return f.current().ReadMany(keys, dest)
+}
+
+// ReadManyWhere retrieves the records with the given keys matching conditions.
+func (f *FailoverSQLAdapter) ReadManyWhere(keys []interface{}, conditions map[string]interface{}, dest interface{}) error {
+    reader, ok := f.current().(ConditionalReader)
+    if !ok {
+        return fmt.Errorf("sql store cannot read with conditions")
+    }
+    return reader.ReadManyWhere(keys, conditions, dest)
+}
+
+// Preload loads the named associations into already loaded records.
+func (f *FailoverSQLAdapter) Preload(dest interface{}, preloads ...string) error {
+    return f.current().Preload(dest, preloads...)
+}
+
+// ReadForUpdate locks and retrieves a record by ID; it needs a writable database.
+func (f *FailoverSQLAdapter) ReadForUpdate(id uint, model interface{}) error {
+    db, err := f.writable()
+    if err != nil {
+        return err
+    }
+    return db.ReadForUpdate(id, model)
+}
+
+// ClaimBatch locks a batch of rows for processing; it needs a writable database.
+func (f *FailoverSQLAdapter) ClaimBatch(dest interface{}, limit int, query string, args ...interface{}) error {
+    db, err := f.writable()
+    if err != nil {
+        return err
+    }
+    return db.ClaimBatch(dest, limit, query, args...)
+}
+
+// Update saves a record to the database in use.
+func (f *FailoverSQLAdapter) Update(model interface{}) (int64, error) {
+    db, err := f.writable()
+    if err != nil {
+        return 0, err
+    }
+    return db.Update(model)
+}
+
+// Delete removes a record by ID.
+func (f *FailoverSQLAdapter) Delete(id uint, model interface{}) (int64, error) {
+    db, err := f.writable()
+    if err != nil {
+        return 0, err
+    }
+    return db.Delete(id, model)
+}
+
+// DeleteByKey removes a record by a primary key of any type.
+func (f *FailoverSQLAdapter) DeleteByKey(key interface{}, model interface{}) (int64, error) {
+    db, err := f.writable()
+    if err != nil {
+        return 0, err
+    }
+    return db.DeleteByKey(key, model)
+}
+
+// BeginTransaction starts a transaction on the database in use, which it keeps until it ends.
+func (f *FailoverSQLAdapter) BeginTransaction() (SQLStore, error) {
+    db, err := f.writable()
+    if err != nil {
+        return nil, err
+    }
+    return db.BeginTransaction()
+}
+
+// Commit is only valid on the transactions returned by BeginTransaction.
+func (f *FailoverSQLAdapter) Commit() error {
+    return utils.ErrNotInTransaction
+}
+
+// Rollback is only valid on the transactions returned by BeginTransaction.
+func (f *FailoverSQLAdapter) Rollback() error {
+    return utils.ErrNotInTransaction
+}
+
+// SavePoint is only valid on the transactions returned by BeginTransaction.
+func (f *FailoverSQLAdapter) SavePoint(name string) error {
+    return utils.ErrNotInTransaction
+}
+
+// RollbackTo is only valid on the transactions returned by BeginTransaction.
+func (f *FailoverSQLAdapter) RollbackTo(name string) error {
+    return utils.ErrNotInTransaction
+}
+
+// RawQuery executes a raw SQL query on the database in use.
+func (f *FailoverSQLAdapter) RawQuery(query string, params []interface{}, dest interface{}) error {
+    return f.current().RawQuery(query, params, dest)
+}
+
+// Exec runs a raw SQL statement on the database in use while it is writable.
+func (f *FailoverSQLAdapter) Exec(query string, params []interface{}) (int64, error) {
+    db, err := f.writable()
+    if err != nil {
+        return 0, err
+    }
+    return db.Exec(query, params)
+}
+
+// Close stops the health checks and closes both databases.
+func (f *FailoverSQLAdapter) Close() error {
+    s := f.state
+    s.stopOnce.Do(func() { close(s.stop) })
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    err := s.primary.Close()
+    if standbyErr := s.standby.Close(); err == nil {
+        err = standbyErr
+    }
+    return err
+}
@@ -0,0 +1,226 @@
+package adapters
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+
+    "gorm.io/gorm"
+)
+
+// MigrationStep is a DDL statement AutoMigrate would run, as returned by PlanMigration.
+type MigrationStep struct {
+    SQL string
+    // Destructive marks statements that may lose data, such as dropping a table or column or
+    // narrowing a column's type; Reason says why.
+    Destructive bool
+    Reason      string
+}
+
+type migrationPlanKey struct{}
+
+// migrationPlan collects the statements of a planned migration.
+type migrationPlan struct {
+    mu    sync.Mutex
+    steps []MigrationStep
+}
+
+var migrationPlanMu sync.Mutex
+
+// PlanMigration returns the DDL AutoMigrate would run to migrate models in db, without changing the
+// database: the current schema is read as usual, but the statements that would alter it are
+// recorded instead of executed.
+func PlanMigration(db *gorm.DB, models ...interface{}) ([]MigrationStep, error) {
+    migrationPlanMu.Lock()
+    if db.Callback().Raw().Get("migration:plan") == nil {
+        if err := db.Callback().Raw().Before("gorm:raw").Register("migration:plan", recordMigrationStep); err != nil {
+            migrationPlanMu.Unlock()
+            return nil, err
+        }
+    }
+    migrationPlanMu.Unlock()
+
+    plan := &migrationPlan{}
+    ctx := context.WithValue(db.Statement.Context, migrationPlanKey{}, plan)
+    if err := db.WithContext(ctx).AutoMigrate(models...); err != nil {
+        return nil, err
+    }
+    for i := range plan.steps {
+        plan.steps[i].Reason = destructiveReason(db, plan.steps[i].SQL)
+        plan.steps[i].Destructive = plan.steps[i].Reason != ""
+    }
+    return plan.steps, nil
+}
+
+// SchemaDrift compares the live schema of db with models and describes each difference: DDL that
+// AutoMigrate would still run, and columns the models don't know about that make their inserts
+// fail because they are NOT NULL without a default.
+func SchemaDrift(db *gorm.DB, models ...interface{}) ([]string, error) {
+    steps, err := PlanMigration(db, models...)
+    if err != nil {
+        return nil, err
+    }
+    var drift []string
+    for _, step := range steps {
+        drift = append(drift, "pending DDL: "+step.SQL)
+    }
+    for _, model := range models {
+        stmt := &gorm.Statement{DB: db}
+        if err := stmt.Parse(model); err != nil {
+            return nil, err
+        }
+        if !db.Migrator().HasTable(model) {
+            continue // Already reported as a pending CREATE TABLE.
+        }
+        columnTypes, err := db.Migrator().ColumnTypes(model)
+        if err != nil {
+            return nil, err
+        }
+        for _, column := range columnTypes {
+            if _, known := stmt.Schema.FieldsByDBName[column.Name()]; known {
+                continue
+            }
+            nullable, _ := column.Nullable()
+            _, hasDefault := column.DefaultValue()
+            if !nullable && !hasDefault {
+                drift = append(drift, fmt.Sprintf("column %s.%s is NOT NULL without a default and not in model %s", stmt.Table, column.Name(), stmt.Schema.Name))
+            }
+        }
+    }
+    return drift, nil
+}
+
+// recordMigrationStep diverts the statements of a planned migration to its plan.
+func recordMigrationStep(db *gorm.DB) {
+    plan, ok := db.Statement.Context.Value(migrationPlanKey{}).(*migrationPlan)
+    if ok {
+        db.Statement.ConnPool = planningPool{ConnPool: db.Statement.ConnPool, plan: plan, dialector: db.Dialector}
+    }
+}
+
+// planningPool records statements instead of executing them; reads pass through.
+type planningPool struct {
+    gorm.ConnPool
+    plan      *migrationPlan
+    dialector gorm.Dialector
+}
+
+func (p planningPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+    p.plan.mu.Lock()
+    defer p.plan.mu.Unlock()
+    p.plan.steps = append(p.plan.steps, MigrationStep{SQL: p.dialector.Explain(query, args...)})
+    return driver.RowsAffected(0), nil
+}
+
+var (
+    dropTablePattern   = regexp.MustCompile(`(?i)^\s*DROP\s+TABLE\b`)
+    dropColumnPattern  = regexp.MustCompile("(?i)^\\s*ALTER\\s+TABLE\\s+[`\"]?([\\w.]+)[`\"]?\\s+DROP\\s+COLUMN\\s+[`\"]?(\\w+)")
+    alterColumnPattern = regexp.MustCompile("(?i)^\\s*ALTER\\s+TABLE\\s+[`\"]?([\\w.]+)[`\"]?\\s+(?:MODIFY\\s+COLUMN\\s+[`\"]?(\\w+)[`\"]?|ALTER\\s+COLUMN\\s+[`\"]?(\\w+)[`\"]?\\s+TYPE)\\s+(.+)")
+    columnTypePattern  = regexp.MustCompile(`(?i)^\s*(double precision|character varying|[a-z0-9]+)\s*(?:\(\s*(\d+)\s*(?:,\s*(\d+)\s*)?\))?`)
+)
+
+// destructiveReason explains how a DDL statement may lose data, or returns "" when it can't.
+func destructiveReason(db *gorm.DB, statement string) string {
+    if dropTablePattern.MatchString(statement) {
+        return "drops a table"
+    }
+    if m := dropColumnPattern.FindStringSubmatch(statement); m != nil {
+        return fmt.Sprintf("drops column %s.%s", m[1], m[2])
+    }
+    m := alterColumnPattern.FindStringSubmatch(statement)
+    if m == nil {
+        return ""
+    }
+    table, column := m[1], m[2]
+    if column == "" {
+        column = m[3]
+    }
+    columnTypes, err := db.Migrator().ColumnTypes(table)
+    if err != nil {
+        return ""
+    }
+    for _, current := range columnTypes {
+        if current.Name() != column {
+            continue
+        }
+        from := parseColumnType(current.DatabaseTypeName())
+        if length, ok := current.Length(); ok && length > 0 && length < 1<<31 {
+            from.size = length
+        }
+        if precision, scale, ok := current.DecimalSize(); ok {
+            from.size, from.scale = precision, scale
+        }
+        to := parseColumnType(m[4])
+        if narrows(from, to) {
+            return fmt.Sprintf("narrows column %s.%s from %s to %s", table, column, from, to)
+        }
+    }
+    return ""
+}
+
+// columnType is a column type reduced to what narrows compares.
+type columnType struct {
+    name        string
+    size, scale int64
+}
+
+func (t columnType) String() string {
+    switch {
+    case t.scale > 0:
+        return fmt.Sprintf("%s(%d,%d)", t.name, t.size, t.scale)
+    case t.size > 0:
+        return fmt.Sprintf("%s(%d)", t.name, t.size)
+    }
+    return t.name
+}
+
+func parseColumnType(s string) columnType {
+    m := columnTypePattern.FindStringSubmatch(s)
+    if m == nil {
+        return columnType{name: strings.ToLower(strings.TrimSpace(s))}
+    }
+    t := columnType{name: strings.ToLower(m[1])}
+    t.size, _ = strconv.ParseInt(m[2], 10, 64)
+    t.scale, _ = strconv.ParseInt(m[3], 10, 64)
+    return t
+}
+
+// Type families ordered by width; types of different families never convert without loss.
+var columnFamilies = map[string]struct {
+    family string
+    rank   int
+}{
+    "tinyint": {"int", 1}, "smallint": {"int", 2}, "int2": {"int", 2}, "mediumint": {"int", 3},
+    "int": {"int", 4}, "integer": {"int", 4}, "int4": {"int", 4}, "bigint": {"int", 5}, "int8": {"int", 5},
+    "real": {"float", 1}, "float": {"float", 1}, "float4": {"float", 1},
+    "double": {"float", 2}, "double precision": {"float", 2}, "float8": {"float", 2},
+    "decimal": {"decimal", 1}, "numeric": {"decimal", 1},
+    "char": {"text", 1}, "character": {"text", 1}, "bpchar": {"text", 1},
+    "varchar": {"text", 1}, "character varying": {"text", 1},
+    "tinytext": {"text", 2}, "text": {"text", 3}, "mediumtext": {"text", 4}, "longtext": {"text", 5},
+    "binary": {"binary", 1}, "varbinary": {"binary", 1}, "tinyblob": {"binary", 2}, "blob": {"binary", 3},
+    "mediumblob": {"binary", 4}, "longblob": {"binary", 5}, "bytea": {"binary", 5},
+    "date": {"time", 1}, "datetime": {"time", 2}, "timestamp": {"time", 2}, "timestamptz": {"time", 2},
+}
+
+// narrows reports whether converting a column from one type to another may lose data: a change of
+// type family, a narrower type of the family, or a smaller size or scale.
+func narrows(from, to columnType) bool {
+    f, fKnown := columnFamilies[from.name]
+    t, tKnown := columnFamilies[to.name]
+    if !fKnown || !tKnown {
+        return from.name != to.name || to.size > 0 && to.size < from.size
+    }
+    if f.family != t.family || t.rank < f.rank {
+        return true
+    }
+    if t.rank > f.rank {
+        return false
+    }
+    return to.size > 0 && to.size < from.size || to.scale < from.scale
+}
@@ -0,0 +1,41 @@
+package adapters
+
+import (
+    "fmt"
+)
+
+// ReplicationPosition returns the position of the database in its replication stream: the
+// executed GTID set on MySQL, the current WAL LSN on Postgres. Call it on the primary after a
+// write; a replica that has replayed up to the position has seen the write.
+func (g *SQLAdapter) ReplicationPosition() (string, error) {
+    var position string
+    var err error
+    switch g.db.Dialector.Name() {
+    case "mysql":
+        err = g.db.Raw("SELECT @@GLOBAL.gtid_executed").Scan(&position).Error
+    case "postgres":
+        err = g.db.Raw("SELECT pg_current_wal_lsn()::text").Scan(&position).Error
+    default:
+        return "", fmt.Errorf("replication positions are not supported on %s", g.db.Dialector.Name())
+    }
+    if err == nil && position == "" {
+        err = fmt.Errorf("replication position unavailable; enable GTIDs or WAL")
+    }
+    return position, err
+}
+
+// CaughtUp reports whether this database, usually a replica, has replayed the replication stream
+// up to position, as returned by ReplicationPosition on its primary.
+func (g *SQLAdapter) CaughtUp(position string) (bool, error) {
+    var caughtUp bool
+    var err error
+    switch g.db.Dialector.Name() {
+    case "mysql":
+        err = g.db.Raw("SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)", position).Scan(&caughtUp).Error
+    case "postgres":
+        err = g.db.Raw("SELECT COALESCE(pg_last_wal_replay_lsn(), pg_current_wal_lsn()) >= ?::pg_lsn", position).Scan(&caughtUp).Error
+    default:
+        return false, fmt.Errorf("replication positions are not supported on %s", g.db.Dialector.Name())
+    }
+    return caughtUp, err
+}
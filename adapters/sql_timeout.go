@@ -0,0 +1,140 @@
+package adapters
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+type statementTimeoutKey struct{}
+
+// WithStatementTimeout returns a context whose SQL statements time out after d instead of the
+// adapter's default; 0 lifts the default. It needs EnableStatementTimeouts.
+func WithStatementTimeout(ctx context.Context, d time.Duration) context.Context {
+    return context.WithValue(ctx, statementTimeoutKey{}, d)
+}
+
+// EnableStatementTimeouts bounds every statement of the adapter by def, 0 for no default, or by the
+// timeout of its context set with WithStatementTimeout. Each statement runs under a context
+// deadline, which the Postgres driver enforces by cancelling the statement on the server. MySQL
+// SELECTs also carry a MAX_EXECUTION_TIME hint, since the MySQL driver only drops the connection
+// and would leave the query running. Call it once, at startup.
+func (g *SQLAdapter) EnableStatementTimeouts(def time.Duration) error {
+    t := statementTimeouts{def: def, mysql: g.db.Dialector.Name() == "mysql"}
+    callbacks := g.db.Callback()
+    // Row and Rows are left out: their results are read after the callbacks return.
+    for _, err := range []error{
+        callbacks.Query().Before("gorm:query").Register("timeouts:before_query", t.before),
+        callbacks.Query().After("gorm:query").Register("timeouts:after_query", t.after),
+        callbacks.Create().Before("gorm:create").Register("timeouts:before_create", t.before),
+        callbacks.Create().After("gorm:create").Register("timeouts:after_create", t.after),
+        callbacks.Update().Before("gorm:update").Register("timeouts:before_update", t.before),
+        callbacks.Update().After("gorm:update").Register("timeouts:after_update", t.after),
+        callbacks.Delete().Before("gorm:delete").Register("timeouts:before_delete", t.before),
+        callbacks.Delete().After("gorm:delete").Register("timeouts:after_delete", t.after),
+        callbacks.Raw().Before("gorm:raw").Register("timeouts:before_raw", t.before),
+        callbacks.Raw().After("gorm:raw").Register("timeouts:after_raw", t.after),
+    } {
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type statementTimeouts struct {
+    def   time.Duration
+    mysql bool
+}
+
+func (t statementTimeouts) before(db *gorm.DB) {
+    ctx := db.Statement.Context
+    timeout := t.def
+    if unbounded(ctx) {
+        timeout = 0
+    }
+    if d, ok := ctx.Value(statementTimeoutKey{}).(time.Duration); ok {
+        timeout = d
+    }
+    if timeout <= 0 {
+        return
+    }
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    db.Statement.Context = ctx
+    db.InstanceSet("timeouts:cancel", cancel)
+
+    // Statements given as raw SQL are already built and can't take the hint.
+    if t.mysql && db.Statement.SQL.Len() == 0 {
+        if deadline, ok := ctx.Deadline(); ok {
+            selectClause := db.Statement.Clauses["SELECT"]
+            selectClause.AfterNameExpression = maxExecutionTime(time.Until(deadline))
+            db.Statement.Clauses["SELECT"] = selectClause
+        }
+    }
+}
+
+func (t statementTimeouts) after(db *gorm.DB) {
+    if cancel, ok := db.InstanceGet("timeouts:cancel"); ok {
+        cancel.(context.CancelFunc)()
+    }
+}
+
+// SetDeadlines bounds statements whose context has no deadline: queries by d.Read, and inserts,
+// updates, deletes and raw statements, DDL included, by d.Write. Unlike statement timeouts, which
+// bound each statement, a deadline is what the caller has left to wait, e.g. the Postgres driver
+// cancels the statement when it passes. Migrations and bulk jobs run with WithoutDeadlines. Call
+// it once, at startup.
+func (g *SQLAdapter) SetDeadlines(d Deadlines) error {
+    read, write := sqlDeadline{d, false}, sqlDeadline{d, true}
+    callbacks := g.db.Callback()
+    // Run before every other callback, so they see the caller's context rather than one already
+    // bounded by a statement timeout.
+    for _, err := range []error{
+        callbacks.Query().Before("*").Register("deadlines:before_query", read.before),
+        callbacks.Query().After("*").Register("deadlines:after_query", read.after),
+        callbacks.Create().Before("*").Register("deadlines:before_create", write.before),
+        callbacks.Create().After("*").Register("deadlines:after_create", write.after),
+        callbacks.Update().Before("*").Register("deadlines:before_update", write.before),
+        callbacks.Update().After("*").Register("deadlines:after_update", write.after),
+        callbacks.Delete().Before("*").Register("deadlines:before_delete", write.before),
+        callbacks.Delete().After("*").Register("deadlines:after_delete", write.after),
+        callbacks.Raw().Before("*").Register("deadlines:before_raw", write.before),
+        callbacks.Raw().After("*").Register("deadlines:after_raw", write.after),
+    } {
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type sqlDeadline struct {
+    deadlines Deadlines
+    write     bool
+}
+
+func (d sqlDeadline) before(db *gorm.DB) {
+    ctx, cancel := d.deadlines.bound(db.Statement.Context, d.write)
+    db.Statement.Context = ctx
+    db.InstanceSet("deadlines:cancel", cancel)
+}
+
+func (d sqlDeadline) after(db *gorm.DB) {
+    if cancel, ok := db.InstanceGet("deadlines:cancel"); ok {
+        cancel.(context.CancelFunc)()
+    }
+}
+
+// maxExecutionTime is the MySQL optimizer hint bounding a SELECT's execution time.
+type maxExecutionTime time.Duration
+
+func (m maxExecutionTime) Build(builder clause.Builder) {
+    ms := time.Duration(m).Milliseconds()
+    if ms < 1 {
+        ms = 1
+    }
+    builder.WriteString(fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */", ms))
+}
@@ -0,0 +1,48 @@
+package admin
+
+import (
+    "context"
+    "persistence-layer/flags"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// HandleFlags registers GetFeatureFlags, which returns the flags in effect by name, and
+// SetFeatureFlag, which overrides the flag named in the request's "name" field on every instance
+// with its "enabled", "percentage" and "tenants" fields, or restores its configured value when
+// "clear" is set.
+func HandleFlags(s *Service, store *flags.Store) {
+    s.Handle("GetFeatureFlags", func(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+        return ToStruct(map[string]interface{}{"flags": store.All()})
+    })
+    s.Handle("SetFeatureFlag", func(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+        fields := req.GetFields()
+        name := fields["name"].GetStringValue()
+        if name == "" {
+            return nil, status.Error(codes.InvalidArgument, "name is required")
+        }
+        var err error
+        if fields["clear"].GetBoolValue() {
+            err = store.ClearOverride(name)
+        } else {
+            flag := flags.Flag{
+                Enabled:    fields["enabled"].GetBoolValue(),
+                Percentage: fields["percentage"].GetNumberValue(),
+            }
+            if flag.Percentage < 0 || flag.Percentage > 100 {
+                return nil, status.Error(codes.InvalidArgument, "percentage must be between 0 and 100")
+            }
+            for _, tenant := range fields["tenants"].GetListValue().GetValues() {
+                flag.Tenants = append(flag.Tenants, tenant.GetStringValue())
+            }
+            err = store.Override(name, flag)
+        }
+        if err != nil {
+            return nil, status.Error(codes.Unavailable, err.Error())
+        }
+        flag, _ := store.Get(name)
+        return ToStruct(map[string]interface{}{"name": name, "flag": flag})
+    })
+}
@@ -0,0 +1,22 @@
+package admin
+
+import (
+    "context"
+    "persistence-layer/orm"
+
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// HandleHotKeys registers GetHotKeys, which returns the cached read counts by model and the most
+// read keys, at most the request's "limit" (default 20), of the model named in its "model" field or
+// of every model when it is empty.
+func HandleHotKeys(s *Service, tracker *orm.HotKeyTracker) {
+    s.Handle("GetHotKeys", func(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+        limit := int(req.GetFields()["limit"].GetNumberValue())
+        if limit <= 0 {
+            limit = 20
+        }
+        model := req.GetFields()["model"].GetStringValue()
+        return ToStruct(map[string]interface{}{"models": tracker.Stats(), "hot_keys": tracker.Top(model, limit)})
+    })
+}
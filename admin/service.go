@@ -0,0 +1,88 @@
+package admin
+
+import (
+    "context"
+    "encoding/json"
+    "sort"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// ServiceName is the fully qualified gRPC name of the admin service.
+const ServiceName = "persistence.AdminService"
+
+// Handler serves one AdminService RPC. Requests and responses are free-form structs, so operational
+// RPCs can be added without regenerating protobuf code.
+type Handler func(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+
+// adminServer is the handler type of the hand-written service descriptor.
+type adminServer interface{}
+
+// Service is the gRPC admin service. Features register their RPCs with Handle before Register is called.
+type Service struct {
+    methods map[string]Handler
+}
+
+// NewService creates an admin service with no RPCs.
+func NewService() *Service {
+    return &Service{methods: make(map[string]Handler)}
+}
+
+// Handle registers the RPC /persistence.AdminService/<method>.
+func (s *Service) Handle(method string, h Handler) {
+    s.methods[method] = h
+}
+
+// Register registers the service and every RPC added so far with the gRPC server.
+func (s *Service) Register(server *grpc.Server) {
+    names := make([]string, 0, len(s.methods))
+    for name := range s.methods {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    desc := grpc.ServiceDesc{
+        ServiceName: ServiceName,
+        HandlerType: (*adminServer)(nil),
+        Metadata:    "admin",
+    }
+    for _, name := range names {
+        desc.Methods = append(desc.Methods, grpc.MethodDesc{
+            MethodName: name,
+            Handler:    unaryHandler(name, s.methods[name]),
+        })
+    }
+    server.RegisterService(&desc, s)
+}
+
+func unaryHandler(method string, h Handler) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+    return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+        req := new(structpb.Struct)
+        if err := dec(req); err != nil {
+            return nil, err
+        }
+        if interceptor == nil {
+            return h(ctx, req)
+        }
+        info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + method}
+        return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+            return h(ctx, req.(*structpb.Struct))
+        })
+    }
+}
+
+// ToStruct converts a JSON-serializable value into a response struct.
+func ToStruct(value interface{}) (*structpb.Struct, error) {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "encoding response: %v", err)
+    }
+    var fields map[string]interface{}
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return nil, status.Errorf(codes.Internal, "encoding response: %v", err)
+    }
+    return structpb.NewStruct(fields)
+}
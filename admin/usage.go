@@ -0,0 +1,39 @@
+package admin
+
+import (
+    "context"
+    "persistence-layer/orm"
+
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// tenantUsage is the GetTenantUsage response entry for one tenant.
+type tenantUsage struct {
+    orm.Usage
+    Quota orm.Quota `json:"quota"`
+}
+
+// HandleUsage registers GetTenantUsage, which returns the usage and quota of the tenant named in the
+// request's "tenant" field, or of every tracked tenant when it is empty.
+func HandleUsage(s *Service, tracker *orm.UsageTracker) {
+    s.Handle("GetTenantUsage", func(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+        var tenants []string
+        if tenant := req.GetFields()["tenant"].GetStringValue(); tenant != "" {
+            tenants = []string{tenant}
+        } else {
+            var err error
+            if tenants, err = tracker.Tenants(); err != nil {
+                return nil, err
+            }
+        }
+        usage := make(map[string]tenantUsage, len(tenants))
+        for _, tenant := range tenants {
+            u, err := tracker.Usage(tenant)
+            if err != nil {
+                return nil, err
+            }
+            usage[tenant] = tenantUsage{Usage: u, Quota: tracker.Quota(tenant)}
+        }
+        return ToStruct(map[string]interface{}{"tenants": usage})
+    })
+}
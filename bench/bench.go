@@ -0,0 +1,169 @@
+package bench
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "math/rand"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Operation is one kind of request in the load mix. Weight is its relative share of requests.
+type Operation struct {
+    Name   string
+    Weight int
+    Run    func(ctx context.Context) error
+}
+
+// Config controls a benchmark run.
+type Config struct {
+    Duration    time.Duration
+    Concurrency int
+    Operations  []Operation
+}
+
+// OpStats accumulates the outcome of every call to one operation.
+type OpStats struct {
+    Count     int
+    Errors    int
+    latencies []time.Duration
+}
+
+// Percentile returns the latency below which p percent (0-100) of the calls completed.
+func (s *OpStats) Percentile(p float64) time.Duration {
+    if len(s.latencies) == 0 {
+        return 0
+    }
+    idx := int(float64(len(s.latencies)-1) * p / 100)
+    return s.latencies[idx]
+}
+
+// ErrorRate returns the fraction of calls that failed.
+func (s *OpStats) ErrorRate() float64 {
+    if s.Count == 0 {
+        return 0
+    }
+    return float64(s.Errors) / float64(s.Count)
+}
+
+// Report is the result of a benchmark run.
+type Report struct {
+    Elapsed time.Duration
+    Ops     map[string]*OpStats
+}
+
+// Run drives the weighted operation mix from Concurrency workers until Duration elapses or ctx is cancelled.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+    totalWeight := 0
+    for _, op := range cfg.Operations {
+        if op.Weight < 0 {
+            return nil, fmt.Errorf("operation %q has a negative weight", op.Name)
+        }
+        totalWeight += op.Weight
+    }
+    if totalWeight == 0 {
+        return nil, fmt.Errorf("operation mix has no weighted operations")
+    }
+    if cfg.Concurrency <= 0 {
+        cfg.Concurrency = 1
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+    defer cancel()
+
+    report := &Report{Ops: make(map[string]*OpStats)}
+    for _, op := range cfg.Operations {
+        report.Ops[op.Name] = &OpStats{}
+    }
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    start := time.Now()
+    for w := 0; w < cfg.Concurrency; w++ {
+        wg.Add(1)
+        go func(seed int64) {
+            defer wg.Done()
+            rng := rand.New(rand.NewSource(seed))
+            for ctx.Err() == nil {
+                op := pick(cfg.Operations, rng.Intn(totalWeight))
+                began := time.Now()
+                err := op.Run(ctx)
+                latency := time.Since(began)
+                if ctx.Err() != nil {
+                    // Calls interrupted by the end of the run would skew the error rate.
+                    return
+                }
+
+                mu.Lock()
+                stats := report.Ops[op.Name]
+                stats.Count++
+                if err != nil {
+                    stats.Errors++
+                }
+                stats.latencies = append(stats.latencies, latency)
+                mu.Unlock()
+            }
+        }(time.Now().UnixNano() + int64(w))
+    }
+    wg.Wait()
+    report.Elapsed = time.Since(start)
+
+    for _, stats := range report.Ops {
+        sort.Slice(stats.latencies, func(i, j int) bool { return stats.latencies[i] < stats.latencies[j] })
+    }
+    return report, nil
+}
+
+// pick maps a number in [0, totalWeight) onto the operation owning that slice of the weight range.
+func pick(ops []Operation, n int) Operation {
+    for _, op := range ops {
+        if n < op.Weight {
+            return op
+        }
+        n -= op.Weight
+    }
+    return ops[len(ops)-1]
+}
+
+// Print writes a table of throughput, latency percentiles and error rates per operation.
+func (r *Report) Print(w io.Writer) {
+    names := make([]string, 0, len(r.Ops))
+    for name := range r.Ops {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    fmt.Fprintf(w, "%-10s %8s %10s %10s %10s %10s %8s\n", "operation", "count", "ops/s", "p50", "p95", "p99", "errors")
+    for _, name := range names {
+        s := r.Ops[name]
+        fmt.Fprintf(w, "%-10s %8d %10.1f %10s %10s %10s %7.2f%%\n",
+            name, s.Count, float64(s.Count)/r.Elapsed.Seconds(),
+            s.Percentile(50).Round(time.Microsecond), s.Percentile(95).Round(time.Microsecond),
+            s.Percentile(99).Round(time.Microsecond), s.ErrorRate()*100)
+    }
+}
+
+// ParseMix parses a weight specification such as "create=1,read=5,search=2,cache=2".
+func ParseMix(spec string) (map[string]int, error) {
+    mix := make(map[string]int)
+    for _, part := range strings.Split(spec, ",") {
+        part = strings.TrimSpace(part)
+        if part == "" {
+            continue
+        }
+        kv := strings.SplitN(part, "=", 2)
+        if len(kv) != 2 {
+            return nil, fmt.Errorf("invalid mix entry %q, expected name=weight", part)
+        }
+        weight, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+        if err != nil || weight < 0 {
+            return nil, fmt.Errorf("invalid weight in mix entry %q", part)
+        }
+        mix[strings.TrimSpace(kv[0])] = weight
+    }
+    return mix, nil
+}
@@ -0,0 +1,223 @@
+// Package client is the Go SDK for the persistence layer's gRPC services. A Client keeps a small
+// pool of connections to the server, gives every call a default deadline and retries calls the
+// server couldn't take, so consumers don't repeat the dial and stub boilerplate. The typed helpers
+// of each model, e.g. c.Users().Get(ctx, id), are generated next to the services by
+// generate_model.py.
+//
+//     c, err := client.Dial("persistence-layer:50051", client.Options{})
+//     if err != nil {
+//         return err
+//     }
+//     defer c.Close()
+//     user, err := c.Users().Get(client.WithToken(ctx, token), 42)
+package client
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "math/rand"
+    "path"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+)
+
+// Metadata keys read by the server's interceptors; they must match the headers in the
+// interceptors package.
+const (
+    authorizationHeader = "authorization"
+    sessionHeader       = "x-session-token"
+)
+
+// DefaultTimeout is the deadline of calls made with a context that has none.
+const DefaultTimeout = 5 * time.Second
+
+// RetryPolicy controls how a Client retries a failed call.
+type RetryPolicy struct {
+    MaxAttempts    int // Total attempts, including the first; 1 disables retries.
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+    // Codes are the status codes worth retrying. Only retry codes for which the server did not
+    // apply the call, so creates aren't duplicated.
+    Codes []codes.Code
+    // Idempotent reports whether a method, e.g. "/proto.UserService/CreateUser", may run twice.
+    // Other methods are retried only when the call never reached a server, since an Unavailable
+    // connection lost mid-call says nothing of whether the server applied it. Defaults to
+    // IdempotentMethod.
+    Idempotent func(method string) bool
+}
+
+// DefaultRetryPolicy makes up to three attempts at calls that failed with Unavailable, which the
+// server returns before running a call, e.g. while it restarts.
+var DefaultRetryPolicy = RetryPolicy{
+    MaxAttempts:    3,
+    InitialBackoff: 50 * time.Millisecond,
+    MaxBackoff:     time.Second,
+    Codes:          []codes.Code{codes.Unavailable},
+    Idempotent:     IdempotentMethod,
+}
+
+// idempotentPrefixes are the method names of calls that read, or set a record to a given state.
+var idempotentPrefixes = []string{"Get", "List", "Search", "Read", "Count", "Update", "Delete"}
+
+// IdempotentMethod reports whether the name of a method starts with Get, List, Search, Read, Count,
+// Update or Delete. Creates and any other call are not idempotent.
+func IdempotentMethod(method string) bool {
+    name := path.Base(method)
+    for _, prefix := range idempotentPrefixes {
+        if strings.HasPrefix(name, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// Options configures a Client. The zero value dials without TLS with the defaults.
+type Options struct {
+    // PoolSize is the number of connections calls are spread over; defaults to 1. More
+    // connections help when a single HTTP/2 connection's stream limit becomes the bottleneck.
+    PoolSize int
+    // Timeout is the deadline of calls whose context has none; defaults to DefaultTimeout.
+    Timeout time.Duration
+    // Retry defaults to DefaultRetryPolicy when MaxAttempts is 0.
+    Retry RetryPolicy
+    // TLS enables transport security; nil dials in plain text.
+    TLS *tls.Config
+    // DialOptions are passed to every connection, after the Client's own.
+    DialOptions []grpc.DialOption
+}
+
+// Client is a pool of connections to the server. It implements grpc.ClientConnInterface, so
+// generated stubs can be built on it directly. It is safe for concurrent use.
+type Client struct {
+    conns   []*grpc.ClientConn
+    next    uint32
+    timeout time.Duration
+    retry   RetryPolicy
+}
+
+// Dial connects to the server at target, e.g. "localhost:50051" or "dns:///persistence-layer:50051".
+// Connections are established lazily, so Dial does not fail when the server is down.
+func Dial(target string, opts Options) (*Client, error) {
+    c := &Client{timeout: opts.Timeout, retry: opts.Retry}
+    if c.timeout <= 0 {
+        c.timeout = DefaultTimeout
+    }
+    if c.retry.MaxAttempts == 0 {
+        c.retry = DefaultRetryPolicy
+    }
+    if c.retry.InitialBackoff <= 0 {
+        c.retry.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+    }
+    if c.retry.MaxBackoff < c.retry.InitialBackoff {
+        c.retry.MaxBackoff = c.retry.InitialBackoff
+    }
+    if c.retry.Idempotent == nil {
+        c.retry.Idempotent = IdempotentMethod
+    }
+    size := opts.PoolSize
+    if size < 1 {
+        size = 1
+    }
+
+    creds := insecure.NewCredentials()
+    if opts.TLS != nil {
+        creds = credentials.NewTLS(opts.TLS)
+    }
+    dialOptions := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts.DialOptions...)
+    for i := 0; i < size; i++ {
+        conn, err := grpc.NewClient(target, dialOptions...)
+        if err != nil {
+            c.Close()
+            return nil, fmt.Errorf("client: dialing %s: %w", target, err)
+        }
+        c.conns = append(c.conns, conn)
+    }
+    return c, nil
+}
+
+// Close closes the connections of the pool.
+func (c *Client) Close() error {
+    var first error
+    for _, conn := range c.conns {
+        if err := conn.Close(); err != nil && first == nil {
+            first = err
+        }
+    }
+    return first
+}
+
+// conn picks the connections of the pool in turn.
+func (c *Client) conn() *grpc.ClientConn {
+    n := atomic.AddUint32(&c.next, 1)
+    return c.conns[int(n)%len(c.conns)]
+}
+
+// Invoke runs a unary call with the default deadline, retrying it as the RetryPolicy allows. All
+// attempts share the call's deadline. Calls that are not idempotent are retried only when no
+// connection to a server was ready to send them on.
+func (c *Client) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+    if _, ok := ctx.Deadline(); !ok {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, c.timeout)
+        defer cancel()
+    }
+    idempotent := c.retry.Idempotent(method)
+    // The peer is set once the call has a stream to a server, i.e. once it may have been sent.
+    var sent peer.Peer
+    opts = append(opts[:len(opts):len(opts)], grpc.Peer(&sent))
+    backoff := c.retry.InitialBackoff
+    for attempt := 1; ; attempt++ {
+        sent = peer.Peer{}
+        err := c.conn().Invoke(ctx, method, args, reply, opts...)
+        if err == nil || attempt >= c.retry.MaxAttempts || !c.retryable(err) || !idempotent && sent.Addr != nil {
+            return err
+        }
+        // Full jitter so clients that failed together don't retry in lockstep.
+        select {
+        case <-ctx.Done():
+            return err
+        case <-time.After(time.Duration(rand.Int63n(int64(backoff)) + 1)):
+        }
+        backoff *= 2
+        if backoff > c.retry.MaxBackoff {
+            backoff = c.retry.MaxBackoff
+        }
+    }
+}
+
+// NewStream opens a stream on one of the connections. Streams get neither the default deadline,
+// since they may legitimately run for long, nor retries.
+func (c *Client) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+    return c.conn().NewStream(ctx, desc, method, opts...)
+}
+
+func (c *Client) retryable(err error) bool {
+    code := status.Code(err)
+    for _, retry := range c.retry.Codes {
+        if code == retry {
+            return true
+        }
+    }
+    return false
+}
+
+// WithToken returns a context whose calls authenticate with the bearer token, a JWT whose claims
+// name the caller, its roles and its tenant.
+func WithToken(ctx context.Context, token string) context.Context {
+    return metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)
+}
+
+// WithSession returns a context whose calls authenticate with the session token.
+func WithSession(ctx context.Context, token string) context.Context {
+    return metadata.AppendToOutgoingContext(ctx, sessionHeader, token)
+}
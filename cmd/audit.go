@@ -0,0 +1,53 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "log"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "time"
+)
+
+// startAuditExport installs the audit middleware when enabled and ships its events to the
+// configured sink, publishing the exporter's counts as the "audit_export" expvar.
+func startAuditExport(ctx context.Context, ormLayer *orm.ORM, cfg config.AuditConfig) {
+    if !cfg.Enabled {
+        return
+    }
+    var sink orm.AuditSink
+    switch cfg.Sink {
+    case "syslog", "":
+        sink = orm.NewSyslogAuditSink(cfg.SyslogNetwork, cfg.SyslogAddress, "")
+    case "http":
+        if cfg.URL == "" {
+            log.Fatalf("Invalid audit configuration: the http sink needs a url")
+        }
+        sink = orm.NewHTTPAuditSink(cfg.URL)
+    case "kafka":
+        if cfg.URL == "" || cfg.Topic == "" {
+            log.Fatalf("Invalid audit configuration: the kafka sink needs a url and a topic")
+        }
+        sink = orm.NewKafkaAuditSink(cfg.URL, cfg.Topic)
+    default:
+        log.Fatalf("Invalid audit configuration: unknown sink %q", cfg.Sink)
+    }
+    exporter := orm.NewAuditExporter(sink, cfg.Buffer)
+    if len(cfg.Operations) > 0 {
+        exporter.Operations = make(map[string]bool, len(cfg.Operations))
+        for _, name := range cfg.Operations {
+            exporter.Operations[name] = true
+        }
+    }
+    if cfg.BatchSize > 0 {
+        exporter.BatchSize = cfg.BatchSize
+    }
+    if cfg.FlushMs > 0 {
+        exporter.FlushInterval = time.Duration(cfg.FlushMs) * time.Millisecond
+    }
+    exporter.Block = cfg.Block
+    ormLayer.Use(exporter.Middleware())
+    expvar.Publish("audit_export", expvar.Func(func() interface{} { return exporter.Stats() }))
+    go exporter.Run(ctx)
+    log.Printf("Audit export enabled (sink=%s)", cfg.Sink)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+    "log"
+    "persistence-layer/auth"
+    "persistence-layer/config"
+    "time"
+)
+
+// newVerifier returns the verifier of the bearer tokens of calls, or nil when authentication is
+// disabled.
+func newVerifier(cfg config.AuthConfig) *auth.Verifier {
+    if cfg.JWTSecret == "" {
+        log.Printf("Authentication disabled: calls act as an anonymous principal")
+        return nil
+    }
+    verifier, err := auth.NewVerifier(auth.Options{
+        Secret:   []byte(cfg.JWTSecret),
+        Issuer:   cfg.Issuer,
+        Audience: cfg.Audience,
+        Leeway:   time.Duration(cfg.LeewaySeconds) * time.Second,
+    })
+    if err != nil {
+        log.Fatalf("Failed to set up authentication: %v", err)
+    }
+    return verifier
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "math/rand"
+    "os"
+    "persistence-layer/bench"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "sort"
+    "sync"
+    "time"
+)
+
+// benchIndex is the index bench records are kept in, named after their table so the search
+// operation falls back to it when Elasticsearch is down.
+const benchIndex = "bench_records"
+
+// benchRecord is the throwaway model written and read by the bench command. Its policy indexes it,
+// so the search operation measures queries over the documents the create operation writes.
+type benchRecord struct {
+    ID        uint64    `json:"id" gorm:"primaryKey" bson:"_id"`
+    Name      string    `json:"name" bson:"name" search:"text"`
+    Payload   string    `json:"payload" bson:"payload" search:"noindex"`
+    CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime" bson:"created_at"`
+}
+
+// TableName keeps benchmark rows out of the application tables.
+func (benchRecord) TableName() string {
+    return "bench_records"
+}
+
+func (b *benchRecord) GetID() uint64 {
+    return b.ID
+}
+
+// runBench drives a weighted mix of ORM operations against the configured backends and prints
+// latency percentiles, e.g. `bench -duration 30s -concurrency 16 -mix create=1,read=5,search=2,cache=2`.
+func runBench(cfg *config.Config, args []string) {
+    flags := flag.NewFlagSet("bench", flag.ExitOnError)
+    duration := flags.Duration("duration", 30*time.Second, "how long to generate load")
+    concurrency := flags.Int("concurrency", 8, "number of concurrent workers")
+    mixSpec := flags.String("mix", "create=1,read=5,search=2,cache=2", "relative weights of create, read, search and cache operations")
+    payloadSize := flags.Int("payload", 256, "size in bytes of the payload written by create")
+    _ = flags.Parse(args)
+
+    mix, err := bench.ParseMix(*mixSpec)
+    if err != nil {
+        log.Fatalf("Invalid -mix: %v", err)
+    }
+
+    ormLayer, cleanup := initORM(cfg, migrationOptions{})
+    defer cleanup()
+    if ormLayer.SQL != nil {
+        if err := ormLayer.SQL.GetDB().AutoMigrate(&benchRecord{}); err != nil {
+            log.Fatalf("Failed to migrate bench table: %v", err)
+        }
+    }
+    if ormLayer.Elasticsearch != nil {
+        if err := ormLayer.SetPolicy(&benchRecord{}, orm.Policy{SQL: true, Index: benchIndex}); err != nil {
+            log.Fatalf("Failed to set the bench policy: %v", err)
+        }
+        if err := ormLayer.EnsureIndex(benchIndex, &benchRecord{}); err != nil {
+            log.Fatalf("Failed to create the bench index: %v", err)
+        }
+    }
+
+    ops, err := benchOperations(ormLayer, mix, *payloadSize)
+    if err != nil {
+        log.Fatalf("Invalid -mix: %v", err)
+    }
+
+    log.Printf("Running benchmark for %s with %d workers (mix %s)", *duration, *concurrency, *mixSpec)
+    report, err := bench.Run(context.Background(), bench.Config{
+        Duration:    *duration,
+        Concurrency: *concurrency,
+        Operations:  ops,
+    })
+    if err != nil {
+        log.Fatalf("Benchmark failed: %v", err)
+    }
+    report.Print(os.Stdout)
+}
+
+// benchOperations builds the operations named in the mix.
+func benchOperations(ormLayer *orm.ORM, mix map[string]int, payloadSize int) ([]bench.Operation, error) {
+    var mu sync.Mutex
+    var ids []uint64
+    payload := make([]byte, payloadSize)
+    for i := range payload {
+        payload[i] = 'a' + byte(i%26)
+    }
+
+    randomID := func() (uint64, bool) {
+        mu.Lock()
+        defer mu.Unlock()
+        if len(ids) == 0 {
+            return 0, false
+        }
+        return ids[rand.Intn(len(ids))], true
+    }
+
+    available := map[string]func(ctx context.Context) error{
+        "create": func(ctx context.Context) error {
+            record := &benchRecord{Name: fmt.Sprintf("bench-%d", rand.Int63()), Payload: string(payload)}
+            if _, err := ormLayer.Create(record); err != nil {
+                return err
+            }
+            mu.Lock()
+            ids = append(ids, record.ID)
+            mu.Unlock()
+            return nil
+        },
+        "read": func(ctx context.Context) error {
+            id, ok := randomID()
+            if !ok {
+                return errors.New("no records created yet")
+            }
+            var record benchRecord
+            return ormLayer.Read(uint(id), &record)
+        },
+        "search": func(ctx context.Context) error {
+            query := map[string]interface{}{
+                "query": map[string]interface{}{"match": map[string]interface{}{"name": "bench"}},
+                "size":  10,
+            }
+            var result map[string]interface{}
+            return ormLayer.Search(benchIndex, query, &result)
+        },
+        "cache": func(ctx context.Context) error {
+            key := fmt.Sprintf("bench:%d", rand.Intn(1000))
+            if err := ormLayer.SetCache(key, string(payload), time.Minute); err != nil {
+                return err
+            }
+            var value string
+            return ormLayer.GetCache(key, &value)
+        },
+    }
+
+    names := make([]string, 0, len(mix))
+    for name := range mix {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    ops := make([]bench.Operation, 0, len(names))
+    for _, name := range names {
+        run, ok := available[name]
+        if !ok {
+            return nil, fmt.Errorf("unknown operation %q (expected create, read, search or cache)", name)
+        }
+        ops = append(ops, bench.Operation{Name: name, Weight: mix[name], Run: run})
+    }
+    return ops, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "log"
+    "os"
+    "persistence-layer/config"
+    "strings"
+
+    "gopkg.in/yaml.v2"
+)
+
+// configLoader is the loader the configuration was read with at startup, reused on reload.
+var configLoader config.Loader
+
+// overrides collects the repeatable -set flag.
+type overrides []string
+
+func (o *overrides) String() string { return strings.Join(*o, ",") }
+
+func (o *overrides) Set(value string) error {
+    *o = append(*o, value)
+    return nil
+}
+
+// parseGlobalFlags parses the flags preceding the command, e.g.
+//
+//     server -config config/config.toml -env prod -set rate_limit.requests=200 serve
+//
+// into the loader they describe, returning it with the command line left after them. The file,
+// environment and remote source default to $PL_CONFIG_FILE, $PL_ENV and $PL_REMOTE_CONFIG, the
+// remote store's token is read from $PL_REMOTE_TOKEN and its cache file from $PL_REMOTE_CACHE; see
+// config.Loader for the precedence of the layers.
+func parseGlobalFlags() (config.Loader, []string) {
+    path := os.Getenv(config.DefaultEnvPrefix + config.FileVariable)
+    if path == "" {
+        path = config.DefaultPath
+    }
+    var sets overrides
+    flag.StringVar(&path, "config", path, "base configuration file (.yaml, .yml, .json or .toml)")
+    env := flag.String("env", os.Getenv(config.DefaultEnvPrefix+config.EnvVariable), "environment whose overlay file, e.g. config/config.prod.yaml, is applied over the base")
+    remote := flag.String("remote", os.Getenv(config.DefaultEnvPrefix+config.RemoteVariable), "remote configuration key, e.g. consul://consul:8500/config/persistence-layer.yaml or etcd://etcd:2379/...")
+    flag.Var(&sets, "set", "override a setting, as path=value, e.g. rate_limit.requests=200 (repeatable)")
+    flag.Parse()
+    loader := config.Loader{Path: path, Env: *env, EnvPrefix: config.DefaultEnvPrefix, Overrides: sets}
+    if *remote != "" {
+        source, err := config.ParseRemote(*remote)
+        if err != nil {
+            log.Fatalf("Invalid remote configuration: %v", err)
+        }
+        source.Token = os.Getenv(config.DefaultEnvPrefix + config.RemoteTokenVariable)
+        if cache, ok := os.LookupEnv(config.DefaultEnvPrefix + config.RemoteCacheVariable); ok {
+            source.CacheFile = cache // Empty disables the cache.
+        }
+        loader.Remote = source
+    }
+    return loader, flag.Args()
+}
+
+// configSources describes where the configuration is loaded from, for log messages.
+func configSources() string {
+    sources := configLoader.Files()
+    if configLoader.Remote != nil {
+        sources = append(sources, configLoader.Remote.String())
+    }
+    return strings.Join(sources, ", ")
+}
+
+// runConfig prints the effective configuration, after every layer, environment variable and
+// default is applied, with credentials masked: `config show [-format yaml|json]`.
+func runConfig(cfg *config.Config, args []string) {
+    if len(args) == 0 || args[0] != "show" {
+        log.Fatalf("Usage: config show [-format yaml|json]")
+    }
+    flags := flag.NewFlagSet("config show", flag.ExitOnError)
+    format := flags.String("format", "yaml", "output format: yaml or json")
+    _ = flags.Parse(args[1:])
+
+    redacted, err := cfg.Redacted()
+    if err != nil {
+        log.Fatalf("Rendering configuration failed: %v", err)
+    }
+    var out []byte
+    switch *format {
+    case "yaml":
+        out, err = yaml.Marshal(redacted)
+        out = append([]byte("# Loaded from "+config.RedactCredentials(configSources())+"\n"), out...)
+    case "json":
+        out, err = json.MarshalIndent(redacted, "", "  ")
+        out = append(out, '\n')
+    default:
+        log.Fatalf("Unknown format %q (expected yaml or json)", *format)
+    }
+    if err != nil {
+        log.Fatalf("Rendering configuration failed: %v", err)
+    }
+    os.Stdout.Write(out)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "fmt"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "time"
+)
+
+// startSearchChecks compares SQL with the search index of every model whose policy has one, when
+// enabled in config, publishing the latest reports as the "search_drift" expvar.
+func startSearchChecks(ctx context.Context, ormLayer *orm.ORM, cfg config.ConsistencyConfig) {
+    if !cfg.Search.Enabled || ormLayer.Elasticsearch == nil {
+        return
+    }
+    interval := time.Duration(cfg.IntervalMins) * time.Minute
+    if interval <= 0 {
+        interval = time.Hour
+    }
+
+    checkers := map[string]driftChecker{}
+    for _, model := range GetAllModels() {
+        if ormLayer.PolicyFor(model).Index == "" {
+            continue
+        }
+        shards, err := ormLayer.Shards(model)
+        if err != nil {
+            continue
+        }
+        for i, db := range shards {
+            checker := orm.NewSearchChecker(db, model)
+            checker.SampleSize = cfg.Search.SampleSize
+            checker.Repair = cfg.Search.Repair
+            checkers[shardName(model, i, len(shards))] = checker
+            go checker.Run(ctx, interval)
+        }
+    }
+    expvar.Publish("search_drift", expvar.Func(func() interface{} { return driftSnapshot(checkers) }))
+}
+
+// startCacheChecks compares SQL with the cached entries of every model whose policy caches its
+// rows, when enabled in config, publishing the latest reports as the "cache_drift" expvar.
+func startCacheChecks(ctx context.Context, ormLayer *orm.ORM, cfg config.ConsistencyConfig) {
+    if !cfg.Cache.Enabled || ormLayer.Redis == nil {
+        return
+    }
+    interval := time.Duration(cfg.IntervalMins) * time.Minute
+    if interval <= 0 {
+        interval = time.Hour
+    }
+
+    checkers := map[string]driftChecker{}
+    for _, model := range GetAllModels() {
+        if policy := ormLayer.PolicyFor(model); !policy.Cache || !policy.SQL {
+            continue
+        }
+        shards, err := ormLayer.Shards(model)
+        if err != nil {
+            continue
+        }
+        for i, db := range shards {
+            checker := orm.NewCacheChecker(db, model)
+            checker.SampleSize = cfg.Cache.SampleSize
+            checker.Repair = cfg.Cache.Repair
+            checkers[shardName(model, i, len(shards))] = checker
+            go checker.Run(ctx, interval)
+        }
+    }
+    expvar.Publish("cache_drift", expvar.Func(func() interface{} { return driftSnapshot(checkers) }))
+}
+
+// shardName names the checker of one shard of a model, e.g. "User/2"; unsharded models keep their
+// name.
+func shardName(model interface{}, shard, shards int) string {
+    if shards == 1 {
+        return modelTypeName(model)
+    }
+    return fmt.Sprintf("%s/%d", modelTypeName(model), shard)
+}
+
+// driftChecker is implemented by orm.SearchChecker and orm.CacheChecker.
+type driftChecker interface {
+    LastReport() *orm.DriftReport
+}
+
+// driftSnapshot reports, per model, the latest drift report and its drift ratio.
+func driftSnapshot(checkers map[string]driftChecker) map[string]interface{} {
+    snapshot := make(map[string]interface{}, len(checkers))
+    for name, checker := range checkers {
+        if report := checker.LastReport(); report != nil {
+            snapshot[name] = map[string]interface{}{
+                "drift":    report.Drift(),
+                "checked":  report.Checked,
+                "missing":  len(report.Missing),
+                "stale":    len(report.Stale),
+                "repaired": report.Repaired,
+                "finished": report.Finished,
+            }
+        }
+    }
+    return snapshot
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "fmt"
+    "log"
+    "persistence-layer/adapters"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "os"
+    "os/signal"
+    "sort"
+    "syscall"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// openSQL connects the main SQL database, behind a failover adapter when a standby DSN is
+// configured, whose state is published as the "sql_failover" expvar.
+func openSQL(cfg *config.Config, retry adapters.RetryPolicy) (adapters.SQLStore, error) {
+    if cfg.StandbyDSN == "" {
+        adapter, err := adapters.NewSQLAdapter(cfg.MySQLDSN, "mysql", retry)
+        if err != nil {
+            return nil, err
+        }
+        limitStatements(adapter, cfg.StatementTimeoutMs)
+        setDeadlines("SQL", adapter, sqlDeadlines(cfg.Timeouts))
+        return adapter, nil
+    }
+    adapter, err := adapters.NewFailoverSQLAdapter(cfg.MySQLDSN, cfg.StandbyDSN, "mysql", retry, adapters.FailoverOptions{
+        CheckInterval:   time.Duration(cfg.Failover.CheckIntervalSeconds) * time.Second,
+        FailAfter:       cfg.Failover.FailAfter,
+        RecoverAfter:    cfg.Failover.RecoverAfter,
+        StandbyWritable: cfg.Failover.StandbyWritable,
+    })
+    if err != nil {
+        return nil, err
+    }
+    limitStatements(adapter, cfg.StatementTimeoutMs)
+    setDeadlines("SQL", adapter, sqlDeadlines(cfg.Timeouts))
+    expvar.Publish("sql_failover", expvar.Func(func() interface{} { return adapter.Status() }))
+    if cfg.Failover.StandbyWritable {
+        go failbackOnSignal(adapter)
+    }
+    return adapter, nil
+}
+
+// failbackOnSignal fails back to the primary database on SIGUSR1, which the operator sends once
+// the writes a writable standby took are on the primary.
+func failbackOnSignal(adapter *adapters.FailoverSQLAdapter) {
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGUSR1)
+    for range signals {
+        if err := adapter.Failback(); err != nil {
+            log.Printf("Failing back to the primary database: %v", err)
+            continue
+        }
+        log.Println("Using the primary database.")
+    }
+}
+
+// addDatabases connects the named SQL databases declared in config and registers them with the
+// ORM, returning their close functions.
+func addDatabases(ormLayer *orm.ORM, databases map[string]config.DatabaseConfig, retry adapters.RetryPolicy, timeoutMs int, deadlines adapters.Deadlines) []func() {
+    var closers []func()
+    for name, db := range databases {
+        adapter, err := adapters.NewSQLAdapter(db.DSN, db.Driver, retry)
+        if err != nil {
+            log.Fatalf("Failed to initialize SQL database %s: %v", name, err)
+        }
+        limitStatements(adapter, timeoutMs)
+        setDeadlines("SQL", adapter, deadlines)
+        ormLayer.AddDatabase(name, adapter)
+        closers = append(closers, func() { _ = adapter.Close() })
+    }
+    return closers
+}
+
+// addReplicas connects the read replicas declared in config and registers them with the ORM,
+// returning their close functions.
+func addReplicas(ormLayer *orm.ORM, replicas map[string]config.DatabaseConfig, retry adapters.RetryPolicy, timeoutMs int, deadlines adapters.Deadlines) []func() {
+    var closers []func()
+    for name, db := range replicas {
+        adapter, err := adapters.NewSQLAdapter(db.DSN, db.Driver, retry)
+        if err != nil {
+            log.Fatalf("Failed to initialize replica of SQL database %s: %v", name, err)
+        }
+        limitStatements(adapter, timeoutMs)
+        setDeadlines("SQL", adapter, deadlines)
+        ormLayer.AddReplica(name, adapter)
+        closers = append(closers, func() { _ = adapter.Close() })
+    }
+    return closers
+}
+
+// limitStatements enables statement timeouts on a SQL store, with timeoutMs as the default. They
+// are enabled even without a default so calls can still set one with ORM.WithStatementTimeout.
+func limitStatements(store adapters.StatementLimiter, timeoutMs int) {
+    if err := store.EnableStatementTimeouts(time.Duration(timeoutMs) * time.Millisecond); err != nil {
+        log.Fatalf("Failed to enable SQL statement timeouts: %v", err)
+    }
+}
+
+// modelsByDatabase groups the models by the SQL databases their policies route them to, their
+// database or each of their shards, and returns the sorted database names.
+func modelsByDatabase(ormLayer *orm.ORM) (map[string][]interface{}, []string) {
+    byDatabase := map[string][]interface{}{}
+    for _, model := range GetAllModels() {
+        policy := ormLayer.PolicyFor(model)
+        names := policy.Shards
+        if len(names) == 0 {
+            names = []string{policy.Database}
+        }
+        for _, name := range names {
+            byDatabase[name] = append(byDatabase[name], model)
+        }
+    }
+    names := make([]string, 0, len(byDatabase))
+    for name := range byDatabase {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return byDatabase, names
+}
+
+// migrateModels auto-migrates every model in the SQL databases its policy routes it to. The DDL of
+// every database is planned and logged first; nothing is applied if a plan has destructive steps
+// and the options don't allow them, or on a dry run.
+func migrateModels(ormLayer *orm.ORM, opts migrationOptions) error {
+    byDatabase, names := modelsByDatabase(ormLayer)
+    dbs := map[string]*gorm.DB{}
+    destructive := 0
+    for _, name := range names {
+        db, err := ormLayer.Database(name)
+        if err != nil {
+            return err
+        }
+        if db.SQL == nil {
+            continue
+        }
+        // DDL may run long on large tables, past the deadlines meant for requests.
+        gormDB := db.SQL.GetDB().WithContext(adapters.WithoutDeadlines(context.Background()))
+        steps, err := adapters.PlanMigration(gormDB, byDatabase[name]...)
+        if err != nil {
+            return err
+        }
+        if len(steps) == 0 {
+            continue
+        }
+        log.Printf("Migration plan for database %s:", name)
+        for _, step := range steps {
+            if step.Destructive {
+                destructive++
+                log.Printf("  DESTRUCTIVE (%s): %s", step.Reason, step.SQL)
+            } else {
+                log.Printf("  %s", step.SQL)
+            }
+        }
+        dbs[name] = gormDB
+    }
+    if destructive > 0 && !opts.AllowDestructive {
+        return fmt.Errorf("migration has %d destructive step(s); rerun with -allow-destructive to apply it", destructive)
+    }
+    if opts.DryRun {
+        return nil
+    }
+    for _, name := range names {
+        if db, ok := dbs[name]; ok {
+            if err := db.AutoMigrate(byDatabase[name]...); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// checkSchemaDrift compares the schema of every SQL database with its models, as configured by
+// mode: "log" logs each difference, "fail" also returns an error, "off" skips the check.
+func checkSchemaDrift(ormLayer *orm.ORM, mode string) error {
+    if mode == "off" {
+        return nil
+    }
+    byDatabase, names := modelsByDatabase(ormLayer)
+    drifted := 0
+    for _, name := range names {
+        db, err := ormLayer.Database(name)
+        if err != nil {
+            return err
+        }
+        if db.SQL == nil {
+            continue
+        }
+        drift, err := adapters.SchemaDrift(db.SQL.GetDB(), byDatabase[name]...)
+        if err != nil {
+            return err
+        }
+        for _, difference := range drift {
+            log.Printf("Schema drift in database %s: %s", name, difference)
+        }
+        drifted += len(drift)
+    }
+    if drifted > 0 && mode == "fail" {
+        return fmt.Errorf("schema differs from the models in %d place(s)", drifted)
+    }
+    return nil
+}
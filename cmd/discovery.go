@@ -0,0 +1,50 @@
+package main
+
+import (
+    "context"
+    "log"
+    "net"
+    "os"
+    "persistence-layer/config"
+    "persistence-layer/discovery"
+    "strconv"
+)
+
+// defaultServiceName is the name instances register under unless configured otherwise.
+const defaultServiceName = "persistence-layer"
+
+// registerService registers the gRPC server listening on port in the service catalog of cfg and
+// returns the function deregistering it. Registration failures are fatal: an instance clients
+// can't find would sit idle.
+func registerService(ctx context.Context, cfg config.DiscoveryConfig, port int) func() {
+    if cfg.Kind == "" {
+        return func() {}
+    }
+    advertise := cfg.Advertise
+    if advertise == "" {
+        host, err := os.Hostname()
+        if err != nil {
+            log.Fatalf("Failed to determine the address to advertise: %v", err)
+        }
+        advertise = net.JoinHostPort(host, strconv.Itoa(port))
+    }
+    name := cfg.ServiceName
+    if name == "" {
+        name = defaultServiceName
+    }
+    deregister, err := discovery.Register(ctx, discovery.Registration{
+        Kind:            cfg.Kind,
+        Address:         cfg.Address,
+        Token:           cfg.Token,
+        Name:            name,
+        Tags:            cfg.Tags,
+        Advertise:       advertise,
+        CheckInterval:   cfg.CheckInterval,
+        DeregisterAfter: cfg.DeregisterAfter,
+        Prefix:          cfg.EtcdPrefix,
+    })
+    if err != nil {
+        log.Fatalf("Failed to register with %s: %v", cfg.Kind, err)
+    }
+    return deregister
+}
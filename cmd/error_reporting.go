@@ -0,0 +1,36 @@
+package main
+
+import (
+    "context"
+    "log"
+    "persistence-layer/config"
+    "persistence-layer/utils"
+    "time"
+)
+
+// startErrorReporting sends the errors passed to utils.LogError to Sentry when a DSN is configured,
+// returning the function that waits for queued reports before exit.
+func startErrorReporting(cfg config.ErrorReportingConfig) func() {
+    if cfg.SentryDSN == "" {
+        return func() {}
+    }
+    reporter, err := utils.NewSentryReporter(utils.SentryOptions{
+        DSN:         cfg.SentryDSN,
+        Environment: cfg.Environment,
+        Release:     cfg.Release,
+        SampleRate:  cfg.SampleRate,
+        ScrubFields: cfg.ScrubFields,
+    })
+    if err != nil {
+        log.Fatalf("Failed to configure error reporting: %v", err)
+    }
+    utils.SetErrorReporter(reporter)
+    log.Printf("Reporting errors to Sentry (environment %q, release %q)", cfg.Environment, cfg.Release)
+    return func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := reporter.Flush(ctx); err != nil {
+            log.Printf("Flushing error reports: %v", err)
+        }
+    }
+}
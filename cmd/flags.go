@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "persistence-layer/config"
+    "persistence-layer/flags"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+    "time"
+)
+
+// startFlags makes the feature flags declared in config, with their Redis overrides, the default
+// flags store, evaluated per tenant, and refreshes the overrides in the background.
+func startFlags(ctx context.Context, ormLayer *orm.ORM, cfg config.FeatureFlagsConfig) *flags.Store {
+    store := flags.NewStore(toFlags(cfg.Flags), ormLayer.Redis)
+    store.Tenant = orm.TenantFromContext
+    if err := store.Refresh(); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Flags Refresh"})
+    }
+    flags.SetDefault(store)
+    interval := time.Duration(cfg.RefreshSeconds) * time.Second
+    if interval <= 0 {
+        interval = 10 * time.Second
+    }
+    if ormLayer.Redis != nil {
+        go store.Run(ctx, interval)
+    }
+    return store
+}
+
+// currentFlags returns the store set up by startFlags, for commands whose flag.FlagSet shadows the
+// flags package.
+func currentFlags() *flags.Store {
+    return flags.Default()
+}
+
+func toFlags(declared map[string]config.FlagConfig) map[string]flags.Flag {
+    out := make(map[string]flags.Flag, len(declared))
+    for name, flag := range declared {
+        out[name] = flags.Flag{Enabled: flag.Enabled, Percentage: flag.Percentage, Tenants: flag.Tenants}
+    }
+    return out
+}
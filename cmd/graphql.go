@@ -0,0 +1,29 @@
+package main
+
+import (
+    "log"
+    "net/http"
+    "persistence-layer/graphql"
+    "persistence-layer/orm"
+
+    "google.golang.org/grpc"
+)
+
+// startGraphQL serves every model through GraphQL at /graphql on addr, passing requests through
+// interceptor, the chain of the gRPC server. The returned server is shut down with the gRPC server.
+func startGraphQL(ormLayer *orm.ORM, addr string, interceptor grpc.UnaryServerInterceptor) *http.Server {
+    schema, err := graphql.NewSchema(GetAllModels()...)
+    if err != nil {
+        log.Fatalf("Failed to build the GraphQL schema: %v", err)
+    }
+    mux := http.NewServeMux()
+    mux.Handle("/graphql", graphql.Handler(ormLayer, schema, interceptor))
+    server := &http.Server{Addr: addr, Handler: mux}
+    go func() {
+        log.Printf("GraphQL server listening on %s", addr)
+        if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Printf("GraphQL server stopped: %v", err)
+        }
+    }()
+    return server
+}
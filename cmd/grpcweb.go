@@ -0,0 +1,32 @@
+package main
+
+import (
+    "log"
+    "net/http"
+    "persistence-layer/config"
+    "persistence-layer/grpcweb"
+    "persistence-layer/interceptors"
+
+    "google.golang.org/grpc"
+)
+
+// startGRPCWeb serves the services registered on grpcServer to browsers through gRPC-Web on
+// cfg.Addr. Browsers may send their bearer token and session token besides cfg.AllowedHeaders;
+// the identity of a call comes from the token only. The returned server is shut down with the gRPC
+// server.
+func startGRPCWeb(grpcServer *grpc.Server, cfg config.GRPCWebConfig) *http.Server {
+    headers := append([]string{interceptors.SessionHeader}, cfg.AllowedHeaders...)
+    handler := grpcweb.Handler(grpcServer, grpcweb.Options{
+        AllowedOrigins: cfg.AllowedOrigins,
+        AllowedHeaders: headers,
+        MaxAge:         cfg.MaxAgeSeconds,
+    })
+    server := &http.Server{Addr: cfg.Addr, Handler: handler}
+    go func() {
+        log.Printf("gRPC-Web server listening on %s", cfg.Addr)
+        if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Printf("gRPC-Web server stopped: %v", err)
+        }
+    }()
+    return server
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+    "persistence-layer/config"
+    "persistence-layer/utils"
+    "sync/atomic"
+    "time"
+)
+
+// slowOperationNanos is the threshold of the slow operation log, which changes on reload.
+var slowOperationNanos int64
+
+// applyLogSampling applies the sampling settings of info logs and the slow operation threshold.
+func applyLogSampling(cfg config.LoggingConfig) {
+    messages := make(map[string]utils.LogRule, len(cfg.Messages))
+    for message, rule := range cfg.Messages {
+        messages[message] = utils.LogRule{SampleRate: rule.SampleRate, MaxPerSecond: rule.MaxPerSecond}
+    }
+    utils.SetLogSampling(utils.LogSampling{
+        DisableInfo: cfg.DisableSuccess,
+        Default:     utils.LogRule{SampleRate: cfg.SampleRate, MaxPerSecond: cfg.MaxPerSecond},
+        Messages:    messages,
+    })
+    atomic.StoreInt64(&slowOperationNanos, int64(time.Duration(cfg.SlowOperationMs)*time.Millisecond))
+}
+
+// slowOperationThreshold returns the current slow operation threshold for orm.LogSlowOperations.
+func slowOperationThreshold() time.Duration {
+    return time.Duration(atomic.LoadInt64(&slowOperationNanos))
+}
@@ -1,43 +1,47 @@
 package main
 
 import (
+    "context"
+    "flag"
+    "fmt"
     "log"
+    "math"
     "net"
+    "net/http"
+    "os"
+    "os/signal"
     "persistence-layer/adapters"
+    "persistence-layer/admin"
     "persistence-layer/config"
+    "persistence-layer/dataloader"
+    "persistence-layer/interceptors"
     "persistence-layer/models"
     "persistence-layer/orm"
     "persistence-layer/services"
     "persistence-layer/utils"
     "google.golang.org/grpc"
-    "reflect"
+    "google.golang.org/grpc/health"
+    healthpb "google.golang.org/grpc/health/grpc_health_v1"
+    "syscall"
+    "time"
 )
 
-// RegisterableService is an interface that requires services to have a Register method.
-type RegisterableService interface {
-    Register(server *grpc.Server)
-}
+// grpcPort is the port the gRPC server listens on.
+const grpcPort = 50051
 
-// GetAllServices returns a list of all RegisterableService implementations.
-func GetAllServices(ormLayer *orm.ORM) []RegisterableService {
-    return []RegisterableService{
-        services.NewTagServiceServerImpl(ormLayer),
-        services.NewPosttagServiceServerImpl(ormLayer),
-        services.NewPostServiceServerImpl(ormLayer),
-        services.NewUserServiceServerImpl(ormLayer),
-        services.NewCategoryServiceServerImpl(ormLayer),
-        services.NewCommentServiceServerImpl(ormLayer),
-        services.NewProductServiceServerImpl(ormLayer),
-    }
+// serviceShutdownTimeout bounds the Shutdown hooks of the services.
+const serviceShutdownTimeout = 30 * time.Second
 
-}
-
-// RegisterAllServices dynamically registers all services that implement RegisterableService.
-func RegisterAllServices(server *grpc.Server, ormLayer *orm.ORM) {
-    for _, service := range GetAllServices(ormLayer) {
-        serviceType := reflect.TypeOf(service).Elem().Name()
-        service.Register(server)
-        println("Registered service:", serviceType)
+// GetAllModels returns every model managed by the persistence layer, in migration order.
+func GetAllModels() []interface{} {
+    return []interface{}{
+        &models.Product{},
+        &models.Comment{},
+        &models.Posttag{},
+        &models.User{},
+        &models.Tag{},
+        &models.Category{},
+        &models.Post{},
     }
 }
 
@@ -46,54 +50,350 @@ func main() {
     utils.InitLogger()
 
     // Load configuration
-    cfg, err := config.LoadConfigFromFile("config/config.yaml")
+    var args []string
+    configLoader, args = parseGlobalFlags()
+    cfg, err := configLoader.Load()
     if err != nil {
         utils.LogError(err, map[string]interface{}{"context": "config"})
         log.Fatalf("Failed to load configuration: %v", err)
     }
+    if err := utils.SetLogLevel(cfg.Logging.Level); err != nil {
+        log.Fatalf("Invalid logging level: %v", err)
+    }
+    if err := utils.SetParamLogMode(utils.ParamLogMode(cfg.Logging.SQLParams), cfg.Logging.ParamHashKey); err != nil {
+        log.Fatalf("Invalid logging configuration: %v", err)
+    }
+    applyLogSampling(cfg.Logging)
+    flushErrorReports := startErrorReporting(cfg.ErrorReporting)
+    defer flushErrorReports()
 
-    // Initialize Adapters
-    sqlAdapter := adapters.NewSQLAdapter(cfg.MySQLDSN, "mysql")
-    mongoAdapter := adapters.NewMongoAdapter(cfg.MongoURI)
-    redisAdapter := adapters.NewRedisAdapter(cfg.RedisURI)
-    esAdapter := adapters.NewESAdapter(cfg.ElasticsearchURI)
+    command := "serve"
+    if len(args) > 0 {
+        command, args = args[0], args[1:]
+    }
 
-    defer sqlAdapter.Close()
-    defer mongoAdapter.Disconnect()
-    defer redisAdapter.Close()
-    defer esAdapter.Close()
+    switch command {
+    case "serve":
+        runServer(cfg, args)
+    case "seed":
+        runSeed(withoutSQLTimeouts(cfg), args)
+    case "bench":
+        runBench(cfg, args)
+    case "reindex":
+        runReindex(withoutSQLTimeouts(cfg), args)
+    case "migrate":
+        runMigrate(withoutSQLTimeouts(cfg), args)
+    case "backup":
+        runBackup(withoutSQLTimeouts(cfg), args)
+    case "restore":
+        runRestore(withoutSQLTimeouts(cfg), args)
+    case "webhook":
+        runWebhook(cfg, args)
+    case "rbac":
+        runRBAC(cfg, args)
+    case "config":
+        runConfig(cfg, args)
+    default:
+        log.Fatalf("Unknown command %q (expected serve, seed, bench, reindex, migrate, backup, restore, webhook, rbac or config)", command)
+    }
+}
+
+// initORM connects every enabled backend, runs auto-migration as migration allows and returns the
+// ORM together with a cleanup function that closes the adapters.
+func initORM(cfg *config.Config, migration migrationOptions) (*orm.ORM, func()) {
+    var err error
+    var closers []func()
+    cleanup := func() {
+        for i := len(closers) - 1; i >= 0; i-- {
+            closers[i]()
+        }
+    }
+
+    // Initialize Adapters concurrently, skipping any backend disabled in config.
+    var sqlAdapter adapters.SQLStore
+    var mongoAdapter *adapters.MongoAdapter
+    var redisAdapter *adapters.RedisAdapter
+    var esAdapter *adapters.ESAdapter
+    var connects []backendConnect
+    if cfg.BackendEnabled(orm.BackendSQL) {
+        connects = append(connects, backendConnect{orm.BackendSQL, func(retry adapters.RetryPolicy) (err error) {
+            sqlAdapter, err = openSQL(cfg, retry)
+            return err
+        }})
+    }
+    if cfg.BackendEnabled(orm.BackendMongo) {
+        connects = append(connects, backendConnect{orm.BackendMongo, func(retry adapters.RetryPolicy) (err error) {
+            mongoAdapter, err = adapters.NewMongoAdapter(cfg.MongoURI, retry)
+            return err
+        }})
+    }
+    if cfg.BackendEnabled(orm.BackendRedis) {
+        connects = append(connects, backendConnect{orm.BackendRedis, func(retry adapters.RetryPolicy) (err error) {
+            redisAdapter, err = adapters.NewRedisAdapterWithOptions(cfg.RedisURI, retry, redisOptions(cfg))
+            return err
+        }})
+    }
+    if cfg.BackendEnabled(orm.BackendElasticsearch) {
+        connects = append(connects, backendConnect{orm.BackendElasticsearch, func(retry adapters.RetryPolicy) (err error) {
+            esAdapter, err = adapters.NewESAdapterWithOptions(esOptions(cfg), retry)
+            return err
+        }})
+    }
+    connectBackends(cfg.Startup, connects)
+    if sqlAdapter != nil {
+        closers = append(closers, func() { _ = sqlAdapter.Close() })
+    }
+    if mongoAdapter != nil {
+        setDeadlines("MongoDB", mongoAdapter, adapters.Deadlines{Read: cfg.Timeouts.MongoRead, Write: cfg.Timeouts.MongoWrite})
+        closers = append(closers, mongoAdapter.Disconnect)
+    }
+    if redisAdapter != nil {
+        setDeadlines("Redis", redisAdapter, adapters.Deadlines{Read: cfg.Timeouts.Redis, Write: cfg.Timeouts.Redis})
+        closers = append(closers, func() { _ = redisAdapter.Close() })
+    }
+    if esAdapter != nil {
+        setDeadlines("Elasticsearch", esAdapter, adapters.Deadlines{Read: cfg.Timeouts.ESSearch, Write: cfg.Timeouts.ESWrite})
+        closers = append(closers, func() { _ = esAdapter.Close() })
+    }
+    // The additional databases and replicas connect once the ORM they register with exists.
+    retry := backendRetry(cfg.Startup, orm.BackendSQL)
 
     // ORM layer setup
     ormLayer := orm.NewORM(sqlAdapter, mongoAdapter, redisAdapter, esAdapter)
-	                                                                    // Run GORM auto-migration for your models here
-    db := sqlAdapter.GetDB()
-    err = db.AutoMigrate(
-        &models.Product{},
-        &models.Comment{},
-        &models.Posttag{},
-        &models.User{},
-        &models.Tag{},
-        &models.Category{},
-        &models.Post{},
-    )
-    if err != nil {
-        log.Fatalf("Failed to auto migrate models: %v", err)
+    ormLayer.Use(orm.LogSlowOperations(slowOperationThreshold))
+    if cfg.Transactions.MaxAttempts > 0 {
+        ormLayer.TxRetry.MaxAttempts = cfg.Transactions.MaxAttempts
+    }
+    if cfg.Transactions.RetryBackoffMs > 0 {
+        ormLayer.TxRetry.InitialBackoff = time.Duration(cfg.Transactions.RetryBackoffMs) * time.Millisecond
+    }
+    if cfg.Redis.TTLJitter != 0 {
+        ormLayer.CacheJitter = math.Max(cfg.Redis.TTLJitter, 0)
     }
-    log.Println("Auto migration completed successfully.")
+    if cfg.Elasticsearch.BreakerFailures > 0 {
+        openFor := time.Duration(cfg.Elasticsearch.BreakerOpenSeconds) * time.Second
+        if openFor <= 0 {
+            openFor = 30 * time.Second
+        }
+        ormLayer.SearchBreaker = orm.NewCircuitBreaker(orm.BackendElasticsearch, cfg.Elasticsearch.BreakerFailures, openFor)
+    }
+    for operation, ms := range cfg.HedgeAfterMs {
+        ormLayer.SetHedge(operation, time.Duration(ms)*time.Millisecond)
+    }
+    closers = append(closers, addDatabases(ormLayer, cfg.Databases, retry, cfg.StatementTimeoutMs, sqlDeadlines(cfg.Timeouts))...)
+    closers = append(closers, addReplicas(ormLayer, cfg.Replicas, retry, cfg.StatementTimeoutMs, sqlDeadlines(cfg.Timeouts))...)
+    if cfg.ReplicaLagSeconds > 0 {
+        ormLayer.ReplicaLag = time.Duration(cfg.ReplicaLagSeconds) * time.Second
+    }
+    applyPolicies(ormLayer, cfg.Policies)
+    if cfg.Localization.DefaultLocale != "" {
+        ormLayer.Locales.Default = cfg.Localization.DefaultLocale
+    }
+    ormLayer.Locales.Fallbacks = cfg.Localization.Fallbacks
+    applyPagination(ormLayer, cfg.Pagination)
+    ormLayer.Webhooks = orm.WebhookPolicy{SecretKey: []byte(cfg.Webhooks.SecretKey), AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks}
+    if cfg.Chaos.Enabled {
+        rules := make(map[string]orm.FaultRule, len(cfg.Chaos.Backends))
+        for backend, rule := range cfg.Chaos.Backends {
+            rules[backend] = orm.FaultRule{
+                LatencyRate: rule.LatencyRate,
+                Latency:     time.Duration(rule.LatencyMs) * time.Millisecond,
+                ErrorRate:   rule.ErrorRate,
+                DropRate:    rule.DropRate,
+            }
+        }
+        ormLayer.Use(orm.FaultInjector(rules))
+        log.Printf("Chaos mode enabled: injecting faults into %d backend(s)", len(rules))
+    }
+
+    if failover, ok := sqlAdapter.(*adapters.FailoverSQLAdapter); ok && failover.Status().ReadOnly {
+        log.Println("Started on the read-only standby database; skipping auto migration.")
+    } else if sqlAdapter != nil {
+        // Run GORM auto-migration for your models here
+        err = migrateModels(ormLayer, migration)
+        if err != nil {
+            log.Fatalf("Failed to auto migrate models: %v", err)
+        }
+        if migration.DryRun {
+            return ormLayer, cleanup // Before the internal tables and search indices are created.
+        }
+        log.Println("Auto migration completed successfully.")
+        if usesOutbox(cfg) {
+            if err := ormLayer.EnableOutbox(); err != nil {
+                log.Fatalf("Failed to migrate outbox table: %v", err)
+            }
+        }
+        if cfg.Localization.Translations {
+            if err := ormLayer.EnableTranslations(); err != nil {
+                log.Fatalf("Failed to migrate translations table: %v", err)
+            }
+        }
+        enableWebhooks(ormLayer, cfg.Webhooks)
+    }
+    if sqlAdapter != nil {
+        if err := checkSchemaDrift(ormLayer, cfg.SchemaDrift); err != nil {
+            log.Fatalf("Schema drift check failed: %v", err)
+        }
+    }
+    ensureIndices(ormLayer, cfg.Policies)
+    startFlags(context.Background(), ormLayer, cfg.FeatureFlags)
+
+    return ormLayer, cleanup
+}
+
+// esOptions builds the Elasticsearch client options from config, falling back to es_uri, which may
+// list several comma-separated nodes.
+func esOptions(cfg *config.Config) adapters.ESOptions {
+    es := cfg.Elasticsearch
+    addresses := es.Addresses
+    if len(addresses) == 0 {
+        addresses = adapters.ESAddresses(cfg.ElasticsearchURI)
+    }
+    return adapters.ESOptions{
+        Flavor:          es.Flavor,
+        Addresses:       addresses,
+        MaxRetries:      es.MaxRetries,
+        RetryBackoff:    time.Duration(es.RetryBackoffMs) * time.Millisecond,
+        MaxRetryBackoff: time.Duration(es.MaxRetryBackoffMs) * time.Millisecond,
+        RequestTimeout:  time.Duration(es.RequestTimeoutMs) * time.Millisecond,
+        SniffOnStart:    es.SniffOnStart,
+        SniffInterval:   time.Duration(es.SniffIntervalSeconds) * time.Second,
+    }
+}
+
+// redisOptions namespaces cache keys by app, environment and tenant and versions them.
+func redisOptions(cfg *config.Config) adapters.RedisOptions {
+    return adapters.RedisOptions{
+        Namespace:         []string{cfg.Redis.App, cfg.Redis.Env, cfg.Redis.Tenant},
+        SchemaVersion:     cfg.Redis.SchemaVersion,
+        MaxPatternDeletes: cfg.Redis.MaxPatternDeletes,
+    }
+}
+
+// runServer starts the background workers and serves the gRPC API until the process exits.
+func runServer(cfg *config.Config, args []string) {
+    flags := flag.NewFlagSet("serve", flag.ExitOnError)
+    migration := migrationFlags(flags)
+    _ = flags.Parse(args)
+
+    ormLayer, cleanup := initORM(cfg, *migration)
+    defer cleanup()
+
+    // Start background maintenance workers.
+    startArchiver(context.Background(), ormLayer, cfg.Retention)
+    startPartitioner(context.Background(), ormLayer, cfg.Partitioning)
+    startOutboxRelay(context.Background(), ormLayer, cfg)
+    startIndexPool(context.Background(), ormLayer, cfg.IndexPool)
+    startCDC(context.Background(), ormLayer, cfg.CDC)
+    startWebhookDispatcher(context.Background(), ormLayer, cfg.Webhooks)
+    startAuditExport(context.Background(), ormLayer, cfg.Audit)
+    startSearchChecks(context.Background(), ormLayer, cfg.Consistency)
+    startCacheChecks(context.Background(), ormLayer, cfg.Consistency)
+    startCacheRefresher(context.Background(), ormLayer, cfg.Redis.Refresh)
+    tracker := startUsageTracking(ormLayer, cfg.Quotas)
+    hotKeys := startHotKeyTracking(ormLayer, cfg.Redis.HotKeys)
+
+    // Metrics are published through expvar at /debug/vars.
+    if cfg.MetricsAddr != "" {
+        go func() {
+            if err := http.ListenAndServe(cfg.MetricsAddr, nil); err != nil {
+                log.Printf("Metrics server stopped: %v", err)
+            }
+        }()
+    }
+
     // gRPC server setup
-    grpcServer := grpc.NewServer()
+    loaderConfig := dataloader.Config{
+        Wait:     time.Duration(cfg.Dataloader.WaitMs) * time.Millisecond,
+        MaxBatch: cfg.Dataloader.MaxBatch,
+        CacheTTL: time.Duration(cfg.Dataloader.CacheTTLSeconds) * time.Second,
+    }
+    verifier := newVerifier(cfg.Auth)
+    unary := []grpc.UnaryServerInterceptor{panicRecovery(), interceptors.Authenticate(verifier), interceptors.Tenant(cfg.Quotas.Enabled && cfg.Quotas.Enforce), interceptors.Actor(), interceptors.Session()}
+    var serverOptions []grpc.ServerOption
+    if cfg.MethodLimits.Enabled {
+        limiter := methodLimiter(cfg.MethodLimits)
+        unary = append(unary, limiter.Interceptor())
+        if limit := limiter.MaxRequestBytes(); limit > 0 {
+            serverOptions = append(serverOptions, grpc.MaxRecvMsgSize(limit))
+        }
+    }
+    limits := newRateLimits(cfg.RateLimit)
+    if limiter, ok := ormLayer.Redis.(adapters.RateLimiter); ok && cfg.RateLimit.Enabled {
+        unary = append(unary, interceptors.DynamicRateLimit(limiter, limits.get))
+    }
+    if cfg.RBAC.Enabled {
+        unary = append(unary, startRBAC(context.Background(), ormLayer, cfg.RBAC))
+    }
+    // Validate after RBAC, so callers denied a method learn nothing of its constraints.
+    unary = append(unary, interceptors.Validate())
+    if cfg.QueryBudget.Enabled {
+        unary = append(unary, queryBudget(ormLayer, cfg.QueryBudget))
+    }
+    unary = append(unary, interceptors.Dataloaders(ormLayer, loaderConfig))
+    grpcServer := grpc.NewServer(append(serverOptions, grpc.ChainUnaryInterceptor(unary...))...)
+    watchConfig(cfg, ormLayer, limits)
 
-    // Dynamically register all services with the gRPC server.
-    RegisterAllServices(grpcServer, ormLayer)
+    // Register the services added by the generated service files, in name order.
+    registered := services.Build(ormLayer)
+    registered.Register(grpcServer)
+
+    adminService := admin.NewService()
+    if tracker != nil {
+        admin.HandleUsage(adminService, tracker)
+    }
+    if hotKeys != nil {
+        admin.HandleHotKeys(adminService, hotKeys)
+    }
+    admin.HandleFlags(adminService, currentFlags())
+    adminService.Register(grpcServer)
+
+    // The standard health service answers the checks of service discovery and load balancers.
+    healthpb.RegisterHealthServer(grpcServer, health.NewServer())
 
     // Start listening on port 50051
-    listener, err := net.Listen("tcp", ":50051")
+    listener, err := net.Listen("tcp", fmt.Sprintf(":%d", grpcPort))
     if err != nil {
-        log.Fatalf("Failed to listen on port 50051: %v", err)
+        log.Fatalf("Failed to listen on port %d: %v", grpcPort, err)
+    }
+
+    // Leave the service catalog and drain in-flight calls on SIGINT or SIGTERM.
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    if err := registered.Init(ctx); err != nil {
+        log.Fatalf("Failed to initialize services: %v", err)
+    }
+
+    // The HTTP gateways serve only once the services are initialized, and stop with the gRPC server.
+    var gateways []*http.Server
+    if cfg.GRPCWeb.Addr != "" {
+        gateways = append(gateways, startGRPCWeb(grpcServer, cfg.GRPCWeb))
+    }
+    if cfg.GraphQLAddr != "" {
+        gateways = append(gateways, startGraphQL(ormLayer, cfg.GraphQLAddr, interceptors.Chain(unary...)))
     }
+    deregister := registerService(ctx, cfg.Discovery, grpcPort)
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        <-ctx.Done()
+        deregister()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), serviceShutdownTimeout)
+        defer cancel()
+        for _, gateway := range gateways {
+            if err := gateway.Shutdown(shutdownCtx); err != nil {
+                log.Printf("Failed to shut down the server on %s: %v", gateway.Addr, err)
+            }
+        }
+        grpcServer.GracefulStop()
+        if err := registered.Shutdown(shutdownCtx); err != nil {
+            log.Printf("Failed to shut down services: %v", err)
+        }
+    }()
 
-    log.Println("gRPC server listening on port 50051")
+    log.Printf("gRPC server listening on port %d", grpcPort)
     if err := grpcServer.Serve(listener); err != nil {
         log.Fatalf("Failed to serve gRPC: %v", err)
     }
+    <-stopped
 }
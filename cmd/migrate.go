@@ -0,0 +1,35 @@
+package main
+
+import (
+    "flag"
+    "log"
+    "persistence-layer/config"
+)
+
+// migrationOptions controls the auto-migration run by initORM.
+type migrationOptions struct {
+    DryRun           bool // Log the planned DDL without applying it.
+    AllowDestructive bool // Apply plans that drop tables or columns or narrow column types.
+}
+
+// migrationFlags registers the flags shared by commands that migrate, e.g. `serve -allow-destructive`.
+func migrationFlags(flags *flag.FlagSet) *migrationOptions {
+    opts := &migrationOptions{}
+    flags.BoolVar(&opts.AllowDestructive, "allow-destructive", false, "apply migrations that may lose data")
+    return opts
+}
+
+// runMigrate migrates the SQL databases and exits, e.g. `migrate -dry-run` to review the DDL a
+// deploy would run.
+func runMigrate(cfg *config.Config, args []string) {
+    flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+    opts := migrationFlags(flags)
+    flags.BoolVar(&opts.DryRun, "dry-run", false, "log the planned DDL without applying it")
+    _ = flags.Parse(args)
+
+    _, cleanup := initORM(cfg, *opts)
+    defer cleanup()
+    if opts.DryRun {
+        log.Println("Dry run: no migration applied.")
+    }
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+    "context"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "time"
+)
+
+// startPartitioner launches the partition rotation job in the background when it is enabled in config.
+func startPartitioner(ctx context.Context, ormLayer *orm.ORM, cfg config.PartitioningConfig) {
+    if !cfg.Enabled || len(cfg.Tables) == 0 {
+        return
+    }
+
+    specs := make([]orm.PartitionSpec, 0, len(cfg.Tables))
+    for _, t := range cfg.Tables {
+        specs = append(specs, orm.PartitionSpec{
+            Table:         t.Table,
+            PremakeMonths: t.PremakeMonths,
+            RetainMonths:  t.RetainMonths,
+        })
+    }
+
+    interval := time.Duration(cfg.IntervalHours) * time.Hour
+    if interval <= 0 {
+        interval = 24 * time.Hour
+    }
+
+    go orm.NewPartitioner(ormLayer, specs...).Run(ctx, interval)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "log"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "reflect"
+    "time"
+)
+
+// applyPolicies declares the persistence policy of every model configured under policies, keyed by
+// model type name, e.g. "Product".
+func applyPolicies(ormLayer *orm.ORM, policies map[string]config.PolicyConfig) {
+    for _, model := range GetAllModels() {
+        name := modelTypeName(model)
+        cfg, ok := policies[name]
+        if !ok {
+            continue
+        }
+        policy := orm.Policy{
+            Collection: cfg.Collection,
+            Cache:      cfg.Cache,
+            CacheTTL:   time.Duration(cfg.CacheTTLSeconds) * time.Second,
+            Index:      cfg.Index,
+            AsyncIndex: cfg.AsyncIndex,
+            Database:   cfg.Database,
+            Shards:     cfg.Shards,
+            ShardKey:   cfg.ShardKey,
+        }
+        for _, backend := range cfg.Backends {
+            switch backend {
+            case orm.BackendSQL:
+                policy.SQL = true
+            case orm.BackendMongo:
+                policy.Mongo = true
+            default:
+                log.Fatalf("Invalid policy for %s: unknown backend %q", name, backend)
+            }
+        }
+        if len(cfg.Backends) == 0 {
+            policy.SQL = true
+        }
+        if err := ormLayer.SetPolicy(model, policy); err != nil {
+            log.Fatalf("Invalid policy for %s: %v", name, err)
+        }
+        if cfg.Owner.Field != "" {
+            rule := orm.OwnershipRule{Field: cfg.Owner.Field, Operations: cfg.Owner.Operations, AdminRoles: cfg.Owner.AdminRoles}
+            if err := ormLayer.SetOwnership(model, rule); err != nil {
+                log.Fatalf("Invalid policy for %s: %v", name, err)
+            }
+        }
+    }
+}
+
+// ensureIndices creates the Elasticsearch index of every policy that has one, with the mapping
+// derived from the model's search tags. Failures are logged; writes still index dynamically.
+func ensureIndices(ormLayer *orm.ORM, policies map[string]config.PolicyConfig) {
+    if ormLayer.Elasticsearch == nil {
+        return
+    }
+    for _, model := range GetAllModels() {
+        name := modelTypeName(model)
+        cfg, ok := policies[name]
+        if !ok || cfg.Index == "" {
+            continue
+        }
+        if err := ormLayer.EnsureIndex(cfg.Index, model); err != nil {
+            log.Printf("Failed to create index %s for %s: %v", cfg.Index, name, err)
+        }
+    }
+}
+
+// modelTypeName returns the type name models are configured by, e.g. "Product".
+func modelTypeName(model interface{}) string {
+    return reflect.Indirect(reflect.ValueOf(model)).Type().Name()
+}
+
+// asyncIndexing reports whether any policy indexes through the outbox.
+func asyncIndexing(policies map[string]config.PolicyConfig) bool {
+    for _, p := range policies {
+        if p.AsyncIndex && p.Index != "" {
+            return true
+        }
+    }
+    return false
+}
+
+// dualStored reports whether any policy stores records in both SQL and Mongo, whose failed Mongo
+// writes are repaired through the outbox.
+func dualStored(policies map[string]config.PolicyConfig) bool {
+    for _, p := range policies {
+        var sql, mongo bool
+        for _, backend := range p.Backends {
+            sql = sql || backend == "sql"
+            mongo = mongo || backend == "mongo"
+        }
+        if sql && mongo {
+            return true
+        }
+    }
+    return false
+}
+
+// usesOutbox reports whether changes go through the outbox: the index changes of policies indexing
+// asynchronously or those the index pool has no room for, and the repairs of Mongo copies.
+func usesOutbox(cfg *config.Config) bool {
+    return asyncIndexing(cfg.Policies) || dualStored(cfg.Policies) || cfg.IndexPool.Enabled && cfg.IndexPool.Overflow == orm.OverflowOutbox
+}
+
+// startOutboxRelay relays outbox events to Elasticsearch and Mongo when changes go through the
+// outbox, with one relay per database since events are written next to the records they index.
+func startOutboxRelay(ctx context.Context, ormLayer *orm.ORM, cfg *config.Config) {
+    if !usesOutbox(cfg) || ormLayer.Elasticsearch == nil && ormLayer.Mongo == nil {
+        return
+    }
+    interval := time.Duration(cfg.Outbox.IntervalMs) * time.Millisecond
+    if interval <= 0 {
+        interval = time.Second
+    }
+    for _, name := range ormLayer.DatabaseNames() {
+        db, err := ormLayer.Database(name)
+        if err != nil {
+            log.Fatalf("Failed to start the outbox relay of database %s: %v", name, err)
+        }
+        relay := orm.NewOutboxRelay(db)
+        if cfg.Outbox.BatchSize > 0 {
+            relay.BatchSize = cfg.Outbox.BatchSize
+        }
+        if cfg.Outbox.MaxAttempts > 0 {
+            relay.MaxAttempts = cfg.Outbox.MaxAttempts
+        }
+        if cfg.Outbox.CheckpointName != "" {
+            relay.Name = cfg.Outbox.CheckpointName
+        }
+        if cfg.Outbox.BackoffMs > 0 {
+            relay.Backoff = time.Duration(cfg.Outbox.BackoffMs) * time.Millisecond
+        }
+        if cfg.Outbox.MaxBackoffMs > 0 {
+            relay.MaxBackoff = time.Duration(cfg.Outbox.MaxBackoffMs) * time.Millisecond
+        }
+        go relay.Run(ctx, interval)
+    }
+}
+
+// startIndexPool applies the index changes of writes in the background when enabled, publishing
+// the pool's queue depth, lag and counts as the "index_pool" expvar.
+func startIndexPool(ctx context.Context, ormLayer *orm.ORM, cfg config.IndexPoolConfig) {
+    if !cfg.Enabled || ormLayer.Elasticsearch == nil {
+        return
+    }
+    pool := orm.NewIndexPool(ormLayer, cfg.Workers, cfg.QueueSize)
+    if cfg.Overflow != "" {
+        pool.Overflow = cfg.Overflow
+    }
+    ormLayer.IndexPool = pool
+    expvar.Publish("index_pool", expvar.Func(func() interface{} { return pool.Stats() }))
+    go pool.Run(ctx)
+    log.Printf("Index pool enabled (overflow=%s)", pool.Overflow)
+}
+
+// startCDC applies the change events Debezium captures for the models configured under
+// cdc.streams, keeping their cache and index in sync with writes made outside the ORM.
+func startCDC(ctx context.Context, ormLayer *orm.ORM, cfg config.CDCConfig) {
+    if !cfg.Enabled || ormLayer.Redis == nil {
+        return
+    }
+    group := cfg.Group
+    if group == "" {
+        group = "cdc"
+    }
+    consumer := orm.NewCDCConsumer(ormLayer, group)
+    for _, model := range GetAllModels() {
+        stream, ok := cfg.Streams[modelTypeName(model)]
+        if !ok {
+            continue
+        }
+        queue, err := consumer.Watch(stream, model)
+        if err != nil {
+            log.Fatalf("Invalid CDC stream for %s: %v", modelTypeName(model), err)
+        }
+        queue.MaxDeliveries = cfg.MaxDeliveries
+    }
+    go func() {
+        if err := consumer.Consume(ctx); err != nil && ctx.Err() == nil {
+            log.Printf("CDC consumer stopped: %v", err)
+        }
+    }()
+}
+
+// applyPagination overrides the ORM's default pagination policy with the settings given in config.
+func applyPagination(ormLayer *orm.ORM, cfg config.PaginationConfig) {
+    if cfg.DefaultSize > 0 {
+        ormLayer.Pagination.DefaultSize = cfg.DefaultSize
+    }
+    if cfg.MaxSize > 0 {
+        ormLayer.Pagination.MaxSize = cfg.MaxSize
+    }
+    if cfg.Count != "" {
+        ormLayer.Pagination.Count = orm.CountStrategy(cfg.Count)
+    }
+    if cfg.CountCap > 0 {
+        ormLayer.Pagination.CountCap = cfg.CountCap
+    }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "expvar"
+    "log"
+    "persistence-layer/config"
+    "persistence-layer/interceptors"
+    "persistence-layer/orm"
+    "time"
+
+    "google.golang.org/grpc"
+)
+
+// startUsageTracking installs the per-tenant usage middleware when quotas are enabled and publishes
+// the usage as the "tenant_usage" expvar. It returns nil when quotas are disabled.
+func startUsageTracking(ormLayer *orm.ORM, cfg config.QuotaConfig) *orm.UsageTracker {
+    if !cfg.Enabled {
+        return nil
+    }
+    quotas := make(map[string]orm.Quota, len(cfg.Tenants))
+    for tenant, q := range cfg.Tenants {
+        quotas[tenant] = toQuota(q)
+    }
+    tracker, err := orm.NewUsageTracker(ormLayer.Redis, toQuota(cfg.Default), quotas, cfg.Enforce)
+    if err != nil {
+        log.Fatalf("Failed to start usage tracking: %v", err)
+    }
+    tracker.Window = time.Duration(cfg.WindowSeconds) * time.Second
+    ormLayer.Use(tracker.Middleware())
+    expvar.Publish("tenant_usage", expvar.Func(func() interface{} { return tracker.Snapshot() }))
+    log.Printf("Tenant usage tracking enabled (enforce=%t)", cfg.Enforce)
+    return tracker
+}
+
+func toQuota(q config.TenantQuota) orm.Quota {
+    return orm.Quota{
+        MaxRequests:     q.MaxRequests,
+        MaxRows:         q.MaxRows,
+        MaxStorageBytes: q.MaxStorageBytes,
+        MaxCacheBytes:   q.MaxCacheBytes,
+    }
+}
+
+// startHotKeyTracking records the cached reads of the ORM when enabled and publishes the stats as
+// the "cache_hot_keys" expvar. It returns nil when disabled.
+func startHotKeyTracking(ormLayer *orm.ORM, cfg config.HotKeysConfig) *orm.HotKeyTracker {
+    if !cfg.Enabled || ormLayer.Redis == nil {
+        return nil
+    }
+    tracker := orm.NewHotKeyTracker(cfg.SampleRate)
+    if cfg.Capacity > 0 {
+        tracker.Capacity = cfg.Capacity
+    }
+    if cfg.WindowMins > 0 {
+        tracker.Window = time.Duration(cfg.WindowMins) * time.Minute
+    }
+    ormLayer.HotKeys = tracker
+    expvar.Publish("cache_hot_keys", expvar.Func(func() interface{} { return tracker.Snapshot() }))
+    return tracker
+}
+
+// queryBudget counts the ORM operations of every gRPC call, returning the interceptor that warns
+// about calls over budget and counts them by method in the "query_budget_exceeded" expvar.
+func queryBudget(ormLayer *orm.ORM, cfg config.QueryBudgetConfig) grpc.UnaryServerInterceptor {
+    budget := cfg.MaxCalls
+    if budget <= 0 {
+        budget = 50
+    }
+    ormLayer.Use(orm.CountCalls())
+    exceeded := expvar.NewMap("query_budget_exceeded")
+    return interceptors.QueryBudget(budget, func(method string, calls int) { exceeded.Add(method, 1) })
+}
+
+// methodLimiter enforces the configured size limits and latency SLOs of gRPC calls, publishing the
+// counts of each method as the "method_limits" expvar.
+func methodLimiter(cfg config.MethodLimitsConfig) *interceptors.MethodLimiter {
+    limits := func(c config.MethodLimitConfig) interceptors.MethodLimits {
+        return interceptors.MethodLimits{
+            MaxRequestBytes:  c.MaxRequestBytes,
+            MaxResponseBytes: c.MaxResponseBytes,
+            LatencySLO:       time.Duration(c.LatencySLOMs) * time.Millisecond,
+        }
+    }
+    methods := make(map[string]interceptors.MethodLimits, len(cfg.Methods))
+    for method, c := range cfg.Methods {
+        methods[method] = limits(c)
+    }
+    limiter := interceptors.NewMethodLimiter(limits(cfg.Default), methods)
+    expvar.Publish("method_limits", expvar.Func(func() interface{} { return limiter.Stats() }))
+    return limiter
+}
+
+// panicRecovery returns the interceptor recovering from panics in handlers, counting them by
+// fingerprint in the "grpc_panics" expvar.
+func panicRecovery() grpc.UnaryServerInterceptor {
+    panics := expvar.NewMap("grpc_panics")
+    return interceptors.Recovery(func(method, fingerprint string) { panics.Add(fingerprint, 1) })
+}
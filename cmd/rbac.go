@@ -0,0 +1,69 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "persistence-layer/config"
+    "persistence-layer/interceptors"
+    "persistence-layer/orm"
+    "time"
+
+    "google.golang.org/grpc"
+)
+
+// startRBAC loads the stored permissions, installs their enforcement on the ORM and returns the
+// interceptor resolving each call's principal. Permissions are reloaded in the background.
+func startRBAC(ctx context.Context, ormLayer *orm.ORM, cfg config.RBACConfig) grpc.UnaryServerInterceptor {
+    rbac, err := orm.NewRBAC(ormLayer)
+    if err != nil {
+        log.Fatalf("Failed to load RBAC permissions: %v", err)
+    }
+    ormLayer.Use(rbac.Middleware())
+    interval := time.Duration(cfg.ReloadSeconds) * time.Second
+    if interval <= 0 {
+        interval = 30 * time.Second
+    }
+    go rbac.Run(ctx, interval)
+    log.Printf("RBAC enabled")
+    return interceptors.RBAC(rbac)
+}
+
+// runRBAC stores a permission, e.g. `rbac -role viewer -entity Product -operation Delete -deny`,
+// or assigns a role to a subject with `rbac -role admin -subject alice`.
+func runRBAC(cfg *config.Config, args []string) {
+    flags := flag.NewFlagSet("rbac", flag.ExitOnError)
+    role := flags.String("role", "", "role the permission or assignment is for")
+    entity := flags.String("entity", orm.AnyEntity, "model the permission covers, e.g. Product; * for all")
+    operation := flags.String("operation", orm.AnyOperation, "ORM operation the permission covers, e.g. Delete; * for all")
+    deny := flags.Bool("deny", false, "deny instead of allow")
+    subject := flags.String("subject", "", "assign the role to this subject instead")
+    _ = flags.Parse(args)
+    if *role == "" {
+        log.Fatalf("Usage: rbac -role <role> [-entity <model>] [-operation <operation>] [-deny] | rbac -role <role> -subject <subject>")
+    }
+
+    ormLayer, cleanup := initORM(cfg, migrationOptions{})
+    defer cleanup()
+    rbac, err := orm.NewRBAC(ormLayer)
+    if err != nil {
+        log.Fatalf("Failed to load RBAC permissions: %v", err)
+    }
+
+    if *subject != "" {
+        if err := rbac.AssignRole(*subject, *role); err != nil {
+            log.Fatalf("Assigning role failed: %v", err)
+        }
+        fmt.Printf("Assigned role %s to %s.\n", *role, *subject)
+        return
+    }
+    effect := orm.EffectAllow
+    if *deny {
+        effect = orm.EffectDeny
+    }
+    if err := rbac.SetPermission(&orm.Permission{Role: *role, Entity: *entity, Operation: *operation, Effect: effect}); err != nil {
+        log.Fatalf("Storing permission failed: %v", err)
+    }
+    fmt.Printf("Role %s: %s %s on %s.\n", *role, effect, *operation, *entity)
+}
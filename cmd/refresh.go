@@ -0,0 +1,50 @@
+package main
+
+import (
+    "context"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+    "time"
+)
+
+// startCacheRefresher keeps the cached records of the models named in config warm, refreshing them
+// from their source of truth as Redis reports them expired if they were read recently.
+func startCacheRefresher(ctx context.Context, ormLayer *orm.ORM, cfg config.RefreshConfig) {
+    if !cfg.Enabled || ormLayer.Redis == nil {
+        return
+    }
+    if cfg.EnableNotifications {
+        if notifier, ok := ormLayer.Redis.(interface{ EnableExpiryNotifications() error }); ok {
+            if err := notifier.EnableExpiryNotifications(); err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "EnableExpiryNotifications"})
+            }
+        }
+    }
+
+    refresher := orm.NewCacheRefresher(ormLayer)
+    if cfg.LeadSeconds > 0 {
+        refresher.Lead = time.Duration(cfg.LeadSeconds) * time.Second
+    }
+    if cfg.Workers > 0 {
+        refresher.Workers = cfg.Workers
+    }
+    if cfg.IdleSeconds > 0 {
+        refresher.Idle = time.Duration(cfg.IdleSeconds) * time.Second
+    }
+    hot := make(map[string]bool, len(cfg.Models))
+    for _, name := range cfg.Models {
+        hot[name] = true
+    }
+    for _, model := range GetAllModels() {
+        if hot[modelTypeName(model)] && ormLayer.PolicyFor(model).Cache {
+            refresher.RegisterModel(model)
+        }
+    }
+    ormLayer.Refresher = refresher
+    go func() {
+        if err := refresher.Run(ctx); err != nil && ctx.Err() == nil {
+            utils.LogError(err, map[string]interface{}{"operation": "CacheRefresher.Run"})
+        }
+    }()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "time"
+)
+
+// runReindex rebuilds the search index of one model from SQL, e.g.
+// `reindex -model Product -batch 1000 -concurrency 8`. Interrupted runs resume where they stopped
+// unless -restart is given.
+func runReindex(cfg *config.Config, args []string) {
+    flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+    modelName := flags.String("model", "", "model to reindex, e.g. Product")
+    batchSize := flags.Int("batch", 500, "rows read and bulk indexed per batch")
+    concurrency := flags.Int("concurrency", 4, "batches indexed in parallel")
+    restart := flags.Bool("restart", false, "discard an unfinished run and start a fresh index")
+    _ = flags.Parse(args)
+
+    var model interface{}
+    for _, m := range GetAllModels() {
+        if modelTypeName(m) == *modelName {
+            model = m
+        }
+    }
+    if model == nil {
+        log.Fatalf("Unknown -model %q", *modelName)
+    }
+
+    ormLayer, cleanup := initORM(cfg, migrationOptions{})
+    defer cleanup()
+
+    db, err := ormLayer.ForModel(model)
+    if err != nil {
+        log.Fatalf("Reindex failed: %v", err)
+    }
+    backfiller := orm.NewBackfiller(db, model)
+    backfiller.BatchSize = *batchSize
+    backfiller.Concurrency = *concurrency
+    backfiller.Restart = *restart
+    backfiller.Progress = func(p orm.BackfillProgress) {
+        percent := 100.0
+        if p.Total > 0 {
+            percent = float64(p.Indexed) * 100 / float64(p.Total)
+        }
+        fmt.Printf("%s: %d/%d documents (%.1f%%) in %s\n", p.Target, p.Indexed, p.Total, percent, p.Elapsed.Round(time.Millisecond))
+    }
+    // Interrupting stops after the current round; the checkpoint lets the next run resume.
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    checkpoint, err := backfiller.Run(ctx)
+    if err != nil {
+        log.Fatalf("Reindex failed: %v (rerun to resume)", err)
+    }
+    fmt.Printf("%s now points to %s with %d documents\n", checkpoint.Alias, checkpoint.Target, checkpoint.Indexed)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "log"
+    "persistence-layer/config"
+    "persistence-layer/flags"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+    "sync"
+    "time"
+)
+
+// rateLimits holds the rate limit settings, which change on reload.
+type rateLimits struct {
+    mu       sync.RWMutex
+    requests int64
+    window   time.Duration
+}
+
+func newRateLimits(cfg config.RateLimitConfig) *rateLimits {
+    limits := &rateLimits{}
+    limits.set(cfg)
+    return limits
+}
+
+func (l *rateLimits) set(cfg config.RateLimitConfig) {
+    window := time.Duration(cfg.WindowSeconds) * time.Second
+    if window <= 0 {
+        window = time.Second
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.requests, l.window = cfg.Requests, window
+}
+
+func (l *rateLimits) get() (int64, time.Duration) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+    return l.requests, l.window
+}
+
+// watchConfig re-applies the reloadable settings of the configuration files and remote key whenever
+// they change: log levels and sampling, rate limits, feature flags and cache TTLs. Other changes are rejected
+// until a restart.
+func watchConfig(cfg *config.Config, ormLayer *orm.ORM, limits *rateLimits) {
+    if !cfg.WatchConfig {
+        return
+    }
+    apply := func(old, updated *config.Config) {
+        if err := utils.SetLogLevel(updated.Logging.Level); err != nil {
+            log.Printf("Ignoring reloaded logging level: %v", err)
+        }
+        if err := utils.SetParamLogMode(utils.ParamLogMode(updated.Logging.SQLParams), updated.Logging.ParamHashKey); err != nil {
+            log.Printf("Ignoring reloaded logging configuration: %v", err)
+        }
+        applyLogSampling(updated.Logging)
+        limits.set(updated.RateLimit)
+        flags.Default().SetDeclared(toFlags(updated.FeatureFlags.Flags))
+        for _, model := range GetAllModels() {
+            name := modelTypeName(model)
+            if ttl := updated.Policies[name].CacheTTLSeconds; ttl != old.Policies[name].CacheTTLSeconds {
+                ormLayer.SetCacheTTL(model, time.Duration(ttl)*time.Second)
+            }
+        }
+        log.Printf("Reloaded configuration from %s", configSources())
+    }
+    if err := config.Watch(configLoader, cfg, apply, nil); err != nil {
+        log.Printf("Configuration reload disabled: %v", err)
+    }
+}
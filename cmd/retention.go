@@ -0,0 +1,34 @@
+package main
+
+import (
+    "context"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "time"
+)
+
+// startArchiver launches the retention archiver in the background when it is enabled in config.
+func startArchiver(ctx context.Context, ormLayer *orm.ORM, cfg config.RetentionConfig) {
+    if !cfg.Enabled || len(cfg.Policies) == 0 {
+        return
+    }
+
+    policies := make([]orm.RetentionPolicy, 0, len(cfg.Policies))
+    for _, p := range cfg.Policies {
+        policies = append(policies, orm.RetentionPolicy{
+            Table:        p.Table,
+            ArchiveTable: p.ArchiveTable,
+            TimeColumn:   p.TimeColumn,
+            MaxAge:       time.Duration(p.MaxAgeDays) * 24 * time.Hour,
+            BatchSize:    p.BatchSize,
+        })
+    }
+
+    interval := time.Duration(cfg.IntervalMins) * time.Minute
+    if interval <= 0 {
+        interval = time.Hour
+    }
+
+    archiver := orm.NewArchiver(ormLayer, orm.NewFileArchiveSink(cfg.ExportDir), policies...)
+    go archiver.Run(ctx, interval)
+}
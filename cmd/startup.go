@@ -0,0 +1,75 @@
+package main
+
+import (
+    "expvar"
+    "fmt"
+    "log"
+    "persistence-layer/adapters"
+    "persistence-layer/config"
+    "strings"
+    "sync"
+    "time"
+)
+
+// backendConnect connects one backend at startup.
+type backendConnect struct {
+    backend string
+    connect func(retry adapters.RetryPolicy) error
+}
+
+// backendStartup is how connecting a backend went, as reported at startup.
+type backendStartup struct {
+    Backend   string `json:"backend"`
+    ElapsedMs int64  `json:"elapsed_ms"`
+    Error     string `json:"error,omitempty"`
+}
+
+// backendRetry returns the retry policy of backend, waiting as long as its entry in
+// startup.backend_wait_seconds allows, else max_wait_seconds.
+func backendRetry(cfg config.StartupConfig, backend string) adapters.RetryPolicy {
+    retry := adapters.DefaultRetryPolicy
+    if cfg.MaxWaitSeconds > 0 {
+        retry.MaxWait = time.Duration(cfg.MaxWaitSeconds) * time.Second
+    }
+    if seconds := cfg.BackendWaitSeconds[backend]; seconds > 0 {
+        retry.MaxWait = time.Duration(seconds) * time.Second
+    }
+    retry.Lazy = cfg.Lazy
+    return retry
+}
+
+// connectBackends connects the backends concurrently, so cold start takes as long as the slowest
+// backend rather than all of them together. It logs which backend took how long and publishes the
+// report as the "startup" expvar. When backends failed, startup aborts after all of them finished,
+// naming every failure.
+func connectBackends(cfg config.StartupConfig, connects []backendConnect) {
+    report := make([]backendStartup, len(connects))
+    var wg sync.WaitGroup
+    for i, c := range connects {
+        wg.Add(1)
+        go func(i int, c backendConnect) {
+            defer wg.Done()
+            start := time.Now()
+            err := c.connect(backendRetry(cfg, c.backend))
+            report[i] = backendStartup{Backend: c.backend, ElapsedMs: time.Since(start).Milliseconds()}
+            if err != nil {
+                report[i].Error = err.Error()
+            }
+        }(i, c)
+    }
+    wg.Wait()
+    expvar.Publish("startup", expvar.Func(func() interface{} { return report }))
+
+    var failures []string
+    for _, r := range report {
+        if r.Error != "" {
+            log.Printf("Startup: %s failed after %dms: %s", r.Backend, r.ElapsedMs, r.Error)
+            failures = append(failures, fmt.Sprintf("%s: %s", r.Backend, r.Error))
+        } else {
+            log.Printf("Startup: %s connected in %dms", r.Backend, r.ElapsedMs)
+        }
+    }
+    if len(failures) > 0 {
+        log.Fatalf("Failed to initialize %d backend(s): %s", len(failures), strings.Join(failures, "; "))
+    }
+}
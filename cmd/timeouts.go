@@ -0,0 +1,30 @@
+package main
+
+import (
+    "log"
+    "persistence-layer/adapters"
+    "persistence-layer/config"
+)
+
+// setDeadlines gives a backend's calls the default deadlines configured under timeouts.
+func setDeadlines(backend string, store adapters.DeadlineSetter, d adapters.Deadlines) {
+    if d == (adapters.Deadlines{}) {
+        return
+    }
+    if err := store.SetDeadlines(d); err != nil {
+        log.Fatalf("Failed to set %s deadlines: %v", backend, err)
+    }
+}
+
+func sqlDeadlines(cfg config.TimeoutsConfig) adapters.Deadlines {
+    return adapters.Deadlines{Read: cfg.SQLRead, Write: cfg.SQLWrite}
+}
+
+// withoutSQLTimeouts returns a copy of cfg without SQL deadlines and default statement timeout,
+// for admin commands whose bulk statements legitimately run longer than a request's.
+func withoutSQLTimeouts(cfg *config.Config) *config.Config {
+    admin := *cfg
+    admin.StatementTimeoutMs = 0
+    admin.Timeouts.SQLRead, admin.Timeouts.SQLWrite = 0, 0
+    return &admin
+}
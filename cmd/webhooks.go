@@ -0,0 +1,103 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "persistence-layer/config"
+    "persistence-layer/orm"
+    "time"
+)
+
+// enableWebhooks turns on webhooks for the models configured under webhooks.models.
+func enableWebhooks(ormLayer *orm.ORM, cfg config.WebhooksConfig) {
+    if len(cfg.Models) == 0 {
+        return
+    }
+    wanted := make(map[string]bool, len(cfg.Models))
+    for _, name := range cfg.Models {
+        wanted[name] = true
+    }
+    var models []interface{}
+    for _, model := range GetAllModels() {
+        if wanted[modelTypeName(model)] {
+            models = append(models, model)
+            delete(wanted, modelTypeName(model))
+        }
+    }
+    for name := range wanted {
+        log.Fatalf("Invalid webhooks configuration: unknown model %q", name)
+    }
+    if err := ormLayer.EnableWebhooks(models...); err != nil {
+        log.Fatalf("Failed to enable webhooks: %v", err)
+    }
+}
+
+// startWebhookDispatcher delivers pending webhooks when any model has them enabled.
+func startWebhookDispatcher(ctx context.Context, ormLayer *orm.ORM, cfg config.WebhooksConfig) {
+    if len(cfg.Models) == 0 || ormLayer.SQL == nil {
+        return
+    }
+    dispatcher := orm.NewWebhookDispatcher(ormLayer)
+    if cfg.BatchSize > 0 {
+        dispatcher.BatchSize = cfg.BatchSize
+    }
+    if cfg.MaxAttempts > 0 {
+        dispatcher.MaxAttempts = cfg.MaxAttempts
+    }
+    if cfg.TimeoutSeconds > 0 {
+        dispatcher.Client.Timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+    }
+    interval := time.Duration(cfg.IntervalMs) * time.Millisecond
+    if interval <= 0 {
+        interval = time.Second
+    }
+    go dispatcher.Run(ctx, interval)
+}
+
+// runWebhook registers a webhook endpoint, e.g. `webhook -entity Product -events updated -url
+// https://partner.example/hooks`, printing its secret, or lists the latest deliveries to one with
+// `webhook -deliveries 12`.
+func runWebhook(cfg *config.Config, args []string) {
+    flags := flag.NewFlagSet("webhook", flag.ExitOnError)
+    entity := flags.String("entity", "", "model whose changes are sent, e.g. Product")
+    events := flags.String("events", "", "comma-separated events to send: created, updated, deleted; all when empty")
+    url := flags.String("url", "", "endpoint URL")
+    deliveries := flags.Uint64("deliveries", 0, "list the latest deliveries to the endpoint with this ID instead")
+    limit := flags.Int("limit", 20, "number of deliveries to list")
+    _ = flags.Parse(args)
+    if *deliveries == 0 && (*entity == "" || *url == "") {
+        log.Fatalf("Usage: webhook -entity <model> -url <url> [-events created,updated,deleted] | webhook -deliveries <endpoint id>")
+    }
+
+    ormLayer, cleanup := initORM(cfg, migrationOptions{})
+    defer cleanup()
+    if err := ormLayer.EnableWebhooks(); err != nil {
+        log.Fatalf("Failed to migrate webhook tables: %v", err)
+    }
+
+    if *deliveries != 0 {
+        list, err := ormLayer.WebhookDeliveries(*deliveries, *limit)
+        if err != nil {
+            log.Fatalf("Listing deliveries failed: %v", err)
+        }
+        for _, d := range list {
+            state := "pending"
+            switch {
+            case d.DeliveredAt != nil:
+                state = "delivered"
+            case d.FailedAt != nil:
+                state = "failed"
+            }
+            fmt.Printf("%d\t%s\t%s\t%s\tattempts=%d\tstatus=%d\t%s\n", d.ID, d.CreatedAt.Format(time.RFC3339), d.Event, state, d.Attempts, d.LastStatus, d.LastError)
+        }
+        return
+    }
+
+    endpoint := &orm.WebhookEndpoint{Entity: *entity, Events: *events, URL: *url}
+    if err := ormLayer.RegisterWebhook(endpoint); err != nil {
+        log.Fatalf("Registering webhook failed: %v", err)
+    }
+    fmt.Printf("Registered webhook %d for %s.\nSigning secret: %s\n", endpoint.ID, endpoint.Entity, endpoint.Secret)
+}
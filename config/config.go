@@ -1,24 +1,570 @@
 package config
 
 import (
-    "gopkg.in/yaml.v2"
-    "io/ioutil"
+    "strings"
+    "time"
 )
 
 type Config struct {
+    // Environment names the deployment, e.g. "dev" or "prod"; Loader sets it to its Env when
+    // empty. Development-only features, such as the gRPC-Web listener, require "dev".
+    Environment       string `yaml:"environment"`
     SQLDSN            string `yaml:"sql_dsn"`
     MySQLDSN            string `yaml:"mysql_dsn"`
+    // StandbyDSN, when set, is failed over to while the MySQL primary is down.
+    StandbyDSN        string `yaml:"standby_dsn"`
+    Failover          FailoverConfig `yaml:"failover"`
+    // Databases declares additional SQL databases by name, e.g. "billing", for policies to route
+    // models to.
+    Databases         map[string]DatabaseConfig `yaml:"databases"`
+    // Replicas declares read replicas by the name of the database they replicate, "default" for
+    // the main one. ReplicaLagSeconds bounds how long a session reads its writes from the primary
+    // when replication positions are unavailable.
+    Replicas          map[string]DatabaseConfig `yaml:"replicas"`
+    ReplicaLagSeconds int `yaml:"replica_lag_seconds"`
+    // StatementTimeoutMs bounds every SQL statement of every database unless the call overrides
+    // it; 0 leaves statements unbounded. Migrations and admin commands are not bounded.
+    StatementTimeoutMs int `yaml:"statement_timeout_ms"`
+    Timeouts          TimeoutsConfig `yaml:"timeouts"`
+    // SchemaDrift is what startup does when the SQL schema differs from the models after
+    // migration: "log" (default), "fail" or "off".
+    SchemaDrift       string `yaml:"schema_drift"`
     MongoURI          string `yaml:"mongo_uri"`
     RedisURI          string `yaml:"redis_uri"`
+    Redis             RedisConfig `yaml:"redis"`
     ElasticsearchURI  string `yaml:"es_uri"`
+    Elasticsearch     ElasticsearchConfig `yaml:"elasticsearch"`
+    DisabledBackends  []string `yaml:"disabled_backends"`
+    Logging           LoggingConfig `yaml:"logging"`
+    ErrorReporting    ErrorReportingConfig `yaml:"error_reporting"`
+    Startup           StartupConfig `yaml:"startup"`
+    Transactions      TransactionConfig `yaml:"transactions"`
+    Retention         RetentionConfig `yaml:"retention"`
+    Partitioning      PartitioningConfig `yaml:"partitioning"`
+    Chaos             ChaosConfig `yaml:"chaos"`
+    Quotas            QuotaConfig `yaml:"quotas"`
+    RateLimit         RateLimitConfig `yaml:"rate_limit"`
+    QueryBudget       QueryBudgetConfig `yaml:"query_budget"`
+    MethodLimits      MethodLimitsConfig `yaml:"method_limits"`
+    Auth              AuthConfig `yaml:"auth"`
+    RBAC              RBACConfig `yaml:"rbac"`
+    FeatureFlags      FeatureFlagsConfig `yaml:"feature_flags"`
+    Dataloader        DataloaderConfig `yaml:"dataloader"`
+    Localization      LocalizationConfig `yaml:"localization"`
+    Pagination        PaginationConfig `yaml:"pagination"`
+    // Policies declares where each model's records live, keyed by model name, e.g. "Product".
+    Policies          map[string]PolicyConfig `yaml:"policies"`
+    Outbox            OutboxConfig `yaml:"outbox"`
+    IndexPool         IndexPoolConfig `yaml:"index_pool"`
+    CDC               CDCConfig `yaml:"cdc"`
+    Webhooks          WebhooksConfig `yaml:"webhooks"`
+    Audit             AuditConfig `yaml:"audit"`
+    Consistency       ConsistencyConfig `yaml:"consistency"`
+    Seed              SeedConfig `yaml:"seed"`
+    Backup            BackupConfig `yaml:"backup"`
+    // HedgeAfterMs hedges read operations, keyed by ORM operation name such as "Read" or "Search",
+    // sending a second request when the first hasn't answered after that many milliseconds.
+    HedgeAfterMs      map[string]int `yaml:"hedge_after_ms"`
+    // MetricsAddr is the listen address of the HTTP server exposing expvar metrics at /debug/vars.
+    MetricsAddr       string `yaml:"metrics_addr"`
+    // GraphQLAddr, when set, is the listen address of the HTTP server exposing the models through
+    // GraphQL at /graphql.
+    GraphQLAddr       string `yaml:"graphql_addr"`
+    GRPCWeb           GRPCWebConfig `yaml:"grpc_web"`
+    Discovery         DiscoveryConfig `yaml:"discovery"`
+    // WatchConfig reloads the configuration when its files or remote key change, applying logging,
+    // rate_limit requests and window, feature flags and policy cache TTLs; other changes are
+    // rejected until a restart.
+    WatchConfig       bool `yaml:"watch_config"`
 }
 
-func LoadConfigFromFile(filePath string) (*Config, error) {
-    data, err := ioutil.ReadFile(filePath)
-    if err != nil {
-        return nil, err
+// SeedConfig declares the seed profiles, e.g. "demo", each the fixture files it loads in order.
+type SeedConfig struct {
+    Profiles map[string][]string `yaml:"profiles"`
+}
+
+// BackupConfig controls the backup and restore commands.
+type BackupConfig struct {
+    // Dir holds a timestamped directory per backup, e.g. a mounted bucket.
+    Dir                string   `yaml:"dir"`
+    // Collections are the Mongo collections to back up; all of them when empty.
+    Collections        []string `yaml:"collections"`
+    // SnapshotRepository is the Elasticsearch snapshot repository to snapshot indices into; search
+    // indices are left out of backups when empty.
+    SnapshotRepository string   `yaml:"snapshot_repository"`
+    Indices            []string `yaml:"indices"`
+    // AnonymizeKey keys the anonymizer of `backup -anonymize` and `restore -anonymize`; keep it
+    // secret, since anyone holding it can test guesses against anonymized values.
+    AnonymizeKey       string   `yaml:"anonymize_key"`
+}
+
+// LoggingConfig controls the log level and how SQL parameter values appear in logs.
+type LoggingConfig struct {
+    Level        string `yaml:"level"`
+    // SQLParams is "redact" (default), "hash" or "debug"; debug logs raw values only at debug level.
+    SQLParams    string `yaml:"sql_params"`
+    // ParamHashKey keys the hashes of SQLParams "hash", and is required with it.
+    ParamHashKey string `yaml:"param_hash_key"`
+    // DisableSuccess drops the info logs of successful operations; warnings, errors and slow
+    // operations are still logged.
+    DisableSuccess bool `yaml:"disable_success"`
+    // SampleRate and MaxPerSecond thin out each info message, unless Messages has a rule for it.
+    SampleRate   *float64 `yaml:"sample_rate"`
+    MaxPerSecond int      `yaml:"max_per_second"`
+    // Messages holds sampling rules by message, e.g. "Record retrieved successfully".
+    Messages     map[string]LogRuleConfig `yaml:"messages"`
+    // SlowOperationMs logs ORM operations slower than this as warnings; 0 disables it.
+    SlowOperationMs int `yaml:"slow_operation_ms"`
+}
+
+// LogRuleConfig samples an info message: SampleRate is the share written, from 0 (none) to 1 (all,
+// also when unset), and MaxPerSecond caps them, 0 meaning no cap.
+type LogRuleConfig struct {
+    SampleRate   *float64 `yaml:"sample_rate"`
+    MaxPerSecond int      `yaml:"max_per_second"`
+}
+
+// ErrorReportingConfig sends the errors the service logs to Sentry, or a service accepting its API.
+// Empty SentryDSN disables reporting. Expected errors, such as missing records, aren't reported,
+// and only scalar log fields are, with quoted values and emails removed from them.
+type ErrorReportingConfig struct {
+    SentryDSN   string `yaml:"sentry_dsn"`
+    Environment string `yaml:"environment"`
+    Release     string `yaml:"release"`
+    // SampleRate is the share of errors reported, from 0 (none) to 1 (all, also when unset).
+    SampleRate  *float64 `yaml:"sample_rate"`
+    // ScrubFields names further log fields whose values are never reported, beyond passwords,
+    // tokens, emails and the like.
+    ScrubFields []string `yaml:"scrub_fields"`
+}
+
+// StartupConfig controls how long adapters wait for their backends at boot. The backends connect
+// concurrently, each within its own wait.
+type StartupConfig struct {
+    MaxWaitSeconds int  `yaml:"max_wait_seconds"`
+    // BackendWaitSeconds overrides MaxWaitSeconds per backend: sql, mongo, redis or elasticsearch.
+    BackendWaitSeconds map[string]int `yaml:"backend_wait_seconds"`
+    // Lazy starts the service even if a backend is still unreachable after the wait;
+    // the adapter connects on first use.
+    Lazy           bool `yaml:"lazy"`
+}
+
+// TransactionConfig controls retries of transactions aborted by deadlocks or serialization failures.
+type TransactionConfig struct {
+    MaxAttempts    int `yaml:"max_attempts"`
+    RetryBackoffMs int `yaml:"retry_backoff_ms"`
+}
+
+// RetentionConfig controls the archival worker and the per-table retention policies.
+type RetentionConfig struct {
+    Enabled       bool              `yaml:"enabled"`
+    IntervalMins  int               `yaml:"interval_minutes"`
+    ExportDir     string            `yaml:"export_dir"`
+    Policies      []RetentionPolicy `yaml:"policies"`
+}
+
+// RetentionPolicy describes how long rows of a single table are kept.
+// Rows are copied to ArchiveTable when it is set, otherwise exported to ExportDir.
+type RetentionPolicy struct {
+    Table         string `yaml:"table"`
+    ArchiveTable  string `yaml:"archive_table"`
+    TimeColumn    string `yaml:"time_column"`
+    MaxAgeDays    int    `yaml:"max_age_days"`
+    BatchSize     int    `yaml:"batch_size"`
+}
+
+// PartitioningConfig controls the job that rotates monthly range partitions.
+type PartitioningConfig struct {
+    Enabled       bool             `yaml:"enabled"`
+    IntervalHours int              `yaml:"interval_hours"`
+    Tables        []PartitionTable `yaml:"tables"`
+}
+
+// PartitionTable configures partition rotation for a single range-partitioned table.
+type PartitionTable struct {
+    Table         string `yaml:"table"`
+    PremakeMonths int    `yaml:"premake_months"`
+    RetainMonths  int    `yaml:"retain_months"`
+}
+
+// Dev reports whether the configuration is for the dev environment.
+func (c *Config) Dev() bool {
+    return c.Environment == "dev"
+}
+
+// BackendEnabled reports whether a backend ("sql", "mongo", "redis" or "elasticsearch") should be started.
+// A backend is disabled when it is listed in disabled_backends or its URI is empty.
+func (c *Config) BackendEnabled(backend string) bool {
+    for _, disabled := range c.DisabledBackends {
+        if strings.EqualFold(disabled, backend) {
+            return false
+        }
+    }
+    switch backend {
+    case "sql":
+        return c.MySQLDSN != ""
+    case "mongo":
+        return c.MongoURI != ""
+    case "redis":
+        return c.RedisURI != ""
+    case "elasticsearch":
+        return c.ElasticsearchURI != "" || len(c.Elasticsearch.Addresses) > 0
     }
-    var cfg Config
-    err = yaml.Unmarshal(data, &cfg)
-    return &cfg, err
+    return false
+}
+
+// LoadConfigFromFile reads the configuration file at filePath, in any format Loader supports,
+// applies defaults and validates it. A configuration failing validation is returned with a
+// *ValidationError listing every problem.
+func LoadConfigFromFile(filePath string) (*Config, error) {
+    return Loader{Path: filePath}.Load()
+}
+
+// ChaosConfig enables fault injection for resilience testing. It must stay disabled in production.
+type ChaosConfig struct {
+    Enabled  bool                 `yaml:"enabled"`
+    // Backends maps a backend name (sql, mongo, redis, elasticsearch) to the faults injected into it.
+    Backends map[string]FaultRule `yaml:"backends"`
+}
+
+// FaultRule sets the probability (0-1) of each fault for one backend.
+type FaultRule struct {
+    LatencyRate float64 `yaml:"latency_rate"`
+    LatencyMs   int     `yaml:"latency_ms"`
+    ErrorRate   float64 `yaml:"error_rate"`
+    DropRate    float64 `yaml:"drop_rate"`
+}
+
+// QuotaConfig controls per-tenant usage accounting, counted in Redis so quotas hold across
+// instances. Tenants are identified by the tenant claim of the caller's token.
+type QuotaConfig struct {
+    Enabled bool `yaml:"enabled"`
+    // Enforce rejects operations over quota, and calls without a tenant, instead of only tracking
+    // usage.
+    Enforce bool `yaml:"enforce"`
+    // WindowSeconds is the window max_requests applies to; 86400 by default.
+    WindowSeconds int                    `yaml:"window_seconds"`
+    Default       TenantQuota            `yaml:"default"`
+    Tenants       map[string]TenantQuota `yaml:"tenants"`
+}
+
+// RateLimitConfig limits the gRPC calls of each caller, the subject of its token or else its IP
+// address, per method over a sliding window, counted in Redis so the limit holds across instances.
+// Requests of 0 or less disables the limit.
+type RateLimitConfig struct {
+    Enabled       bool  `yaml:"enabled"`
+    Requests      int64 `yaml:"requests"`
+    WindowSeconds int   `yaml:"window_seconds"`
+}
+
+// GRPCWebConfig serves the gRPC services to browsers through gRPC-Web on a separate HTTP listener,
+// for single-page apps in the dev environment only. Empty Addr disables it.
+type GRPCWebConfig struct {
+    Addr string `yaml:"addr"`
+    // AllowedOrigins lists the origins allowed to call the services, e.g. "http://localhost:3000".
+    AllowedOrigins []string `yaml:"allowed_origins"`
+    // AllowedHeaders are request headers browsers may send besides the standard gRPC-Web ones.
+    AllowedHeaders []string `yaml:"allowed_headers"`
+    MaxAgeSeconds  int      `yaml:"max_age_seconds"`
+}
+
+// DiscoveryConfig registers the gRPC server in Consul's or etcd's service catalog on startup and
+// deregisters it on shutdown. Empty Kind disables registration.
+type DiscoveryConfig struct {
+    Kind    string `yaml:"kind"`    // "consul" or "etcd".
+    Address string `yaml:"address"` // Base URL of the HTTP API, e.g. http://consul:8500.
+    Token   string `yaml:"token"`
+    // ServiceName defaults to "persistence-layer".
+    ServiceName string   `yaml:"service_name"`
+    Tags        []string `yaml:"tags"`
+    // Advertise is the host:port clients dial; defaults to the hostname and the gRPC port.
+    Advertise string `yaml:"advertise"`
+    // CheckInterval paces Consul's health checks and etcd's lease renewals, e.g. "10s".
+    CheckInterval time.Duration `yaml:"check_interval"`
+    // DeregisterAfter removes instances failing their checks, or no longer renewing their etcd
+    // lease, after this long, e.g. "1m".
+    DeregisterAfter time.Duration `yaml:"deregister_after"`
+    // EtcdPrefix is the key prefix of etcd registrations; defaults to "services".
+    EtcdPrefix string `yaml:"etcd_prefix"`
+}
+
+// TimeoutsConfig sets the default deadlines of backend calls made without one, as durations such
+// as "200ms"; empty leaves that kind of call unbounded. Redis bounds both reads and writes. SQL
+// migrations and the seed, reindex, migrate, backup and restore commands run without the SQL ones.
+type TimeoutsConfig struct {
+    SQLRead    time.Duration `yaml:"sql_read"`
+    SQLWrite   time.Duration `yaml:"sql_write"`
+    MongoRead  time.Duration `yaml:"mongo_read"`
+    MongoWrite time.Duration `yaml:"mongo_write"`
+    Redis      time.Duration `yaml:"redis"`
+    ESSearch   time.Duration `yaml:"es_search"`
+    ESWrite    time.Duration `yaml:"es_write"`
+}
+
+// QueryBudgetConfig warns about gRPC calls making more than MaxCalls ORM operations, e.g. N+1
+// reads in a service.
+type QueryBudgetConfig struct {
+    Enabled  bool `yaml:"enabled"`
+    MaxCalls int  `yaml:"max_calls"`
+}
+
+// MethodLimitsConfig bounds the request and response sizes of gRPC calls and counts calls slower
+// than their latency SLO, with Default overridden per full method name, e.g.
+// "/proto.ProductService/CreateProduct". Zero values are unlimited.
+type MethodLimitsConfig struct {
+    Enabled bool                         `yaml:"enabled"`
+    Default MethodLimitConfig            `yaml:"default"`
+    Methods map[string]MethodLimitConfig `yaml:"methods"`
+}
+
+// MethodLimitConfig are the limits of a method.
+type MethodLimitConfig struct {
+    MaxRequestBytes  int `yaml:"max_request_bytes"`
+    MaxResponseBytes int `yaml:"max_response_bytes"`
+    LatencySLOMs     int `yaml:"latency_slo_ms"`
+}
+
+// RBACConfig enforces the role-based permissions stored in SQL on the ORM operations of gRPC
+// calls, reloading them every ReloadSeconds.
+type RBACConfig struct {
+    Enabled       bool `yaml:"enabled"`
+    ReloadSeconds int  `yaml:"reload_seconds"`
+}
+
+// AuthConfig verifies the bearer token of every gRPC call, a JWT signed with JWTSecret, and takes
+// the caller's subject, roles and tenant from it. Empty JWTSecret disables authentication, which
+// RBAC requires.
+type AuthConfig struct {
+    JWTSecret     string `yaml:"jwt_secret"`
+    Issuer        string `yaml:"issuer"`
+    Audience      string `yaml:"audience"`
+    LeewaySeconds int    `yaml:"leeway_seconds"`
+}
+
+// FeatureFlagsConfig declares feature flags by name, e.g. "async_indexing". Overrides stored in
+// Redis take precedence and are re-read every RefreshSeconds.
+type FeatureFlagsConfig struct {
+    RefreshSeconds int                   `yaml:"refresh_seconds"`
+    Flags          map[string]FlagConfig `yaml:"flags"`
+}
+
+// FlagConfig enables a flag for everyone, for the listed Tenants and for Percentage (0-100) of the
+// other tenants.
+type FlagConfig struct {
+    Enabled    bool     `yaml:"enabled"`
+    Percentage float64  `yaml:"percentage"`
+    Tenants    []string `yaml:"tenants"`
+}
+
+// TenantQuota limits one tenant's usage. Zero values are unlimited.
+type TenantQuota struct {
+    MaxRequests     int64 `yaml:"max_requests"` // Per window_seconds.
+    MaxRows         int64 `yaml:"max_rows"`
+    MaxStorageBytes int64 `yaml:"max_storage_bytes"`
+    MaxCacheBytes   int64 `yaml:"max_cache_bytes"`
+}
+
+// LocalizationConfig controls locale fallback for localized reads and the translations table.
+// PaginationConfig sets the page sizes of paged queries and how they count their total: "none",
+// "exact" or "estimated" (default), which reads table statistics or counts up to count_cap.
+type PaginationConfig struct {
+    DefaultSize int    `yaml:"default_size"`
+    MaxSize     int    `yaml:"max_size"`
+    Count       string `yaml:"count"`
+    CountCap    int    `yaml:"count_cap"`
+}
+
+type LocalizationConfig struct {
+    DefaultLocale string              `yaml:"default_locale"`
+    Fallbacks     map[string][]string `yaml:"fallbacks"`
+    // Translations creates the translations table for fields tagged `localized:"table"`.
+    Translations  bool                `yaml:"translations"`
+}
+
+// PolicyConfig is the persistence policy of one model; see orm.Policy.
+type PolicyConfig struct {
+    Backends        []string `yaml:"backends"` // "sql" and/or "mongo"; defaults to sql.
+    Collection      string   `yaml:"collection"`
+    Cache           bool     `yaml:"cache"`
+    CacheTTLSeconds int      `yaml:"cache_ttl_seconds"`
+    Index           string   `yaml:"index"`
+    AsyncIndex      bool     `yaml:"async_index"` // Index through the outbox relay.
+    Database        string   `yaml:"database"`    // Named SQL database; defaults to the main one.
+    // Shards spreads the rows over these named databases by a hash of ShardKey, e.g. "user_id".
+    Shards          []string `yaml:"shards"`
+    ShardKey        string   `yaml:"shard_key"`
+    // Owner restricts operations on the model's records to their owner; see OwnerConfig.
+    Owner           OwnerConfig `yaml:"owner"`
+}
+
+// OwnerConfig declares the ownership rule of a model: Operations (defaults to Update and Delete;
+// add Read to scope reads) are limited to the caller whose subject is stored in Field, e.g.
+// "AuthorID", unless it has one of AdminRoles.
+type OwnerConfig struct {
+    Field      string   `yaml:"field"`
+    Operations []string `yaml:"operations"`
+    AdminRoles []string `yaml:"admin_roles"`
+}
+
+// FailoverConfig tunes the health checks deciding when to fail over to the standby DSN and back.
+type FailoverConfig struct {
+    CheckIntervalSeconds int  `yaml:"check_interval_seconds"`
+    FailAfter            int  `yaml:"fail_after"`
+    RecoverAfter         int  `yaml:"recover_after"`
+    // StandbyWritable sends writes to the standby; otherwise the service is read-only while
+    // failed over. Failing back is then manual, with SIGUSR1, once the standby's writes are on the
+    // primary.
+    StandbyWritable      bool `yaml:"standby_writable"`
+}
+
+// DatabaseConfig is the connection of a named SQL database.
+type DatabaseConfig struct {
+    Driver string `yaml:"driver"` // "postgres" (default) or "mysql".
+    DSN    string `yaml:"dsn"`
+}
+
+// ElasticsearchConfig tunes the Elasticsearch client. Addresses, when set, replaces es_uri.
+type ElasticsearchConfig struct {
+    // Flavor is "elasticsearch" (default) or "opensearch".
+    Flavor               string   `yaml:"flavor"`
+    Addresses            []string `yaml:"addresses"`
+    MaxRetries           int      `yaml:"max_retries"`
+    RetryBackoffMs       int      `yaml:"retry_backoff_ms"`
+    MaxRetryBackoffMs    int      `yaml:"max_retry_backoff_ms"`
+    RequestTimeoutMs     int      `yaml:"request_timeout_ms"`
+    SniffOnStart         bool     `yaml:"sniff_on_start"`
+    SniffIntervalSeconds int      `yaml:"sniff_interval_seconds"`
+    // After BreakerFailures consecutive failed searches, search is answered from SQL for
+    // BreakerOpenSeconds before Elasticsearch is tried again. Zero disables the breaker.
+    BreakerFailures      int      `yaml:"breaker_failures"`
+    BreakerOpenSeconds   int      `yaml:"breaker_open_seconds"`
+}
+
+// RedisConfig namespaces and versions cache keys: with App "shop", Env "prod" and SchemaVersion 3,
+// the key "product:42" is stored as "shop:prod:v3:product:42". Bump SchemaVersion on deploys that
+// change the shape of cached models.
+type RedisConfig struct {
+    App           string `yaml:"app"`
+    Env           string `yaml:"env"`
+    Tenant        string `yaml:"tenant"`
+    SchemaVersion int    `yaml:"schema_version"`
+    // MaxPatternDeletes caps the keys deleted by one pattern invalidation.
+    MaxPatternDeletes int64 `yaml:"max_pattern_deletes"`
+    // TTLJitter is the largest share by which cache TTLs are randomly shortened; 0 keeps the
+    // default of 0.1 and a negative value disables jitter.
+    TTLJitter float64 `yaml:"ttl_jitter"`
+    Refresh   RefreshConfig `yaml:"refresh"`
+    HotKeys   HotKeysConfig `yaml:"hot_keys"`
+}
+
+// HotKeysConfig controls the statistics of cached reads: counts per model and the most read keys,
+// estimated from SampleRate of the reads.
+type HotKeysConfig struct {
+    Enabled    bool    `yaml:"enabled"`
+    SampleRate float64 `yaml:"sample_rate"`
+    Capacity   int     `yaml:"capacity"`
+    WindowMins int     `yaml:"window_minutes"`
+}
+
+// RefreshConfig designates models, by type name, whose cached records are refreshed in the
+// background when they expire, as long as they were read within IdleSeconds, with at most Workers
+// refreshes at once. EnableNotifications turns on expired-key events on the server, for
+// deployments where they aren't configured already.
+type RefreshConfig struct {
+    Enabled             bool     `yaml:"enabled"`
+    Models              []string `yaml:"models"`
+    LeadSeconds         int      `yaml:"lead_seconds"`
+    Workers             int      `yaml:"workers"`
+    IdleSeconds         int      `yaml:"idle_seconds"`
+    EnableNotifications bool     `yaml:"enable_notifications"`
+}
+
+// OutboxConfig controls the relay applying outbox events to Elasticsearch. Relays sharing a
+// CheckpointName share their progress; it defaults to "default".
+type OutboxConfig struct {
+    IntervalMs     int    `yaml:"interval_ms"`
+    BatchSize      int    `yaml:"batch_size"`
+    MaxAttempts    int    `yaml:"max_attempts"`
+    CheckpointName string `yaml:"checkpoint_name"`
+    // BackoffMs is how long a failed event waits before it is retried, doubling with each failure
+    // up to MaxBackoffMs.
+    BackoffMs      int    `yaml:"backoff_ms"`
+    MaxBackoffMs   int    `yaml:"max_backoff_ms"`
+}
+
+// IndexPoolConfig moves the index writes of policies without async_index off the request path,
+// into Workers goroutines fed by queues of QueueSize changes in total; the changes of a document
+// always go to the same worker. When its queue is full, Overflow "block" (default) makes writes
+// wait for room and "outbox" hands the queued changes and the new one to the outbox relay.
+type IndexPoolConfig struct {
+    Enabled   bool   `yaml:"enabled"`
+    Workers   int    `yaml:"workers"`
+    QueueSize int    `yaml:"queue_size"`
+    Overflow  string `yaml:"overflow"`
+}
+
+// CDCConfig controls the consumer applying change events captured by Debezium to the cache and
+// search index, for writes made by other applications against the database.
+type CDCConfig struct {
+    Enabled       bool   `yaml:"enabled"`
+    Group         string `yaml:"group"`
+    // Streams maps model names, e.g. "Product", to the Redis stream Debezium Server writes the
+    // changes of their table to, e.g. "app.app_db.products".
+    Streams       map[string]string `yaml:"streams"`
+    // MaxDeliveries stops consuming a stream once an event failed this often, leaving it pending
+    // so later changes are not applied before it; 0 retries forever.
+    MaxDeliveries int64  `yaml:"max_deliveries"`
+}
+
+// WebhooksConfig controls the webhooks notifying registered endpoints of changes to Models, e.g.
+// ["Product"], and the dispatcher delivering them. SecretKey encrypts the endpoints' signing
+// secrets in the database. Endpoints on loopback, private and link-local addresses are refused
+// unless AllowPrivateNetworks.
+type WebhooksConfig struct {
+    Models               []string `yaml:"models"`
+    IntervalMs           int      `yaml:"interval_ms"`
+    BatchSize            int      `yaml:"batch_size"`
+    MaxAttempts          int      `yaml:"max_attempts"`
+    TimeoutSeconds       int      `yaml:"timeout_seconds"`
+    SecretKey            string   `yaml:"secret_key"`
+    AllowPrivateNetworks bool     `yaml:"allow_private_networks"`
+}
+
+// AuditConfig exports an audit trail of ORM writes to a SIEM. Sink is "syslog", "http" (posting
+// to URL) or "kafka" (producing to Topic through the Kafka REST Proxy at URL). Block makes writes
+// wait for room when the buffer is full instead of dropping their events.
+type AuditConfig struct {
+    Enabled       bool     `yaml:"enabled"`
+    Sink          string   `yaml:"sink"`
+    URL           string   `yaml:"url"`
+    Topic         string   `yaml:"topic"`
+    SyslogNetwork string   `yaml:"syslog_network"`
+    SyslogAddress string   `yaml:"syslog_address"`
+    Buffer        int      `yaml:"buffer"`
+    BatchSize     int      `yaml:"batch_size"`
+    FlushMs       int      `yaml:"flush_ms"`
+    Block         bool     `yaml:"block"`
+    // Operations replaces the audited ORM operations, e.g. ["Create", "Update", "Delete"].
+    Operations    []string `yaml:"operations"`
+}
+
+// ConsistencyConfig controls the background jobs measuring drift between SQL and derived stores.
+type ConsistencyConfig struct {
+    IntervalMins int              `yaml:"interval_minutes"`
+    Search       DriftCheckConfig `yaml:"search"`
+    Cache        DriftCheckConfig `yaml:"cache"`
+}
+
+// DriftCheckConfig configures one drift check. SampleSize 0 scans every row.
+type DriftCheckConfig struct {
+    Enabled    bool `yaml:"enabled"`
+    SampleSize int  `yaml:"sample_size"`
+    Repair     bool `yaml:"repair"`
+}
+
+// DataloaderConfig controls the per-request batching of ID lookups.
+type DataloaderConfig struct {
+    WaitMs          int `yaml:"wait_ms"`
+    MaxBatch        int `yaml:"max_batch"`
+    CacheTTLSeconds int `yaml:"cache_ttl_seconds"`
 }
@@ -0,0 +1,330 @@
+package config
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+
+    "github.com/BurntSushi/toml"
+    "gopkg.in/yaml.v2"
+)
+
+// DefaultPath is the base configuration file loaded when no other is given.
+const DefaultPath = "config/config.yaml"
+
+// DefaultEnvPrefix prefixes the environment variables overriding settings.
+const DefaultEnvPrefix = "PL_"
+
+// Environment variables choosing the base file, the environment and the remote source, e.g.
+// PL_CONFIG_FILE, PL_ENV, PL_REMOTE_CONFIG and PL_REMOTE_CACHE, skipped as settings.
+const (
+    FileVariable        = "CONFIG_FILE"
+    EnvVariable         = "ENV"
+    RemoteVariable      = "REMOTE_CONFIG"
+    RemoteTokenVariable = "REMOTE_TOKEN"
+    RemoteCacheVariable = "REMOTE_CACHE"
+)
+
+// Loader assembles the configuration from layered sources, each overriding the settings of the
+// ones before it:
+//
+//  1. the base file at Path, in YAML (.yaml, .yml), JSON (.json) or TOML (.toml), optional when
+//     Remote is set;
+//  2. the Remote key in Consul or etcd, in any of the same formats;
+//  3. the overlay of environment Env, if present: the file next to the base named after it, e.g.
+//     config/config.prod.yaml, in any of the same formats;
+//  4. environment variables named EnvPrefix followed by the setting's path in upper case, with
+//     "__" between levels, e.g. PL_MYSQL_DSN or PL_RATE_LIMIT__REQUESTS=200;
+//  5. Overrides, "path=value" pairs with "." between levels, e.g. "rate_limit.requests=200", as
+//     passed with -set on the command line.
+//
+// Files and overlays are merged key by key, so an overlay only needs the settings it changes.
+// Values of environment variables and overrides are parsed as YAML, e.g. "true", "5" or "[a, b]",
+// except for string settings, which take the value as it is, so a password of "yes" or "0123"
+// stays a string. Environment variables naming no setting are skipped with a warning.
+// Environment variable names are lowercased, so settings under keys with capitals, such as
+// policies.Product, can only be overridden with Overrides.
+type Loader struct {
+    Path      string
+    Env       string
+    EnvPrefix string // Environment variables are ignored when empty.
+    Overrides []string
+    Remote    *Remote
+}
+
+// Load reads every layer, applies defaults and validates the result. A configuration failing
+// validation is returned with a *ValidationError listing every problem.
+func (l Loader) Load() (*Config, error) {
+    merged, err := l.merged()
+    if err != nil {
+        return nil, err
+    }
+    data, err := yaml.Marshal(merged)
+    if err != nil {
+        return nil, err
+    }
+    var cfg Config
+    if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+        return &cfg, err
+    }
+    if cfg.Environment == "" {
+        cfg.Environment = l.Env
+    }
+    cfg.ApplyDefaults()
+    return &cfg, cfg.Validate()
+}
+
+// Files returns the configuration files Load reads, base first.
+func (l Loader) Files() []string {
+    var files []string
+    if _, err := os.Stat(l.path()); err == nil || l.Remote == nil {
+        files = append(files, l.path())
+    }
+    if overlay := l.overlay(); overlay != "" {
+        files = append(files, overlay)
+    }
+    return files
+}
+
+func (l Loader) path() string {
+    if l.Path == "" {
+        return DefaultPath
+    }
+    return l.Path
+}
+
+// overlay returns the path of the environment's overlay file, or "" when there is none.
+func (l Loader) overlay() string {
+    if l.Env == "" {
+        return ""
+    }
+    base := l.path()
+    stem := strings.TrimSuffix(base, filepath.Ext(base)) + "." + l.Env
+    for _, ext := range []string{".yaml", ".yml", ".json", ".toml"} {
+        if _, err := os.Stat(stem + ext); err == nil {
+            return stem + ext
+        }
+    }
+    return ""
+}
+
+// merged returns the settings of every layer merged into one tree.
+func (l Loader) merged() (map[string]interface{}, error) {
+    merged := map[string]interface{}{}
+    files := l.Files()
+    if len(files) > 0 && files[0] == l.path() {
+        if err := mergeFile(merged, files[0]); err != nil {
+            return nil, err
+        }
+        files = files[1:]
+    }
+    if l.Remote != nil {
+        data, err := l.Remote.read(context.Background())
+        if err != nil {
+            return nil, err
+        }
+        settings, err := decode(l.Remote.Key, data, ".yaml")
+        if err != nil {
+            return nil, err
+        }
+        merge(merged, settings)
+    }
+    for _, file := range files {
+        if err := mergeFile(merged, file); err != nil {
+            return nil, err
+        }
+    }
+    if l.EnvPrefix != "" {
+        var names []string
+        values := map[string]string{}
+        for _, entry := range os.Environ() {
+            name, value, _ := strings.Cut(entry, "=")
+            setting := strings.TrimPrefix(name, l.EnvPrefix)
+            switch setting {
+            case name, "", FileVariable, EnvVariable, RemoteVariable, RemoteTokenVariable, RemoteCacheVariable:
+                continue
+            }
+            names = append(names, name)
+            values[name] = value
+        }
+        sort.Strings(names) // Applies parents before children, e.g. PL_REDIS before PL_REDIS__APP.
+        for _, name := range names {
+            path := strings.Split(strings.ToLower(strings.TrimPrefix(name, l.EnvPrefix)), "__")
+            if settingType(path) == nil {
+                log.Printf("Ignoring environment variable %s: no setting %s", name, strings.Join(path, "."))
+                continue
+            }
+            if err := set(merged, path, values[name]); err != nil {
+                return nil, fmt.Errorf("environment variable %s: %w", name, err)
+            }
+        }
+    }
+    for _, override := range l.Overrides {
+        path, value, ok := strings.Cut(override, "=")
+        if !ok || path == "" {
+            return nil, fmt.Errorf("override %q: expected path=value", override)
+        }
+        if err := set(merged, strings.Split(path, "."), value); err != nil {
+            return nil, fmt.Errorf("override %q: %w", override, err)
+        }
+    }
+    return merged, nil
+}
+
+// mergeFile merges the settings of the configuration file at path into tree.
+func mergeFile(tree map[string]interface{}, path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    settings, err := decode(path, data, "")
+    if err != nil {
+        return err
+    }
+    merge(tree, settings)
+    return nil
+}
+
+// decode parses data by the extension of name, or else by fallback, into a tree of settings.
+func decode(name string, data []byte, fallback string) (map[string]interface{}, error) {
+    ext := strings.ToLower(filepath.Ext(name))
+    if ext == "" {
+        ext = fallback
+    }
+    var settings interface{}
+    var err error
+    switch ext {
+    case ".yaml", ".yml":
+        err = yaml.Unmarshal(data, &settings)
+    case ".json":
+        err = json.Unmarshal(data, &settings)
+    case ".toml":
+        var table map[string]interface{}
+        _, err = toml.Decode(string(data), &table)
+        settings = table
+    default:
+        return nil, fmt.Errorf("%s: unsupported configuration format %q (expected .yaml, .yml, .json or .toml)", name, ext)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", name, err)
+    }
+    if settings == nil {
+        return map[string]interface{}{}, nil
+    }
+    tree, ok := normalize(settings).(map[string]interface{})
+    if !ok {
+        return nil, fmt.Errorf("%s: expected a mapping of settings at the top level", name)
+    }
+    return tree, nil
+}
+
+// normalize converts the maps decoded from YAML, keyed by interface{}, to maps keyed by string.
+func normalize(value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[interface{}]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for key, item := range v {
+            out[fmt.Sprint(key)] = normalize(item)
+        }
+        return out
+    case map[string]interface{}:
+        for key, item := range v {
+            v[key] = normalize(item)
+        }
+        return v
+    case []interface{}:
+        for i, item := range v {
+            v[i] = normalize(item)
+        }
+        return v
+    }
+    return value
+}
+
+// merge copies the settings of overlay into base, merging nested mappings key by key.
+func merge(base, overlay map[string]interface{}) {
+    for key, value := range overlay {
+        if nested, ok := value.(map[string]interface{}); ok {
+            if existing, ok := base[key].(map[string]interface{}); ok {
+                merge(existing, nested)
+                continue
+            }
+        }
+        base[key] = value
+    }
+}
+
+// set stores value at path in tree, creating the mappings along the way. Values of string
+// settings are taken as they are; others are parsed as YAML, or else taken as a string.
+func set(tree map[string]interface{}, path []string, value string) error {
+    var parsed interface{} = value
+    if t := settingType(path); t == nil || t.Kind() != reflect.String {
+        if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+            parsed = value // Not YAML, e.g. a DSN starting with "%"; taken as a plain string.
+        }
+    }
+    for i, key := range path {
+        if key == "" {
+            return fmt.Errorf("empty key in %q", strings.Join(path, "."))
+        }
+        if i == len(path)-1 {
+            tree[key] = normalize(parsed)
+            return nil
+        }
+        next, ok := tree[key].(map[string]interface{})
+        if !ok {
+            next = map[string]interface{}{}
+            tree[key] = next
+        }
+        tree = next
+    }
+    return nil
+}
+
+// settingType returns the Go type of the setting at path in Config, following yaml tags, map
+// values and slice elements, or nil when no setting has that path.
+func settingType(path []string) reflect.Type {
+    t := reflect.TypeOf(Config{})
+    for _, key := range path {
+        for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+            t = t.Elem()
+        }
+        switch t.Kind() {
+        case reflect.Struct:
+            field, ok := yamlField(t, key)
+            if !ok {
+                return nil
+            }
+            t = field.Type
+        case reflect.Map:
+            t = t.Elem()
+        case reflect.Interface:
+            return t
+        default:
+            return nil
+        }
+    }
+    return t
+}
+
+// yamlField returns the field of struct type t that the yaml key names.
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+        if name == "" {
+            name = strings.ToLower(field.Name)
+        }
+        if name == key {
+            return field, true
+        }
+    }
+    return reflect.StructField{}, false
+}
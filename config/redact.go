@@ -0,0 +1,85 @@
+package config
+
+import (
+    "regexp"
+    "strings"
+
+    "github.com/go-sql-driver/mysql"
+    "gopkg.in/yaml.v2"
+)
+
+// Mask replaces secrets in the output of Redacted.
+const Mask = "*****"
+
+// secretSettings are the settings whose whole value is a secret, by key.
+var secretSettings = map[string]bool{
+    "anonymize_key":  true,
+    "jwt_secret":     true,
+    "param_hash_key": true,
+    "password":       true,
+    "token":          true,
+    "secret":         true,
+    "secret_key":     true,
+    "sentry_dsn":     true,
+}
+
+// uriPassword matches the password of a URI, e.g. "mongodb://app:s3cret@db:27017".
+var uriPassword = regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://[^:/@\s]*:)(\S*)(@)`)
+
+// keywordPassword matches the password of a key/value DSN, e.g. "host=db password=s3cret".
+var keywordPassword = regexp.MustCompile(`(?i)(\bpassword=)('[^']*'|\S+)`)
+
+// Redacted returns the settings of c as a tree keyed by their YAML names, with secrets masked:
+// secret settings such as param_hash_key entirely, and the passwords in URIs and DSNs, so that
+// e.g. "mysql://app:s3cret@db:3306/app" reads "mysql://app:*****@db:3306/app".
+func (c *Config) Redacted() (map[string]interface{}, error) {
+    data, err := yaml.Marshal(c)
+    if err != nil {
+        return nil, err
+    }
+    var tree interface{}
+    if err := yaml.Unmarshal(data, &tree); err != nil {
+        return nil, err
+    }
+    redacted, _ := redact("", normalize(tree)).(map[string]interface{})
+    return redacted, nil
+}
+
+// redact masks the secrets in value, found under key.
+func redact(key string, value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for k, item := range v {
+            v[k] = redact(k, item)
+        }
+        return v
+    case []interface{}:
+        for i, item := range v {
+            v[i] = redact(key, item)
+        }
+        return v
+    case string:
+        if v == "" {
+            return v
+        }
+        if secretSettings[strings.ToLower(key)] {
+            return Mask
+        }
+        return RedactCredentials(v)
+    }
+    return value
+}
+
+// RedactCredentials masks the password in a URI, a MySQL DSN or a key/value DSN, returning other
+// strings unchanged.
+func RedactCredentials(s string) string {
+    if uriPassword.MatchString(s) {
+        return uriPassword.ReplaceAllString(s, "${1}"+Mask+"${3}")
+    }
+    if strings.Contains(s, "@") {
+        if dsn, err := mysql.ParseDSN(s); err == nil && dsn.Passwd != "" {
+            return strings.Replace(s, ":"+dsn.Passwd+"@", ":"+Mask+"@", 1)
+        }
+    }
+    return keywordPassword.ReplaceAllString(s, "${1}"+Mask)
+}
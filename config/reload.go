@@ -0,0 +1,118 @@
+package config
+
+import (
+    "log"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce lets editors finish writing, often in several events, before the file is read.
+const reloadDebounce = 250 * time.Millisecond
+
+// withoutReloadable returns a copy of c with the settings that may change at runtime cleared:
+// logging, the rate limit's requests and window, feature flags and the cache TTLs of policies.
+func withoutReloadable(c *Config) Config {
+    stripped := *c
+    stripped.Logging = LoggingConfig{}
+    stripped.FeatureFlags.Flags = nil
+    stripped.RateLimit.Requests, stripped.RateLimit.WindowSeconds = 0, 0
+    if c.Policies != nil {
+        stripped.Policies = make(map[string]PolicyConfig, len(c.Policies))
+        for name, policy := range c.Policies {
+            policy.CacheTTLSeconds = 0
+            stripped.Policies[name] = policy
+        }
+    }
+    return stripped
+}
+
+// NonReloadableChanges returns the YAML names of the top-level settings that differ between old and
+// updated other than in reloadable fields, e.g. "sql_dsn"; these need a restart to take effect.
+func NonReloadableChanges(old, updated *Config) []string {
+    a, b := withoutReloadable(old), withoutReloadable(updated)
+    va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+    var changed []string
+    for i := 0; i < va.NumField(); i++ {
+        if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+            name := strings.Split(va.Type().Field(i).Tag.Get("yaml"), ",")[0]
+            changed = append(changed, name)
+        }
+    }
+    sort.Strings(changed)
+    return changed
+}
+
+// Watch reloads the configuration with loader whenever one of its files or its remote key changes
+// and calls apply with the previous and new configuration until stop is closed. Configurations that
+// fail to load, or change settings that can't be reloaded, are rejected with a log message and the
+// running configuration stays in place. Directories are watched rather than files, so replacing a
+// file by a rename, as many editors do, is seen too.
+func Watch(loader Loader, current *Config, apply func(old, updated *Config), stop <-chan struct{}) error {
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return err
+    }
+    files := map[string]bool{}
+    for _, file := range loader.Files() {
+        files[filepath.Clean(file)] = true
+        if err := watcher.Add(filepath.Dir(file)); err != nil {
+            watcher.Close()
+            return err
+        }
+    }
+    sources := loader.Files()
+    remoteChanges := make(chan struct{}, 1)
+    if loader.Remote != nil {
+        sources = append(sources, loader.Remote.String())
+        go loader.Remote.subscribe(stop, func() {
+            select {
+            case remoteChanges <- struct{}{}:
+            default:
+            }
+        })
+    }
+    path := strings.Join(sources, ", ")
+    go func() {
+        defer watcher.Close()
+        var pending <-chan time.Time
+        for {
+            select {
+            case <-stop:
+                return
+            case event, ok := <-watcher.Events:
+                if !ok {
+                    return
+                }
+                if files[filepath.Clean(event.Name)] && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+                    pending = time.After(reloadDebounce)
+                }
+            case <-remoteChanges:
+                pending = time.After(reloadDebounce)
+            case err, ok := <-watcher.Errors:
+                if !ok {
+                    return
+                }
+                log.Printf("Watching %s failed: %v", path, err)
+            case <-pending:
+                pending = nil
+                updated, err := loader.Load()
+                if err != nil {
+                    log.Printf("Ignoring invalid configuration in %s: %v", path, err)
+                    continue
+                }
+                if changed := NonReloadableChanges(current, updated); len(changed) > 0 {
+                    log.Printf("Ignoring reload of %s: %s cannot change without a restart", path, strings.Join(changed, ", "))
+                    continue
+                }
+                apply(current, updated)
+                current = updated
+            }
+        }
+    }()
+    return nil
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Remote reads the configuration from a key of Consul's or etcd's key-value store, for hosts
+// without configuration files. The last configuration read is cached in CacheFile and used while
+// the store is unreachable, so instances still start during an outage.
+type Remote struct {
+    Kind      string // "consul" or "etcd"
+    Address   string // Base URL of the HTTP API, e.g. http://consul:8500.
+    Key       string // e.g. config/persistence-layer.yaml; its extension names the format, YAML by default.
+    Token     string // ACL token for Consul, auth token for etcd.
+    CacheFile string // Written with mode 0600; no cache when empty.
+    // ReadTimeout bounds the read at startup, after which the cache is used; 10s when zero.
+    ReadTimeout time.Duration
+    // PollInterval paces change checks on etcd, which are polled; Consul's are blocking queries.
+    PollInterval time.Duration
+    Client       *http.Client
+}
+
+// remoteWait is how long a Consul blocking query waits for a change before returning.
+const remoteWait = 5 * time.Minute
+
+// defaultRemoteReadTimeout bounds the startup read when Remote.ReadTimeout is zero.
+const defaultRemoteReadTimeout = 10 * time.Second
+
+// ParseRemote parses a remote configuration source of the form
+//
+//     consul://consul:8500/config/persistence-layer.yaml
+//     etcd+https://etcd:2379/config/persistence-layer.json
+//
+// where "+https" selects TLS. The cache file defaults to one named after the key in the user's
+// cache directory, e.g. ~/.cache/persistence-layer, rather than a shared temporary directory
+// where another user could plant it; there is no cache when the user has no cache directory.
+func ParseRemote(spec string) (*Remote, error) {
+    u, err := url.Parse(spec)
+    if err != nil {
+        return nil, fmt.Errorf("remote configuration: not a valid URI")
+    }
+    kind, scheme, _ := strings.Cut(u.Scheme, "+")
+    if scheme == "" {
+        scheme = "http"
+    }
+    if kind != "consul" && kind != "etcd" || scheme != "http" && scheme != "https" {
+        return nil, fmt.Errorf("remote configuration: scheme %q is not consul, etcd, consul+https or etcd+https", u.Scheme)
+    }
+    key := strings.TrimPrefix(u.Path, "/")
+    if u.Host == "" || key == "" {
+        return nil, fmt.Errorf("remote configuration: expected %s://host:port/key", u.Scheme)
+    }
+    remote := &Remote{
+        Kind:         kind,
+        Address:      scheme + "://" + u.Host,
+        Key:          key,
+        PollInterval: 10 * time.Second,
+    }
+    if dir, err := os.UserCacheDir(); err == nil {
+        remote.CacheFile = filepath.Join(dir, "persistence-layer", strings.ReplaceAll(key, "/", "_"))
+    }
+    return remote, nil
+}
+
+// String describes the source without its token, for log messages.
+func (r *Remote) String() string {
+    return r.Kind + " key " + r.Key + " at " + r.Address
+}
+
+// read returns the configuration stored under the key, falling back to the cache when the store
+// can't be reached within ReadTimeout.
+func (r *Remote) read(ctx context.Context) ([]byte, error) {
+    timeout := r.ReadTimeout
+    if timeout <= 0 {
+        timeout = defaultRemoteReadTimeout
+    }
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+    data, _, err := r.fetch(ctx, 0)
+    if err == nil {
+        if r.CacheFile != "" {
+            if err := r.writeCache(data); err != nil {
+                log.Printf("Caching remote configuration in %s failed: %v", r.CacheFile, err)
+            }
+        }
+        return data, nil
+    }
+    if r.CacheFile == "" {
+        return nil, err
+    }
+    cached, cacheErr := ioutil.ReadFile(r.CacheFile)
+    if cacheErr != nil {
+        return nil, fmt.Errorf("%w (no cached configuration: %v)", err, cacheErr)
+    }
+    log.Printf("Using configuration cached in %s: %v", r.CacheFile, err)
+    return cached, nil
+}
+
+// writeCache replaces the cache file through a temporary file of unpredictable name, readable
+// only by the user, as the configuration holds credentials.
+func (r *Remote) writeCache(data []byte) error {
+    dir := filepath.Dir(r.CacheFile)
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return err
+    }
+    tmp, err := ioutil.TempFile(dir, filepath.Base(r.CacheFile)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), r.CacheFile)
+}
+
+// fetch returns the value of the key and its version: Consul's modify index or etcd's mod
+// revision. On Consul a non-zero index blocks until the version moves past it or remoteWait ends.
+func (r *Remote) fetch(ctx context.Context, index uint64) ([]byte, uint64, error) {
+    if r.Kind == "etcd" {
+        return r.fetchEtcd(ctx)
+    }
+    return r.fetchConsul(ctx, index)
+}
+
+func (r *Remote) fetchConsul(ctx context.Context, index uint64) ([]byte, uint64, error) {
+    query := url.Values{"raw": {""}}
+    if index > 0 {
+        query.Set("index", strconv.FormatUint(index, 10))
+        query.Set("wait", remoteWait.String())
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.Address+"/v1/kv/"+r.Key+"?"+query.Encode(), nil)
+    if err != nil {
+        return nil, 0, err
+    }
+    if r.Token != "" {
+        req.Header.Set("X-Consul-Token", r.Token)
+    }
+    resp, err := r.client().Do(req)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer resp.Body.Close()
+    data, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, 0, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, 0, fmt.Errorf("reading %s: %s", r, resp.Status)
+    }
+    version, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+    return data, version, nil
+}
+
+// fetchEtcd reads the key through etcd's v3 JSON gateway, which encodes keys and values in base64
+// and 64-bit integers as strings.
+func (r *Remote) fetchEtcd(ctx context.Context) ([]byte, uint64, error) {
+    body, err := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(r.Key))})
+    if err != nil {
+        return nil, 0, err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Address+"/v3/kv/range", bytes.NewReader(body))
+    if err != nil {
+        return nil, 0, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if r.Token != "" {
+        req.Header.Set("Authorization", r.Token)
+    }
+    resp, err := r.client().Do(req)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        _, _ = io.Copy(ioutil.Discard, resp.Body)
+        return nil, 0, fmt.Errorf("reading %s: %s", r, resp.Status)
+    }
+    var out struct {
+        Kvs []struct {
+            Value       string `json:"value"`
+            ModRevision string `json:"mod_revision"`
+        } `json:"kvs"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+        return nil, 0, fmt.Errorf("reading %s: %w", r, err)
+    }
+    if len(out.Kvs) == 0 {
+        return nil, 0, fmt.Errorf("reading %s: key not found", r)
+    }
+    data, err := base64.StdEncoding.DecodeString(out.Kvs[0].Value)
+    if err != nil {
+        return nil, 0, fmt.Errorf("reading %s: %w", r, err)
+    }
+    version, _ := strconv.ParseUint(out.Kvs[0].ModRevision, 10, 64)
+    return data, version, nil
+}
+
+// subscribe calls changed whenever the key's version moves, until stop is closed. Errors are
+// logged and retried after a pause.
+func (r *Remote) subscribe(stop <-chan struct{}, changed func()) {
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        <-stop
+        cancel()
+    }()
+    interval := r.PollInterval
+    if interval <= 0 {
+        interval = 10 * time.Second
+    }
+    var version uint64
+    for first := true; ctx.Err() == nil; first = false {
+        if !first && (r.Kind == "etcd" || version == 0) {
+            if !sleep(ctx, interval) {
+                return
+            }
+        }
+        _, latest, err := r.fetch(ctx, version)
+        if err != nil {
+            if ctx.Err() != nil {
+                return
+            }
+            log.Printf("Watching %s failed: %v", r, err)
+            if !sleep(ctx, 5*time.Second) {
+                return
+            }
+            continue
+        }
+        if version > 0 && latest != version {
+            changed()
+        }
+        if latest < version {
+            latest = 0 // The index went backwards, e.g. after a Consul restore; start over.
+        }
+        version = latest
+    }
+}
+
+func (r *Remote) client() *http.Client {
+    if r.Client != nil {
+        return r.Client
+    }
+    return &http.Client{Timeout: remoteWait + 30*time.Second}
+}
+
+// sleep waits for d, reporting false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return false
+    case <-timer.C:
+        return true
+    }
+}
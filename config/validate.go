@@ -0,0 +1,426 @@
+package config
+
+import (
+    "fmt"
+    "net"
+    "net/url"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/go-sql-driver/mysql"
+    "github.com/rs/zerolog"
+)
+
+// ValidationError lists every problem found in a configuration, so all of them can be fixed at once.
+type ValidationError struct {
+    Problems []string
+}
+
+func (e *ValidationError) Error() string {
+    return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// ApplyDefaults fills in the documented defaults of settings left empty.
+func (c *Config) ApplyDefaults() {
+    if c.SchemaDrift == "" {
+        c.SchemaDrift = "log"
+    }
+    if c.Logging.SQLParams == "" {
+        c.Logging.SQLParams = "redact"
+    }
+    if c.Elasticsearch.Flavor == "" {
+        c.Elasticsearch.Flavor = "elasticsearch"
+    }
+    for _, databases := range []map[string]DatabaseConfig{c.Databases, c.Replicas} {
+        for name, db := range databases {
+            if db.Driver == "" {
+                db.Driver = "postgres"
+                databases[name] = db
+            }
+        }
+    }
+    for name, policy := range c.Policies {
+        if len(policy.Backends) == 0 {
+            policy.Backends = []string{"sql"}
+            c.Policies[name] = policy
+        }
+    }
+    if c.RateLimit.WindowSeconds <= 0 {
+        c.RateLimit.WindowSeconds = 1
+    }
+    if c.Quotas.WindowSeconds <= 0 {
+        c.Quotas.WindowSeconds = 86400
+    }
+    if c.QueryBudget.MaxCalls <= 0 {
+        c.QueryBudget.MaxCalls = 50
+    }
+    if c.FeatureFlags.RefreshSeconds <= 0 {
+        c.FeatureFlags.RefreshSeconds = 10
+    }
+    if c.RBAC.ReloadSeconds <= 0 {
+        c.RBAC.ReloadSeconds = 30
+    }
+    if c.Outbox.IntervalMs <= 0 {
+        c.Outbox.IntervalMs = 1000
+    }
+    if c.Webhooks.IntervalMs <= 0 {
+        c.Webhooks.IntervalMs = 1000
+    }
+    if c.IndexPool.Overflow == "" {
+        c.IndexPool.Overflow = "block"
+    }
+    if c.CDC.Group == "" {
+        c.CDC.Group = "cdc"
+    }
+    if c.Audit.Sink == "" {
+        c.Audit.Sink = "syslog"
+    }
+}
+
+// Validate checks the formats of URIs and DSNs, the settings each enabled backend and feature
+// requires and the values of enumerated settings, returning a *ValidationError listing every
+// problem found, or nil.
+func (c *Config) Validate() error {
+    var problems []string
+    add := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Sprintf(format, args...))
+    }
+    sql, mongo := c.BackendEnabled("sql"), c.BackendEnabled("mongo")
+    redis, es := c.BackendEnabled("redis"), c.BackendEnabled("elasticsearch")
+
+    for _, backend := range c.DisabledBackends {
+        if !oneOf(strings.ToLower(backend), "sql", "mongo", "redis", "elasticsearch") {
+            add("disabled_backends: unknown backend %q (expected sql, mongo, redis or elasticsearch)", backend)
+        }
+    }
+    for backend, seconds := range c.Startup.BackendWaitSeconds {
+        if !oneOf(backend, "sql", "mongo", "redis", "elasticsearch") {
+            add("startup.backend_wait_seconds: unknown backend %q (expected sql, mongo, redis or elasticsearch)", backend)
+        }
+        if seconds < 0 {
+            add("startup.backend_wait_seconds.%s: must not be negative", backend)
+        }
+    }
+    if sql {
+        if _, err := mysql.ParseDSN(c.MySQLDSN); err != nil {
+            add("mysql_dsn: %v (expected e.g. user:password@tcp(host:3306)/db?parseTime=true)", err)
+        }
+        if c.StandbyDSN != "" {
+            if _, err := mysql.ParseDSN(c.StandbyDSN); err != nil {
+                add("standby_dsn: %v", err)
+            }
+        }
+    }
+    if mongo {
+        checkURI(add, "mongo_uri", c.MongoURI, "mongodb", "mongodb+srv")
+    }
+    if redis {
+        checkURI(add, "redis_uri", c.RedisURI, "redis", "rediss", "unix")
+    }
+    if es {
+        if len(c.Elasticsearch.Addresses) == 0 {
+            checkURI(add, "es_uri", c.ElasticsearchURI, "http", "https")
+        }
+        for i, address := range c.Elasticsearch.Addresses {
+            checkURI(add, fmt.Sprintf("elasticsearch.addresses[%d]", i), address, "http", "https")
+        }
+    }
+    if !oneOf(c.Elasticsearch.Flavor, "elasticsearch", "opensearch") {
+        add("elasticsearch.flavor: %q is not elasticsearch or opensearch", c.Elasticsearch.Flavor)
+    }
+    for name, db := range c.Databases {
+        checkDatabase(add, "databases."+name, db)
+    }
+    for name, db := range c.Replicas {
+        checkDatabase(add, "replicas."+name, db)
+        if _, ok := c.Databases[name]; !ok && name != "default" {
+            add("replicas.%s: no database %q (expected default or a name under databases)", name, name)
+        }
+    }
+
+    if !oneOf(c.SchemaDrift, "log", "fail", "off") {
+        add("schema_drift: %q is not log, fail or off", c.SchemaDrift)
+    }
+    if c.Logging.Level != "" {
+        if _, err := zerolog.ParseLevel(c.Logging.Level); err != nil {
+            add("logging.level: %q is not a log level (expected debug, info, warn or error)", c.Logging.Level)
+        }
+    }
+    if !oneOf(c.Logging.SQLParams, "redact", "hash", "debug") {
+        add("logging.sql_params: %q is not redact, hash or debug", c.Logging.SQLParams)
+    }
+    checkLogRule := func(path string, rate *float64, perSecond int) {
+        if rate != nil && (*rate < 0 || *rate > 1) {
+            add("%s.sample_rate: must be between 0 and 1", path)
+        }
+        if perSecond < 0 {
+            add("%s.max_per_second: must not be negative", path)
+        }
+    }
+    checkLogRule("logging", c.Logging.SampleRate, c.Logging.MaxPerSecond)
+    for message, rule := range c.Logging.Messages {
+        checkLogRule(fmt.Sprintf("logging.messages[%q]", message), rule.SampleRate, rule.MaxPerSecond)
+    }
+    if c.Logging.SlowOperationMs < 0 {
+        add("logging.slow_operation_ms: must not be negative")
+    }
+    if c.ErrorReporting.SentryDSN != "" {
+        if u, err := url.Parse(c.ErrorReporting.SentryDSN); err != nil || u.User == nil || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+            add("error_reporting.sentry_dsn: expected e.g. https://<key>@o1.ingest.sentry.io/42")
+        }
+    }
+    if rate := c.ErrorReporting.SampleRate; rate != nil && (*rate < 0 || *rate > 1) {
+        add("error_reporting.sample_rate: must be between 0 and 1")
+    }
+    if c.Logging.SQLParams == "hash" && c.Logging.ParamHashKey == "" {
+        add("logging.param_hash_key: required when sql_params is hash")
+    }
+    for name, timeout := range map[string]time.Duration{
+        "sql_read": c.Timeouts.SQLRead, "sql_write": c.Timeouts.SQLWrite,
+        "mongo_read": c.Timeouts.MongoRead, "mongo_write": c.Timeouts.MongoWrite,
+        "redis": c.Timeouts.Redis, "es_search": c.Timeouts.ESSearch, "es_write": c.Timeouts.ESWrite,
+    } {
+        if timeout < 0 {
+            add("timeouts.%s: must not be negative", name)
+        }
+    }
+    if c.Pagination.Count != "" && !oneOf(c.Pagination.Count, "none", "exact", "estimated") {
+        add("pagination.count: %q is not none, exact or estimated", c.Pagination.Count)
+    }
+    if c.Pagination.MaxSize > 0 && c.Pagination.DefaultSize > c.Pagination.MaxSize {
+        add("pagination.default_size: exceeds max_size")
+    }
+    if c.MetricsAddr != "" {
+        if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+            add("metrics_addr: %v (expected host:port, e.g. :9090)", err)
+        }
+    }
+    if c.GraphQLAddr != "" {
+        if _, _, err := net.SplitHostPort(c.GraphQLAddr); err != nil {
+            add("graphql_addr: %v (expected host:port, e.g. :8080)", err)
+        }
+        if c.Auth.JWTSecret == "" {
+            add("graphql_addr: needs auth.jwt_secret, so requests are authenticated")
+        }
+    }
+    if c.GRPCWeb.Addr != "" {
+        if _, _, err := net.SplitHostPort(c.GRPCWeb.Addr); err != nil {
+            add("grpc_web.addr: %v (expected host:port, e.g. :8081)", err)
+        }
+        if len(c.GRPCWeb.AllowedOrigins) == 0 {
+            add("grpc_web.allowed_origins: required when grpc_web.addr is set")
+        }
+        if !c.Dev() {
+            add("grpc_web.addr: only served in the dev environment, not %q", c.Environment)
+        }
+    }
+    for _, origin := range c.GRPCWeb.AllowedOrigins {
+        if origin == "*" && !c.Dev() {
+            add("grpc_web.allowed_origins: \"*\" is only allowed in the dev environment")
+        }
+    }
+    if c.Discovery.Kind != "" {
+        if !oneOf(c.Discovery.Kind, "consul", "etcd") {
+            add("discovery.kind: %q is not consul or etcd", c.Discovery.Kind)
+        }
+        if c.Discovery.Address == "" {
+            add("discovery.address: required when discovery.kind is set")
+        }
+        if c.Discovery.Advertise != "" {
+            if _, _, err := net.SplitHostPort(c.Discovery.Advertise); err != nil {
+                add("discovery.advertise: %v (expected host:port, e.g. 10.0.3.7:50051)", err)
+            }
+        }
+        if c.Discovery.CheckInterval < 0 || c.Discovery.DeregisterAfter < 0 {
+            add("discovery: check_interval and deregister_after must not be negative")
+        }
+    }
+
+    for name, policy := range c.Policies {
+        for _, backend := range policy.Backends {
+            switch backend {
+            case "sql":
+                if !sql {
+                    add("policies.%s: stored in sql, which is disabled", name)
+                }
+            case "mongo":
+                if !mongo {
+                    add("policies.%s: stored in mongo, which is disabled", name)
+                }
+            default:
+                add("policies.%s.backends: unknown backend %q (expected sql or mongo)", name, backend)
+            }
+        }
+        if policy.Cache && !redis {
+            add("policies.%s.cache: needs redis, which is disabled", name)
+        }
+        if policy.Index != "" && !es {
+            add("policies.%s.index: needs elasticsearch, which is disabled", name)
+        }
+        for _, database := range append([]string{policy.Database}, policy.Shards...) {
+            if _, ok := c.Databases[database]; database != "" && database != "default" && !ok {
+                add("policies.%s: no database %q under databases", name, database)
+            }
+        }
+        if len(policy.Shards) > 0 && policy.ShardKey == "" {
+            add("policies.%s.shard_key: required with shards", name)
+        }
+    }
+
+    if c.Quotas.Enabled && !redis {
+        add("quotas: needs redis, which is disabled")
+    }
+    if c.RateLimit.Enabled {
+        if !redis {
+            add("rate_limit: needs redis, which is disabled")
+        }
+        if c.RateLimit.Requests <= 0 {
+            add("rate_limit.requests: must be positive")
+        }
+    }
+    checkLimits := func(path string, limits MethodLimitConfig) {
+        if limits.MaxRequestBytes < 0 || limits.MaxResponseBytes < 0 || limits.LatencySLOMs < 0 {
+            add("%s: limits must not be negative", path)
+        }
+    }
+    checkLimits("method_limits.default", c.MethodLimits.Default)
+    for method, limits := range c.MethodLimits.Methods {
+        if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+            add("method_limits.methods.%s: must be a full method name like /proto.ProductService/CreateProduct", method)
+        }
+        checkLimits("method_limits.methods."+method, limits)
+    }
+    for name, flag := range c.FeatureFlags.Flags {
+        if flag.Percentage < 0 || flag.Percentage > 100 {
+            add("feature_flags.flags.%s.percentage: must be between 0 and 100", name)
+        }
+    }
+    if c.Auth.LeewaySeconds < 0 {
+        add("auth.leeway_seconds: must not be negative")
+    }
+    if c.RBAC.Enabled && !sql {
+        add("rbac: needs sql, which is disabled")
+    }
+    if c.RBAC.Enabled && c.Auth.JWTSecret == "" {
+        add("rbac: needs auth.jwt_secret, so roles come from verified tokens")
+    }
+    if len(c.Webhooks.Models) > 0 && !sql {
+        add("webhooks: needs sql, which is disabled")
+    }
+    if len(c.Webhooks.Models) > 0 && c.Webhooks.SecretKey == "" {
+        add("webhooks.secret_key: required to encrypt the endpoints' signing secrets")
+    }
+    if c.CDC.Enabled {
+        if !redis {
+            add("cdc: needs redis, which is disabled")
+        }
+        if len(c.CDC.Streams) == 0 {
+            add("cdc.streams: required when cdc is enabled")
+        }
+    }
+    if c.IndexPool.Enabled {
+        if !es {
+            add("index_pool: needs elasticsearch, which is disabled")
+        }
+        if c.IndexPool.Workers < 0 || c.IndexPool.QueueSize < 0 {
+            add("index_pool: workers and queue_size must not be negative")
+        }
+        switch c.IndexPool.Overflow {
+        case "block":
+        case "outbox":
+            if !sql {
+                add("index_pool.overflow: outbox needs sql, which is disabled")
+            }
+        default:
+            add("index_pool.overflow: %q is not block or outbox", c.IndexPool.Overflow)
+        }
+    }
+    if c.Audit.Enabled {
+        switch c.Audit.Sink {
+        case "syslog":
+        case "http":
+            checkURI(add, "audit.url", c.Audit.URL, "http", "https")
+        case "kafka":
+            checkURI(add, "audit.url", c.Audit.URL, "http", "https")
+            if c.Audit.Topic == "" {
+                add("audit.topic: required by the kafka sink")
+            }
+        default:
+            add("audit.sink: %q is not syslog, http or kafka", c.Audit.Sink)
+        }
+    }
+    if c.Chaos.Enabled {
+        for backend, rule := range c.Chaos.Backends {
+            for _, rate := range []float64{rule.LatencyRate, rule.ErrorRate, rule.DropRate} {
+                if rate < 0 || rate > 1 {
+                    add("chaos.backends.%s: rates must be between 0 and 1", backend)
+                    break
+                }
+            }
+        }
+    }
+    if c.Retention.Enabled {
+        for i, policy := range c.Retention.Policies {
+            if policy.Table == "" || policy.TimeColumn == "" || policy.MaxAgeDays <= 0 {
+                add("retention.policies[%d]: table, time_column and a positive max_age_days are required", i)
+            }
+            if policy.ArchiveTable == "" && c.Retention.ExportDir == "" {
+                add("retention.policies[%d]: needs an archive_table or retention.export_dir", i)
+            }
+        }
+    }
+
+    if len(problems) > 0 {
+        sort.Strings(problems)
+        return &ValidationError{Problems: problems}
+    }
+    return nil
+}
+
+// checkDatabase validates the driver and DSN of a named SQL database.
+func checkDatabase(add func(string, ...interface{}), path string, db DatabaseConfig) {
+    switch db.Driver {
+    case "mysql":
+        if _, err := mysql.ParseDSN(db.DSN); err != nil {
+            add("%s.dsn: %v", path, err)
+        }
+    case "postgres":
+        if db.DSN == "" {
+            add("%s.dsn: required", path)
+        } else if strings.Contains(db.DSN, "://") {
+            checkURI(add, path+".dsn", db.DSN, "postgres", "postgresql")
+        }
+    default:
+        add("%s.driver: %q is not postgres or mysql", path, db.Driver)
+    }
+}
+
+// checkURI validates that uri parses, has a host and uses one of schemes.
+func checkURI(add func(string, ...interface{}), path, uri string, schemes ...string) {
+    if uri == "" {
+        add("%s: required", path)
+        return
+    }
+    u, err := url.Parse(uri)
+    if err != nil {
+        add("%s: not a valid URI", path) // The error would repeat the URI and any password in it.
+        return
+    }
+    if !oneOf(u.Scheme, schemes...) {
+        add("%s: scheme %q is not %s", path, u.Scheme, strings.Join(schemes, " or "))
+        return
+    }
+    if u.Host == "" && u.Scheme != "unix" {
+        add("%s: no host", path)
+    }
+}
+
+func oneOf(value string, options ...string) bool {
+    for _, option := range options {
+        if value == option {
+            return true
+        }
+    }
+    return false
+}
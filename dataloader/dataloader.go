@@ -0,0 +1,289 @@
+package dataloader
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+    "reflect"
+    "sync"
+    "time"
+)
+
+// Config controls how a Loader groups lookups into batches.
+type Config struct {
+    // Wait is how long the first lookup of a batch waits for others to join it.
+    Wait time.Duration
+    // MaxBatch dispatches a batch early once it holds this many keys.
+    MaxBatch int
+    // CacheTTL, when positive, writes records read from SQL back to the cache. Models whose
+    // policy caches them use the policy's CacheTTL instead.
+    CacheTTL time.Duration
+}
+
+// DefaultConfig batches lookups made within a millisecond of each other, up to 100 keys.
+var DefaultConfig = Config{Wait: time.Millisecond, MaxBatch: 100}
+
+// result is the outcome of loading one key; done is closed once value or err is set.
+type result struct {
+    done  chan struct{}
+    value reflect.Value
+    err   error
+}
+
+// batch collects the keys waiting for the next dispatch.
+type batch struct {
+    keys    []interface{}
+    results []*result
+    timer   *time.Timer
+}
+
+// Loader batches and deduplicates lookups of one model by key. Concurrent Load calls within the
+// configured window are answered by one multi-key cache read plus one ReadMany for the misses, and
+// every key is loaded at most once for the lifetime of the Loader; only failed reads are retried. Loaders are meant to live for a
+// single request; see WithLoaders.
+type Loader struct {
+    orm       *orm.ORM
+    modelType reflect.Type
+    cfg       Config
+
+    mu      sync.Mutex
+    results map[string]*result
+    pending *batch
+}
+
+// NewLoader creates a Loader for the type of model, e.g. &models.User{}.
+func NewLoader(o *orm.ORM, model interface{}, cfg Config) *Loader {
+    if cfg.Wait <= 0 {
+        cfg.Wait = DefaultConfig.Wait
+    }
+    if cfg.MaxBatch <= 0 {
+        cfg.MaxBatch = DefaultConfig.MaxBatch
+    }
+    t := reflect.TypeOf(model)
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    return &Loader{orm: o, modelType: t, cfg: cfg, results: make(map[string]*result)}
+}
+
+// Load reads the record with key into dest, a pointer to the Loader's model type. It returns
+// utils.ErrNotFound when no such record exists.
+func (l *Loader) Load(ctx context.Context, key interface{}, dest interface{}) error {
+    r := l.enqueue(key)
+    select {
+    case <-r.done:
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+    if r.err != nil {
+        return r.err
+    }
+    target := reflect.ValueOf(dest)
+    if target.Kind() != reflect.Ptr || target.Elem().Type() != l.modelType {
+        return fmt.Errorf("dest must be a *%s, got %T", l.modelType.Name(), dest)
+    }
+    target.Elem().Set(r.value)
+    return nil
+}
+
+// LoadMany reads the records with the given keys into dest, a pointer to a slice of the model type,
+// in key order. Keys without a record are skipped.
+func (l *Loader) LoadMany(ctx context.Context, keys []interface{}, dest interface{}) error {
+    slice := reflect.ValueOf(dest)
+    if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice || slice.Elem().Type().Elem() != l.modelType {
+        return fmt.Errorf("dest must be a *[]%s, got %T", l.modelType.Name(), dest)
+    }
+    results := make([]*result, len(keys))
+    for i, key := range keys {
+        results[i] = l.enqueue(key)
+    }
+    for _, r := range results {
+        select {
+        case <-r.done:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+        if errors.Is(r.err, utils.ErrNotFound) {
+            continue
+        }
+        if r.err != nil {
+            return r.err
+        }
+        slice.Elem().Set(reflect.Append(slice.Elem(), r.value))
+    }
+    return nil
+}
+
+// enqueue returns the memoized result for key, adding the key to the pending batch on first use.
+func (l *Loader) enqueue(key interface{}) *result {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    id := fmt.Sprint(key)
+    if r, ok := l.results[id]; ok {
+        return r
+    }
+    r := &result{done: make(chan struct{})}
+    l.results[id] = r
+
+    if l.pending == nil {
+        b := &batch{}
+        b.timer = time.AfterFunc(l.cfg.Wait, func() { l.flush(b) })
+        l.pending = b
+    }
+    b := l.pending
+    b.keys = append(b.keys, key)
+    b.results = append(b.results, r)
+    if len(b.keys) >= l.cfg.MaxBatch {
+        b.timer.Stop()
+        l.pending = nil
+        go l.dispatch(b)
+    }
+    return r
+}
+
+// flush dispatches b when its wait elapses, unless it was already dispatched for being full.
+func (l *Loader) flush(b *batch) {
+    l.mu.Lock()
+    if l.pending != b {
+        l.mu.Unlock()
+        return
+    }
+    l.pending = nil
+    l.mu.Unlock()
+    l.dispatch(b)
+}
+
+// dispatch answers a batch from the cache where possible and from SQL for the rest.
+func (l *Loader) dispatch(b *batch) {
+    found := make(map[string]reflect.Value, len(b.keys))
+    model := reflect.New(l.modelType).Interface()
+
+    if l.orm.Redis != nil {
+        cacheKeys := make([]string, len(b.keys))
+        for i, key := range b.keys {
+            cacheKeys[i] = orm.CacheKey(model, key)
+        }
+        // A failed cache read only means every key is read from SQL.
+        if hits, err := l.orm.GetCacheMany(cacheKeys); err == nil {
+            for i, key := range b.keys {
+                data, ok := hits[cacheKeys[i]]
+                if !ok {
+                    continue
+                }
+                record := reflect.New(l.modelType)
+                if json.Unmarshal(data, record.Interface()) == nil {
+                    found[fmt.Sprint(key)] = record.Elem()
+                }
+            }
+        }
+    }
+
+    var misses []interface{}
+    for _, key := range b.keys {
+        if _, ok := found[fmt.Sprint(key)]; !ok {
+            misses = append(misses, key)
+        }
+    }
+    var err error
+    if len(misses) > 0 {
+        records := reflect.New(reflect.SliceOf(reflect.PtrTo(l.modelType)))
+        if err = l.orm.ReadMany(misses, records.Interface()); err == nil {
+            ttl := l.cacheTTL(model)
+            for i := 0; i < records.Elem().Len(); i++ {
+                record := records.Elem().Index(i)
+                key, keyErr := orm.ModelKey(record.Interface())
+                if keyErr != nil {
+                    err = keyErr
+                    break
+                }
+                found[fmt.Sprint(key)] = record.Elem()
+                if ttl > 0 && l.orm.Redis != nil {
+                    _ = l.orm.SetCache(orm.CacheKey(model, key), record.Interface(), ttl)
+                }
+            }
+        }
+    }
+
+    for i, key := range b.keys {
+        r := b.results[i]
+        if value, ok := found[fmt.Sprint(key)]; ok {
+            r.value = value
+        } else if err != nil {
+            r.err = err
+            if !errors.Is(err, utils.ErrNotFound) {
+                l.forget(key, r)
+            }
+        } else {
+            r.err = utils.ErrNotFound
+        }
+        close(r.done)
+    }
+}
+
+// forget drops the memoized result of key after a failed read, so the next Load of the key reads
+// it again rather than repeating a transient error. Callers already waiting get the error.
+func (l *Loader) forget(key interface{}, r *result) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if id := fmt.Sprint(key); l.results[id] == r {
+        delete(l.results, id)
+    }
+}
+
+type registryKey struct{}
+
+// registry holds the loaders of one request, one per model type.
+type registry struct {
+    orm     *orm.ORM
+    cfg     Config
+    mu      sync.Mutex
+    loaders map[reflect.Type]*Loader
+}
+
+// WithLoaders returns a context carrying a fresh set of loaders, typically installed once per RPC by
+// interceptors.Dataloaders. The loaders read through o bound to ctx.
+func WithLoaders(ctx context.Context, o *orm.ORM, cfg Config) context.Context {
+    return context.WithValue(ctx, registryKey{}, &registry{
+        orm:     o.WithContext(ctx),
+        cfg:     cfg,
+        loaders: make(map[reflect.Type]*Loader),
+    })
+}
+
+// For returns the request's Loader for the type of model, so every lookup of that type during the
+// request shares batches and results:
+//
+//     var author models.User
+//     err := dataloader.For(ctx, s.orm, &models.User{}).Load(ctx, post.UserID, &author)
+//
+// Without loaders in ctx it returns a new Loader over o with DefaultConfig.
+func For(ctx context.Context, o *orm.ORM, model interface{}) *Loader {
+    reg, ok := ctx.Value(registryKey{}).(*registry)
+    if !ok {
+        return NewLoader(o.WithContext(ctx), model, DefaultConfig)
+    }
+    t := reflect.TypeOf(model)
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    reg.mu.Lock()
+    defer reg.mu.Unlock()
+    l, ok := reg.loaders[t]
+    if !ok {
+        l = NewLoader(reg.orm, model, reg.cfg)
+        reg.loaders[t] = l
+    }
+    return l
+}
+
+// cacheTTL is the lifetime of records of model written back to the cache; 0 skips the write.
+func (l *Loader) cacheTTL(model interface{}) time.Duration {
+    if policy := l.orm.PolicyFor(model); policy.Cache {
+        return policy.CacheTTL
+    }
+    return l.cfg.CacheTTL
+}
@@ -0,0 +1,267 @@
+// Package discovery registers the gRPC server in Consul's or etcd's service catalog on startup and
+// removes it on shutdown, so clients find instances by service name instead of hard-coded
+// addresses. Consul checks the instance through the standard gRPC health service on the advertised
+// port, which interceptors.Authenticate and interceptors.Tenant let through without credentials;
+// etcd keeps the registration under a lease the instance renews, so it disappears when the
+// instance dies.
+package discovery
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Registration describes the instance to register.
+type Registration struct {
+    Kind    string // "consul" or "etcd".
+    Address string // Base URL of the HTTP API, e.g. http://consul:8500.
+    Token   string // ACL token for Consul, auth token for etcd.
+
+    Name string   // Service name, e.g. "persistence-layer".
+    ID   string   // Instance ID; defaults to "<name>-<host>-<port>".
+    Tags []string // Consul tags; stored with the instance in etcd.
+    // Advertise is the host:port clients dial, e.g. "10.0.3.7:50051".
+    Advertise string
+    // CheckInterval paces Consul's health checks and etcd's lease renewals; defaults to 10s.
+    CheckInterval time.Duration
+    // DeregisterAfter is how long an unhealthy or silent instance stays registered; defaults to 1m.
+    DeregisterAfter time.Duration
+    // Prefix is the etcd key prefix of registrations, "services" by default: the instance is stored
+    // as JSON under "<prefix>/<name>/<id>".
+    Prefix string
+
+    Client *http.Client
+}
+
+// Instance is the value stored under an etcd registration key.
+type Instance struct {
+    ID      string   `json:"id"`
+    Name    string   `json:"name"`
+    Address string   `json:"address"`
+    Tags    []string `json:"tags,omitempty"`
+}
+
+// Register registers the instance and returns a function that deregisters it, to call on shutdown.
+// For etcd the lease is renewed in the background until ctx ends or deregister is called.
+func Register(ctx context.Context, r Registration) (deregister func(), err error) {
+    if err := r.defaults(); err != nil {
+        return nil, err
+    }
+    switch r.Kind {
+    case "consul":
+        if err := r.registerConsul(ctx); err != nil {
+            return nil, err
+        }
+        log.Printf("Registered %s as %s in Consul at %s", r.Advertise, r.ID, r.Address)
+        return func() {
+            if err := r.deregisterConsul(); err != nil {
+                log.Printf("Deregistering %s from Consul failed: %v", r.ID, err)
+            }
+        }, nil
+    case "etcd":
+        lease, err := r.registerEtcd(ctx)
+        if err != nil {
+            return nil, err
+        }
+        log.Printf("Registered %s as %s in etcd at %s", r.Advertise, r.ID, r.Address)
+        keepCtx, stop := context.WithCancel(ctx)
+        go r.keepAlive(keepCtx, lease)
+        return func() {
+            stop()
+            if err := r.call(context.Background(), "/v3/lease/revoke", map[string]string{"ID": lease}, nil); err != nil {
+                log.Printf("Deregistering %s from etcd failed: %v", r.ID, err)
+            }
+        }, nil
+    }
+    return nil, fmt.Errorf("discovery: kind %q is not consul or etcd", r.Kind)
+}
+
+// defaults fills in the optional fields and checks the address to advertise.
+func (r *Registration) defaults() error {
+    host, port, err := net.SplitHostPort(r.Advertise)
+    if err != nil {
+        return fmt.Errorf("discovery: advertise address %q: %w", r.Advertise, err)
+    }
+    if r.Name == "" {
+        return fmt.Errorf("discovery: service name is required")
+    }
+    if r.ID == "" {
+        r.ID = r.Name + "-" + strings.ReplaceAll(host, ":", "_") + "-" + port
+    }
+    if r.CheckInterval <= 0 {
+        r.CheckInterval = 10 * time.Second
+    }
+    if r.DeregisterAfter <= 0 {
+        r.DeregisterAfter = time.Minute
+    }
+    if r.Prefix == "" {
+        r.Prefix = "services"
+    }
+    r.Address = strings.TrimSuffix(r.Address, "/")
+    return nil
+}
+
+func (r *Registration) registerConsul(ctx context.Context) error {
+    host, portText, _ := net.SplitHostPort(r.Advertise)
+    port, err := strconv.Atoi(portText)
+    if err != nil {
+        return fmt.Errorf("discovery: advertise port %q: %w", portText, err)
+    }
+    service := map[string]interface{}{
+        "ID":      r.ID,
+        "Name":    r.Name,
+        "Tags":    r.Tags,
+        "Address": host,
+        "Port":    port,
+        "Check": map[string]interface{}{
+            "GRPC":                           r.Advertise,
+            "Interval":                       r.CheckInterval.String(),
+            "DeregisterCriticalServiceAfter": r.DeregisterAfter.String(),
+        },
+    }
+    return r.consul(ctx, "/v1/agent/service/register", service)
+}
+
+func (r *Registration) deregisterConsul() error {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    return r.consul(ctx, "/v1/agent/service/deregister/"+r.ID, nil)
+}
+
+// consul sends a PUT to Consul's agent API.
+func (r *Registration) consul(ctx context.Context, path string, body interface{}) error {
+    var payload io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        payload = bytes.NewReader(data)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPut, r.Address+path, payload)
+    if err != nil {
+        return err
+    }
+    if r.Token != "" {
+        req.Header.Set("X-Consul-Token", r.Token)
+    }
+    resp, err := r.client().Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("consul %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+    }
+    return nil
+}
+
+// registerEtcd grants a lease living DeregisterAfter and stores the instance under it. It returns
+// the lease ID.
+func (r *Registration) registerEtcd(ctx context.Context) (string, error) {
+    var grant struct {
+        ID string `json:"ID"`
+    }
+    ttl := int64(r.DeregisterAfter / time.Second)
+    if err := r.call(ctx, "/v3/lease/grant", map[string]interface{}{"TTL": strconv.FormatInt(ttl, 10)}, &grant); err != nil {
+        return "", err
+    }
+    if grant.ID == "" {
+        return "", fmt.Errorf("etcd lease grant returned no lease")
+    }
+    value, err := json.Marshal(Instance{ID: r.ID, Name: r.Name, Address: r.Advertise, Tags: r.Tags})
+    if err != nil {
+        return "", err
+    }
+    key := r.Prefix + "/" + r.Name + "/" + r.ID
+    err = r.call(ctx, "/v3/kv/put", map[string]string{
+        "key":   base64.StdEncoding.EncodeToString([]byte(key)),
+        "value": base64.StdEncoding.EncodeToString(value),
+        "lease": grant.ID,
+    }, nil)
+    return grant.ID, err
+}
+
+// keepAlive renews the lease every CheckInterval until ctx ends, registering again if the lease was
+// lost, e.g. while etcd was unreachable for longer than DeregisterAfter.
+func (r *Registration) keepAlive(ctx context.Context, lease string) {
+    ticker := time.NewTicker(r.CheckInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        var out struct {
+            Result struct {
+                TTL string `json:"TTL"`
+            } `json:"result"`
+        }
+        err := r.call(ctx, "/v3/lease/keepalive", map[string]string{"ID": lease}, &out)
+        if err == nil && out.Result.TTL != "" && out.Result.TTL != "0" {
+            continue
+        }
+        if ctx.Err() != nil {
+            return
+        }
+        if err != nil {
+            log.Printf("Renewing the etcd lease of %s failed: %v", r.ID, err)
+            continue
+        }
+        if renewed, err := r.registerEtcd(ctx); err != nil {
+            log.Printf("Registering %s in etcd again failed: %v", r.ID, err)
+        } else {
+            lease = renewed
+        }
+    }
+}
+
+// call posts a request to etcd's v3 JSON gateway and decodes its response into out, if not nil.
+func (r *Registration) call(ctx context.Context, path string, body interface{}, out interface{}) error {
+    data, err := json.Marshal(body)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Address+path, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if r.Token != "" {
+        req.Header.Set("Authorization", r.Token)
+    }
+    resp, err := r.client().Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("etcd %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+    }
+    if out == nil {
+        _, _ = io.Copy(ioutil.Discard, resp.Body)
+        return nil
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (r *Registration) client() *http.Client {
+    if r.Client != nil {
+        return r.Client
+    }
+    return &http.Client{Timeout: 10 * time.Second}
+}
@@ -0,0 +1,546 @@
+package fixtures
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+
+    "gopkg.in/yaml.v2"
+    "gorm.io/gorm/schema"
+)
+
+// refPrefix marks a string value that refers to the ID of another fixture row, e.g. "@alice".
+const refPrefix = "@"
+
+// refKey is the reserved field naming a SQL fixture row so other rows can reference its ID.
+const refKey = "_ref"
+
+// keyKey is the reserved field listing the columns, or Mongo fields, that identify a row when
+// upserting, e.g. `_key: email` or `_key: [post_id, tag_id]`.
+const keyKey = "_key"
+
+// repeatKey is the reserved field repeating a row, e.g. `_repeat: 1000` for load tests. Every
+// "{n}" in the row's strings, including its _ref and references, becomes the copy's number from 1.
+const repeatKey = "_repeat"
+
+// File is the on-disk fixture format, in YAML or JSON:
+//
+//     sql:
+//       users:
+//         - {_ref: alice, name: Alice, email: alice@example.com}
+//       posts:
+//         - {_ref: hello, title: Hello, user_id: "@alice"}
+//     mongo:
+//       post_views:
+//         - {post_id: "@hello", views: 3}
+//
+// SQL tables are inserted in dependency order derived from their references, so a table is
+// always loaded after the tables whose rows it points at. Rows may repeat themselves with _repeat
+// and name their identifying fields for Loader.Upsert with _key:
+//
+//     sql:
+//       users:
+//         - {_ref: "user{n}", _repeat: 1000, _key: email, name: "User {n}", email: "user{n}@example.com"}
+type File struct {
+    SQL   map[string][]map[string]interface{} `yaml:"sql" json:"sql"`
+    Mongo map[string][]map[string]interface{} `yaml:"mongo" json:"mongo"`
+}
+
+// Loader inserts fixture files through the ORM and remembers the IDs assigned to referenced rows.
+type Loader struct {
+    // Upsert updates rows that already exist instead of inserting them again, so a file can be
+    // loaded repeatedly without duplicating rows. A row is matched on its _key fields, by default
+    // its id, or _id in Mongo, when it has one and all of its fields otherwise.
+    Upsert bool
+
+    orm    *orm.ORM
+    models map[string]reflect.Type
+    refs   map[string]interface{}
+}
+
+// NewLoader creates a Loader for the given models. Each model's table name follows gorm's naming
+// strategy, so models.User is loaded from the "users" section.
+func NewLoader(o *orm.ORM, models ...interface{}) *Loader {
+    l := &Loader{orm: o, models: make(map[string]reflect.Type), refs: make(map[string]interface{})}
+    for _, model := range models {
+        l.Register(model)
+    }
+    return l
+}
+
+// Register makes a model available to SQL fixture sections named after its table.
+func (l *Loader) Register(model interface{}) {
+    t := reflect.TypeOf(model)
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    l.models[schema.NamingStrategy{}.TableName(t.Name())] = t
+}
+
+// Ref returns the ID or key assigned to a referenced fixture row, for assertions in tests.
+func (l *Loader) Ref(name string) (interface{}, bool) {
+    id, ok := l.refs[name]
+    return id, ok
+}
+
+// LoadFiles parses and loads each file in order. References may point at rows from earlier files.
+func (l *Loader) LoadFiles(paths ...string) error {
+    for _, path := range paths {
+        file, err := ParseFile(path)
+        if err != nil {
+            return err
+        }
+        if err := l.Load(file); err != nil {
+            return fmt.Errorf("loading fixtures from %s: %w", path, err)
+        }
+    }
+    return nil
+}
+
+// ParseFile reads a .yaml, .yml or .json fixture file.
+func ParseFile(path string) (*File, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var file File
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".json":
+        err = json.Unmarshal(data, &file)
+    case ".yaml", ".yml":
+        err = yaml.Unmarshal(data, &file)
+    default:
+        return nil, fmt.Errorf("unsupported fixture file type %q", path)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("parsing fixtures from %s: %w", path, err)
+    }
+    return &file, nil
+}
+
+// Load inserts SQL rows in dependency order, then Mongo documents.
+func (l *Loader) Load(file *File) error {
+    sql, err := expandAll(file.SQL)
+    if err != nil {
+        return err
+    }
+    mongo, err := expandAll(file.Mongo)
+    if err != nil {
+        return err
+    }
+    tables, err := l.tableOrder(sql)
+    if err != nil {
+        return err
+    }
+    for _, table := range tables {
+        for i, row := range sql[table] {
+            if err := l.insertRow(table, row); err != nil {
+                return fmt.Errorf("table %s row %d: %w", table, i, err)
+            }
+        }
+    }
+
+    collections := make([]string, 0, len(mongo))
+    for collection := range mongo {
+        collections = append(collections, collection)
+    }
+    sort.Strings(collections)
+    for _, collection := range collections {
+        for i, doc := range mongo[collection] {
+            if err := l.insertDocument(collection, doc); err != nil {
+                return fmt.Errorf("collection %s document %d: %w", collection, i, err)
+            }
+        }
+    }
+    return nil
+}
+
+// insertDocument creates a Mongo document, or updates the matching one when upserting.
+func (l *Loader) insertDocument(collection string, doc map[string]interface{}) error {
+    if l.orm.Mongo == nil {
+        return fmt.Errorf("mongo backend is disabled")
+    }
+    resolved, err := l.resolve(doc)
+    if err != nil {
+        return err
+    }
+    keys, err := rowKeys(resolved, "_id")
+    if err != nil {
+        return err
+    }
+    if !l.Upsert {
+        return l.orm.Mongo.Create(collection, resolved)
+    }
+    filter, err := keyValues(resolved, keys)
+    if err != nil {
+        return err
+    }
+    var existing map[string]interface{}
+    err = l.orm.Mongo.Read(collection, filter, &existing)
+    if errors.Is(utils.HandleMongoError(err), utils.ErrNotFound) {
+        return l.orm.Mongo.Create(collection, resolved)
+    }
+    if err != nil {
+        return err
+    }
+    _, err = l.orm.Mongo.Update(collection, filter, resolved)
+    return err
+}
+
+// insertRow decodes the row into a new model value, creates it and records its ID under the row's _ref.
+func (l *Loader) insertRow(table string, row map[string]interface{}) error {
+    modelType, ok := l.models[table]
+    if !ok {
+        return fmt.Errorf("no model registered for table %q", table)
+    }
+
+    fields, err := l.resolve(row)
+    if err != nil {
+        return err
+    }
+    ref, _ := fields[refKey].(string)
+    delete(fields, refKey)
+    if ref != "" {
+        if _, exists := l.refs[ref]; exists {
+            return fmt.Errorf("duplicate fixture reference %q", ref)
+        }
+    }
+    keys, err := rowKeys(fields, "id")
+    if err != nil {
+        return err
+    }
+
+    model := reflect.New(modelType).Interface()
+    var existing interface{}
+    if l.Upsert {
+        if existing, err = l.findRow(modelType, fields, keys); err != nil {
+            return err
+        }
+        if existing != nil {
+            model = existing // Fields absent from the fixture keep their stored values.
+        }
+    }
+    data, err := json.Marshal(fields)
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(data, model); err != nil {
+        return err
+    }
+    if existing != nil {
+        _, err = l.orm.Update(model)
+    } else {
+        _, err = l.orm.Create(model)
+    }
+    if err != nil {
+        return err
+    }
+
+    if ref != "" {
+        switch identified := model.(type) {
+        case interface{ GetKey() string }:
+            l.refs[ref] = identified.GetKey()
+        case interface{ GetID() uint64 }:
+            l.refs[ref] = identified.GetID()
+        default:
+            return fmt.Errorf("model for table %q has no GetID or GetKey method, so %q cannot be referenced", table, ref)
+        }
+    }
+    return nil
+}
+
+// findRow returns the stored row of modelType whose key columns hold the row's values, searching
+// every shard of sharded models, or nil when there is none.
+func (l *Loader) findRow(modelType reflect.Type, fields map[string]interface{}, keys []string) (interface{}, error) {
+    conditions, err := keyValues(fields, keys)
+    if err != nil {
+        return nil, err
+    }
+    dbs, err := l.orm.Shards(reflect.New(modelType).Interface())
+    if err != nil {
+        return nil, err
+    }
+    for _, db := range dbs {
+        if db.SQL == nil {
+            return nil, fmt.Errorf("sql backend is disabled")
+        }
+        if db.SQL.GetDB() == nil {
+            return nil, fmt.Errorf("resolving references requires a gorm-backed SQL store")
+        }
+        row := reflect.New(modelType).Interface()
+        result := db.SQL.GetDB().Where(conditions).Limit(1).Find(row)
+        if result.Error != nil {
+            return nil, result.Error
+        }
+        if result.RowsAffected > 0 {
+            return row, nil
+        }
+    }
+    return nil, nil
+}
+
+// rowKeys removes the _key field from a row and returns the fields it names: by default idField
+// when the row sets it, otherwise every field.
+func rowKeys(fields map[string]interface{}, idField string) ([]string, error) {
+    value, ok := fields[keyKey]
+    delete(fields, keyKey)
+    var keys []string
+    switch v := value.(type) {
+    case nil:
+        if ok {
+            return nil, fmt.Errorf("%s is empty", keyKey)
+        }
+        if _, hasID := fields[idField]; hasID {
+            return []string{idField}, nil
+        }
+        for field := range fields {
+            keys = append(keys, field)
+        }
+    case string:
+        keys = []string{v}
+    case []interface{}:
+        for _, item := range v {
+            field, isString := item.(string)
+            if !isString {
+                return nil, fmt.Errorf("%s lists a non-string field %v", keyKey, item)
+            }
+            keys = append(keys, field)
+        }
+    default:
+        return nil, fmt.Errorf("%s must be a field name or a list of them", keyKey)
+    }
+    return keys, nil
+}
+
+// keyValues returns the values of the key fields of a row.
+func keyValues(fields map[string]interface{}, keys []string) (map[string]interface{}, error) {
+    values := make(map[string]interface{}, len(keys))
+    for _, key := range keys {
+        value, ok := fields[key]
+        if !ok {
+            return nil, fmt.Errorf("key field %s is not set", key)
+        }
+        values[key] = value
+    }
+    return values, nil
+}
+
+// resolve replaces "@ref" strings with the referenced row's ID or key, recursing into nested values.
+func (l *Loader) resolve(value map[string]interface{}) (map[string]interface{}, error) {
+    out := make(map[string]interface{}, len(value))
+    for k, v := range value {
+        resolved, err := l.resolveValue(v)
+        if err != nil {
+            return nil, fmt.Errorf("field %s: %w", k, err)
+        }
+        out[k] = resolved
+    }
+    return out, nil
+}
+
+func (l *Loader) resolveValue(value interface{}) (interface{}, error) {
+    switch v := value.(type) {
+    case string:
+        if !strings.HasPrefix(v, refPrefix) {
+            return v, nil
+        }
+        id, ok := l.refs[strings.TrimPrefix(v, refPrefix)]
+        if !ok {
+            return nil, fmt.Errorf("unknown fixture reference %q", v)
+        }
+        return id, nil
+    case map[string]interface{}:
+        return l.resolve(v)
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i, item := range v {
+            resolved, err := l.resolveValue(item)
+            if err != nil {
+                return nil, err
+            }
+            out[i] = resolved
+        }
+        return out, nil
+    }
+    return value, nil
+}
+
+// tableOrder sorts tables topologically so that every table comes after the tables defining the
+// references it uses. References to rows loaded by earlier files impose no ordering.
+func (l *Loader) tableOrder(sql map[string][]map[string]interface{}) ([]string, error) {
+    definedIn := map[string]string{}
+    for table, rows := range sql {
+        for _, row := range rows {
+            if ref, ok := row[refKey].(string); ok && ref != "" {
+                definedIn[ref] = table
+            }
+        }
+    }
+
+    deps := map[string]map[string]bool{}
+    tables := make([]string, 0, len(sql))
+    for table, rows := range sql {
+        tables = append(tables, table)
+        deps[table] = map[string]bool{}
+        for _, row := range rows {
+            for _, ref := range collectRefs(normalize(row)) {
+                if dep, ok := definedIn[ref]; ok && dep != table {
+                    deps[table][dep] = true
+                }
+            }
+        }
+    }
+    sort.Strings(tables)
+
+    var order []string
+    state := map[string]int{} // 0 = unvisited, 1 = visiting, 2 = done
+    var visit func(table string) error
+    visit = func(table string) error {
+        switch state[table] {
+        case 1:
+            return fmt.Errorf("circular fixture references involving table %q", table)
+        case 2:
+            return nil
+        }
+        state[table] = 1
+        depNames := make([]string, 0, len(deps[table]))
+        for dep := range deps[table] {
+            depNames = append(depNames, dep)
+        }
+        sort.Strings(depNames)
+        for _, dep := range depNames {
+            if err := visit(dep); err != nil {
+                return err
+            }
+        }
+        state[table] = 2
+        order = append(order, table)
+        return nil
+    }
+    for _, table := range tables {
+        if err := visit(table); err != nil {
+            return nil, err
+        }
+    }
+    return order, nil
+}
+
+// expandAll normalizes the rows of every table or collection and expands their _repeat fields.
+func expandAll(sections map[string][]map[string]interface{}) (map[string][]map[string]interface{}, error) {
+    out := make(map[string][]map[string]interface{}, len(sections))
+    for name, rows := range sections {
+        for i, row := range rows {
+            expanded, err := expand(normalize(row))
+            if err != nil {
+                return nil, fmt.Errorf("%s row %d: %w", name, i, err)
+            }
+            out[name] = append(out[name], expanded...)
+        }
+    }
+    return out, nil
+}
+
+// expand returns the copies of a row with _repeat, or the row itself.
+func expand(row map[string]interface{}) ([]map[string]interface{}, error) {
+    value, ok := row[repeatKey]
+    if !ok {
+        return []map[string]interface{}{row}, nil
+    }
+    count, isInt := value.(int)
+    if f, isFloat := value.(float64); isFloat && f == float64(int(f)) { // JSON numbers.
+        count, isInt = int(f), true
+    }
+    if !isInt || count < 0 {
+        return nil, fmt.Errorf("%s must be a non-negative integer, not %v", repeatKey, value)
+    }
+    template := make(map[string]interface{}, len(row))
+    for k, v := range row {
+        if k != repeatKey {
+            template[k] = v
+        }
+    }
+    rows := make([]map[string]interface{}, count)
+    for n := 1; n <= count; n++ {
+        rows[n-1] = numbered(template, strconv.Itoa(n)).(map[string]interface{})
+    }
+    return rows, nil
+}
+
+// numbered returns a copy of value with "{n}" replaced by n in every string.
+func numbered(value interface{}, n string) interface{} {
+    switch v := value.(type) {
+    case string:
+        return strings.ReplaceAll(v, "{n}", n)
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for k, item := range v {
+            out[k] = numbered(item, n)
+        }
+        return out
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i, item := range v {
+            out[i] = numbered(item, n)
+        }
+        return out
+    }
+    return value
+}
+
+// collectRefs returns the names of every "@ref" string within a value.
+func collectRefs(value interface{}) []string {
+    var refs []string
+    switch v := value.(type) {
+    case string:
+        if strings.HasPrefix(v, refPrefix) {
+            refs = append(refs, strings.TrimPrefix(v, refPrefix))
+        }
+    case map[string]interface{}:
+        for _, item := range v {
+            refs = append(refs, collectRefs(item)...)
+        }
+    case []interface{}:
+        for _, item := range v {
+            refs = append(refs, collectRefs(item)...)
+        }
+    }
+    return refs
+}
+
+// normalize converts the map[interface{}]interface{} values produced by yaml.v2 into JSON-friendly maps.
+func normalize(row map[string]interface{}) map[string]interface{} {
+    out := make(map[string]interface{}, len(row))
+    for k, v := range row {
+        out[k] = normalizeValue(v)
+    }
+    return out
+}
+
+func normalizeValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[interface{}]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for k, item := range v {
+            out[fmt.Sprint(k)] = normalizeValue(item)
+        }
+        return out
+    case map[string]interface{}:
+        return normalize(v)
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i, item := range v {
+            out[i] = normalizeValue(item)
+        }
+        return out
+    }
+    return value
+}
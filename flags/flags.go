@@ -0,0 +1,225 @@
+// Package flags gates risky persistence behaviors behind feature flags, so they can be rolled out
+// gradually: to some tenants first, then to a growing share of them.
+//
+//     if flags.Enabled(ctx, "async_indexing") {
+//         ...
+//     }
+//
+// Flags are declared in config and may be overridden at runtime through Redis, e.g. to turn a
+// misbehaving feature off across every instance without a deploy.
+package flags
+
+import (
+    "context"
+    "encoding/json"
+    "hash/fnv"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "sort"
+    "sync"
+    "time"
+)
+
+// OverridesKey is the Redis hash holding flag overrides, by flag name, as JSON-encoded Flags.
+const OverridesKey = "feature_flags"
+
+// Flag decides for whom a feature is enabled: everyone when Enabled is set, otherwise the Tenants
+// listed and a stable Percentage (0-100) of the others, picked by a hash of the flag and tenant
+// name so a tenant keeps its answer while the percentage only grows.
+type Flag struct {
+    Enabled    bool     `json:"enabled"`
+    Percentage float64  `json:"percentage"`
+    Tenants    []string `json:"tenants,omitempty"`
+}
+
+// enabledFor reports whether the flag named name is on for tenant.
+func (f Flag) enabledFor(name, tenant string) bool {
+    if f.Enabled {
+        return true
+    }
+    for _, t := range f.Tenants {
+        if t == tenant {
+            return true
+        }
+    }
+    if f.Percentage <= 0 {
+        return false
+    }
+    h := fnv.New32a()
+    _, _ = h.Write([]byte(name + "\x00" + tenant))
+    return float64(h.Sum32()%10000) < f.Percentage*100
+}
+
+// Store holds the flags declared in config and the overrides read from Redis, which take
+// precedence. Unknown flags are off.
+type Store struct {
+    // Tenant returns the tenant of a context, e.g. orm.TenantFromContext; without it flags are
+    // evaluated for the empty tenant.
+    Tenant func(ctx context.Context) string
+
+    redis     adapters.CacheStore
+    mu        sync.RWMutex
+    declared  map[string]Flag
+    overrides map[string]Flag
+}
+
+// NewStore creates a store of the declared flags, reading overrides from redis when it is not nil.
+func NewStore(declared map[string]Flag, redis adapters.CacheStore) *Store {
+    s := &Store{redis: redis}
+    s.SetDeclared(declared)
+    return s
+}
+
+// SetDeclared replaces the flags declared in config, e.g. on a configuration reload.
+func (s *Store) SetDeclared(declared map[string]Flag) {
+    copied := make(map[string]Flag, len(declared))
+    for name, flag := range declared {
+        copied[name] = flag
+    }
+    s.mu.Lock()
+    s.declared = copied
+    s.mu.Unlock()
+}
+
+// Enabled reports whether the flag is on for the tenant of ctx.
+func (s *Store) Enabled(ctx context.Context, name string) bool {
+    flag, ok := s.Get(name)
+    if !ok {
+        return false
+    }
+    var tenant string
+    if s.Tenant != nil && ctx != nil {
+        tenant = s.Tenant(ctx)
+    }
+    return flag.enabledFor(name, tenant)
+}
+
+// Get returns the flag in effect under name and whether there is one.
+func (s *Store) Get(name string) (Flag, bool) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    if flag, ok := s.overrides[name]; ok {
+        return flag, true
+    }
+    flag, ok := s.declared[name]
+    return flag, ok
+}
+
+// All returns the flags in effect by name.
+func (s *Store) All() map[string]Flag {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    all := make(map[string]Flag, len(s.declared)+len(s.overrides))
+    for name, flag := range s.declared {
+        all[name] = flag
+    }
+    for name, flag := range s.overrides {
+        all[name] = flag
+    }
+    return all
+}
+
+// Names returns the names of the flags in effect, sorted.
+func (s *Store) Names() []string {
+    all := s.All()
+    names := make([]string, 0, len(all))
+    for name := range all {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Override stores flag in Redis, overriding its declaration on every instance once they refresh,
+// this one at once.
+func (s *Store) Override(name string, flag Flag) error {
+    if s.redis == nil {
+        return utils.ErrBackendDisabled
+    }
+    data, err := json.Marshal(flag)
+    if err != nil {
+        return err
+    }
+    if err := s.redis.HSet(OverridesKey, map[string]string{name: string(data)}, 0); err != nil {
+        return err
+    }
+    return s.Refresh()
+}
+
+// ClearOverride removes the Redis override of a flag, restoring its declaration.
+func (s *Store) ClearOverride(name string) error {
+    if s.redis == nil {
+        return utils.ErrBackendDisabled
+    }
+    if err := s.redis.HDel(OverridesKey, name); err != nil {
+        return err
+    }
+    return s.Refresh()
+}
+
+// Refresh reloads the overrides from Redis. Overrides that fail to decode are logged and skipped.
+func (s *Store) Refresh() error {
+    if s.redis == nil {
+        return nil
+    }
+    fields, err := s.redis.HGetAll(OverridesKey)
+    if err != nil {
+        return err
+    }
+    overrides := make(map[string]Flag, len(fields))
+    for name, data := range fields {
+        var flag Flag
+        if err := json.Unmarshal([]byte(data), &flag); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Flags Refresh", "flag": name})
+            continue
+        }
+        overrides[name] = flag
+    }
+    s.mu.Lock()
+    s.overrides = overrides
+    s.mu.Unlock()
+    return nil
+}
+
+// Run refreshes the overrides every interval until the context is cancelled. While Redis is
+// unreachable the last overrides read stay in effect.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := s.Refresh(); err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "Flags Refresh"})
+            }
+        }
+    }
+}
+
+var (
+    defaultMu    sync.RWMutex
+    defaultStore *Store
+)
+
+// SetDefault makes store the one read by the package-level Enabled.
+func SetDefault(store *Store) {
+    defaultMu.Lock()
+    defaultStore = store
+    defaultMu.Unlock()
+}
+
+// Default returns the store set with SetDefault, or nil.
+func Default() *Store {
+    defaultMu.RLock()
+    defer defaultMu.RUnlock()
+    return defaultStore
+}
+
+// Enabled reports whether the flag is on for the tenant of ctx in the default store. Every flag is
+// off until SetDefault is called.
+func Enabled(ctx context.Context, name string) bool {
+    store := Default()
+    return store != nil && store.Enabled(ctx, name)
+}
@@ -9,6 +9,7 @@ SCHEMA_DIR = "schemas"
 MODEL_DIR = "models"
 PROTO_DIR = "proto"
 SERVICE_DIR = "services"
+CLIENT_DIR = "client"
 
 # Map JSON schema types to Go types
 type_mapping = {
@@ -30,6 +31,23 @@ def convert_field_name(field):
     
     return camel_cased
 
+def enum_type_name(model_name, field):
+    return f"{model_name}{convert_field_name(field)}"
+
+def enum_value_name(value):
+    name = convert_field_name(re.sub(r'[^0-9A-Za-z]+', '_', str(value)).lower())
+    if not name or name[0].isdigit():
+        name = f"V{name}"
+    return name
+
+def upper_snake(name):
+    # Must match protoConstant in types/enum.go.
+    name = re.sub(r'([a-z])([A-Z])', r'\1_\2', name)
+    return re.sub(r'[^0-9A-Za-z]', '_', name).upper()
+
+def enum_fields(properties):
+    return {field: specs for field, specs in properties.items() if "enum" in specs}
+
 def load_schema(schema_name):
     with open(f"{SCHEMA_DIR}/{schema_name}_schema.json") as f:
         return json.load(f)
@@ -63,6 +81,10 @@ def generate_go_model(schema_name, schema):
         # Add other types as necessary
     }
 
+    enums = enum_fields(properties)
+    if enums:
+        imports.add("persistence-layer/types")
+
     # Start building the model code
     model_lines.append("import (\n")
     for imp in sorted(imports):
@@ -75,7 +97,9 @@ def generate_go_model(schema_name, schema):
     # Now process each field
     for field, specs in properties.items():
         field_type = type_mapping.get(specs["type"], "interface{}")
-        if "format" in specs and specs["format"] == "date-time":
+        if field in enums:
+            field_type = enum_type_name(model_name, field)
+        elif "format" in specs and specs["format"] == "date-time":
             field_type = type_mapping["date-time"]
         elif specs["type"] == "array" and "items" in specs:
             # Determine the type of array elements
@@ -139,6 +163,10 @@ def generate_go_model(schema_name, schema):
         if validation_tags:
             tags.append(f'validate:"{",".join(validation_tags)}"')
 
+        # Personal data rewritten by orm.Anonymizer, e.g. "x-anonymize": "email"
+        if "x-anonymize" in specs:
+            tags.append(f'anonymize:"{specs["x-anonymize"]}"')
+
         # Combine tags
         tag_str = ' '.join(tags)
 
@@ -148,6 +176,37 @@ def generate_go_model(schema_name, schema):
     # Close struct definition
     model_lines.append("}\n")
 
+    # Generate enum types with their allowed values
+    for field, specs in enums.items():
+        type_name = enum_type_name(model_name, field)
+        values = specs["enum"]
+        if specs["type"] == "integer":
+            model_lines.append(f"\n// {type_name} is an enum; values outside {type_name}Enum are rejected on write.\n")
+            model_lines.append(f"type {type_name} int64\n\n")
+            model_lines.append("const (\n")
+            for value in values:
+                model_lines.append(f"\t{type_name}{enum_value_name(value)} {type_name} = {value}\n")
+            model_lines.append(")\n\n")
+            labels = ", ".join(f'{value}: "{value}"' for value in values)
+            model_lines.append(f'var {type_name}Enum = types.NewIntEnum("{type_name}", map[int64]string{{{labels}}})\n\n')
+            model_lines.append(f"// Valid reports whether the value is one of the allowed {type_name} values.\n")
+            model_lines.append(f"func (e {type_name}) Valid() bool {{\n")
+            model_lines.append(f"\treturn {type_name}Enum.Contains(int64(e))\n")
+            model_lines.append("}\n")
+        else:
+            model_lines.append(f"\n// {type_name} is an enum; values outside {type_name}Enum are rejected on write.\n")
+            model_lines.append(f"type {type_name} string\n\n")
+            model_lines.append("const (\n")
+            for value in values:
+                model_lines.append(f'\t{type_name}{enum_value_name(value)} {type_name} = "{value}"\n')
+            model_lines.append(")\n\n")
+            quoted = ", ".join(f'"{value}"' for value in values)
+            model_lines.append(f'var {type_name}Enum = types.NewStringEnum("{type_name}", {quoted})\n\n')
+            model_lines.append(f"// Valid reports whether the value is one of the allowed {type_name} values.\n")
+            model_lines.append(f"func (e {type_name}) Valid() bool {{\n")
+            model_lines.append(f"\treturn {type_name}Enum.Contains(string(e))\n")
+            model_lines.append("}\n")
+
     # Generate methods for custom types
     for type_name, specs in custom_types.items():
         model_lines.append(f"\n// {type_name} is a custom type for storing a slice of strings as JSON in the database.\n")
@@ -179,6 +238,76 @@ def generate_go_model(schema_name, schema):
 
     print(f"Generated Go model: {model_file_path}")
 
+# Fields the ORM sets, left unconstrained in the protos.
+MANAGED_FIELDS = {"id", "created_at", "updated_at"}
+
+def validate_rules(specs, required, is_enum):
+    """Map the JSON schema constraints of a field to protoc-gen-validate rules, enforced by
+    interceptors.Validate. Returns the field option, or an empty string when there are none."""
+    rules = []
+    if is_enum:
+        rules.append("defined_only: true")
+        if required:
+            rules.append("not_in: [0]")
+        kind = "enum"
+    elif specs.get("format") == "date-time":
+        return " [(validate.rules).timestamp.required = true]" if required else ""
+    elif specs["type"] == "string":
+        kind = "string"
+        min_len = specs.get("minLength", 0)
+        if required:
+            min_len = max(min_len, 1)
+        if min_len:
+            rules.append(f"min_len: {min_len}")
+        if "maxLength" in specs:
+            rules.append(f"max_len: {specs['maxLength']}")
+        formats = {"email": "email", "uri": "uri", "uuid": "uuid", "hostname": "hostname", "ipv4": "ipv4", "ipv6": "ipv6"}
+        if specs.get("format") in formats:
+            rules.append(f"{formats[specs['format']]}: true")
+        if "pattern" in specs:
+            rules.append(f"pattern: {json.dumps(specs['pattern'])}")
+        if rules and not required:
+            # Optional strings may be left empty.
+            rules.append("ignore_empty: true")
+    elif specs["type"] in ("integer", "number"):
+        kind = "uint64" if specs["type"] == "integer" else "double"
+        minimum, maximum = specs.get("minimum"), specs.get("maximum")
+        if kind == "uint64":
+            # uint64 fields cannot hold negative bounds: a minimum at or below 0 always holds and a
+            # negative maximum is clamped to 0.
+            if minimum is not None and minimum <= 0:
+                minimum = None
+            if maximum is not None and maximum < 0:
+                maximum = 0
+        if minimum is not None:
+            rules.append(f"gte: {minimum}")
+        if maximum is not None:
+            rules.append(f"lte: {maximum}")
+    elif specs["type"] == "array":
+        kind = "repeated"
+        if "minItems" in specs:
+            rules.append(f"min_items: {specs['minItems']}")
+        elif required:
+            rules.append("min_items: 1")
+        if "maxItems" in specs:
+            rules.append(f"max_items: {specs['maxItems']}")
+        if specs.get("uniqueItems", False):
+            rules.append("unique: true")
+        item_specs = specs.get("items", {})
+        item_rules = []
+        if item_specs.get("type") == "string":
+            if "minLength" in item_specs:
+                item_rules.append(f"min_len: {item_specs['minLength']}")
+            if "maxLength" in item_specs:
+                item_rules.append(f"max_len: {item_specs['maxLength']}")
+        if item_rules:
+            rules.append(f"items: {{string: {{{', '.join(item_rules)}}}}}")
+    else:
+        return ""
+    if not rules:
+        return ""
+    return f" [(validate.rules).{kind} = {{{', '.join(rules)}}}]"
+
 def generate_proto_file(schema_name, schema):
     model_name = convert_field_name(schema_name)
     properties = schema["properties"]
@@ -190,6 +319,7 @@ def generate_proto_file(schema_name, schema):
         "package proto;\n",
         f'option go_package = "./proto";\n\n',
     ]
+    proto_lines.append('import "validate/validate.proto";\n')
 
     # Check if we need to import google.protobuf.Timestamp
     needs_timestamp_import = any(
@@ -198,6 +328,24 @@ def generate_proto_file(schema_name, schema):
     if needs_timestamp_import:
         proto_lines.append('import "google/protobuf/timestamp.proto";\n\n')
 
+    # Define enums ahead of the message; string enums are numbered from 1 in schema order, matching
+    # types.StringEnum, while integer enums keep their own numbers.
+    enums = enum_fields(properties)
+    for field, specs in enums.items():
+        type_name = enum_type_name(model_name, field)
+        prefix = upper_snake(type_name)
+        proto_lines.append(f"enum {type_name} {{\n")
+        if specs["type"] == "integer":
+            if 0 not in specs["enum"]:
+                proto_lines.append(f"    {prefix}_UNSPECIFIED = 0;\n")
+            for value in specs["enum"]:
+                proto_lines.append(f"    {prefix}_{upper_snake(str(value))} = {value};\n")
+        else:
+            proto_lines.append(f"    {prefix}_UNSPECIFIED = 0;\n")
+            for number, value in enumerate(specs["enum"], start=1):
+                proto_lines.append(f"    {prefix}_{upper_snake(str(value))} = {number};\n")
+        proto_lines.append("}\n\n")
+
     # Start the message definition
     proto_lines.append(f"message {model_name} {{\n")
 
@@ -227,25 +375,31 @@ def generate_proto_file(schema_name, schema):
             proto_type = f"repeated {type_mapping.get(item_type, 'string')}"
         if "format" in specs and specs["format"] == "date-time":
             proto_type = "google.protobuf.Timestamp"  # Use timestamp for date-time fields
+        if field in enums:
+            proto_type = enum_type_name(model_name, field)
 
         # Convert field names to Go-style camel case
         go_field_name = convert_field_name(field)
 
+        rules = ""
+        if field not in MANAGED_FIELDS:
+            rules = validate_rules(specs, field in required_fields, field in enums)
+
         # Add the field to the proto message
-        proto_lines.append(f"    {proto_type} {go_field_name} = {field_counter};\n")
+        proto_lines.append(f"    {proto_type} {go_field_name} = {field_counter}{rules};\n")
         field_counter += 1
 
     proto_lines.append("}\n\n")
 
     # Define service methods for CRUD operations
     proto_lines += [
-        f"message Create{model_name}Request {{\n    {model_name} {schema_name} = 1;\n}}\n",
+        f"message Create{model_name}Request {{\n    {model_name} {schema_name} = 1 [(validate.rules).message.required = true];\n}}\n",
         f"message Create{model_name}Response {{\n    uint64 id = 1;\n    string message = 2;\n}}\n",
-        f"message Get{model_name}Request {{\n    uint64 id = 1;\n}}\n",
+        f"message Get{model_name}Request {{\n    uint64 id = 1 [(validate.rules).uint64.gt = 0];\n}}\n",
         f"message Get{model_name}Response {{\n    {model_name} {schema_name} = 1;\n}}\n",
-        f"message Update{model_name}Request {{\n    {model_name} {schema_name} = 1;\n}}\n",
+        f"message Update{model_name}Request {{\n    {model_name} {schema_name} = 1 [(validate.rules).message.required = true];\n}}\n",
         f"message Update{model_name}Response {{\n    string message = 1;\n}}\n",
-        f"message Delete{model_name}Request {{\n    uint64 id = 1;\n}}\n",
+        f"message Delete{model_name}Request {{\n    uint64 id = 1 [(validate.rules).uint64.gt = 0];\n}}\n",
         f"message Delete{model_name}Response {{\n    string message = 1;\n}}\n",
         f"service {model_name}Service {{\n",
         f"    rpc Create{model_name}(Create{model_name}Request) returns (Create{model_name}Response);\n",
@@ -262,7 +416,19 @@ def generate_proto_file(schema_name, schema):
 
     print(f"Generated gRPC proto file: {proto_file_path}")
     print("To generate the gRPC code, run:")
-    print(f"protoc --go_out=. --go-grpc_out=. {proto_file_path}")
+    print(f"protoc --go_out=. --go-grpc_out=. --validate_out=lang=go:. {proto_file_path}")
+
+def enum_from_proto(model_name, field, specs, expr):
+    type_name = enum_type_name(model_name, field)
+    if specs["type"] == "integer":
+        return f"models.{type_name}({expr})"
+    return f"models.{type_name}(models.{type_name}Enum.FromProto(int32({expr})))"
+
+def enum_to_proto(model_name, field, specs, expr):
+    type_name = enum_type_name(model_name, field)
+    if specs["type"] == "integer":
+        return f"proto.{type_name}({expr})"
+    return f"proto.{type_name}(models.{type_name}Enum.ToProto(string({expr})))"
 
 def generate_service_impl(schema_name, schema):
     model_name = convert_field_name(schema_name)
@@ -273,12 +439,15 @@ def generate_service_impl(schema_name, schema):
         f"package services\n\n",
         f'import (\n',
         f'    "fmt"\n',
-        f'    "time"\n',
         f'    "context"\n',
         f'    "persistence-layer/models"\n',
         f'    "persistence-layer/orm"\n',
         f'    "persistence-layer/proto"\n',
-        f'    "persistence-layer/utils"\n',
+    ]
+    # utils only converts timestamps; errors are mapped by statusError.
+    if any(specs.get("format") == "date-time" for specs in schema["properties"].values()):
+        service_lines.append(f'    "persistence-layer/utils"\n')
+    service_lines += [
         f'    "google.golang.org/grpc"\n',
         f')\n\n',
         f'type {service_name} struct {{\n',
@@ -301,16 +470,18 @@ def generate_service_impl(schema_name, schema):
     # Map fields from proto request to Go model, including conversion for timestamps
     for field, specs in schema["properties"].items():
         go_field_name = convert_field_name(field)
-        if specs.get("format") == "date-time":
+        if "enum" in specs:
+            service_lines.append(f'        {go_field_name}: {enum_from_proto(model_name, field, specs, f"req.{model_name}.{go_field_name}")},\n')
+        elif specs.get("format") == "date-time":
             service_lines.append(f'        {go_field_name}: utils.ToTime(req.{model_name}.{go_field_name}),\n')
         else:
             service_lines.append(f'        {go_field_name}: req.{model_name}.{go_field_name},\n')
 
     service_lines += [
         f'    }}\n\n',
-        f'    err := s.orm.Create(&{schema_name})\n',
+        f'    _, err := s.orm.WithContext(ctx).Create(&{schema_name})\n',
         f'    if err != nil {{\n',
-        f'        return nil, utils.HandleSQLError(err)\n',
+        f'        return nil, statusError(err)\n',
         f'    }}\n\n',
         f'    return &proto.Create{model_name}Response{{\n',
         f'        Id: uint64({schema_name}.ID),\n',
@@ -325,19 +496,19 @@ def generate_service_impl(schema_name, schema):
         f'    var {schema_name} models.{model_name}\n',
         f'    cacheKey := fmt.Sprintf("{schema_name}:%d", uint(req.Id))\n',
         f'    // Attempt to retrieve {schema_name} from cache\n',
-        f'    err := s.orm.GetCache(cacheKey, &{schema_name})\n',
+        f'    err := s.orm.WithContext(ctx).GetCache(cacheKey, &{schema_name})\n',
         f'    fromDb := false\n',
         f'    if err != nil || {schema_name}.ID == 0 {{\n',
         f'        // If {schema_name} is not found in cache, fetch from SQL database\n',
-        f'        err := s.orm.Read(uint(req.Id), &{schema_name})\n',
+        f'        err := s.orm.WithContext(ctx).Read(uint(req.Id), &{schema_name})\n',
         f'        if err != nil {{\n',
-        f'            return nil, utils.HandleSQLError(err)\n',
+        f'            return nil, statusError(err)\n',
         f'        }}\n',
         f'        fromDb = true\n',
         f'    }}\n\n',
-        f'    // Cache the {schema_name} data with a TTL of 10 minutes\n',
-        f'    if fromDb {{\n',
-        f'        _ = s.orm.SetCache(cacheKey, &{schema_name}, 10*time.Minute)\n',
+        f'    // Cache the {schema_name} data for the CacheTTL of its policy, if it is cached\n',
+        f'    if policy := s.orm.PolicyFor(&{schema_name}); fromDb && policy.Cache {{\n',
+        f'        _ = s.orm.WithContext(ctx).SetCache(cacheKey, &{schema_name}, policy.CacheTTL)\n',
         f'    }}\n',
         f'    return &proto.Get{model_name}Response{{\n',
         f'        {model_name}: &proto.{model_name}{{\n',
@@ -345,7 +516,9 @@ def generate_service_impl(schema_name, schema):
     # Map fields from Go model to proto response, including conversion for timestamps
     for field, specs in schema["properties"].items():
         go_field_name = convert_field_name(field)
-        if specs.get("format") == "date-time":
+        if "enum" in specs:
+            service_lines.append(f'            {go_field_name}: {enum_to_proto(model_name, field, specs, f"{schema_name}.{go_field_name}")},\n')
+        elif specs.get("format") == "date-time":
             service_lines.append(f'            {go_field_name}: utils.ToTimestamp({schema_name}.{go_field_name}),\n')
         else:
             service_lines.append(f'            {go_field_name}: {schema_name}.{go_field_name},\n')
@@ -365,19 +538,23 @@ def generate_service_impl(schema_name, schema):
     # Map fields from proto request to Go model for update, including conversion for timestamps
     for field, specs in schema["properties"].items():
         go_field_name = convert_field_name(field)
-        if specs.get("format") == "date-time":
+        if "enum" in specs:
+            service_lines.append(f'        {go_field_name}: {enum_from_proto(model_name, field, specs, f"req.{model_name}.{go_field_name}")},\n')
+        elif specs.get("format") == "date-time":
             service_lines.append(f'        {go_field_name}: utils.ToTime(req.{model_name}.{go_field_name}),\n')
         else:
             service_lines.append(f'        {go_field_name}: req.{model_name}.{go_field_name},\n')
 
     service_lines += [
         f'    }}\n\n',
-        f'    err := s.orm.Update(&{schema_name})\n',
+        f'    _, err := s.orm.WithContext(ctx).Update(&{schema_name})\n',
         f'    if err != nil {{\n',
-        f'        return nil, utils.HandleSQLError(err)\n',
+        f'        return nil, statusError(err)\n',
         f'    }}\n\n',
         f'    cacheKey := fmt.Sprintf("{schema_name}:%d", uint(req.{model_name}.ID))\n',
-        f'    _ = s.orm.SetCache(cacheKey, &{schema_name}, 10*time.Minute)\n',
+        f'    if policy := s.orm.PolicyFor(&{schema_name}); policy.Cache {{\n',
+        f'        _ = s.orm.WithContext(ctx).SetCache(cacheKey, &{schema_name}, policy.CacheTTL)\n',
+        f'    }}\n',
         f'    \n\n',
         f'    return &proto.Update{model_name}Response{{\n',
         f'        Message: "{model_name} updated successfully",\n',
@@ -388,12 +565,12 @@ def generate_service_impl(schema_name, schema):
     # Implement Delete
     service_lines += [
         f'func (s *{service_name}) Delete{model_name}(ctx context.Context, req *proto.Delete{model_name}Request) (*proto.Delete{model_name}Response, error) {{\n',
-        f'    err := s.orm.Delete(uint(req.Id), &models.{model_name}{{}})\n',
+        f'    _, err := s.orm.WithContext(ctx).Delete(uint(req.Id), &models.{model_name}{{}})\n',
         f'    if err != nil {{\n',
-        f'        return nil, utils.HandleSQLError(err)\n',
+        f'        return nil, statusError(err)\n',
         f'    }}\n\n',
         f'    cacheKey := fmt.Sprintf("product:%d", uint(req.Id))\n'
-        f'    _ = s.orm.DeleteCache(cacheKey)\n\n'
+        f'    _ = s.orm.WithContext(ctx).DeleteCache(cacheKey)\n\n'
         f'    return &proto.Delete{model_name}Response{{\n',
         f'        Message: "{model_name} deleted successfully",\n',
         f'    }}, nil\n',
@@ -403,7 +580,10 @@ def generate_service_impl(schema_name, schema):
     service_lines += [
         f'func (s *{service_name}) Register(server *grpc.Server) {{\n',
         f'    proto.Register{convert_field_name(schema_name)}ServiceServer(server, s)\n',
-        f'}}\n\n'
+        f'}}\n\n',
+        f'func init() {{\n',
+        f'    Add("{model_name}Service", func(o *orm.ORM) Service {{ return New{service_name}(o) }})\n',
+        f'}}\n'
     ]
     # Write the service implementation to a file
     with open(service_file_path, "w") as f:
@@ -411,6 +591,66 @@ def generate_service_impl(schema_name, schema):
 
     print(f"Generated gRPC service implementation: {service_file_path}")
 
+def plural(name):
+    # Must match plural in graphql/schema.go.
+    if name.endswith("y") and len(name) > 1 and name[-2] not in "aeiou":
+        return name[:-1] + "ies"
+    if name.endswith(("s", "x", "ch", "sh")):
+        return name + "es"
+    return name + "s"
+
+def generate_client(schema_name):
+    model_name = convert_field_name(schema_name)
+    client_name = f"{model_name}Client"
+    client_file_path = f"{CLIENT_DIR}/{schema_name}_client.go"
+
+    client_lines = [
+        f"package client\n\n",
+        f'import (\n',
+        f'    "context"\n',
+        f'    "persistence-layer/proto"\n',
+        f'    "google.golang.org/grpc"\n',
+        f')\n\n',
+        f'// {client_name} calls the {model_name}Service.\n',
+        f'type {client_name} struct {{\n',
+        f'    stub proto.{model_name}ServiceClient\n',
+        f'}}\n\n',
+        f'// {plural(model_name)} returns the typed helpers of the {model_name}Service.\n',
+        f'func (c *Client) {plural(model_name)}() *{client_name} {{\n',
+        f'    return &{client_name}{{stub: proto.New{model_name}ServiceClient(c)}}\n',
+        f'}}\n\n',
+        f'// Create creates {schema_name} and returns its ID.\n',
+        f'func (c *{client_name}) Create(ctx context.Context, {schema_name} *proto.{model_name}, opts ...grpc.CallOption) (uint64, error) {{\n',
+        f'    resp, err := c.stub.Create{model_name}(ctx, &proto.Create{model_name}Request{{{model_name}: {schema_name}}}, opts...)\n',
+        f'    if err != nil {{\n',
+        f'        return 0, err\n',
+        f'    }}\n',
+        f'    return resp.GetId(), nil\n',
+        f'}}\n\n',
+        f'// Get returns the {schema_name} with the ID.\n',
+        f'func (c *{client_name}) Get(ctx context.Context, id uint64, opts ...grpc.CallOption) (*proto.{model_name}, error) {{\n',
+        f'    resp, err := c.stub.Get{model_name}(ctx, &proto.Get{model_name}Request{{Id: id}}, opts...)\n',
+        f'    if err != nil {{\n',
+        f'        return nil, err\n',
+        f'    }}\n',
+        f'    return resp.Get{model_name}(), nil\n',
+        f'}}\n\n',
+        f'// Update saves {schema_name}, identified by its ID.\n',
+        f'func (c *{client_name}) Update(ctx context.Context, {schema_name} *proto.{model_name}, opts ...grpc.CallOption) error {{\n',
+        f'    _, err := c.stub.Update{model_name}(ctx, &proto.Update{model_name}Request{{{model_name}: {schema_name}}}, opts...)\n',
+        f'    return err\n',
+        f'}}\n\n',
+        f'// Delete deletes the {schema_name} with the ID.\n',
+        f'func (c *{client_name}) Delete(ctx context.Context, id uint64, opts ...grpc.CallOption) error {{\n',
+        f'    _, err := c.stub.Delete{model_name}(ctx, &proto.Delete{model_name}Request{{Id: id}}, opts...)\n',
+        f'    return err\n',
+        f'}}\n',
+    ]
+    with open(client_file_path, "w") as f:
+        f.writelines(client_lines)
+
+    print(f"Generated gRPC client helpers: {client_file_path}")
+
 
 def main(schema_name):
     # Load the JSON schema
@@ -425,6 +665,9 @@ def main(schema_name):
     # Generate the gRPC service implementation based on the schema
     generate_service_impl(schema_name, schema)
 
+    # Generate the typed client helpers of the service
+    generate_client(schema_name)
+
 if __name__ == "__main__":
     if len(sys.argv) != 2:
         # No specific schema provided, process all schemas
@@ -442,8 +685,11 @@ if __name__ == "__main__":
         # Find all .proto files in the 'proto' directory
         proto_files = glob.glob("proto/*.proto")
         if proto_files:
+            # validate/validate.proto comes from protoc-gen-validate; PGV_INCLUDE points at its
+            # checkout when it isn't on protoc's include path.
+            includes = [f"-I{os.environ['PGV_INCLUDE']}", "-I."] if os.environ.get("PGV_INCLUDE") else []
             subprocess.run(
-                ["protoc", "--go_out=.", "--go-grpc_out=.", *proto_files],
+                ["protoc", *includes, "--go_out=.", "--go-grpc_out=.", "--validate_out=lang=go:.", *proto_files],
                 check=True
             )
             print("Successfully generated Go code from proto files.")
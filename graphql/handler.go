@@ -0,0 +1,424 @@
+package graphql
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+    "reflect"
+    "strings"
+
+    "github.com/vektah/gqlparser/v2/gqlerror"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+)
+
+// maxRequestBytes bounds the size of a request body.
+const maxRequestBytes = 1 << 20
+
+// Request is a GraphQL request as POSTed to the handler.
+type Request struct {
+    Query         string                 `json:"query"`
+    OperationName string                 `json:"operationName,omitempty"`
+    Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Fields that failed are null in Data and have an entry in
+// Errors.
+type Response struct {
+    Data   map[string]interface{} `json:"data"`
+    Errors []Error                `json:"errors,omitempty"`
+}
+
+// Error is a failure of a request or of one of its fields, named by Path.
+type Error struct {
+    Message string        `json:"message"`
+    Path    []interface{} `json:"path,omitempty"`
+}
+
+// FullMethod is the method name requests are passed to the interceptor under, e.g. for
+// method_limits or rate limits.
+const FullMethod = "/graphql.GraphQL/Execute"
+
+// Handler serves the schema over o: POST executes a Request, GET returns the schema in SDL. Each
+// request is run through interceptor, with its HTTP headers as the incoming gRPC metadata, so the
+// server's authentication, rate limits and RBAC apply to it; nil runs requests directly.
+//
+//     schema, err := graphql.NewSchema(GetAllModels()...)
+//     http.Handle("/graphql", graphql.Handler(ormLayer, schema, interceptors.Chain(unary...)))
+func Handler(o *orm.ORM, s *Schema, interceptor grpc.UnaryServerInterceptor) http.Handler {
+    execute := func(ctx context.Context, req interface{}) (interface{}, error) {
+        return s.Execute(ctx, o, req.(Request)), nil
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+            _, _ = w.Write([]byte(s.SDL()))
+            return
+        case http.MethodPost:
+        default:
+            w.Header().Set("Allow", "GET, POST")
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        var req Request
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+            writeResponse(w, http.StatusBadRequest, Response{Errors: []Error{{Message: "invalid request body: " + err.Error()}}})
+            return
+        }
+        if interceptor == nil {
+            resp := s.Execute(r.Context(), o, req)
+            writeResponse(w, responseStatus(resp), resp)
+            return
+        }
+        result, err := interceptor(incomingContext(r), req, &grpc.UnaryServerInfo{FullMethod: FullMethod}, execute)
+        if err != nil {
+            writeResponse(w, httpStatus(status.Code(err)), Response{Errors: []Error{{Message: status.Convert(err).Message()}}})
+            return
+        }
+        resp := result.(Response)
+        writeResponse(w, responseStatus(resp), resp)
+    })
+}
+
+// incomingContext returns the context of r carrying its headers as incoming gRPC metadata and its
+// client's address as the peer, as the gRPC server would.
+func incomingContext(r *http.Request) context.Context {
+    md := make(metadata.MD, len(r.Header))
+    for name, values := range r.Header {
+        md.Append(strings.ToLower(name), values...)
+    }
+    ctx := metadata.NewIncomingContext(r.Context(), md)
+    if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+        ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+    }
+    return ctx
+}
+
+// responseStatus is 400 for requests that didn't execute and 200 otherwise, even when fields
+// failed.
+func responseStatus(resp Response) int {
+    if resp.Data == nil {
+        return http.StatusBadRequest
+    }
+    return http.StatusOK
+}
+
+// httpStatus maps the code of a request the interceptor rejected to an HTTP status.
+func httpStatus(code codes.Code) int {
+    switch code {
+    case codes.Unauthenticated:
+        return http.StatusUnauthorized
+    case codes.PermissionDenied:
+        return http.StatusForbidden
+    case codes.ResourceExhausted:
+        return http.StatusTooManyRequests
+    case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+        return http.StatusBadRequest
+    case codes.DeadlineExceeded:
+        return http.StatusGatewayTimeout
+    case codes.Unavailable:
+        return http.StatusServiceUnavailable
+    }
+    return http.StatusInternalServerError
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp Response) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    _ = json.NewEncoder(w).Encode(resp)
+}
+
+// Execute runs req against o with ctx. Root fields run one after the other, so the mutations of a
+// request apply in order. Documents that don't parse or validate return a Response without Data.
+func (s *Schema) Execute(ctx context.Context, o *orm.ORM, req Request) Response {
+    op, err := s.parse(req.Query, req.OperationName, req.Variables)
+    if err != nil {
+        var list gqlerror.List
+        if !errors.As(err, &list) {
+            return Response{Errors: []Error{{Message: err.Error()}}}
+        }
+        resp := Response{}
+        for _, e := range list {
+            resp.Errors = append(resp.Errors, Error{Message: e.Message})
+        }
+        return resp
+    }
+    roots := s.queries
+    if op.kind == "mutation" {
+        roots = s.mutations
+    }
+    o = o.WithContext(ctx)
+    resp := Response{Data: make(map[string]interface{}, len(op.selections))}
+    for _, sel := range op.selections {
+        if sel.name == "__typename" {
+            resp.Data[sel.key()] = map[string]string{"query": "Query", "mutation": "Mutation"}[op.kind]
+            continue
+        }
+        if sel.name == "__schema" || sel.name == "__type" {
+            value, err := s.introspect(sel)
+            if err != nil {
+                resp.Data[sel.key()] = nil
+                resp.Errors = append(resp.Errors, Error{Message: err.Error(), Path: []interface{}{sel.key()}})
+                continue
+            }
+            resp.Data[sel.key()] = value
+            continue
+        }
+        root, ok := roots[sel.name]
+        if !ok {
+            resp.Data[sel.key()] = nil
+            resp.Errors = append(resp.Errors, Error{Message: fmt.Sprintf("unknown %s field %q", op.kind, sel.name), Path: []interface{}{sel.key()}})
+            continue
+        }
+        value, err := resolve(o, root, sel)
+        if err != nil {
+            resp.Data[sel.key()] = nil
+            resp.Errors = append(resp.Errors, Error{Message: err.Error(), Path: []interface{}{sel.key()}})
+            continue
+        }
+        resp.Data[sel.key()] = value
+    }
+    return resp
+}
+
+// resolve runs one root field and projects its result onto the field's selections.
+func resolve(o *orm.ORM, root rootField, sel *selection) (interface{}, error) {
+    t := root.typ
+    if root.kind != "delete" && len(sel.selections) == 0 {
+        return nil, fmt.Errorf("%s must select fields of %s", sel.name, t.name)
+    }
+    model := reflect.New(t.model).Interface()
+    switch root.kind {
+    case "get":
+        key, err := t.parseKey(sel.args["id"])
+        if err != nil {
+            return nil, err
+        }
+        if err := o.ReadByKey(key, model); err != nil {
+            if errors.Is(err, utils.ErrNotFound) {
+                return nil, nil
+            }
+            return nil, err
+        }
+        return t.project(model, sel.selections)
+    case "list":
+        return listPage(o, t, sel)
+    case "create":
+        if err := t.decodeInput(sel.args["input"], model); err != nil {
+            return nil, err
+        }
+        if _, err := o.Create(model); err != nil {
+            return nil, err
+        }
+        return t.project(model, sel.selections)
+    case "update":
+        key, err := t.parseKey(sel.args["id"])
+        if err != nil {
+            return nil, err
+        }
+        if err := o.ReadByKey(key, model); err != nil {
+            return nil, err
+        }
+        if err := t.decodeInput(sel.args["input"], model); err != nil {
+            return nil, err
+        }
+        if _, err := o.Update(model); err != nil {
+            return nil, err
+        }
+        return t.project(model, sel.selections)
+    case "delete":
+        key, err := t.parseKey(sel.args["id"])
+        if err != nil {
+            return nil, err
+        }
+        if _, err := o.DeleteByKey(key, model); err != nil {
+            if errors.Is(err, utils.ErrNotFound) {
+                return false, nil
+            }
+            return nil, err
+        }
+        return true, nil
+    }
+    return nil, fmt.Errorf("unsupported field %s", sel.name)
+}
+
+// listPage runs a list query as a SearchSQLPage call.
+func listPage(o *orm.ORM, t *objectType, sel *selection) (interface{}, error) {
+    qb := utils.NewQueryBuilder()
+    if where, ok := sel.args["where"].(map[string]interface{}); ok {
+        if err := t.applyFilter(qb, where); err != nil {
+            return nil, err
+        }
+    } else if sel.args["where"] != nil {
+        return nil, fmt.Errorf("where must be an object")
+    }
+    if orderBy, ok := sel.args["orderBy"].([]interface{}); ok {
+        for _, field := range orderBy {
+            name, _ := field.(string)
+            desc := len(name) > 0 && name[0] == '-'
+            if desc {
+                name = name[1:]
+            }
+            if f := t.byName[name]; f != nil {
+                name = f.column
+            }
+            if desc {
+                name = "-" + name
+            }
+            qb.SortFields = append(qb.SortFields, name)
+        }
+    }
+    page := orm.PageRequest{}
+    switch first := sel.args["first"].(type) {
+    case int64:
+        page.Size = int(first)
+    case float64: // From JSON variables.
+        page.Size = int(first)
+    }
+    page.Token, _ = sel.args["after"].(string)
+    if count, ok := sel.args["count"].(string); ok {
+        page.Count = orm.CountStrategy(count)
+    }
+    rows := reflect.New(reflect.SliceOf(t.model))
+    pagination, err := o.SearchSQLPage(qb, page, rows.Interface())
+    if err != nil {
+        return nil, err
+    }
+
+    result := make(map[string]interface{}, len(sel.selections))
+    for _, field := range sel.selections {
+        switch field.name {
+        case "items":
+            items := make([]interface{}, rows.Elem().Len())
+            for i := range items {
+                if items[i], err = t.project(rows.Elem().Index(i).Addr().Interface(), field.selections); err != nil {
+                    return nil, err
+                }
+            }
+            result[field.key()] = items
+        case "nextPageToken":
+            if pagination.NextToken != "" {
+                result[field.key()] = pagination.NextToken
+            } else {
+                result[field.key()] = nil
+            }
+        case "total":
+            if pagination.Total != nil {
+                result[field.key()] = *pagination.Total
+            } else {
+                result[field.key()] = nil
+            }
+        case "totalRelation":
+            result[field.key()] = pagination.TotalRelation
+        case "__typename":
+            result[field.key()] = t.name + "Page"
+        default:
+            return nil, fmt.Errorf("unknown field %q on %sPage", field.name, t.name)
+        }
+    }
+    return result, nil
+}
+
+// applyFilter maps a where argument onto qb. Each field takes a Filter with one of eq, in, like
+// and between.
+func (t *objectType) applyFilter(qb *utils.QueryBuilder, where map[string]interface{}) error {
+    for name, condition := range where {
+        f := t.byName[name]
+        if f == nil {
+            return fmt.Errorf("cannot filter %s by unknown field %q", t.name, name)
+        }
+        ops, ok := condition.(map[string]interface{})
+        if !ok {
+            return fmt.Errorf("filter on %s must be an object", name)
+        }
+        if len(ops) != 1 {
+            return fmt.Errorf("filter on %s must have exactly one of eq, in, like or between", name)
+        }
+        for op, value := range ops {
+            switch op {
+            case "eq":
+                qb.Where(f.column, value)
+            case "in":
+                values, ok := value.([]interface{})
+                if !ok {
+                    return fmt.Errorf("%s.in must be a list", name)
+                }
+                qb.WhereIn(f.column, values)
+            case "like":
+                pattern, ok := value.(string)
+                if !ok {
+                    return fmt.Errorf("%s.like must be a string", name)
+                }
+                qb.WhereLike(f.column, pattern)
+            case "between":
+                bounds, ok := value.([]interface{})
+                if !ok || len(bounds) != 2 {
+                    return fmt.Errorf("%s.between must be a list of two values", name)
+                }
+                qb.WhereBetween(f.column, bounds[0], bounds[1])
+            default:
+                return fmt.Errorf("unknown filter operator %q on %s", op, name)
+            }
+        }
+    }
+    return nil
+}
+
+// decodeInput sets the fields of model named in input. The primary key and the fields the ORM
+// manages can't be set.
+func (t *objectType) decodeInput(input interface{}, model interface{}) error {
+    fields, ok := input.(map[string]interface{})
+    if !ok {
+        return fmt.Errorf("input must be an object")
+    }
+    for name := range fields {
+        f := t.byName[name]
+        if f == nil {
+            return fmt.Errorf("unknown field %q in %sInput", name, t.name)
+        }
+        if f.primary || f.managed {
+            return fmt.Errorf("%s.%s can't be set", t.name, name)
+        }
+    }
+    data, err := json.Marshal(fields)
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(data, model); err != nil {
+        return fmt.Errorf("%w: %v", utils.ErrInvalidValue, err)
+    }
+    return nil
+}
+
+// project returns the selected fields of a record, read from its JSON encoding.
+func (t *objectType) project(model interface{}, selections []*selection) (map[string]interface{}, error) {
+    data, err := json.Marshal(model)
+    if err != nil {
+        return nil, err
+    }
+    var record map[string]interface{}
+    if err := json.Unmarshal(data, &record); err != nil {
+        return nil, err
+    }
+    result := make(map[string]interface{}, len(selections))
+    for _, sel := range selections {
+        if sel.name == "__typename" {
+            result[sel.key()] = t.name
+            continue
+        }
+        if t.byName[sel.name] == nil {
+            return nil, fmt.Errorf("unknown field %q on %s", sel.name, t.name)
+        }
+        result[sel.key()] = record[sel.name]
+    }
+    return result, nil
+}
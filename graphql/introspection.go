@@ -0,0 +1,308 @@
+package graphql
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/vektah/gqlparser/v2/ast"
+)
+
+// introspect resolves the __schema and __type root fields from the parsed schema, so GraphiQL and
+// client code generators can discover the API.
+func (s *Schema) introspect(sel *selection) (interface{}, error) {
+    switch sel.name {
+    case "__schema":
+        return s.resolveMeta(s.ast, sel.selections)
+    case "__type":
+        name, _ := sel.args["name"].(string)
+        if s.ast.Types[name] == nil {
+            return nil, nil
+        }
+        return s.resolveMeta(ast.NamedType(name, nil), sel.selections)
+    }
+    return nil, fmt.Errorf("unsupported field %s", sel.name)
+}
+
+// resolveMeta projects a node of the schema, or a list of them, onto the selections of its
+// introspection type. Nodes are the *ast.Schema (__Schema), *ast.Type (__Type),
+// *ast.FieldDefinition (__Field and __InputValue), *ast.ArgumentDefinition (__InputValue),
+// *ast.EnumValueDefinition (__EnumValue) and *ast.DirectiveDefinition (__Directive).
+func (s *Schema) resolveMeta(node interface{}, selections []*selection) (interface{}, error) {
+    if list, ok := node.([]interface{}); ok {
+        items := make([]interface{}, len(list))
+        for i, item := range list {
+            value, err := s.resolveMeta(item, selections)
+            if err != nil {
+                return nil, err
+            }
+            items[i] = value
+        }
+        return items, nil
+    }
+    if node == nil {
+        return nil, nil
+    }
+    result := make(map[string]interface{}, len(selections))
+    for _, sel := range selections {
+        if sel.name == "__typename" {
+            result[sel.key()] = sel.parent
+            continue
+        }
+        value, err := s.metaField(node, sel)
+        if err != nil {
+            return nil, err
+        }
+        if len(sel.selections) > 0 {
+            if value, err = s.resolveMeta(value, sel.selections); err != nil {
+                return nil, err
+            }
+        }
+        result[sel.key()] = value
+    }
+    return result, nil
+}
+
+// metaField returns the value of one field of an introspection node: a scalar, or nodes to
+// project further.
+func (s *Schema) metaField(node interface{}, sel *selection) (interface{}, error) {
+    includeDeprecated, _ := sel.args["includeDeprecated"].(bool)
+    switch node := node.(type) {
+    case *ast.Schema:
+        switch sel.name {
+        case "description":
+            return optional(node.Description), nil
+        case "types":
+            names := make([]string, 0, len(node.Types))
+            for name := range node.Types {
+                names = append(names, name)
+            }
+            sort.Strings(names)
+            types := make([]interface{}, len(names))
+            for i, name := range names {
+                types[i] = ast.NamedType(name, nil)
+            }
+            return types, nil
+        case "queryType":
+            return namedType(node.Query), nil
+        case "mutationType":
+            return namedType(node.Mutation), nil
+        case "subscriptionType":
+            return namedType(node.Subscription), nil
+        case "directives":
+            names := make([]string, 0, len(node.Directives))
+            for name := range node.Directives {
+                names = append(names, name)
+            }
+            sort.Strings(names)
+            directives := make([]interface{}, len(names))
+            for i, name := range names {
+                directives[i] = node.Directives[name]
+            }
+            return directives, nil
+        }
+    case *ast.Type:
+        return s.typeField(node, sel.name, includeDeprecated)
+    case *ast.FieldDefinition:
+        deprecated, reason := deprecation(node.Directives)
+        switch sel.name {
+        case "name":
+            return node.Name, nil
+        case "description":
+            return optional(node.Description), nil
+        case "args":
+            args := make([]interface{}, 0, len(node.Arguments))
+            for _, arg := range node.Arguments {
+                args = append(args, arg)
+            }
+            return args, nil
+        case "type":
+            return node.Type, nil
+        case "defaultValue":
+            return defaultValue(node.DefaultValue), nil
+        case "isDeprecated":
+            return deprecated, nil
+        case "deprecationReason":
+            return reason, nil
+        }
+    case *ast.ArgumentDefinition:
+        deprecated, reason := deprecation(node.Directives)
+        switch sel.name {
+        case "name":
+            return node.Name, nil
+        case "description":
+            return optional(node.Description), nil
+        case "type":
+            return node.Type, nil
+        case "defaultValue":
+            return defaultValue(node.DefaultValue), nil
+        case "isDeprecated":
+            return deprecated, nil
+        case "deprecationReason":
+            return reason, nil
+        }
+    case *ast.EnumValueDefinition:
+        deprecated, reason := deprecation(node.Directives)
+        switch sel.name {
+        case "name":
+            return node.Name, nil
+        case "description":
+            return optional(node.Description), nil
+        case "isDeprecated":
+            return deprecated, nil
+        case "deprecationReason":
+            return reason, nil
+        }
+    case *ast.DirectiveDefinition:
+        switch sel.name {
+        case "name":
+            return node.Name, nil
+        case "description":
+            return optional(node.Description), nil
+        case "locations":
+            locations := make([]interface{}, len(node.Locations))
+            for i, location := range node.Locations {
+                locations[i] = string(location)
+            }
+            return locations, nil
+        case "args":
+            args := make([]interface{}, 0, len(node.Arguments))
+            for _, arg := range node.Arguments {
+                args = append(args, arg)
+            }
+            return args, nil
+        case "isRepeatable":
+            return node.IsRepeatable, nil
+        }
+    }
+    return nil, fmt.Errorf("unknown introspection field %q on %s", sel.name, sel.parent)
+}
+
+// typeField returns a field of __Type. Wrapping types, NON_NULL and LIST, have only a kind and
+// the type they wrap; named types are described by their definition.
+func (s *Schema) typeField(t *ast.Type, name string, includeDeprecated bool) (interface{}, error) {
+    switch {
+    case t.NonNull:
+        unwrapped := *t
+        unwrapped.NonNull = false
+        return wrapperField("NON_NULL", &unwrapped, name), nil
+    case t.Elem != nil:
+        return wrapperField("LIST", t.Elem, name), nil
+    }
+    def := s.ast.Types[t.NamedType]
+    if def == nil {
+        return nil, fmt.Errorf("unknown type %q", t.NamedType)
+    }
+    switch name {
+    case "kind":
+        return string(def.Kind), nil
+    case "name":
+        return def.Name, nil
+    case "description":
+        return optional(def.Description), nil
+    case "specifiedByURL", "ofType":
+        return nil, nil
+    case "isOneOf":
+        return false, nil
+    case "fields":
+        if def.Kind != ast.Object && def.Kind != ast.Interface {
+            return nil, nil
+        }
+        fields := make([]interface{}, 0, len(def.Fields))
+        for _, field := range def.Fields {
+            if deprecated, _ := deprecation(field.Directives); strings.HasPrefix(field.Name, "__") || deprecated && !includeDeprecated {
+                continue
+            }
+            fields = append(fields, field)
+        }
+        return fields, nil
+    case "inputFields":
+        if def.Kind != ast.InputObject {
+            return nil, nil
+        }
+        fields := make([]interface{}, 0, len(def.Fields))
+        for _, field := range def.Fields {
+            fields = append(fields, field)
+        }
+        return fields, nil
+    case "interfaces":
+        if def.Kind != ast.Object && def.Kind != ast.Interface {
+            return nil, nil
+        }
+        interfaces := make([]interface{}, 0, len(def.Interfaces))
+        for _, name := range def.Interfaces {
+            interfaces = append(interfaces, ast.NamedType(name, nil))
+        }
+        return interfaces, nil
+    case "possibleTypes":
+        if def.Kind != ast.Interface && def.Kind != ast.Union {
+            return nil, nil
+        }
+        var types []interface{}
+        for _, possible := range s.ast.GetPossibleTypes(def) {
+            types = append(types, ast.NamedType(possible.Name, nil))
+        }
+        return types, nil
+    case "enumValues":
+        if def.Kind != ast.Enum {
+            return nil, nil
+        }
+        values := make([]interface{}, 0, len(def.EnumValues))
+        for _, value := range def.EnumValues {
+            if deprecated, _ := deprecation(value.Directives); deprecated && !includeDeprecated {
+                continue
+            }
+            values = append(values, value)
+        }
+        return values, nil
+    }
+    return nil, fmt.Errorf("unknown introspection field %q on __Type", name)
+}
+
+// wrapperField returns a field of a NON_NULL or LIST __Type wrapping of.
+func wrapperField(kind string, of *ast.Type, name string) interface{} {
+    switch name {
+    case "kind":
+        return kind
+    case "ofType":
+        return of
+    }
+    return nil
+}
+
+// namedType returns the type reference of def, or nil.
+func namedType(def *ast.Definition) interface{} {
+    if def == nil {
+        return nil
+    }
+    return ast.NamedType(def.Name, nil)
+}
+
+// deprecation reads the @deprecated directive of a definition.
+func deprecation(directives ast.DirectiveList) (bool, interface{}) {
+    d := directives.ForName("deprecated")
+    if d == nil {
+        return false, nil
+    }
+    reason := "No longer supported"
+    if arg := d.Arguments.ForName("reason"); arg != nil && arg.Value != nil {
+        reason = arg.Value.Raw
+    }
+    return true, reason
+}
+
+// defaultValue returns the default of an input value in GraphQL syntax, or nil.
+func defaultValue(value *ast.Value) interface{} {
+    if value == nil {
+        return nil
+    }
+    return value.String()
+}
+
+// optional returns s, or nil when it is empty, for nullable strings.
+func optional(s string) interface{} {
+    if s == "" {
+        return nil
+    }
+    return s
+}
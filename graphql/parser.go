@@ -0,0 +1,128 @@
+package graphql
+
+import (
+    "fmt"
+
+    "github.com/vektah/gqlparser/v2"
+    "github.com/vektah/gqlparser/v2/ast"
+    "github.com/vektah/gqlparser/v2/validator"
+)
+
+// operation is an executable operation of a request document.
+type operation struct {
+    kind       string // "query" or "mutation".
+    name       string
+    selections []*selection
+}
+
+// selection is a field requested by an operation, with its arguments resolved against the
+// request's variables and the fields of its fragments merged in.
+type selection struct {
+    alias      string
+    name       string
+    parent     string // Name of the type the field is selected on, e.g. "Query" or "__Type".
+    args       map[string]interface{}
+    selections []*selection
+}
+
+// key returns the name of the selection in the response.
+func (s *selection) key() string {
+    if s.alias != "" {
+        return s.alias
+    }
+    return s.name
+}
+
+// parse parses a request document, validates it against the schema, coerces variables to the
+// types they are declared with and returns the operation named name, or its only operation when
+// name is empty. Validation errors are returned as a gqlerror.List.
+func (s *Schema) parse(query, name string, variables map[string]interface{}) (*operation, error) {
+    doc, errs := gqlparser.LoadQueryWithRules(s.ast, query, nil)
+    if len(errs) > 0 {
+        return nil, errs
+    }
+    op := doc.Operations.ForName(name)
+    if op == nil {
+        if name == "" {
+            return nil, fmt.Errorf("document has %d operations; operationName is required", len(doc.Operations))
+        }
+        return nil, fmt.Errorf("unknown operation %q", name)
+    }
+    if op.Operation == ast.Subscription {
+        return nil, fmt.Errorf("subscriptions are not supported")
+    }
+    vars, err := validator.VariableValues(s.ast, op, variables)
+    if err != nil {
+        return nil, err
+    }
+    return &operation{kind: string(op.Operation), name: op.Name, selections: collect(op.SelectionSet, vars)}, nil
+}
+
+// collect flattens a selection set into the fields it selects: fragment spreads and inline
+// fragments contribute their fields, fields left out by @skip or @include are dropped and fields
+// under the same response key merge their sub-selections, as the spec's CollectFields does.
+func collect(set ast.SelectionSet, vars map[string]interface{}) []*selection {
+    var keys []string
+    fields := make(map[string][]*ast.Field)
+    var walk func(ast.SelectionSet)
+    walk = func(set ast.SelectionSet) {
+        for _, item := range set {
+            switch item := item.(type) {
+            case *ast.Field:
+                if !included(item.Directives, vars) {
+                    continue
+                }
+                key := item.Alias
+                if key == "" {
+                    key = item.Name
+                }
+                if _, ok := fields[key]; !ok {
+                    keys = append(keys, key)
+                }
+                fields[key] = append(fields[key], item)
+            case *ast.FragmentSpread:
+                if included(item.Directives, vars) && item.Definition != nil {
+                    walk(item.Definition.SelectionSet)
+                }
+            case *ast.InlineFragment:
+                if included(item.Directives, vars) {
+                    walk(item.SelectionSet)
+                }
+            }
+        }
+    }
+    walk(set)
+
+    selections := make([]*selection, 0, len(keys))
+    for _, key := range keys {
+        first := fields[key][0]
+        var children ast.SelectionSet
+        for _, field := range fields[key] {
+            children = append(children, field.SelectionSet...)
+        }
+        sel := &selection{alias: key, name: first.Name, args: first.ArgumentMap(vars)}
+        if first.ObjectDefinition != nil {
+            sel.parent = first.ObjectDefinition.Name
+        }
+        if len(children) > 0 {
+            sel.selections = collect(children, vars)
+        }
+        selections = append(selections, sel)
+    }
+    return selections
+}
+
+// included applies the @skip and @include directives of a selection.
+func included(directives ast.DirectiveList, vars map[string]interface{}) bool {
+    if d := directives.ForName("skip"); d != nil {
+        if skip, _ := d.ArgumentMap(vars)["if"].(bool); skip {
+            return false
+        }
+    }
+    if d := directives.ForName("include"); d != nil {
+        if include, _ := d.ArgumentMap(vars)["if"].(bool); !include {
+            return false
+        }
+    }
+    return true
+}
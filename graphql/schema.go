@@ -0,0 +1,229 @@
+// Package graphql serves a GraphQL API over the models registered with the persistence layer, for
+// clients that want to pick the fields they read. Every model gets a query by key, a paged list
+// query with filtering mapped onto utils.QueryBuilder, and create, update and delete mutations
+// mapped onto ORM writes. Requests pass through the interceptor given to Handler, the server's
+// gRPC chain, so they are authenticated, rate limited and attributed as gRPC calls are, and the
+// ORM's policies, RBAC, ownership rules and audit apply to their operations.
+//
+// Documents are parsed and validated against the schema with gqlparser, so queries and mutations
+// may use variables, aliases, fragments, @skip and @include, and introspection is answered;
+// subscriptions are not supported. The schema is generated at runtime from the models rather than
+// by gqlgen, whose resolvers are generated at build time from a fixed schema. GET on the handler
+// returns the schema in SDL for client code generators.
+package graphql
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/vektah/gqlparser/v2"
+    "github.com/vektah/gqlparser/v2/ast"
+    "gorm.io/gorm"
+    "gorm.io/gorm/schema"
+)
+
+// schemaCache caches the parsed gorm schemas of the registered models.
+var schemaCache sync.Map
+
+// objectType is the GraphQL type of a model.
+type objectType struct {
+    name   string
+    model  reflect.Type // Struct type of the model.
+    fields []*objectField
+    byName map[string]*objectField
+    key    *objectField // Primary key field.
+}
+
+// objectField is a field of a model as exposed to GraphQL under its JSON name.
+type objectField struct {
+    name    string // JSON name.
+    column  string // Column name; empty for fields that aren't columns.
+    typ     string // GraphQL scalar type.
+    goType  reflect.Type
+    primary bool
+    managed bool // Set by the ORM, such as timestamps, so left out of inputs.
+}
+
+// Schema is the GraphQL schema of a set of models.
+type Schema struct {
+    types     []*objectType
+    queries   map[string]rootField
+    mutations map[string]rootField
+    ast       *ast.Schema // Parsed SDL, which requests are validated against.
+}
+
+// rootField is a field of the Query or Mutation type.
+type rootField struct {
+    kind string // "get", "list", "create", "update" or "delete".
+    typ  *objectType
+}
+
+// NewSchema builds the schema of models, e.g. the result of GetAllModels. A model Post gets the
+// queries post(id) and posts(where, orderBy, first, after, count) and the mutations
+// createPost(input), updatePost(id, input) and deletePost(id).
+func NewSchema(models ...interface{}) (*Schema, error) {
+    s := &Schema{queries: make(map[string]rootField), mutations: make(map[string]rootField)}
+    for _, model := range models {
+        t, err := newObjectType(model)
+        if err != nil {
+            return nil, err
+        }
+        s.types = append(s.types, t)
+        single := lowerFirst(t.name)
+        s.queries[single] = rootField{kind: "get", typ: t}
+        s.queries[plural(single)] = rootField{kind: "list", typ: t}
+        s.mutations["create"+t.name] = rootField{kind: "create", typ: t}
+        s.mutations["update"+t.name] = rootField{kind: "update", typ: t}
+        s.mutations["delete"+t.name] = rootField{kind: "delete", typ: t}
+    }
+    sort.Slice(s.types, func(i, j int) bool { return s.types[i].name < s.types[j].name })
+    parsed, err := gqlparser.LoadSchema(&ast.Source{Name: "schema.graphql", Input: s.SDL()})
+    if err != nil {
+        return nil, fmt.Errorf("graphql: %w", err)
+    }
+    s.ast = parsed
+    return s, nil
+}
+
+// newObjectType reads the columns of model with gorm's naming strategy.
+func newObjectType(model interface{}) (*objectType, error) {
+    parsed, err := schema.Parse(model, &schemaCache, schema.NamingStrategy{})
+    if err != nil {
+        return nil, fmt.Errorf("graphql: parsing %T: %w", model, err)
+    }
+    t := &objectType{name: parsed.Name, model: parsed.ModelType, byName: make(map[string]*objectField)}
+    for _, f := range parsed.Fields {
+        name := jsonName(f.StructField)
+        if name == "" || f.DBName == "" {
+            continue
+        }
+        field := &objectField{name: name, column: f.DBName, typ: scalarType(f.FieldType), goType: f.FieldType, primary: f.PrimaryKey}
+        field.managed = f.AutoCreateTime > 0 || f.AutoUpdateTime > 0 || f.FieldType == reflect.TypeOf(gorm.DeletedAt{})
+        if f.PrimaryKey {
+            field.typ = "ID"
+            if t.key == nil {
+                t.key = field
+            }
+        }
+        t.fields = append(t.fields, field)
+        t.byName[name] = field
+    }
+    if t.key == nil {
+        return nil, fmt.Errorf("graphql: %s has no primary key", t.name)
+    }
+    return t, nil
+}
+
+// jsonName returns the name the field is encoded under, or "" when it isn't encoded.
+func jsonName(f reflect.StructField) string {
+    tag := f.Tag.Get("json")
+    if tag == "-" {
+        return ""
+    }
+    if name := strings.Split(tag, ",")[0]; name != "" {
+        return name
+    }
+    return f.Name
+}
+
+// scalarType maps a Go field type to a GraphQL scalar; types without one are exposed as JSON.
+func scalarType(t reflect.Type) string {
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t == reflect.TypeOf(time.Time{}) {
+        return "Time"
+    }
+    switch t.Kind() {
+    case reflect.String:
+        return "String"
+    case reflect.Bool:
+        return "Boolean"
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+        return "Int"
+    case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+        // Beyond the 32 bits of GraphQL's Int.
+        return "Int64"
+    case reflect.Float32, reflect.Float64:
+        return "Float"
+    }
+    return "JSON"
+}
+
+// parseKey converts an ID argument to the type of the model's primary key.
+func (t *objectType) parseKey(value interface{}) (interface{}, error) {
+    s := fmt.Sprint(value)
+    switch t.key.goType.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        n, err := strconv.ParseInt(s, 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid %s id %q", t.name, s)
+        }
+        return reflect.ValueOf(n).Convert(t.key.goType).Interface(), nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        n, err := strconv.ParseUint(s, 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid %s id %q", t.name, s)
+        }
+        return reflect.ValueOf(n).Convert(t.key.goType).Interface(), nil
+    }
+    return s, nil
+}
+
+// SDL returns the schema in the GraphQL schema definition language.
+func (s *Schema) SDL() string {
+    var b strings.Builder
+    b.WriteString("scalar Time\nscalar Int64\nscalar JSON\n\n")
+    b.WriteString("input Filter {\n  eq: JSON\n  in: [JSON!]\n  like: String\n  between: [JSON!]\n}\n\n")
+    var queries, mutations []string
+    for _, t := range s.types {
+        fmt.Fprintf(&b, "type %s {\n", t.name)
+        for _, f := range t.fields {
+            fmt.Fprintf(&b, "  %s: %s\n", f.name, f.typ)
+        }
+        fmt.Fprintf(&b, "}\n\ninput %sInput {\n", t.name)
+        for _, f := range t.fields {
+            if !f.primary && !f.managed {
+                fmt.Fprintf(&b, "  %s: %s\n", f.name, f.typ)
+            }
+        }
+        fmt.Fprintf(&b, "}\n\ninput %sFilter {\n", t.name)
+        for _, f := range t.fields {
+            fmt.Fprintf(&b, "  %s: Filter\n", f.name)
+        }
+        fmt.Fprintf(&b, "}\n\ntype %sPage {\n  items: [%s!]!\n  nextPageToken: String\n  total: Int64\n  totalRelation: String\n}\n\n", t.name, t.name)
+        single := lowerFirst(t.name)
+        queries = append(queries,
+            fmt.Sprintf("  %s(id: ID!): %s", single, t.name),
+            fmt.Sprintf("  %s(where: %sFilter, orderBy: [String!], first: Int, after: String, count: String): %sPage!", plural(single), t.name, t.name))
+        mutations = append(mutations,
+            fmt.Sprintf("  create%s(input: %sInput!): %s!", t.name, t.name, t.name),
+            fmt.Sprintf("  update%s(id: ID!, input: %sInput!): %s!", t.name, t.name, t.name),
+            fmt.Sprintf("  delete%s(id: ID!): Boolean!", t.name))
+    }
+    fmt.Fprintf(&b, "type Query {\n%s\n}\n\ntype Mutation {\n%s\n}\n", strings.Join(queries, "\n"), strings.Join(mutations, "\n"))
+    return b.String()
+}
+
+func lowerFirst(name string) string {
+    if name == "" {
+        return name
+    }
+    return strings.ToLower(name[:1]) + name[1:]
+}
+
+// plural returns the English plural of a model name for its list query, e.g. "categories".
+func plural(name string) string {
+    switch {
+    case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsAny(name[len(name)-2:len(name)-1], "aeiou"):
+        return name[:len(name)-1] + "ies"
+    case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+        return name + "es"
+    }
+    return name + "s"
+}
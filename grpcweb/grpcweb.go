@@ -0,0 +1,205 @@
+// Package grpcweb lets browser clients call the gRPC services through the gRPC-Web protocol,
+// translated in process so development setups don't need an Envoy proxy in front of the server.
+// Requests in the binary (application/grpc-web+proto) and text (application/grpc-web-text) wire
+// formats are handed to the grpc.Server as regular gRPC calls, so its interceptors apply, and the
+// call's trailers are sent back in the response body as gRPC-Web requires. Only unary and
+// server-streaming calls are possible over gRPC-Web.
+package grpcweb
+
+import (
+    "bufio"
+    "encoding/base64"
+    "encoding/binary"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+
+    "google.golang.org/grpc"
+)
+
+const (
+    contentTypeWeb     = "application/grpc-web"
+    contentTypeWebText = "application/grpc-web-text"
+    // trailerFrame flags the length-prefixed frame carrying the trailers.
+    trailerFrame = 0x80
+)
+
+// DefaultAllowedHeaders are the request headers browsers may send on cross-origin calls, beyond
+// those in Options.AllowedHeaders.
+var DefaultAllowedHeaders = []string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout", "authorization"}
+
+// Options controls the cross-origin requests the handler accepts.
+type Options struct {
+    // AllowedOrigins lists the origins, e.g. "http://localhost:3000", allowed to call the services;
+    // "*" allows any, for development only. Requests from other origins get no CORS headers, so
+    // browsers reject them.
+    AllowedOrigins []string
+    // AllowedHeaders adds request headers, e.g. custom metadata, to DefaultAllowedHeaders.
+    AllowedHeaders []string
+    // MaxAge is how many seconds browsers may cache a preflight response; 0 leaves it to them.
+    MaxAge int
+}
+
+// Handler serves gRPC-Web requests with server, answering CORS preflights as opts allows. Other
+// requests get 415 Unsupported Media Type.
+//
+//     http.ListenAndServe(":8081", grpcweb.Handler(grpcServer, grpcweb.Options{AllowedOrigins: []string{"http://localhost:3000"}}))
+func Handler(server *grpc.Server, opts Options) http.Handler {
+    allowed := make(map[string]bool, len(opts.AllowedOrigins))
+    for _, origin := range opts.AllowedOrigins {
+        allowed[origin] = true
+    }
+    headers := strings.Join(append(append([]string{}, DefaultAllowedHeaders...), opts.AllowedHeaders...), ", ")
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if origin := r.Header.Get("Origin"); origin != "" && (allowed["*"] || allowed[origin]) {
+            // Without Access-Control-Allow-Credentials: callers authenticate with a bearer token
+            // in the authorization header, never with cookies the browser attaches on its own.
+            w.Header().Set("Access-Control-Allow-Origin", origin)
+            w.Header().Add("Vary", "Origin")
+            w.Header().Set("Access-Control-Expose-Headers", "grpc-status, grpc-message, grpc-status-details-bin")
+            if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+                w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+                w.Header().Set("Access-Control-Allow-Headers", headers)
+                if opts.MaxAge > 0 {
+                    w.Header().Set("Access-Control-Max-Age", strconv.Itoa(opts.MaxAge))
+                }
+                w.WriteHeader(http.StatusNoContent)
+                return
+            }
+        }
+        if !IsGRPCWebRequest(r) {
+            http.Error(w, "expected a gRPC-Web request", http.StatusUnsupportedMediaType)
+            return
+        }
+        serve(server, w, r)
+    })
+}
+
+// IsGRPCWebRequest reports whether r is a gRPC-Web call.
+func IsGRPCWebRequest(r *http.Request) bool {
+    return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeWeb)
+}
+
+// serve runs one gRPC-Web call as a gRPC call.
+func serve(server *grpc.Server, w http.ResponseWriter, r *http.Request) {
+    contentType := r.Header.Get("Content-Type")
+    text := strings.HasPrefix(contentType, contentTypeWebText)
+    subtype := "proto"
+    if i := strings.IndexByte(contentType, '+'); i >= 0 {
+        subtype = contentType[i+1:]
+    }
+
+    req := r.Clone(r.Context())
+    req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+    req.Header.Set("Content-Type", "application/grpc+"+subtype)
+    req.Header.Del("Content-Length")
+    req.ContentLength = -1
+    if text {
+        req.Body = io.NopCloser(base64.NewDecoder(base64.StdEncoding, r.Body))
+    }
+
+    rw := &responseWriter{w: w, header: make(http.Header), contentType: contentTypeWeb + "+" + subtype}
+    rw.body = w
+    if text {
+        rw.contentType = contentTypeWebText + "+" + subtype
+        rw.encoder = base64.NewEncoder(base64.StdEncoding, w)
+        rw.body = rw.encoder
+    }
+    server.ServeHTTP(rw, req)
+    rw.finish()
+}
+
+// responseWriter turns the HTTP/2 response of a gRPC call into a gRPC-Web one: headers are passed
+// through, and the trailers grpc-go sets after the body are written as a trailer frame instead.
+type responseWriter struct {
+    w           http.ResponseWriter
+    header      http.Header // What the gRPC server sets, headers and trailers alike.
+    contentType string
+    body        io.Writer
+    encoder     io.WriteCloser // Base64 encoder of the text format.
+    wroteHeader bool
+    sent        map[string]bool // Headers already sent, so later values are trailers.
+}
+
+func (rw *responseWriter) Header() http.Header {
+    return rw.header
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+    if rw.wroteHeader {
+        return
+    }
+    rw.wroteHeader = true
+    rw.sent = make(map[string]bool, len(rw.header))
+    out := rw.w.Header()
+    for k, vv := range rw.header {
+        if k == "Trailer" || strings.HasPrefix(k, http.TrailerPrefix) {
+            continue
+        }
+        rw.sent[k] = true
+        for _, v := range vv {
+            out.Add(k, v)
+        }
+    }
+    out.Set("Content-Type", rw.contentType)
+    out.Del("Content-Length")
+    rw.w.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+    rw.WriteHeader(http.StatusOK)
+    return rw.body.Write(b)
+}
+
+// Flush is required by grpc-go; it forwards to the underlying writer when it can flush.
+func (rw *responseWriter) Flush() {
+    rw.WriteHeader(http.StatusOK)
+    if f, ok := rw.w.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// finish writes the trailers grpc-go declared or prefixed with http.TrailerPrefix as the final
+// frame of the body.
+func (rw *responseWriter) finish() {
+    rw.WriteHeader(http.StatusOK)
+    trailers := make(http.Header)
+    for _, k := range rw.header.Values("Trailer") {
+        k = http.CanonicalHeaderKey(k)
+        if vv, ok := rw.header[k]; ok && !rw.sent[k] {
+            trailers[k] = vv
+        }
+    }
+    for k, vv := range rw.header {
+        if strings.HasPrefix(k, http.TrailerPrefix) {
+            trailers[strings.TrimPrefix(k, http.TrailerPrefix)] = vv
+        }
+    }
+    keys := make([]string, 0, len(trailers))
+    for k := range trailers {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var block strings.Builder
+    for _, k := range keys {
+        for _, v := range trailers[k] {
+            block.WriteString(strings.ToLower(k) + ": " + v + "\r\n")
+        }
+    }
+
+    frame := bufio.NewWriter(rw.body)
+    var prefix [5]byte
+    prefix[0] = trailerFrame
+    binary.BigEndian.PutUint32(prefix[1:], uint32(block.Len()))
+    _, _ = frame.Write(prefix[:])
+    _, _ = frame.WriteString(block.String())
+    _ = frame.Flush()
+    if rw.encoder != nil {
+        _ = rw.encoder.Close()
+    }
+    if f, ok := rw.w.(http.Flusher); ok {
+        f.Flush()
+    }
+}
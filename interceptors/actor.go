@@ -0,0 +1,21 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/auth"
+    "persistence-layer/orm"
+
+    "google.golang.org/grpc"
+)
+
+// Actor returns a unary interceptor that copies the subject of the caller's verified token into
+// the context with orm.WithActor, so audit events of ORM calls made with that context name the
+// actor. Chain it after Authenticate; unauthenticated calls have no actor.
+func Actor() grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        if claims, ok := auth.FromContext(ctx); ok {
+            ctx = orm.WithActor(ctx, claims.Subject)
+        }
+        return handler(ctx, req)
+    }
+}
@@ -0,0 +1,57 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/auth"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+    "strings"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+// AuthorizationHeader is the metadata key carrying the caller's "Bearer <token>" credential.
+const AuthorizationHeader = "authorization"
+
+// HealthService prefixes the methods of the standard gRPC health service, which load balancers and
+// service discovery, such as the Consul check of discovery, call without credentials.
+const HealthService = "/grpc.health.v1.Health/"
+
+// infrastructure reports whether method is called by infrastructure rather than clients, and so
+// skips Authenticate and Tenant.
+func infrastructure(method string) bool {
+    return strings.HasPrefix(method, HealthService)
+}
+
+// Authenticate returns a unary interceptor verifying the bearer token of every call with verifier
+// and setting its claims with auth.WithClaims and its subject and roles as the context's
+// orm.Principal, for Tenant, Actor and RBAC to act on. Calls without a valid token fail with
+// Unauthenticated, except those of the health service, which act as an anonymous principal. A nil
+// verifier admits every call as an anonymous principal. Chain it first, after panic recovery.
+func Authenticate(verifier *auth.Verifier) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        if verifier == nil || infrastructure(info.FullMethod) {
+            return handler(orm.WithPrincipal(ctx, orm.Principal{}), req)
+        }
+        var header string
+        if md, ok := metadata.FromIncomingContext(ctx); ok {
+            if values := md.Get(AuthorizationHeader); len(values) > 0 {
+                header = values[0]
+            }
+        }
+        token, ok := auth.BearerToken(header)
+        if !ok {
+            return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+        }
+        claims, err := verifier.Verify(token)
+        if err != nil {
+            utils.LogWarn("Rejected call with an invalid token", map[string]interface{}{"method": info.FullMethod, "error": err.Error()})
+            return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+        }
+        ctx = auth.WithClaims(ctx, claims)
+        return handler(orm.WithPrincipal(ctx, orm.Principal{Subject: claims.Subject, Roles: claims.Roles}), req)
+    }
+}
@@ -0,0 +1,74 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/auth"
+    "persistence-layer/orm"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+var testSecret = []byte("test-secret")
+
+// signedToken returns a token of subject for tenant, signed with testSecret.
+func signedToken(t *testing.T, subject, tenant string) string {
+    t.Helper()
+    claims := jwt.MapClaims{"sub": subject, "exp": time.Now().Add(time.Hour).Unix()}
+    if tenant != "" {
+        claims["tenant"] = tenant
+    }
+    token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+    if err != nil {
+        t.Fatalf("signing a token: %v", err)
+    }
+    return token
+}
+
+// withBearer returns a context carrying token as the incoming authorization metadata.
+func withBearer(token string) context.Context {
+    if token == "" {
+        return context.Background()
+    }
+    return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationHeader, "Bearer "+token))
+}
+
+func TestAuthenticate(t *testing.T) {
+    verifier, err := auth.NewVerifier(auth.Options{Secret: testSecret})
+    if err != nil {
+        t.Fatalf("NewVerifier: %v", err)
+    }
+    tests := []struct {
+        name        string
+        method      string
+        token       string
+        wantCode    codes.Code
+        wantSubject string
+    }{
+        {name: "valid token", method: "/proto.UserService/GetUser", token: signedToken(t, "alice", ""), wantCode: codes.OK, wantSubject: "alice"},
+        {name: "missing token", method: "/proto.UserService/GetUser", wantCode: codes.Unauthenticated},
+        {name: "invalid token", method: "/proto.UserService/GetUser", token: "not-a-token", wantCode: codes.Unauthenticated},
+        {name: "health check without token", method: HealthService + "Check", wantCode: codes.OK},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var principal orm.Principal
+            handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+                principal, _ = orm.PrincipalFromContext(ctx)
+                return "ok", nil
+            }
+            _, err := Authenticate(verifier)(withBearer(tt.token), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+            if code := status.Code(err); code != tt.wantCode {
+                t.Fatalf("code = %v (%v), want %v", code, err, tt.wantCode)
+            }
+            if principal.Subject != tt.wantSubject {
+                t.Fatalf("principal subject = %q, want %q", principal.Subject, tt.wantSubject)
+            }
+        })
+    }
+}
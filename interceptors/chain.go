@@ -0,0 +1,23 @@
+package interceptors
+
+import (
+    "context"
+
+    "google.golang.org/grpc"
+)
+
+// Chain returns a unary interceptor running interceptors in order, the first outermost, as
+// grpc.ChainUnaryInterceptor does on a server, so endpoints served outside gRPC, such as GraphQL,
+// pass through the same checks.
+func Chain(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        next := handler
+        for i := len(interceptors) - 1; i >= 0; i-- {
+            interceptor, inner := interceptors[i], next
+            next = func(ctx context.Context, req interface{}) (interface{}, error) {
+                return interceptor(ctx, req, info, inner)
+            }
+        }
+        return next(ctx, req)
+    }
+}
@@ -0,0 +1,18 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/dataloader"
+    "persistence-layer/orm"
+
+    "google.golang.org/grpc"
+)
+
+// Dataloaders returns a unary interceptor that gives every RPC its own set of loaders, so lookups
+// made through dataloader.For while handling the RPC are batched and deduplicated together.
+// Install it after Tenant so the loaders read with the tenant's context.
+func Dataloaders(o *orm.ORM, cfg dataloader.Config) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        return handler(dataloader.WithLoaders(ctx, o, cfg), req)
+    }
+}
@@ -0,0 +1,152 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/utils"
+    "sync"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+)
+
+// MethodLimits bounds the calls of a method. Zero fields are unlimited.
+type MethodLimits struct {
+    MaxRequestBytes  int
+    MaxResponseBytes int
+    // LatencySLO is the latency calls are expected to stay within; slower calls still succeed but
+    // count against the method's latency budget.
+    LatencySLO time.Duration
+}
+
+// MethodLimitStats counts the calls of a method and how many broke its limits.
+type MethodLimitStats struct {
+    Calls            int64 `json:"calls"`
+    RequestTooLarge  int64 `json:"request_too_large"`
+    ResponseTooLarge int64 `json:"response_too_large"`
+    SlowCalls        int64 `json:"slow_calls"` // Slower than LatencySLO.
+}
+
+// MethodLimiter enforces request and response size limits per method and tracks calls against their
+// latency SLO.
+type MethodLimiter struct {
+    defaults MethodLimits
+    methods  map[string]MethodLimits
+
+    mu    sync.Mutex
+    stats map[string]*MethodLimitStats
+}
+
+// NewMethodLimiter limits calls to defaults, overridden per full method name, e.g.
+// "/proto.ProductService/CreateProduct", by the non-zero fields of methods.
+func NewMethodLimiter(defaults MethodLimits, methods map[string]MethodLimits) *MethodLimiter {
+    return &MethodLimiter{defaults: defaults, methods: methods, stats: make(map[string]*MethodLimitStats)}
+}
+
+// limits returns the limits of method.
+func (l *MethodLimiter) limits(method string) MethodLimits {
+    limits := l.defaults
+    if override, ok := l.methods[method]; ok {
+        if override.MaxRequestBytes > 0 {
+            limits.MaxRequestBytes = override.MaxRequestBytes
+        }
+        if override.MaxResponseBytes > 0 {
+            limits.MaxResponseBytes = override.MaxResponseBytes
+        }
+        if override.LatencySLO > 0 {
+            limits.LatencySLO = override.LatencySLO
+        }
+    }
+    return limits
+}
+
+// MaxRequestBytes returns the largest request any method accepts, or 0 when some method is
+// unlimited. Pass it to grpc.MaxRecvMsgSize so oversized requests are refused before being decoded.
+func (l *MethodLimiter) MaxRequestBytes() int {
+    largest := l.defaults.MaxRequestBytes
+    for method := range l.methods {
+        limit := l.limits(method).MaxRequestBytes
+        if limit == 0 || largest == 0 {
+            return 0
+        }
+        if limit > largest {
+            largest = limit
+        }
+    }
+    return largest
+}
+
+// Interceptor returns the unary interceptor failing calls whose request or response exceeds the
+// method's size limits with ResourceExhausted, and logging calls slower than its latency SLO.
+func (l *MethodLimiter) Interceptor() grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        limits := l.limits(info.FullMethod)
+        l.count(info.FullMethod, func(s *MethodLimitStats) { s.Calls++ })
+        if size := messageSize(req); limits.MaxRequestBytes > 0 && size > limits.MaxRequestBytes {
+            l.count(info.FullMethod, func(s *MethodLimitStats) { s.RequestTooLarge++ })
+            utils.LogWarn("Request exceeded its size limit", map[string]interface{}{
+                "method": info.FullMethod,
+                "bytes":  size,
+                "limit":  limits.MaxRequestBytes,
+            })
+            return nil, status.Errorf(codes.ResourceExhausted, "request of %d bytes exceeds the limit of %d bytes", size, limits.MaxRequestBytes)
+        }
+
+        start := time.Now()
+        resp, err := handler(ctx, req)
+        elapsed := time.Since(start)
+        if limits.LatencySLO > 0 && elapsed > limits.LatencySLO {
+            l.count(info.FullMethod, func(s *MethodLimitStats) { s.SlowCalls++ })
+            utils.LogWarn("Call exceeded its latency SLO", map[string]interface{}{
+                "method":     info.FullMethod,
+                "elapsed_ms": elapsed.Milliseconds(),
+                "slo_ms":     limits.LatencySLO.Milliseconds(),
+            })
+        }
+        if err != nil {
+            return resp, err
+        }
+        if size := messageSize(resp); limits.MaxResponseBytes > 0 && size > limits.MaxResponseBytes {
+            l.count(info.FullMethod, func(s *MethodLimitStats) { s.ResponseTooLarge++ })
+            utils.LogWarn("Response exceeded its size limit", map[string]interface{}{
+                "method": info.FullMethod,
+                "bytes":  size,
+                "limit":  limits.MaxResponseBytes,
+            })
+            return nil, status.Errorf(codes.ResourceExhausted, "response of %d bytes exceeds the limit of %d bytes", size, limits.MaxResponseBytes)
+        }
+        return resp, nil
+    }
+}
+
+func (l *MethodLimiter) count(method string, update func(*MethodLimitStats)) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    s, ok := l.stats[method]
+    if !ok {
+        s = &MethodLimitStats{}
+        l.stats[method] = s
+    }
+    update(s)
+}
+
+// Stats returns the counts of every method called so far.
+func (l *MethodLimiter) Stats() map[string]MethodLimitStats {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    stats := make(map[string]MethodLimitStats, len(l.stats))
+    for method, s := range l.stats {
+        stats[method] = *s
+    }
+    return stats
+}
+
+// messageSize returns the encoded size of a protobuf message, or 0 for other values.
+func messageSize(msg interface{}) int {
+    if m, ok := msg.(proto.Message); ok {
+        return proto.Size(m)
+    }
+    return 0
+}
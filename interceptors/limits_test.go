@@ -0,0 +1,49 @@
+package interceptors
+
+import (
+    "context"
+    "strings"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMethodLimiter(t *testing.T) {
+    const method = "/proto.UserService/ListUsers"
+    small, large := wrapperspb.String("ok"), wrapperspb.String(strings.Repeat("x", 100))
+    tests := []struct {
+        name      string
+        defaults  MethodLimits
+        overrides map[string]MethodLimits
+        req, resp interface{}
+        delay     time.Duration
+        wantCode  codes.Code
+        wantStats MethodLimitStats
+    }{
+        {name: "within limits", defaults: MethodLimits{MaxRequestBytes: 50, MaxResponseBytes: 50}, req: small, resp: small, wantCode: codes.OK, wantStats: MethodLimitStats{Calls: 1}},
+        {name: "request too large", defaults: MethodLimits{MaxRequestBytes: 50}, req: large, resp: small, wantCode: codes.ResourceExhausted, wantStats: MethodLimitStats{Calls: 1, RequestTooLarge: 1}},
+        {name: "response too large", defaults: MethodLimits{MaxResponseBytes: 50}, req: small, resp: large, wantCode: codes.ResourceExhausted, wantStats: MethodLimitStats{Calls: 1, ResponseTooLarge: 1}},
+        {name: "override raises the limit", defaults: MethodLimits{MaxResponseBytes: 50}, overrides: map[string]MethodLimits{method: {MaxResponseBytes: 500}}, req: small, resp: large, wantCode: codes.OK, wantStats: MethodLimitStats{Calls: 1}},
+        {name: "slow call succeeds", defaults: MethodLimits{LatencySLO: time.Millisecond}, req: small, resp: small, delay: 5 * time.Millisecond, wantCode: codes.OK, wantStats: MethodLimitStats{Calls: 1, SlowCalls: 1}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            limiter := NewMethodLimiter(tt.defaults, tt.overrides)
+            handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+                time.Sleep(tt.delay)
+                return tt.resp, nil
+            }
+            _, err := limiter.Interceptor()(context.Background(), tt.req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+            if code := status.Code(err); code != tt.wantCode {
+                t.Fatalf("code = %v (%v), want %v", code, err, tt.wantCode)
+            }
+            if stats := limiter.Stats()[method]; stats != tt.wantStats {
+                t.Fatalf("stats = %+v, want %+v", stats, tt.wantStats)
+            }
+        })
+    }
+}
@@ -0,0 +1,32 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+
+    "google.golang.org/grpc"
+)
+
+// QueryBudget returns a unary interceptor counting the ORM operations of each call. Calls making
+// more than budget operations are logged with the call sites that made the most, a sign of an N+1
+// pattern, and passed to exceeded, e.g. to count them in metrics. The ORM needs orm.CountCalls
+// installed, and services must derive their ORMs from the request context.
+func QueryBudget(budget int, exceeded func(method string, calls int)) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        ctx, calls := orm.WithRequestCalls(ctx)
+        resp, err := handler(ctx, req)
+        if total := calls.Total(); total > budget {
+            utils.LogWarn("Request exceeded its query budget", map[string]interface{}{
+                "method": info.FullMethod,
+                "calls":  total,
+                "budget": budget,
+                "sites":  calls.Top(5),
+            })
+            if exceeded != nil {
+                exceeded(info.FullMethod, total)
+            }
+        }
+        return resp, err
+    }
+}
@@ -0,0 +1,67 @@
+package interceptors
+
+import (
+    "context"
+    "net"
+    "persistence-layer/adapters"
+    "persistence-layer/auth"
+    "persistence-layer/utils"
+    "strconv"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+)
+
+// RateLimit returns a unary interceptor admitting at most limit calls per sliding window for each
+// caller and method, failing the rest with ResourceExhausted and a retry-after header in seconds.
+// Callers are the subject of their verified token, so chain it after Authenticate, or their IP
+// address when the server runs without one. A limit of 0 or less disables limiting. When the
+// limiter is unavailable calls are let through.
+func RateLimit(limiter adapters.RateLimiter, limit int64, window time.Duration) grpc.UnaryServerInterceptor {
+    return DynamicRateLimit(limiter, func() (int64, time.Duration) { return limit, window })
+}
+
+// DynamicRateLimit is RateLimit with the limit and window read from limits on every call, so they
+// can change at runtime, e.g. on a configuration reload.
+func DynamicRateLimit(limiter adapters.RateLimiter, limits func() (int64, time.Duration)) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        limit, window := limits()
+        if limit <= 0 {
+            return handler(ctx, req)
+        }
+        key := "ratelimit:" + caller(ctx) + ":" + info.FullMethod
+        decision, err := limiter.Allow(key, limit, window)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RateLimit", "method": info.FullMethod})
+            return handler(ctx, req)
+        }
+        if !decision.Allowed {
+            seconds := int64((decision.RetryAfter + time.Second - 1) / time.Second)
+            _ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.FormatInt(seconds, 10)))
+            return nil, status.Errorf(codes.ResourceExhausted, "rate limit of %d calls per %s exceeded", limit, window)
+        }
+        _ = grpc.SetHeader(ctx, metadata.Pairs("x-ratelimit-remaining", strconv.FormatInt(decision.Remaining, 10)))
+        return handler(ctx, req)
+    }
+}
+
+// caller identifies the caller of a call for rate limiting: "sub:" and the subject of its verified
+// token, or "ip:" and the address it connects from. Clients can set neither, unlike a header.
+func caller(ctx context.Context) string {
+    if claims, ok := auth.FromContext(ctx); ok {
+        return "sub:" + claims.Subject
+    }
+    if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+        addr := p.Addr.String()
+        // The port changes with every connection.
+        if host, _, err := net.SplitHostPort(addr); err == nil {
+            addr = host
+        }
+        return "ip:" + addr
+    }
+    return "unknown"
+}
@@ -0,0 +1,50 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/adapters/memory"
+    "persistence-layer/auth"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestRateLimit(t *testing.T) {
+    tests := []struct {
+        name  string
+        limit int64
+        want  []codes.Code // Codes of successive calls of the same caller.
+    }{
+        {name: "within the limit then exhausted", limit: 2, want: []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted}},
+        {name: "zero limit disables limiting", limit: 0, want: []codes.Code{codes.OK, codes.OK, codes.OK}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            interceptor := RateLimit(memory.NewRedisAdapter(), tt.limit, time.Minute)
+            ctx := auth.WithClaims(context.Background(), auth.Claims{Subject: "alice"})
+            info := &grpc.UnaryServerInfo{FullMethod: "/proto.UserService/GetUser"}
+            handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+            for i, want := range tt.want {
+                _, err := interceptor(ctx, nil, info, handler)
+                if code := status.Code(err); code != want {
+                    t.Fatalf("call %d: code = %v (%v), want %v", i, code, err, want)
+                }
+            }
+        })
+    }
+}
+
+func TestRateLimitSeparatesCallers(t *testing.T) {
+    interceptor := RateLimit(memory.NewRedisAdapter(), 1, time.Minute)
+    info := &grpc.UnaryServerInfo{FullMethod: "/proto.UserService/GetUser"}
+    handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+    for _, subject := range []string{"alice", "bob"} {
+        ctx := auth.WithClaims(context.Background(), auth.Claims{Subject: subject})
+        if _, err := interceptor(ctx, nil, info, handler); err != nil {
+            t.Fatalf("first call of %s: %v", subject, err)
+        }
+    }
+}
@@ -0,0 +1,29 @@
+package interceptors
+
+import (
+    "context"
+    "errors"
+    "persistence-layer/auth"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// RBAC returns a unary interceptor resolving the caller's roles from the subject and roles of its
+// verified token and setting them as the context's orm.Principal, so that rbac's middleware checks
+// the ORM operations of the call. Chain it after Authenticate; calls it did not authenticate act
+// with no roles. Calls denied an operation fail with PermissionDenied.
+func RBAC(rbac *orm.RBAC) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        claims, _ := auth.FromContext(ctx)
+        principal := orm.Principal{Subject: claims.Subject, Roles: rbac.Roles(claims.Subject, claims.Roles)}
+        resp, err := handler(orm.WithPrincipal(ctx, principal), req)
+        if errors.Is(err, utils.ErrPermissionDenied) {
+            return nil, status.Error(codes.PermissionDenied, err.Error())
+        }
+        return resp, err
+    }
+}
@@ -0,0 +1,75 @@
+package interceptors
+
+import (
+    "context"
+    "crypto/sha1"
+    "encoding/hex"
+    "fmt"
+    "persistence-layer/utils"
+    "runtime"
+    "runtime/debug"
+    "strings"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// Recovery returns a unary interceptor turning a panic in a handler into an Internal error instead
+// of crashing the server. The panic is logged with its stack trace and a fingerprint that is the
+// same for every panic of the same kind at the same place, so repeated occurrences can be grouped,
+// reported through utils.LogError's ErrorReporter when one is set, and passed to recovered, e.g.
+// to count panics in metrics. Chain it first so it covers the other interceptors.
+func Recovery(recovered func(method, fingerprint string)) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+        defer func() {
+            value := recover()
+            if value == nil {
+                return
+            }
+            fingerprint := panicFingerprint(value)
+            utils.LogError(fmt.Errorf("panic: %v", value), map[string]interface{}{
+                "operation":   "Recovery",
+                "method":      info.FullMethod,
+                "fingerprint": fingerprint,
+                "stack":       string(debug.Stack()),
+            })
+            if recovered != nil {
+                recovered(info.FullMethod, fingerprint)
+            }
+            resp, err = nil, status.Errorf(codes.Internal, "internal error (fingerprint %s)", fingerprint)
+        }()
+        return handler(ctx, req)
+    }
+}
+
+// panicFingerprint hashes the type of the panic value and the functions on the stack of the
+// panicking goroutine. Line numbers and argument values are left out, so the fingerprint survives
+// unrelated edits to the files involved and doesn't vary with the request.
+func panicFingerprint(value interface{}) string {
+    pcs := make([]uintptr, 32)
+    // Skip runtime.Callers, this function, the deferred recovery function and runtime.gopanic.
+    n := runtime.Callers(4, pcs)
+    frames := runtime.CallersFrames(pcs[:n])
+    h := sha1.New()
+    fmt.Fprintf(h, "%T", value)
+    if err, ok := value.(error); ok {
+        // Runtime errors have the same type; their messages tell nil dereferences from bad indexes.
+        if _, runtimeErr := err.(runtime.Error); runtimeErr {
+            fmt.Fprintf(h, "|%s", strings.SplitN(err.Error(), " [", 2)[0])
+        }
+    }
+    for more := true; more; {
+        var frame runtime.Frame
+        frame, more = frames.Next()
+        if strings.HasPrefix(frame.Function, "google.golang.org/grpc") {
+            // The stack below the first gRPC frame is the server's, the same for every call.
+            break
+        }
+        // Runtime frames are the panic machinery and the runtime's own checks.
+        if !strings.HasPrefix(frame.Function, "runtime.") {
+            fmt.Fprintf(h, "|%s", frame.Function)
+        }
+    }
+    return hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -0,0 +1,35 @@
+package interceptors
+
+import (
+    "context"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestRecovery(t *testing.T) {
+    var fingerprints []string
+    interceptor := Recovery(func(method, fingerprint string) { fingerprints = append(fingerprints, fingerprint) })
+    info := &grpc.UnaryServerInfo{FullMethod: "/proto.UserService/GetUser"}
+    panicking := func(ctx context.Context, req interface{}) (interface{}, error) {
+        var m map[string]int
+        m["boom"]++
+        return nil, nil
+    }
+    for i := 0; i < 2; i++ {
+        resp, err := interceptor(context.Background(), i, info, panicking)
+        if resp != nil || status.Code(err) != codes.Internal {
+            t.Fatalf("Recovery = %v, %v; want an Internal error", resp, err)
+        }
+    }
+    if len(fingerprints) != 2 || fingerprints[0] == "" || fingerprints[0] != fingerprints[1] {
+        t.Fatalf("fingerprints = %q, want the same one for both panics", fingerprints)
+    }
+
+    resp, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+    if resp != "ok" || err != nil || len(fingerprints) != 2 {
+        t.Fatalf("Recovery of a call without panic = %v, %v", resp, err)
+    }
+}
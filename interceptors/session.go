@@ -0,0 +1,37 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/orm"
+    "persistence-layer/utils"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/metadata"
+)
+
+// SessionHeader is the metadata key carrying the client's read-your-writes session token, both in
+// requests and in response headers.
+const SessionHeader = "x-session-token"
+
+// Session returns a unary interceptor that restores the caller's orm.Session from the request
+// metadata into the context, so reads through ORMs derived with that context see the caller's
+// earlier writes even when replicas lag, and returns the updated token in the response header.
+func Session() grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        var token string
+        if md, ok := metadata.FromIncomingContext(ctx); ok {
+            if values := md.Get(SessionHeader); len(values) > 0 {
+                token = values[0]
+            }
+        }
+        session, err := orm.ParseSession(token)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Session", "method": info.FullMethod})
+        }
+        resp, err := handler(orm.WithSession(ctx, session), req)
+        if token := session.Token(); token != "" {
+            _ = grpc.SetHeader(ctx, metadata.Pairs(SessionHeader, token))
+        }
+        return resp, err
+    }
+}
@@ -0,0 +1,27 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/auth"
+    "persistence-layer/orm"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// Tenant returns a unary interceptor that copies the tenant of the caller's verified token into
+// the context with orm.WithTenant, so ORM calls made with that context are attributed to the
+// tenant. With required set, as when quotas are enforced, calls whose token names no tenant fail
+// with PermissionDenied rather than escape accounting; health checks are exempt. Chain it after
+// Authenticate.
+func Tenant(required bool) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        if claims, ok := auth.FromContext(ctx); ok && claims.Tenant != "" {
+            ctx = orm.WithTenant(ctx, claims.Tenant)
+        } else if required && !infrastructure(info.FullMethod) {
+            return nil, status.Error(codes.PermissionDenied, "the caller's token names no tenant")
+        }
+        return handler(ctx, req)
+    }
+}
@@ -0,0 +1,45 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/auth"
+    "persistence-layer/orm"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestTenant(t *testing.T) {
+    tests := []struct {
+        name       string
+        required   bool
+        method     string
+        tenant     string // Tenant of the caller's token; empty for none.
+        wantCode   codes.Code
+        wantTenant string
+    }{
+        {name: "tenant set", required: true, method: "/proto.UserService/GetUser", tenant: "acme", wantCode: codes.OK, wantTenant: "acme"},
+        {name: "no tenant, optional", method: "/proto.UserService/GetUser", wantCode: codes.OK},
+        {name: "no tenant, required", required: true, method: "/proto.UserService/GetUser", wantCode: codes.PermissionDenied},
+        {name: "health check, required", required: true, method: HealthService + "Check", wantCode: codes.OK},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ctx := auth.WithClaims(context.Background(), auth.Claims{Subject: "alice", Tenant: tt.tenant})
+            var tenant string
+            handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+                tenant = orm.TenantFromContext(ctx)
+                return "ok", nil
+            }
+            _, err := Tenant(tt.required)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+            if code := status.Code(err); code != tt.wantCode {
+                t.Fatalf("code = %v (%v), want %v", code, err, tt.wantCode)
+            }
+            if tenant != tt.wantTenant {
+                t.Fatalf("tenant = %q, want %q", tenant, tt.wantTenant)
+            }
+        })
+    }
+}
@@ -0,0 +1,63 @@
+package interceptors
+
+import (
+    "context"
+    "persistence-layer/utils"
+    "strings"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// allValidator is implemented by messages generated by protoc-gen-validate, returning every
+// violated constraint at once.
+type allValidator interface {
+    ValidateAll() error
+}
+
+// validator is implemented by messages generated by older protoc-gen-validate versions, returning
+// the first violated constraint.
+type validator interface {
+    Validate() error
+}
+
+// multiError is the error ValidateAll returns when several constraints are violated.
+type multiError interface {
+    AllErrors() []error
+}
+
+// Validate returns a unary interceptor checking requests against the constraints declared in the
+// protos with protoc-gen-validate, e.g. string lengths, email formats and required IDs, and failing
+// invalid ones with InvalidArgument before the handler reaches the ORM. Requests of messages
+// without generated validation pass through.
+func Validate() grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        var err error
+        switch msg := req.(type) {
+        case allValidator:
+            err = msg.ValidateAll()
+        case validator:
+            err = msg.Validate()
+        }
+        if err != nil {
+            utils.LogWarn("Rejected an invalid request", map[string]interface{}{"method": info.FullMethod, "error": err.Error()})
+            return nil, status.Error(codes.InvalidArgument, "invalid request: "+violations(err))
+        }
+        return handler(ctx, req)
+    }
+}
+
+// violations lists the violated constraints of err, one per field.
+func violations(err error) string {
+    multi, ok := err.(multiError)
+    if !ok {
+        return err.Error()
+    }
+    all := multi.AllErrors()
+    messages := make([]string, len(all))
+    for i, e := range all {
+        messages[i] = e.Error()
+    }
+    return strings.Join(messages, "; ")
+}
@@ -0,0 +1,64 @@
+package interceptors
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// validated is a request with generated validation returning err.
+type validated struct{ err error }
+
+func (v validated) Validate() error { return v.err }
+
+// multiValidated is a request with generated ValidateAll returning every violation.
+type multiValidated struct{ errs []error }
+
+func (v multiValidated) ValidateAll() error {
+    if len(v.errs) == 0 {
+        return nil
+    }
+    return violationList(v.errs)
+}
+
+type violationList []error
+
+func (l violationList) Error() string     { return "several violations" }
+func (l violationList) AllErrors() []error { return l }
+
+func TestValidate(t *testing.T) {
+    tests := []struct {
+        name        string
+        req         interface{}
+        wantCode    codes.Code
+        wantMessage string
+    }{
+        {name: "valid", req: validated{}, wantCode: codes.OK},
+        {name: "without validation", req: "plain", wantCode: codes.OK},
+        {name: "invalid", req: validated{err: errors.New("Email: value must be a valid email address")}, wantCode: codes.InvalidArgument, wantMessage: "invalid request: Email: value must be a valid email address"},
+        {name: "every violation", req: multiValidated{errs: []error{errors.New("Name: too short"), errors.New("Age: too low")}}, wantCode: codes.InvalidArgument, wantMessage: "invalid request: Name: too short; Age: too low"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            called := false
+            handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+                called = true
+                return "ok", nil
+            }
+            _, err := Validate()(context.Background(), tt.req, &grpc.UnaryServerInfo{FullMethod: "/proto.UserService/CreateUser"}, handler)
+            if code := status.Code(err); code != tt.wantCode {
+                t.Fatalf("code = %v (%v), want %v", code, err, tt.wantCode)
+            }
+            if called != (tt.wantCode == codes.OK) {
+                t.Fatalf("handler called = %v, want it called only for valid requests", called)
+            }
+            if tt.wantMessage != "" && status.Convert(err).Message() != tt.wantMessage {
+                t.Fatalf("message = %q, want %q", status.Convert(err).Message(), tt.wantMessage)
+            }
+        })
+    }
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+    "persistence-layer/utils"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// BaseModel provides a UUIDv7 primary key, timestamps and soft delete. Embed it in new models:
+//
+//     type Invoice struct {
+//         models.BaseModel
+//         Total float64 `json:"total"`
+//     }
+//
+// Records are read and deleted by key with orm.ReadByKey and orm.DeleteByKey. Deleting sets
+// DeletedAt instead of removing the row, and soft-deleted rows are excluded from reads.
+type BaseModel struct {
+    ID        string         `json:"id" gorm:"type:char(36);primaryKey" bson:"_id"`
+    CreatedAt time.Time      `json:"created_at" gorm:"autoCreateTime;<-:create" bson:"created_at"`
+    UpdatedAt time.Time      `json:"updated_at" gorm:"autoUpdateTime" bson:"updated_at"`
+    DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index" bson:"deleted_at,omitempty"`
+}
+
+// AssignKey assigns a UUIDv7 key to a record without one. orm.Create calls it before writing to
+// any backend, so records kept only in Mongo get a key too.
+func (m *BaseModel) AssignKey() {
+    if m.ID == "" {
+        m.ID = utils.NewUUIDv7()
+    }
+}
+
+// BeforeCreate assigns a key to records created through gorm without one, as a fallback for
+// writes bypassing orm.Create.
+func (m *BaseModel) BeforeCreate(tx *gorm.DB) error {
+    m.AssignKey()
+    return nil
+}
+
+// GetKey returns the UUIDv7 key of the record, for orm.ModelKey.
+func (m *BaseModel) GetKey() string {
+    return m.ID
+}
+
+// BaseIntModel is BaseModel with an auto-incrementing integer key, for tables that need compact keys.
+type BaseIntModel struct {
+    ID        uint64         `json:"id" gorm:"primaryKey;autoIncrement" bson:"_id"`
+    CreatedAt time.Time      `json:"created_at" gorm:"autoCreateTime;<-:create" bson:"created_at"`
+    UpdatedAt time.Time      `json:"updated_at" gorm:"autoUpdateTime" bson:"updated_at"`
+    DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index" bson:"deleted_at,omitempty"`
+}
+
+// GetID returns the integer key of the record, for orm.ModelKey.
+func (m *BaseIntModel) GetID() uint64 {
+    return m.ID
+}
@@ -0,0 +1,140 @@
+package orm
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "reflect"
+    "strings"
+    "unicode/utf8"
+
+    "gorm.io/gorm/schema"
+)
+
+// Anonymizer rewrites personal data in the fields of a model tagged with the kind of data they
+// hold, e.g.
+//
+//     Email string `json:"email" anonymize:"email"`
+//     Name  string `json:"name" anonymize:"name"`
+//     Notes string `json:"notes" anonymize:"freetext"`
+//
+// Replacements are derived from the original value with an HMAC keyed by Key, so a value is
+// replaced the same way in every table and every run: columns referring to a tagged column keep
+// matching it when they carry the same tag. Without the key the originals can't be recovered, not
+// even by hashing guesses, so keep it secret and don't reuse it across environments.
+type Anonymizer struct {
+    Key []byte
+}
+
+// NewAnonymizer creates an Anonymizer keyed by key.
+func NewAnonymizer(key []byte) *Anonymizer {
+    return &Anonymizer{Key: key}
+}
+
+// anonymizers are the kinds of data accepted by the anonymize tag.
+var anonymizers = map[string]func(sum []byte, original string) string{
+    "email":    anonymousEmail,
+    "name":     anonymousName,
+    "phone":    anonymousPhone,
+    "freetext": anonymousText,
+}
+
+// Anonymize rewrites the tagged fields of model, a pointer to a struct.
+func (a *Anonymizer) Anonymize(model interface{}) error {
+    s, err := schema.Parse(model, &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        return err
+    }
+    return a.anonymizeRow(s, reflect.ValueOf(model).Elem())
+}
+
+// Value returns the replacement of value as data of the given kind, e.g. "email".
+func (a *Anonymizer) Value(kind, value string) (string, error) {
+    anonymize, ok := anonymizers[kind]
+    if !ok {
+        return "", fmt.Errorf("unknown anonymize kind %q", kind)
+    }
+    if value == "" {
+        return "", nil // Keeps empty values recognisable as such.
+    }
+    if kind == "email" {
+        value = strings.ToLower(strings.TrimSpace(value)) // Addresses compare case-insensitively.
+    }
+    mac := hmac.New(sha256.New, a.Key)
+    mac.Write([]byte(kind))
+    mac.Write([]byte{0})
+    mac.Write([]byte(value))
+    return anonymize(mac.Sum(nil), value), nil
+}
+
+// anonymizeRow rewrites the tagged fields of row, an addressable value of s's model type.
+func (a *Anonymizer) anonymizeRow(s *schema.Schema, row reflect.Value) error {
+    ctx := context.Background()
+    for _, field := range s.Fields {
+        kind := field.Tag.Get("anonymize")
+        if kind == "" {
+            continue
+        }
+        value, zero := field.ValueOf(ctx, row)
+        if zero {
+            continue
+        }
+        rv := reflect.Indirect(reflect.ValueOf(value))
+        if rv.Kind() != reflect.String {
+            return fmt.Errorf("field %s.%s: cannot anonymize %s values", s.Name, field.Name, field.FieldType)
+        }
+        replacement, err := a.Value(kind, rv.String())
+        if err != nil {
+            return fmt.Errorf("field %s.%s: %w", s.Name, field.Name, err)
+        }
+        if err := field.Set(ctx, row, replacement); err != nil {
+            return fmt.Errorf("field %s.%s: %w", s.Name, field.Name, err)
+        }
+    }
+    return nil
+}
+
+func anonymousEmail(sum []byte, _ string) string {
+    return "user-" + hex.EncodeToString(sum[:8]) + "@example.invalid"
+}
+
+var (
+    anonymousFirstNames = []string{"Alex", "Blake", "Casey", "Dana", "Elliot", "Frankie", "Gray", "Harper", "Indy", "Jordan", "Kai", "Logan", "Morgan", "Noel", "Oakley", "Parker", "Quinn", "Riley", "Sam", "Taylor"}
+    anonymousLastNames  = []string{"Abbott", "Baker", "Carter", "Dalton", "Ellis", "Foster", "Garner", "Hayes", "Irving", "Jensen", "Keller", "Lawson", "Mercer", "Nolan", "Osborne", "Porter", "Reed", "Sutton", "Turner", "Walsh"}
+)
+
+func anonymousName(sum []byte, _ string) string {
+    return anonymousFirstNames[int(sum[0])%len(anonymousFirstNames)] + " " + anonymousLastNames[int(sum[1])%len(anonymousLastNames)]
+}
+
+// anonymousPhone returns a number in the 555-0100 to 555-0199 range, which is reserved for fiction.
+func anonymousPhone(sum []byte, _ string) string {
+    return fmt.Sprintf("+1-%03d-555-01%02d", 200+binary.BigEndian.Uint16(sum[:2])%800, sum[2]%100)
+}
+
+var anonymousWords = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris nisi aliquip ex ea commodo consequat")
+
+// anonymousText returns filler words about as long as the original, so columns keep their shape
+// and length limits.
+func anonymousText(sum []byte, original string) string {
+    length := utf8.RuneCountInString(original)
+    var b strings.Builder
+    for i := 0; b.Len() < length; i++ {
+        word := anonymousWords[int(sum[i%len(sum)]+byte(i/len(sum)))%len(anonymousWords)]
+        if b.Len() > 0 {
+            if b.Len()+1+len(word) > length {
+                break
+            }
+            b.WriteByte(' ')
+        }
+        b.WriteString(word)
+    }
+    text := b.String()
+    if len(text) > length {
+        text = text[:length]
+    }
+    return text
+}
@@ -0,0 +1,282 @@
+package orm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "persistence-layer/utils"
+    "reflect"
+    "sync"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/schema"
+)
+
+var errNoGormDB = errors.New("operation requires a gorm-backed SQL store")
+
+// ManyToMany describes a relation stored as rows of a join model, e.g. posts and tags through
+// posttags:
+//
+//     var PostTags = orm.ManyToMany{
+//         Join:         &models.Posttag{},
+//         Target:       &models.Tag{},
+//         OwnerColumn:  "post_id",
+//         TargetColumn: "tag_id",
+//     }
+//
+// For polymorphic relations, where one join table links several owner types, set TypeColumn and
+// TypeValue, e.g. "taggable_type" and "posts", alongside an OwnerColumn such as "taggable_id".
+type ManyToMany struct {
+    Join         interface{} // Join model; a new instance is created for every link.
+    Target       interface{} // Target model, used to invalidate and re-index targets.
+    OwnerColumn  string
+    TargetColumn string
+    TypeColumn   string
+    TypeValue    string
+}
+
+// Append links owner to each target that isn't linked yet.
+func (o *ORM) Append(rel ManyToMany, owner interface{}, targets ...interface{}) error {
+    return o.associate("Append", rel, owner, targets, false)
+}
+
+// Replace links owner to exactly the given targets, removing its other links.
+func (o *ORM) Replace(rel ManyToMany, owner interface{}, targets ...interface{}) error {
+    return o.associate("Replace", rel, owner, targets, true)
+}
+
+// Clear removes every link of owner.
+func (o *ORM) Clear(rel ManyToMany, owner interface{}) error {
+    return o.associate("Clear", rel, owner, nil, true)
+}
+
+// associate maintains the join rows in one transaction, then drops the cached copies of the owner
+// and every target whose links changed, and re-indexes them when Elasticsearch is enabled.
+func (o *ORM) associate(name string, rel ManyToMany, owner interface{}, targets []interface{}, prune bool) error {
+    return o.invoke(name, BackendSQL, rel.Join, "", func() error {
+        ownerKey, err := ModelKey(owner)
+        if err != nil {
+            return err
+        }
+        wanted := make([]interface{}, 0, len(targets))
+        for _, target := range targets {
+            key, err := ModelKey(target)
+            if err != nil {
+                return err
+            }
+            wanted = append(wanted, key)
+        }
+
+        // The join rows live next to the owner's row.
+        db, err := o.route(o.PolicyFor(owner), ownerKey, owner)
+        if err != nil {
+            return err
+        }
+        var changed []interface{}
+        err = db.WithTransaction(o.opContext(), func(txORM *ORM) error {
+            db := txORM.SQL.GetDB()
+            if db == nil {
+                return errNoGormDB
+            }
+            existing, err := rel.linkedKeys(db, ownerKey)
+            if err != nil {
+                return err
+            }
+            linked := keySet(existing)
+            keep := keySet(wanted)
+
+            if prune {
+                var stale []interface{}
+                for _, key := range existing {
+                    if !keep[fmt.Sprint(key)] {
+                        stale = append(stale, key)
+                    }
+                }
+                if len(stale) > 0 {
+                    err := rel.scope(db, ownerKey).Where(rel.TargetColumn+" IN ?", stale).Delete(rel.newJoin()).Error
+                    if err != nil {
+                        return err
+                    }
+                    changed = append(changed, stale...)
+                }
+            }
+            for _, key := range wanted {
+                if linked[fmt.Sprint(key)] {
+                    continue
+                }
+                row, err := rel.joinRow(db, ownerKey, key)
+                if err != nil {
+                    return err
+                }
+                if err := db.Create(row).Error; err != nil {
+                    return err
+                }
+                linked[fmt.Sprint(key)] = true
+                changed = append(changed, key)
+            }
+            return nil
+        })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": name, "owner": ownerKey})
+            if errors.Is(err, errNoGormDB) {
+                return err
+            }
+            return utils.HandleSQLError(err)
+        }
+
+        o.invalidate(owner, ownerKey)
+        o.reindex(owner, ownerKey)
+        for _, key := range changed {
+            o.invalidate(rel.Target, key)
+            if o.Elasticsearch == nil {
+                continue
+            }
+            target := reflect.New(indirectType(rel.Target)).Interface()
+            err := o.readRouted(o.PolicyFor(target), key, target, func(db *ORM) error {
+                if db.SQL == nil {
+                    return backendDisabled(BackendSQL)
+                }
+                return db.SQL.ReadByKey(key, target)
+            })
+            if err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": name + " Reindex", "key": key})
+                continue
+            }
+            o.reindex(target, key)
+        }
+        utils.LogInfo("Associations updated successfully", map[string]interface{}{"operation": name, "owner": ownerKey, "changed": len(changed)})
+        return nil
+    })
+}
+
+// invalidate drops the cached copy of a record. Failures are logged and returned for reporting
+// only: the join rows are already committed and stale copies expire on their own.
+func (o *ORM) invalidate(model interface{}, key interface{}) error {
+    if o.Redis == nil {
+        return nil
+    }
+    if o.Refresher != nil {
+        o.Refresher.invalidated(CacheKey(model, key))
+    }
+    err := o.Redis.Delete(CacheKey(model, key))
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Invalidate Cache", "key": CacheKey(model, key)})
+    }
+    return err
+}
+
+// reindex re-indexes a record in the index of its Policy, or the index named after its table when
+// the policy has none, logging failures like invalidate.
+func (o *ORM) reindex(model interface{}, key interface{}) {
+    if o.Elasticsearch == nil {
+        return
+    }
+    index := o.PolicyFor(model).Index
+    if index == "" {
+        index = schema.NamingStrategy{}.TableName(indirectType(model).Name())
+    }
+    doc, err := NewSearchDocument(model)
+    if err == nil {
+        err = o.Elasticsearch.IndexDocument(index, doc)
+    }
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Reindex", "index": index, "key": key})
+    }
+}
+
+// CacheKey returns the key under which the generated services cache a record, e.g. "post:42".
+func CacheKey(model interface{}, key interface{}) string {
+    name := schema.NamingStrategy{SingularTable: true}.TableName(indirectType(model).Name())
+    return fmt.Sprintf("%s:%v", name, key)
+}
+
+// linkedKeys returns the target keys currently linked to the owner.
+func (rel ManyToMany) linkedKeys(db *gorm.DB, ownerKey interface{}) ([]interface{}, error) {
+    var keys []string
+    if err := rel.scope(db, ownerKey).Pluck(rel.TargetColumn, &keys).Error; err != nil {
+        return nil, err
+    }
+    out := make([]interface{}, len(keys))
+    for i, key := range keys {
+        out[i] = key
+    }
+    return out, nil
+}
+
+// scope restricts a query to the join rows of one owner.
+func (rel ManyToMany) scope(db *gorm.DB, ownerKey interface{}) *gorm.DB {
+    q := db.Model(rel.newJoin()).Where(rel.OwnerColumn+" = ?", ownerKey)
+    if rel.TypeColumn != "" {
+        q = q.Where(rel.TypeColumn+" = ?", rel.TypeValue)
+    }
+    return q
+}
+
+func (rel ManyToMany) newJoin() interface{} {
+    return reflect.New(indirectType(rel.Join)).Interface()
+}
+
+// joinRow builds a join model linking ownerKey and targetKey, setting fields by their column names.
+func (rel ManyToMany) joinRow(db *gorm.DB, ownerKey, targetKey interface{}) (interface{}, error) {
+    row := rel.newJoin()
+    s, err := schema.Parse(row, &sync.Map{}, db.NamingStrategy)
+    if err != nil {
+        return nil, err
+    }
+    values := map[string]interface{}{rel.OwnerColumn: ownerKey, rel.TargetColumn: targetKey}
+    if rel.TypeColumn != "" {
+        values[rel.TypeColumn] = rel.TypeValue
+    }
+    rv := reflect.ValueOf(row)
+    for column, value := range values {
+        field := s.LookUpField(column)
+        if field == nil {
+            return nil, fmt.Errorf("join model %s has no column %q", s.Name, column)
+        }
+        if err := field.Set(context.Background(), rv, value); err != nil {
+            return nil, err
+        }
+    }
+    return row, nil
+}
+
+// keyAssigner is implemented by models generating their own key, such as models.BaseModel.
+type keyAssigner interface {
+    AssignKey()
+}
+
+// ModelKey returns the primary key of a model through its GetKey or GetID method.
+func ModelKey(model interface{}) (interface{}, error) {
+    switch m := model.(type) {
+    case interface{ GetKey() string }:
+        return m.GetKey(), nil
+    case interface{ GetID() uint64 }:
+        return m.GetID(), nil
+    }
+    return nil, fmt.Errorf("model %T has no GetID or GetKey method", model)
+}
+
+func keySet(keys []interface{}) map[string]bool {
+    set := make(map[string]bool, len(keys))
+    for _, key := range keys {
+        set[fmt.Sprint(key)] = true
+    }
+    return set
+}
+
+func indirectType(model interface{}) reflect.Type {
+    t := reflect.TypeOf(model)
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    return t
+}
+
+// recordType is the struct behind a model or a slice of models, e.g. a Find destination.
+func recordType(model interface{}) reflect.Type {
+    t := reflect.TypeOf(model)
+    for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+        t = t.Elem()
+    }
+    return t
+}
@@ -0,0 +1,265 @@
+package orm
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "persistence-layer/utils"
+    "sync/atomic"
+    "time"
+)
+
+// AuditSchemaVersion is the version of the AuditEvent schema. Fields are only ever added within a
+// version, so consumers can rely on the ones they know.
+const AuditSchemaVersion = 1
+
+const (
+    defaultAuditBuffer        = 10000
+    defaultAuditBatchSize     = 100
+    defaultAuditFlushInterval = time.Second
+    maxAuditRetryBackoff      = 30 * time.Second
+)
+
+// DefaultAuditOperations are the ORM operations audited by default: those changing data or
+// access to it.
+var DefaultAuditOperations = []string{
+    "Create", "Update", "Delete", "DeleteByQuery", "UpdateByQuery", "ExecSQL", "RunNamed",
+    "SetTranslation", "RegisterWebhook", "SetPermission", "AssignRole",
+}
+
+type actorKey struct{}
+
+// WithActor returns a context that attributes ORM operations to actor, e.g. a user or service
+// account ID, in audit events.
+func WithActor(ctx context.Context, actor string) context.Context {
+    return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or "" when there is none.
+func ActorFromContext(ctx context.Context) string {
+    if ctx == nil {
+        return ""
+    }
+    actor, _ := ctx.Value(actorKey{}).(string)
+    return actor
+}
+
+// AuditEvent is the record of one audited ORM operation, as shipped to the audit sink.
+type AuditEvent struct {
+    SchemaVersion int       `json:"schema_version"`
+    ID            string    `json:"id"` // Random; lets sinks drop the duplicates of retried batches.
+    Time          time.Time `json:"time"`
+    Tenant        string    `json:"tenant,omitempty"`
+    Actor         string    `json:"actor,omitempty"`
+    Action        string    `json:"action"` // ORM operation, e.g. "Update".
+    Backend       string    `json:"backend"`
+    Entity        string    `json:"entity,omitempty"` // Model name, e.g. "Product".
+    Key           string    `json:"key,omitempty"`
+    Target        string    `json:"target,omitempty"` // Collection, index or cache key.
+    Outcome       string    `json:"outcome"`          // "success", "failure" or "rolled_back".
+    Error         string    `json:"error,omitempty"`
+    DurationMs    float64   `json:"duration_ms"`
+}
+
+// AuditSink ships batches of audit events to an external system such as a SIEM.
+type AuditSink interface {
+    Send(ctx context.Context, events []AuditEvent) error
+}
+
+// AuditStats counts the events handled by an AuditExporter.
+type AuditStats struct {
+    Sent     int64 `json:"sent"`
+    Dropped  int64 `json:"dropped"`  // Lost to a full buffer.
+    Failures int64 `json:"failures"` // Failed sends, each retried.
+    Pending  int   `json:"pending"`
+}
+
+// AuditExporter records the operations in Operations through its Middleware and ships them to a
+// sink from Run, in batches of up to BatchSize sent at least every FlushInterval. A failed batch is
+// retried with backoff until it goes through, holding up later events, which queue in a buffer.
+// When the buffer is full, operations wait for room if Block is set, applying backpressure to the
+// callers, and otherwise go ahead with their event dropped and counted.
+type AuditExporter struct {
+    Operations    map[string]bool
+    BatchSize     int
+    FlushInterval time.Duration
+    Block         bool
+
+    sink     AuditSink
+    events   chan AuditEvent
+    sent     int64
+    dropped  int64
+    failures int64
+}
+
+// NewAuditExporter creates an exporter of DefaultAuditOperations to sink buffering up to buffer
+// events; 0 buffers 10000.
+func NewAuditExporter(sink AuditSink, buffer int) *AuditExporter {
+    if buffer <= 0 {
+        buffer = defaultAuditBuffer
+    }
+    operations := make(map[string]bool, len(DefaultAuditOperations))
+    for _, name := range DefaultAuditOperations {
+        operations[name] = true
+    }
+    return &AuditExporter{
+        Operations:    operations,
+        BatchSize:     defaultAuditBatchSize,
+        FlushInterval: defaultAuditFlushInterval,
+        sink:          sink,
+        events:        make(chan AuditEvent, buffer),
+    }
+}
+
+// Middleware returns the middleware recording audited operations once they complete.
+func (e *AuditExporter) Middleware() Middleware {
+    return func(next Handler) Handler {
+        return func(op *Operation) error {
+            if !e.Operations[op.Name] {
+                return next(op)
+            }
+            err := next(op)
+            if err != nil {
+                e.record(op, err)
+                return err
+            }
+            // Writes of a transaction that rolls back did not happen; wait for its outcome.
+            op.AfterTransaction(func(committed bool) {
+                if committed {
+                    e.record(op, nil)
+                } else {
+                    e.record(op, errRolledBack)
+                }
+            })
+            return nil
+        }
+    }
+}
+
+// errRolledBack marks the events of operations whose transaction rolled back.
+var errRolledBack = errors.New("transaction rolled back")
+
+// record queues the event of a completed operation.
+func (e *AuditExporter) record(op *Operation, err error) {
+    event := AuditEvent{
+        SchemaVersion: AuditSchemaVersion,
+        ID:            auditEventID(),
+        Time:          op.Started.UTC(),
+        Tenant:        TenantFromContext(op.Context),
+        Actor:         ActorFromContext(op.Context),
+        Action:        op.Name,
+        Backend:       op.Backend,
+        Entity:        op.Model,
+        Target:        op.Target,
+        Outcome:       "success",
+        DurationMs:    float64(op.Elapsed().Microseconds()) / 1000,
+    }
+    key := op.Key
+    if key == nil && (err == nil || err == errRolledBack) && op.Value != nil {
+        key, _ = ModelKey(op.Value)
+    }
+    if key != nil {
+        event.Key = fmt.Sprint(key)
+    }
+    if err == errRolledBack {
+        event.Outcome = "rolled_back"
+    } else if err != nil {
+        event.Outcome, event.Error = "failure", err.Error()
+    }
+
+    select {
+    case e.events <- event:
+        return
+    default:
+    }
+    if e.Block && op.Context != nil {
+        select {
+        case e.events <- event:
+            return
+        case <-op.Context.Done():
+        }
+    }
+    if atomic.AddInt64(&e.dropped, 1)%1000 == 1 {
+        utils.LogError(fmt.Errorf("audit buffer full"), map[string]interface{}{"operation": "Audit", "dropped": atomic.LoadInt64(&e.dropped)})
+    }
+}
+
+// Run ships queued events until the context is cancelled, then makes a last attempt at the events
+// still queued.
+func (e *AuditExporter) Run(ctx context.Context) {
+    interval := e.FlushInterval
+    if interval <= 0 {
+        interval = defaultAuditFlushInterval
+    }
+    size := e.BatchSize
+    if size <= 0 {
+        size = defaultAuditBatchSize
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    batch := make([]AuditEvent, 0, size)
+    for {
+        select {
+        case event := <-e.events:
+            if batch = append(batch, event); len(batch) < size {
+                continue
+            }
+        case <-ticker.C:
+        case <-ctx.Done():
+            for len(e.events) > 0 {
+                batch = append(batch, <-e.events)
+            }
+            if len(batch) > 0 {
+                flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+                e.send(flushCtx, batch)
+                cancel()
+            }
+            return
+        }
+        if len(batch) > 0 {
+            e.send(ctx, batch)
+            batch = batch[:0]
+        }
+    }
+}
+
+// send delivers a batch, retrying with backoff until it succeeds or the context ends.
+func (e *AuditExporter) send(ctx context.Context, batch []AuditEvent) {
+    backoff := 100 * time.Millisecond
+    for {
+        err := e.sink.Send(ctx, batch)
+        if err == nil {
+            atomic.AddInt64(&e.sent, int64(len(batch)))
+            return
+        }
+        atomic.AddInt64(&e.failures, 1)
+        utils.LogError(err, map[string]interface{}{"operation": "Audit Export", "events": len(batch)})
+        select {
+        case <-ctx.Done():
+            atomic.AddInt64(&e.dropped, int64(len(batch)))
+            return
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > maxAuditRetryBackoff {
+            backoff = maxAuditRetryBackoff
+        }
+    }
+}
+
+// Stats returns the exporter's counts.
+func (e *AuditExporter) Stats() AuditStats {
+    return AuditStats{
+        Sent:     atomic.LoadInt64(&e.sent),
+        Dropped:  atomic.LoadInt64(&e.dropped),
+        Failures: atomic.LoadInt64(&e.failures),
+        Pending:  len(e.events),
+    }
+}
+
+func auditEventID() string {
+    id := make([]byte, 16)
+    _, _ = rand.Read(id)
+    return hex.EncodeToString(id)
+}
@@ -0,0 +1,139 @@
+package orm
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/syslog"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// SyslogAuditSink writes each audit event as a JSON line to a syslog daemon, for SIEMs collecting
+// through syslog forwarding.
+type SyslogAuditSink struct {
+    Network string // "udp", "tcp" or "" for the local daemon.
+    Address string
+    Tag     string
+
+    mu     sync.Mutex
+    writer *syslog.Writer
+}
+
+// NewSyslogAuditSink creates a sink writing to the syslog daemon at address over network, both
+// empty for the local one.
+func NewSyslogAuditSink(network, address, tag string) *SyslogAuditSink {
+    if tag == "" {
+        tag = "persistence-layer-audit"
+    }
+    return &SyslogAuditSink{Network: network, Address: address, Tag: tag}
+}
+
+// Send writes the events, reconnecting on the next batch after a failure.
+func (s *SyslogAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.writer == nil {
+        writer, err := syslog.Dial(s.Network, s.Address, syslog.LOG_NOTICE|syslog.LOG_AUTH, s.Tag)
+        if err != nil {
+            return err
+        }
+        s.writer = writer
+    }
+    for i := range events {
+        line, err := json.Marshal(events[i])
+        if err != nil {
+            return err
+        }
+        if err := s.writer.Notice(string(line)); err != nil {
+            s.writer.Close()
+            s.writer = nil
+            return err
+        }
+    }
+    return nil
+}
+
+// HTTPAuditSink posts each batch of audit events as a JSON array to a collector URL, e.g. an HTTP
+// event collector. Header may carry its credentials.
+type HTTPAuditSink struct {
+    URL    string
+    Header http.Header
+    Client *http.Client
+}
+
+// NewHTTPAuditSink creates a sink posting to url.
+func NewHTTPAuditSink(url string) *HTTPAuditSink {
+    return &HTTPAuditSink{URL: url, Header: http.Header{}, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Send posts the events; any status outside 2xx fails the batch.
+func (s *HTTPAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+    body, err := json.Marshal(events)
+    if err != nil {
+        return err
+    }
+    return postAudit(ctx, s.Client, s.URL, "application/json", s.Header, body)
+}
+
+// KafkaAuditSink produces audit events to a Kafka topic through a Kafka REST Proxy, one record per
+// event keyed by its entity and key, so that the events of an entity stay ordered within a
+// partition.
+type KafkaAuditSink struct {
+    URL    string // REST Proxy base URL, e.g. "http://kafka-rest:8082".
+    Topic  string
+    Header http.Header
+    Client *http.Client
+}
+
+// NewKafkaAuditSink creates a sink producing to topic through the REST Proxy at url.
+func NewKafkaAuditSink(url, topic string) *KafkaAuditSink {
+    return &KafkaAuditSink{URL: url, Topic: topic, Header: http.Header{}, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type kafkaRecord struct {
+    Key   string     `json:"key,omitempty"`
+    Value AuditEvent `json:"value"`
+}
+
+// Send produces the events in one request.
+func (s *KafkaAuditSink) Send(ctx context.Context, events []AuditEvent) error {
+    records := make([]kafkaRecord, len(events))
+    for i, event := range events {
+        records[i] = kafkaRecord{Value: event}
+        if event.Entity != "" {
+            records[i].Key = event.Entity + ":" + event.Key
+        }
+    }
+    body, err := json.Marshal(map[string]interface{}{"records": records})
+    if err != nil {
+        return err
+    }
+    url := strings.TrimRight(s.URL, "/") + "/topics/" + s.Topic
+    return postAudit(ctx, s.Client, url, "application/vnd.kafka.json.v2+json", s.Header, body)
+}
+
+func postAudit(ctx context.Context, client *http.Client, url, contentType string, header http.Header, body []byte) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    req.Header.Set("Content-Type", contentType)
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // Lets the connection be reused.
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("audit sink %s answered %s", url, resp.Status)
+    }
+    return nil
+}
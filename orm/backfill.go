@@ -0,0 +1,305 @@
+package orm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "persistence-layer/utils"
+    "reflect"
+    "strconv"
+    "sync"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "gorm.io/gorm/schema"
+)
+
+const (
+    defaultBackfillBatchSize   = 500
+    defaultBackfillConcurrency = 4
+)
+
+// BackfillCheckpoint records the progress of a rebuild of one alias, so an interrupted backfill
+// resumes after the last indexed key instead of starting over.
+type BackfillCheckpoint struct {
+    ID        uint64    `json:"id" gorm:"primaryKey"`
+    Alias     string    `json:"alias" gorm:"size:128;not null;index"`
+    Target    string    `json:"target" gorm:"size:160;not null"` // The fresh index being filled.
+    Model     string    `json:"model" gorm:"size:64;not null"`
+    LastKey   string    `json:"last_key" gorm:"size:64"`
+    Indexed   int64     `json:"indexed"`
+    Done      bool      `json:"done"`
+    StartedAt time.Time `json:"started_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BackfillProgress is reported after every round of batches.
+type BackfillProgress struct {
+    Alias   string
+    Target  string
+    Indexed int64 // Documents indexed so far, including those of resumed runs.
+    Total   int64 // Rows in the table when the run started.
+    Elapsed time.Duration
+}
+
+// Backfiller rebuilds the search index of a model from SQL, its source of truth. It creates a
+// fresh index named <alias>_<unix time> with the model's SearchMapping, streams the table in
+// primary key order (keyset pagination, so late batches cost the same as early ones) and bulk
+// indexes Concurrency batches at a time. When the table is exhausted it points the alias, the
+// Index of the model's Policy, at the new index and re-indexes rows updated meanwhile.
+//
+// The alias must not exist as a concrete index; delete or reindex it once before the first run.
+type Backfiller struct {
+    orm         *ORM
+    model       interface{}
+    BatchSize   int
+    Concurrency int
+    Restart     bool                   // Discard an unfinished checkpoint and start a fresh index.
+    Progress    func(BackfillProgress) // Optional; progress is always logged.
+}
+
+// NewBackfiller creates a backfiller for the type of model, e.g. &models.Product{}.
+func NewBackfiller(o *ORM, model interface{}) *Backfiller {
+    return &Backfiller{orm: o, model: model, BatchSize: defaultBackfillBatchSize, Concurrency: defaultBackfillConcurrency}
+}
+
+// Backfill rebuilds the search index of model with the given batch size and concurrency, resuming
+// an interrupted run. See Backfiller.
+func (o *ORM) Backfill(model interface{}, batchSize, concurrency int) (*BackfillCheckpoint, error) {
+    b := NewBackfiller(o, model)
+    if batchSize > 0 {
+        b.BatchSize = batchSize
+    }
+    if concurrency > 0 {
+        b.Concurrency = concurrency
+    }
+    return b.Run(o.opContext())
+}
+
+// Run performs the backfill and returns the final checkpoint.
+func (b *Backfiller) Run(ctx context.Context) (*BackfillCheckpoint, error) {
+    o := b.orm
+    if o.Elasticsearch == nil {
+        return nil, backendDisabled(BackendElasticsearch)
+    }
+    if o.SQL == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    alias := o.PolicyFor(b.model).Index
+    if alias == "" {
+        return nil, fmt.Errorf("backfill %s: its policy has no search index", modelName(b.model))
+    }
+    stmt := &gorm.Statement{DB: db}
+    if err := stmt.Parse(b.model); err != nil {
+        return nil, err
+    }
+    if stmt.Schema.PrioritizedPrimaryField == nil {
+        return nil, fmt.Errorf("backfill %s: model has no primary key", modelName(b.model))
+    }
+    if err := migrating(db).AutoMigrate(&BackfillCheckpoint{}); err != nil {
+        return nil, utils.HandleSQLError(err)
+    }
+
+    checkpoint, err := b.checkpoint(db, alias)
+    if err != nil {
+        return nil, err
+    }
+    var total int64
+    if err := db.Table(stmt.Schema.Table).Count(&total).Error; err != nil {
+        return nil, utils.HandleSQLError(err)
+    }
+
+    started := time.Now()
+    for !checkpoint.Done {
+        if err := ctx.Err(); err != nil {
+            return checkpoint, err
+        }
+        // Batches are read in sequence, since each starts after the previous one's last key, and
+        // indexed in parallel. The checkpoint only moves once the whole round is indexed.
+        var round [][]interface{}
+        lastKey := checkpoint.LastKey
+        for len(round) < b.Concurrency {
+            batch, last, err := readBatch(db, stmt.Schema, lastKey, b.BatchSize)
+            if err != nil {
+                return checkpoint, err
+            }
+            if len(batch) == 0 {
+                break
+            }
+            lastKey = last
+            round = append(round, batch)
+            if len(batch) < b.BatchSize {
+                break
+            }
+        }
+        if len(round) == 0 {
+            if err := b.finish(db, checkpoint); err != nil {
+                return checkpoint, err
+            }
+            break
+        }
+        if err := b.indexRound(checkpoint.Target, round); err != nil {
+            return checkpoint, err
+        }
+        for _, batch := range round {
+            checkpoint.Indexed += int64(len(batch))
+        }
+        checkpoint.LastKey = lastKey
+        if err := db.Save(checkpoint).Error; err != nil {
+            return checkpoint, utils.HandleSQLError(err)
+        }
+        b.report(checkpoint, total, time.Since(started))
+    }
+    return checkpoint, nil
+}
+
+// checkpoint resumes the unfinished backfill of alias or starts one into a fresh index.
+func (b *Backfiller) checkpoint(db *gorm.DB, alias string) (*BackfillCheckpoint, error) {
+    var checkpoint BackfillCheckpoint
+    err := db.Where("alias = ? AND done = ?", alias, false).Order("id DESC").First(&checkpoint).Error
+    switch {
+    case err == nil && !b.Restart:
+        utils.LogInfo("Resuming backfill", map[string]interface{}{"alias": alias, "target": checkpoint.Target, "last_key": checkpoint.LastKey})
+        return &checkpoint, nil
+    case err == nil:
+        if err := db.Model(&checkpoint).Update("done", true).Error; err != nil {
+            return nil, utils.HandleSQLError(err)
+        }
+    case !errors.Is(err, gorm.ErrRecordNotFound):
+        return nil, utils.HandleSQLError(err)
+    }
+
+    now := time.Now()
+    checkpoint = BackfillCheckpoint{
+        Alias:     alias,
+        Target:    fmt.Sprintf("%s_%d", alias, now.Unix()),
+        Model:     modelName(b.model),
+        StartedAt: now,
+    }
+    if err := b.orm.Elasticsearch.EnsureIndex(checkpoint.Target, SearchMapping(b.model)); err != nil {
+        return nil, err
+    }
+    if err := db.Create(&checkpoint).Error; err != nil {
+        return nil, utils.HandleSQLError(err)
+    }
+    utils.LogInfo("Starting backfill", map[string]interface{}{"alias": alias, "target": checkpoint.Target})
+    return &checkpoint, nil
+}
+
+// indexRound bulk indexes the batches of a round concurrently, returning the first failure.
+func (b *Backfiller) indexRound(target string, round [][]interface{}) error {
+    errs := make([]error, len(round))
+    var wg sync.WaitGroup
+    for i, batch := range round {
+        wg.Add(1)
+        go func(i int, batch []interface{}) {
+            defer wg.Done()
+            docs := make([]interface{}, 0, len(batch))
+            for _, row := range batch {
+                doc, err := NewSearchDocument(row)
+                if err != nil {
+                    errs[i] = err
+                    return
+                }
+                docs = append(docs, doc)
+            }
+            errs[i] = b.orm.Elasticsearch.BulkIndex(target, docs)
+        }(i, batch)
+    }
+    wg.Wait()
+    for _, err := range errs {
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Backfill", "index": target})
+            return err
+        }
+    }
+    return nil
+}
+
+// finish switches the alias to the rebuilt index and re-indexes rows written since the backfill
+// started, which the old index received instead. Rows deleted meanwhile are not removed.
+func (b *Backfiller) finish(db *gorm.DB, checkpoint *BackfillCheckpoint) error {
+    if err := b.orm.Elasticsearch.PointAlias(checkpoint.Alias, checkpoint.Target); err != nil {
+        return err
+    }
+    if _, ok := indirectType(b.model).FieldByName("UpdatedAt"); ok {
+        rows := reflect.New(reflect.SliceOf(reflect.PtrTo(indirectType(b.model))))
+        if err := db.Model(b.model).Where("updated_at >= ?", checkpoint.StartedAt).Find(rows.Interface()).Error; err != nil {
+            return utils.HandleSQLError(err)
+        }
+        var docs []interface{}
+        for i := 0; i < rows.Elem().Len(); i++ {
+            doc, err := NewSearchDocument(rows.Elem().Index(i).Interface())
+            if err != nil {
+                return err
+            }
+            docs = append(docs, doc)
+        }
+        if err := b.orm.Elasticsearch.BulkIndex(checkpoint.Target, docs); err != nil {
+            return err
+        }
+    }
+    checkpoint.Done = true
+    if err := db.Save(checkpoint).Error; err != nil {
+        return utils.HandleSQLError(err)
+    }
+    utils.LogInfo("Backfill completed", map[string]interface{}{"alias": checkpoint.Alias, "target": checkpoint.Target, "indexed": checkpoint.Indexed})
+    return nil
+}
+
+func (b *Backfiller) report(checkpoint *BackfillCheckpoint, total int64, elapsed time.Duration) {
+    progress := BackfillProgress{Alias: checkpoint.Alias, Target: checkpoint.Target, Indexed: checkpoint.Indexed, Total: total, Elapsed: elapsed}
+    utils.LogInfo("Backfill progress", map[string]interface{}{"alias": progress.Alias, "indexed": progress.Indexed, "total": progress.Total, "elapsed": progress.Elapsed.String()})
+    if b.Progress != nil {
+        b.Progress(progress)
+    }
+}
+
+// readBatch reads up to limit rows of the table of s in primary key order, after the key
+// afterKey ("" for the first batch), returning them as pointers to the model type together with
+// the key of the last one.
+func readBatch(db *gorm.DB, s *schema.Schema, afterKey string, limit int) ([]interface{}, string, error) {
+    key := s.PrioritizedPrimaryField
+    column := clause.Column{Name: key.DBName}
+    tx := db.Table(s.Table).Order(clause.OrderByColumn{Column: column}).Limit(limit)
+    if afterKey != "" {
+        after, err := parseKey(key, afterKey)
+        if err != nil {
+            return nil, "", err
+        }
+        tx = tx.Where(clause.Gt{Column: column, Value: after})
+    }
+    rows := reflect.New(reflect.SliceOf(reflect.PtrTo(s.ModelType)))
+    if err := tx.Find(rows.Interface()).Error; err != nil {
+        return nil, "", utils.HandleSQLError(err)
+    }
+    n := rows.Elem().Len()
+    if n == 0 {
+        return nil, afterKey, nil
+    }
+    batch := make([]interface{}, n)
+    for i := range batch {
+        batch[i] = rows.Elem().Index(i).Interface()
+    }
+    last, err := ModelKey(batch[n-1])
+    if err != nil {
+        return nil, "", err
+    }
+    return batch, fmt.Sprint(last), nil
+}
+
+// parseKey converts a checkpointed key back to the type of the primary key column.
+func parseKey(field *schema.Field, key string) (interface{}, error) {
+    switch field.FieldType.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return strconv.ParseInt(key, 10, 64)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return strconv.ParseUint(key, 10, 64)
+    }
+    return key, nil
+}
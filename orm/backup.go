@@ -0,0 +1,506 @@
+package orm
+
+import (
+    "bufio"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+    "strings"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "gorm.io/gorm/schema"
+)
+
+const (
+    backupManifestFile     = "manifest.json"
+    defaultBackupBatchSize = 1000
+)
+
+// BackupStore holds the files of a backup, e.g. a directory synced to object storage.
+type BackupStore interface {
+    Create(name string) (io.WriteCloser, error)
+    Open(name string) (io.ReadCloser, error)
+}
+
+// DirBackupStore keeps backup files under Dir, e.g. a mounted bucket or a directory synced to
+// object storage after Backup returns.
+type DirBackupStore struct {
+    Dir string
+}
+
+// NewDirBackupStore creates a DirBackupStore keeping files under dir.
+func NewDirBackupStore(dir string) *DirBackupStore {
+    return &DirBackupStore{Dir: dir}
+}
+
+// Create creates the file name, a slash-separated path, and its directories.
+func (s *DirBackupStore) Create(name string) (io.WriteCloser, error) {
+    file := filepath.Join(s.Dir, filepath.FromSlash(name))
+    if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+        return nil, err
+    }
+    return os.Create(file)
+}
+
+// Open opens the file name, a slash-separated path.
+func (s *DirBackupStore) Open(name string) (io.ReadCloser, error) {
+    return os.Open(filepath.Join(s.Dir, filepath.FromSlash(name)))
+}
+
+// BackupSpec says what Backup copies and Restore reloads.
+type BackupSpec struct {
+    // Models are the SQL models to dump, in an order where tables come after the tables they
+    // reference. Sharded models are dumped from every shard.
+    Models []interface{}
+    // Collections are the Mongo collections to dump; all of them when empty.
+    Collections []string
+    // SnapshotRepository is the Elasticsearch snapshot repository, e.g. one backed by S3, that
+    // indices are snapshotted into; indices are left out when empty.
+    SnapshotRepository string
+    // Indices are the indices to snapshot; all of them when empty.
+    Indices []string
+    // Replace lets Restore delete the existing rows, documents and indices it restores over.
+    // Otherwise it refuses to restore into tables that aren't empty.
+    Replace bool
+    // BatchSize is how many rows are read or inserted at a time; defaults to 1000.
+    BatchSize int
+    // Anonymizer, when set, rewrites the fields of models tagged with anonymize as rows are dumped
+    // by Backup or loaded by Restore, e.g. to give developers production-shaped data without the
+    // personal data. Mongo collections and search indices have no tags to go by, so they are left
+    // out of anonymized backups and restores.
+    Anonymizer *Anonymizer
+}
+
+// BackupManifest describes a backup. Backup writes it last, so a backup without one is incomplete.
+type BackupManifest struct {
+    CreatedAt   time.Time       `json:"created_at"`
+    Tables      []BackupFile    `json:"tables,omitempty"`
+    Collections []BackupFile    `json:"collections,omitempty"`
+    Snapshot    *BackupSnapshot `json:"snapshot,omitempty"`
+}
+
+// BackupFile is the dump of a table or collection.
+type BackupFile struct {
+    Database string `json:"database,omitempty"` // SQL database, by the name given to AddDatabase.
+    Name     string `json:"name"`
+    File     string `json:"file"`
+    Rows     int64  `json:"rows"`
+}
+
+// BackupSnapshot is the Elasticsearch snapshot of a backup.
+type BackupSnapshot struct {
+    Repository string   `json:"repository"`
+    Name       string   `json:"name"`
+    Indices    []string `json:"indices,omitempty"`
+}
+
+// Backup copies the data described by spec into target: a newline-delimited JSON dump of every SQL
+// table, read in one repeatable-read transaction per database so each database is dumped as of a
+// single point in time; a dump of every Mongo collection; and a snapshot of the search indices.
+// The manifest is written last.
+//
+//     manifest, err := o.Backup(orm.NewDirBackupStore("/backups/2024-05-01"), orm.BackupSpec{Models: models})
+func (o *ORM) Backup(target BackupStore, spec BackupSpec) (*BackupManifest, error) {
+    manifest := &BackupManifest{CreatedAt: time.Now().UTC()}
+    databases, byDatabase, err := o.backupDatabases(spec.Models)
+    if err != nil {
+        return nil, err
+    }
+    for _, name := range databases {
+        db, err := o.Database(name)
+        if err != nil {
+            return nil, err
+        }
+        if db.SQL == nil {
+            return nil, backendDisabled(BackendSQL)
+        }
+        if db.SQL.GetDB() == nil {
+            return nil, errNoGormDB
+        }
+        err = db.SQL.GetDB().Transaction(func(tx *gorm.DB) error {
+            for _, model := range byDatabase[name] {
+                file, err := dumpTable(tx, target, name, model, spec.batchSize(), spec.Anonymizer)
+                if err != nil {
+                    return err
+                }
+                manifest.Tables = append(manifest.Tables, file)
+            }
+            return nil
+        }, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Backup", "database": name})
+            return nil, err
+        }
+    }
+
+    if o.Mongo != nil && spec.Anonymizer == nil {
+        exporter, ok := o.Mongo.(adapters.CollectionExporter)
+        if !ok {
+            return nil, errors.New("mongo backend cannot export collections")
+        }
+        collections := spec.Collections
+        if len(collections) == 0 {
+            if collections, err = exporter.Collections(); err != nil {
+                return nil, err
+            }
+        }
+        for _, collection := range collections {
+            file := BackupFile{Name: collection, File: path.Join("mongo", collection+".ndjson")}
+            err := writeBackupFile(target, file.File, func(w io.Writer) (err error) {
+                file.Rows, err = exporter.ExportCollection(collection, w)
+                return err
+            })
+            if err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "Backup", "collection": collection})
+                return nil, err
+            }
+            manifest.Collections = append(manifest.Collections, file)
+        }
+    }
+
+    if spec.SnapshotRepository != "" && spec.Anonymizer == nil {
+        snapshotter, ok := o.Elasticsearch.(adapters.Snapshotter)
+        if !ok {
+            return nil, errors.New("search backend cannot take snapshots")
+        }
+        snapshot := &BackupSnapshot{
+            Repository: spec.SnapshotRepository,
+            Name:       "backup-" + manifest.CreatedAt.Format("20060102-150405"),
+            Indices:    spec.Indices,
+        }
+        if err := snapshotter.Snapshot(snapshot.Repository, snapshot.Name, snapshot.Indices); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Backup", "snapshot": snapshot.Name})
+            return nil, err
+        }
+        manifest.Snapshot = snapshot
+    }
+
+    err = writeBackupFile(target, backupManifestFile, func(w io.Writer) error {
+        encoder := json.NewEncoder(w)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(manifest)
+    })
+    if err != nil {
+        return nil, err
+    }
+    utils.LogInfo("Backup completed successfully", map[string]interface{}{"tables": len(manifest.Tables), "collections": len(manifest.Collections), "anonymized": spec.Anonymizer != nil})
+    return manifest, nil
+}
+
+// Restore loads a backup written by Backup from source. spec.Models must include the model of
+// every table in the backup. Each SQL database is restored in one transaction, then the Mongo
+// collections and the search snapshot. The cached records of spec.Models are deleted afterwards,
+// even when the restore fails part way, since they may describe rows the restore replaced.
+func (o *ORM) Restore(source BackupStore, spec BackupSpec) (*BackupManifest, error) {
+    var manifest BackupManifest
+    err := readBackupFile(source, backupManifestFile, func(r io.Reader) error {
+        return json.NewDecoder(r).Decode(&manifest)
+    })
+    if err != nil {
+        return nil, fmt.Errorf("reading backup manifest: %w", err)
+    }
+
+    // Also when a later step fails, the databases restored before it may no longer match the cache.
+    defer func() {
+        if o.Redis != nil {
+            for _, model := range spec.Models {
+                _, _ = o.DeleteCachePattern(CacheKey(model, "*")) // Logged on failure; stale entries still expire.
+            }
+        }
+    }()
+
+    models := make(map[string]interface{}, len(spec.Models))
+    for _, model := range spec.Models {
+        s, err := schema.Parse(model, &shardSchemas, schema.NamingStrategy{})
+        if err != nil {
+            return nil, err
+        }
+        models[s.Table] = model
+    }
+    var databases []string
+    byDatabase := map[string][]BackupFile{}
+    for _, file := range manifest.Tables {
+        if _, ok := models[file.Name]; !ok {
+            return nil, fmt.Errorf("backup has table %s, but no model for it", file.Name)
+        }
+        if _, seen := byDatabase[file.Database]; !seen {
+            databases = append(databases, file.Database)
+        }
+        byDatabase[file.Database] = append(byDatabase[file.Database], file)
+    }
+    for _, name := range databases {
+        db, err := o.Database(name)
+        if err != nil {
+            return nil, err
+        }
+        if db.SQL == nil {
+            return nil, backendDisabled(BackendSQL)
+        }
+        if db.SQL.GetDB() == nil {
+            return nil, errNoGormDB
+        }
+        err = db.SQL.GetDB().Transaction(func(tx *gorm.DB) error {
+            files := byDatabase[name]
+            for i := len(files) - 1; i >= 0; i-- { // Referencing tables first.
+                if err := clearTable(tx, models[files[i].Name], spec.Replace); err != nil {
+                    return err
+                }
+            }
+            for _, file := range files {
+                if err := loadTable(tx, source, file, models[file.Name], spec.batchSize(), spec.Anonymizer); err != nil {
+                    return fmt.Errorf("restoring table %s: %w", file.Name, err)
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Restore", "database": name})
+            return nil, err
+        }
+    }
+
+    if len(manifest.Collections) > 0 && spec.Anonymizer == nil {
+        importer, ok := o.Mongo.(adapters.CollectionExporter)
+        if !ok {
+            return nil, errors.New("mongo backend cannot import collections")
+        }
+        for _, file := range manifest.Collections {
+            err := readBackupFile(source, file.File, func(r io.Reader) error {
+                _, err := importer.ImportCollection(file.Name, r, spec.Replace)
+                return err
+            })
+            if err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "Restore", "collection": file.Name})
+                return nil, err
+            }
+        }
+    }
+
+    if manifest.Snapshot != nil && spec.Anonymizer == nil {
+        snapshotter, ok := o.Elasticsearch.(adapters.Snapshotter)
+        if !ok {
+            return nil, errors.New("search backend cannot restore snapshots")
+        }
+        snapshot := manifest.Snapshot
+        if err := snapshotter.RestoreSnapshot(snapshot.Repository, snapshot.Name, snapshot.Indices); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Restore", "snapshot": snapshot.Name})
+            return nil, err
+        }
+    }
+
+    utils.LogInfo("Restore completed successfully", map[string]interface{}{"tables": len(manifest.Tables), "collections": len(manifest.Collections), "anonymized": spec.Anonymizer != nil})
+    return &manifest, nil
+}
+
+func (spec BackupSpec) batchSize() int {
+    if spec.BatchSize <= 0 {
+        return defaultBackupBatchSize
+    }
+    return spec.BatchSize
+}
+
+// backupDatabases groups models by the SQL databases holding their rows, in the order the
+// databases first appear.
+func (o *ORM) backupDatabases(models []interface{}) ([]string, map[string][]interface{}, error) {
+    var names []string
+    byDatabase := map[string][]interface{}{}
+    for _, model := range models {
+        shards, err := o.Shards(model)
+        if err != nil {
+            return nil, nil, err
+        }
+        for _, db := range shards {
+            name := db.databaseName()
+            if _, seen := byDatabase[name]; !seen {
+                names = append(names, name)
+            }
+            byDatabase[name] = append(byDatabase[name], model)
+        }
+    }
+    return names, byDatabase, nil
+}
+
+// dumpTable writes every row of model's table, soft-deleted ones included, to target in pages
+// ordered by primary key, anonymized by anonymizer unless nil. Each line maps column names to values.
+func dumpTable(tx *gorm.DB, target BackupStore, database string, model interface{}, batchSize int, anonymizer *Anonymizer) (BackupFile, error) {
+    s, err := schema.Parse(model, &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        return BackupFile{}, err
+    }
+    pk := s.PrioritizedPrimaryField
+    if pk == nil {
+        return BackupFile{}, fmt.Errorf("table %s has no primary key to page by", s.Table)
+    }
+    file := BackupFile{Database: database, Name: s.Table, File: path.Join("sql", database, s.Table+".ndjson")}
+    ctx := context.Background()
+    err = writeBackupFile(target, file.File, func(w io.Writer) error {
+        encoder := json.NewEncoder(w)
+        var last interface{}
+        for {
+            rows := reflect.New(reflect.SliceOf(s.ModelType))
+            query := tx.Unscoped().Model(model).Order(clause.OrderByColumn{Column: clause.Column{Name: pk.DBName}}).Limit(batchSize)
+            if last != nil {
+                query = query.Where(clause.Gt{Column: clause.Column{Name: pk.DBName}, Value: last})
+            }
+            if err := query.Find(rows.Interface()).Error; err != nil {
+                return err
+            }
+            page := rows.Elem()
+            for i := 0; i < page.Len(); i++ {
+                last, _ = pk.ValueOf(ctx, page.Index(i)) // Before anonymizing, which may rewrite it.
+                if anonymizer != nil {
+                    if err := anonymizer.anonymizeRow(s, page.Index(i)); err != nil {
+                        return err
+                    }
+                }
+                row := make(map[string]interface{}, len(s.DBNames))
+                for _, field := range s.Fields {
+                    if field.DBName != "" {
+                        row[field.DBName], _ = field.ValueOf(ctx, page.Index(i))
+                    }
+                }
+                if err := encoder.Encode(row); err != nil {
+                    return err
+                }
+            }
+            file.Rows += int64(page.Len())
+            if page.Len() < batchSize {
+                return nil
+            }
+        }
+    })
+    return file, err
+}
+
+// clearTable deletes every row of model's table when replace is set, and otherwise fails unless
+// the table is empty.
+func clearTable(tx *gorm.DB, model interface{}, replace bool) error {
+    if replace {
+        return tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(model).Error
+    }
+    var count int64
+    if err := tx.Unscoped().Model(model).Count(&count).Error; err != nil {
+        return err
+    }
+    if count > 0 {
+        return fmt.Errorf("table of %s is not empty; restore with Replace to overwrite it", modelName(model))
+    }
+    return nil
+}
+
+// loadTable inserts the rows dumped by dumpTable without running model hooks, which would, e.g.,
+// hash stored password hashes again, anonymizing them by anonymizer unless nil, then moves Postgres
+// sequences past the restored IDs.
+func loadTable(tx *gorm.DB, source BackupStore, file BackupFile, model interface{}, batchSize int, anonymizer *Anonymizer) error {
+    s, err := schema.Parse(model, &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        return err
+    }
+    ctx := context.Background()
+    insert := tx.Session(&gorm.Session{SkipHooks: true}).Omit(clause.Associations)
+    err = readBackupFile(source, file.File, func(r io.Reader) error {
+        rows := reflect.MakeSlice(reflect.SliceOf(s.ModelType), 0, batchSize)
+        flush := func() error {
+            if rows.Len() == 0 {
+                return nil
+            }
+            page := reflect.New(rows.Type())
+            page.Elem().Set(rows)
+            if err := insert.Create(page.Interface()).Error; err != nil {
+                return err
+            }
+            rows = rows.Slice(0, 0)
+            return nil
+        }
+        scanner := bufio.NewScanner(r)
+        scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+        for scanner.Scan() {
+            var columns map[string]json.RawMessage
+            if err := json.Unmarshal(scanner.Bytes(), &columns); err != nil {
+                return err
+            }
+            row := reflect.New(s.ModelType).Elem()
+            for column, raw := range columns {
+                field := s.LookUpField(column)
+                if field == nil {
+                    continue // Dropped from the model since the backup.
+                }
+                value := reflect.New(field.FieldType)
+                if err := json.Unmarshal(raw, value.Interface()); err != nil {
+                    return fmt.Errorf("column %s: %w", column, err)
+                }
+                if err := field.Set(ctx, row, value.Elem().Interface()); err != nil {
+                    return fmt.Errorf("column %s: %w", column, err)
+                }
+            }
+            if anonymizer != nil {
+                if err := anonymizer.anonymizeRow(s, row); err != nil {
+                    return err
+                }
+            }
+            rows = reflect.Append(rows, row)
+            if rows.Len() == batchSize {
+                if err := flush(); err != nil {
+                    return err
+                }
+            }
+        }
+        if err := scanner.Err(); err != nil {
+            return err
+        }
+        return flush()
+    })
+    if err != nil {
+        return err
+    }
+
+    pk := s.PrioritizedPrimaryField
+    if tx.Dialector.Name() == "postgres" && pk != nil && pk.AutoIncrement {
+        table, column := tx.Statement.Quote(s.Table), tx.Statement.Quote(pk.DBName)
+        return tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence(?, ?), COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)", column, table),
+            s.Table, pk.DBName).Error
+    }
+    return nil
+}
+
+// writeBackupFile creates name in target and hands it to write.
+func writeBackupFile(target BackupStore, name string, write func(w io.Writer) error) error {
+    w, err := target.Create(name)
+    if err != nil {
+        return err
+    }
+    buffered := bufio.NewWriter(w)
+    if err := write(buffered); err != nil {
+        w.Close()
+        return err
+    }
+    if err := buffered.Flush(); err != nil {
+        w.Close()
+        return err
+    }
+    return w.Close()
+}
+
+// readBackupFile opens name in source and hands it to read.
+func readBackupFile(source BackupStore, name string, read func(r io.Reader) error) error {
+    if strings.Contains(name, "..") {
+        return fmt.Errorf("invalid backup file %q", name)
+    }
+    r, err := source.Open(name)
+    if err != nil {
+        return err
+    }
+    defer r.Close()
+    return read(bufio.NewReader(r))
+}
@@ -0,0 +1,94 @@
+package orm
+
+import (
+    "persistence-layer/utils"
+    "sync"
+    "time"
+)
+
+// Circuit breaker states.
+const (
+    BreakerClosed   = "closed"
+    BreakerOpen     = "open"
+    BreakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calls to a failing backend. After FailureThreshold consecutive failures it
+// opens for OpenFor; then a single trial call is let through (half-open), closing the breaker on
+// success and reopening it on failure.
+type CircuitBreaker struct {
+    Name             string
+    FailureThreshold int
+    OpenFor          time.Duration
+
+    mu       sync.Mutex
+    state    string
+    failures int
+    openedAt time.Time
+    trial    bool // A half-open trial call is in flight.
+}
+
+// NewCircuitBreaker creates a closed breaker.
+func NewCircuitBreaker(name string, failureThreshold int, openFor time.Duration) *CircuitBreaker {
+    return &CircuitBreaker{Name: name, FailureThreshold: failureThreshold, OpenFor: openFor, state: BreakerClosed}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be followed by Success or
+// Failure.
+func (b *CircuitBreaker) Allow() bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    switch b.state {
+    case BreakerOpen:
+        if time.Since(b.openedAt) < b.OpenFor {
+            return false
+        }
+        b.transition(BreakerHalfOpen)
+        b.trial = true
+        return true
+    case BreakerHalfOpen:
+        if b.trial {
+            return false
+        }
+        b.trial = true
+    }
+    return true
+}
+
+// Success records a successful call, closing the breaker.
+func (b *CircuitBreaker) Success() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.failures = 0
+    b.trial = false
+    if b.state != BreakerClosed {
+        b.transition(BreakerClosed)
+    }
+}
+
+// Failure records a failed call, opening the breaker at the threshold or after a failed trial.
+func (b *CircuitBreaker) Failure() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.failures++
+    b.trial = false
+    if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.FailureThreshold) {
+        b.openedAt = time.Now()
+        b.transition(BreakerOpen)
+    }
+}
+
+// State returns BreakerClosed, BreakerOpen or BreakerHalfOpen.
+func (b *CircuitBreaker) State() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.state == "" {
+        return BreakerClosed
+    }
+    return b.state
+}
+
+func (b *CircuitBreaker) transition(state string) {
+    utils.LogInfo("Circuit breaker state changed", map[string]interface{}{"breaker": b.Name, "from": b.state, "to": state, "failures": b.failures})
+    b.state = state
+}
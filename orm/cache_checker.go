@@ -0,0 +1,148 @@
+package orm
+
+import (
+    "context"
+    "fmt"
+    "persistence-layer/utils"
+    "sync"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// CacheChecker compares the cached entries of a model, as ReadByKey fills them, with the rows in
+// SQL, reporting entries that differ from their row; these are writes whose invalidation was
+// missed. With Repair set stale entries are deleted, so the next read reloads them. Rows without
+// a cache entry are skipped, and Checked counts only the entries compared. SampleSize and
+// BatchSize work as for SearchChecker.
+type CacheChecker struct {
+    orm        *ORM
+    model      interface{}
+    BatchSize  int
+    SampleSize int  // Rows checked per run; 0 checks every row.
+    Repair     bool // Delete stale entries.
+
+    mu   sync.Mutex
+    last *DriftReport
+}
+
+// NewCacheChecker creates a checker for the type of model, e.g. &models.Product{}.
+func NewCacheChecker(o *ORM, model interface{}) *CacheChecker {
+    return &CacheChecker{orm: o, model: model, BatchSize: defaultBackfillBatchSize}
+}
+
+// Run executes Check every interval until the context is cancelled.
+func (c *CacheChecker) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        _, _ = c.Check(ctx)
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// LastReport returns the report of the latest completed check, or nil before the first.
+func (c *CacheChecker) LastReport() *DriftReport {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.last
+}
+
+// Check runs one comparison.
+func (c *CacheChecker) Check(ctx context.Context) (*DriftReport, error) {
+    report, err := c.check(ctx)
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Cache Consistency Check", "model": modelName(c.model)})
+        return nil, err
+    }
+    report.Finished = time.Now()
+    c.mu.Lock()
+    c.last = report
+    c.mu.Unlock()
+    fields := map[string]interface{}{
+        "model": report.Model, "checked": report.Checked, "stale": len(report.Stale), "repaired": report.Repaired,
+    }
+    if len(report.Stale) > 0 {
+        fields["keys"] = report.Stale
+        utils.LogError(fmt.Errorf("%d stale cache entries", len(report.Stale)), fields)
+    } else {
+        utils.LogInfo("Cache consistency checked", fields)
+    }
+    return report, nil
+}
+
+func (c *CacheChecker) check(ctx context.Context) (*DriftReport, error) {
+    o := c.orm
+    if o.Redis == nil {
+        return nil, backendDisabled(BackendRedis)
+    }
+    if o.SQL == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    if policy := o.PolicyFor(c.model); !policy.Cache || !policy.SQL {
+        return nil, fmt.Errorf("check %s: its policy does not cache SQL rows", modelName(c.model))
+    }
+    stmt := &gorm.Statement{DB: db}
+    if err := stmt.Parse(c.model); err != nil {
+        return nil, err
+    }
+    if stmt.Schema.PrioritizedPrimaryField == nil {
+        return nil, fmt.Errorf("check %s: model has no primary key", modelName(c.model))
+    }
+
+    report := &DriftReport{Model: modelName(c.model), Missing: []string{}, Stale: []string{}}
+    err := scanRows(ctx, db, stmt.Schema, c.SampleSize, c.BatchSize, func(batch []interface{}) error {
+        return c.compare(batch, report)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return report, nil
+}
+
+// compare checks the cache entries of a batch of rows, deleting stale ones when asked to.
+func (c *CacheChecker) compare(rows []interface{}, report *DriftReport) error {
+    byKey := make(map[string]interface{}, len(rows))
+    keys := make([]string, 0, len(rows))
+    for _, row := range rows {
+        key, err := ModelKey(row)
+        if err != nil {
+            return err
+        }
+        cacheKey := CacheKey(row, key)
+        byKey[cacheKey] = row
+        keys = append(keys, cacheKey)
+    }
+    cached, err := c.orm.Redis.GetMany(keys)
+    if err != nil {
+        return err
+    }
+
+    for _, key := range keys {
+        entry, ok := cached[key]
+        if !ok {
+            continue
+        }
+        report.Checked++
+        if sameSource(byKey[key], entry) {
+            continue
+        }
+        report.Stale = append(report.Stale, key)
+        if !c.Repair {
+            continue
+        }
+        if err := c.orm.Redis.Delete(key); err != nil {
+            return err
+        }
+        report.Repaired++
+    }
+    return nil
+}
@@ -0,0 +1,291 @@
+package orm
+
+import (
+    "context"
+    "errors"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+const (
+    // refreshMarkerPrefix prefixes the shadow key whose expiry triggers the refresh of an entry.
+    refreshMarkerPrefix = "refresh:"
+    // refreshGuardPrefix prefixes the counter every invalidation of an entry bumps, checked before a
+    // refresh writes the entry back.
+    refreshGuardPrefix = "refresh-guard:"
+    // refreshGuardTTL keeps a guard far longer than any refresh takes.
+    refreshGuardTTL = time.Hour
+    // DefaultRefreshLead is how long before expiry entries are refreshed by default.
+    DefaultRefreshLead = 10 * time.Second
+    // DefaultRefreshWorkers is how many entries are refreshed at once by default.
+    DefaultRefreshWorkers = 8
+    // DefaultRefreshIdle is how long after its last read an entry is still refreshed by default.
+    DefaultRefreshIdle = 10 * time.Minute
+)
+
+// RefreshFunc loads the current value of a cache key and the TTL to cache it with. It returns
+// utils.ErrNotFound (or any error) to let the entry lapse.
+type RefreshFunc func(key string) (value interface{}, ttl time.Duration, err error)
+
+// CacheRefresher keeps designated hot cache entries warm by re-populating them in the background,
+// driven by Redis expired-key notifications. Entries written with Set get a shadow key that
+// expires Lead before them, so they are reloaded before clients can miss. Other entries under a
+// registered prefix, such as those cached by ReadByKey, are reloaded right after they expire and
+// carry a shadow key from then on.
+//
+// Only entries read through the ORM within Idle are refreshed, so an entry nobody reads lapses;
+// set the refresher as ORM.Refresher for reads to count. Every instance hears every notification,
+// and the instances that read the entry compete for its refresh. At most Workers entries are
+// reloaded at once. A refresh is written back only if no write invalidated the entry while it was
+// loading, when the cache backend supports it (see adapters.GuardedCache).
+//
+//     r := orm.NewCacheRefresher(o)
+//     r.RegisterModel(&models.Product{})
+//     o.Refresher = r
+//     go r.Run(ctx)
+type CacheRefresher struct {
+    orm     *ORM
+    Lead    time.Duration
+    Workers int           // Defaults to DefaultRefreshWorkers.
+    Idle    time.Duration // Defaults to DefaultRefreshIdle.
+
+    mu      sync.RWMutex
+    sources map[string]RefreshFunc // By key prefix.
+
+    readsMu sync.Mutex
+    reads   map[string]time.Time // Last read of the entries under a registered prefix.
+}
+
+// NewCacheRefresher creates a refresher with no registered keys.
+func NewCacheRefresher(o *ORM) *CacheRefresher {
+    return &CacheRefresher{
+        orm:     o,
+        Lead:    DefaultRefreshLead,
+        Workers: DefaultRefreshWorkers,
+        Idle:    DefaultRefreshIdle,
+        sources: make(map[string]RefreshFunc),
+        reads:   make(map[string]time.Time),
+    }
+}
+
+// Register designates the keys starting with prefix as hot, reloading them with load.
+func (r *CacheRefresher) Register(prefix string, load RefreshFunc) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.sources[prefix] = load
+}
+
+// RegisterModel designates every cached record of the type of model, e.g. &models.Product{}, as
+// hot, reloading it from the source of truth of its Policy with the policy's CacheTTL.
+func (r *CacheRefresher) RegisterModel(model interface{}) {
+    t := indirectType(model)
+    prefix := CacheKey(model, "")
+    r.Register(prefix, func(key string) (interface{}, time.Duration, error) {
+        record := reflect.New(t).Interface()
+        policy := r.orm.PolicyFor(record)
+        id := r.orm.typedKey(record, strings.TrimPrefix(key, prefix))
+        if err := r.orm.readSource(policy, id, record); err != nil {
+            return nil, 0, err
+        }
+        return record, policy.CacheTTL, nil
+    })
+}
+
+// Set caches value at key like SetCache and schedules its refresh Lead before it expires.
+func (r *CacheRefresher) Set(key string, value interface{}, ttl time.Duration) error {
+    _, err := r.set(key, value, ttl, nil)
+    return err
+}
+
+// set is Set, writing the entry only while its guard still holds *guard when guard is not nil. It
+// reports whether the entry was written.
+func (r *CacheRefresher) set(key string, value interface{}, ttl time.Duration, guard *int64) (bool, error) {
+    o := r.orm
+    written := true
+    err := o.invoke("RefreshCache", BackendRedis, value, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        ttl = o.jitter(ttl)
+        var err error
+        if guarded, ok := o.Redis.(adapters.GuardedCache); ok && guard != nil {
+            if written, err = guarded.SetIfGuard(key, value, ttl, refreshGuardPrefix+key, *guard); err == nil && !written {
+                return nil
+            }
+        } else {
+            err = o.Redis.SetWithTTL(key, value, ttl)
+        }
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RefreshCache", "key": key})
+            return err
+        }
+        lead := r.Lead
+        if lead >= ttl {
+            lead = ttl / 2
+        }
+        if lead <= 0 {
+            return nil
+        }
+        return o.Redis.SetWithTTL(refreshMarkerPrefix+key, 1, ttl-lead)
+    })
+    return written && err == nil, err
+}
+
+// Run subscribes to expired-key notifications and refreshes designated keys read within Idle until
+// the context is cancelled. With several instances running, each refresh is claimed by one of them
+// when the cache backend supports locks.
+func (r *CacheRefresher) Run(ctx context.Context) error {
+    if r.orm.Redis == nil {
+        return backendDisabled(BackendRedis)
+    }
+    notifier, ok := r.orm.Redis.(adapters.ExpiryNotifier)
+    if !ok {
+        return errors.New("cache refresher: the cache backend does not report expired keys")
+    }
+    workers := r.Workers
+    if workers <= 0 {
+        workers = DefaultRefreshWorkers
+    }
+    slots := make(chan struct{}, workers)
+    go r.forget(ctx)
+    return notifier.SubscribeExpired(ctx, func(key string) {
+        key = strings.TrimPrefix(key, refreshMarkerPrefix)
+        load := r.source(key)
+        if load == nil || !r.recentlyRead(key) {
+            return
+        }
+        select {
+        case slots <- struct{}{}:
+        case <-ctx.Done():
+            return
+        }
+        go func() {
+            defer func() { <-slots }()
+            r.refresh(key, load)
+        }()
+    })
+}
+
+func (r *CacheRefresher) source(key string) RefreshFunc {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    for prefix, load := range r.sources {
+        if strings.HasPrefix(key, prefix) {
+            return load
+        }
+    }
+    return nil
+}
+
+// read records a read of the entry at key through the ORM.
+func (r *CacheRefresher) read(key string) {
+    if r.source(key) == nil {
+        return
+    }
+    r.readsMu.Lock()
+    defer r.readsMu.Unlock()
+    r.reads[key] = time.Now()
+}
+
+// recentlyRead reports whether the entry at key was read within Idle.
+func (r *CacheRefresher) recentlyRead(key string) bool {
+    r.readsMu.Lock()
+    defer r.readsMu.Unlock()
+    last, ok := r.reads[key]
+    return ok && time.Since(last) < r.idle()
+}
+
+// forget drops the reads older than Idle every Idle until the context is cancelled.
+func (r *CacheRefresher) forget(ctx context.Context) {
+    ticker := time.NewTicker(r.idle())
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            r.readsMu.Lock()
+            for key, last := range r.reads {
+                if time.Since(last) >= r.idle() {
+                    delete(r.reads, key)
+                }
+            }
+            r.readsMu.Unlock()
+        }
+    }
+}
+
+func (r *CacheRefresher) idle() time.Duration {
+    if r.Idle <= 0 {
+        return DefaultRefreshIdle
+    }
+    return r.Idle
+}
+
+// invalidated bumps the guard of the entry at key, before a write drops it, so a refresh that
+// loaded the entry earlier doesn't write it back.
+func (r *CacheRefresher) invalidated(key string) {
+    guarded, ok := r.orm.Redis.(adapters.GuardedCache)
+    if !ok || r.source(key) == nil {
+        return
+    }
+    if err := guarded.BumpGuard(refreshGuardPrefix+key, refreshGuardTTL); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "RefreshCache Guard", "key": key})
+    }
+}
+
+// refresh reloads key unless another instance is already doing so.
+func (r *CacheRefresher) refresh(key string, load RefreshFunc) {
+    if locker, ok := r.orm.Redis.(interface {
+        TryLock(key, token string, ttl time.Duration) (bool, error)
+    }); ok {
+        if locked, err := locker.TryLock("refresh-lock:"+key, utils.NewUUIDv7(), r.Lead); err != nil || !locked {
+            return
+        }
+    }
+    // The guard is read before loading: a write committing meanwhile bumps it before dropping the
+    // entry, and the stale value loaded here is then not written back.
+    var guard int64
+    if err := r.orm.Redis.Get(refreshGuardPrefix+key, &guard); err != nil && !errors.Is(err, utils.ErrCacheMiss) {
+        utils.LogError(err, map[string]interface{}{"operation": "RefreshCache Guard", "key": key})
+        return
+    }
+    value, ttl, err := load(key)
+    if err != nil {
+        if !errors.Is(err, utils.ErrNotFound) {
+            utils.LogError(err, map[string]interface{}{"operation": "RefreshCache", "key": key})
+        }
+        return
+    }
+    written, err := r.set(key, value, ttl, &guard)
+    if err != nil {
+        return
+    }
+    if !written {
+        utils.LogInfo("Cache refresh dropped after a concurrent write", map[string]interface{}{"key": key})
+        return
+    }
+    utils.LogInfo("Cache entry refreshed", map[string]interface{}{"key": key})
+}
+
+// typedKey converts a key taken from a cache key back to the type of the model's primary key.
+func (o *ORM) typedKey(model interface{}, key string) interface{} {
+    if o.SQL == nil || o.SQL.GetDB() == nil {
+        return key
+    }
+    stmt := &gorm.Statement{DB: o.SQL.GetDB()}
+    if err := stmt.Parse(model); err != nil || stmt.Schema.PrioritizedPrimaryField == nil {
+        return key
+    }
+    typed, err := parseKey(stmt.Schema.PrioritizedPrimaryField, key)
+    if err != nil {
+        return key
+    }
+    return typed
+}
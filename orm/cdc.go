@@ -0,0 +1,437 @@
+package orm
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "gorm.io/gorm/schema"
+)
+
+// ChangeEvent is a row change captured from the database log by a CDCConsumer.
+type ChangeEvent struct {
+    Operation string      // "Create", "Update", "Delete" or "Truncate".
+    Table     string
+    Key       interface{} // Primary key of the row; nil for truncates.
+    Model     interface{} // Pointer to the row after the change, or before it for deletes; nil for truncates.
+    Before    interface{} // Pointer to the row before an update if captured (binlog_row_image=FULL); else nil.
+    Snapshot  bool        // Read by the connector's initial snapshot rather than from the log.
+    At        time.Time   // When the database applied the change.
+}
+
+// CDCConsumer applies row changes captured from the MySQL binlog by Debezium to the cache and
+// search index of each model's Policy, so they stay in sync with writes made by other
+// applications directly against the database. Debezium Server delivers the change events to one
+// Redis stream per table, named "<topic prefix>.<database>.<table>" after the cache namespace's
+// key prefix, if any, with its Redis sink in either message format:
+//
+//     c := orm.NewCDCConsumer(o, "cdc")
+//     _, err := c.Watch("app.app_db.products", &models.Product{})
+//     c.OnChange(func(ctx context.Context, e orm.ChangeEvent) error { ... })
+//     go c.Consume(ctx)
+//
+// Events are consumed at least once and in order per table, as long as a single consumer of the
+// group reads each stream: a failing event is retried in place, holding back the later changes of
+// its table, and after MaxDeliveries attempts the stream stops, so Consume returns an error, rather
+// than an older image of a row overwriting a newer one in the index. Writes made through the ORM
+// come back as events too, which is harmless: applying an event again invalidates and indexes the
+// same row.
+type CDCConsumer struct {
+    orm      *ORM
+    Group    string
+    streams  []cdcStream
+    handlers []func(context.Context, ChangeEvent) error
+}
+
+type cdcStream struct {
+    queue  *TaskQueue
+    model  interface{}
+    schema *schema.Schema
+}
+
+// NewCDCConsumer creates a consumer reading change events as group.
+func NewCDCConsumer(o *ORM, group string) *CDCConsumer {
+    return &CDCConsumer{orm: o, Group: group}
+}
+
+// Watch consumes the change events of model's table from stream, e.g. "app.app_db.products". It
+// returns the stream's ordered queue, whose retry settings may be tuned before Consume.
+func (c *CDCConsumer) Watch(stream string, model interface{}) (*TaskQueue, error) {
+    s, err := schema.Parse(model, &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        return nil, err
+    }
+    q := NewTaskQueue(c.orm, "", c.Group)
+    q.Stream = stream
+    q.decode = debeziumTask
+    q.Ordered = true
+    c.streams = append(c.streams, cdcStream{queue: q, model: model, schema: s})
+    return q, nil
+}
+
+// OnChange adds a handler called with every change after the cache and index were updated, e.g. to
+// publish domain events. A failing handler makes the event be delivered again, to every handler.
+func (c *CDCConsumer) OnChange(handler func(context.Context, ChangeEvent) error) {
+    c.handlers = append(c.handlers, handler)
+}
+
+// Consume applies change events from every watched stream until the context is cancelled.
+func (c *CDCConsumer) Consume(ctx context.Context) error {
+    if len(c.streams) == 0 {
+        return errors.New("cdc: no streams watched")
+    }
+    errs := make(chan error, len(c.streams))
+    for _, stream := range c.streams {
+        stream := stream
+        go func() {
+            err := stream.queue.Consume(ctx, func(ctx context.Context, task Task) error {
+                return c.apply(ctx, stream, task)
+            })
+            if err != nil && ctx.Err() == nil {
+                utils.LogError(err, map[string]interface{}{"operation": "CDC", "stream": stream.queue.Stream})
+            }
+            errs <- err
+        }()
+    }
+    var first error
+    for range c.streams {
+        if err := <-errs; err != nil && !errors.Is(err, ctx.Err()) && first == nil {
+            first = err
+        }
+    }
+    if first != nil {
+        return first
+    }
+    return ctx.Err()
+}
+
+// debeziumEvent is the envelope of a Debezium change event.
+type debeziumEvent struct {
+    Before json.RawMessage `json:"before"`
+    After  json.RawMessage `json:"after"`
+    Op     string          `json:"op"`
+    Source struct {
+        Table string `json:"table"`
+        TsMs  int64  `json:"ts_ms"`
+    } `json:"source"`
+}
+
+var debeziumOperations = map[string]string{"c": "Create", "r": "Create", "u": "Update", "d": "Delete", "t": "Truncate"}
+
+// debeziumTask takes the event out of an entry of the Debezium Redis sink: a "value" field in the
+// extended message format, or the value of the entry's only field, keyed by the event key, in the
+// compact one.
+func debeziumTask(m adapters.StreamMessage) Task {
+    value, ok := m.Values["value"]
+    if !ok && len(m.Values) == 1 {
+        for _, v := range m.Values {
+            value = v
+        }
+    }
+    return Task{Type: "debezium", Payload: json.RawMessage(value)}
+}
+
+// apply brings the cache and index in line with one change event and passes it to the handlers.
+func (c *CDCConsumer) apply(ctx context.Context, stream cdcStream, task Task) error {
+    event, err := decodeChange(stream, task.Payload)
+    if err != nil || event == nil { // Malformed events stop the stream; tombstones carry none.
+        return err
+    }
+    policy := c.orm.PolicyFor(stream.model)
+    if event.Operation == "Truncate" {
+        if policy.Cache && c.orm.Redis != nil {
+            if _, err := c.orm.DeleteCachePattern(CacheKey(stream.model, "*")); err != nil {
+                return err
+            }
+        }
+        // Documents of a truncated table stay in the index until it is rebuilt.
+        utils.LogInfo("Table truncated outside the ORM", map[string]interface{}{"operation": "CDC", "table": event.Table})
+    } else {
+        if policy.Cache && c.orm.Redis != nil {
+            if err := c.orm.invalidate(event.Model, event.Key); err != nil {
+                return err
+            }
+        }
+        // Writes of other applications never reach the outbox, so AsyncIndex policies are indexed
+        // here too.
+        if err := c.orm.syncIndex(event.Operation, policy, event.Key, event.Model); err != nil {
+            return err
+        }
+    }
+    for _, handle := range c.handlers {
+        if err := handle(ctx, *event); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// decodeChange decodes a Debezium change event of stream's table, with or without its schema;
+// nil means a tombstone, which Debezium sends after deletes for log compaction.
+func decodeChange(stream cdcStream, data []byte) (*ChangeEvent, error) {
+    var wrapped struct {
+        Schema  json.RawMessage `json:"schema"`
+        Payload json.RawMessage `json:"payload"`
+    }
+    var columns map[string]columnSchema
+    if err := json.Unmarshal(data, &wrapped); err == nil && wrapped.Schema != nil && wrapped.Payload != nil {
+        data = wrapped.Payload
+        columns = rowSchema(wrapped.Schema)
+    }
+    if data = bytes.TrimSpace(data); len(data) == 0 || bytes.Equal(data, []byte("null")) {
+        return nil, nil
+    }
+    var envelope debeziumEvent
+    if err := json.Unmarshal(data, &envelope); err != nil {
+        return nil, fmt.Errorf("cdc: decoding change event: %w", err)
+    }
+    operation, ok := debeziumOperations[envelope.Op]
+    if !ok {
+        return nil, fmt.Errorf("cdc: unknown operation %q", envelope.Op)
+    }
+    event := &ChangeEvent{
+        Operation: operation,
+        Table:     envelope.Source.Table,
+        Snapshot:  envelope.Op == "r",
+        At:        time.UnixMilli(envelope.Source.TsMs),
+    }
+    if operation == "Truncate" {
+        return event, nil
+    }
+    row := envelope.After
+    if operation == "Delete" {
+        row = envelope.Before
+    }
+    var err error
+    if event.Model, err = decodeRow(stream.schema, row, columns); err != nil {
+        return nil, fmt.Errorf("cdc: table %s: %w", event.Table, err)
+    }
+    if operation == "Update" && len(envelope.Before) > 0 && !bytes.Equal(envelope.Before, []byte("null")) {
+        if event.Before, err = decodeRow(stream.schema, envelope.Before, columns); err != nil {
+            return nil, fmt.Errorf("cdc: table %s: %w", event.Table, err)
+        }
+    }
+    if pk := stream.schema.PrioritizedPrimaryField; pk != nil {
+        event.Key, _ = pk.ValueOf(context.Background(), reflect.ValueOf(event.Model).Elem())
+    }
+    return event, nil
+}
+
+// columnSchema is the Kafka Connect schema of a column of a row image. Name is its logical type,
+// e.g. "io.debezium.time.MicroTimestamp"; empty for plain values.
+type columnSchema struct {
+    Field      string            `json:"field"`
+    Name       string            `json:"name"`
+    Parameters map[string]string `json:"parameters"`
+}
+
+// rowSchema returns the column schemas of the row images of an event's schema, by column.
+func rowSchema(data json.RawMessage) map[string]columnSchema {
+    var envelope struct {
+        Fields []struct {
+            Field  string         `json:"field"`
+            Fields []columnSchema `json:"fields"`
+        } `json:"fields"`
+    }
+    if err := json.Unmarshal(data, &envelope); err != nil {
+        return nil
+    }
+    for _, image := range envelope.Fields {
+        if (image.Field == "after" || image.Field == "before") && len(image.Fields) > 0 {
+            columns := make(map[string]columnSchema, len(image.Fields))
+            for _, column := range image.Fields {
+                columns[column.Field] = column
+            }
+            return columns
+        }
+    }
+    return nil
+}
+
+// decodeRow decodes the columns of a row image into a new model of s, using the column schemas of
+// the event when it carries them.
+func decodeRow(s *schema.Schema, data json.RawMessage, columns map[string]columnSchema) (interface{}, error) {
+    var values map[string]json.RawMessage
+    if err := json.Unmarshal(data, &values); err != nil {
+        return nil, err
+    }
+    if values == nil {
+        return nil, errors.New("change event has no row image")
+    }
+    model := reflect.New(s.ModelType)
+    for column, raw := range values {
+        field := s.LookUpField(column)
+        if field == nil {
+            continue // Not mapped by the model.
+        }
+        if err := setColumn(field, model.Elem(), raw, columns[column]); err != nil {
+            return nil, fmt.Errorf("column %s: %w", column, err)
+        }
+    }
+    return model.Interface(), nil
+}
+
+// Logical types of the Debezium MySQL connector's columns.
+const (
+    connectDecimal    = "org.apache.kafka.connect.data.Decimal"
+    connectDate       = "org.apache.kafka.connect.data.Date"
+    connectTimestamp  = "org.apache.kafka.connect.data.Timestamp"
+    debeziumDate      = "io.debezium.time.Date"
+    debeziumTimestamp = "io.debezium.time.Timestamp"
+    debeziumMicros    = "io.debezium.time.MicroTimestamp"
+    debeziumNanos     = "io.debezium.time.NanoTimestamp"
+)
+
+// timeUnits is the unit of the numbers of each temporal logical type, since the epoch.
+var timeUnits = map[string]time.Duration{
+    connectDate:       24 * time.Hour,
+    debeziumDate:      24 * time.Hour,
+    connectTimestamp:  time.Millisecond,
+    debeziumTimestamp: time.Millisecond,
+    debeziumMicros:    time.Microsecond,
+    debeziumNanos:     time.Nanosecond,
+}
+
+// setColumn sets field from a column value of a row image. Debezium encodes some values unlike
+// encoding/json: temporal columns as numbers since the epoch, DECIMAL as the base64 bytes of its
+// unscaled value and TINYINT(1) as a number. column says which, when the event carries a schema;
+// otherwise the field's column type does.
+func setColumn(field *schema.Field, row reflect.Value, raw json.RawMessage, column columnSchema) error {
+    ctx := context.Background()
+    if column.Name == connectDecimal {
+        if value, ok, err := debeziumDecimal(raw, field, column); err != nil {
+            return err
+        } else if ok {
+            return field.Set(ctx, row, value)
+        }
+    }
+    value := reflect.New(field.FieldType)
+    if err := json.Unmarshal(raw, value.Interface()); err == nil {
+        return field.Set(ctx, row, value.Elem().Interface())
+    }
+    isTime := field.FieldType == schema.TimeReflectType || field.FieldType == schema.TimePtrReflectType
+    if column.Name == "" && !isTime && isJSONString(raw) {
+        // A string the field's type does not take is a DECIMAL when it is valid base64.
+        if value, ok, err := debeziumDecimal(raw, field, column); ok && err == nil {
+            return field.Set(ctx, row, value)
+        }
+    }
+    var number json.Number
+    if err := json.Unmarshal(raw, &number); err != nil {
+        var v interface{}
+        if err := json.Unmarshal(raw, &v); err != nil {
+            return err
+        }
+        return field.Set(ctx, row, v)
+    }
+    n, err := number.Int64()
+    if err != nil {
+        f, err := number.Float64()
+        if err != nil {
+            return err
+        }
+        return field.Set(ctx, row, f)
+    }
+    if isTime {
+        unit, ok := timeUnits[column.Name]
+        if !ok {
+            unit = columnTimeUnit(field)
+        }
+        return field.Set(ctx, row, debeziumTime(n, unit))
+    }
+    return field.Set(ctx, row, n)
+}
+
+// debeziumDecimal decodes a DECIMAL column: the big-endian two's complement bytes of its unscaled
+// value, base64 encoded, scaled by the schema's "scale" parameter or else by the field's. ok is
+// false when raw is not such a value, e.g. with decimal.handling.mode string or double.
+func debeziumDecimal(raw json.RawMessage, field *schema.Field, column columnSchema) (string, bool, error) {
+    var encoded string
+    if err := json.Unmarshal(raw, &encoded); err != nil {
+        return "", false, nil
+    }
+    data, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil {
+        if column.Name == connectDecimal {
+            return "", false, fmt.Errorf("decoding DECIMAL: %w", err)
+        }
+        return "", false, nil
+    }
+    scale := field.Scale
+    if s, ok := column.Parameters["scale"]; ok {
+        if scale, err = strconv.Atoi(s); err != nil {
+            return "", false, fmt.Errorf("DECIMAL scale %q: %w", s, err)
+        }
+    }
+    unscaled := new(big.Int).SetBytes(data)
+    if len(data) > 0 && data[0]&0x80 != 0 {
+        unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(data))*8))
+    }
+    return scaledDecimal(unscaled, scale), true, nil
+}
+
+// scaledDecimal formats unscaled * 10^-scale exactly.
+func scaledDecimal(unscaled *big.Int, scale int) string {
+    digits := new(big.Int).Abs(unscaled).String()
+    if scale <= 0 {
+        digits += strings.Repeat("0", -scale)
+    } else {
+        if len(digits) <= scale {
+            digits = strings.Repeat("0", scale-len(digits)+1) + digits
+        }
+        digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+    }
+    if unscaled.Sign() < 0 {
+        return "-" + digits
+    }
+    return digits
+}
+
+// isJSONString reports whether raw is a JSON string.
+func isJSONString(raw json.RawMessage) bool {
+    raw = bytes.TrimSpace(raw)
+    return len(raw) > 0 && raw[0] == '"'
+}
+
+// columnTimeUnit returns the unit Debezium encodes a temporal column in when the event carries no
+// schema, from the field's column type: days for DATE, microseconds for DATETIME with a fractional
+// precision above 3 and milliseconds otherwise. TIMESTAMP columns come as strings.
+func columnTimeUnit(field *schema.Field) time.Duration {
+    columnType := strings.ToLower(strings.TrimSpace(field.TagSettings["TYPE"]))
+    if columnType == "date" {
+        return 24 * time.Hour
+    }
+    precision := field.Precision
+    if open := strings.IndexByte(columnType, '('); open >= 0 && strings.HasSuffix(columnType, ")") {
+        if p, err := strconv.Atoi(columnType[open+1 : len(columnType)-1]); err == nil {
+            precision = p
+        }
+    }
+    if precision > 3 {
+        return time.Microsecond
+    }
+    return time.Millisecond
+}
+
+// debeziumTime converts a temporal column of n units since the epoch.
+func debeziumTime(n int64, unit time.Duration) time.Time {
+    switch unit {
+    case time.Millisecond:
+        return time.UnixMilli(n).UTC()
+    case time.Microsecond:
+        return time.UnixMicro(n).UTC()
+    case time.Nanosecond:
+        return time.Unix(0, n).UTC()
+    }
+    return time.Unix(n*int64(unit/time.Second), 0).UTC()
+}
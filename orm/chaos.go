@@ -0,0 +1,61 @@
+package orm
+
+import (
+    "errors"
+    "fmt"
+    "math/rand"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// ErrInjectedFault is returned, possibly wrapped, by operations failed by the fault injector.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultRule configures the faults injected into the operations of one backend. Rates are
+// probabilities between 0 and 1, evaluated independently for every operation.
+type FaultRule struct {
+    LatencyRate float64       // Chance of delaying the operation by Latency.
+    Latency     time.Duration
+    ErrorRate   float64       // Chance of failing the operation with ErrInjectedFault.
+    DropRate    float64       // Chance of failing the operation as if the connection was reset.
+}
+
+// FaultInjector returns middleware that injects latency and errors into operations according to the
+// rule for their backend, to exercise retries and circuit breakers in staging. Backends without a
+// rule are left alone. Failed operations never reach the backend.
+func FaultInjector(rules map[string]FaultRule) Middleware {
+    var mu sync.Mutex
+    rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+    roll := func(rate float64) bool {
+        if rate <= 0 {
+            return false
+        }
+        mu.Lock()
+        defer mu.Unlock()
+        return rng.Float64() < rate
+    }
+
+    return func(next Handler) Handler {
+        return func(op *Operation) error {
+            rule, ok := rules[op.Backend]
+            if !ok {
+                return next(op)
+            }
+            if roll(rule.LatencyRate) {
+                select {
+                case <-time.After(rule.Latency):
+                case <-op.Context.Done():
+                    return op.Context.Err()
+                }
+            }
+            if roll(rule.DropRate) {
+                return fmt.Errorf("%w: %s %s: connection dropped: %w", ErrInjectedFault, op.Backend, op.Name, syscall.ECONNRESET)
+            }
+            if roll(rule.ErrorRate) {
+                return fmt.Errorf("%w: %s %s", ErrInjectedFault, op.Backend, op.Name)
+            }
+            return next(op)
+        }
+    }
+}
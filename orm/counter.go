@@ -0,0 +1,271 @@
+package orm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "persistence-layer/utils"
+    "strconv"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "gorm.io/gorm/schema"
+)
+
+// counterBatchField holds the ID of a flush batch among the pending deltas of a counter.
+const counterBatchField = "_batch"
+
+// CounterFlush records a batch of counter deltas applied to SQL, so a batch is never applied twice
+// when a flush is retried after a crash.
+type CounterFlush struct {
+    ID        uint64    `json:"id" gorm:"primaryKey"`
+    Counter   string    `json:"counter" gorm:"size:128;not null"`
+    Batch     string    `json:"batch" gorm:"size:36;not null;uniqueIndex"`
+    Rows      int       `json:"rows"`
+    AppliedAt time.Time `json:"applied_at"`
+}
+
+// Counter accumulates increments of an integer column, such as products.view_count, in Redis
+// and periodically adds them to SQL in one transaction, instead of updating the row on every hit:
+//
+//     views := orm.NewCounter(o, &models.Product{}, "view_count")
+//     views.Threshold = 1000
+//     go views.Run(ctx, time.Minute)
+//     _, err := views.Incr(productID, 1)
+//
+// Pending deltas live in the Redis hash "counter:<table>:<column>". A flush renames it to
+// "counter:<table>:<column>:flushing", applies it together with a CounterFlush record and then
+// deletes it. A flush interrupted at any point is completed by the next one, and the CounterFlush
+// record keeps a batch from being applied twice. Deltas are lost only if Redis loses them.
+type Counter struct {
+    orm       *ORM
+    model     interface{}
+    Column    string
+    Threshold int64 // Flush early once a row's pending delta reaches this; 0 only flushes on the interval.
+
+    key   string
+    flush chan struct{}
+}
+
+// NewCounter creates a counter of column, e.g. "view_count", of the table of model. The deltas are
+// flushed to the database of model's Policy; sharded models are not supported.
+func NewCounter(o *ORM, model interface{}, column string) *Counter {
+    c := &Counter{
+        orm:    o,
+        model:  model,
+        Column: column,
+        flush:  make(chan struct{}, 1),
+    }
+    table := schema.NamingStrategy{}.TableName(indirectType(model).Name())
+    if db, err := c.db(); err == nil {
+        stmt := &gorm.Statement{DB: db}
+        if stmt.Parse(model) == nil {
+            table = stmt.Schema.Table
+        }
+    }
+    c.key = fmt.Sprintf("counter:%s:%s", table, column)
+    return c
+}
+
+// Incr adds by, which may be negative, to the counter of the row with primary key key and returns
+// the row's pending delta, which is not yet in SQL.
+func (c *Counter) Incr(key interface{}, by int64) (int64, error) {
+    var pending int64
+    err := c.orm.invoke("Counter.Incr", BackendRedis, c.model, c.key, func() error {
+        if c.orm.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        var err error
+        if pending, err = c.orm.Redis.HIncrBy(c.key, fmt.Sprint(key), by); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Counter.Incr", "counter": c.key, "id": key})
+            return err
+        }
+        return nil
+    })
+    if err == nil && c.Threshold > 0 && (pending >= c.Threshold || pending <= -c.Threshold) {
+        select {
+        case c.flush <- struct{}{}:
+        default:
+        }
+    }
+    return pending, err
+}
+
+// Value returns the counter of a row: the column's value in SQL plus the deltas not yet flushed.
+func (c *Counter) Value(key interface{}) (int64, error) {
+    db, err := c.db()
+    if err != nil {
+        return 0, err
+    }
+    if c.orm.Redis == nil {
+        return 0, backendDisabled(BackendRedis)
+    }
+    s, err := c.schema(db)
+    if err != nil {
+        return 0, err
+    }
+    var stored int64
+    err = db.Table(s.Table).Select(c.Column).Where(clause.Eq{Column: clause.Column{Name: s.PrioritizedPrimaryField.DBName}, Value: key}).Scan(&stored).Error
+    if err != nil {
+        return 0, utils.HandleSQLError(err)
+    }
+    value := stored
+    for _, hash := range []string{c.key, c.key + ":flushing"} {
+        deltas, err := c.orm.Redis.HGetAll(hash)
+        if err != nil {
+            return 0, err
+        }
+        if delta, ok := deltas[fmt.Sprint(key)]; ok {
+            n, _ := strconv.ParseInt(delta, 10, 64)
+            value += n
+        }
+    }
+    return value, nil
+}
+
+// Run flushes every interval, and whenever a row reaches Threshold, until the context is
+// cancelled. It flushes once more before returning.
+func (c *Counter) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            _ = c.Flush()
+            return
+        case <-ticker.C:
+        case <-c.flush:
+        }
+        _ = c.Flush()
+    }
+}
+
+// Flush completes an interrupted flush, if any, and adds the pending deltas to SQL.
+func (c *Counter) Flush() error {
+    return c.orm.invoke("Counter.Flush", BackendSQL, c.model, c.key, func() error {
+        err := c.flushPending()
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Counter.Flush", "counter": c.key})
+        }
+        return err
+    })
+}
+
+func (c *Counter) flushPending() error {
+    if c.orm.Redis == nil {
+        return backendDisabled(BackendRedis)
+    }
+    db, err := c.db()
+    if err != nil {
+        return err
+    }
+    if err := migrating(db).AutoMigrate(&CounterFlush{}); err != nil {
+        return utils.HandleSQLError(err)
+    }
+    s, err := c.schema(db)
+    if err != nil {
+        return err
+    }
+
+    flushing := c.key + ":flushing"
+    if err := c.apply(db, s, flushing); err != nil {
+        return err
+    }
+    pending, err := c.orm.Redis.HGetAll(c.key)
+    if err != nil || len(pending) == 0 {
+        return err
+    }
+    // The batch ID travels with the deltas, so whoever completes the batch applies it under the
+    // same ID. Increments racing with the rename land in either hash and are never lost.
+    batch := map[string]string{counterBatchField: utils.NewUUIDv7()}
+    if err := c.orm.Redis.HSet(c.key, batch, 0); err != nil {
+        return err
+    }
+    if moved, err := c.orm.Redis.RenameNX(c.key, flushing); err != nil || !moved {
+        return err
+    }
+    return c.apply(db, s, flushing)
+}
+
+// apply adds the deltas of the hash at key to SQL, unless its batch was applied already, and
+// deletes the hash.
+func (c *Counter) apply(db *gorm.DB, s *schema.Schema, key string) error {
+    deltas, err := c.orm.Redis.HGetAll(key)
+    if err != nil || len(deltas) == 0 {
+        return err
+    }
+    batch := deltas[counterBatchField]
+    delete(deltas, counterBatchField)
+    if len(deltas) == 0 {
+        // A concurrent flush tagged the hash after another one had renamed it away.
+        return c.orm.Redis.Delete(key)
+    }
+    if batch == "" {
+        // Batches are tagged before they are renamed, so an untagged one was never applied.
+        batch = utils.NewUUIDv7()
+    }
+
+    pk := s.PrioritizedPrimaryField
+    err = db.Transaction(func(tx *gorm.DB) error {
+        var applied int64
+        if err := tx.Model(&CounterFlush{}).Where("batch = ?", batch).Count(&applied).Error; err != nil {
+            return err
+        }
+        if applied > 0 {
+            return nil
+        }
+        for id, delta := range deltas {
+            n, err := strconv.ParseInt(delta, 10, 64)
+            if err != nil || n == 0 {
+                continue
+            }
+            rowKey, err := parseKey(pk, id)
+            if err != nil {
+                return err
+            }
+            err = tx.Table(s.Table).
+                Where(clause.Eq{Column: clause.Column{Name: pk.DBName}, Value: rowKey}).
+                UpdateColumn(c.Column, gorm.Expr("? + ?", clause.Column{Name: c.Column}, n)).Error
+            if err != nil {
+                return err
+            }
+        }
+        return tx.Create(&CounterFlush{Counter: c.key, Batch: batch, Rows: len(deltas), AppliedAt: time.Now()}).Error
+    })
+    if err != nil {
+        return utils.HandleSQLError(err)
+    }
+    if err := c.orm.Redis.Delete(key); err != nil {
+        return err
+    }
+    utils.LogInfo("Counter flushed", map[string]interface{}{"counter": c.key, "batch": batch, "rows": len(deltas)})
+    return nil
+}
+
+func (c *Counter) schema(db *gorm.DB) (*schema.Schema, error) {
+    stmt := &gorm.Statement{DB: db}
+    if err := stmt.Parse(c.model); err != nil {
+        return nil, err
+    }
+    if stmt.Schema.PrioritizedPrimaryField == nil {
+        return nil, errors.New("counter: model " + modelName(c.model) + " has no primary key")
+    }
+    return stmt.Schema, nil
+}
+
+// db returns the gorm handle of the database holding the counted table.
+func (c *Counter) db() (*gorm.DB, error) {
+    o, err := c.orm.modelDB(c.model)
+    if err != nil {
+        return nil, err
+    }
+    if o.SQL == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    return db, nil
+}
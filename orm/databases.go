@@ -0,0 +1,95 @@
+package orm
+
+import (
+    "errors"
+    "fmt"
+    "persistence-layer/adapters"
+    "sort"
+)
+
+// DefaultDatabase names the SQL database passed to NewORM.
+const DefaultDatabase = "default"
+
+// ErrUnknownDatabase is returned, wrapped with the name, for databases never added with AddDatabase.
+var ErrUnknownDatabase = errors.New("unknown database")
+
+// AddDatabase registers an additional SQL database under name, e.g. "billing" or "legacy". Models
+// are routed to it by the Database of their Policy; other operations reach it through Database.
+// Call it at startup, before the ORM is shared.
+func (o *ORM) AddDatabase(name string, store adapters.SQLStore) {
+    if o.databases == nil {
+        o.databases = make(map[string]adapters.SQLStore)
+    }
+    o.databases[name] = store
+}
+
+// DatabaseNames returns the names of the SQL databases of the ORM, DefaultDatabase included, in
+// order.
+func (o *ORM) DatabaseNames() []string {
+    names := make([]string, 0, len(o.databases))
+    for name, store := range o.databases {
+        if !isNil(store) {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Database returns a copy of the ORM whose SQL operations, including WithTransaction, raw queries
+// and the workers built from it, run against the named database:
+//
+//     billing, err := o.Database("billing")
+//     err = billing.SearchSQL(qb, &invoices)
+//
+// A transaction spans a single database, so an ORM handed to a WithTransaction callback only
+// returns itself.
+func (o *ORM) Database(name string) (*ORM, error) {
+    if name == "" {
+        name = DefaultDatabase
+    }
+    if name == o.databaseName() {
+        return o, nil
+    }
+    if o.tx != nil {
+        return nil, fmt.Errorf("database %s is outside the transaction on %s", name, o.databaseName())
+    }
+    store, ok := o.databases[name]
+    if !ok || isNil(store) {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownDatabase, name)
+    }
+    c := o.clone()
+    c.SQL = store
+    if c.ctx != nil {
+        c.SQL = store.WithContext(c.ctx)
+    }
+    c.database = name
+    return c, nil
+}
+
+// ForModel returns the ORM for the SQL database of model's Policy, e.g. to run a worker or raw
+// query against the database holding the model's table. Sharded models have no single database;
+// see Shard and Shards.
+func (o *ORM) ForModel(model interface{}) (*ORM, error) {
+    policy := o.PolicyFor(model)
+    if len(policy.Shards) > 0 {
+        return nil, fmt.Errorf("%s is spread over %d shards", modelName(model), len(policy.Shards))
+    }
+    return o.Database(policy.Database)
+}
+
+// modelDB returns the ORM for the database of model's Policy, unless the ORM was pointed at a
+// database with Database or runs a transaction, which then wins.
+func (o *ORM) modelDB(model interface{}) (*ORM, error) {
+    if o.database != "" || o.tx != nil {
+        return o, nil
+    }
+    return o.ForModel(model)
+}
+
+func (o *ORM) databaseName() string {
+    if o.database == "" {
+        return DefaultDatabase
+    }
+    return o.database
+}
@@ -0,0 +1,104 @@
+package orm
+
+import (
+    "fmt"
+    "persistence-layer/types"
+    "persistence-layer/utils"
+    "reflect"
+)
+
+// InvalidEnumError is returned when a record is written with an enum field outside its allowed
+// values. It matches utils.ErrInvalidValue with errors.Is.
+type InvalidEnumError struct {
+    Model string
+    Field string
+    Value interface{}
+}
+
+func (e *InvalidEnumError) Error() string {
+    return fmt.Sprintf("invalid value %v for %s.%s", e.Value, e.Model, e.Field)
+}
+
+func (e *InvalidEnumError) Is(target error) bool {
+    return target == utils.ErrInvalidValue
+}
+
+var enumType = reflect.TypeOf((*types.Enum)(nil)).Elem()
+
+// validateEnums checks every types.Enum field of model, including those of embedded structs.
+func validateEnums(model interface{}) error {
+    v := reflect.ValueOf(model)
+    for v.Kind() == reflect.Ptr {
+        if v.IsNil() {
+            return nil
+        }
+        v = v.Elem()
+    }
+    if v.Kind() != reflect.Struct {
+        return nil
+    }
+    return validateStructEnums(modelName(model), v)
+}
+
+func validateStructEnums(model string, v reflect.Value) error {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        if !field.IsExported() {
+            continue
+        }
+        value := v.Field(i)
+        if field.Type.Implements(enumType) {
+            if field.Type.Kind() == reflect.Ptr && value.IsNil() {
+                continue
+            }
+            if !value.Interface().(types.Enum).Valid() {
+                return &InvalidEnumError{Model: model, Field: field.Name, Value: value.Interface()}
+            }
+            continue
+        }
+        if field.Anonymous && field.Type.Kind() == reflect.Struct {
+            if err := validateStructEnums(model, value); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// validateUpdateEnums checks the enum values a Mongo update document writes into collection: the
+// fields of records its operators set, e.g. {"$set": &post}, and enum values set field by field,
+// e.g. {"$set": {"status": models.PostStatus("bogus")}}.
+func validateUpdateEnums(collection string, update interface{}) error {
+    ops := reflect.ValueOf(update)
+    if ops.Kind() != reflect.Map || ops.Type().Key().Kind() != reflect.String {
+        return validateEnums(update)
+    }
+    iter := ops.MapRange()
+    for iter.Next() {
+        value := iter.Value().Elem()
+        if !value.IsValid() {
+            continue
+        }
+        if value.Kind() != reflect.Map || value.Type().Key().Kind() != reflect.String {
+            if err := validateEnums(value.Interface()); err != nil {
+                return err
+            }
+            continue
+        }
+        fields := value.MapRange()
+        for fields.Next() {
+            enum, ok := fields.Value().Interface().(types.Enum)
+            if !ok {
+                continue
+            }
+            if v := reflect.ValueOf(enum); v.Kind() == reflect.Ptr && v.IsNil() {
+                continue
+            }
+            if !enum.Valid() {
+                return &InvalidEnumError{Model: collection, Field: fields.Key().String(), Value: enum}
+            }
+        }
+    }
+    return nil
+}
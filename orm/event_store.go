@@ -0,0 +1,336 @@
+package orm
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "persistence-layer/types"
+    "persistence-layer/utils"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// defaultSnapshotEvery is how many events an EventStore appends to an aggregate between snapshots.
+const defaultSnapshotEvery = 100
+
+// Aggregate is a record kept as the sequence of events that changed it, such as an order or the
+// inventory of a product, rather than as its last state. Apply folds one event into the state; it
+// must not fail for events already stored, since every load replays them. The state is encoded to
+// JSON for snapshots, so the fields making it up must be exported.
+type Aggregate interface {
+    Apply(event *StoredEvent) error
+}
+
+// Event is a change to append to an aggregate, e.g. {Type: "ItemAdded", Data: ItemAdded{...}}.
+type Event struct {
+    Type string
+    Data interface{}
+}
+
+// StoredEvent is an event of an aggregate as stored in the aggregate_events table. Version numbers
+// the events of each aggregate from 1 without gaps.
+type StoredEvent struct {
+    ID            uint64     `json:"id" gorm:"primaryKey"`
+    AggregateType string     `json:"aggregate_type" gorm:"size:64;not null;uniqueIndex:idx_aggregate_events_version"` // Model name, e.g. "Order".
+    AggregateID   string     `json:"aggregate_id" gorm:"size:64;not null;uniqueIndex:idx_aggregate_events_version"`
+    Version       int        `json:"version" gorm:"not null;uniqueIndex:idx_aggregate_events_version"`
+    Type          string     `json:"type" gorm:"size:64;not null"`
+    Data          types.JSON `json:"data"`
+    Tenant        string     `json:"tenant" gorm:"size:64"`
+    CreatedAt     time.Time  `json:"created_at"`
+}
+
+// TableName stores events in aggregate_events.
+func (StoredEvent) TableName() string {
+    return "aggregate_events"
+}
+
+// Decode unmarshals the event's data into dest.
+func (e *StoredEvent) Decode(dest interface{}) error {
+    return e.Data.Decode(dest)
+}
+
+// AggregateSnapshot is the state of an aggregate after its first Version events, stored in the
+// aggregate_snapshots table so loading it only replays the events since.
+type AggregateSnapshot struct {
+    AggregateType string     `json:"aggregate_type" gorm:"primaryKey;size:64"`
+    AggregateID   string     `json:"aggregate_id" gorm:"primaryKey;size:64"`
+    Version       int        `json:"version" gorm:"not null"`
+    State         types.JSON `json:"state"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// VersionConflictError is returned by EventStore.Append when events were appended to the aggregate
+// since the caller loaded it. Reload the aggregate, check the command against its new state and
+// append again. It matches utils.ErrVersionConflict with errors.Is.
+type VersionConflictError struct {
+    AggregateType string
+    AggregateID   string
+    Expected      int
+    Actual        int
+}
+
+func (e *VersionConflictError) Error() string {
+    return fmt.Sprintf("%s %s is at version %d, not %d", e.AggregateType, e.AggregateID, e.Actual, e.Expected)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+    return target == utils.ErrVersionConflict
+}
+
+// EnableEventStore creates or migrates the aggregate_events and aggregate_snapshots tables used by
+// EventStore.
+func (o *ORM) EnableEventStore() error {
+    if o.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return errNoGormDB
+    }
+    return migrating(db).AutoMigrate(&StoredEvent{}, &AggregateSnapshot{})
+}
+
+// EventStore keeps aggregates as append-only event streams in SQL, for records that need their
+// full, replayable history instead of the last-write state of Create and Update. Appends use
+// optimistic concurrency: each names the version it was decided on, and fails with a
+// *VersionConflictError when another append got there first.
+//
+//     order := &Order{}
+//     version, err := store.Load(ctx, "42", order)
+//     ...
+//     _, err = store.Append(ctx, "42", order, version, orm.Event{Type: "ItemAdded", Data: item})
+type EventStore struct {
+    orm *ORM
+    // SnapshotEvery is how many events are appended to an aggregate between snapshots of its
+    // state; 0 disables snapshots. Defaults to 100.
+    SnapshotEvery int
+}
+
+// NewEventStore creates an event store over o. Call o.EnableEventStore at startup first.
+func NewEventStore(o *ORM) *EventStore {
+    return &EventStore{orm: o, SnapshotEvery: defaultSnapshotEvery}
+}
+
+// Load rehydrates the aggregate with id into aggregate, from its latest snapshot and the events
+// appended since, and returns its version. It returns utils.ErrNotFound when the aggregate has no
+// events.
+func (s *EventStore) Load(ctx context.Context, id string, aggregate Aggregate) (int, error) {
+    return s.load(ctx, id, aggregate, 0)
+}
+
+// LoadVersion rehydrates the aggregate with id as it was after its first version events, replaying
+// them from the start. It returns utils.ErrNotFound when the aggregate has fewer events.
+func (s *EventStore) LoadVersion(ctx context.Context, id string, aggregate Aggregate, version int) error {
+    if version < 1 {
+        return fmt.Errorf("%w: aggregate version %d", utils.ErrInvalidValue, version)
+    }
+    _, err := s.load(ctx, id, aggregate, version)
+    return err
+}
+
+// load replays the events of an aggregate up to version, or all of them when version is 0, in
+// which case it starts from the latest snapshot.
+func (s *EventStore) load(ctx context.Context, id string, aggregate Aggregate, version int) (int, error) {
+    current := 0
+    err := s.orm.WithContext(ctx).invokeKeyed("LoadAggregate", BackendSQL, aggregate, id, "", func() error {
+        db, err := s.db(ctx)
+        if err != nil {
+            return err
+        }
+        aggregateType := modelName(aggregate)
+        if version == 0 {
+            var snapshot AggregateSnapshot
+            err := db.Where("aggregate_type = ? AND aggregate_id = ?", aggregateType, id).Limit(1).Find(&snapshot).Error
+            if err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "LoadAggregate Snapshot", "aggregate": aggregateType, "id": id})
+                return utils.HandleSQLError(err)
+            }
+            if snapshot.Version > 0 {
+                if err := snapshot.State.Decode(aggregate); err != nil {
+                    return fmt.Errorf("decoding %s %s snapshot: %w", aggregateType, id, err)
+                }
+                current = snapshot.Version
+            }
+        }
+
+        q := db.Where("aggregate_type = ? AND aggregate_id = ? AND version > ?", aggregateType, id, current)
+        if version > 0 {
+            q = q.Where("version <= ?", version)
+        }
+        var events []StoredEvent
+        if err := q.Order("version").Find(&events).Error; err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "LoadAggregate", "aggregate": aggregateType, "id": id})
+            return utils.HandleSQLError(err)
+        }
+        for i := range events {
+            if err := aggregate.Apply(&events[i]); err != nil {
+                return fmt.Errorf("applying %s %s event %d (%s): %w", aggregateType, id, events[i].Version, events[i].Type, err)
+            }
+            current = events[i].Version
+        }
+        if current == 0 || current < version {
+            return utils.ErrNotFound
+        }
+        return nil
+    })
+    return current, err
+}
+
+// Events returns the events of the aggregate with id after version, oldest first, e.g. to replay
+// its history into a read model. aggregate only names the aggregate's type.
+func (s *EventStore) Events(ctx context.Context, id string, aggregate Aggregate, after int) ([]StoredEvent, error) {
+    var events []StoredEvent
+    err := s.orm.WithContext(ctx).invokeKeyed("AggregateEvents", BackendSQL, aggregate, id, "", func() error {
+        db, err := s.db(ctx)
+        if err != nil {
+            return err
+        }
+        err = db.Where("aggregate_type = ? AND aggregate_id = ? AND version > ?", modelName(aggregate), id, after).
+            Order("version").Find(&events).Error
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "AggregateEvents", "aggregate": modelName(aggregate), "id": id})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+    return events, err
+}
+
+// Append stores events as the next versions of the aggregate with id, which the caller loaded at
+// expected (0 for a new aggregate), and applies them to aggregate. It returns the aggregate's new
+// version, or a *VersionConflictError when the aggregate is no longer at expected. The events and
+// any snapshot they complete are written in one transaction, which joins the one of a
+// WithTransaction callback when called on its txORM.
+func (s *EventStore) Append(ctx context.Context, id string, aggregate Aggregate, expected int, events ...Event) (int, error) {
+    version := expected
+    err := s.orm.WithContext(ctx).invokeKeyed("AppendEvents", BackendSQL, aggregate, id, "", func() error {
+        if s.orm.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        if len(events) == 0 {
+            return nil
+        }
+        aggregateType := modelName(aggregate)
+        tenant := TenantFromContext(ctx)
+        return s.orm.WithTransaction(ctx, func(txORM *ORM) error {
+            db := txORM.SQL.GetDB()
+            if db == nil {
+                return errNoGormDB
+            }
+            actual, err := aggregateVersion(db, aggregateType, id)
+            if err != nil {
+                return err
+            }
+            if actual != expected {
+                return &VersionConflictError{AggregateType: aggregateType, AggregateID: id, Expected: expected, Actual: actual}
+            }
+
+            stored := make([]StoredEvent, len(events))
+            for i, event := range events {
+                data, err := types.NewJSON(event.Data)
+                if err != nil {
+                    return fmt.Errorf("encoding %s event: %w", event.Type, err)
+                }
+                stored[i] = StoredEvent{
+                    AggregateType: aggregateType,
+                    AggregateID:   id,
+                    Version:       expected + i + 1,
+                    Type:          event.Type,
+                    Data:          data,
+                    Tenant:        tenant,
+                }
+            }
+            if err := db.Create(&stored).Error; err != nil {
+                // A concurrent append took the same versions first and the unique index rejected
+                // ours; it has committed by now, so look outside this transaction, which the
+                // database may have aborted.
+                if outside, derr := s.db(ctx); derr == nil {
+                    if actual, verr := aggregateVersion(outside, aggregateType, id); verr == nil && actual != expected {
+                        return &VersionConflictError{AggregateType: aggregateType, AggregateID: id, Expected: expected, Actual: actual}
+                    }
+                }
+                utils.LogError(err, map[string]interface{}{"operation": "AppendEvents", "aggregate": aggregateType, "id": id})
+                return utils.HandleSQLError(err)
+            }
+            for i := range stored {
+                if err := aggregate.Apply(&stored[i]); err != nil {
+                    return fmt.Errorf("applying %s %s event %d (%s): %w", aggregateType, id, stored[i].Version, stored[i].Type, err)
+                }
+            }
+            version = expected + len(stored)
+
+            if s.SnapshotEvery > 0 && version/s.SnapshotEvery > expected/s.SnapshotEvery {
+                return saveSnapshot(db, aggregateType, id, version, aggregate)
+            }
+            return nil
+        })
+    })
+    if err != nil {
+        return expected, err
+    }
+    return version, nil
+}
+
+// Snapshot stores the state of aggregate, loaded at version, as the snapshot of the aggregate with
+// id, e.g. after changing SnapshotEvery or before replaying a long stream for the first time.
+func (s *EventStore) Snapshot(ctx context.Context, id string, aggregate Aggregate, version int) error {
+    return s.orm.WithContext(ctx).invokeKeyed("SnapshotAggregate", BackendSQL, aggregate, id, "", func() error {
+        db, err := s.db(ctx)
+        if err != nil {
+            return err
+        }
+        return saveSnapshot(db, modelName(aggregate), id, version, aggregate)
+    })
+}
+
+// db returns the gorm handle of the store's SQL database bound to ctx.
+func (s *EventStore) db(ctx context.Context) (*gorm.DB, error) {
+    if s.orm.SQL == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    db := s.orm.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    return db.WithContext(ctx), nil
+}
+
+// aggregateVersion returns the version of the latest event of an aggregate, 0 when it has none.
+func aggregateVersion(db *gorm.DB, aggregateType, id string) (int, error) {
+    var version int
+    err := db.Model(&StoredEvent{}).Where("aggregate_type = ? AND aggregate_id = ?", aggregateType, id).
+        Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+    if err != nil {
+        return 0, utils.HandleSQLError(err)
+    }
+    return version, nil
+}
+
+// saveSnapshot replaces the snapshot of an aggregate unless a later one is already stored.
+func saveSnapshot(db *gorm.DB, aggregateType, id string, version int, aggregate Aggregate) error {
+    state, err := json.Marshal(aggregate)
+    if err != nil {
+        return fmt.Errorf("encoding %s %s snapshot: %w", aggregateType, id, err)
+    }
+    snapshot := &AggregateSnapshot{AggregateType: aggregateType, AggregateID: id, Version: version, State: types.JSON(state)}
+    var existing AggregateSnapshot
+    err = db.Where("aggregate_type = ? AND aggregate_id = ?", aggregateType, id).Limit(1).Find(&existing).Error
+    if err != nil {
+        return utils.HandleSQLError(err)
+    }
+    if existing.Version >= version {
+        return nil
+    }
+    err = db.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "aggregate_type"}, {Name: "aggregate_id"}},
+        DoUpdates: clause.AssignmentColumns([]string{"version", "state", "updated_at"}),
+    }).Create(snapshot).Error
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "SnapshotAggregate", "aggregate": aggregateType, "id": id})
+        return utils.HandleSQLError(err)
+    }
+    return nil
+}
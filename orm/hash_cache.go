@@ -0,0 +1,152 @@
+package orm
+
+import (
+    "encoding/json"
+    "persistence-layer/utils"
+    "strconv"
+    "time"
+)
+
+// hashCachedField marks a hash written whole by SetCacheHash, telling it from one holding only the
+// fields SetCacheFields or IncrCacheField wrote after the entity expired, which is a miss.
+const hashCachedField = "@cached_at"
+
+// SetCacheHash caches model as a Redis hash at key with one field per JSON property, each holding
+// the property's JSON, replacing the hash and its TTL in one transaction. Unlike SetCache, single
+// fields can then be changed with SetCacheFields or IncrCacheField without rewriting the whole
+// entity:
+//
+//     err := o.SetCacheHash(orm.CacheKey(&product, product.ID), &product, time.Hour)
+//     views, err := o.IncrCacheField(orm.CacheKey(&product, product.ID), "view_count", 1)
+func (o *ORM) SetCacheHash(key string, model interface{}, ttl time.Duration) error {
+    return o.invoke("SetCacheHash", BackendRedis, model, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        data, err := json.Marshal(model)
+        if err != nil {
+            return err
+        }
+        var properties map[string]json.RawMessage
+        if err := json.Unmarshal(data, &properties); err != nil {
+            return err
+        }
+        fields := make(map[string]string, len(properties)+1)
+        for name, value := range properties {
+            fields[name] = string(value)
+        }
+        fields[hashCachedField] = strconv.FormatInt(time.Now().Unix(), 10)
+        if err := o.Redis.HReplace(key, fields, o.jitter(ttl)); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SetCacheHash", "key": key})
+            return err
+        }
+        utils.LogInfo("Cache hash set successfully", map[string]interface{}{"key": key})
+        return nil
+    })
+}
+
+// GetCacheHash decodes the hash cached at key into dest, reporting false on a cache miss: no hash,
+// or one not written by SetCacheHash, which is removed.
+func (o *ORM) GetCacheHash(key string, dest interface{}) (bool, error) {
+    var found bool
+    err := o.invoke("GetCacheHash", BackendRedis, dest, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        fields, err := o.Redis.HGetAll(key)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "GetCacheHash", "key": key})
+            return err
+        }
+        if len(fields) == 0 {
+            return nil
+        }
+        if _, ok := fields[hashCachedField]; !ok {
+            o.dropPartialHash(key)
+            return nil
+        }
+        delete(fields, hashCachedField)
+        properties := make(map[string]json.RawMessage, len(fields))
+        for name, value := range fields {
+            properties[name] = json.RawMessage(value)
+        }
+        data, err := json.Marshal(properties)
+        if err != nil {
+            return err
+        }
+        if err := json.Unmarshal(data, dest); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "GetCacheHash", "key": key})
+            return err
+        }
+        found = true
+        return nil
+    })
+    return found, err
+}
+
+// SetCacheFields replaces single fields of the hash cached at key, named by JSON property, leaving
+// its other fields and its TTL as they are. On a cache miss nothing stays cached.
+func (o *ORM) SetCacheFields(key string, fields map[string]interface{}) error {
+    return o.invoke("SetCacheFields", BackendRedis, nil, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        encoded := make(map[string]string, len(fields))
+        for name, value := range fields {
+            data, err := json.Marshal(value)
+            if err != nil {
+                return err
+            }
+            encoded[name] = string(data)
+        }
+        if err := o.Redis.HSet(key, encoded, 0); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SetCacheFields", "key": key})
+            return err
+        }
+        return o.checkWholeHash(key)
+    })
+}
+
+// IncrCacheField atomically adds by to an integer field of the hash cached at key, such as a view
+// counter, and returns the new value. On a cache miss nothing stays cached and it returns
+// utils.ErrNotFound, so cache the entity with SetCacheHash first.
+func (o *ORM) IncrCacheField(key, field string, by int64) (int64, error) {
+    var value int64
+    err := o.invoke("IncrCacheField", BackendRedis, nil, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        var err error
+        if value, err = o.Redis.HIncrBy(key, field, by); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "IncrCacheField", "key": key, "field": field})
+            return err
+        }
+        if value != by {
+            return nil // The field existed, so did the hash.
+        }
+        return o.checkWholeHash(key)
+    })
+    return value, err
+}
+
+// checkWholeHash removes the hash at key when it was not written by SetCacheHash, e.g. created by
+// a partial write after the entity expired, which would otherwise linger without a TTL, and then
+// returns utils.ErrNotFound.
+func (o *ORM) checkWholeHash(key string) error {
+    fields, err := o.Redis.HGetAll(key)
+    if err != nil {
+        return err
+    }
+    if _, ok := fields[hashCachedField]; ok {
+        return nil
+    }
+    o.dropPartialHash(key)
+    return utils.ErrNotFound
+}
+
+// dropPartialHash removes a hash not written by SetCacheHash.
+func (o *ORM) dropPartialHash(key string) {
+    if err := o.Redis.Delete(key); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "GetCacheHash", "key": key})
+    }
+}
@@ -0,0 +1,91 @@
+package orm
+
+import (
+    "reflect"
+    "time"
+)
+
+// Operations that may be hedged. They only read, so running them twice is harmless.
+var hedgeable = map[string]bool{
+    "Read":      true,
+    "SearchSQL": true,
+    "MongoRead": true,
+    "Search":    true,
+    "Aggregate": true,
+    "KnnSearch": true,
+}
+
+// SetHedge hedges the read operation named operation, e.g. "Read" or "Search": when a call hasn't
+// answered after after, a second identical request is sent and the first successful response is
+// used. The second request goes through the backend's client again, which picks another pooled
+// connection, or another node of an Elasticsearch cluster, so one slow connection or node no longer
+// sets the tail latency. An after of 0 disables hedging. Operations that write are ignored.
+//
+//     o.SetHedge("Read", 50*time.Millisecond)
+func (o *ORM) SetHedge(operation string, after time.Duration) {
+    if !hedgeable[operation] {
+        return
+    }
+    hedges := make(map[string]time.Duration, len(o.hedges)+1)
+    for name, d := range o.hedges {
+        hedges[name] = d
+    }
+    if after > 0 {
+        hedges[operation] = after
+    } else {
+        delete(hedges, operation)
+    }
+    o.hedges = hedges
+}
+
+// hedged runs attempt, which decodes into the destination it is given, hedging it as configured for
+// operation. Every attempt decodes into its own zero value of dest's type, so the attempts share no
+// memory, and the winner is copied into dest. An attempt failing before the hedge was sent fails
+// the call; it is not retried.
+func (o *ORM) hedged(operation string, dest interface{}, attempt func(dest interface{}) error) error {
+    after := o.hedges[operation]
+    rv := reflect.ValueOf(dest)
+    if after <= 0 || rv.Kind() != reflect.Ptr || rv.IsNil() {
+        return attempt(dest)
+    }
+
+    type result struct {
+        value reflect.Value
+        err   error
+    }
+    results := make(chan result, 2) // Buffered so the losing attempt never blocks.
+    run := func() {
+        value := reflect.New(rv.Elem().Type())
+        results <- result{value: value, err: attempt(value.Interface())}
+    }
+    go run()
+
+    timer := time.NewTimer(after)
+    defer timer.Stop()
+    ctx := o.opContext()
+    hedgedSent := false
+    var firstErr error
+    for pending := 1; pending > 0; {
+        select {
+        case <-timer.C:
+            hedgedSent = true
+            pending++
+            go run()
+        case r := <-results:
+            pending--
+            if r.err == nil {
+                rv.Elem().Set(r.value.Elem())
+                return nil
+            }
+            if firstErr == nil {
+                firstErr = r.err
+            }
+            if !hedgedSent {
+                return r.err
+            }
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+    return firstErr
+}
@@ -0,0 +1,200 @@
+package orm
+
+import (
+    "math/rand"
+    "sort"
+    "sync"
+    "time"
+)
+
+const (
+    defaultHotKeyCapacity = 1000
+    defaultHotKeyWindow   = 10 * time.Minute
+)
+
+// HotKey is a frequently read cache key with its estimated number of reads.
+type HotKey struct {
+    Model string  `json:"model"`
+    Key   string  `json:"key"`
+    Reads float64 `json:"reads"` // Estimated from the sample, with older windows decayed.
+}
+
+// CacheStats counts the cached reads of one model. A stampede is a miss while another read of the
+// same key is already loading it from the source, i.e. a duplicate load a single-flight would save.
+type CacheStats struct {
+    Hits      int64   `json:"hits"`
+    Misses    int64   `json:"misses"`
+    Stampedes int64   `json:"stampedes"`
+    HitRate   float64 `json:"hit_rate"`
+}
+
+// HotKeyTracker records the cached reads of ReadByKey, set as ORM.HotKeys: exact hit, miss and
+// stampede counts per model, and the read frequency of a sample of keys, to find the entities that
+// deserve longer TTLs or an in-process cache. Frequencies are estimated with the space-saving
+// algorithm, keeping at most Capacity keys, and halved every Window so that keys that cooled down
+// drop out. Counts are kept in memory and restart with the process.
+type HotKeyTracker struct {
+    SampleRate float64       // Share of reads counted towards key frequencies, e.g. 0.01; 1 counts all.
+    Capacity   int           // Keys tracked; defaults to 1000.
+    Window     time.Duration // Defaults to 10 minutes.
+
+    mu      sync.Mutex
+    keys    map[hotKeyID]float64
+    models  map[string]*CacheStats
+    loading map[hotKeyID]int // Misses still loading their key from the source.
+    decayed time.Time
+}
+
+type hotKeyID struct {
+    model, key string
+}
+
+// NewHotKeyTracker creates a tracker sampling sampleRate of the reads.
+func NewHotKeyTracker(sampleRate float64) *HotKeyTracker {
+    return &HotKeyTracker{
+        SampleRate: sampleRate,
+        Capacity:   defaultHotKeyCapacity,
+        Window:     defaultHotKeyWindow,
+        keys:       make(map[hotKeyID]float64),
+        models:     make(map[string]*CacheStats),
+        loading:    make(map[hotKeyID]int),
+        decayed:    time.Now(),
+    }
+}
+
+// hit records a read of key answered by the cache.
+func (t *HotKeyTracker) hit(model, key string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.stats(model).Hits++
+    t.sample(hotKeyID{model, key})
+}
+
+// miss records a read of key that missed the cache and returns the function to call once the
+// record was loaded from its source.
+func (t *HotKeyTracker) miss(model, key string) func() {
+    id := hotKeyID{model, key}
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    stats := t.stats(model)
+    stats.Misses++
+    if t.loading[id] > 0 {
+        stats.Stampedes++
+    }
+    t.loading[id]++
+    t.sample(id)
+    return func() {
+        t.mu.Lock()
+        defer t.mu.Unlock()
+        if t.loading[id]--; t.loading[id] <= 0 {
+            delete(t.loading, id)
+        }
+    }
+}
+
+func (t *HotKeyTracker) stats(model string) *CacheStats {
+    stats, ok := t.models[model]
+    if !ok {
+        stats = &CacheStats{}
+        t.models[model] = stats
+    }
+    return stats
+}
+
+// sample counts a read of id towards its frequency with probability SampleRate. When Capacity keys
+// are tracked already, the least read one makes room and passes its count on, so a new key that
+// turns out hot catches up quickly while the estimates never undercount.
+func (t *HotKeyTracker) sample(id hotKeyID) {
+    if t.SampleRate <= 0 || t.SampleRate < 1 && rand.Float64() >= t.SampleRate {
+        return
+    }
+    t.decay()
+    weight := 1.0
+    if t.SampleRate < 1 {
+        weight = 1 / t.SampleRate
+    }
+    if count, ok := t.keys[id]; ok {
+        t.keys[id] = count + weight
+        return
+    }
+    capacity := t.Capacity
+    if capacity <= 0 {
+        capacity = defaultHotKeyCapacity
+    }
+    var floor float64
+    if len(t.keys) >= capacity {
+        var coldest hotKeyID
+        floor = -1
+        for other, count := range t.keys {
+            if floor < 0 || count < floor {
+                coldest, floor = other, count
+            }
+        }
+        delete(t.keys, coldest)
+    }
+    t.keys[id] = floor + weight
+}
+
+// decay halves the key frequencies once per elapsed window.
+func (t *HotKeyTracker) decay() {
+    window := t.Window
+    if window <= 0 {
+        window = defaultHotKeyWindow
+    }
+    for time.Since(t.decayed) >= window {
+        for id, count := range t.keys {
+            if count /= 2; count < 1 {
+                delete(t.keys, id)
+            } else {
+                t.keys[id] = count
+            }
+        }
+        t.decayed = t.decayed.Add(window)
+        if len(t.keys) == 0 {
+            t.decayed = time.Now()
+        }
+    }
+}
+
+// Top returns the n most read keys, of model or of every model when it is empty, hottest first.
+func (t *HotKeyTracker) Top(model string, n int) []HotKey {
+    t.mu.Lock()
+    t.decay()
+    top := make([]HotKey, 0, len(t.keys))
+    for id, reads := range t.keys {
+        if model == "" || id.model == model {
+            top = append(top, HotKey{Model: id.model, Key: id.key, Reads: reads})
+        }
+    }
+    t.mu.Unlock()
+    sort.Slice(top, func(i, j int) bool {
+        if top[i].Reads != top[j].Reads {
+            return top[i].Reads > top[j].Reads
+        }
+        return top[i].Key < top[j].Key
+    })
+    if n > 0 && len(top) > n {
+        top = top[:n]
+    }
+    return top
+}
+
+// Stats returns the cached read counts by model name.
+func (t *HotKeyTracker) Stats() map[string]CacheStats {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    out := make(map[string]CacheStats, len(t.models))
+    for model, stats := range t.models {
+        s := *stats
+        if reads := s.Hits + s.Misses; reads > 0 {
+            s.HitRate = float64(s.Hits) / float64(reads)
+        }
+        out[model] = s
+    }
+    return out
+}
+
+// Snapshot returns the stats by model and the 20 hottest keys, for publishing as an expvar.
+func (t *HotKeyTracker) Snapshot() map[string]interface{} {
+    return map[string]interface{}{"models": t.Stats(), "hot_keys": t.Top("", 20)}
+}
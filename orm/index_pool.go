@@ -0,0 +1,303 @@
+package orm
+
+import (
+    "context"
+    "errors"
+    "hash/fnv"
+    "persistence-layer/utils"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Overflow policies of an IndexPool whose queue is full.
+const (
+    // OverflowBlock makes writes wait for room in the queue, slowing callers down to the pace of
+    // Elasticsearch.
+    OverflowBlock = "block"
+    // OverflowOutbox records the change in the outbox for the OutboxRelay, which must be running,
+    // and lets the write return at once.
+    OverflowOutbox = "outbox"
+)
+
+const (
+    defaultIndexWorkers   = 4
+    defaultIndexQueueSize = 1000
+)
+
+// IndexPoolStats describes the queue of an IndexPool and counts the changes it handled.
+type IndexPoolStats struct {
+    Workers    int     `json:"workers"`
+    Queued     int     `json:"queued"`
+    Capacity   int     `json:"capacity"`
+    LagMs      float64 `json:"lag_ms"` // How long the latest change taken by a worker waited in the queue.
+    Applied    int64   `json:"applied"`
+    Failed     int64   `json:"failed"`
+    Blocked    int64   `json:"blocked"`    // Writes that waited for room in the queue.
+    Overflowed int64   `json:"overflowed"` // Changes recorded in the outbox instead.
+}
+
+// IndexPool applies the search index changes of writes in the background, set as ORM.IndexPool, so
+// Create, Update and Delete return once the record is stored instead of waiting for Elasticsearch.
+// Changes queue in bounded buffers, one per worker; the changes of a document always go to the
+// same worker, which applies them in the order they were made. When its buffer is full, Overflow
+// decides between backpressure and the outbox. Policies with AsyncIndex keep indexing through the
+// outbox, and webhook deliveries are already posted by the WebhookDispatcher.
+//
+// With OverflowOutbox a full buffer moves the changes it holds to the outbox together with the new
+// one, and the later changes of that worker follow them there until the relay applied them, so the
+// relay and the worker never apply the changes of a document out of order.
+//
+// The index is updated shortly after the write rather than with it, so a search right after a
+// write may not find it yet. Changes still queued when the process dies are lost, as are those of
+// synchronous indexing that failed; a reindex repairs both.
+type IndexPool struct {
+    Overflow string // OverflowBlock (default) or OverflowOutbox.
+
+    orm      *ORM
+    shards   []*indexShard
+    capacity int // Changes each shard holds.
+
+    lag        int64 // Nanoseconds.
+    applied    int64
+    failed     int64
+    blocked    int64
+    overflowed int64
+}
+
+// indexShard is the queue of one worker of an IndexPool.
+type indexShard struct {
+    mu       sync.Mutex
+    cond     *sync.Cond // Signalled when jobs, busy, spilling or stopped change.
+    jobs     []indexJob
+    busy     bool      // The worker is applying a job it took from jobs.
+    spilling bool      // A submit is moving the jobs to the outbox.
+    stopping bool      // The pool was stopped; the worker exits once jobs is empty.
+    done     bool      // The worker exited; changes are applied by submit.
+    spilled  uint64    // ID of the shard's latest change moved to the outbox, until it was relayed.
+    checked  time.Time // When the relay's progress on spilled was last looked up.
+}
+
+// indexJob is a queued index change.
+type indexJob struct {
+    event  *OutboxEvent
+    queued time.Time
+}
+
+// spillRecheck is how often a shard whose changes go to the outbox looks up whether the relay
+// caught up, to take changes in its queue again.
+const spillRecheck = time.Second
+
+// NewIndexPool creates a pool applying the index changes of o with workers goroutines and queues of
+// queueSize changes in total; 0 uses 4 workers and 1000 changes. Start it with Run.
+func NewIndexPool(o *ORM, workers, queueSize int) *IndexPool {
+    if workers <= 0 {
+        workers = defaultIndexWorkers
+    }
+    if queueSize <= 0 {
+        queueSize = defaultIndexQueueSize
+    }
+    p := &IndexPool{
+        Overflow: OverflowBlock,
+        orm:      o,
+        capacity: (queueSize + workers - 1) / workers,
+    }
+    for i := 0; i < workers; i++ {
+        shard := &indexShard{}
+        shard.cond = sync.NewCond(&shard.mu)
+        p.shards = append(p.shards, shard)
+    }
+    return p
+}
+
+// Run applies queued changes until the context is cancelled, then applies those still queued.
+// Writes made after it returned index synchronously again.
+func (p *IndexPool) Run(ctx context.Context) {
+    var wg sync.WaitGroup
+    for _, shard := range p.shards {
+        wg.Add(1)
+        go func(shard *indexShard) {
+            defer wg.Done()
+            p.work(shard)
+        }(shard)
+    }
+    <-ctx.Done()
+    for _, shard := range p.shards {
+        shard.mu.Lock()
+        shard.stopping = true
+        shard.cond.Broadcast()
+        shard.mu.Unlock()
+    }
+    wg.Wait()
+}
+
+// work applies the jobs of shard one at a time, in the order they were queued, until the pool is
+// stopped and the queue is empty.
+func (p *IndexPool) work(shard *indexShard) {
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+    for {
+        for len(shard.jobs) == 0 || shard.spilling {
+            if shard.stopping && len(shard.jobs) == 0 {
+                shard.done = true
+                return
+            }
+            shard.cond.Wait()
+        }
+        job := shard.jobs[0]
+        shard.jobs[0] = indexJob{}
+        shard.jobs = shard.jobs[1:]
+        shard.busy = true
+        shard.mu.Unlock()
+        p.apply(job)
+        shard.mu.Lock()
+        shard.busy = false
+        shard.cond.Broadcast()
+    }
+}
+
+// shard returns the shard of the document an event changes.
+func (p *IndexPool) shard(event *OutboxEvent) *indexShard {
+    h := fnv.New32a()
+    h.Write([]byte(event.Index))
+    h.Write([]byte{0})
+    h.Write([]byte(event.AggregateKey))
+    return p.shards[h.Sum32()%uint32(len(p.shards))]
+}
+
+// submit queues the index change of a write, snapshotting the search document so later changes to
+// model don't leak into it.
+func (p *IndexPool) submit(operation string, policy Policy, key interface{}, model interface{}) error {
+    event, err := newOutboxEvent(operation, policy, key, model)
+    if err != nil {
+        return err
+    }
+    shard := p.shard(event)
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+    for shard.spilling {
+        shard.cond.Wait()
+    }
+    if shard.done {
+        _, err := p.orm.applyOutboxEvent(event)
+        return err
+    }
+    if shard.spilled != 0 && !p.relayed(shard) {
+        // Earlier changes of the shard are still in the outbox; this one must follow them.
+        err := p.spill(shard, event)
+        if err == nil {
+            return nil
+        }
+        utils.LogError(err, map[string]interface{}{"operation": "Index Overflow", "index": event.Index, "id": event.AggregateKey})
+    }
+    job := indexJob{event: event, queued: time.Now()}
+    if len(shard.jobs) < p.capacity {
+        shard.jobs = append(shard.jobs, job)
+        shard.cond.Broadcast()
+        return nil
+    }
+    if p.Overflow == OverflowOutbox {
+        err := p.spill(shard, event)
+        if err == nil {
+            return nil
+        }
+        // Without the outbox the change waits for room like with OverflowBlock.
+        utils.LogError(err, map[string]interface{}{"operation": "Index Overflow", "index": event.Index, "id": event.AggregateKey})
+    }
+    atomic.AddInt64(&p.blocked, 1)
+    for len(shard.jobs) >= p.capacity && !shard.done {
+        shard.cond.Wait()
+    }
+    if shard.done {
+        _, err := p.orm.applyOutboxEvent(event)
+        return err
+    }
+    shard.jobs = append(shard.jobs, job)
+    shard.cond.Broadcast()
+    return nil
+}
+
+// spill records the changes queued in shard, then event, in the outbox in one transaction, once
+// the worker finished the change it is applying, so the relay applies them in order after it. The
+// shard's mutex is held; the queue is left as it was when the outbox can't be written.
+func (p *IndexPool) spill(shard *indexShard, event *OutboxEvent) error {
+    shard.spilling = true
+    defer func() {
+        shard.spilling = false
+        shard.cond.Broadcast()
+    }()
+    for shard.busy {
+        shard.cond.Wait()
+    }
+    events := make([]*OutboxEvent, 0, len(shard.jobs)+1)
+    for _, job := range shard.jobs {
+        events = append(events, job.event)
+    }
+    events = append(events, event)
+    err := p.orm.WithTransaction(context.Background(), func(txORM *ORM) error {
+        for _, event := range events {
+            if err := txORM.SQL.Create(event); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    shard.jobs = nil
+    shard.spilled, shard.checked = event.ID, time.Now()
+    atomic.AddInt64(&p.overflowed, int64(len(events)))
+    return nil
+}
+
+// relayed reports whether the relay applied the latest change shard moved to the outbox, looking
+// it up at most every spillRecheck. The shard's mutex is held.
+func (p *IndexPool) relayed(shard *indexShard) bool {
+    if time.Since(shard.checked) < spillRecheck {
+        return false
+    }
+    shard.checked = time.Now()
+    var event OutboxEvent
+    err := p.orm.SQL.ReadByKey(shard.spilled, &event)
+    if err != nil && !errors.Is(err, utils.ErrNotFound) {
+        utils.LogError(err, map[string]interface{}{"operation": "Index Overflow", "event": shard.spilled})
+        return false
+    }
+    if err == nil && event.ProcessedAt == nil {
+        return false
+    }
+    shard.spilled = 0
+    return true
+}
+
+// apply performs a queued change against Elasticsearch.
+func (p *IndexPool) apply(job indexJob) {
+    atomic.StoreInt64(&p.lag, int64(time.Since(job.queued)))
+    if _, err := p.orm.applyOutboxEvent(job.event); err != nil {
+        atomic.AddInt64(&p.failed, 1)
+        utils.LogError(err, map[string]interface{}{"operation": job.event.Operation + " Index", "index": job.event.Index, "id": job.event.AggregateKey})
+        return
+    }
+    atomic.AddInt64(&p.applied, 1)
+}
+
+// Stats returns the pool's queue depth, lag and counts.
+func (p *IndexPool) Stats() IndexPoolStats {
+    queued := 0
+    for _, shard := range p.shards {
+        shard.mu.Lock()
+        queued += len(shard.jobs)
+        shard.mu.Unlock()
+    }
+    return IndexPoolStats{
+        Workers:    len(p.shards),
+        Queued:     queued,
+        Capacity:   p.capacity * len(p.shards),
+        LagMs:      float64(atomic.LoadInt64(&p.lag)) / float64(time.Millisecond),
+        Applied:    atomic.LoadInt64(&p.applied),
+        Failed:     atomic.LoadInt64(&p.failed),
+        Blocked:    atomic.LoadInt64(&p.blocked),
+        Overflowed: atomic.LoadInt64(&p.overflowed),
+    }
+}
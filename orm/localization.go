@@ -0,0 +1,188 @@
+package orm
+
+import (
+    "fmt"
+    "persistence-layer/types"
+    "persistence-layer/utils"
+    "reflect"
+
+    "gorm.io/gorm/clause"
+)
+
+// LocalePolicy controls how ReadLocalized resolves a requested locale. Fallbacks lists the locales
+// to try after a given one, e.g. "pt-BR": {"pt-PT"}; base languages and Default are always tried
+// last, see types.LocaleChain.
+type LocalePolicy struct {
+    Default   string
+    Fallbacks map[string][]string
+}
+
+// DefaultLocalePolicy falls back to English.
+var DefaultLocalePolicy = LocalePolicy{Default: "en"}
+
+// Translation is one per-locale value of a translatable field stored in the translations table.
+type Translation struct {
+    ID          uint64 `json:"id" gorm:"primaryKey"`
+    RecordTable string `json:"record_table" gorm:"size:64;not null;uniqueIndex:idx_translations_record,priority:1"`
+    RecordKey   string `json:"record_key" gorm:"size:64;not null;uniqueIndex:idx_translations_record,priority:2"`
+    Field       string `json:"field" gorm:"size:64;not null;uniqueIndex:idx_translations_record,priority:3"`
+    Locale      string `json:"locale" gorm:"size:16;not null;uniqueIndex:idx_translations_record,priority:4"`
+    Value       string `json:"value" gorm:"type:text"`
+}
+
+// EnableTranslations creates or migrates the translations table used by fields tagged
+// `localized:"table"`. The table lives in DefaultDatabase, whichever database holds the records.
+func (o *ORM) EnableTranslations() error {
+    o, err := o.Database(DefaultDatabase)
+    if err != nil {
+        return err
+    }
+    if o.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return errNoGormDB
+    }
+    return migrating(db).AutoMigrate(&Translation{})
+}
+
+// SetTranslation stores value as the locale's translation of a field (by column name, e.g. "name")
+// of the record with key, replacing any previous translation.
+func (o *ORM) SetTranslation(key interface{}, model interface{}, field, locale, value string) error {
+    return o.invoke("SetTranslation", BackendSQL, model, "", func() error {
+        o, err := o.Database(DefaultDatabase)
+        if err != nil {
+            return err
+        }
+        if o.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        db := o.SQL.GetDB()
+        if db == nil {
+            return errNoGormDB
+        }
+        table, err := tableName(db, model)
+        if err != nil {
+            return err
+        }
+        err = db.Clauses(clause.OnConflict{
+            Columns:   []clause.Column{{Name: "record_table"}, {Name: "record_key"}, {Name: "field"}, {Name: "locale"}},
+            DoUpdates: clause.AssignmentColumns([]string{"value"}),
+        }).Create(&Translation{RecordTable: table, RecordKey: fmt.Sprint(key), Field: field, Locale: locale, Value: value}).Error
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SetTranslation", "id": key, "field": field, "locale": locale})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+}
+
+// ReadLocalized reads the record with key into model and replaces its translatable string fields
+// with their values for locale, falling back along o.Locales. Fields are marked with a
+// `localized` tag naming where their translations live:
+//
+//     type Product struct {
+//         ...
+//         Name        string              `json:"name" localized:"NameI18n"`
+//         NameI18n    types.LocalizedText `json:"name_i18n"`
+//         Description string              `json:"description" localized:"table"`
+//     }
+//
+// "table" reads the translations table (see EnableTranslations and SetTranslation); any other
+// value names a types.LocalizedText field of the same model. A field without a translation in
+// any locale of the chain keeps its stored value.
+func (o *ORM) ReadLocalized(key interface{}, model interface{}, locale string) error {
+    return o.invoke("ReadLocalized", BackendSQL, model, "", func() error {
+        err := o.readRouted(o.PolicyFor(model), key, model, func(db *ORM) error {
+            if db.SQL == nil {
+                return backendDisabled(BackendSQL)
+            }
+            if err := db.SQL.ReadByKey(key, model); err != nil {
+                return utils.HandleSQLError(err)
+            }
+            return nil
+        })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "ReadLocalized", "id": key})
+            return err
+        }
+        v := reflect.ValueOf(model)
+        for v.Kind() == reflect.Ptr {
+            v = v.Elem()
+        }
+        if v.Kind() != reflect.Struct {
+            return nil
+        }
+        chain := types.LocaleChain(locale, o.Locales.Fallbacks, o.Locales.Default)
+
+        var fromTable []reflect.StructField
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+            field := t.Field(i)
+            source, ok := field.Tag.Lookup("localized")
+            if !ok || field.Type.Kind() != reflect.String {
+                continue
+            }
+            if source == "table" {
+                fromTable = append(fromTable, field)
+                continue
+            }
+            sourceField := v.FieldByName(source)
+            if !sourceField.IsValid() || sourceField.Type() != localizedTextType {
+                return fmt.Errorf("localized field %s.%s: %s is not a types.LocalizedText", t.Name(), field.Name, source)
+            }
+            texts := sourceField.Interface().(types.LocalizedText)
+            if value, ok := texts.Get(chain...); ok {
+                v.FieldByIndex(field.Index).SetString(value)
+            }
+        }
+        if len(fromTable) == 0 {
+            return nil
+        }
+        return o.applyTranslations(key, model, v, fromTable, chain)
+    })
+}
+
+// applyTranslations sets fields from the translations table, preferring earlier locales of chain.
+func (o *ORM) applyTranslations(key interface{}, model interface{}, v reflect.Value, fields []reflect.StructField, chain []string) error {
+    o, err := o.Database(DefaultDatabase)
+    if err != nil {
+        return err
+    }
+    if o.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return errNoGormDB
+    }
+    table, err := tableName(db, model)
+    if err != nil {
+        return err
+    }
+    columns := make([]string, len(fields))
+    for i, field := range fields {
+        columns[i] = db.NamingStrategy.ColumnName(table, field.Name)
+    }
+    var translations []Translation
+    err = db.Where("record_table = ? AND record_key = ? AND field IN ? AND locale IN ?", table, fmt.Sprint(key), columns, chain).
+        Find(&translations).Error
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "ReadLocalized Translations", "id": key})
+        return utils.HandleSQLError(err)
+    }
+    texts := make(map[string]types.LocalizedText, len(columns))
+    for _, tr := range translations {
+        if texts[tr.Field] == nil {
+            texts[tr.Field] = types.LocalizedText{}
+        }
+        texts[tr.Field][tr.Locale] = tr.Value
+    }
+    for i, field := range fields {
+        if value, ok := texts[columns[i]].Get(chain...); ok {
+            v.FieldByIndex(field.Index).SetString(value)
+        }
+    }
+    return nil
+}
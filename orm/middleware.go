@@ -0,0 +1,101 @@
+package orm
+
+import (
+    "context"
+    "reflect"
+    "time"
+)
+
+// Operation describes a single ORM call as seen by middleware.
+type Operation struct {
+    Name    string      // ORM method, e.g. "Create", "Search" or "GetCache"; ReadByKey and ReadWith report as "Read", DeleteByKey as "Delete".
+    Backend string      // BackendSQL, BackendMongo, BackendRedis or BackendElasticsearch.
+    Model   string      // Go type name of the model or result, e.g. "User"; empty when there is none.
+    Target  string      // Collection, index or cache key the call addresses; empty for SQL.
+    Value   interface{} // Model, result or cache value passed to the call; may be nil.
+    Key     interface{} // Primary key passed to keyed calls such as Read and Delete; nil otherwise.
+    Context context.Context
+    Started time.Time
+
+    tx *SQLTransaction // The enclosing WithTransaction's, if any.
+}
+
+// Elapsed returns how long the operation has been running. Called after next returns, it is the
+// operation's duration including any inner middleware.
+func (op *Operation) Elapsed() time.Duration {
+    return time.Since(op.Started)
+}
+
+// AfterTransaction calls fn with whether the writes of the operation were kept: right away for
+// operations outside WithTransaction, which commit on their own, and otherwise once the enclosing
+// transaction commits or rolls back. Call it after next returns.
+func (op *Operation) AfterTransaction(fn func(committed bool)) {
+    if op.tx == nil {
+        fn(true)
+        return
+    }
+    op.tx.afterTransaction(fn)
+}
+
+// Handler runs an ORM operation.
+type Handler func(op *Operation) error
+
+// Middleware wraps every ORM operation, in the style of HTTP middleware. It may inspect the
+// operation, call next (or not) and inspect or replace the returned error:
+//
+//     o.Use(func(next orm.Handler) orm.Handler {
+//         return func(op *orm.Operation) error {
+//             err := next(op)
+//             metrics.Observe(op.Backend, op.Name, op.Model, op.Elapsed(), err)
+//             return err
+//         }
+//     })
+type Middleware func(next Handler) Handler
+
+// Use appends middleware to the ORM. The first middleware registered is the outermost. Use is not
+// safe to call concurrently with operations; register middleware during setup. ORMs derived with
+// WithContext or passed to WithTransaction callbacks inherit the middleware registered so far.
+func (o *ORM) Use(middleware ...Middleware) {
+    o.middleware = append(o.middleware, middleware...)
+}
+
+// invoke runs call through the registered middleware.
+func (o *ORM) invoke(name, backend string, model interface{}, target string, call func() error) error {
+    return o.invokeKeyed(name, backend, model, nil, target, call)
+}
+
+// invokeKeyed is invoke for calls addressing a record by primary key.
+func (o *ORM) invokeKeyed(name, backend string, model interface{}, key interface{}, target string, call func() error) error {
+    call = o.guardOwnership(name, model, key, call)
+    if len(o.middleware) == 0 {
+        return call()
+    }
+    op := &Operation{
+        Name:    name,
+        Backend: backend,
+        Model:   modelName(model),
+        Target:  target,
+        Value:   model,
+        Key:     key,
+        Context: o.opContext(),
+        Started: time.Now(),
+        tx:      o.tx,
+    }
+    h := func(*Operation) error { return call() }
+    for i := len(o.middleware) - 1; i >= 0; i-- {
+        h = o.middleware[i](h)
+    }
+    return h(op)
+}
+
+// modelName returns the name of the struct behind model, looking through pointers and slices.
+func modelName(model interface{}) string {
+    if model == nil {
+        return ""
+    }
+    t := reflect.TypeOf(model)
+    for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+        t = t.Elem()
+    }
+    return t.Name()
+}
@@ -0,0 +1,135 @@
+package orm
+
+import (
+    "errors"
+    "fmt"
+    "persistence-layer/utils"
+    "reflect"
+)
+
+// ErrUnknownQuery is returned, wrapped with the name, for queries never registered with RegisterQuery.
+var ErrUnknownQuery = errors.New("unknown query")
+
+// NamedQuery is raw SQL registered under a name with RegisterQuery, e.g. a report or a maintenance
+// statement, so it lives in one place instead of being spelled out by every caller.
+type NamedQuery struct {
+    // SQL refers to parameters as @Name, after the fields of Params or the keys of a
+    // map[string]interface{}.
+    SQL string
+    // Params is a zero value of the parameter type, e.g. TopSellersParams{}; nil for none.
+    Params interface{}
+    // Result is a zero value of what the query returns, e.g. []TopSeller{} or TopSeller{}; nil for
+    // statements returning no rows, whose callers get the number of rows affected.
+    Result interface{}
+    // Database names the SQL database to run against; empty for DefaultDatabase.
+    Database string
+}
+
+// namedQuery is a NamedQuery with its parameter and result types resolved.
+type namedQuery struct {
+    NamedQuery
+    params reflect.Type
+    result reflect.Type
+}
+
+// RegisterQuery registers query under name for RunNamed. Call it at startup, before the ORM is shared.
+//
+//     err := o.RegisterQuery("top_sellers", orm.NamedQuery{
+//         SQL:    "SELECT product_id, SUM(quantity) AS sold FROM order_items WHERE created_at >= @Since GROUP BY product_id ORDER BY sold DESC LIMIT @Limit",
+//         Params: TopSellersParams{},
+//         Result: []TopSeller{},
+//     })
+func (o *ORM) RegisterQuery(name string, query NamedQuery) error {
+    if query.SQL == "" {
+        return fmt.Errorf("query %s has no SQL", name)
+    }
+    q := namedQuery{NamedQuery: query}
+    if query.Params != nil {
+        q.params = reflect.TypeOf(query.Params)
+        if q.params.Kind() != reflect.Struct && q.params != reflect.TypeOf(map[string]interface{}{}) {
+            return fmt.Errorf("query %s: parameters must be a struct or map[string]interface{}, not %s", name, q.params)
+        }
+    }
+    if query.Result != nil {
+        q.result = reflect.TypeOf(query.Result)
+    }
+    if query.Database != "" && query.Database != DefaultDatabase {
+        if _, ok := o.databases[query.Database]; !ok {
+            return fmt.Errorf("query %s: %w: %s", name, ErrUnknownDatabase, query.Database)
+        }
+    }
+    if o.queries == nil {
+        o.queries = make(map[string]namedQuery)
+    }
+    o.queries[name] = q
+    return nil
+}
+
+// RunNamed runs the query registered under name with params, a value or pointer of its parameter
+// type, or nil when it has none. Queries scan their rows into dest, a pointer to their result
+// type, reading from a replica like SearchSQL. Statements take an optional *int64 dest receiving
+// the number of rows affected.
+//
+//     var sellers []TopSeller
+//     err := o.RunNamed("top_sellers", TopSellersParams{Since: monthStart, Limit: 10}, &sellers)
+func (o *ORM) RunNamed(name string, params interface{}, dest interface{}) error {
+    return o.invoke("RunNamed", BackendSQL, dest, name, func() error {
+        q, ok := o.queries[name]
+        if !ok {
+            return fmt.Errorf("%w: %s", ErrUnknownQuery, name)
+        }
+        args, err := q.args(name, params)
+        if err != nil {
+            return err
+        }
+        db, err := o.Database(q.Database)
+        if err != nil {
+            return err
+        }
+        if db.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+
+        if q.result == nil {
+            affected, err := db.SQL.Exec(q.SQL, args)
+            if err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "RunNamed", "query": name})
+                return utils.HandleSQLError(err)
+            }
+            db.noteWrite(db.opContext())
+            if rows, ok := dest.(*int64); ok && rows != nil {
+                *rows = affected
+            }
+            utils.LogInfo("Named statement executed successfully", map[string]interface{}{"query": name, "affected": affected})
+            return nil
+        }
+
+        if rv := reflect.ValueOf(dest); rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Type() != q.result {
+            return fmt.Errorf("%w: query %s returns %s, not %T", utils.ErrInvalidValue, name, q.result, dest)
+        }
+        if err := db.reader().RawQuery(q.SQL, args, dest); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RunNamed", "query": name})
+            return utils.HandleSQLError(err)
+        }
+        utils.LogInfo("Named query executed successfully", map[string]interface{}{"query": name})
+        return nil
+    })
+}
+
+// args checks params against the query's parameter type and returns them as SQL arguments.
+func (q namedQuery) args(name string, params interface{}) ([]interface{}, error) {
+    if q.params == nil {
+        if params != nil {
+            return nil, fmt.Errorf("%w: query %s takes no parameters", utils.ErrInvalidValue, name)
+        }
+        return nil, nil
+    }
+    rv := reflect.ValueOf(params)
+    if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+        rv = rv.Elem()
+    }
+    if !rv.IsValid() || rv.Type() != q.params {
+        return nil, fmt.Errorf("%w: query %s takes %s, not %T", utils.ErrInvalidValue, name, q.params, params)
+    }
+    return []interface{}{rv.Interface()}, nil
+}
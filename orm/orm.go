@@ -1,33 +1,204 @@
 package orm
 
 import (
+    "context"
+    "errors"
+    "fmt"
     "persistence-layer/adapters"
     "persistence-layer/utils"
+    "reflect"
     "time"
+
+    "gorm.io/gorm"
+)
+
+// Backend names used in errors, configuration and logs.
+const (
+    BackendSQL           = "sql"
+    BackendMongo         = "mongo"
+    BackendRedis         = "redis"
+    BackendElasticsearch = "elasticsearch"
 )
 
-// ORM struct to integrate all data adapters.
+// ORM struct to integrate all data adapters. Any backend may be nil, in which case
+// operations that need it return utils.ErrBackendDisabled.
 type ORM struct {
-    SQL           *adapters.SQLAdapter
-    Mongo         *adapters.MongoAdapter
-    Redis         *adapters.RedisAdapter
-    Elasticsearch *adapters.ESAdapter
+    SQL           adapters.SQLStore
+    Mongo         adapters.MongoStore
+    Redis         adapters.CacheStore
+    Elasticsearch adapters.SearchStore
+
+    // TxRetry controls how WithTransaction re-runs callbacks aborted by deadlocks or serialization failures.
+    TxRetry TxRetryPolicy
+    // Locales controls the fallback locales of ReadLocalized.
+    Locales LocalePolicy
+    // SearchBreaker, when set, guards Elasticsearch searches; while it is open Search answers
+    // from SQL in degraded mode.
+    SearchBreaker *CircuitBreaker
+    // CacheJitter is the largest share by which the TTL of a cache write is randomly shortened;
+    // 0 disables jitter. Defaults to DefaultCacheJitter.
+    CacheJitter float64
+    // ReplicaLag is how long after a write a session reads from the primary when the databases
+    // can't tell whether a replica has caught up. Defaults to DefaultReplicaLag.
+    ReplicaLag time.Duration
+    // HotKeys, when set, records the cached reads of ReadByKey.
+    HotKeys *HotKeyTracker
+    // Refresher, when set, learns which cached records ReadByKey reads and keeps the refreshes of
+    // the records a write invalidates from writing back older data.
+    Refresher *CacheRefresher
+    // IndexPool, when set, applies the index changes of writes in the background.
+    IndexPool *IndexPool
+    // Views, when set, marks the materialized views affected by writes for refresh.
+    Views *ViewMaintainer
+    // Pagination sets the page sizes and total-count strategy of SearchSQLPage and SearchPage.
+    Pagination PaginationPolicy
+    // Webhooks encrypts the webhook endpoints' secrets and guards the addresses they are sent to.
+    Webhooks WebhookPolicy
+    // EventSchemas, when set, stamps webhook payloads with the versions of their entities'
+    // payloads; without it every entity stays at version 1.
+    EventSchemas *EventSchemas
+
+    tx         *SQLTransaction // Set on the ORM handed to a WithTransaction callback.
+    ctx        context.Context
+    middleware []Middleware
+    revisioned map[reflect.Type]bool        // Models registered with EnableRevisions.
+    webhooked  map[reflect.Type]bool        // Models registered with EnableWebhooks.
+    policies   map[reflect.Type]Policy      // Models registered with SetPolicy.
+    ownership  map[reflect.Type]*ownership  // Models registered with SetOwnership.
+    cacheTTLs  *cacheTTLs                   // Cache TTLs set with SetCacheTTL, shared by derived ORMs.
+    hedges     map[string]time.Duration     // Hedging delays by operation, set with SetHedge.
+    databases  map[string]adapters.SQLStore // Named SQL databases, including DefaultDatabase.
+    replicas   map[string]adapters.SQLStore // Read replicas by database name, set with AddReplica.
+    database   string                       // Name of the database behind SQL; empty for DefaultDatabase.
+    queries    map[string]namedQuery        // Named queries, set with RegisterQuery.
 }
 
-// NewORM initializes and returns a new ORM instance.
-func NewORM(sql *adapters.SQLAdapter, mongo *adapters.MongoAdapter, redis *adapters.RedisAdapter, es *adapters.ESAdapter) *ORM {
+// NewORM initializes and returns a new ORM instance. Pass nil for backends the deployment doesn't use.
+func NewORM(sql adapters.SQLStore, mongo adapters.MongoStore, redis adapters.CacheStore, es adapters.SearchStore) *ORM {
     utils.InitLogger() // Initialize logging.
-    return &ORM{
-        SQL:           sql,
-        Mongo:         mongo,
-        Redis:         redis,
-        Elasticsearch: es,
+    o := &ORM{TxRetry: DefaultTxRetryPolicy, Locales: DefaultLocalePolicy, CacheJitter: DefaultCacheJitter, ReplicaLag: DefaultReplicaLag, Pagination: DefaultPaginationPolicy, cacheTTLs: &cacheTTLs{}}
+    if !isNil(sql) {
+        o.SQL = sql
+        o.AddDatabase(DefaultDatabase, sql)
+    }
+    if !isNil(mongo) {
+        o.Mongo = mongo
+    }
+    if !isNil(redis) {
+        o.Redis = redis
+    }
+    if !isNil(es) {
+        o.Elasticsearch = es
+    }
+    return o
+}
+
+// clone returns a shallow copy of the ORM sharing its adapters.
+func (o *ORM) clone() *ORM {
+    c := *o
+    return &c
+}
+
+// WithContext returns a copy of the ORM whose SQL statements run with ctx and whose middleware
+// receive ctx in Operation.Context, e.g. to read the caller's tenant or deadline.
+func (o *ORM) WithContext(ctx context.Context) *ORM {
+    c := o.clone()
+    c.ctx = ctx
+    if c.SQL != nil {
+        c.SQL = c.SQL.WithContext(ctx)
+    }
+    return c
+}
+
+// WithStatementTimeout returns a copy of the ORM whose SQL statements each time out after d instead
+// of the default statement timeout, e.g. for a report known to run long; 0 lifts the timeout. It
+// has no effect unless the SQL adapter enabled statement timeouts.
+//
+//     err := o.WithStatementTimeout(time.Minute).SearchSQL(qb, &rows)
+func (o *ORM) WithStatementTimeout(d time.Duration) *ORM {
+    return o.WithContext(adapters.WithStatementTimeout(o.opContext(), d))
+}
+
+// opContext returns the context bound by WithContext, or context.Background.
+func (o *ORM) opContext() context.Context {
+    if o.ctx == nil {
+        return context.Background()
     }
+    return o.ctx
 }
 
-// Create inserts a new record into the primary SQL database with transaction.
-func (o *ORM) Create(model interface{}) error {
-    tx, err := NewSQLTransaction(o.SQL)
+// migrating returns db without the default deadlines and statement timeout, for the DDL creating
+// the ORM's own tables, which may run long on a busy database.
+func migrating(db *gorm.DB) *gorm.DB {
+    return db.WithContext(adapters.WithoutDeadlines(db.Statement.Context))
+}
+
+// isNil reports whether an adapter is nil, including typed nil pointers such as a nil *adapters.SQLAdapter.
+func isNil(adapter interface{}) bool {
+    if adapter == nil {
+        return true
+    }
+    v := reflect.ValueOf(adapter)
+    return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// backendDisabled wraps utils.ErrBackendDisabled with the name of the missing backend.
+func backendDisabled(backend string) error {
+    return fmt.Errorf("%w: %s", utils.ErrBackendDisabled, backend)
+}
+
+// Create inserts a new record into the backends of its Policy, the SQL part with transaction, then
+// invalidates its cached copy and indexes it. Records stored in both SQL and Mongo are written to
+// Mongo once the SQL write commits; see Result.Mongo. Records with invalid enum values are rejected with an
+// *InvalidEnumError before reaching any backend. Models generating their own key, such as those
+// embedding models.BaseModel, get it before the first backend is written. The Result carries the
+// generated key.
+func (o *ORM) Create(model interface{}) (*Result, error) {
+    policy := o.PolicyFor(model)
+    result := &Result{RowsAffected: -1, Mongo: SideEffectSkipped, Cache: SideEffectSkipped, Index: SideEffectSkipped}
+    err := o.invoke("Create", policy.backend(), model, "", func() error {
+        if m, ok := model.(keyAssigner); ok {
+            m.AssignKey()
+        }
+        if err := validateEnums(model); err != nil {
+            return err
+        }
+        if err := checkMongoKey(policy, nil, model); err != nil {
+            return err
+        }
+        if policy.SQL {
+            if err := o.createSQL(policy, model); err != nil {
+                return err
+            }
+            result.RowsAffected = 1
+        }
+        key, _ := ModelKey(model)
+        result.Key = key
+        if policy.Mongo && !policy.SQL {
+            if _, err := o.mongoWrite("Create", policy, key, model); err != nil {
+                return err
+            }
+            result.RowsAffected = 1
+        }
+        result.Mongo, result.Cache, result.Index = o.afterWrite("Create", policy, key, model)
+        utils.LogInfo("Record created successfully", map[string]interface{}{"model": model})
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+func (o *ORM) createSQL(policy Policy, model interface{}) error {
+    db, err := o.route(policy, nil, model)
+    if err != nil {
+        return err
+    }
+    if db.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    tx, err := db.beginTransaction()
     if err != nil {
         return err
     }
@@ -38,150 +209,770 @@ func (o *ORM) Create(model interface{}) error {
         utils.LogError(err, map[string]interface{}{"operation": "Create", "model": model})
         return utils.HandleSQLError(err)
     }
+    if err := o.enqueueIndex(tx, "Create", policy, nil, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Create Outbox", "model": model})
+        return utils.HandleSQLError(err)
+    }
+    if err := o.enqueueWebhooks(tx, "Create", nil, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Create Webhooks", "model": model})
+        return utils.HandleSQLError(err)
+    }
 
     err = tx.Commit()
     if err != nil {
         utils.LogError(err, map[string]interface{}{"operation": "Create Commit", "model": model})
         return err
     }
-
-    utils.LogInfo("Record created successfully", map[string]interface{}{"model": model})
+    db.noteWrite(db.opContext())
     return nil
 }
 
-// Update updates an existing record in the primary SQL database with transaction.
-func (o *ORM) Update(model interface{}) error {
-    tx, err := NewSQLTransaction(o.SQL)
+// Update updates an existing record in the backends of its Policy like Create, validating enum
+// fields first. The Result counts the SQL rows written, or the Mongo documents matched for models
+// kept only in Mongo; when none has the record's ID nothing is written and utils.ErrNotFound is
+// returned.
+func (o *ORM) Update(model interface{}) (*Result, error) {
+    policy := o.PolicyFor(model)
+    result := &Result{RowsAffected: -1, Mongo: SideEffectSkipped, Cache: SideEffectSkipped, Index: SideEffectSkipped}
+    err := o.invoke("Update", policy.backend(), model, "", func() error {
+        if err := validateEnums(model); err != nil {
+            return err
+        }
+        if err := checkMongoKey(policy, nil, model); err != nil {
+            return err
+        }
+        if policy.SQL {
+            rows, err := o.updateSQL(policy, model)
+            if err != nil {
+                return err
+            }
+            result.RowsAffected = rows
+        }
+        key, _ := ModelKey(model)
+        result.Key = key
+        if policy.Mongo && !policy.SQL {
+            _, before, err := o.viewBefore(nil, policy, key, model)
+            if err != nil {
+                return err
+            }
+            count, err := o.mongoWrite("Update", policy, key, model)
+            if err != nil {
+                return err
+            }
+            if count == 0 {
+                return utils.ErrNotFound
+            }
+            result.RowsAffected = count
+            o.viewsChanged(before, key)
+        }
+        result.Mongo, result.Cache, result.Index = o.afterWrite("Update", policy, key, model)
+        utils.LogInfo("Record updated successfully", map[string]interface{}{"model": model})
+        return nil
+    })
     if err != nil {
-        return err
+        return nil, err
+    }
+    return result, nil
+}
+
+func (o *ORM) updateSQL(policy Policy, model interface{}) (int64, error) {
+    db, err := o.route(policy, nil, model)
+    if err != nil {
+        return 0, err
+    }
+    if db.SQL == nil {
+        return 0, backendDisabled(BackendSQL)
+    }
+    tx, err := db.beginTransaction()
+    if err != nil {
+        return 0, err
     }
     defer tx.Rollback()
 
-    err = tx.Update(model)
+    if err := o.recordRevision(tx, "update", nil, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Update Revision", "model": model})
+        return 0, utils.HandleSQLError(err)
+    }
+    key, before, err := o.viewBefore(tx, policy, nil, model)
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Update View", "model": model})
+        return 0, utils.HandleSQLError(err)
+    }
+
+    rows, err := tx.Update(model)
     if err != nil {
         utils.LogError(err, map[string]interface{}{"operation": "Update", "model": model})
-        return utils.HandleSQLError(err)
+        return 0, utils.HandleSQLError(err)
+    }
+    if rows == 0 {
+        return 0, utils.ErrNotFound
+    }
+    if err := o.enqueueIndex(tx, "Update", policy, nil, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Update Outbox", "model": model})
+        return 0, utils.HandleSQLError(err)
+    }
+    if err := o.enqueueWebhooks(tx, "Update", nil, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Update Webhooks", "model": model})
+        return 0, utils.HandleSQLError(err)
     }
 
     err = tx.Commit()
     if err != nil {
         utils.LogError(err, map[string]interface{}{"operation": "Update Commit", "model": model})
-        return err
+        return 0, err
     }
-
-    utils.LogInfo("Record updated successfully", map[string]interface{}{"model": model})
-    return nil
+    db.noteWrite(db.opContext())
+    db.viewsChanged(before, key)
+    return rows, nil
 }
 
 // Delete removes a record from the primary SQL database by ID with transaction.
-func (o *ORM) Delete(id uint, model interface{}) error {
-    tx, err := NewSQLTransaction(o.SQL)
+func (o *ORM) Delete(id uint, model interface{}) (*Result, error) {
+    return o.DeleteByKey(id, model)
+}
+
+// DeleteByKey removes a record by a primary key of any type, such as the UUID of a models.BaseModel,
+// from the backends of its Policy, its cache and its index. Models with a DeletedAt field are
+// soft-deleted in SQL. The Result counts the SQL rows deleted, or the Mongo documents deleted for
+// models kept only in Mongo; when none has the key, or it is already soft-deleted,
+// utils.ErrNotFound is returned.
+func (o *ORM) DeleteByKey(key interface{}, model interface{}) (*Result, error) {
+    policy := o.PolicyFor(model)
+    result := &Result{RowsAffected: -1, Key: key, Mongo: SideEffectSkipped, Cache: SideEffectSkipped, Index: SideEffectSkipped}
+    err := o.invokeKeyed("Delete", policy.backend(), model, key, "", func() error {
+        if err := checkMongoKey(policy, key, model); err != nil {
+            return err
+        }
+        if policy.SQL {
+            rows, err := o.deleteSQL(policy, key, model)
+            if err != nil {
+                return err
+            }
+            result.RowsAffected = rows
+        }
+        if policy.Mongo && !policy.SQL {
+            _, before, err := o.viewBefore(nil, policy, key, model)
+            if err != nil {
+                return err
+            }
+            count, err := o.mongoWrite("Delete", policy, key, model)
+            if err != nil {
+                return err
+            }
+            if count == 0 {
+                return utils.ErrNotFound
+            }
+            result.RowsAffected = count
+            o.viewsChanged(before, key)
+        }
+        result.Mongo, result.Cache, result.Index = o.afterWrite("Delete", policy, key, model)
+        utils.LogInfo("Record deleted successfully", map[string]interface{}{"id": key})
+        return nil
+    })
     if err != nil {
-        return err
+        return nil, err
+    }
+    return result, nil
+}
+
+func (o *ORM) deleteSQL(policy Policy, key interface{}, model interface{}) (int64, error) {
+    db, err := o.route(policy, key, model)
+    if err != nil {
+        return 0, err
+    }
+    if db.SQL == nil {
+        return 0, backendDisabled(BackendSQL)
+    }
+    tx, err := db.beginTransaction()
+    if err != nil {
+        return 0, err
     }
     defer tx.Rollback()
 
-    err = tx.Delete(id, model)
+    if err := o.recordRevision(tx, "delete", key, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Delete Revision", "id": key})
+        return 0, utils.HandleSQLError(err)
+    }
+    _, before, err := o.viewBefore(tx, policy, key, model)
     if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "Delete", "id": id})
-        return utils.HandleSQLError(err)
+        utils.LogError(err, map[string]interface{}{"operation": "Delete View", "id": key})
+        return 0, utils.HandleSQLError(err)
     }
 
-    err = tx.Commit()
+    rows, err := tx.DeleteByKey(key, model)
     if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "Delete Commit", "id": id})
-        return err
+        utils.LogError(err, map[string]interface{}{"operation": "Delete", "id": key})
+        return 0, utils.HandleSQLError(err)
+    }
+    if rows == 0 {
+        return 0, utils.ErrNotFound
+    }
+    if err := o.enqueueIndex(tx, "Delete", policy, key, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Delete Outbox", "id": key})
+        return 0, utils.HandleSQLError(err)
+    }
+    if err := o.enqueueWebhooks(tx, "Delete", key, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Delete Webhooks", "id": key})
+        return 0, utils.HandleSQLError(err)
     }
 
-    utils.LogInfo("Record deleted successfully", map[string]interface{}{"id": id})
-    return nil
+    err = tx.Commit()
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Delete Commit", "id": key})
+        return 0, err
+    }
+    db.noteWrite(db.opContext())
+    db.viewsChanged(before, key)
+    return rows, nil
 }
 
 // Read retrieves a record from the primary SQL database by ID.
 func (o *ORM) Read(id uint, model interface{}) error {
-    err := o.SQL.Read(id, model)
-    if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "Read", "id": id})
-        return utils.HandleSQLError(err)
+    return o.ReadByKey(id, model)
+}
+
+// ReadMany retrieves the records with the given keys into dest, a pointer to a slice of models, with
+// a single query. Missing keys, and records an ownership rule hides from the caller, are skipped
+// rather than reported.
+func (o *ORM) ReadMany(keys []interface{}, dest interface{}) error {
+    return o.invoke("ReadMany", BackendSQL, dest, "", func() error {
+        if len(keys) == 0 {
+            return nil
+        }
+        err := o.readMany(o.PolicyFor(dest), keys, o.ownerConditions(dest), dest)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "ReadMany", "count": len(keys)})
+            return utils.HandleSQLError(err)
+        }
+        utils.LogInfo("Records retrieved successfully", map[string]interface{}{"count": len(keys)})
+        return nil
+    })
+}
+
+// ReadWith retrieves a record by key together with the named associations, so that fetching a
+// post with its comments and their authors costs one query per association instead of one per row:
+//
+//     err := o.ReadWith(postID, &post, "Comments", "Comments.Author", "Tags")
+func (o *ORM) ReadWith(key interface{}, model interface{}, preloads ...string) error {
+    return o.invokeKeyed("Read", BackendSQL, model, key, "", func() error {
+        err := o.readRouted(o.PolicyFor(model), key, model, func(db *ORM) error {
+            if db.SQL == nil {
+                return backendDisabled(BackendSQL)
+            }
+            if err := db.reader().ReadWith(key, model, preloads...); err != nil {
+                return utils.HandleSQLError(err)
+            }
+            return nil
+        })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "ReadWith", "id": key, "preloads": preloads})
+            return err
+        }
+        utils.LogInfo("Record retrieved successfully", map[string]interface{}{"id": key, "preloads": preloads})
+        return nil
+    })
+}
+
+// ReadByKey retrieves a record by a primary key of any type, such as the UUID of a models.BaseModel.
+// The record comes from the cache when its Policy caches it, otherwise from SQL, or from Mongo for
+// Mongo-only models.
+func (o *ORM) ReadByKey(key interface{}, model interface{}) error {
+    policy := o.PolicyFor(model)
+    return o.invokeKeyed("Read", policy.backend(), model, key, "", func() error {
+        cacheKey := CacheKey(model, key)
+        if policy.Cache && o.Redis != nil {
+            if o.Refresher != nil {
+                o.Refresher.read(cacheKey)
+            }
+            err := o.Redis.Get(cacheKey, model)
+            if err == nil {
+                if o.HotKeys != nil {
+                    o.HotKeys.hit(modelName(model), fmt.Sprint(key))
+                }
+                return nil
+            }
+            if !errors.Is(err, utils.ErrCacheMiss) {
+                // A failing cache degrades to reads from the source.
+                utils.LogError(err, map[string]interface{}{"operation": "Read Cache", "key": cacheKey})
+            }
+            if o.HotKeys != nil {
+                defer o.HotKeys.miss(modelName(model), fmt.Sprint(key))()
+            }
+        }
+        err := o.hedged("Read", model, func(dest interface{}) error { return o.readSource(policy, key, dest) })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Read", "id": key})
+            return err
+        }
+        if policy.Cache && o.Redis != nil {
+            if err := o.Redis.SetWithTTL(cacheKey, model, o.jitter(policy.CacheTTL)); err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "Read Cache", "key": cacheKey})
+            }
+        }
+        utils.LogInfo("Record retrieved successfully", map[string]interface{}{"id": key, "model": model})
+        return nil
+    })
+}
+
+// readSource reads a record by key from the source of truth of its policy, bypassing the cache.
+func (o *ORM) readSource(policy Policy, key interface{}, model interface{}) error {
+    if policy.SQL {
+        err := o.readRouted(policy, key, model, func(db *ORM) error {
+            if db.SQL == nil {
+                return backendDisabled(BackendSQL)
+            }
+            if err := db.reader().ReadByKey(key, model); err != nil {
+                return utils.HandleSQLError(err)
+            }
+            return nil
+        })
+        return err
+    }
+    if o.Mongo == nil {
+        return backendDisabled(BackendMongo)
+    }
+    if err := o.Mongo.Read(policy.Collection, map[string]interface{}{"_id": key}, model); err != nil {
+        return utils.HandleMongoError(err)
     }
-    utils.LogInfo("Record retrieved successfully", map[string]interface{}{"id": id, "model": model})
     return nil
 }
 
-// SearchSQL uses QueryBuilder for complex SQL queries.
+// SearchSQL uses QueryBuilder for complex SQL queries, run against the database of the model's
+// Policy; sharded models are searched with SearchShards.
 func (o *ORM) SearchSQL(queryBuilder *utils.QueryBuilder, model interface{}) error {
-    sqlQuery, params := queryBuilder.ToSQL()
-    err := o.SQL.RawQuery(sqlQuery, params, model)
-    if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "SearchSQL", "query": sqlQuery})
-        return utils.HandleSQLError(err)
-    }
-    utils.LogInfo("SQL search executed successfully", map[string]interface{}{"query": sqlQuery, "params": params})
-    return nil
+    return o.invoke("SearchSQL", BackendSQL, model, "", func() error {
+        db, err := o.modelDB(model)
+        if err != nil {
+            return err
+        }
+        if db.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        if err := queryBuilder.ValidateSQL(); err != nil {
+            return err
+        }
+        queryBuilder, err := stableSort(o.scopeQuery(queryBuilder, model), model)
+        if err != nil {
+            return err
+        }
+        sqlQuery, params := queryBuilder.ToSQL()
+        err = db.hedged("SearchSQL", model, func(dest interface{}) error {
+            reader := db.reader()
+            if err := reader.RawQuery(sqlQuery, params, dest); err != nil {
+                utils.LogError(utils.RedactError(err), map[string]interface{}{"operation": "SearchSQL", "query": sqlQuery})
+                return utils.HandleSQLError(err)
+            }
+            if err := reader.Preload(dest, queryBuilder.Preloads...); err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "SearchSQL Preload", "preloads": queryBuilder.Preloads})
+                return utils.HandleSQLError(err)
+            }
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+        utils.LogInfo("SQL search executed successfully", map[string]interface{}{"query": sqlQuery, "params": utils.RedactParams(params)})
+        return nil
+    })
+}
+
+// ExecSQL runs a raw SQL statement that returns no rows, e.g. a maintenance UPDATE or DELETE, and
+// returns how many rows it affected. Models' caches and search indexes are not updated.
+//
+//     purged, err := o.ExecSQL("DELETE FROM sessions WHERE expires_at < ?", time.Now())
+func (o *ORM) ExecSQL(query string, params ...interface{}) (int64, error) {
+    var affected int64
+    err := o.invoke("ExecSQL", BackendSQL, nil, "", func() error {
+        if o.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        var err error
+        affected, err = o.SQL.Exec(query, params)
+        if err != nil {
+            utils.LogError(utils.RedactError(err), map[string]interface{}{"operation": "ExecSQL", "query": query})
+            return utils.HandleSQLError(err)
+        }
+        o.noteWrite(o.opContext())
+        utils.LogInfo("SQL statement executed successfully", map[string]interface{}{"query": query, "params": utils.RedactParams(params), "affected": affected})
+        return nil
+    })
+    return affected, err
 }
 
 // MongoRead retrieves a record from MongoDB using a filter.
 func (o *ORM) MongoRead(collection string, filter map[string]interface{}, result interface{}) error {
-    err := o.Mongo.Read(collection, filter, result)
+    return o.invoke("MongoRead", BackendMongo, result, collection, func() error {
+        if o.Mongo == nil {
+            return backendDisabled(BackendMongo)
+        }
+        err := o.hedged("MongoRead", result, func(dest interface{}) error { return o.Mongo.Read(collection, filter, dest) })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "MongoRead", "collection": collection, "filter": filter})
+            return utils.HandleMongoError(err)
+        }
+        utils.LogInfo("MongoDB record retrieved successfully", map[string]interface{}{"collection": collection, "filter": filter})
+        return nil
+    })
+}
+
+// MongoFindOneAndUpdate atomically updates the first document of collection matching filter and
+// decodes it into result, for read-modify-write patterns such as counters or claiming work:
+//
+//     err := o.MongoFindOneAndUpdate("jobs", map[string]interface{}{"status": "pending"},
+//         map[string]interface{}{"$set": map[string]interface{}{"status": "running"}},
+//         adapters.FindOneAndUpdateOptions{ReturnNew: true, Sort: []string{"created_at"}}, &job)
+//
+// Enum values the update sets are validated as in Update. When result is a model kept in
+// collection, the cached copy of the document is invalidated and the document reindexed, as after
+// Update; other results, such as bson.M, leave both to the caller. It returns utils.ErrNotFound when
+// no document matched and none was upserted.
+func (o *ORM) MongoFindOneAndUpdate(collection string, filter map[string]interface{}, update interface{}, opts adapters.FindOneAndUpdateOptions, result interface{}) error {
+    return o.invoke("MongoFindOneAndUpdate", BackendMongo, result, collection, func() error {
+        if o.Mongo == nil {
+            return backendDisabled(BackendMongo)
+        }
+        updater, ok := o.Mongo.(adapters.DocumentUpdater)
+        if !ok {
+            return errors.New("mongo backend cannot find and update documents")
+        }
+        if err := validateUpdateEnums(collection, update); err != nil {
+            return err
+        }
+        err := updater.FindOneAndUpdate(collection, filter, update, opts, result)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "MongoFindOneAndUpdate", "collection": collection, "filter": filter})
+            return utils.HandleMongoError(err)
+        }
+        utils.LogInfo("MongoDB record updated successfully", map[string]interface{}{"collection": collection, "filter": filter})
+        o.afterFindOneAndUpdate(collection, opts, result)
+        return nil
+    })
+}
+
+// afterFindOneAndUpdate propagates the document MongoFindOneAndUpdate wrote to the cache and the
+// search index when result is a model kept in collection, reading it back when result holds it as
+// it was before the update.
+func (o *ORM) afterFindOneAndUpdate(collection string, opts adapters.FindOneAndUpdateOptions, result interface{}) {
+    policy := o.PolicyFor(result)
+    if !policy.Mongo || policy.Collection != collection {
+        return
+    }
+    key, err := ModelKey(result)
     if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "MongoRead", "collection": collection, "filter": filter})
-        return utils.HandleMongoError(err)
+        return
     }
-    utils.LogInfo("MongoDB record retrieved successfully", map[string]interface{}{"collection": collection, "filter": filter})
-    return nil
+    model := result
+    if !opts.ReturnNew {
+        model = reflect.New(indirectType(result)).Interface()
+        if err := o.Mongo.Read(collection, map[string]interface{}{"_id": key}, model); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "MongoFindOneAndUpdate Propagate", "collection": collection, "id": key})
+            return
+        }
+    }
+    o.propagate("Update", policy, key, model)
 }
 
-// Index indexes a document in Elasticsearch.
+// Index indexes the SearchDocument projection of model in Elasticsearch.
 func (o *ORM) Index(index string, model interface{}) error {
-    err := o.Elasticsearch.IndexDocument(index, model)
-    if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "Index", "model": model})
-        return err
-    }
-    utils.LogInfo("Document indexed successfully in Elasticsearch", map[string]interface{}{"model": model})
-    return nil
+    return o.invoke("Index", BackendElasticsearch, model, index, func() error {
+        if o.Elasticsearch == nil {
+            return backendDisabled(BackendElasticsearch)
+        }
+        doc, err := NewSearchDocument(model)
+        if err != nil {
+            return err
+        }
+        err = o.Elasticsearch.IndexDocument(index, doc)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Index", "model": model})
+            return err
+        }
+        utils.LogInfo("Document indexed successfully in Elasticsearch", map[string]interface{}{"model": model})
+        return nil
+    })
 }
 
-// Search performs a search in Elasticsearch.
+// Search performs a search in Elasticsearch. When Elasticsearch is disabled, or SearchBreaker is
+// open after repeated failures, the query is answered from the SQL table named after the index
+// instead, with "degraded": true in the response (see sqlSearchFallback).
 func (o *ORM) Search(index string, query map[string]interface{}, result interface{}) error {
-    err := o.Elasticsearch.Search(index, query, result)
-    if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "Search", "query": query})
-        return err
+    breakerOpen := o.Elasticsearch != nil && o.SearchBreaker != nil && !o.SearchBreaker.Allow()
+    backend := BackendElasticsearch
+    if (o.Elasticsearch == nil || breakerOpen) && o.SQL != nil {
+        backend = BackendSQL
     }
-    utils.LogInfo("Elasticsearch search executed successfully", map[string]interface{}{"query": query})
-    return nil
+    return o.invoke("Search", backend, result, index, func() error {
+        query, err := o.scopeSearchBody(index, query)
+        if err != nil {
+            return err
+        }
+        if o.Elasticsearch == nil || breakerOpen {
+            if o.SQL == nil {
+                if breakerOpen {
+                    return fmt.Errorf("%w: %s", utils.ErrCircuitOpen, BackendElasticsearch)
+                }
+                return backendDisabled(BackendElasticsearch)
+            }
+            return o.sqlSearchFallback(index, query, result)
+        }
+        err = o.hedged("Search", result, func(dest interface{}) error { return o.Elasticsearch.Search(index, query, dest) })
+        if o.SearchBreaker != nil {
+            if err != nil {
+                o.SearchBreaker.Failure()
+            } else {
+                o.SearchBreaker.Success()
+            }
+        }
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Search", "query": query})
+            return err
+        }
+        utils.LogInfo("Elasticsearch search executed successfully", map[string]interface{}{"query": query})
+        return nil
+    })
+}
+
+// Aggregate runs aggregations over the documents of index matching query, a query clause (nil
+// matches all), and decodes them into result, usually an *adapters.Aggregations:
+//
+//     var aggs adapters.Aggregations
+//     err := o.Aggregate("products", nil, map[string]interface{}{"by_category": adapters.TermsAgg("category", 20)}, &aggs)
+//     categories, err := aggs.Terms("by_category")
+func (o *ORM) Aggregate(index string, query map[string]interface{}, aggs map[string]interface{}, result interface{}) error {
+    return o.invoke("Aggregate", BackendElasticsearch, result, index, func() error {
+        if o.Elasticsearch == nil {
+            return backendDisabled(BackendElasticsearch)
+        }
+        query, err := o.scopeIndexQuery("Aggregate", "Read", index, query)
+        if err != nil {
+            return err
+        }
+        err = o.hedged("Aggregate", result, func(dest interface{}) error {
+            return o.Elasticsearch.Aggregate(index, query, aggs, dest)
+        })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Aggregate", "index": index, "aggs": aggs})
+            return err
+        }
+        utils.LogInfo("Elasticsearch aggregation executed successfully", map[string]interface{}{"index": index})
+        return nil
+    })
+}
+
+// Suggest returns up to size completions of prefix from a completion field of index, for typeahead.
+// field is a `search:"completion"` field or "<field>.suggest" for a `search:"text,suggest"` one.
+func (o *ORM) Suggest(index, field, prefix string, size int) ([]adapters.Suggestion, error) {
+    var suggestions []adapters.Suggestion
+    err := o.invoke("Suggest", BackendElasticsearch, nil, index, func() error {
+        if o.Elasticsearch == nil {
+            return backendDisabled(BackendElasticsearch)
+        }
+        // Completion suggesters cannot filter by owner, so owned documents are not suggested.
+        if err := o.guardIndex("Suggest", "Read", index); err != nil {
+            return err
+        }
+        var err error
+        suggestions, err = o.Elasticsearch.Suggest(index, field, prefix, size)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Suggest", "index": index, "field": field})
+            return err
+        }
+        return nil
+    })
+    return suggestions, err
+}
+
+// KnnSearch finds the k documents of index nearest to vector in a dense_vector field, e.g. similar
+// products, among those matching filter (nil for all), decoding hits into result.
+func (o *ORM) KnnSearch(index, field string, vector []float32, k int, filter map[string]interface{}, result interface{}) error {
+    return o.invoke("KnnSearch", BackendElasticsearch, result, index, func() error {
+        if o.Elasticsearch == nil {
+            return backendDisabled(BackendElasticsearch)
+        }
+        filter, err := o.scopeIndexQuery("KnnSearch", "Read", index, filter)
+        if err != nil {
+            return err
+        }
+        err = o.hedged("KnnSearch", result, func(dest interface{}) error {
+            return o.Elasticsearch.KnnSearch(index, field, vector, k, filter, dest)
+        })
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "KnnSearch", "index": index, "field": field, "k": k})
+            return err
+        }
+        return nil
+    })
+}
+
+// DeleteByQuery removes every document of index matching query, a query clause, without fetching
+// their IDs first, e.g. all documents of a deleted tenant. It returns how many were deleted.
+func (o *ORM) DeleteByQuery(index string, query map[string]interface{}) (int64, error) {
+    var deleted int64
+    err := o.invoke("DeleteByQuery", BackendElasticsearch, nil, index, func() error {
+        if o.Elasticsearch == nil {
+            return backendDisabled(BackendElasticsearch)
+        }
+        query, err := o.scopeIndexQuery("DeleteByQuery", "Delete", index, query)
+        if err != nil {
+            return err
+        }
+        deleted, err = o.Elasticsearch.DeleteByQuery(index, query)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "DeleteByQuery", "index": index, "query": query})
+            return err
+        }
+        utils.LogInfo("Documents deleted by query", map[string]interface{}{"index": index, "deleted": deleted})
+        return nil
+    })
+    return deleted, err
+}
+
+// UpdateByQuery sets fields on every document of index matching query and returns how many were
+// updated.
+func (o *ORM) UpdateByQuery(index string, query map[string]interface{}, fields map[string]interface{}) (int64, error) {
+    var updated int64
+    err := o.invoke("UpdateByQuery", BackendElasticsearch, nil, index, func() error {
+        if o.Elasticsearch == nil {
+            return backendDisabled(BackendElasticsearch)
+        }
+        if err := o.guardOwnerUpdate(index, fields); err != nil {
+            return err
+        }
+        query, err := o.scopeIndexQuery("UpdateByQuery", "Update", index, query)
+        if err != nil {
+            return err
+        }
+        updated, err = o.Elasticsearch.UpdateByQuery(index, query, fields)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "UpdateByQuery", "index": index, "query": query})
+            return err
+        }
+        utils.LogInfo("Documents updated by query", map[string]interface{}{"index": index, "updated": updated})
+        return nil
+    })
+    return updated, err
 }
 
-// SetCache sets a cache value with TTL in Redis.
+// SetCache sets a cache value with TTL in Redis, shortened by up to CacheJitter.
 func (o *ORM) SetCache(key string, value interface{}, ttl time.Duration) error {
-    err := o.Redis.SetWithTTL(key, value, ttl)
-    if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "SetCache", "key": key})
-        return err
-    }
-    utils.LogInfo("Cache value set successfully", map[string]interface{}{"key": key, "ttl": ttl})
-    return nil
+    return o.invoke("SetCache", BackendRedis, value, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        err := o.Redis.SetWithTTL(key, value, o.jitter(ttl))
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SetCache", "key": key})
+            return err
+        }
+        utils.LogInfo("Cache value set successfully", map[string]interface{}{"key": key, "ttl": ttl})
+        return nil
+    })
 }
 
-// GetCache retrieves a cached value from Redis.
+// GetCache retrieves a cached value from Redis. It returns utils.ErrNotFound when key is missing or
+// expired.
 func (o *ORM) GetCache(key string, dest interface{}) error {
-    err := o.Redis.Get(key, dest)
-    if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "GetCache", "key": key})
+    return o.invoke("GetCache", BackendRedis, dest, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        err := o.Redis.Get(key, dest)
+        if errors.Is(err, utils.ErrCacheMiss) {
+            return utils.ErrNotFound
+        }
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "GetCache", "key": key})
+            return err
+        }
+        utils.LogInfo("Cache value retrieved successfully", map[string]interface{}{"key": key})
+        return nil
+    })
+}
+
+// GetCacheMany retrieves several cached values from Redis in one round trip, returning the JSON of
+// each hit keyed by cache key. Missing keys are absent from the result.
+func (o *ORM) GetCacheMany(keys []string) (map[string][]byte, error) {
+    var hits map[string][]byte
+    err := o.invoke("GetCacheMany", BackendRedis, nil, "", func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        var err error
+        hits, err = o.Redis.GetMany(keys)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "GetCacheMany", "count": len(keys)})
+            return err
+        }
+        utils.LogInfo("Cache values retrieved successfully", map[string]interface{}{"count": len(keys), "hits": len(hits)})
+        return nil
+    })
+    return hits, err
+}
+
+// DeleteCachePattern deletes the cached values whose keys match a glob pattern, e.g. "product:*"
+// after a bulk import, and returns how many it deleted. See RedisAdapter.DeleteByPattern.
+func (o *ORM) DeleteCachePattern(pattern string) (int64, error) {
+    var deleted int64
+    err := o.invoke("DeleteCachePattern", BackendRedis, nil, pattern, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        var err error
+        deleted, err = o.Redis.DeleteByPattern(pattern)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "DeleteCachePattern", "pattern": pattern, "deleted": deleted})
+            return err
+        }
+        utils.LogInfo("Cache values deleted successfully", map[string]interface{}{"pattern": pattern, "deleted": deleted})
+        return nil
+    })
+    return deleted, err
+}
+
+// Allow reports whether a request under key is within limit requests per sliding window across
+// all processes sharing the cache, and counts it if so:
+//
+//     decision, err := o.Allow("export:"+userID, 10, time.Hour)
+//     if err == nil && !decision.Allowed { ... retry after decision.RetryAfter }
+//
+// A limit or window that isn't positive fails with utils.ErrInvalidValue.
+func (o *ORM) Allow(key string, limit int64, window time.Duration) (adapters.RateLimit, error) {
+    var decision adapters.RateLimit
+    err := o.invoke("Allow", BackendRedis, nil, key, func() error {
+        if limit <= 0 || window <= 0 {
+            return fmt.Errorf("%w: rate limit of %d per %s", utils.ErrInvalidValue, limit, window)
+        }
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        limiter, ok := o.Redis.(adapters.RateLimiter)
+        if !ok {
+            return errors.New("rate limit: the cache backend does not support rate limiting")
+        }
+        var err error
+        if decision, err = limiter.Allow(key, limit, window); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Allow", "key": key})
+        }
         return err
-    }
-    utils.LogInfo("Cache value retrieved successfully", map[string]interface{}{"key": key})
-    return nil
+    })
+    return decision, err
 }
 
 // DeleteCache deletes a cached value in Redis.
 func (o *ORM) DeleteCache(key string) error {
-    err := o.Redis.Delete(key)
-    if err != nil {
-        utils.LogError(err, map[string]interface{}{"operation": "DeleteCache", "key": key})
-        return err
-    }
-    utils.LogInfo("Cache value deleted successfully", map[string]interface{}{"key": key})
-    return nil
+    return o.invoke("DeleteCache", BackendRedis, nil, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        if o.Refresher != nil {
+            o.Refresher.invalidated(key)
+        }
+        err := o.Redis.Delete(key)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "DeleteCache", "key": key})
+            return err
+        }
+        utils.LogInfo("Cache value deleted successfully", map[string]interface{}{"key": key})
+        return nil
+    })
 }
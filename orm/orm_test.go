@@ -0,0 +1,185 @@
+package orm
+
+import (
+    "errors"
+    "persistence-layer/adapters/memory"
+    "persistence-layer/models"
+    "persistence-layer/utils"
+    "testing"
+    "time"
+)
+
+type cachedRecord struct {
+    ID   uint64 `json:"id"`
+    Name string `json:"name"`
+}
+
+func (r *cachedRecord) GetID() uint64 { return r.ID }
+
+// newCachedORM returns an ORM over the memory SQL and Redis fakes caching cachedRecord.
+func newCachedORM(t *testing.T) (*ORM, *memory.SQLAdapter, *memory.RedisAdapter) {
+    t.Helper()
+    sql, redis := memory.NewSQLAdapter(), memory.NewRedisAdapter()
+    o := NewORM(sql, nil, redis, nil)
+    if err := o.SetPolicy(&cachedRecord{}, Policy{SQL: true, Cache: true, CacheTTL: time.Minute}); err != nil {
+        t.Fatalf("SetPolicy: %v", err)
+    }
+    return o, sql, redis
+}
+
+func TestReadByKeyCache(t *testing.T) {
+    tests := []struct {
+        name   string
+        cached *cachedRecord // Entry in the cache before the read, if any.
+        want   string
+    }{
+        {name: "miss reads SQL", want: "from sql"},
+        {name: "hit reads the cache", cached: &cachedRecord{ID: 1, Name: "from cache"}, want: "from cache"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            o, sql, redis := newCachedORM(t)
+            if err := sql.Create(&cachedRecord{Name: "from sql"}); err != nil {
+                t.Fatalf("Create: %v", err)
+            }
+            key := CacheKey(&cachedRecord{}, uint64(1))
+            if tt.cached != nil {
+                if err := redis.SetWithTTL(key, tt.cached, time.Minute); err != nil {
+                    t.Fatalf("SetWithTTL: %v", err)
+                }
+            }
+
+            var got cachedRecord
+            if err := o.ReadByKey(uint64(1), &got); err != nil {
+                t.Fatalf("ReadByKey: %v", err)
+            }
+            if got.ID != 1 || got.Name != tt.want {
+                t.Fatalf("ReadByKey = %+v, want ID 1 named %q", got, tt.want)
+            }
+            var cached cachedRecord
+            if err := redis.Get(key, &cached); err != nil || cached.Name != tt.want {
+                t.Fatalf("cache holds %+v, %v; want the record read", cached, err)
+            }
+        })
+    }
+}
+
+func TestReadByKeyCountsHotKeyHitsAndMisses(t *testing.T) {
+    o, sql, _ := newCachedORM(t)
+    o.HotKeys = NewHotKeyTracker(1)
+    if err := sql.Create(&cachedRecord{Name: "first"}); err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    for i := 0; i < 3; i++ {
+        var got cachedRecord
+        if err := o.ReadByKey(uint64(1), &got); err != nil {
+            t.Fatalf("ReadByKey: %v", err)
+        }
+    }
+    stats := o.HotKeys.Stats()["cachedRecord"]
+    if stats.Misses != 1 || stats.Hits != 2 {
+        t.Fatalf("stats = %+v, want 1 miss loading the cache and 2 hits", stats)
+    }
+    if top := o.HotKeys.Top("cachedRecord", 1); len(top) != 1 || top[0].Key != "1" || top[0].Reads != 3 {
+        t.Fatalf("Top = %+v, want key 1 read 3 times", top)
+    }
+}
+
+func TestAllow(t *testing.T) {
+    tests := []struct {
+        name        string
+        limit       int64
+        window      time.Duration
+        wantAllowed []bool // Decisions of successive requests.
+        wantErr     error
+    }{
+        {name: "within the limit then denied", limit: 2, window: time.Minute, wantAllowed: []bool{true, true, false}},
+        {name: "zero limit", limit: 0, window: time.Minute, wantErr: utils.ErrInvalidValue},
+        {name: "negative limit", limit: -1, window: time.Minute, wantErr: utils.ErrInvalidValue},
+        {name: "zero window", limit: 1, wantErr: utils.ErrInvalidValue},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            o := NewORM(nil, nil, memory.NewRedisAdapter(), nil)
+            if tt.wantErr != nil {
+                if _, err := o.Allow("key", tt.limit, tt.window); !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("Allow = %v, want %v", err, tt.wantErr)
+                }
+                return
+            }
+            for i, want := range tt.wantAllowed {
+                decision, err := o.Allow("key", tt.limit, tt.window)
+                if err != nil || decision.Allowed != want {
+                    t.Fatalf("request %d: Allow = %+v, %v; want allowed %v", i, decision, err, want)
+                }
+            }
+        })
+    }
+}
+
+type mongoNote struct {
+    models.BaseModel `bson:",inline"`
+    Text             string `json:"text" bson:"text"`
+}
+
+func TestCreateAssignsKeysToMongoOnlyModels(t *testing.T) {
+    o := NewORM(nil, memory.NewMongoAdapter(), nil, nil)
+    if err := o.SetPolicy(&mongoNote{}, Policy{Mongo: true}); err != nil {
+        t.Fatalf("SetPolicy: %v", err)
+    }
+    first, second := &mongoNote{Text: "first"}, &mongoNote{Text: "second"}
+    for _, note := range []*mongoNote{first, second} {
+        result, err := o.Create(note)
+        if err != nil {
+            t.Fatalf("Create: %v", err)
+        }
+        if note.ID == "" || result.Key != note.ID {
+            t.Fatalf("Create assigned key %q, result key %v; want a UUIDv7 reported in the result", note.ID, result.Key)
+        }
+    }
+    if first.ID == second.ID {
+        t.Fatalf("both records got key %q", first.ID)
+    }
+    var read mongoNote
+    if err := o.ReadByKey(second.ID, &read); err != nil || read.Text != "second" {
+        t.Fatalf("ReadByKey = %+v, %v; want the second note", read, err)
+    }
+}
+
+func TestWritesInvalidateTheCache(t *testing.T) {
+    o, _, redis := newCachedORM(t)
+    record := &cachedRecord{Name: "first"}
+    if _, err := o.Create(record); err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    key := CacheKey(record, record.ID)
+    var read cachedRecord
+    if err := o.ReadByKey(record.ID, &read); err != nil {
+        t.Fatalf("ReadByKey: %v", err)
+    }
+
+    record.Name = "renamed"
+    result, err := o.Update(record)
+    if err != nil {
+        t.Fatalf("Update: %v", err)
+    }
+    if result.RowsAffected != 1 || result.Cache != SideEffectDone {
+        t.Fatalf("Update result = %+v, want 1 row and the cache invalidated", result)
+    }
+    if err := redis.Get(key, &read); !errors.Is(err, utils.ErrCacheMiss) {
+        t.Fatalf("cache after Update: %v, want a miss", err)
+    }
+    if err := o.ReadByKey(record.ID, &read); err != nil || read.Name != "renamed" {
+        t.Fatalf("ReadByKey after Update = %+v, %v; want the renamed record", read, err)
+    }
+
+    if _, err := o.DeleteByKey(record.ID, &cachedRecord{}); err != nil {
+        t.Fatalf("DeleteByKey: %v", err)
+    }
+    if err := o.ReadByKey(record.ID, &cachedRecord{}); !errors.Is(err, utils.ErrNotFound) {
+        t.Fatalf("ReadByKey after DeleteByKey = %v, want utils.ErrNotFound", err)
+    }
+    if _, err := o.Update(&cachedRecord{ID: 42, Name: "missing"}); !errors.Is(err, utils.ErrNotFound) {
+        t.Fatalf("Update of a missing record = %v, want utils.ErrNotFound", err)
+    }
+}
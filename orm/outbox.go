@@ -0,0 +1,449 @@
+package orm
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/rand"
+    "persistence-layer/adapters"
+    "persistence-layer/types"
+    "persistence-layer/utils"
+    "reflect"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+const (
+    defaultOutboxBatchSize   = 100
+    defaultOutboxMaxAttempts = 10
+    defaultOutboxBackoff     = time.Second
+    defaultOutboxMaxBackoff  = 5 * time.Minute
+    defaultOutboxRelayName   = "default"
+    // defaultOutboxSettle is how old events must be before the checkpoint passes them, longer than
+    // any transaction that may still commit an event with a lower ID.
+    defaultOutboxSettle = 5 * time.Minute
+)
+
+// OutboxEvent is a pending search index change, written to the outbox_events table in the same
+// transaction as the SQL write that caused it, so the index is updated if and only if the write
+// commits. Its ID orders the changes of a record and serves as their external version in the
+// index, which suppresses duplicates. Events naming a Collection instead repair the Mongo copy of
+// a record stored in both SQL and Mongo whose Mongo write failed after the SQL write committed.
+type OutboxEvent struct {
+    ID            uint64     `json:"id" gorm:"primaryKey"`
+    Aggregate     string     `json:"aggregate" gorm:"size:64;not null;index:idx_outbox_aggregate"` // Model name, e.g. "Product".
+    AggregateKey  string     `json:"aggregate_key" gorm:"size:64;not null;index:idx_outbox_aggregate"`
+    Operation     string     `json:"operation" gorm:"size:16;not null"` // "Create", "Update", "Delete" or "Repair".
+    Index         string     `json:"index" gorm:"size:128;not null"`
+    Collection    string     `json:"collection" gorm:"size:128;not null;default:''"` // Mongo collection of a repair.
+    Payload       types.JSON `json:"payload"` // The SearchDocument source, empty for deletes; the record's key for repairs.
+    Attempts      int        `json:"attempts"`
+    LastError     string     `json:"last_error" gorm:"type:text"`
+    NextAttemptAt *time.Time `json:"next_attempt_at" gorm:"index"` // Failed events wait until then; see OutboxRelay.Backoff.
+    CreatedAt     time.Time  `json:"created_at"`
+    ProcessedAt   *time.Time `json:"processed_at" gorm:"index"`
+}
+
+// OutboxCheckpoint is the progress of the relays sharing a name: every event up to LastEventID was
+// applied, suppressed as a duplicate or given up on, so they only look at later events. It is
+// stored in the outbox_checkpoints table with the relays' counts.
+type OutboxCheckpoint struct {
+    Relay       string    `json:"relay" gorm:"primaryKey;size:64"`
+    LastEventID uint64    `json:"last_event_id"`
+    Applied     int64     `json:"applied"`
+    Suppressed  int64     `json:"suppressed"` // Events the index already had a later version of.
+    UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// EnableOutbox creates or migrates the outbox_events and outbox_checkpoints tables used by policies
+// with AsyncIndex in every database, since events are written in the transaction of the record
+// they index; run an OutboxRelay over each database, see Database.
+func (o *ORM) EnableOutbox() error {
+    if len(o.databases) == 0 {
+        return backendDisabled(BackendSQL)
+    }
+    for _, name := range o.DatabaseNames() {
+        db := o.databases[name].GetDB()
+        if db == nil {
+            return errNoGormDB
+        }
+        if err := migrating(db).AutoMigrate(&OutboxEvent{}, &OutboxCheckpoint{}); err != nil {
+            return fmt.Errorf("failed to migrate the outbox of database %s: %w", name, err)
+        }
+    }
+    return nil
+}
+
+// enqueueIndex records the index change of a write in tx when the model's policy indexes
+// asynchronously.
+func (o *ORM) enqueueIndex(tx Transaction, operation string, policy Policy, key interface{}, model interface{}) error {
+    if !policy.AsyncIndex || policy.Index == "" {
+        return nil
+    }
+    event, err := newOutboxEvent(operation, policy, key, model)
+    if err != nil {
+        return err
+    }
+    store := transactionStore(tx)
+    if store == nil {
+        return errNoGormDB
+    }
+    return store.Create(event)
+}
+
+// enqueueMongoRepair records, in a transaction of its own on the default database, that the Mongo
+// copy of a record must be brought in line with its SQL state, see OutboxRelay.
+func (o *ORM) enqueueMongoRepair(policy Policy, key interface{}, model interface{}) error {
+    store := o.databases[DefaultDatabase]
+    if isNil(store) {
+        return backendDisabled(BackendSQL)
+    }
+    payload, err := types.NewJSON(map[string]interface{}{"key": key})
+    if err != nil {
+        return err
+    }
+    event := &OutboxEvent{
+        Aggregate:    modelName(model),
+        AggregateKey: fmt.Sprint(key),
+        Operation:    "Repair",
+        Collection:   policy.Collection,
+        Payload:      payload,
+    }
+    if o.ctx != nil {
+        store = store.WithContext(o.ctx)
+    }
+    return store.Create(event)
+}
+
+// newOutboxEvent returns the index change of a write, with the search document of model as it is
+// now.
+func newOutboxEvent(operation string, policy Policy, key interface{}, model interface{}) (*OutboxEvent, error) {
+    event := &OutboxEvent{
+        Aggregate: modelName(model),
+        Operation: operation,
+        Index:     policy.Index,
+    }
+    if operation == "Delete" {
+        event.AggregateKey = fmt.Sprint(key)
+        return event, nil
+    }
+    doc, err := NewSearchDocument(model)
+    if err != nil {
+        return nil, err
+    }
+    if event.Payload, err = types.NewJSON(doc.Source); err != nil {
+        return nil, err
+    }
+    event.AggregateKey = doc.Key
+    return event, nil
+}
+
+// OutboxRelay applies pending outbox events to Elasticsearch, and repairs the Mongo copies of
+// records by writing their SQL state to Mongo, or removing them there when the SQL record is gone.
+// Several relays may run against the same database; each batch is claimed with SKIP LOCKED so no
+// event is applied twice concurrently. Without Elasticsearch or Mongo, the events for it wait.
+//
+// The events of a record are applied in order: a batch only claims the earliest pending event of
+// each record, so a later one waits until it is applied or given up on, even when another relay
+// holds it. Events are written with their ID as external version when the search store supports
+// it, so the index drops an event redelivered after a crash, or overtaken by a later one, instead
+// of going back to an older state. Progress is kept in the OutboxCheckpoint named Name.
+type OutboxRelay struct {
+    orm         *ORM
+    Name        string // Checkpoint of the relay; defaults to "default".
+    BatchSize   int    // Defaults to 100.
+    MaxAttempts int    // Events failing this often are left for inspection; defaults to 10.
+    // Settle is how old events must be before the checkpoint moves past them, longer than any
+    // transaction writing events; defaults to 5 minutes.
+    Settle time.Duration
+    // Backoff is how long a failed event waits before its next attempt, doubling with each further
+    // failure up to MaxBackoff, so a failing backend isn't retried on every tick. Defaults to 1
+    // second and 5 minutes.
+    Backoff    time.Duration
+    MaxBackoff time.Duration
+}
+
+// NewOutboxRelay creates a relay over the outbox of o's database.
+func NewOutboxRelay(o *ORM) *OutboxRelay {
+    return &OutboxRelay{
+        orm:         o,
+        Name:        defaultOutboxRelayName,
+        BatchSize:   defaultOutboxBatchSize,
+        MaxAttempts: defaultOutboxMaxAttempts,
+        Settle:      defaultOutboxSettle,
+        Backoff:     defaultOutboxBackoff,
+        MaxBackoff:  defaultOutboxMaxBackoff,
+    }
+}
+
+// Run executes RunOnce every interval until the context is cancelled, draining the outbox between
+// ticks.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        for {
+            n, err := r.RunOnce(ctx)
+            if err != nil || n < r.BatchSize {
+                break
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// RunOnce claims one batch of pending events in creation order, applies them and marks them
+// processed, then advances the checkpoint. A failed event keeps its place with its attempt count
+// raised and its next attempt put off (see Backoff), holding back the later events of its record.
+// It returns the number of events claimed.
+func (r *OutboxRelay) RunOnce(ctx context.Context) (int, error) {
+    if r.orm.Elasticsearch == nil && r.orm.Mongo == nil {
+        return 0, backendDisabled(BackendElasticsearch)
+    }
+    var backends string
+    switch {
+    case r.orm.Elasticsearch == nil:
+        backends = " AND collection <> ''"
+    case r.orm.Mongo == nil:
+        backends = " AND collection = ''"
+    }
+    var events []OutboxEvent
+    var applied, suppressed int64
+    err := r.orm.WithTransaction(ctx, func(txORM *ORM) error {
+        checkpoint, err := r.checkpoint(txORM)
+        if err != nil {
+            return err
+        }
+        err = txORM.ClaimBatch(&events, r.BatchSize, `id > ? AND processed_at IS NULL AND attempts < ?
+            AND (next_attempt_at IS NULL OR next_attempt_at <= ?) AND NOT EXISTS (
+            SELECT 1 FROM outbox_events earlier WHERE earlier.aggregate = outbox_events.aggregate
+            AND earlier.aggregate_key = outbox_events.aggregate_key AND earlier.collection = outbox_events.collection
+            AND earlier.id < outbox_events.id AND earlier.processed_at IS NULL AND earlier.attempts < ?)`+backends,
+            checkpoint.LastEventID, r.MaxAttempts, time.Now(), r.MaxAttempts)
+        if err != nil {
+            return err
+        }
+        for i := range events {
+            event := &events[i]
+            if ok, err := r.orm.applyOutboxEvent(event); err != nil {
+                event.Attempts++
+                event.LastError = err.Error()
+                next := time.Now().Add(r.backoff(event.Attempts))
+                event.NextAttemptAt = &next
+                utils.LogError(err, map[string]interface{}{"operation": "Outbox Relay", "event": event.ID, "index": event.Index})
+            } else {
+                now := time.Now()
+                event.ProcessedAt = &now
+                event.LastError = ""
+                if ok {
+                    applied++
+                } else {
+                    suppressed++
+                }
+            }
+            if _, err := txORM.SQL.Update(event); err != nil {
+                return err
+            }
+        }
+        return r.advance(txORM, checkpoint, applied, suppressed)
+    })
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Outbox Relay"})
+        return 0, err
+    }
+    if len(events) > 0 {
+        utils.LogInfo("Outbox events relayed", map[string]interface{}{"count": len(events), "suppressed": suppressed})
+    }
+    return len(events), nil
+}
+
+// Checkpoint returns the relay's checkpoint, zero before its first batch.
+func (r *OutboxRelay) Checkpoint() (OutboxCheckpoint, error) {
+    checkpoint := OutboxCheckpoint{Relay: r.name()}
+    err := r.orm.invoke("OutboxCheckpoint", BackendSQL, &checkpoint, "", func() error {
+        if r.orm.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        db := r.orm.SQL.GetDB()
+        if db == nil {
+            return errNoGormDB
+        }
+        if err := db.Where("relay = ?", checkpoint.Relay).Limit(1).Find(&checkpoint).Error; err != nil {
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+    return checkpoint, err
+}
+
+// checkpoint loads the relay's checkpoint in the transaction, creating it on first use.
+func (r *OutboxRelay) checkpoint(txORM *ORM) (*OutboxCheckpoint, error) {
+    db := txORM.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    checkpoint := &OutboxCheckpoint{Relay: r.name()}
+    if err := db.Where(checkpoint).FirstOrCreate(checkpoint).Error; err != nil {
+        return nil, err
+    }
+    return checkpoint, nil
+}
+
+// advance moves the checkpoint up to the last event before the earliest one still pending, among
+// those old enough to have settled, and adds the batch's counts. Relays sharing the checkpoint
+// only ever move it forward.
+func (r *OutboxRelay) advance(txORM *ORM, checkpoint *OutboxCheckpoint, applied, suppressed int64) error {
+    db := txORM.SQL.GetDB()
+    var settled, pending uint64
+    settle := r.Settle
+    if settle <= 0 {
+        settle = defaultOutboxSettle
+    }
+    err := db.Model(&OutboxEvent{}).Select("COALESCE(MAX(id), 0)").
+        Where("id > ? AND created_at < ?", checkpoint.LastEventID, time.Now().Add(-settle)).Scan(&settled).Error
+    if err != nil {
+        return err
+    }
+    err = db.Model(&OutboxEvent{}).Select("COALESCE(MIN(id), 0)").
+        Where("id > ? AND processed_at IS NULL AND attempts < ?", checkpoint.LastEventID, r.MaxAttempts).Scan(&pending).Error
+    if err != nil {
+        return err
+    }
+    if pending > 0 && pending-1 < settled {
+        settled = pending - 1
+    }
+    updates := map[string]interface{}{
+        "applied":    gorm.Expr("applied + ?", applied),
+        "suppressed": gorm.Expr("suppressed + ?", suppressed),
+        "updated_at": time.Now(),
+    }
+    if settled > checkpoint.LastEventID {
+        updates["last_event_id"] = gorm.Expr("CASE WHEN last_event_id < ? THEN ? ELSE last_event_id END", settled, settled)
+    }
+    return db.Model(&OutboxCheckpoint{}).Where("relay = ?", checkpoint.Relay).Updates(updates).Error
+}
+
+// backoff returns how long an event that failed attempts times waits for its next attempt: Backoff
+// doubled for each failure after the first, up to MaxBackoff, less up to a fifth at random so the
+// events failing together don't all come back at once.
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+    base, max := r.Backoff, r.MaxBackoff
+    if base <= 0 {
+        base = defaultOutboxBackoff
+    }
+    if max < base {
+        max = base
+    }
+    wait := base
+    for i := 1; i < attempts && wait < max; i++ {
+        wait *= 2
+    }
+    if wait > max {
+        wait = max
+    }
+    return wait - time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+func (r *OutboxRelay) name() string {
+    if r.Name == "" {
+        return defaultOutboxRelayName
+    }
+    return r.Name
+}
+
+// applyOutboxEvent performs one event against Elasticsearch, reporting false when the index
+// suppressed it as a duplicate or an outdated change. Stored events are versioned by their ID;
+// those of an IndexPool, which have none, overwrite the document.
+func (o *ORM) applyOutboxEvent(event *OutboxEvent) (bool, error) {
+    if event.Collection != "" {
+        return true, o.repairMongo(event)
+    }
+    doc := &SearchDocument{Key: event.AggregateKey}
+    versioned, ok := o.Elasticsearch.(adapters.VersionedIndexer)
+    ok = ok && event.ID > 0
+    switch event.Operation {
+    case "Create", "Update":
+        if err := json.Unmarshal(event.Payload, &doc.Source); err != nil {
+            return false, err
+        }
+        if ok {
+            return versioned.IndexVersioned(event.Index, doc, int64(event.ID))
+        }
+        return true, o.Elasticsearch.IndexDocument(event.Index, doc)
+    case "Delete":
+        if ok {
+            return versioned.DeleteVersioned(event.Index, doc, int64(event.ID))
+        }
+        return true, o.Elasticsearch.DeleteDocument(event.Index, doc)
+    }
+    return false, errors.New("unknown outbox operation " + event.Operation)
+}
+
+// repairMongo writes the current SQL state of the record of a repair event to its Mongo collection,
+// or deletes it there when the record no longer exists in SQL.
+func (o *ORM) repairMongo(event *OutboxEvent) error {
+    if o.Mongo == nil {
+        return backendDisabled(BackendMongo)
+    }
+    var t reflect.Type
+    var policy Policy
+    for candidate := range o.policies {
+        if p := o.PolicyFor(reflect.New(candidate).Interface()); candidate.Name() == event.Aggregate && p.Collection == event.Collection {
+            t, policy = candidate, p
+        }
+    }
+    if t == nil {
+        return fmt.Errorf("no policy stores %s in collection %s", event.Aggregate, event.Collection)
+    }
+    var payload struct {
+        Key interface{} `json:"key"`
+    }
+    decoder := json.NewDecoder(bytes.NewReader(event.Payload))
+    decoder.UseNumber()
+    if err := decoder.Decode(&payload); err != nil {
+        return err
+    }
+    key := payload.Key
+    if n, ok := key.(json.Number); ok {
+        if i, err := n.Int64(); err == nil {
+            key = i
+        }
+    }
+
+    record := reflect.New(t).Interface()
+    err := o.readRouted(policy, key, record, func(db *ORM) error {
+        if db.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        // The primary, not a replica that may not have the write yet.
+        if err := db.SQL.ReadByKey(key, record); err != nil {
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+    filter := map[string]interface{}{"_id": key}
+    switch {
+    case errors.Is(err, utils.ErrNotFound):
+        _, err = o.Mongo.Delete(event.Collection, filter)
+    case err != nil:
+        return err
+    default:
+        if updater, ok := o.Mongo.(adapters.DocumentUpdater); ok {
+            err = updater.FindOneAndUpdate(event.Collection, filter, map[string]interface{}{"$set": record},
+                adapters.FindOneAndUpdateOptions{Upsert: true, ReturnNew: true}, reflect.New(t).Interface())
+        } else if _, err = o.Mongo.Delete(event.Collection, filter); err == nil {
+            err = o.Mongo.Create(event.Collection, record)
+        }
+    }
+    if err != nil {
+        return fmt.Errorf("repairing %s %s in %s: %w", event.Aggregate, event.AggregateKey, event.Collection, err)
+    }
+    return nil
+}
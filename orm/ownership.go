@@ -0,0 +1,356 @@
+package orm
+
+import (
+    "context"
+    "fmt"
+    "persistence-layer/utils"
+    "reflect"
+
+    "gorm.io/gorm/schema"
+)
+
+// OwnershipRule restricts operations on the records of a model to their owner, the principal whose
+// subject is stored in Field, e.g. letting only the author of a Comment update or delete it:
+//
+//     err := o.SetOwnership(&models.Comment{}, orm.OwnershipRule{Field: "AuthorID", AdminRoles: []string{"admin"}})
+//
+// Listing "Read" in Operations scopes reads too, e.g. to list only my drafts: Read, ReadWith,
+// ReadForUpdate, ReadLocalized, ReadAsOf and GetCache answer utils.ErrNotFound for others'
+// records, and ReadMany, SearchSQL and the Elasticsearch queries of the model's Policy.Index
+// (Search, Aggregate, KnnSearch) only match the principal's own. So do DeleteByQuery and
+// UpdateByQuery under the Delete and Update rules. Queries are scoped by the owner field of the
+// search document, which must be indexed as an exact value, e.g. `search:"keyword"`; Suggest cannot
+// be scoped and is denied. Rules apply to operations whose context carries a Principal (see WithPrincipal)
+// or else an actor (see WithActor). Every gRPC and GraphQL request gets a Principal from
+// interceptors.Authenticate, an anonymous one without authentication, which owns nothing, so
+// requests fail closed; operations without either, e.g. those of background workers, are not
+// restricted.
+type OwnershipRule struct {
+    Field      string   // Go field holding the owner's subject, e.g. "AuthorID".
+    Operations []string // Restricted operations among Create, Update, Delete and Read; defaults to Update and Delete.
+    AdminRoles []string // Roles exempt from the rule, e.g. "admin".
+}
+
+// ownership is a rule resolved against its model's schema.
+type ownership struct {
+    OwnershipRule
+    field      *schema.Field
+    operations map[string]bool
+}
+
+// SetOwnership declares the ownership rule of the type of model. Call it at startup, before the ORM
+// is shared. Creates by a restricted principal get its subject stored in Field when empty and are
+// rejected when Field names someone else; updates may not hand a record over to someone else.
+func (o *ORM) SetOwnership(model interface{}, rule OwnershipRule) error {
+    s, err := schema.Parse(reflect.New(recordType(model)).Interface(), &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        return err
+    }
+    field := s.LookUpField(rule.Field)
+    if field == nil {
+        return fmt.Errorf("ownership of %s: no field %s", s.Name, rule.Field)
+    }
+    operations := rule.Operations
+    if len(operations) == 0 {
+        operations = []string{"Update", "Delete"}
+    }
+    owned := &ownership{OwnershipRule: rule, field: field, operations: make(map[string]bool, len(operations))}
+    for _, name := range operations {
+        switch name {
+        case "Create", "Update", "Delete", "Read":
+            owned.operations[name] = true
+        default:
+            return fmt.Errorf("ownership of %s: unsupported operation %q", s.Name, name)
+        }
+    }
+    if o.ownership == nil {
+        o.ownership = make(map[reflect.Type]*ownership)
+    }
+    o.ownership[recordType(model)] = owned
+    return nil
+}
+
+// restrictingOwnership returns the rule restricting operation on model for the principal of the
+// ORM's context, or nil when the operation is unrestricted.
+func (o *ORM) restrictingOwnership(operation string, model interface{}) (*ownership, Principal) {
+    if model == nil {
+        return nil, Principal{}
+    }
+    return o.restrictingRule(operation, recordType(model))
+}
+
+// restrictingRule returns the rule restricting operation on the records of type t for the
+// principal of the ORM's context, or nil when the operation is unrestricted.
+func (o *ORM) restrictingRule(operation string, t reflect.Type) (*ownership, Principal) {
+    if len(o.ownership) == 0 {
+        return nil, Principal{}
+    }
+    rule, ok := o.ownership[t]
+    if !ok {
+        return nil, Principal{}
+    }
+    principal, ok := PrincipalFromContext(o.opContext())
+    if !ok {
+        principal.Subject = ActorFromContext(o.opContext())
+        ok = principal.Subject != ""
+    }
+    if !ok || !rule.operations[operation] {
+        return nil, Principal{}
+    }
+    for _, role := range principal.Roles {
+        for _, admin := range rule.AdminRoles {
+            if role == admin {
+                return nil, Principal{}
+            }
+        }
+    }
+    return rule, principal
+}
+
+// guardOwnership wraps the call of an operation with the checks of its model's ownership rule.
+// Reads of a single record are checked once read; ReadMany and the queries scope themselves with
+// ownerConditions, scopeQuery and scopeIndexQuery.
+func (o *ORM) guardOwnership(name string, model interface{}, key interface{}, call func() error) func() error {
+    operation := name
+    switch name {
+    case "Create", "Update", "Delete", "Read":
+    case "GetCache", "ReadForUpdate", "ReadLocalized", "ReadAsOf":
+        operation = "Read"
+    default:
+        return call
+    }
+    rule, principal := o.restrictingOwnership(operation, model)
+    if rule == nil {
+        return call
+    }
+    denied := &PermissionDeniedError{Subject: principal.Subject, Roles: principal.Roles, Entity: modelName(model), Operation: name}
+    return func() error {
+        switch name {
+        case "Create":
+            if err := rule.claim(model, principal.Subject); err != nil {
+                return err
+            }
+            if !rule.owns(model, principal.Subject) {
+                return denied
+            }
+        case "Update", "Delete":
+            if key == nil {
+                key, _ = ModelKey(model)
+            }
+            stored := reflect.New(indirectType(model)).Interface()
+            if err := o.readSource(o.PolicyFor(model), key, stored); err != nil {
+                return err
+            }
+            if !rule.owns(stored, principal.Subject) || name == "Update" && !rule.owns(model, principal.Subject) {
+                return denied
+            }
+        }
+        if err := call(); err != nil {
+            return err
+        }
+        if operation == "Read" && !rule.ownsAll(model, principal.Subject) {
+            // Others' records are reported missing, and not left in model for the caller to use.
+            rv := reflect.Indirect(reflect.ValueOf(model))
+            rv.Set(reflect.Zero(rv.Type()))
+            return utils.ErrNotFound
+        }
+        return nil
+    }
+}
+
+// ownerConditions returns the column conditions restricting a read of the records of model to the
+// principal's own, or nil when its reads are unrestricted.
+func (o *ORM) ownerConditions(model interface{}) map[string]interface{} {
+    rule, principal := o.restrictingOwnership("Read", model)
+    if rule == nil {
+        return nil
+    }
+    return map[string]interface{}{rule.field.DBName: principal.Subject}
+}
+
+// indexOwnership returns the rule restricting operation on the documents of index for the
+// principal of the ORM's context, and their model, or nil when the operation is unrestricted. The
+// model of an index is the one whose Policy names it.
+func (o *ORM) indexOwnership(operation, index string) (*ownership, Principal, reflect.Type) {
+    if len(o.ownership) == 0 || index == "" {
+        return nil, Principal{}, nil
+    }
+    for t, policy := range o.policies {
+        if policy.Index != index {
+            continue
+        }
+        if rule, principal := o.restrictingRule(operation, t); rule != nil {
+            return rule, principal, t
+        }
+    }
+    return nil, Principal{}, nil
+}
+
+// scopeIndexQuery returns clause, a query clause (nil matches all) over the documents of index
+// run by the operation name, restricted to the principal's own documents when an ownership rule
+// restricts operation on them. The documents must carry the owner field as an exact, searchable
+// value, e.g. `search:"keyword"`; otherwise a restricted principal is denied rather than shown
+// everything.
+func (o *ORM) scopeIndexQuery(name, operation, index string, clause map[string]interface{}) (map[string]interface{}, error) {
+    rule, principal, t := o.indexOwnership(operation, index)
+    if rule == nil {
+        return clause, nil
+    }
+    field, ok := ownerSearchField(t, rule)
+    if !ok {
+        return nil, &PermissionDeniedError{Subject: principal.Subject, Roles: principal.Roles, Entity: t.Name(), Operation: name}
+    }
+    must := []interface{}{}
+    if clause != nil {
+        must = append(must, clause)
+    }
+    return map[string]interface{}{"bool": map[string]interface{}{
+        "must":   must,
+        "filter": []interface{}{map[string]interface{}{"term": map[string]interface{}{field: principal.Subject}}},
+    }}, nil
+}
+
+// guardIndex denies the operation name on the documents of index to a principal an ownership rule
+// restricts, for operations that cannot be scoped to the principal's own documents.
+func (o *ORM) guardIndex(name, operation, index string) error {
+    rule, principal, t := o.indexOwnership(operation, index)
+    if rule == nil {
+        return nil
+    }
+    return &PermissionDeniedError{Subject: principal.Subject, Roles: principal.Roles, Entity: t.Name(), Operation: name}
+}
+
+// guardOwnerUpdate denies updates of fields that would hand the documents of index over to someone
+// else.
+func (o *ORM) guardOwnerUpdate(index string, fields map[string]interface{}) error {
+    rule, principal, t := o.indexOwnership("Update", index)
+    if rule == nil {
+        return nil
+    }
+    field, _ := ownerSearchField(t, rule)
+    if owner, ok := fields[field]; ok && fmt.Sprint(owner) != principal.Subject {
+        return &PermissionDeniedError{Subject: principal.Subject, Roles: principal.Roles, Entity: t.Name(), Operation: "UpdateByQuery"}
+    }
+    return nil
+}
+
+// scopeSearchBody returns body, a search request, with its query scoped like scopeIndexQuery.
+func (o *ORM) scopeSearchBody(index string, body map[string]interface{}) (map[string]interface{}, error) {
+    query, _ := body["query"].(map[string]interface{})
+    if rule, _, _ := o.indexOwnership("Read", index); rule == nil {
+        return body, nil
+    }
+    scoped, err := o.scopeIndexQuery("Search", "Read", index, query)
+    if err != nil {
+        return nil, err
+    }
+    out := make(map[string]interface{}, len(body)+1)
+    for k, v := range body {
+        out[k] = v
+    }
+    out["query"] = scoped
+    return out, nil
+}
+
+// ownerSearchField returns the name of the search document field holding the owner of the records
+// of t, if it is indexed as an exact value.
+func ownerSearchField(t reflect.Type, rule *ownership) (string, bool) {
+    for _, field := range searchFieldsOf(t) {
+        if t.FieldByIndex(field.index).Name != rule.field.Name {
+            continue
+        }
+        if field.mapping == nil || field.mapping["index"] == false || field.mapping["type"] == "text" {
+            return "", false
+        }
+        return field.name, true
+    }
+    return "", false
+}
+
+// scopeQuery returns qb restricted to the principal's own records when the ownership rule of model
+// scopes reads.
+func (o *ORM) scopeQuery(qb *utils.QueryBuilder, model interface{}) *utils.QueryBuilder {
+    rule, principal := o.restrictingOwnership("Read", model)
+    if rule == nil {
+        return qb
+    }
+    scoped := *qb
+    scoped.Conditions = make(map[string]interface{}, len(qb.Conditions)+1)
+    for field, value := range qb.Conditions {
+        scoped.Conditions[field] = value
+    }
+    scoped.Conditions[rule.field.DBName] = principal.Subject
+    return &scoped
+}
+
+// owns reports whether the record model belongs to subject.
+func (r *ownership) owns(model interface{}, subject string) bool {
+    value, zero := r.field.ValueOf(context.Background(), reflect.Indirect(reflect.ValueOf(model)))
+    return !zero && fmt.Sprint(reflect.Indirect(reflect.ValueOf(value)).Interface()) == subject
+}
+
+// claim stores subject as the owner of model when it has none.
+func (r *ownership) claim(model interface{}, subject string) error {
+    rv := reflect.Indirect(reflect.ValueOf(model))
+    if _, zero := r.field.ValueOf(context.Background(), rv); !zero || subject == "" {
+        return nil
+    }
+    if err := r.field.Set(context.Background(), rv, subject); err != nil {
+        return fmt.Errorf("%w: owner %q of %s: %v", utils.ErrInvalidValue, subject, modelName(model), err)
+    }
+    return nil
+}
+
+// ownsAll reports whether model, a record or a slice of records, belongs entirely to subject.
+func (r *ownership) ownsAll(model interface{}, subject string) bool {
+    rv := reflect.Indirect(reflect.ValueOf(model))
+    if rv.Kind() != reflect.Slice {
+        return r.owns(model, subject)
+    }
+    for i := 0; i < rv.Len(); i++ {
+        item := rv.Index(i)
+        if item.Kind() != reflect.Ptr {
+            item = item.Addr()
+        }
+        if !r.owns(item.Interface(), subject) {
+            return false
+        }
+    }
+    return true
+}
+
+// filterRecords removes the records not matching conditions, column -> value, from dest, a pointer
+// to a slice of models, for stores that cannot apply them in the query.
+func filterRecords(dest interface{}, conditions map[string]interface{}) {
+    slice := reflect.Indirect(reflect.ValueOf(dest))
+    if slice.Kind() != reflect.Slice {
+        return
+    }
+    s, err := schema.Parse(reflect.New(recordType(dest)).Interface(), &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        slice.SetLen(0)
+        return
+    }
+    kept := 0
+    for i := 0; i < slice.Len(); i++ {
+        item := reflect.Indirect(slice.Index(i))
+        matches := true
+        for column, want := range conditions {
+            field := s.LookUpField(column)
+            if field == nil {
+                matches = false
+                break
+            }
+            value, zero := field.ValueOf(context.Background(), item)
+            if zero || fmt.Sprint(reflect.Indirect(reflect.ValueOf(value)).Interface()) != fmt.Sprint(want) {
+                matches = false
+                break
+            }
+        }
+        if matches {
+            slice.Index(kept).Set(slice.Index(i))
+            kept++
+        }
+    }
+    slice.SetLen(kept)
+}
@@ -0,0 +1,272 @@
+package orm
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+)
+
+// CountStrategy chooses how a page reports the total number of results.
+type CountStrategy string
+
+const (
+    // CountNone reports no total, the cheapest option for infinite scrolling.
+    CountNone CountStrategy = "none"
+    // CountExact runs COUNT(*) in SQL and tracks every hit in Elasticsearch.
+    CountExact CountStrategy = "exact"
+    // CountEstimated answers from table statistics when the query has no conditions, and otherwise
+    // counts at most PaginationPolicy.CountCap results, reporting a lower bound beyond it.
+    // Elasticsearch tracks hits up to the cap likewise.
+    CountEstimated CountStrategy = "estimated"
+)
+
+// Relations of a Pagination's total to the actual number of results.
+const (
+    TotalExact      = "eq"
+    TotalLowerBound = "gte"
+    TotalEstimate   = "estimate"
+)
+
+// PaginationPolicy sets the defaults of paged queries.
+type PaginationPolicy struct {
+    DefaultSize int // Page size when the request gives none.
+    MaxSize     int // Larger requested sizes are reduced to it.
+    Count       CountStrategy
+    CountCap    int // Results counted at most by CountEstimated.
+}
+
+// DefaultPaginationPolicy pages by 20, up to 100, and estimates totals up to 10000.
+var DefaultPaginationPolicy = PaginationPolicy{
+    DefaultSize: 20,
+    MaxSize:     100,
+    Count:       CountEstimated,
+    CountCap:    10000,
+}
+
+// PageRequest asks for one page of results: the first when Token is empty, otherwise the one after
+// the page that returned it. Size and Count default to the ORM's PaginationPolicy.
+type PageRequest struct {
+    Token string
+    Size  int
+    Count CountStrategy
+}
+
+// Pagination describes a page of results. NextToken is empty on the last page. Total is nil when
+// not counted, with TotalRelation telling whether it is exact, a lower bound or an estimate.
+type Pagination struct {
+    Size          int    `json:"page_size"`
+    NextToken     string `json:"next_page_token,omitempty"`
+    Total         *int64 `json:"total,omitempty"`
+    TotalRelation string `json:"total_relation,omitempty"`
+}
+
+// pageToken is the content of a page token.
+type pageToken struct {
+    Offset int `json:"o"`
+}
+
+// resolve applies the policy's defaults to page and decodes its token into an offset.
+func (p PaginationPolicy) resolve(page PageRequest) (size, offset int, count CountStrategy, err error) {
+    size, count = page.Size, page.Count
+    if size <= 0 {
+        size = p.DefaultSize
+    }
+    if size <= 0 {
+        size = DefaultPaginationPolicy.DefaultSize
+    }
+    if p.MaxSize > 0 && size > p.MaxSize {
+        size = p.MaxSize
+    }
+    if count == "" {
+        count = p.Count
+    }
+    if count == "" {
+        count = CountNone
+    }
+    switch count {
+    case CountNone, CountExact, CountEstimated:
+    default:
+        return 0, 0, "", fmt.Errorf("%w: count strategy %q (expected none, exact or estimated)", utils.ErrInvalidValue, count)
+    }
+    if page.Token != "" {
+        data, decodeErr := base64.RawURLEncoding.DecodeString(page.Token)
+        var token pageToken
+        if decodeErr != nil || json.Unmarshal(data, &token) != nil || token.Offset < 0 {
+            return 0, 0, "", fmt.Errorf("%w: page token %q", utils.ErrInvalidValue, page.Token)
+        }
+        offset = token.Offset
+    }
+    return size, offset, count, nil
+}
+
+// countCap returns the policy's CountCap or its default.
+func (p PaginationPolicy) countCap() int {
+    if p.CountCap > 0 {
+        return p.CountCap
+    }
+    return DefaultPaginationPolicy.CountCap
+}
+
+// encodePageToken returns the token of the page starting at offset.
+func encodePageToken(offset int) string {
+    data, _ := json.Marshal(pageToken{Offset: offset})
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// SearchSQLPage runs queryBuilder like SearchSQL for one page of results, whose limit and offset
+// replace the builder's, and counts the total as the page's CountStrategy asks:
+//
+//     var products []models.Product
+//     page, err := o.SearchSQLPage(utils.NewQueryBuilder().Where("category", "books").Sort("id"),
+//         orm.PageRequest{Token: req.PageToken, Size: 50}, &products)
+//
+// Sort by a unique column so pages neither repeat nor skip records.
+func (o *ORM) SearchSQLPage(queryBuilder *utils.QueryBuilder, page PageRequest, dest interface{}) (Pagination, error) {
+    size, offset, count, err := o.Pagination.resolve(page)
+    if err != nil {
+        return Pagination{}, err
+    }
+    paged := *queryBuilder
+    paged.Limit, paged.Offset = size+1, offset // One extra row tells whether a next page exists.
+    if err := o.SearchSQL(&paged, dest); err != nil {
+        return Pagination{}, err
+    }
+    result := Pagination{Size: size}
+    rows := reflect.ValueOf(dest).Elem()
+    if rows.Len() > size {
+        rows.Set(rows.Slice(0, size))
+        result.NextToken = encodePageToken(offset + size)
+    }
+    if count == CountNone {
+        return result, nil
+    }
+    err = o.invoke("SearchSQL", BackendSQL, dest, "", func() error {
+        db, err := o.modelDB(dest)
+        if err != nil {
+            return err
+        }
+        total, relation, err := db.countSQL(o.scopeQuery(queryBuilder, dest), dest, count)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SearchSQLPage Count", "strategy": count})
+            return utils.HandleSQLError(err)
+        }
+        result.Total, result.TotalRelation = &total, relation
+        return nil
+    })
+    return result, err
+}
+
+// countSQL counts the rows matching queryBuilder in the table of model, in the ORM's database.
+func (o *ORM) countSQL(queryBuilder *utils.QueryBuilder, model interface{}, count CountStrategy) (int64, string, error) {
+    db := o.SQL.GetDB()
+    if db == nil {
+        return 0, "", errNoGormDB
+    }
+    table, err := tableName(db, model)
+    if err != nil {
+        return 0, "", err
+    }
+    reader := o.reader()
+    if count == CountEstimated && len(queryBuilder.Conditions) == 0 {
+        if estimate, ok := estimateRows(reader, db.Dialector.Name(), table); ok {
+            return estimate, TotalEstimate, nil
+        }
+    }
+    where := utils.QueryBuilder{Conditions: queryBuilder.Conditions}
+    clause, params := where.ToSQL()
+    quoted := db.Statement.Quote(table)
+    query := fmt.Sprintf("SELECT COUNT(*) FROM %s %s", quoted, clause)
+    limit := 0
+    if count == CountEstimated {
+        limit = o.Pagination.countCap()
+        query = fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s %s LIMIT %d) AS capped", quoted, clause, limit+1)
+    }
+    var total int64
+    if err := reader.RawQuery(query, params, &total); err != nil {
+        return 0, "", err
+    }
+    if limit > 0 && total > int64(limit) {
+        return int64(limit), TotalLowerBound, nil
+    }
+    return total, TotalExact, nil
+}
+
+// estimateRows reads the row count of table from the database's statistics, which may lag behind
+// recent writes.
+func estimateRows(reader adapters.SQLStore, dialect, table string) (int64, bool) {
+    var query string
+    switch dialect {
+    case "postgres":
+        query = "SELECT reltuples FROM pg_class WHERE oid = to_regclass(?)"
+    case "mysql":
+        query = "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
+    default:
+        return 0, false
+    }
+    // Stays -1 for unknown tables; Postgres also reports -1 for tables never analyzed.
+    estimate := -1.0
+    if err := reader.RawQuery(query, []interface{}{table}, &estimate); err != nil || estimate < 0 {
+        return 0, false
+    }
+    return int64(estimate), true
+}
+
+// SearchPage runs query, an Elasticsearch request body, like Search for one page of results, whose
+// from and size replace the query's. The total comes from the response's hits.total, tracked as
+// the page's CountStrategy asks. Elasticsearch pages no deeper than its max_result_window, 10000
+// results by default.
+func (o *ORM) SearchPage(index string, query map[string]interface{}, page PageRequest, result interface{}) (Pagination, error) {
+    size, offset, count, err := o.Pagination.resolve(page)
+    if err != nil {
+        return Pagination{}, err
+    }
+    paged := make(map[string]interface{}, len(query)+3)
+    for key, value := range query {
+        paged[key] = value
+    }
+    paged["from"], paged["size"] = offset, size
+    switch count {
+    case CountExact:
+        paged["track_total_hits"] = true
+    case CountEstimated:
+        paged["track_total_hits"] = o.Pagination.countCap()
+    default:
+        paged["track_total_hits"] = false
+    }
+    var raw json.RawMessage
+    if err := o.Search(index, paged, &raw); err != nil {
+        return Pagination{}, err
+    }
+    var hits struct {
+        Hits struct {
+            Total *struct {
+                Value    int64  `json:"value"`
+                Relation string `json:"relation"`
+            } `json:"total"`
+            Hits []json.RawMessage `json:"hits"`
+        } `json:"hits"`
+    }
+    if err := json.Unmarshal(raw, &hits); err != nil {
+        return Pagination{}, err
+    }
+    if err := json.Unmarshal(raw, result); err != nil {
+        return Pagination{}, err
+    }
+
+    pagination := Pagination{Size: size}
+    more := len(hits.Hits.Hits) == size
+    if total := hits.Hits.Total; total != nil && count != CountNone {
+        value := total.Value
+        pagination.Total, pagination.TotalRelation = &value, total.Relation
+        if total.Relation == TotalExact {
+            more = int64(offset+size) < total.Value
+        }
+    }
+    if more {
+        pagination.NextToken = encodePageToken(offset + size)
+    }
+    return pagination, nil
+}
@@ -0,0 +1,207 @@
+package orm
+
+import (
+    "context"
+    "fmt"
+    "persistence-layer/utils"
+    "sort"
+    "strings"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+const partitionNameLayout = "2006_01"
+
+// PartitionSpec describes a table that is range-partitioned by month on a time column.
+// The table itself must already be declared as partitioned on that column:
+//
+//     Postgres: CREATE TABLE comments (...) PARTITION BY RANGE (created_at)
+//     MySQL:    CREATE TABLE comments (...) PARTITION BY RANGE (TO_DAYS(created_at)) (...)
+type PartitionSpec struct {
+    Table         string
+    PremakeMonths int    // Number of upcoming months to create ahead of time. Defaults to 2.
+    RetainMonths  int    // Partitions older than this many months are dropped. Zero keeps everything.
+}
+
+// partitionDialect abstracts the DDL needed to manage monthly partitions on a specific database.
+type partitionDialect interface {
+    listPartitions(db *gorm.DB, table string) ([]string, error)
+    createPartition(db *gorm.DB, spec PartitionSpec, month time.Time) error
+    dropPartition(db *gorm.DB, spec PartitionSpec, name string) error
+}
+
+// Partitioner pre-creates upcoming monthly partitions and drops expired ones.
+type Partitioner struct {
+    orm   *ORM
+    specs []PartitionSpec
+}
+
+// NewPartitioner creates a Partitioner for the given tables.
+func NewPartitioner(o *ORM, specs ...PartitionSpec) *Partitioner {
+    return &Partitioner{orm: o, specs: specs}
+}
+
+// Run executes Maintain every interval until the context is cancelled.
+func (p *Partitioner) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        _ = p.Maintain(time.Now())
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Maintain rotates the partitions of every table relative to now and returns the first error encountered.
+func (p *Partitioner) Maintain(now time.Time) error {
+    if p.orm.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    db := p.orm.SQL.GetDB()
+    if db == nil {
+        return errNoGormDB
+    }
+    dialect, err := partitionDialectFor(db)
+    if err != nil {
+        return err
+    }
+
+    var firstErr error
+    for _, spec := range p.specs {
+        if err := rotatePartitions(db, dialect, spec, now); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RotatePartitions", "table": spec.Table})
+            if firstErr == nil {
+                firstErr = err
+            }
+        }
+    }
+    return firstErr
+}
+
+// rotatePartitions creates any missing partitions from the current month up to the premake horizon
+// and drops partitions that fall entirely before the retention window.
+func rotatePartitions(db *gorm.DB, dialect partitionDialect, spec PartitionSpec, now time.Time) error {
+    if spec.PremakeMonths <= 0 {
+        spec.PremakeMonths = 2
+    }
+
+    existing, err := dialect.listPartitions(db, spec.Table)
+    if err != nil {
+        return err
+    }
+    have := make(map[string]bool, len(existing))
+    for _, name := range existing {
+        have[name] = true
+    }
+
+    current := startOfMonth(now)
+    for i := 0; i <= spec.PremakeMonths; i++ {
+        month := current.AddDate(0, i, 0)
+        if have[PartitionName(spec.Table, month)] {
+            continue
+        }
+        if err := dialect.createPartition(db, spec, month); err != nil {
+            return err
+        }
+        utils.LogInfo("Partition created successfully", map[string]interface{}{"table": spec.Table, "partition": PartitionName(spec.Table, month)})
+    }
+
+    if spec.RetainMonths <= 0 {
+        return nil
+    }
+    oldest := current.AddDate(0, -spec.RetainMonths, 0)
+    sort.Strings(existing)
+    for _, name := range existing {
+        month, ok := partitionMonth(spec.Table, name)
+        if !ok || !month.Before(oldest) {
+            continue
+        }
+        if err := dialect.dropPartition(db, spec, name); err != nil {
+            return err
+        }
+        utils.LogInfo("Partition dropped successfully", map[string]interface{}{"table": spec.Table, "partition": name})
+    }
+    return nil
+}
+
+// PartitionName returns the conventional name of the partition holding the given month, e.g. comments_p2024_05.
+func PartitionName(table string, month time.Time) string {
+    return fmt.Sprintf("%s_p%s", table, month.Format(partitionNameLayout))
+}
+
+// partitionMonth parses the month back out of a partition name created by PartitionName.
+func partitionMonth(table, name string) (time.Time, bool) {
+    prefix := table + "_p"
+    if !strings.HasPrefix(name, prefix) {
+        return time.Time{}, false
+    }
+    month, err := time.Parse(partitionNameLayout, strings.TrimPrefix(name, prefix))
+    if err != nil {
+        return time.Time{}, false
+    }
+    return month, true
+}
+
+func startOfMonth(t time.Time) time.Time {
+    t = t.UTC()
+    return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func partitionDialectFor(db *gorm.DB) (partitionDialect, error) {
+    switch db.Dialector.Name() {
+    case "postgres":
+        return postgresPartitions{}, nil
+    case "mysql":
+        return mysqlPartitions{}, nil
+    default:
+        return nil, fmt.Errorf("partitioning is not supported for dialect %q", db.Dialector.Name())
+    }
+}
+
+// postgresPartitions manages declarative partitions, which are tables attached to their parent.
+type postgresPartitions struct{}
+
+func (postgresPartitions) listPartitions(db *gorm.DB, table string) ([]string, error) {
+    var names []string
+    err := db.Raw(`SELECT c.relname FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        JOIN pg_class p ON p.oid = i.inhparent
+        WHERE p.relname = ?`, table).Scan(&names).Error
+    return names, err
+}
+
+func (postgresPartitions) createPartition(db *gorm.DB, spec PartitionSpec, month time.Time) error {
+    ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+        PartitionName(spec.Table, month), spec.Table,
+        month.Format("2006-01-02"), month.AddDate(0, 1, 0).Format("2006-01-02"))
+    return db.Exec(ddl).Error
+}
+
+func (postgresPartitions) dropPartition(db *gorm.DB, spec PartitionSpec, name string) error {
+    return db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", name)).Error
+}
+
+// mysqlPartitions manages RANGE partitions over TO_DAYS(column). MySQL only allows adding
+// partitions at the end of the range, so tables must not define a MAXVALUE catch-all partition.
+type mysqlPartitions struct{}
+
+func (mysqlPartitions) listPartitions(db *gorm.DB, table string) ([]string, error) {
+    var names []string
+    err := db.Raw(`SELECT PARTITION_NAME FROM information_schema.PARTITIONS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL`, table).Scan(&names).Error
+    return names, err
+}
+
+func (mysqlPartitions) createPartition(db *gorm.DB, spec PartitionSpec, month time.Time) error {
+    ddl := fmt.Sprintf("ALTER TABLE %s ADD PARTITION (PARTITION %s VALUES LESS THAN (TO_DAYS('%s')))",
+        spec.Table, PartitionName(spec.Table, month), month.AddDate(0, 1, 0).Format("2006-01-02"))
+    return db.Exec(ddl).Error
+}
+
+func (mysqlPartitions) dropPartition(db *gorm.DB, spec PartitionSpec, name string) error {
+    return db.Exec(fmt.Sprintf("ALTER TABLE %s DROP PARTITION %s", spec.Table, name)).Error
+}
@@ -0,0 +1,336 @@
+package orm
+
+import (
+    "fmt"
+    "math/rand"
+    "persistence-layer/utils"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
+
+    "gorm.io/gorm/schema"
+)
+
+const (
+    // DefaultCacheTTL is the cache lifetime of records whose Policy caches them without a CacheTTL
+    // and whose model declares none.
+    DefaultCacheTTL = 10 * time.Minute
+    // DefaultCacheJitter is the default of ORM.CacheJitter.
+    DefaultCacheJitter = 0.1
+)
+
+// Policy declares where the records of a model live. The ORM applies it in Create, Update,
+// Delete and Read, so services no longer decide per call which backends to touch:
+//
+//     o.SetPolicy(&models.Product{}, orm.Policy{SQL: true, Cache: true, CacheTTL: 5 * time.Minute, Index: "products", AsyncIndex: true})
+//     o.SetPolicy(&models.AuditLog{}, orm.Policy{Mongo: true, Collection: "audit_logs"})
+//
+// Models without a policy follow DefaultPolicy. A model may declare its default cache TTL with a
+// cache tag on a blank field:
+//
+//     type Product struct {
+//         _ struct{} `cache:"ttl=5m"`
+//         ...
+//     }
+type Policy struct {
+    SQL        bool          // Store rows in the SQL database; SQL is the source of truth when set.
+    Mongo      bool          // Store documents in MongoDB, keyed by "_id".
+    Collection string        // Mongo collection; defaults to the table name.
+    Cache      bool          // Read through Redis, invalidating on every write.
+    CacheTTL   time.Duration // Defaults to the model's cache tag, then DefaultCacheTTL.
+    Index      string        // Elasticsearch index kept in sync on writes; empty disables indexing.
+    // AsyncIndex records index changes of SQL writes in the outbox, in the write's transaction,
+    // for an OutboxRelay to apply, instead of indexing before the write returns.
+    AsyncIndex bool
+    // Database names the SQL database holding the model's table, added with AddDatabase; empty
+    // for DefaultDatabase.
+    Database string
+    // Shards spreads the rows over these databases by a hash of the ShardKey column, e.g.
+    // "user_id" or "ID". Writes need the shard key set; reads locate rows by the primary key when
+    // it is the shard key and ask every shard otherwise, so a ShardKey other than the primary key
+    // needs keys unique across shards, e.g. UUIDs or IDs tagged autoIncrement:false.
+    Shards   []string
+    ShardKey string
+}
+
+// DefaultPolicy stores records in SQL only.
+var DefaultPolicy = Policy{SQL: true}
+
+// SetPolicy declares the policy of the type of model, e.g. &models.Product{}. Call it at startup,
+// before the ORM is shared.
+func (o *ORM) SetPolicy(model interface{}, policy Policy) error {
+    if !policy.SQL && !policy.Mongo {
+        return fmt.Errorf("policy for %s stores records in no backend", modelName(model))
+    }
+    if len(policy.Shards) > 0 {
+        if policy.Database != "" || policy.ShardKey == "" {
+            return fmt.Errorf("policy for %s needs a shard key and no database besides its shards", modelName(model))
+        }
+        field, err := shardField(policy, model)
+        if err != nil {
+            return fmt.Errorf("policy for %s: %w", modelName(model), err)
+        }
+        // Reads by primary key ask every shard and take the first row found, which is only the
+        // right one when keys are unique across shards, unlike each shard's auto-increment IDs.
+        if pk := field.Schema.PrioritizedPrimaryField; !field.PrimaryKey && pk != nil && pk.AutoIncrement {
+            return fmt.Errorf("policy for %s: sharding by %s needs primary keys unique across shards, not auto-increment %s", modelName(model), policy.ShardKey, pk.Name)
+        }
+    }
+    for _, name := range append([]string{policy.Database}, policy.Shards...) {
+        if name == "" || name == DefaultDatabase {
+            continue
+        }
+        if _, ok := o.databases[name]; !ok {
+            return fmt.Errorf("policy for %s: %w: %s", modelName(model), ErrUnknownDatabase, name)
+        }
+    }
+    if o.policies == nil {
+        o.policies = make(map[reflect.Type]Policy)
+    }
+    o.policies[recordType(model)] = policy
+    return nil
+}
+
+// PolicyFor returns the policy of model's type, resolving defaults.
+func (o *ORM) PolicyFor(model interface{}) Policy {
+    policy, ok := o.policies[recordType(model)]
+    if !ok {
+        policy = DefaultPolicy
+    }
+    if policy.Mongo && policy.Collection == "" {
+        policy.Collection = schema.NamingStrategy{}.TableName(indirectType(model).Name())
+    }
+    if ttl, ok := o.cacheTTLs.get(recordType(model)); ok {
+        policy.CacheTTL = ttl
+    }
+    if policy.Cache && policy.CacheTTL <= 0 {
+        policy.CacheTTL = modelCacheTTL(indirectType(model))
+    }
+    return policy
+}
+
+// cacheTTLs holds the cache TTLs changed at runtime, overriding those of policies.
+type cacheTTLs struct {
+    mu   sync.RWMutex
+    ttls map[reflect.Type]time.Duration
+}
+
+func (c *cacheTTLs) get(t reflect.Type) (time.Duration, bool) {
+    if c == nil {
+        return 0, false
+    }
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    ttl, ok := c.ttls[t]
+    return ttl, ok
+}
+
+// SetCacheTTL changes the cache TTL of the type of model, e.g. on a configuration reload; 0 restores
+// the TTL of its policy. Unlike SetPolicy it is safe to call while the ORM is in use. Entries
+// already cached keep their TTL.
+func (o *ORM) SetCacheTTL(model interface{}, ttl time.Duration) {
+    if o.cacheTTLs == nil {
+        return
+    }
+    o.cacheTTLs.mu.Lock()
+    defer o.cacheTTLs.mu.Unlock()
+    if ttl <= 0 {
+        delete(o.cacheTTLs.ttls, recordType(model))
+        return
+    }
+    if o.cacheTTLs.ttls == nil {
+        o.cacheTTLs.ttls = make(map[reflect.Type]time.Duration)
+    }
+    o.cacheTTLs.ttls[recordType(model)] = ttl
+}
+
+// modelCacheTTL returns the TTL declared by the cache tag of a field of t, or DefaultCacheTTL.
+func modelCacheTTL(t reflect.Type) time.Duration {
+    if t.Kind() != reflect.Struct {
+        return DefaultCacheTTL
+    }
+    for i := 0; i < t.NumField(); i++ {
+        tag, ok := t.Field(i).Tag.Lookup("cache")
+        if !ok {
+            continue
+        }
+        for _, option := range strings.Split(tag, ",") {
+            if value := strings.TrimPrefix(option, "ttl="); value != option {
+                if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+                    return ttl
+                }
+            }
+        }
+    }
+    return DefaultCacheTTL
+}
+
+// jitter shortens ttl by a random share of up to o.CacheJitter, so entries cached together, e.g.
+// by a warm-up or a bulk read, don't all expire at once. The result never exceeds ttl.
+func (o *ORM) jitter(ttl time.Duration) time.Duration {
+    if ttl <= 0 || o.CacheJitter <= 0 {
+        return ttl
+    }
+    return ttl - time.Duration(rand.Float64()*o.CacheJitter*float64(ttl))
+}
+
+// backend is the backend reported to middleware: the source of truth of the model.
+func (p Policy) backend() string {
+    if p.SQL {
+        return BackendSQL
+    }
+    return BackendMongo
+}
+
+// checkMongoKey rejects writes of records stored in Mongo that cannot be keyed there, before any
+// backend is written.
+func checkMongoKey(policy Policy, key interface{}, model interface{}) error {
+    if !policy.Mongo || key != nil {
+        return nil
+    }
+    _, err := ModelKey(model)
+    return err
+}
+
+// afterWrite brings the other backends of a record in line with its SQL write once the write
+// commits: the Mongo copy of records stored in both (see syncMongo), then the cache and search
+// index (see propagate). Within a WithTransaction callback they run when the transaction commits
+// and are reported queued, and are dropped when it, or the savepoint of the write, rolls back.
+// Writes of records stored only in Mongo are not transactional, so their effects follow at once.
+func (o *ORM) afterWrite(operation string, policy Policy, key interface{}, model interface{}) (mongo, cache, index SideEffect) {
+    if o.tx == nil || !policy.SQL {
+        mongo = o.syncMongo(operation, policy, key, model)
+        cache, index = o.propagate(operation, policy, key, model)
+        return mongo, cache, index
+    }
+    // The caller may reuse model before the transaction commits; apply the record as written.
+    snapshot := reflect.New(indirectType(model))
+    snapshot.Elem().Set(reflect.Indirect(reflect.ValueOf(model)))
+    committed := o.committed()
+    o.tx.afterTransaction(func(ok bool) {
+        if ok {
+            committed.syncMongo(operation, policy, key, snapshot.Interface())
+            committed.propagate(operation, policy, key, snapshot.Interface())
+        }
+    })
+    mongo, cache, index = SideEffectSkipped, SideEffectSkipped, SideEffectSkipped
+    if policy.Mongo {
+        mongo = SideEffectQueued
+    }
+    if policy.Cache && o.Redis != nil {
+        cache = SideEffectQueued
+    }
+    if policy.Index != "" && (o.Elasticsearch != nil || policy.AsyncIndex) {
+        index = SideEffectQueued
+    }
+    return mongo, cache, index
+}
+
+// committed returns the ORM of the database o's transaction runs on, for work following its commit.
+func (o *ORM) committed() *ORM {
+    c := o.clone()
+    c.tx = nil
+    if store := o.databases[o.databaseName()]; !isNil(store) {
+        c.SQL = store
+        if c.ctx != nil {
+            c.SQL = store.WithContext(c.ctx)
+        }
+    }
+    return c
+}
+
+// syncMongo writes the Mongo copy of a record stored in both SQL and Mongo after its SQL write
+// committed. SQL stays the source of truth: when Mongo fails, an outbox event is recorded for the
+// OutboxRelay to copy the record's SQL state to Mongo, or remove it there, until they agree.
+func (o *ORM) syncMongo(operation string, policy Policy, key interface{}, model interface{}) SideEffect {
+    if !policy.SQL || !policy.Mongo {
+        return SideEffectSkipped
+    }
+    // An Update matching nothing means the Mongo copy is missing; the repair writes it back.
+    if count, err := o.mongoWrite(operation, policy, key, model); err == nil && (count > 0 || operation == "Delete") {
+        return SideEffectDone
+    }
+    if err := o.enqueueMongoRepair(policy, key, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": operation + " Mongo Repair", "collection": policy.Collection, "id": key})
+        return SideEffectFailed
+    }
+    return SideEffectQueued
+}
+
+// mongoWrite applies a write to the model's Mongo collection and returns the number of documents
+// it matched: 1 for a Create, 0 or 1 for an Update or Delete.
+func (o *ORM) mongoWrite(operation string, policy Policy, key interface{}, model interface{}) (int64, error) {
+    if o.Mongo == nil {
+        return 0, backendDisabled(BackendMongo)
+    }
+    var err error
+    count := int64(1)
+    switch operation {
+    case "Create":
+        err = o.Mongo.Create(policy.Collection, model)
+    case "Update":
+        count, err = o.Mongo.Update(policy.Collection, map[string]interface{}{"_id": key}, model)
+    case "Delete":
+        count, err = o.Mongo.Delete(policy.Collection, map[string]interface{}{"_id": key})
+    }
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": operation, "collection": policy.Collection, "id": key})
+        return 0, utils.HandleMongoError(err)
+    }
+    return count, nil
+}
+
+// propagate brings the cache and search index of a record in line with a committed write and
+// reports what became of each. Failures are logged rather than returned: the write itself
+// succeeded, cached copies expire on their own and the index can be rebuilt. Asynchronously indexed
+// SQL writes were already queued in the outbox; with an IndexPool the others are queued in it.
+// Affected materialized views are marked for refresh.
+func (o *ORM) propagate(operation string, policy Policy, key interface{}, model interface{}) (cache, index SideEffect) {
+    cache, index = SideEffectSkipped, SideEffectSkipped
+    if policy.Cache && o.Redis != nil {
+        cache = SideEffectDone
+        if err := o.invalidate(model, key); err != nil {
+            cache = SideEffectFailed
+        }
+    }
+    if o.Views != nil {
+        o.Views.changed(model, key)
+    }
+    if policy.Index == "" {
+        return cache, index
+    }
+    if policy.AsyncIndex && policy.SQL {
+        return cache, SideEffectQueued
+    }
+    if o.Elasticsearch == nil {
+        return cache, index
+    }
+    if o.IndexPool != nil {
+        if err := o.IndexPool.submit(operation, policy, key, model); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": operation + " Index", "index": policy.Index, "id": key})
+            return cache, SideEffectFailed
+        }
+        return cache, SideEffectQueued
+    }
+    if err := o.syncIndex(operation, policy, key, model); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": operation + " Index", "index": policy.Index, "id": key})
+        return cache, SideEffectFailed
+    }
+    return cache, SideEffectDone
+}
+
+// syncIndex applies a write to the search index of the model's policy, if it has one.
+func (o *ORM) syncIndex(operation string, policy Policy, key interface{}, model interface{}) error {
+    if policy.Index == "" || o.Elasticsearch == nil {
+        return nil
+    }
+    if operation == "Delete" {
+        return o.Elasticsearch.DeleteDocument(policy.Index, &SearchDocument{Key: fmt.Sprint(key)})
+    }
+    doc, err := NewSearchDocument(model)
+    if err != nil {
+        return err
+    }
+    return o.Elasticsearch.IndexDocument(policy.Index, doc)
+}
@@ -0,0 +1,175 @@
+package orm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+)
+
+// ProjectionSource is a model whose changes alter the documents of a Projection, e.g. the author
+// of a post or its tags.
+type ProjectionSource struct {
+    Model interface{} // e.g. &models.Author{}.
+    // Roots returns the keys of the root records whose documents embed record, e.g. the posts of an
+    // author. Deletes carry the row before the change; updates pass the row before the change too,
+    // when the event has it (see ChangeEvent.Before).
+    Roots func(o *ORM, record interface{}) ([]interface{}, error)
+}
+
+// Projection is a denormalized Mongo read model with one document per record of its root model,
+// e.g. a post with its author's name and its tag names, so the hottest reads take a single
+// document instead of joins.
+type Projection struct {
+    Collection string      // e.g. "post_reads".
+    Root       interface{} // e.g. &models.Post{}.
+    Sources    []ProjectionSource
+    // Build reads the document of the root record with key from SQL. Its "_id" must be key. It
+    // returns utils.ErrNotFound when the record is gone, which removes the document.
+    Build func(o *ORM, key interface{}) (interface{}, error)
+}
+
+// Projector maintains the documents of its projections from SQL change events: a change to a root
+// record rebuilds its document, and a change to a source rebuilds the documents of the roots
+// embedding it. Register Handle with a CDCConsumer watching the root and source tables:
+//
+//     p := orm.NewProjector(o)
+//     err := p.Add(orm.Projection{Collection: "post_reads", Root: &models.Post{}, Build: buildPostRead,
+//         Sources: []orm.ProjectionSource{{Model: &models.Author{}, Roots: postsOfAuthor}}})
+//     c.OnChange(p.Handle)
+//
+//     var post PostRead
+//     err = o.ReadProjection("post_reads", id, &post)
+//
+// Events are delivered at least once, and rebuilding a document from the current rows is
+// idempotent, so redelivered or reordered events leave the documents correct.
+type Projector struct {
+    orm         *ORM
+    projections []*Projection
+}
+
+// NewProjector creates a projector with no projections.
+func NewProjector(o *ORM) *Projector {
+    return &Projector{orm: o}
+}
+
+// Add registers a projection. Call it at startup, before the projector handles events.
+func (p *Projector) Add(projection Projection) error {
+    if projection.Collection == "" || projection.Root == nil || projection.Build == nil {
+        return fmt.Errorf("%w: a projection needs a Collection, a Root and a Build function", utils.ErrInvalidValue)
+    }
+    for _, source := range projection.Sources {
+        if source.Model == nil || source.Roots == nil {
+            return fmt.Errorf("%w: projection %s has a source without a Model or Roots", utils.ErrInvalidValue, projection.Collection)
+        }
+    }
+    p.projections = append(p.projections, &projection)
+    return nil
+}
+
+// Handle rebuilds the documents affected by a change event. It has the signature of
+// CDCConsumer.OnChange handlers; an error makes the consumer deliver the event again. Truncates are
+// ignored: rebuild the projection with Rebuild afterwards.
+func (p *Projector) Handle(ctx context.Context, e ChangeEvent) error {
+    if e.Model == nil {
+        return nil
+    }
+    o := p.orm.WithContext(ctx)
+    t := indirectType(e.Model)
+    for _, projection := range p.projections {
+        var roots []interface{}
+        if indirectType(projection.Root) == t {
+            roots = append(roots, e.Key)
+        }
+        for _, source := range projection.Sources {
+            if indirectType(source.Model) != t {
+                continue
+            }
+            // An update may move the record to other roots, e.g. a post to another author: the
+            // roots of its prior state lose it.
+            for _, record := range []interface{}{e.Before, e.Model} {
+                if record == nil {
+                    continue
+                }
+                keys, err := source.Roots(o, record)
+                if err != nil {
+                    return fmt.Errorf("projection %s: finding the roots of %s %v: %w", projection.Collection, t.Name(), e.Key, err)
+                }
+                roots = append(roots, keys...)
+            }
+        }
+        done := make(map[string]bool, len(roots))
+        for _, key := range roots {
+            if done[fmt.Sprint(key)] {
+                continue
+            }
+            done[fmt.Sprint(key)] = true
+            if err := p.project(o, projection, key); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// Rebuild rebuilds the documents of the projection in collection for the root records with keys,
+// e.g. every key of the root table after deploying a new projection.
+func (p *Projector) Rebuild(ctx context.Context, collection string, keys ...interface{}) error {
+    o := p.orm.WithContext(ctx)
+    for _, projection := range p.projections {
+        if projection.Collection != collection {
+            continue
+        }
+        for _, key := range keys {
+            if err := p.project(o, projection, key); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    return fmt.Errorf("%w: no projection writes %s", utils.ErrInvalidValue, collection)
+}
+
+// project replaces the document of one root record with a fresh build, or removes it when the
+// record is gone. Stores that can replace a document in place do so, so readers never miss it.
+func (p *Projector) project(o *ORM, projection *Projection, key interface{}) error {
+    return o.invokeKeyed("Project", BackendMongo, projection.Root, key, projection.Collection, func() error {
+        if o.Mongo == nil {
+            return backendDisabled(BackendMongo)
+        }
+        doc, err := projection.Build(o, key)
+        if err != nil && !errors.Is(err, utils.ErrNotFound) {
+            utils.LogError(err, map[string]interface{}{"operation": "Project", "collection": projection.Collection, "id": key})
+            return err
+        }
+        filter := map[string]interface{}{"_id": o.typedKey(projection.Root, fmt.Sprint(key))}
+        gone := doc == nil || reflect.ValueOf(doc).Kind() == reflect.Ptr && reflect.ValueOf(doc).IsNil()
+        if replacer, ok := o.Mongo.(adapters.DocumentReplacer); ok && !gone {
+            err = replacer.ReplaceOne(projection.Collection, filter, doc, true)
+        } else if _, err = o.Mongo.Delete(projection.Collection, filter); err == nil && !gone {
+            err = o.Mongo.Create(projection.Collection, doc)
+        }
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Project", "collection": projection.Collection, "id": key})
+            return utils.HandleMongoError(err)
+        }
+        return nil
+    })
+}
+
+// ReadProjection reads the document of the root record with key from a projection's collection
+// into dest. It returns utils.ErrNotFound when the projector hasn't written it, e.g. before the
+// projection was rebuilt.
+func (o *ORM) ReadProjection(collection string, key interface{}, dest interface{}) error {
+    return o.invokeKeyed("ReadProjection", BackendMongo, dest, key, collection, func() error {
+        if o.Mongo == nil {
+            return backendDisabled(BackendMongo)
+        }
+        if err := o.Mongo.Read(collection, map[string]interface{}{"_id": key}, dest); err != nil {
+            return utils.HandleMongoError(err)
+        }
+        return nil
+    })
+}
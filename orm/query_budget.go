@@ -0,0 +1,125 @@
+package orm
+
+import (
+    "context"
+    "fmt"
+    "path"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+)
+
+type requestCallsKey struct{}
+
+// RequestCalls counts the ORM operations made on behalf of one request, e.g. one RPC, by the call
+// site outside the persistence layer that made them, to spot N+1 patterns: a service reading the
+// rows of a list one by one shows up as one site with as many calls as the list has rows.
+type RequestCalls struct {
+    mu    sync.Mutex
+    total int
+    sites map[CallSite]int
+}
+
+// CallSite is where a service called the ORM, e.g. "services/post_service.go:42", and the operation
+// it called there.
+type CallSite struct {
+    Site      string `json:"site"`
+    Operation string `json:"operation"`
+}
+
+// CallSiteCount is the number of operations made at a CallSite.
+type CallSiteCount struct {
+    CallSite
+    Calls int `json:"calls"`
+}
+
+// WithRequestCalls returns a context in which CountCalls counts the operations of ORMs derived with
+// it, and the counter.
+func WithRequestCalls(ctx context.Context) (context.Context, *RequestCalls) {
+    calls := &RequestCalls{sites: make(map[CallSite]int)}
+    return context.WithValue(ctx, requestCallsKey{}, calls), calls
+}
+
+// RequestCallsFromContext returns the counter set by WithRequestCalls, or nil when there is none.
+func RequestCallsFromContext(ctx context.Context) *RequestCalls {
+    if ctx == nil {
+        return nil
+    }
+    calls, _ := ctx.Value(requestCallsKey{}).(*RequestCalls)
+    return calls
+}
+
+// Total returns the number of operations counted.
+func (c *RequestCalls) Total() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.total
+}
+
+// Top returns the n call sites that made the most operations, most first.
+func (c *RequestCalls) Top(n int) []CallSiteCount {
+    c.mu.Lock()
+    top := make([]CallSiteCount, 0, len(c.sites))
+    for site, calls := range c.sites {
+        top = append(top, CallSiteCount{CallSite: site, Calls: calls})
+    }
+    c.mu.Unlock()
+    sort.Slice(top, func(i, j int) bool {
+        if top[i].Calls != top[j].Calls {
+            return top[i].Calls > top[j].Calls
+        }
+        return top[i].Site < top[j].Site
+    })
+    if n > 0 && len(top) > n {
+        top = top[:n]
+    }
+    return top
+}
+
+func (c *RequestCalls) add(site CallSite) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.total++
+    c.sites[site]++
+}
+
+// CountCalls returns middleware counting every operation whose context carries a RequestCalls,
+// attributed to the first caller outside the persistence layer's own packages. Services must run
+// their operations through an ORM derived with the request context, e.g. o.WithContext(ctx).
+func CountCalls() Middleware {
+    return func(next Handler) Handler {
+        return func(op *Operation) error {
+            if calls := RequestCallsFromContext(op.Context); calls != nil {
+                calls.add(CallSite{Site: callSite(), Operation: op.Name})
+            }
+            return next(op)
+        }
+    }
+}
+
+// Packages whose frames are skipped when attributing an operation to its call site.
+var layerPackages = []string{"persistence-layer/orm.", "persistence-layer/adapters.", "persistence-layer/dataloader.", "runtime.", "gorm.io/"}
+
+// callSite returns the file and line of the first frame outside layerPackages, e.g.
+// "services/post_service.go:42", or "unknown".
+func callSite() string {
+    var pcs [32]uintptr
+    frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+    for {
+        frame, more := frames.Next()
+        inLayer := false
+        for _, pkg := range layerPackages {
+            if strings.HasPrefix(frame.Function, pkg) {
+                inLayer = true
+                break
+            }
+        }
+        if !inLayer && frame.Function != "" {
+            return fmt.Sprintf("%s/%s:%d", path.Base(path.Dir(frame.File)), path.Base(frame.File), frame.Line)
+        }
+        if !more {
+            return "unknown"
+        }
+    }
+}
@@ -0,0 +1,320 @@
+package orm
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context that attributes ORM operations to tenant for usage accounting.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+    return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or "" when there is none.
+func TenantFromContext(ctx context.Context) string {
+    if ctx == nil {
+        return ""
+    }
+    tenant, _ := ctx.Value(tenantKey{}).(string)
+    return tenant
+}
+
+// Usage is the resource consumption recorded for one tenant. Requests are those of the current
+// window; the rest accrue over the tenant's lifetime.
+type Usage struct {
+    Requests     int64            `json:"requests"`
+    ByBackend    map[string]int64 `json:"by_backend"` // Requests of the current window by backend.
+    Rows         int64            `json:"rows"`
+    StorageBytes int64            `json:"storage_bytes"` // Estimated from the JSON size of created rows.
+    CacheBytes   int64            `json:"cache_bytes"`   // Size of values currently set through SetCache.
+}
+
+// Quota limits a tenant's usage. Zero fields are unlimited.
+type Quota struct {
+    MaxRequests     int64 // Per UsageTracker.Window.
+    MaxRows         int64
+    MaxStorageBytes int64
+    MaxCacheBytes   int64
+}
+
+// QuotaExceededError is returned when an operation would take a tenant over its quota.
+// It matches utils.ErrQuotaExceeded with errors.Is.
+type QuotaExceededError struct {
+    Tenant   string
+    Resource string // "requests", "rows", "storage_bytes" or "cache_bytes".
+    Limit    int64
+    Used     int64
+}
+
+func (e *QuotaExceededError) Error() string {
+    return fmt.Sprintf("tenant %q exceeded its %s quota (%d of %d)", e.Tenant, e.Resource, e.Used, e.Limit)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+    return target == utils.ErrQuotaExceeded
+}
+
+// DefaultUsageWindow is the window requests are counted over when UsageTracker.Window is unset.
+const DefaultUsageWindow = 24 * time.Hour
+
+// UsageTracker counts requests, rows, storage and cache bytes per tenant through its Middleware and
+// optionally enforces quotas. The counters live in the cache backend, so every instance shares
+// them and they survive restarts: requests per Window, the rest over the tenant's lifetime. Each
+// operation reserves its usage atomically before it runs and gives back what it didn't use, so
+// concurrent operations can't overshoot a quota together. Operations without a tenant in their
+// context, such as background jobs, are not tracked; interceptors.Tenant rejects calls without
+// one when quotas are enforced.
+type UsageTracker struct {
+    Window time.Duration
+
+    cache        adapters.CacheStore
+    counter      adapters.UsageCounter
+    defaultQuota Quota
+    quotas       map[string]Quota
+    enforce      bool
+    registered   sync.Map // Tenants this process has added to the tenant index.
+}
+
+// NewUsageTracker creates a tracker counting in cache, which must implement adapters.UsageCounter.
+// When enforce is true, operations that would exceed the tenant's quota (or defaultQuota for
+// tenants without their own) fail with a *QuotaExceededError.
+func NewUsageTracker(cache adapters.CacheStore, defaultQuota Quota, quotas map[string]Quota, enforce bool) (*UsageTracker, error) {
+    counter, ok := cache.(adapters.UsageCounter)
+    if !ok {
+        return nil, fmt.Errorf("usage tracking: %T cannot count usage", cache)
+    }
+    if quotas == nil {
+        quotas = map[string]Quota{}
+    }
+    return &UsageTracker{
+        Window:       DefaultUsageWindow,
+        cache:        cache,
+        counter:      counter,
+        defaultQuota: defaultQuota,
+        quotas:       quotas,
+        enforce:      enforce,
+    }, nil
+}
+
+// Cache keys of the usage counters.
+const (
+    usageTenantsKey = "quota:tenants"
+    usagePrefix     = "quota:"
+)
+
+// Middleware returns the ORM middleware that performs the accounting. Register it with ORM.Use.
+func (t *UsageTracker) Middleware() Middleware {
+    return func(next Handler) Handler {
+        return func(op *Operation) error {
+            tenant := TenantFromContext(op.Context)
+            if tenant == "" {
+                return next(op)
+            }
+            size := int64(0)
+            if op.Name == "Create" || op.Name == "SetCache" {
+                size = valueSize(op.Value)
+            }
+            if err := t.reserve(tenant, op, size); err != nil {
+                return err
+            }
+            err := next(op)
+            t.settle(tenant, op, size, err == nil)
+            return err
+        }
+    }
+}
+
+// reserve counts the request of op and reserves the rows and bytes it adds, failing when that
+// exceeds the tenant's quota. A refused operation still counts as a request.
+func (t *UsageTracker) reserve(tenant string, op *Operation, size int64) error {
+    t.register(tenant)
+    q := t.Quota(tenant)
+    err := t.apply(tenant, t.requestsKey(tenant), t.window(),
+        adapters.UsageDelta{Field: "requests", By: 1, Limit: q.MaxRequests},
+        adapters.UsageDelta{Field: "backend:" + op.Backend, By: 1})
+    if err != nil {
+        return err
+    }
+    switch op.Name {
+    case "Create":
+        return t.apply(tenant, usagePrefix+tenant, 0,
+            adapters.UsageDelta{Field: "rows", By: 1, Limit: q.MaxRows},
+            adapters.UsageDelta{Field: "storage_bytes", By: size, Limit: q.MaxStorageBytes})
+    case "SetCache":
+        return t.apply(tenant, usagePrefix+tenant, 0,
+            adapters.UsageDelta{Field: "cache_bytes", By: size, Limit: q.MaxCacheBytes, Member: op.Target})
+    }
+    return nil
+}
+
+// settle gives back the reservation of a failed Create and frees what a successful Delete or
+// DeleteCache released. A failed SetCache keeps its reservation until the key is set or deleted.
+func (t *UsageTracker) settle(tenant string, op *Operation, size int64, succeeded bool) {
+    var deltas []adapters.UsageDelta
+    switch {
+    case op.Name == "Create" && !succeeded:
+        deltas = []adapters.UsageDelta{{Field: "rows", By: -1}, {Field: "storage_bytes", By: -size}}
+    case op.Name == "Delete" && succeeded:
+        u, err := t.lifetime(tenant)
+        if err != nil || u.Rows <= 0 {
+            break
+        }
+        // Row sizes aren't tracked individually, so a delete frees the average row size.
+        deltas = []adapters.UsageDelta{{Field: "rows", By: -1}, {Field: "storage_bytes", By: -u.StorageBytes / u.Rows}}
+    case op.Name == "DeleteCache" && succeeded:
+        deltas = []adapters.UsageDelta{{Field: "cache_bytes", By: 0, Member: op.Target}}
+    }
+    if len(deltas) == 0 {
+        return
+    }
+    if err := t.apply(tenant, usagePrefix+tenant, 0, deltas...); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Usage Accounting", "tenant": tenant})
+    }
+}
+
+// apply reserves deltas on the counters at key, turning a refusal into a *QuotaExceededError.
+// Limits only apply when quotas are enforced.
+func (t *UsageTracker) apply(tenant, key string, ttl time.Duration, deltas ...adapters.UsageDelta) error {
+    if !t.enforce {
+        for i := range deltas {
+            deltas[i].Limit = 0
+        }
+    }
+    res, err := t.counter.ReserveUsage(key, ttl, deltas...)
+    if err != nil {
+        return err
+    }
+    if res.Applied {
+        return nil
+    }
+    limit := int64(0)
+    for _, d := range deltas {
+        if d.Field == res.Field {
+            limit = d.Limit
+        }
+    }
+    return &QuotaExceededError{Tenant: tenant, Resource: res.Field, Limit: limit, Used: res.Used}
+}
+
+// register adds tenant to the tenant index once per process.
+func (t *UsageTracker) register(tenant string) {
+    if _, loaded := t.registered.LoadOrStore(tenant, true); loaded {
+        return
+    }
+    member := adapters.ScoredMember{Member: tenant, Score: float64(time.Now().Unix())}
+    if err := t.cache.ZAdd(usageTenantsKey, member); err != nil {
+        t.registered.Delete(tenant)
+        utils.LogError(err, map[string]interface{}{"operation": "Usage Accounting", "tenant": tenant})
+    }
+}
+
+// Usage returns the usage recorded for tenant.
+func (t *UsageTracker) Usage(tenant string) (Usage, error) {
+    u, err := t.lifetime(tenant)
+    if err != nil {
+        return Usage{}, err
+    }
+    window, err := t.cache.HGetAll(t.requestsKey(tenant))
+    if err != nil {
+        return Usage{}, err
+    }
+    for field, value := range window {
+        n, _ := strconv.ParseInt(value, 10, 64)
+        if backend := strings.TrimPrefix(field, "backend:"); backend != field {
+            u.ByBackend[backend] = n
+        } else if field == "requests" {
+            u.Requests = n
+        }
+    }
+    return u, nil
+}
+
+// lifetime returns the rows and bytes recorded for tenant.
+func (t *UsageTracker) lifetime(tenant string) (Usage, error) {
+    counters, err := t.cache.HGetAll(usagePrefix + tenant)
+    if err != nil {
+        return Usage{}, err
+    }
+    value := func(field string) int64 {
+        n, _ := strconv.ParseInt(counters[field], 10, 64)
+        return n
+    }
+    return Usage{
+        ByBackend:    map[string]int64{},
+        Rows:         value("rows"),
+        StorageBytes: value("storage_bytes"),
+        CacheBytes:   value("cache_bytes"),
+    }, nil
+}
+
+// Snapshot returns the usage of every tenant, keyed by tenant, for the expvar. Tenants whose usage
+// can't be read are left out.
+func (t *UsageTracker) Snapshot() map[string]Usage {
+    tenants, err := t.Tenants()
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Usage Snapshot"})
+    }
+    out := make(map[string]Usage, len(tenants))
+    for _, tenant := range tenants {
+        if u, err := t.Usage(tenant); err == nil {
+            out[tenant] = u
+        }
+    }
+    return out
+}
+
+// Tenants returns the tracked tenants in sorted order.
+func (t *UsageTracker) Tenants() ([]string, error) {
+    members, err := t.cache.ZRange(usageTenantsKey, 0, -1, false)
+    if err != nil {
+        return nil, err
+    }
+    tenants := make([]string, len(members))
+    for i, m := range members {
+        tenants[i] = m.Member
+    }
+    sort.Strings(tenants)
+    return tenants, nil
+}
+
+// Quota returns the quota applied to tenant.
+func (t *UsageTracker) Quota(tenant string) Quota {
+    if q, ok := t.quotas[tenant]; ok {
+        return q
+    }
+    return t.defaultQuota
+}
+
+// requestsKey returns the key of tenant's request counters in the current window.
+func (t *UsageTracker) requestsKey(tenant string) string {
+    return fmt.Sprintf("%s%s:requests:%d", usagePrefix, tenant, time.Now().UnixNano()/int64(t.window()))
+}
+
+func (t *UsageTracker) window() time.Duration {
+    if t.Window <= 0 {
+        return DefaultUsageWindow
+    }
+    return t.Window
+}
+
+// valueSize estimates the stored size of a value by its JSON encoding.
+func valueSize(value interface{}) int64 {
+    if value == nil {
+        return 0
+    }
+    data, err := json.Marshal(value)
+    if err != nil {
+        return 0
+    }
+    return int64(len(data))
+}
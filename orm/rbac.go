@@ -0,0 +1,256 @@
+package orm
+
+import (
+    "context"
+    "fmt"
+    "persistence-layer/utils"
+    "sort"
+    "sync"
+    "time"
+
+    "gorm.io/gorm/clause"
+)
+
+// Permission effects. A deny overrides any allow matching the same operation.
+const (
+    EffectAllow = "allow"
+    EffectDeny  = "deny"
+)
+
+// AnyEntity and AnyOperation match every entity or operation in a Permission.
+const (
+    AnyEntity    = "*"
+    AnyOperation = "*"
+)
+
+// Permission allows or denies a role an ORM operation on an entity, e.g. denying "viewer" the
+// "Delete" of "Product" records.
+type Permission struct {
+    ID        uint64    `json:"id" gorm:"primaryKey"`
+    Role      string    `json:"role" gorm:"size:64;not null;uniqueIndex:idx_permission"`
+    Entity    string    `json:"entity" gorm:"size:64;not null;uniqueIndex:idx_permission"`    // Model name, e.g. "Product", or "*".
+    Operation string    `json:"operation" gorm:"size:64;not null;uniqueIndex:idx_permission"` // ORM operation, e.g. "Delete", or "*".
+    Effect    string    `json:"effect" gorm:"size:8;not null"`                                // EffectAllow or EffectDeny.
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// RoleAssignment gives a subject a role on top of those claimed by its credentials.
+type RoleAssignment struct {
+    ID        uint64    `json:"id" gorm:"primaryKey"`
+    Subject   string    `json:"subject" gorm:"size:128;not null;uniqueIndex:idx_role_assignment"`
+    Role      string    `json:"role" gorm:"size:64;not null;uniqueIndex:idx_role_assignment"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// Principal is the authenticated caller of an operation and its resolved roles.
+type Principal struct {
+    Subject string
+    Roles   []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context whose ORM operations are checked against principal's roles by
+// RBAC.Middleware.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+    return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal set by WithPrincipal and whether there is one.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+    if ctx == nil {
+        return Principal{}, false
+    }
+    principal, ok := ctx.Value(principalKey{}).(Principal)
+    return principal, ok
+}
+
+// PermissionDeniedError is returned for operations none of the principal's roles may perform.
+// It matches utils.ErrPermissionDenied with errors.Is.
+type PermissionDeniedError struct {
+    Subject   string
+    Roles     []string
+    Entity    string
+    Operation string
+}
+
+func (e *PermissionDeniedError) Error() string {
+    return fmt.Sprintf("subject %q with roles %v may not %s %s", e.Subject, e.Roles, e.Operation, e.Entity)
+}
+
+func (e *PermissionDeniedError) Is(target error) bool {
+    return target == utils.ErrPermissionDenied
+}
+
+// RBAC enforces role-based access to ORM operations. Permissions and role assignments live in SQL
+// and are cached in memory, refreshed by Load. An operation is allowed when one of the principal's
+// roles has an allow permission matching its entity and operation, exactly or through "*", and
+// none has a matching deny; everything else is denied.
+type RBAC struct {
+    o *ORM
+
+    mu          sync.RWMutex
+    permissions map[string][]Permission // By role.
+    assignments map[string][]string     // Roles by subject.
+}
+
+// NewRBAC creates the permission tables in the default database when missing and loads them.
+func NewRBAC(o *ORM) (*RBAC, error) {
+    o, err := o.Database(DefaultDatabase)
+    if err != nil {
+        return nil, err
+    }
+    if o.SQL == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    if err := migrating(db).AutoMigrate(&Permission{}, &RoleAssignment{}); err != nil {
+        return nil, err
+    }
+    r := &RBAC{o: o}
+    if err := r.Load(context.Background()); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+// Load replaces the cached permissions and role assignments with those stored in SQL.
+func (r *RBAC) Load(ctx context.Context) error {
+    db := r.o.SQL.GetDB().WithContext(ctx)
+    var permissions []Permission
+    if err := db.Find(&permissions).Error; err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "RBAC Load"})
+        return utils.HandleSQLError(err)
+    }
+    var assignments []RoleAssignment
+    if err := db.Find(&assignments).Error; err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "RBAC Load"})
+        return utils.HandleSQLError(err)
+    }
+    byRole := make(map[string][]Permission)
+    for _, p := range permissions {
+        byRole[p.Role] = append(byRole[p.Role], p)
+    }
+    bySubject := make(map[string][]string)
+    for _, a := range assignments {
+        bySubject[a.Subject] = append(bySubject[a.Subject], a.Role)
+    }
+    r.mu.Lock()
+    r.permissions, r.assignments = byRole, bySubject
+    r.mu.Unlock()
+    return nil
+}
+
+// Run reloads the permissions every interval until the context is cancelled, so changes made by
+// other instances take effect.
+func (r *RBAC) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            _ = r.Load(ctx)
+        }
+    }
+}
+
+// Roles resolves the roles of subject: those claimed by its credentials plus those assigned to it.
+func (r *RBAC) Roles(subject string, claimed []string) []string {
+    r.mu.RLock()
+    assigned := r.assignments[subject]
+    r.mu.RUnlock()
+    seen := make(map[string]bool, len(claimed)+len(assigned))
+    roles := make([]string, 0, len(claimed)+len(assigned))
+    for _, role := range append(append([]string(nil), claimed...), assigned...) {
+        if role != "" && !seen[role] {
+            seen[role] = true
+            roles = append(roles, role)
+        }
+    }
+    sort.Strings(roles)
+    return roles
+}
+
+// Check returns a *PermissionDeniedError unless principal may perform operation on entity.
+func (r *RBAC) Check(principal Principal, entity, operation string) error {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    allowed := false
+    for _, role := range principal.Roles {
+        for _, p := range r.permissions[role] {
+            if (p.Entity == entity || p.Entity == AnyEntity) && (p.Operation == operation || p.Operation == AnyOperation) {
+                if p.Effect == EffectDeny {
+                    return &PermissionDeniedError{Subject: principal.Subject, Roles: principal.Roles, Entity: entity, Operation: operation}
+                }
+                allowed = true
+            }
+        }
+    }
+    if !allowed {
+        return &PermissionDeniedError{Subject: principal.Subject, Roles: principal.Roles, Entity: entity, Operation: operation}
+    }
+    return nil
+}
+
+// Middleware returns the middleware checking every operation whose context carries a Principal
+// before running it. Operations without one, e.g. those of background workers, are not checked.
+func (r *RBAC) Middleware() Middleware {
+    return func(next Handler) Handler {
+        return func(op *Operation) error {
+            if principal, ok := PrincipalFromContext(op.Context); ok {
+                if err := r.Check(principal, op.Model, op.Name); err != nil {
+                    return err
+                }
+            }
+            return next(op)
+        }
+    }
+}
+
+// SetPermission stores a permission, replacing the effect of an existing one for the same role,
+// entity and operation, and reloads the cache.
+func (r *RBAC) SetPermission(p *Permission) error {
+    if p.Effect != EffectAllow && p.Effect != EffectDeny {
+        return fmt.Errorf("%w: permission effect %q", utils.ErrInvalidValue, p.Effect)
+    }
+    if p.Role == "" || p.Entity == "" || p.Operation == "" {
+        return fmt.Errorf("%w: permission needs a role, entity and operation", utils.ErrInvalidValue)
+    }
+    err := r.o.invoke("SetPermission", BackendSQL, p, "", func() error {
+        err := r.o.SQL.GetDB().Clauses(clause.OnConflict{
+            Columns:   []clause.Column{{Name: "role"}, {Name: "entity"}, {Name: "operation"}},
+            DoUpdates: clause.AssignmentColumns([]string{"effect"}),
+        }).Create(p).Error
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "SetPermission", "role": p.Role, "entity": p.Entity})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    return r.Load(context.Background())
+}
+
+// AssignRole gives subject role, if it doesn't have it already, and reloads the cache.
+func (r *RBAC) AssignRole(subject, role string) error {
+    assignment := &RoleAssignment{Subject: subject, Role: role}
+    err := r.o.invoke("AssignRole", BackendSQL, assignment, "", func() error {
+        err := r.o.SQL.GetDB().Clauses(clause.OnConflict{DoNothing: true}).Create(assignment).Error
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "AssignRole", "subject": subject, "role": role})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    return r.Load(context.Background())
+}
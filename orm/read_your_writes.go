@@ -0,0 +1,176 @@
+package orm
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "sync"
+    "time"
+)
+
+// DefaultReplicaLag is the default of ORM.ReplicaLag.
+const DefaultReplicaLag = 5 * time.Second
+
+// Session tracks the latest write of a client session to each SQL database, so reads in the session
+// avoid replicas that haven't replayed it yet. Bind it to a context with WithSession and pass the
+// context to ORM.WithContext; carry it across requests with Token and ParseSession:
+//
+//     session, _ := orm.ParseSession(tokenFromClient)
+//     db := o.WithContext(orm.WithSession(ctx, session))
+//     err := db.Create(&post)
+//     err = db.ReadByKey(post.ID, &post) // From the primary, or a replica that has the post.
+//     tokenForClient := session.Token()
+type Session struct {
+    mu     sync.Mutex
+    writes map[string]sessionWrite // By database name.
+}
+
+// sessionWrite is the replication position of the primary after a write, when the database reports
+// one, and the time of the write.
+type sessionWrite struct {
+    Position string    `json:"p,omitempty"`
+    At       time.Time `json:"t"`
+}
+
+type sessionKey struct{}
+
+// NewSession creates a session without writes.
+func NewSession() *Session {
+    return &Session{writes: make(map[string]sessionWrite)}
+}
+
+// ParseSession restores a session from a Token. An empty token yields a new session.
+func ParseSession(token string) (*Session, error) {
+    s := NewSession()
+    if token == "" {
+        return s, nil
+    }
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return s, err
+    }
+    if err := json.Unmarshal(data, &s.writes); err != nil {
+        return NewSession(), err
+    }
+    return s, nil
+}
+
+// Token encodes the session's writes for the client to send back with its next request; "" when
+// the session hasn't written.
+func (s *Session) Token() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.writes) == 0 {
+        return ""
+    }
+    data, err := json.Marshal(s.writes)
+    if err != nil {
+        return ""
+    }
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (s *Session) record(database string, write sessionWrite) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.writes[database] = write
+}
+
+func (s *Session) last(database string) (sessionWrite, bool) {
+    if s == nil {
+        return sessionWrite{}, false
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    write, ok := s.writes[database]
+    return write, ok
+}
+
+// forget drops a write once a replica has caught up with it, unless a newer one replaced it.
+func (s *Session) forget(database string, write sessionWrite) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.writes[database] == write {
+        delete(s.writes, database)
+    }
+}
+
+// WithSession returns a context carrying session for ORMs derived with WithContext.
+func WithSession(ctx context.Context, session *Session) context.Context {
+    return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFromContext returns the session set by WithSession, or nil when there is none.
+func SessionFromContext(ctx context.Context) *Session {
+    if ctx == nil {
+        return nil
+    }
+    session, _ := ctx.Value(sessionKey{}).(*Session)
+    return session
+}
+
+// AddReplica registers a read replica of the SQL database named database, e.g. DefaultDatabase.
+// Reads outside transactions go to the replica unless the session in the ORM's context wrote to
+// the database and the replica hasn't caught up: by replication position when the databases
+// report one, otherwise for ReplicaLag after the write. Call it at startup, before the ORM is
+// shared.
+func (o *ORM) AddReplica(database string, store adapters.SQLStore) {
+    if o.replicas == nil {
+        o.replicas = make(map[string]adapters.SQLStore)
+    }
+    o.replicas[database] = store
+}
+
+// reader returns the store to read from: the replica of the ORM's database when it may serve the
+// session, otherwise the database itself.
+func (o *ORM) reader() adapters.SQLStore {
+    replica := o.replicas[o.databaseName()]
+    if replica == nil || o.tx != nil {
+        return o.SQL
+    }
+    if o.ctx != nil {
+        replica = replica.WithContext(o.ctx)
+    }
+    session := SessionFromContext(o.ctx)
+    write, ok := session.last(o.databaseName())
+    if !ok {
+        return replica
+    }
+    if tracker, isTracker := replica.(adapters.ReplicationTracker); isTracker && write.Position != "" {
+        caughtUp, err := tracker.CaughtUp(write.Position)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Replica CaughtUp", "database": o.databaseName()})
+        }
+        if !caughtUp {
+            return o.SQL
+        }
+        session.forget(o.databaseName(), write)
+        return replica
+    }
+    if time.Since(write.At) < o.ReplicaLag {
+        return o.SQL
+    }
+    session.forget(o.databaseName(), write)
+    return replica
+}
+
+// noteWrite records a committed write to the ORM's database in the session of ctx, if any, when
+// the database has a replica to keep the session away from. Writes in a WithTransaction callback
+// are noted when the transaction commits.
+func (o *ORM) noteWrite(ctx context.Context) {
+    session := SessionFromContext(ctx)
+    if session == nil || o.tx != nil || o.replicas[o.databaseName()] == nil {
+        return
+    }
+    write := sessionWrite{At: time.Now()}
+    if tracker, ok := o.SQL.(adapters.ReplicationTracker); ok {
+        position, err := tracker.ReplicationPosition()
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Replication Position", "database": o.databaseName()})
+        }
+        write.Position = position
+    }
+    session.record(o.databaseName(), write)
+}
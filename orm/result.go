@@ -0,0 +1,37 @@
+package orm
+
+// SideEffect is what became of the Mongo copy, cache invalidation or index update following a
+// write.
+type SideEffect string
+
+const (
+    // SideEffectSkipped means the model's policy or the deployment has no such side effect, e.g. a
+    // model without an index or an ORM without Redis.
+    SideEffectSkipped SideEffect = "skipped"
+    // SideEffectDone means the side effect was applied before the write returned.
+    SideEffectDone SideEffect = "done"
+    // SideEffectQueued means the side effect was left to the outbox relay or the IndexPool, or to the
+    // commit of the enclosing transaction.
+    SideEffectQueued SideEffect = "queued"
+    // SideEffectFailed means the side effect failed and was logged; the write itself succeeded.
+    SideEffectFailed SideEffect = "failed"
+)
+
+// Result describes a committed Create, Update or Delete, so callers can report what the write did
+// rather than guess, e.g. that a delete matched no rows.
+type Result struct {
+    // RowsAffected is the number of SQL rows the write changed or, for models kept only in Mongo,
+    // the number of documents it matched.
+    RowsAffected int64
+    // Key is the primary key of the record, including the ID the database generated on Create; nil
+    // for models without a GetID or GetKey method.
+    Key interface{}
+    // Mongo is what became of the copy in Mongo of a record its policy stores in both SQL and Mongo.
+    // It is written once the SQL write commits; when that fails, an outbox event has the relay
+    // bring the copy in line with SQL later and Mongo is SideEffectQueued.
+    Mongo SideEffect
+    // Cache is what became of the invalidation of the record's cached copy.
+    Cache SideEffect
+    // Index is what became of the update of the record's search document.
+    Index SideEffect
+}
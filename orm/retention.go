@@ -0,0 +1,235 @@
+package orm
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "persistence-layer/utils"
+    "reflect"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+const defaultArchiveBatchSize = 500
+
+// RetentionPolicy describes how long rows of a table are kept before they are archived and deleted.
+type RetentionPolicy struct {
+    Table        string
+    ArchiveTable string // When empty, rows are handed to the Archiver's sink instead.
+    TimeColumn   string // Defaults to "created_at".
+    MaxAge       time.Duration
+    BatchSize    int // Defaults to 500 rows per transaction.
+    // Model, e.g. &models.Order{}, is the model kept in Table; defaults to the model registered
+    // with SetPolicy whose table it is. Archived records leave its cache and search index, and its
+    // Mongo copy, as on Delete.
+    Model interface{}
+}
+
+// ArchiveSink receives expired rows for policies without an archive table (e.g. an object storage exporter).
+type ArchiveSink interface {
+    Archive(table string, rows []map[string]interface{}) error
+}
+
+// Archiver moves expired rows out of their tables in bounded batches so no single transaction holds long locks.
+type Archiver struct {
+    orm      *ORM
+    sink     ArchiveSink
+    policies []RetentionPolicy
+}
+
+// NewArchiver creates an Archiver for the given policies. sink may be nil when every policy has an ArchiveTable.
+func NewArchiver(o *ORM, sink ArchiveSink, policies ...RetentionPolicy) *Archiver {
+    return &Archiver{orm: o, sink: sink, policies: policies}
+}
+
+// Run executes RunOnce every interval until the context is cancelled.
+func (a *Archiver) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        _ = a.RunOnce()
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// RunOnce applies every policy until no expired rows remain and returns the first error encountered.
+func (a *Archiver) RunOnce() error {
+    var firstErr error
+    for _, policy := range a.policies {
+        archived, err := a.apply(policy)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "Archive", "table": policy.Table, "archived": archived})
+            if firstErr == nil {
+                firstErr = err
+            }
+            continue
+        }
+        if archived > 0 {
+            utils.LogInfo("Expired records archived successfully", map[string]interface{}{"table": policy.Table, "archived": archived})
+        }
+    }
+    return firstErr
+}
+
+// apply archives batches for a single policy until a batch comes back short.
+func (a *Archiver) apply(policy RetentionPolicy) (int, error) {
+    if policy.Table == "" || policy.MaxAge <= 0 {
+        return 0, fmt.Errorf("invalid retention policy for table %q", policy.Table)
+    }
+    if policy.ArchiveTable == "" && a.sink == nil {
+        return 0, fmt.Errorf("retention policy for table %q has neither an archive table nor a sink", policy.Table)
+    }
+    if policy.TimeColumn == "" {
+        policy.TimeColumn = "created_at"
+    }
+    if policy.BatchSize <= 0 {
+        policy.BatchSize = defaultArchiveBatchSize
+    }
+
+    cutoff := time.Now().Add(-policy.MaxAge)
+    total := 0
+    for {
+        n, err := a.archiveBatch(policy, cutoff)
+        total += n
+        if err != nil {
+            return total, err
+        }
+        if n < policy.BatchSize {
+            return total, nil
+        }
+    }
+}
+
+// archiveBatch copies or exports one batch of expired rows and deletes them within a single
+// transaction, then drops them from the cache and search index of their model like Delete.
+func (a *Archiver) archiveBatch(policy RetentionPolicy, cutoff time.Time) (int, error) {
+    if a.orm.SQL == nil {
+        return 0, backendDisabled(BackendSQL)
+    }
+    if a.orm.SQL.GetDB() == nil {
+        return 0, errNoGormDB
+    }
+    tx := a.orm.SQL.GetDB().Begin()
+    if tx.Error != nil {
+        return 0, tx.Error
+    }
+    defer tx.Rollback()
+
+    var rows []map[string]interface{}
+    err := tx.Table(policy.Table).
+        Where(fmt.Sprintf("%s < ?", policy.TimeColumn), cutoff).
+        Order("id").
+        Limit(policy.BatchSize).
+        Find(&rows).Error
+    if err != nil {
+        return 0, err
+    }
+    if len(rows) == 0 {
+        return 0, nil
+    }
+
+    ids := make([]interface{}, 0, len(rows))
+    for _, row := range rows {
+        id := row["id"]
+        if b, ok := id.([]byte); ok {
+            id = string(b)
+        }
+        ids = append(ids, id)
+    }
+    model := policy.Model
+    if model == nil {
+        model = a.registeredModel(tx, policy.Table)
+    }
+
+    if policy.ArchiveTable != "" {
+        insert := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE id IN ?", policy.ArchiveTable, policy.Table)
+        if err := tx.Exec(insert, ids).Error; err != nil {
+            return 0, err
+        }
+    } else if err := a.sink.Archive(policy.Table, rows); err != nil {
+        return 0, err
+    }
+
+    if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN ?", policy.Table), ids).Error; err != nil {
+        return 0, err
+    }
+    var modelPolicy Policy
+    if model != nil {
+        modelPolicy = a.orm.PolicyFor(model)
+        if modelPolicy.AsyncIndex && modelPolicy.Index != "" {
+            for _, id := range ids {
+                event, err := newOutboxEvent("Delete", modelPolicy, id, model)
+                if err != nil {
+                    return 0, err
+                }
+                if err := tx.Create(event).Error; err != nil {
+                    return 0, err
+                }
+            }
+        }
+    }
+    if err := tx.Commit().Error; err != nil {
+        return 0, err
+    }
+    if model != nil {
+        for _, id := range ids {
+            a.orm.afterWrite("Delete", modelPolicy, id, model)
+        }
+    }
+    return len(rows), nil
+}
+
+// registeredModel returns a model registered with SetPolicy whose table is table, or nil.
+func (a *Archiver) registeredModel(db *gorm.DB, table string) interface{} {
+    for t := range a.orm.policies {
+        model := reflect.New(t).Interface()
+        if name, err := tableName(db, model); err == nil && name == table {
+            return model
+        }
+    }
+    return nil
+}
+
+// FileArchiveSink writes archived rows as newline-delimited JSON files, one file per batch,
+// into a directory that can be synced to object storage.
+type FileArchiveSink struct {
+    Dir string
+}
+
+// NewFileArchiveSink creates a FileArchiveSink writing into dir.
+func NewFileArchiveSink(dir string) *FileArchiveSink {
+    return &FileArchiveSink{Dir: dir}
+}
+
+// Archive writes the rows to <dir>/<table>/<timestamp>.ndjson.
+func (s *FileArchiveSink) Archive(table string, rows []map[string]interface{}) error {
+    if s.Dir == "" {
+        return errors.New("archive export directory is not configured")
+    }
+    dir := filepath.Join(s.Dir, table)
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return err
+    }
+
+    file, err := os.Create(filepath.Join(dir, fmt.Sprintf("%d.ndjson", time.Now().UnixNano())))
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    encoder := json.NewEncoder(file)
+    for _, row := range rows {
+        if err := encoder.Encode(row); err != nil {
+            return err
+        }
+    }
+    return file.Sync()
+}
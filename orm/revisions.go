@@ -0,0 +1,279 @@
+package orm
+
+import (
+    "errors"
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/types"
+    "persistence-layer/utils"
+    "reflect"
+    "sort"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Revision is a prior state of a versioned record, stored in the "<table>_revisions" table. The
+// record held Data from ValidFrom until ValidTo, when Operation ("update" or "delete") replaced it.
+type Revision struct {
+    ID        uint64     `json:"id" gorm:"primaryKey"`
+    RecordKey string     `json:"record_key" gorm:"size:64;not null"`
+    Operation string     `json:"operation" gorm:"size:16;not null"`
+    Data      types.JSON `json:"data"`
+    ValidFrom time.Time  `json:"valid_from"` // Zero when the record has no CreatedAt field.
+    ValidTo   time.Time  `json:"valid_to" gorm:"not null"`
+}
+
+// Decode unmarshals the revision's state into model.
+func (r *Revision) Decode(model interface{}) error {
+    return r.Data.Decode(model)
+}
+
+// RevisionTable returns the name of the table holding the revisions of table.
+func RevisionTable(table string) string {
+    return table + "_revisions"
+}
+
+// EnableRevisions turns on versioning for the given models, e.g. &models.Product{}: from then on
+// Update and Delete first copy the record's current state to its revisions table, in the same
+// transaction. The revisions tables are created or migrated here, next to the model's table in
+// each database of its Policy, so call this at startup before serving requests.
+func (o *ORM) EnableRevisions(models ...interface{}) error {
+    if o.revisioned == nil {
+        o.revisioned = make(map[reflect.Type]bool)
+    }
+    for _, model := range models {
+        dbs, err := o.Shards(model)
+        if err != nil {
+            return err
+        }
+        for _, db := range dbs {
+            if err := db.migrateRevisions(model); err != nil {
+                return err
+            }
+        }
+        o.revisioned[indirectType(model)] = true
+    }
+    return nil
+}
+
+// migrateRevisions creates or migrates the revisions table of model in the ORM's database.
+func (o *ORM) migrateRevisions(model interface{}) error {
+    if o.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return errNoGormDB
+    }
+    table, err := tableName(db, model)
+    if err != nil {
+        return err
+    }
+    revisions := RevisionTable(table)
+    if err := migrating(db).Table(revisions).AutoMigrate(&Revision{}); err != nil {
+        return fmt.Errorf("failed to migrate %s: %w", revisions, err)
+    }
+    index := revisions + "_key_valid_to"
+    if !db.Table(revisions).Migrator().HasIndex(&Revision{}, index) {
+        err := db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (record_key, valid_to)", index, revisions)).Error
+        if err != nil {
+            return fmt.Errorf("failed to index %s: %w", revisions, err)
+        }
+    }
+    utils.LogInfo("Revisions enabled", map[string]interface{}{"database": o.databaseName(), "table": table, "revisions": revisions})
+    return nil
+}
+
+// History returns every prior state of the record with key, oldest first. The current state is
+// not included; read it with Read.
+func (o *ORM) History(key interface{}, model interface{}) ([]Revision, error) {
+    var revisions []Revision
+    err := o.invoke("History", BackendSQL, model, "", func() error {
+        var dbs []*ORM
+        db, err := o.route(o.PolicyFor(model), key, model)
+        switch {
+        case err == nil:
+            dbs = []*ORM{db}
+        case errors.Is(err, ErrNoShardKey):
+            // A sharded record that can't be located by key may have revisions in any shard.
+            if dbs, err = o.Shards(model); err != nil {
+                return err
+            }
+        default:
+            return err
+        }
+        for _, db := range dbs {
+            q, err := db.revisionQuery(key, model)
+            if err != nil {
+                return err
+            }
+            var found []Revision
+            if err := q.Order("valid_to, id").Find(&found).Error; err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "History", "id": key, "database": db.databaseName()})
+                return utils.HandleSQLError(err)
+            }
+            revisions = append(revisions, found...)
+        }
+        if len(dbs) > 1 {
+            sort.SliceStable(revisions, func(i, j int) bool {
+                if !revisions[i].ValidTo.Equal(revisions[j].ValidTo) {
+                    return revisions[i].ValidTo.Before(revisions[j].ValidTo)
+                }
+                return revisions[i].ID < revisions[j].ID
+            })
+        }
+        return nil
+    })
+    return revisions, err
+}
+
+// ReadAsOf reads the state the record with key had at ts into model. It returns utils.ErrNotFound
+// when the record didn't exist at ts, i.e. it was created later or had already been deleted.
+func (o *ORM) ReadAsOf(key interface{}, model interface{}, ts time.Time) error {
+    return o.invoke("ReadAsOf", BackendSQL, model, "", func() error {
+        return o.readRouted(o.PolicyFor(model), key, model, func(db *ORM) error {
+            return db.readAsOf(key, model, ts)
+        })
+    })
+}
+
+// readAsOf is ReadAsOf against the ORM's database.
+func (o *ORM) readAsOf(key interface{}, model interface{}, ts time.Time) error {
+    q, err := o.revisionQuery(key, model)
+    if err != nil {
+        return err
+    }
+    // The first state replaced after ts is the one that was current at ts.
+    var revision Revision
+    err = q.Where("valid_to > ?", ts).Order("valid_to, id").Take(&revision).Error
+    if err == nil {
+        if !revision.ValidFrom.IsZero() && revision.ValidFrom.After(ts) {
+            return utils.ErrNotFound
+        }
+        return revision.Decode(model)
+    }
+    if !errors.Is(err, gorm.ErrRecordNotFound) {
+        utils.LogError(err, map[string]interface{}{"operation": "ReadAsOf", "id": key})
+        return utils.HandleSQLError(err)
+    }
+
+    // Nothing changed since ts, so the current row is the answer if it already existed.
+    if err := o.SQL.ReadByKey(key, model); err != nil {
+        return utils.HandleSQLError(err)
+    }
+    if created, ok := createdAt(model); ok && created.After(ts) {
+        return utils.ErrNotFound
+    }
+    return nil
+}
+
+// revisionQuery selects the revisions of one record of a versioned model in the ORM's database.
+func (o *ORM) revisionQuery(key interface{}, model interface{}) (*gorm.DB, error) {
+    if o.SQL == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    if !o.revisioned[indirectType(model)] {
+        return nil, fmt.Errorf("revisions are not enabled for %s", modelName(model))
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    table, err := tableName(db, model)
+    if err != nil {
+        return nil, err
+    }
+    return db.Table(RevisionTable(table)).Where("record_key = ?", fmt.Sprint(key)), nil
+}
+
+// recordRevision copies the current state of a versioned record to its revisions table within tx,
+// before operation replaces it. key may be nil to take it from model.
+func (o *ORM) recordRevision(tx Transaction, operation string, key interface{}, model interface{}) error {
+    if !o.revisioned[indirectType(model)] {
+        return nil
+    }
+    store := transactionStore(tx)
+    if store == nil || store.GetDB() == nil {
+        return errNoGormDB
+    }
+    db := store.GetDB()
+    if key == nil {
+        var err error
+        if key, err = ModelKey(model); err != nil {
+            return err
+        }
+    }
+    current := reflect.New(indirectType(model)).Interface()
+    if err := store.ReadByKey(key, current); err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            // Nothing to preserve; the update or delete itself reports the missing record.
+            return nil
+        }
+        return err
+    }
+    data, err := types.NewJSON(current)
+    if err != nil {
+        return err
+    }
+    table, err := tableName(db, model)
+    if err != nil {
+        return err
+    }
+    revisions := db.Table(RevisionTable(table))
+
+    // The state began when the previous revision ended, or when the record was created.
+    var previous Revision
+    validFrom, _ := createdAt(current)
+    err = revisions.Session(&gorm.Session{}).Where("record_key = ?", fmt.Sprint(key)).Order("valid_to DESC, id DESC").Take(&previous).Error
+    if err == nil {
+        validFrom = previous.ValidTo
+    } else if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return err
+    }
+
+    return revisions.Session(&gorm.Session{}).Create(&Revision{
+        RecordKey: fmt.Sprint(key),
+        Operation: operation,
+        Data:      data,
+        ValidFrom: validFrom,
+        ValidTo:   time.Now(),
+    }).Error
+}
+
+// transactionStore returns the SQL store a Transaction writes through.
+func transactionStore(tx Transaction) adapters.SQLStore {
+    switch t := tx.(type) {
+    case *SQLTransaction:
+        return t.tx
+    case *ambientTransaction:
+        return t.tx
+    }
+    return nil
+}
+
+// tableName resolves the table of model with the database's naming strategy.
+func tableName(db *gorm.DB, model interface{}) (string, error) {
+    stmt := &gorm.Statement{DB: db}
+    if err := stmt.Parse(model); err != nil {
+        return "", err
+    }
+    return stmt.Schema.Table, nil
+}
+
+// createdAt returns the CreatedAt field of model, if it has one.
+func createdAt(model interface{}) (time.Time, bool) {
+    v := reflect.ValueOf(model)
+    for v.Kind() == reflect.Ptr {
+        v = v.Elem()
+    }
+    if v.Kind() != reflect.Struct {
+        return time.Time{}, false
+    }
+    field := v.FieldByName("CreatedAt")
+    if !field.IsValid() {
+        return time.Time{}, false
+    }
+    t, ok := field.Interface().(time.Time)
+    return t, ok && !t.IsZero()
+}
@@ -0,0 +1,289 @@
+package orm
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+    "sync"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "gorm.io/gorm/schema"
+)
+
+// DriftReport is the outcome of one consistency check between SQL and a derived store.
+type DriftReport struct {
+    Model    string    `json:"model"`
+    Index    string    `json:"index,omitempty"` // The search index checked; empty for the cache.
+    Checked  int       `json:"checked"`
+    Missing  []string  `json:"missing"` // Keys of rows without a document.
+    Stale    []string  `json:"stale"`   // Keys of rows whose document or cache entry differs from the row.
+    Repaired int       `json:"repaired"`
+    Finished time.Time `json:"finished"`
+}
+
+// Drift returns the share of checked rows that were missing or stale.
+func (r *DriftReport) Drift() float64 {
+    if r.Checked == 0 {
+        return 0
+    }
+    return float64(len(r.Missing)+len(r.Stale)) / float64(r.Checked)
+}
+
+// SearchChecker compares the rows of a model in SQL with their documents in the model's search
+// index, reporting documents that are missing or differ from the SearchDocument of the row, and
+// re-indexing them when Repair is set. Repairs index the rows as re-read from the primary at repair
+// time, not as scanned, so a write committed during the scan is not rolled back in the index. With
+// SampleSize set it checks that many random rows per run; otherwise it scans the whole table in
+// primary key order. Documents of deleted rows are not detected.
+type SearchChecker struct {
+    orm        *ORM
+    model      interface{}
+    BatchSize  int
+    SampleSize int  // Rows checked per run; 0 checks every row.
+    Repair     bool // Re-index missing and stale documents.
+
+    mu   sync.Mutex
+    last *DriftReport
+}
+
+// NewSearchChecker creates a checker for the type of model, e.g. &models.Product{}.
+func NewSearchChecker(o *ORM, model interface{}) *SearchChecker {
+    return &SearchChecker{orm: o, model: model, BatchSize: defaultBackfillBatchSize}
+}
+
+// Run executes Check every interval until the context is cancelled.
+func (c *SearchChecker) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        _, _ = c.Check(ctx)
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// LastReport returns the report of the latest completed check, or nil before the first.
+func (c *SearchChecker) LastReport() *DriftReport {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.last
+}
+
+// Check runs one comparison.
+func (c *SearchChecker) Check(ctx context.Context) (*DriftReport, error) {
+    report, err := c.check(ctx)
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Search Consistency Check", "model": modelName(c.model)})
+        return nil, err
+    }
+    report.Finished = time.Now()
+    c.mu.Lock()
+    c.last = report
+    c.mu.Unlock()
+    utils.LogInfo("Search consistency checked", map[string]interface{}{
+        "model": report.Model, "index": report.Index, "checked": report.Checked,
+        "missing": len(report.Missing), "stale": len(report.Stale), "repaired": report.Repaired,
+    })
+    return report, nil
+}
+
+func (c *SearchChecker) check(ctx context.Context) (*DriftReport, error) {
+    o := c.orm
+    if o.Elasticsearch == nil {
+        return nil, backendDisabled(BackendElasticsearch)
+    }
+    if o.SQL == nil {
+        return nil, backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    index := o.PolicyFor(c.model).Index
+    if index == "" {
+        return nil, fmt.Errorf("check %s: its policy has no search index", modelName(c.model))
+    }
+    stmt := &gorm.Statement{DB: db}
+    if err := stmt.Parse(c.model); err != nil {
+        return nil, err
+    }
+    if stmt.Schema.PrioritizedPrimaryField == nil {
+        return nil, fmt.Errorf("check %s: model has no primary key", modelName(c.model))
+    }
+
+    report := &DriftReport{Model: modelName(c.model), Index: index, Missing: []string{}, Stale: []string{}}
+    err := scanRows(ctx, db, stmt.Schema, c.SampleSize, c.BatchSize, func(batch []interface{}) error {
+        return c.compare(db, stmt.Schema, index, batch, report)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return report, nil
+}
+
+// scanRows passes the rows of the table of s to fn in batches: sample random rows when sample is
+// positive, otherwise every row in primary key order.
+func scanRows(ctx context.Context, db *gorm.DB, s *schema.Schema, sample, batchSize int, fn func([]interface{}) error) error {
+    if sample > 0 {
+        rows := reflect.New(reflect.SliceOf(reflect.PtrTo(s.ModelType)))
+        if err := db.Table(s.Table).Order(randomOrder(db)).Limit(sample).Find(rows.Interface()).Error; err != nil {
+            return utils.HandleSQLError(err)
+        }
+        batch := make([]interface{}, rows.Elem().Len())
+        for i := range batch {
+            batch[i] = rows.Elem().Index(i).Interface()
+        }
+        return fn(batch)
+    }
+
+    lastKey := ""
+    for {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        batch, last, err := readBatch(db, s, lastKey, batchSize)
+        if err != nil {
+            return err
+        }
+        if len(batch) == 0 {
+            return nil
+        }
+        if err := fn(batch); err != nil {
+            return err
+        }
+        lastKey = last
+    }
+}
+
+// compare checks a batch of rows against their documents, repairing them when asked to.
+func (c *SearchChecker) compare(db *gorm.DB, s *schema.Schema, index string, rows []interface{}, report *DriftReport) error {
+    docs := make(map[string]*SearchDocument, len(rows))
+    ids := make([]string, 0, len(rows))
+    for _, row := range rows {
+        doc, err := NewSearchDocument(row)
+        if err != nil {
+            return err
+        }
+        docs[doc.Key] = doc
+        ids = append(ids, doc.Key)
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+
+    var res adapters.SearchResponse
+    query := map[string]interface{}{"query": map[string]interface{}{"ids": map[string]interface{}{"values": ids}}, "size": len(ids)}
+    if err := c.orm.Elasticsearch.Search(index, query, &res); err != nil {
+        return err
+    }
+    indexed := make(map[string]json.RawMessage, len(res.Hits.Hits))
+    for _, hit := range res.Hits.Hits {
+        indexed[hit.ID] = hit.Source
+    }
+
+    var repair []string
+    for _, id := range ids {
+        report.Checked++
+        source, ok := indexed[id]
+        switch {
+        case !ok:
+            report.Missing = append(report.Missing, id)
+        case !sameSource(docs[id].Source, source):
+            report.Stale = append(report.Stale, id)
+        default:
+            continue
+        }
+        repair = append(repair, id)
+    }
+    if !c.Repair || len(repair) == 0 {
+        return nil
+    }
+    fresh, err := rereadRows(db, s, repair)
+    if err != nil {
+        return err
+    }
+    if len(fresh) == 0 {
+        return nil
+    }
+    if err := c.orm.Elasticsearch.BulkIndex(index, fresh); err != nil {
+        return err
+    }
+    report.Repaired += len(fresh)
+    return nil
+}
+
+// rereadRows reads the current SearchDocuments of the rows with the given keys from db, the
+// primary, leaving out rows deleted since they were scanned.
+func rereadRows(db *gorm.DB, s *schema.Schema, keys []string) ([]interface{}, error) {
+    key := s.PrioritizedPrimaryField
+    values := make([]interface{}, len(keys))
+    for i, k := range keys {
+        value, err := parseKey(key, k)
+        if err != nil {
+            return nil, err
+        }
+        values[i] = value
+    }
+    rows := reflect.New(reflect.SliceOf(reflect.PtrTo(s.ModelType)))
+    err := db.Table(s.Table).Where(clause.IN{Column: clause.Column{Name: key.DBName}, Values: values}).Find(rows.Interface()).Error
+    if err != nil {
+        return nil, utils.HandleSQLError(err)
+    }
+    docs := make([]interface{}, rows.Elem().Len())
+    for i := range docs {
+        doc, err := NewSearchDocument(rows.Elem().Index(i).Interface())
+        if err != nil {
+            return nil, err
+        }
+        docs[i] = doc
+    }
+    return docs, nil
+}
+
+// sameSource compares a value, such as a projected document or a row, with its stored JSON, with
+// timestamps compared to the millisecond precision Elasticsearch keeps.
+func sameSource(value interface{}, stored []byte) bool {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return false
+    }
+    var a, b interface{}
+    if json.Unmarshal(data, &a) != nil || json.Unmarshal(stored, &b) != nil {
+        return false
+    }
+    return reflect.DeepEqual(normalizeSource(a), normalizeSource(b))
+}
+
+func normalizeSource(value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for k, item := range v {
+            v[k] = normalizeSource(item)
+        }
+    case []interface{}:
+        for i, item := range v {
+            v[i] = normalizeSource(item)
+        }
+    case string:
+        if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+            return t.UTC().Truncate(time.Millisecond)
+        }
+    }
+    return value
+}
+
+// randomOrder orders rows randomly on MySQL and Postgres.
+func randomOrder(db *gorm.DB) string {
+    if db.Dialector.Name() == "mysql" {
+        return "RAND()"
+    }
+    return "RANDOM()"
+}
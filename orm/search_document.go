@@ -0,0 +1,229 @@
+package orm
+
+import (
+    "encoding/json"
+    "fmt"
+    "persistence-layer/types"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// SearchDocument is the projection of a record that is sent to Elasticsearch: its key and the
+// fields selected for search. Each field's behavior is set with a `search` tag:
+//
+//     type Product struct {
+//         ...
+//         Name         string       `json:"name" search:"text,analyzer=english"`
+//         SKU          string       `json:"sku" search:"keyword"`
+//         Notes        string       `json:"notes" search:"noindex"`
+//         PasswordHash string       `json:"password_hash" search:"-"`
+//         Embedding    types.Vector `json:"embedding" search:"dense_vector,dims=384,similarity=cosine"`
+//     }
+//
+// "-" leaves the field out of the document entirely. Otherwise the tag lists the field type
+// (text, keyword, long, double, boolean, date, object, completion, search_as_you_type, ...)
+// followed by options: analyzer=<name>, search_analyzer=<name>, noindex, which keeps the value in
+// _source without making it searchable, suggest, which adds a "<field>.suggest" completion
+// sub-field for ORM.Suggest, and dims=<n> and similarity=<l2_norm|dot_product|cosine> for the
+// dense_vector embeddings searched by ORM.KnnSearch. Untagged fields are included with a type
+// inferred from their Go type.
+type SearchDocument struct {
+    Key    string
+    Source map[string]interface{}
+}
+
+// GetKey returns the document ID.
+func (d *SearchDocument) GetKey() string {
+    return d.Key
+}
+
+// MarshalJSON encodes the document body.
+func (d *SearchDocument) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.Source)
+}
+
+// NewSearchDocument projects model into the document indexed for it, naming fields after their
+// json tags.
+func NewSearchDocument(model interface{}) (*SearchDocument, error) {
+    key, err := ModelKey(model)
+    if err != nil {
+        return nil, err
+    }
+    v := reflect.ValueOf(model)
+    for v.Kind() == reflect.Ptr {
+        v = v.Elem()
+    }
+    if v.Kind() != reflect.Struct {
+        return nil, fmt.Errorf("cannot index %T: not a struct", model)
+    }
+    fields := searchFieldsOf(v.Type())
+    source := make(map[string]interface{}, len(fields))
+    for _, field := range fields {
+        source[field.name] = v.FieldByIndex(field.index).Interface()
+    }
+    return &SearchDocument{Key: fmt.Sprint(key), Source: source}, nil
+}
+
+// SearchMapping returns the Elasticsearch mapping of model's SearchDocument, for EnsureIndex.
+func SearchMapping(model interface{}) map[string]interface{} {
+    properties := make(map[string]interface{})
+    for _, field := range searchFieldsOf(indirectType(model)) {
+        if field.mapping != nil {
+            properties[field.name] = field.mapping
+        }
+    }
+    return map[string]interface{}{"properties": properties}
+}
+
+// EnsureIndex creates index with the mapping of model when it doesn't exist yet. Existing indices
+// are left alone; changing a mapping requires a reindex.
+func (o *ORM) EnsureIndex(index string, model interface{}) error {
+    return o.invoke("EnsureIndex", BackendElasticsearch, model, index, func() error {
+        if o.Elasticsearch == nil {
+            return backendDisabled(BackendElasticsearch)
+        }
+        return o.Elasticsearch.EnsureIndex(index, SearchMapping(model))
+    })
+}
+
+// searchField is a struct field included in search documents.
+type searchField struct {
+    index   []int
+    name    string
+    mapping map[string]interface{} // nil leaves the field to dynamic mapping.
+}
+
+var searchFieldCache sync.Map // reflect.Type -> []searchField
+
+// searchFieldsOf parses the search tags of t once, flattening embedded structs the way
+// encoding/json does.
+func searchFieldsOf(t reflect.Type) []searchField {
+    if cached, ok := searchFieldCache.Load(t); ok {
+        return cached.([]searchField)
+    }
+    var fields []searchField
+    collectSearchFields(t, nil, &fields)
+    searchFieldCache.Store(t, fields)
+    return fields
+}
+
+func collectSearchFields(t reflect.Type, parent []int, fields *[]searchField) {
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        tag := field.Tag.Get("search")
+        if !field.IsExported() || tag == "-" {
+            continue
+        }
+        name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+        if name == "-" {
+            continue
+        }
+        index := append(append([]int(nil), parent...), i)
+        if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+            collectSearchFields(field.Type, index, fields)
+            continue
+        }
+        if name == "" {
+            name = field.Name
+        }
+        mapping := fieldMapping(field.Type, tag)
+        if tag == "" && mapping != nil && mapping["type"] == "text" && (name == "id" || field.Type.Implements(enumType)) {
+            // Keys and enum values are matched exactly, never analyzed.
+            mapping["type"] = "keyword"
+        }
+        *fields = append(*fields, searchField{index: index, name: name, mapping: mapping})
+    }
+}
+
+// fieldMapping builds the mapping of one field from its search tag and Go type.
+func fieldMapping(t reflect.Type, tag string) map[string]interface{} {
+    mapping := map[string]interface{}{}
+    for i, option := range strings.Split(tag, ",") {
+        option = strings.TrimSpace(option)
+        key, value, hasValue := strings.Cut(option, "=")
+        switch {
+        case option == "":
+        case option == "noindex":
+            mapping["index"] = false
+        case option == "suggest":
+            mapping["fields"] = map[string]interface{}{"suggest": map[string]interface{}{"type": "completion"}}
+        case hasValue && (key == "analyzer" || key == "search_analyzer"):
+            mapping[key] = value
+        case hasValue && key == "dims":
+            dims, _ := strconv.Atoi(value)
+            mapping[key] = dims
+        case hasValue && key == "similarity":
+            mapping[key] = value
+            mapping["index"] = true
+        case i == 0 && !hasValue:
+            mapping["type"] = option
+        }
+    }
+    if _, ok := mapping["type"]; !ok {
+        inferred := inferredMapping(t)
+        if inferred == nil {
+            if len(mapping) == 0 {
+                return nil
+            }
+            inferred = map[string]interface{}{"type": "keyword"}
+        }
+        for k, v := range inferred {
+            mapping[k] = v
+        }
+    }
+    if mapping["index"] == false && mapping["type"] == "object" {
+        // Objects can't be non-indexed; disable them instead.
+        delete(mapping, "index")
+        mapping["enabled"] = false
+    }
+    return mapping
+}
+
+var (
+    timeType          = reflect.TypeOf(time.Time{})
+    decimalType       = reflect.TypeOf(types.Decimal{})
+    jsonMapType       = reflect.TypeOf(types.JSONMap(nil))
+    localizedTextType = reflect.TypeOf(types.LocalizedText(nil))
+    vectorType        = reflect.TypeOf(types.Vector(nil))
+    geoPointType      = reflect.TypeOf(types.GeoPoint{})
+)
+
+// inferredMapping maps Go types to Elasticsearch types, or nil to leave the field to dynamic
+// mapping.
+func inferredMapping(t reflect.Type) map[string]interface{} {
+    for t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    switch t {
+    case timeType:
+        return map[string]interface{}{"type": "date"}
+    case decimalType:
+        return types.DecimalMapping(types.DefaultDecimalScale)
+    case jsonMapType, localizedTextType:
+        return map[string]interface{}{"type": "object"}
+    case vectorType:
+        return map[string]interface{}{"type": "dense_vector"}
+    case geoPointType:
+        return map[string]interface{}{"type": "geo_point"}
+    }
+    switch t.Kind() {
+    case reflect.String:
+        return map[string]interface{}{"type": "text"}
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]interface{}{"type": "long"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "double"}
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return nil
+        }
+        return inferredMapping(t.Elem())
+    }
+    return nil
+}
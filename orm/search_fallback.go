@@ -0,0 +1,244 @@
+package orm
+
+import (
+    "encoding/json"
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+    "strings"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// sqlSearchFallback answers an Elasticsearch query from the SQL table named after the index.
+// match clauses become LIKE conditions, multi_match an OR of LIKEs over its fields, term clauses
+// equality and bool clauses AND their must and filter clauses, negate their must_not clauses and
+// OR their should clauses; the rows are returned in the Elasticsearch response shape so callers
+// decoding hits keep working, with "degraded": true since scoring, highlighting and aggregations
+// are lost. The table is read in the database of the model
+// whose Policy indexes into index, or the default one.
+func (o *ORM) sqlSearchFallback(index string, query map[string]interface{}, result interface{}) error {
+    o, err := o.indexDB(index)
+    if err != nil {
+        return err
+    }
+    if o.SQL == nil {
+        return backendDisabled(BackendElasticsearch)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return backendDisabled(BackendElasticsearch)
+    }
+
+    tx := db.Table(index)
+    root, _ := query["query"].(map[string]interface{})
+    cond, err := searchCondition(root)
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "SearchFallback", "index": index, "query": query})
+        return err
+    }
+    if cond != nil {
+        tx = tx.Where(cond)
+    }
+
+    var total int64
+    if err := tx.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "SearchFallback", "index": index})
+        return utils.HandleSQLError(err)
+    }
+
+    from, size := searchInt(query["from"], 0), searchInt(query["size"], 10)
+    var rows []map[string]interface{}
+    if err := tx.Offset(from).Limit(size).Find(&rows).Error; err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "SearchFallback", "index": index})
+        return utils.HandleSQLError(err)
+    }
+
+    hits := make([]map[string]interface{}, 0, len(rows))
+    for _, row := range rows {
+        hits = append(hits, map[string]interface{}{"_index": index, "_id": fmt.Sprint(row["id"]), "_source": row})
+    }
+    response := map[string]interface{}{
+        "degraded": true,
+        "hits": map[string]interface{}{
+            "total": map[string]interface{}{"value": total, "relation": "eq"},
+            "hits":  hits,
+        },
+    }
+
+    data, err := json.Marshal(response)
+    if err != nil {
+        return err
+    }
+    utils.LogInfo("Search answered from SQL fallback", map[string]interface{}{"index": index, "query": query})
+    return json.Unmarshal(data, result)
+}
+
+// indexDB returns the ORM for the database of the model indexed into index, found among the
+// registered policies. Indexes no policy names are read from the ORM's own database.
+func (o *ORM) indexDB(index string) (*ORM, error) {
+    for t := range o.policies {
+        model := reflect.New(t).Interface()
+        if o.PolicyFor(model).Index == index {
+            return o.modelDB(model)
+        }
+    }
+    return o, nil
+}
+
+// searchCondition translates the match_all, match, multi_match, term and bool
+// (must/filter/must_not/should) query clauses into a condition; nil matches every row. Field
+// names are quoted as identifiers so they cannot inject SQL.
+func searchCondition(query map[string]interface{}) (clause.Expression, error) {
+    var conds []clause.Expression
+    for kind, body := range query {
+        switch kind {
+        case "match_all":
+        case "match", "match_phrase":
+            for field, value := range adapters.QueryFields(body) {
+                conds = append(conds, clause.Like{Column: clause.Column{Name: field}, Value: fmt.Sprintf("%%%v%%", value)})
+            }
+        case "multi_match":
+            m, _ := body.(map[string]interface{})
+            var any []clause.Expression
+            for _, field := range searchClauseFields(m["fields"]) {
+                any = append(any, clause.Like{Column: clause.Column{Name: field}, Value: fmt.Sprintf("%%%v%%", m["query"])})
+            }
+            if len(any) == 0 {
+                return nil, fmt.Errorf("multi_match without fields cannot be answered by the SQL search fallback")
+            }
+            conds = append(conds, clause.Or(any...))
+        case "term":
+            for field, value := range adapters.QueryFields(body) {
+                conds = append(conds, clause.Eq{Column: clause.Column{Name: field}, Value: value})
+            }
+        case "bool":
+            cond, err := boolCondition(body)
+            if err != nil {
+                return nil, err
+            }
+            if cond != nil {
+                conds = append(conds, cond)
+            }
+        default:
+            return nil, fmt.Errorf("query clause %q cannot be answered by the SQL search fallback", kind)
+        }
+    }
+    switch len(conds) {
+    case 0:
+        return nil, nil
+    case 1:
+        return conds[0], nil
+    }
+    return clause.And(conds...), nil
+}
+
+// boolCondition translates a bool query: rows match every must and filter clause, no must_not
+// clause and as many should clauses as adapters.ShouldMatch requires, at most one.
+func boolCondition(body interface{}) (clause.Expression, error) {
+    b, _ := body.(map[string]interface{})
+    var conds []clause.Expression
+    for _, occur := range []string{"must", "filter"} {
+        for _, sub := range adapters.QueryClauses(b[occur]) {
+            cond, err := searchCondition(sub)
+            if err != nil {
+                return nil, err
+            }
+            if cond != nil {
+                conds = append(conds, cond)
+            }
+        }
+    }
+    for _, sub := range adapters.QueryClauses(b["must_not"]) {
+        cond, err := searchCondition(sub)
+        if err != nil {
+            return nil, err
+        }
+        if cond == nil {
+            return matchNone, nil
+        }
+        conds = append(conds, notCondition{cond})
+    }
+    required, err := adapters.ShouldMatch(b)
+    if err != nil {
+        return nil, err
+    }
+    should := adapters.QueryClauses(b["should"])
+    switch {
+    case required > len(should):
+        return matchNone, nil
+    case required > 1:
+        return nil, fmt.Errorf("minimum_should_match of %d cannot be answered by the SQL search fallback", required)
+    case required == 1:
+        var any []clause.Expression
+        for _, sub := range should {
+            cond, err := searchCondition(sub)
+            if err != nil {
+                return nil, err
+            }
+            if cond == nil {
+                any = nil
+                break
+            }
+            any = append(any, cond)
+        }
+        if len(any) > 0 {
+            conds = append(conds, clause.Or(any...))
+        }
+    }
+    switch len(conds) {
+    case 0:
+        return nil, nil
+    case 1:
+        return conds[0], nil
+    }
+    return clause.And(conds...), nil
+}
+
+// matchNone is the condition of a query no row matches, e.g. must_not match_all.
+var matchNone = clause.Expr{SQL: "1 = 0"}
+
+// notCondition negates a condition as a whole; clause.Not distributes over the conditions of an
+// AND instead.
+type notCondition struct {
+    clause.Expression
+}
+
+func (n notCondition) Build(builder clause.Builder) {
+    builder.WriteString("NOT (")
+    n.Expression.Build(builder)
+    builder.WriteByte(')')
+}
+
+// searchClauseFields reads the field list of a multi_match clause, dropping boosts such as "name^2".
+func searchClauseFields(value interface{}) []string {
+    var fields []string
+    switch v := value.(type) {
+    case []string:
+        fields = append(fields, v...)
+    case []interface{}:
+        for _, f := range v {
+            fields = append(fields, fmt.Sprint(f))
+        }
+    }
+    for i, f := range fields {
+        if at := strings.Index(f, "^"); at >= 0 {
+            fields[i] = f[:at]
+        }
+    }
+    return fields
+}
+
+func searchInt(value interface{}, def int) int {
+    switch v := value.(type) {
+    case int:
+        return v
+    case int64:
+        return int(v)
+    case float64:
+        return int(v)
+    }
+    return def
+}
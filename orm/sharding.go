@@ -0,0 +1,351 @@
+package orm
+
+import (
+    "cmp"
+    "context"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "gorm.io/gorm/schema"
+)
+
+// ErrNoShardKey is returned, wrapped, when a write to a sharded model can't tell which shard it
+// addresses because the shard key of the record is unset.
+var ErrNoShardKey = errors.New("no shard key")
+
+var shardSchemas sync.Map // Schemas parsed outside GORM sessions, e.g. to read shard keys.
+
+// shardIndex maps a shard key onto one of n shards with FNV-1a over its decimal or string form, so
+// the uint 42, a *uint pointing to 42 and the string "42" land on the same shard. Changing the
+// number of shards moves most keys, so resharding means copying rows to their new shards offline.
+func shardIndex(value interface{}, n int) int {
+    rv := reflect.ValueOf(value)
+    for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+        rv = rv.Elem()
+    }
+    if rv.IsValid() && rv.Kind() != reflect.Ptr {
+        value = rv.Interface()
+    }
+    h := fnv.New32a()
+    fmt.Fprint(h, value)
+    return int(h.Sum32() % uint32(n))
+}
+
+// Shard returns the ORM for the database holding the records of model's type whose shard key is
+// value, e.g. to run a transaction on one user's rows:
+//
+//     shard, err := o.Shard(&models.User{}, userID)
+//     err = shard.WithTransaction(ctx, func(txORM *orm.ORM) error { ... })
+//
+// Models without shards return their single database.
+func (o *ORM) Shard(model interface{}, value interface{}) (*ORM, error) {
+    policy := o.PolicyFor(model)
+    if len(policy.Shards) == 0 {
+        return o.Database(policy.Database)
+    }
+    return o.Database(policy.Shards[shardIndex(value, len(policy.Shards))])
+}
+
+// Shards returns the ORMs of every database holding records of model's type: its shards, or its
+// single database.
+func (o *ORM) Shards(model interface{}) ([]*ORM, error) {
+    policy := o.PolicyFor(model)
+    names := policy.Shards
+    if len(names) == 0 {
+        names = []string{policy.Database}
+    }
+    dbs := make([]*ORM, len(names))
+    for i, name := range names {
+        db, err := o.Database(name)
+        if err != nil {
+            return nil, err
+        }
+        dbs[i] = db
+    }
+    return dbs, nil
+}
+
+// route returns the ORM for the database holding the record model, whose primary key is key when
+// not nil. Sharded records are located by the shard key: key itself when the shard key is the
+// primary key, otherwise the shard key field of model.
+func (o *ORM) route(policy Policy, key interface{}, model interface{}) (*ORM, error) {
+    if len(policy.Shards) == 0 {
+        return o.Database(policy.Database)
+    }
+    value, err := shardKey(policy, key, model)
+    if err != nil {
+        return nil, err
+    }
+    return o.Database(policy.Shards[shardIndex(value, len(policy.Shards))])
+}
+
+// shardKey returns the shard key of a record; see route.
+func shardKey(policy Policy, key interface{}, model interface{}) (interface{}, error) {
+    field, err := shardField(policy, model)
+    if err != nil {
+        return nil, err
+    }
+    if field.PrimaryKey && key != nil {
+        return key, nil
+    }
+    rv := reflect.ValueOf(model)
+    for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+        rv = rv.Elem()
+    }
+    if rv.Kind() != reflect.Struct {
+        return nil, fmt.Errorf("%w: %s", ErrNoShardKey, modelName(model))
+    }
+    value, zero := field.ValueOf(context.Background(), rv)
+    if zero {
+        return nil, fmt.Errorf("%w: %s.%s is unset", ErrNoShardKey, modelName(model), field.Name)
+    }
+    return value, nil
+}
+
+func shardField(policy Policy, model interface{}) (*schema.Field, error) {
+    s, err := schema.Parse(reflect.New(recordType(model)).Interface(), &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        return nil, err
+    }
+    field := s.LookUpField(policy.ShardKey)
+    if field == nil {
+        return nil, fmt.Errorf("%w: %s has no field %s", ErrNoShardKey, s.Name, policy.ShardKey)
+    }
+    return field, nil
+}
+
+// readRouted runs read against the database holding the record with key. When a sharded record
+// can't be located by its key, every shard is asked in turn until one doesn't fail with
+// utils.ErrNotFound.
+func (o *ORM) readRouted(policy Policy, key interface{}, model interface{}, read func(db *ORM) error) error {
+    db, err := o.route(policy, key, model)
+    if err == nil {
+        return read(db)
+    }
+    if !errors.Is(err, ErrNoShardKey) {
+        return err
+    }
+    shards, err := o.Shards(model)
+    if err != nil {
+        return err
+    }
+    for _, db := range shards {
+        err = read(db)
+        if !errors.Is(err, utils.ErrNotFound) {
+            return err
+        }
+    }
+    return err
+}
+
+// readMany reads the records with keys matching conditions (nil for all) from the database of
+// policy into dest. Sharded records are read from their shards, asking each shard only for its
+// own keys when the shard key is the primary key, and for all of them otherwise.
+func (o *ORM) readMany(policy Policy, keys []interface{}, conditions map[string]interface{}, dest interface{}) error {
+    if len(policy.Shards) == 0 {
+        db, err := o.Database(policy.Database)
+        if err != nil {
+            return err
+        }
+        if db.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        return readManyWhere(db.reader(), keys, conditions, dest)
+    }
+    field, err := shardField(policy, dest)
+    if err != nil {
+        return err
+    }
+    byShard := make(map[int][]interface{})
+    for _, key := range keys {
+        if field.PrimaryKey {
+            i := shardIndex(key, len(policy.Shards))
+            byShard[i] = append(byShard[i], key)
+            continue
+        }
+        for i := range policy.Shards {
+            byShard[i] = append(byShard[i], key)
+        }
+    }
+
+    out := reflect.ValueOf(dest).Elem()
+    out.Set(reflect.MakeSlice(out.Type(), 0, len(keys)))
+    for i, shardKeys := range byShard {
+        db, err := o.Database(policy.Shards[i])
+        if err != nil {
+            return err
+        }
+        if db.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        rows := reflect.New(out.Type())
+        if err := readManyWhere(db.reader(), shardKeys, conditions, rows.Interface()); err != nil {
+            return err
+        }
+        out.Set(reflect.AppendSlice(out, rows.Elem()))
+    }
+    return nil
+}
+
+// readManyWhere reads the records with keys matching conditions from store into dest, in the query
+// when store supports it and by dropping the others from its results otherwise.
+func readManyWhere(store adapters.SQLStore, keys []interface{}, conditions map[string]interface{}, dest interface{}) error {
+    if len(conditions) == 0 {
+        return store.ReadMany(keys, dest)
+    }
+    if reader, ok := store.(adapters.ConditionalReader); ok {
+        return reader.ReadManyWhere(keys, conditions, dest)
+    }
+    if err := store.ReadMany(keys, dest); err != nil {
+        return err
+    }
+    filterRecords(dest, conditions)
+    return nil
+}
+
+// SearchShards runs queryBuilder against every shard of dest's model concurrently, like SearchSQL,
+// and gathers the rows into dest, a pointer to a slice of models. Sort, Limit and Offset apply to
+// the gathered rows: each shard returns up to Offset+Limit rows, which are merged by the sort
+// fields before the page is cut. Models without shards are searched in their single database.
+//
+//     var users []models.User
+//     err := o.SearchShards(utils.NewQueryBuilder().Where("country", "JP").Sort("-created_at").SetLimit(20), &users)
+func (o *ORM) SearchShards(queryBuilder *utils.QueryBuilder, dest interface{}) error {
+    shards, err := o.Shards(dest)
+    if err != nil {
+        return err
+    }
+    if len(shards) == 1 {
+        return shards[0].SearchSQL(queryBuilder, dest)
+    }
+    queryBuilder, err = stableSort(queryBuilder, dest)
+    if err != nil {
+        return err
+    }
+
+    perShard := *queryBuilder
+    perShard.Offset = 0
+    if queryBuilder.Limit > 0 {
+        perShard.Limit = queryBuilder.Limit + queryBuilder.Offset
+    }
+    out := reflect.ValueOf(dest).Elem()
+    results := make([]reflect.Value, len(shards))
+    errs := make([]error, len(shards))
+    var wg sync.WaitGroup
+    for i, db := range shards {
+        wg.Add(1)
+        go func(i int, db *ORM) {
+            defer wg.Done()
+            results[i] = reflect.New(out.Type())
+            errs[i] = db.SearchSQL(&perShard, results[i].Interface())
+        }(i, db)
+    }
+    wg.Wait()
+    for _, err := range errs {
+        if err != nil {
+            return err
+        }
+    }
+
+    rows := reflect.MakeSlice(out.Type(), 0, 0)
+    for _, result := range results {
+        rows = reflect.AppendSlice(rows, result.Elem())
+    }
+    if err := sortRows(rows, queryBuilder.SortFields); err != nil {
+        return err
+    }
+    from := queryBuilder.Offset
+    if from > rows.Len() {
+        from = rows.Len()
+    }
+    to := rows.Len()
+    if queryBuilder.Limit > 0 && from+queryBuilder.Limit < to {
+        to = from + queryBuilder.Limit
+    }
+    out.Set(rows.Slice(from, to))
+    return nil
+}
+
+// sortRows stably sorts a slice of models by columns in QueryBuilder.Sort form, "-" marking
+// descending order.
+func sortRows(rows reflect.Value, columns []string) error {
+    if len(columns) == 0 || rows.Len() < 2 {
+        return nil
+    }
+    s, err := schema.Parse(reflect.New(recordType(rows.Interface())).Interface(), &shardSchemas, schema.NamingStrategy{})
+    if err != nil {
+        return err
+    }
+    type sortField struct {
+        field *schema.Field
+        desc  bool
+    }
+    fields := make([]sortField, len(columns))
+    for i, column := range columns {
+        name := strings.TrimPrefix(column, "-")
+        field := s.LookUpField(name)
+        if field == nil {
+            return fmt.Errorf("cannot sort %s by unknown column %q", s.Name, name)
+        }
+        fields[i] = sortField{field: field, desc: name != column}
+    }
+
+    ctx := context.Background()
+    row := func(i int) reflect.Value {
+        v := rows.Index(i)
+        for v.Kind() == reflect.Ptr {
+            v = v.Elem()
+        }
+        return v
+    }
+    sort.SliceStable(rows.Interface(), func(i, j int) bool {
+        for _, f := range fields {
+            a, _ := f.field.ValueOf(ctx, row(i))
+            b, _ := f.field.ValueOf(ctx, row(j))
+            if c := compareValues(a, b); c != 0 {
+                return (c < 0) != f.desc
+            }
+        }
+        return false
+    })
+    return nil
+}
+
+// compareValues orders two column values of the same type, falling back to their string forms.
+func compareValues(a, b interface{}) int {
+    if ta, ok := a.(time.Time); ok {
+        if tb, ok := b.(time.Time); ok {
+            return ta.Compare(tb)
+        }
+    }
+    va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+    for va.Kind() == reflect.Ptr && !va.IsNil() {
+        va = va.Elem()
+    }
+    for vb.Kind() == reflect.Ptr && !vb.IsNil() {
+        vb = vb.Elem()
+    }
+    if va.Kind() == vb.Kind() {
+        switch va.Kind() {
+        case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+            return cmp.Compare(va.Int(), vb.Int())
+        case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+            return cmp.Compare(va.Uint(), vb.Uint())
+        case reflect.Float32, reflect.Float64:
+            return cmp.Compare(va.Float(), vb.Float())
+        case reflect.String:
+            return strings.Compare(va.String(), vb.String())
+        case reflect.Bool:
+            return strings.Compare(fmt.Sprint(va.Bool()), fmt.Sprint(vb.Bool()))
+        }
+    }
+    return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
@@ -0,0 +1,30 @@
+package orm
+
+import (
+    "persistence-layer/utils"
+    "time"
+)
+
+// LogSlowOperations returns middleware logging the operations slower than threshold as warnings,
+// so slow queries stay visible when the info logs of successful calls are sampled or disabled.
+// threshold is read on every call, so it can change at runtime; 0 logs nothing.
+func LogSlowOperations(threshold func() time.Duration) Middleware {
+    return func(next Handler) Handler {
+        return func(op *Operation) error {
+            err := next(op)
+            if limit := threshold(); limit > 0 {
+                if elapsed := op.Elapsed(); elapsed > limit {
+                    utils.LogWarn("Slow operation", map[string]interface{}{
+                        "operation":  op.Name,
+                        "backend":    op.Backend,
+                        "model":      op.Model,
+                        "target":     op.Target,
+                        "elapsed_ms": elapsed.Milliseconds(),
+                        "failed":     err != nil,
+                    })
+                }
+            }
+            return err
+        }
+    }
+}
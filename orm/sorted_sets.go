@@ -0,0 +1,191 @@
+package orm
+
+import (
+    "math"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "time"
+)
+
+// Leaderboard ranks members, e.g. product IDs, by a score kept in a Redis sorted set, such as the
+// most viewed products:
+//
+//     views := orm.NewLeaderboard(o, "product_views", 100)
+//     _, err := views.Incr(productID, 1)
+//     top, err := views.Top(10)
+//
+// With Size set only the Size highest scored members are kept; a member trimmed away starts from
+// zero if it is incremented again.
+type Leaderboard struct {
+    orm  *ORM
+    Key  string
+    Size int64 // Members kept; 0 keeps all.
+}
+
+// NewLeaderboard creates a leaderboard stored at the cache key "leaderboard:<name>".
+func NewLeaderboard(o *ORM, name string, size int64) *Leaderboard {
+    return &Leaderboard{orm: o, Key: "leaderboard:" + name, Size: size}
+}
+
+// Incr adds by to the score of member and returns its new score.
+func (l *Leaderboard) Incr(member string, by float64) (float64, error) {
+    var score float64
+    err := l.orm.sortedSet("Leaderboard.Incr", l.Key, func(store adapters.CacheStore) error {
+        var err error
+        if score, err = store.ZIncrBy(l.Key, member, by); err != nil {
+            return err
+        }
+        return trimToSize(store, l.Key, l.Size)
+    })
+    return score, err
+}
+
+// Set replaces the score of member.
+func (l *Leaderboard) Set(member string, score float64) error {
+    return l.orm.sortedSet("Leaderboard.Set", l.Key, func(store adapters.CacheStore) error {
+        if err := store.ZAdd(l.Key, adapters.ScoredMember{Member: member, Score: score}); err != nil {
+            return err
+        }
+        return trimToSize(store, l.Key, l.Size)
+    })
+}
+
+// Top returns the n highest scored members, best first.
+func (l *Leaderboard) Top(n int64) ([]adapters.ScoredMember, error) {
+    var top []adapters.ScoredMember
+    err := l.orm.sortedSet("Leaderboard.Top", l.Key, func(store adapters.CacheStore) error {
+        var err error
+        top, err = store.ZRange(l.Key, 0, n-1, true)
+        return err
+    })
+    return top, err
+}
+
+// Between returns up to limit members scored between min and max inclusive, best first. A limit
+// of 0 returns them all.
+func (l *Leaderboard) Between(min, max float64, limit int64) ([]adapters.ScoredMember, error) {
+    var members []adapters.ScoredMember
+    err := l.orm.sortedSet("Leaderboard.Between", l.Key, func(store adapters.CacheStore) error {
+        var err error
+        members, err = store.ZRangeByScore(l.Key, min, max, true, limit)
+        return err
+    })
+    return members, err
+}
+
+// Rank returns the 0-based position of member, 0 being the best, and false when it isn't ranked.
+func (l *Leaderboard) Rank(member string) (int64, bool, error) {
+    var rank int64
+    var ok bool
+    err := l.orm.sortedSet("Leaderboard.Rank", l.Key, func(store adapters.CacheStore) error {
+        var err error
+        rank, ok, err = store.ZRank(l.Key, member, true)
+        return err
+    })
+    return rank, ok, err
+}
+
+// Remove drops members from the leaderboard.
+func (l *Leaderboard) Remove(members ...string) error {
+    return l.orm.sortedSet("Leaderboard.Remove", l.Key, func(store adapters.CacheStore) error {
+        return store.ZRem(l.Key, members...)
+    })
+}
+
+// FeedItem is a member of a feed with the time it was added.
+type FeedItem struct {
+    Member string    `json:"member"`
+    At     time.Time `json:"at"`
+}
+
+// Feed keeps the most recent members, e.g. recently viewed product IDs per user, in a Redis sorted
+// set scored by time. Re-adding a member moves it to the front instead of duplicating it. Items
+// beyond MaxLen, or older than MaxAge, are trimmed on every Add.
+type Feed struct {
+    orm    *ORM
+    Key    string
+    MaxLen int64         // Items kept; 0 keeps all.
+    MaxAge time.Duration // Items older than this are dropped; 0 keeps them.
+}
+
+// NewFeed creates a feed stored at the cache key "feed:<name>".
+func NewFeed(o *ORM, name string, maxLen int64) *Feed {
+    return &Feed{orm: o, Key: "feed:" + name, MaxLen: maxLen}
+}
+
+// Add puts member at time at into the feed.
+func (f *Feed) Add(member string, at time.Time) error {
+    return f.orm.sortedSet("Feed.Add", f.Key, func(store adapters.CacheStore) error {
+        if err := store.ZAdd(f.Key, adapters.ScoredMember{Member: member, Score: float64(at.UnixMilli())}); err != nil {
+            return err
+        }
+        if f.MaxAge > 0 {
+            cutoff := float64(time.Now().Add(-f.MaxAge).UnixMilli())
+            if _, err := store.ZRemRangeByScore(f.Key, math.Inf(-1), cutoff); err != nil {
+                return err
+            }
+        }
+        return trimToSize(store, f.Key, f.MaxLen)
+    })
+}
+
+// Latest returns the n most recent items, newest first.
+func (f *Feed) Latest(n int64) ([]FeedItem, error) {
+    var items []FeedItem
+    err := f.orm.sortedSet("Feed.Latest", f.Key, func(store adapters.CacheStore) error {
+        members, err := store.ZRange(f.Key, 0, n-1, true)
+        items = feedItems(members)
+        return err
+    })
+    return items, err
+}
+
+// Between returns up to limit items added between from and to inclusive, newest first. A limit of
+// 0 returns them all.
+func (f *Feed) Between(from, to time.Time, limit int64) ([]FeedItem, error) {
+    var items []FeedItem
+    err := f.orm.sortedSet("Feed.Between", f.Key, func(store adapters.CacheStore) error {
+        members, err := store.ZRangeByScore(f.Key, float64(from.UnixMilli()), float64(to.UnixMilli()), true, limit)
+        items = feedItems(members)
+        return err
+    })
+    return items, err
+}
+
+// Remove drops members from the feed.
+func (f *Feed) Remove(members ...string) error {
+    return f.orm.sortedSet("Feed.Remove", f.Key, func(store adapters.CacheStore) error {
+        return store.ZRem(f.Key, members...)
+    })
+}
+
+func feedItems(members []adapters.ScoredMember) []FeedItem {
+    items := make([]FeedItem, len(members))
+    for i, m := range members {
+        items[i] = FeedItem{Member: m.Member, At: time.UnixMilli(int64(m.Score))}
+    }
+    return items
+}
+
+// trimToSize keeps the size highest scored members of the set at key; 0 keeps all.
+func trimToSize(store adapters.CacheStore, key string, size int64) error {
+    if size <= 0 {
+        return nil
+    }
+    _, err := store.ZRemRangeByRank(key, 0, -(size + 1))
+    return err
+}
+
+// sortedSet runs a sorted-set operation against Redis through the middleware chain.
+func (o *ORM) sortedSet(name, key string, call func(adapters.CacheStore) error) error {
+    return o.invoke(name, BackendRedis, nil, key, func() error {
+        if o.Redis == nil {
+            return backendDisabled(BackendRedis)
+        }
+        if err := call(o.Redis); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": name, "key": key})
+            return err
+        }
+        return nil
+    })
+}
@@ -0,0 +1,53 @@
+package orm
+
+import (
+    "errors"
+    "fmt"
+    "persistence-layer/utils"
+    "reflect"
+    "strings"
+
+    "gorm.io/gorm/schema"
+)
+
+// stableSort returns a copy of queryBuilder whose sort fields are checked against the columns of
+// model, so sort parameters taken from requests can't inject SQL, and followed by its primary key,
+// so rows with equal sort values keep the same order across pages. Fields may name a column, e.g.
+// "created_at", or a struct field, e.g. "CreatedAt", and are rewritten to the column. Sorting by a
+// field that isn't a column fails with utils.ErrInvalidValue. Results that aren't models, such as
+// maps, are left to the QueryBuilder, which drops sort fields that aren't plain identifiers.
+func stableSort(queryBuilder *utils.QueryBuilder, model interface{}) (*utils.QueryBuilder, error) {
+    s, err := schema.Parse(reflect.New(recordType(model)).Interface(), &shardSchemas, schema.NamingStrategy{})
+    if errors.Is(err, schema.ErrUnsupportedDataType) {
+        return queryBuilder, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    sorted := *queryBuilder
+    sorted.SortFields = make([]string, 0, len(queryBuilder.SortFields)+len(s.PrimaryFields))
+    seen := make(map[string]bool, cap(sorted.SortFields))
+    for _, column := range queryBuilder.SortFields {
+        name := strings.TrimPrefix(column, "-")
+        field := s.LookUpField(name)
+        if field == nil || field.DBName == "" {
+            return nil, fmt.Errorf("%w: cannot sort %s by unknown column %q", utils.ErrInvalidValue, s.Name, name)
+        }
+        if seen[field.DBName] {
+            continue
+        }
+        seen[field.DBName] = true
+        if name != column {
+            sorted.SortFields = append(sorted.SortFields, "-"+field.DBName)
+        } else {
+            sorted.SortFields = append(sorted.SortFields, field.DBName)
+        }
+    }
+    for _, field := range s.PrimaryFields {
+        if !seen[field.DBName] {
+            seen[field.DBName] = true
+            sorted.SortFields = append(sorted.SortFields, field.DBName)
+        }
+    }
+    return &sorted, nil
+}
@@ -0,0 +1,60 @@
+package orm
+
+import (
+    "errors"
+    "persistence-layer/utils"
+    "reflect"
+    "testing"
+)
+
+type sortedRecord struct {
+    ID        uint64 `gorm:"primaryKey"`
+    Name      string
+    CreatedAt int64
+}
+
+func TestStableSort(t *testing.T) {
+    tests := []struct {
+        name    string
+        fields  []string
+        want    []string
+        wantErr error
+    }{
+        {name: "no fields sorts by key", want: []string{"id"}},
+        {name: "column", fields: []string{"name"}, want: []string{"name", "id"}},
+        {name: "struct field", fields: []string{"-CreatedAt"}, want: []string{"-created_at", "id"}},
+        {name: "key kept in place", fields: []string{"-id", "name"}, want: []string{"-id", "name"}},
+        {name: "duplicates dropped", fields: []string{"name", "Name"}, want: []string{"name", "id"}},
+        {name: "unknown column", fields: []string{"password"}, wantErr: utils.ErrInvalidValue},
+        {name: "injection", fields: []string{"name; DROP TABLE users"}, wantErr: utils.ErrInvalidValue},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            qb := utils.NewQueryBuilder().Sort(tt.fields...)
+            sorted, err := stableSort(qb, &[]sortedRecord{})
+            if tt.wantErr != nil {
+                if !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("stableSort = %v, want %v", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("stableSort: %v", err)
+            }
+            if !reflect.DeepEqual(sorted.SortFields, tt.want) {
+                t.Fatalf("sort fields = %q, want %q", sorted.SortFields, tt.want)
+            }
+            if !reflect.DeepEqual(qb.SortFields, tt.fields) {
+                t.Fatalf("stableSort changed the sort fields of its argument to %q", qb.SortFields)
+            }
+        })
+    }
+}
+
+func TestStableSortLeavesMapsToTheQueryBuilder(t *testing.T) {
+    qb := utils.NewQueryBuilder().Sort("anything")
+    sorted, err := stableSort(qb, &[]map[string]interface{}{})
+    if err != nil || sorted != qb {
+        t.Fatalf("stableSort = %v, %v; want the query builder unchanged", sorted, err)
+    }
+}
@@ -0,0 +1,193 @@
+package orm
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "time"
+)
+
+// Task is a job taken from a TaskQueue.
+type Task struct {
+    ID         string
+    Type       string
+    Payload    json.RawMessage
+    Deliveries int64 // 1 on the first attempt.
+}
+
+// Decode unmarshals the task payload into dest.
+func (t Task) Decode(dest interface{}) error {
+    return json.Unmarshal(t.Payload, dest)
+}
+
+// TaskQueue is an at-least-once job queue over a Redis stream with a consumer group, a lighter
+// alternative to Kafka for async persistence jobs in small deployments:
+//
+//     q := orm.NewTaskQueue(o, "reindex", "workers")
+//     _, err := q.Produce("product", map[string]interface{}{"id": 42})
+//     go q.Consume(ctx, func(ctx context.Context, t orm.Task) error { ... })
+//
+// A task is acknowledged once its handler succeeds. Tasks whose handler failed, or whose consumer
+// died, stay pending and are claimed again after ClaimIdle. After MaxDeliveries failed attempts a
+// task is moved to the stream "<Stream>:dead" with its last error.
+//
+// Retries reorder tasks: later ones are handled while a failed one waits for ClaimIdle. An Ordered
+// queue instead retries a failed task in place, holding back the ones after it, and stops
+// consuming, returning an error, once it failed MaxDeliveries times, leaving it pending for an
+// operator rather than skipping it.
+type TaskQueue struct {
+    orm           *ORM
+    Stream        string
+    Group         string
+    Consumer      string        // Unique per process; defaults to "<hostname>-<pid>".
+    MaxLen        int64         // Approximate stream length kept; 0 keeps every entry.
+    BatchSize     int64         // Tasks read per round.
+    Block         time.Duration // How long a read waits for new tasks.
+    ClaimIdle     time.Duration // Pending tasks idle this long are retried.
+    MaxDeliveries int64         // 0 retries forever.
+    // Ordered handles tasks strictly in stream order; run a single consumer of the group.
+    Ordered       bool
+    // decode turns stream entries into tasks, for streams written by other producers than
+    // Produce; nil for entries holding "type" and "payload".
+    decode func(adapters.StreamMessage) Task
+}
+
+// NewTaskQueue creates a queue over the stream "tasks:<name>" consumed by group.
+func NewTaskQueue(o *ORM, name, group string) *TaskQueue {
+    host, _ := os.Hostname()
+    return &TaskQueue{
+        orm:           o,
+        Stream:        "tasks:" + name,
+        Group:         group,
+        Consumer:      fmt.Sprintf("%s-%d", host, os.Getpid()),
+        MaxLen:        100000,
+        BatchSize:     10,
+        Block:         2 * time.Second,
+        ClaimIdle:     time.Minute,
+        MaxDeliveries: 5,
+    }
+}
+
+// Produce appends a task of the given type with the JSON of payload and returns its ID.
+func (q *TaskQueue) Produce(taskType string, payload interface{}) (string, error) {
+    var id string
+    err := q.orm.invoke("TaskQueue.Produce", BackendRedis, nil, q.Stream, func() error {
+        store, err := q.store()
+        if err != nil {
+            return err
+        }
+        data, err := json.Marshal(payload)
+        if err != nil {
+            return err
+        }
+        id, err = store.XAdd(q.Stream, map[string]string{"type": taskType, "payload": string(data)}, q.MaxLen)
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "TaskQueue.Produce", "stream": q.Stream, "type": taskType})
+        }
+        return err
+    })
+    return id, err
+}
+
+// Consume passes tasks to handle, one at a time, until the context is cancelled. Each round first
+// retries stale pending tasks, then reads new ones.
+func (q *TaskQueue) Consume(ctx context.Context, handle func(context.Context, Task) error) error {
+    store, err := q.store()
+    if err != nil {
+        return err
+    }
+    if err := store.XGroupCreate(q.Stream, q.Group); err != nil {
+        return err
+    }
+    claimIdle := q.ClaimIdle
+    if q.Ordered {
+        claimIdle = 0 // Tasks left pending by a previous consumer come before new ones.
+    }
+    for ctx.Err() == nil {
+        messages, err := store.XClaimStale(q.Stream, q.Group, q.Consumer, claimIdle, q.BatchSize)
+        if err == nil && len(messages) == 0 {
+            messages, err = store.XReadGroup(q.Stream, q.Group, q.Consumer, q.BatchSize, q.Block)
+        }
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "TaskQueue.Consume", "stream": q.Stream})
+            select {
+            case <-ctx.Done():
+            case <-time.After(q.Block):
+            }
+            continue
+        }
+        for _, m := range messages {
+            if err := q.process(ctx, store, m, handle); err != nil {
+                return err
+            }
+        }
+    }
+    return ctx.Err()
+}
+
+// process handles one task. It returns an error only when an Ordered queue must stop.
+func (q *TaskQueue) process(ctx context.Context, store adapters.StreamStore, m adapters.StreamMessage, handle func(context.Context, Task) error) error {
+    task := Task{ID: m.ID, Type: m.Values["type"], Payload: json.RawMessage(m.Values["payload"])}
+    if q.decode != nil {
+        task = q.decode(m)
+    }
+    task.ID, task.Deliveries = m.ID, m.Deliveries
+    if task.Deliveries == 0 {
+        task.Deliveries = 1
+    }
+    wait := time.Second
+    for {
+        err := handle(ctx, task)
+        if err == nil {
+            if err := store.XAck(q.Stream, q.Group, m.ID); err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "TaskQueue.Ack", "stream": q.Stream, "id": m.ID})
+            }
+            return nil
+        }
+        fields := map[string]interface{}{"operation": "TaskQueue.Handle", "stream": q.Stream, "id": m.ID, "type": task.Type, "deliveries": task.Deliveries}
+        utils.LogError(err, fields)
+        exhausted := q.MaxDeliveries > 0 && task.Deliveries >= q.MaxDeliveries
+        if q.Ordered {
+            if exhausted {
+                return fmt.Errorf("task queue %s: task %s failed %d times, stopping to keep the stream in order: %w", q.Stream, m.ID, task.Deliveries, err)
+            }
+            select {
+            case <-ctx.Done():
+                return ctx.Err()
+            case <-time.After(wait):
+            }
+            if wait *= 2; wait > q.ClaimIdle {
+                wait = q.ClaimIdle
+            }
+            task.Deliveries++
+            continue
+        }
+        if !exhausted {
+            return nil
+        }
+        dead := map[string]string{"type": task.Type, "payload": string(task.Payload), "id": m.ID, "error": err.Error()}
+        if _, err := store.XAdd(q.Stream+":dead", dead, 0); err != nil {
+            utils.LogError(err, fields)
+            return nil
+        }
+        if err := store.XAck(q.Stream, q.Group, m.ID); err != nil {
+            utils.LogError(err, fields)
+        }
+        return nil
+    }
+}
+
+func (q *TaskQueue) store() (adapters.StreamStore, error) {
+    if q.orm.Redis == nil {
+        return nil, backendDisabled(BackendRedis)
+    }
+    store, ok := q.orm.Redis.(adapters.StreamStore)
+    if !ok {
+        return nil, errors.New("task queue: the cache backend does not support streams")
+    }
+    return store, nil
+}
@@ -1,25 +1,67 @@
 package orm
 
 import (
+    "context"
+    "fmt"
+    "math/rand"
     "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "sync"
+    "time"
 )
 
+// TxRetryPolicy controls how WithTransaction retries a transaction that the database aborted because
+// of a deadlock or serialization failure.
+type TxRetryPolicy struct {
+    MaxAttempts    int // Total runs of the callback, including the first; 1 disables retries.
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+}
+
+// DefaultTxRetryPolicy runs a conflicting transaction up to three times.
+var DefaultTxRetryPolicy = TxRetryPolicy{
+    MaxAttempts:    3,
+    InitialBackoff: 20 * time.Millisecond,
+    MaxBackoff:     500 * time.Millisecond,
+}
+
+// TxConflictError is returned by WithTransaction when every attempt ended in a deadlock or
+// serialization failure. Err is the error of the last attempt.
+type TxConflictError struct {
+    Attempts int
+    Err      error
+}
+
+func (e *TxConflictError) Error() string {
+    return fmt.Sprintf("transaction conflict persisted after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *TxConflictError) Unwrap() error {
+    return e.Err
+}
+
 // Transaction interface defines the operations for a SQL transaction.
 type Transaction interface {
     Commit() error
     Rollback() error
     Create(model interface{}) error
-    Update(model interface{}) error
-    Delete(id uint, model interface{}) error
+    Update(model interface{}) (int64, error)
+    Delete(id uint, model interface{}) (int64, error)
+    DeleteByKey(key interface{}, model interface{}) (int64, error)
 }
 
 // SQLTransaction implements the Transaction interface using a SQL adapter.
 type SQLTransaction struct {
-    tx *adapters.SQLAdapter
+    tx         adapters.SQLStore
+    savepoints int
+
+    mu    sync.Mutex
+    hooks []func(committed bool) // Run once the outcome of the transaction is known.
+    marks map[string]int         // Length of hooks at each savepoint.
 }
 
 // NewSQLTransaction creates a new SQLTransaction using the provided adapter.
-func NewSQLTransaction(adapter *adapters.SQLAdapter) (*SQLTransaction, error) {
+func NewSQLTransaction(adapter adapters.SQLStore) (*SQLTransaction, error) {
     tx, err := adapter.BeginTransaction()
     if err != nil {
         return nil, err
@@ -27,14 +69,82 @@ func NewSQLTransaction(adapter *adapters.SQLAdapter) (*SQLTransaction, error) {
     return &SQLTransaction{tx: tx}, nil
 }
 
-// Commit commits the transaction.
+// Commit commits the transaction, then runs the hooks registered with afterTransaction.
 func (t *SQLTransaction) Commit() error {
-    return t.tx.Commit()
+    err := t.tx.Commit()
+    t.settle(0, err == nil)
+    return err
 }
 
-// Rollback rolls back the transaction.
+// Rollback rolls back the transaction, then runs the hooks registered with afterTransaction.
 func (t *SQLTransaction) Rollback() error {
-    return t.tx.Rollback()
+    err := t.tx.Rollback()
+    t.settle(0, false)
+    return err
+}
+
+// SavePoint marks a new savepoint within the transaction and returns its name.
+func (t *SQLTransaction) SavePoint() (string, error) {
+    t.savepoints++
+    name := fmt.Sprintf("sp_%d", t.savepoints)
+    if err := t.tx.SavePoint(name); err != nil {
+        return "", err
+    }
+    t.mu.Lock()
+    if t.marks == nil {
+        t.marks = make(map[string]int)
+    }
+    t.marks[name] = len(t.hooks)
+    t.mu.Unlock()
+    return name, nil
+}
+
+// RollbackTo undoes the work done since the named savepoint, leaving the transaction open. The
+// hooks registered since run as rolled back.
+func (t *SQLTransaction) RollbackTo(name string) error {
+    if err := t.tx.RollbackTo(name); err != nil {
+        return err
+    }
+    t.mu.Lock()
+    mark, ok := t.marks[name]
+    t.mu.Unlock()
+    if ok {
+        t.settle(mark, false)
+    }
+    return nil
+}
+
+// afterTransaction registers fn to run with the outcome of the transaction once it commits or rolls
+// back, or rolls back to a savepoint taken before the registration.
+func (t *SQLTransaction) afterTransaction(fn func(committed bool)) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.hooks = append(t.hooks, fn)
+}
+
+// settle runs, and forgets, the hooks registered after the first from of them.
+func (t *SQLTransaction) settle(from int, committed bool) {
+    t.mu.Lock()
+    if from > len(t.hooks) {
+        from = len(t.hooks)
+    }
+    hooks := append([]func(committed bool){}, t.hooks[from:]...)
+    t.hooks = t.hooks[:from]
+    t.mu.Unlock()
+    for _, hook := range hooks {
+        hook(committed)
+    }
+}
+
+// ReadForUpdate reads a record and locks its row until the transaction commits or rolls back.
+func (t *SQLTransaction) ReadForUpdate(id uint, model interface{}) error {
+    return t.tx.ReadForUpdate(id, model)
+}
+
+// ClaimBatch locks up to limit rows matching query into dest, skipping rows locked by other
+// transactions.
+func (t *SQLTransaction) ClaimBatch(dest interface{}, limit int, query string, args ...interface{}) error {
+    return t.tx.ClaimBatch(dest, limit, query, args...)
 }
 
 // Create inserts a new record within the transaction.
@@ -43,11 +153,228 @@ func (t *SQLTransaction) Create(model interface{}) error {
 }
 
 // Update updates an existing record within the transaction.
-func (t *SQLTransaction) Update(model interface{}) error {
+func (t *SQLTransaction) Update(model interface{}) (int64, error) {
     return t.tx.Update(model)
 }
 
 // Delete removes a record within the transaction.
-func (t *SQLTransaction) Delete(id uint, model interface{}) error {
+func (t *SQLTransaction) Delete(id uint, model interface{}) (int64, error) {
     return t.tx.Delete(id, model)
 }
+
+// DeleteByKey removes a record by a key of any type within the transaction.
+func (t *SQLTransaction) DeleteByKey(key interface{}, model interface{}) (int64, error) {
+    return t.tx.DeleteByKey(key, model)
+}
+
+// ambientTransaction is handed to ORM operations running inside WithTransaction. Commit and Rollback
+// are no-ops because the enclosing WithTransaction decides the outcome of the whole unit of work.
+type ambientTransaction struct {
+    tx adapters.SQLStore
+}
+
+// Commit is a no-op; WithTransaction commits when its callback succeeds.
+func (t *ambientTransaction) Commit() error {
+    return nil
+}
+
+// Rollback is a no-op; WithTransaction rolls back when its callback fails.
+func (t *ambientTransaction) Rollback() error {
+    return nil
+}
+
+// Create inserts a new record within the ambient transaction.
+func (t *ambientTransaction) Create(model interface{}) error {
+    return t.tx.Create(model)
+}
+
+// Update updates an existing record within the ambient transaction.
+func (t *ambientTransaction) Update(model interface{}) (int64, error) {
+    return t.tx.Update(model)
+}
+
+// Delete removes a record within the ambient transaction.
+func (t *ambientTransaction) Delete(id uint, model interface{}) (int64, error) {
+    return t.tx.Delete(id, model)
+}
+
+// DeleteByKey removes a record by a key of any type within the ambient transaction.
+func (t *ambientTransaction) DeleteByKey(key interface{}, model interface{}) (int64, error) {
+    return t.tx.DeleteByKey(key, model)
+}
+
+// beginTransaction starts the transaction used by a single write operation, or joins the
+// transaction of an enclosing WithTransaction.
+func (o *ORM) beginTransaction() (Transaction, error) {
+    if o.tx != nil {
+        return &ambientTransaction{tx: o.SQL}, nil
+    }
+    return NewSQLTransaction(o.SQL)
+}
+
+// WithTransaction runs fn with an ORM whose SQL operations all share one transaction. The
+// transaction commits when fn returns nil and rolls back when it returns an error or panics.
+// Calling WithTransaction on a txORM nests a savepoint in the enclosing transaction, so a failing
+// inner callback rolls back only its own work; the outer callback decides whether to carry on.
+//
+// When the database aborts the transaction with a deadlock or serialization failure, the outermost
+// WithTransaction re-runs fn according to o.TxRetry, so fn must not have side effects outside the
+// transaction. A *TxConflictError is returned once the attempts are exhausted.
+//
+//     err := o.WithTransaction(ctx, func(txORM *orm.ORM) error {
+//         if _, err := txORM.Create(&post); err != nil {
+//             return err
+//         }
+//         _, err := txORM.Create(&models.Posttag{PostID: post.ID, TagID: tagID})
+//         return err
+//     })
+func (o *ORM) WithTransaction(ctx context.Context, fn func(txORM *ORM) error) error {
+    return o.invoke("WithTransaction", BackendSQL, nil, "", func() error {
+        if o.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        if o.tx != nil {
+            return o.withSavePoint(fn)
+        }
+
+        policy := o.TxRetry
+        if policy.MaxAttempts < 1 {
+            policy.MaxAttempts = 1
+        }
+        if policy.InitialBackoff <= 0 {
+            policy.InitialBackoff = DefaultTxRetryPolicy.InitialBackoff
+        }
+        if policy.MaxBackoff < policy.InitialBackoff {
+            policy.MaxBackoff = policy.InitialBackoff
+        }
+
+        backoff := policy.InitialBackoff
+        for attempt := 1; ; attempt++ {
+            err := o.runTransaction(ctx, fn)
+            if err == nil || !utils.IsTransactionConflict(err) {
+                return err
+            }
+            if attempt >= policy.MaxAttempts {
+                return &TxConflictError{Attempts: attempt, Err: err}
+            }
+            utils.LogError(err, map[string]interface{}{"operation": "WithTransaction", "attempt": attempt, "retrying": true})
+
+            // Full jitter so the transactions that collided don't retry in lockstep.
+            select {
+            case <-ctx.Done():
+                return &TxConflictError{Attempts: attempt, Err: err}
+            case <-time.After(time.Duration(rand.Int63n(int64(backoff)) + 1)):
+            }
+            backoff *= 2
+            if backoff > policy.MaxBackoff {
+                backoff = policy.MaxBackoff
+            }
+        }
+    })
+}
+
+// runTransaction makes a single attempt at running fn in a new transaction.
+func (o *ORM) runTransaction(ctx context.Context, fn func(txORM *ORM) error) (err error) {
+    tx, err := NewSQLTransaction(o.SQL.WithContext(ctx))
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "WithTransaction Begin"})
+        return utils.HandleSQLError(err)
+    }
+
+    txORM := o.clone()
+    txORM.SQL = tx.tx
+    txORM.tx = tx
+    txORM.ctx = ctx
+
+    defer func() {
+        if r := recover(); r != nil {
+            _ = tx.Rollback()
+            panic(r)
+        }
+        if err != nil {
+            if rbErr := tx.Rollback(); rbErr != nil {
+                utils.LogError(rbErr, map[string]interface{}{"operation": "WithTransaction Rollback"})
+            }
+        }
+    }()
+
+    if err = fn(txORM); err != nil {
+        return err
+    }
+    if err = tx.Commit(); err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "WithTransaction Commit"})
+        return fmt.Errorf("committing transaction: %w", err)
+    }
+    o.noteWrite(ctx)
+    return nil
+}
+
+// withSavePoint runs fn inside a savepoint of the ORM's transaction, rolling back to it when fn
+// returns an error or panics.
+func (o *ORM) withSavePoint(fn func(txORM *ORM) error) (err error) {
+    name, err := o.tx.SavePoint()
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "WithTransaction SavePoint"})
+        return utils.HandleSQLError(err)
+    }
+
+    defer func() {
+        if r := recover(); r != nil {
+            _ = o.tx.RollbackTo(name)
+            panic(r)
+        }
+        if err != nil {
+            if rbErr := o.tx.RollbackTo(name); rbErr != nil {
+                utils.LogError(rbErr, map[string]interface{}{"operation": "WithTransaction RollbackTo", "savepoint": name})
+            }
+        }
+    }()
+
+    return fn(o)
+}
+
+// ReadForUpdate reads a record and locks its row for the rest of the transaction. It must be called
+// on the txORM passed to a WithTransaction callback.
+func (o *ORM) ReadForUpdate(id uint, model interface{}) error {
+    return o.invoke("ReadForUpdate", BackendSQL, model, "", func() error {
+        if o.tx == nil {
+            return utils.ErrNotInTransaction
+        }
+        if err := o.tx.ReadForUpdate(id, model); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "ReadForUpdate", "id": id})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+}
+
+// ClaimBatch locks up to limit rows matching query into dest, skipping rows other transactions have
+// already locked, so that several workers can poll the same job or outbox table without processing
+// a row twice. It must be called on the txORM passed to a WithTransaction callback; the claimed rows
+// stay locked until the callback returns.
+//
+//     err := o.WithTransaction(ctx, func(txORM *orm.ORM) error {
+//         var jobs []models.Job
+//         if err := txORM.ClaimBatch(&jobs, 10, "status = ?", "pending"); err != nil {
+//             return err
+//         }
+//         for i := range jobs {
+//             jobs[i].Status = "running"
+//             if _, err := txORM.Update(&jobs[i]); err != nil {
+//                 return err
+//             }
+//         }
+//         return nil
+//     })
+func (o *ORM) ClaimBatch(dest interface{}, limit int, query string, args ...interface{}) error {
+    return o.invoke("ClaimBatch", BackendSQL, dest, "", func() error {
+        if o.tx == nil {
+            return utils.ErrNotInTransaction
+        }
+        if err := o.tx.ClaimBatch(dest, limit, query, args...); err != nil {
+            utils.LogError(utils.RedactError(err), map[string]interface{}{"operation": "ClaimBatch", "query": query})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+}
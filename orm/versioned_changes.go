@@ -0,0 +1,135 @@
+package orm
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "persistence-layer/utils"
+    "sync"
+    "time"
+)
+
+// VersionedChange is a change to a record as delivered to consumers, e.g. in the body of a
+// webhook. Data holds the record's JSON fields as of SchemaVersion, the version of its entity's
+// payload registered in EventSchemas; numbers are json.Number, so 64-bit IDs keep their precision.
+type VersionedChange struct {
+    ID            string                 `json:"id"`
+    Entity        string                 `json:"entity"`
+    Key           string                 `json:"key"`
+    Operation     string                 `json:"operation"` // "Create", "Update" or "Delete".
+    SchemaVersion int                    `json:"schema_version"`
+    OccurredAt    time.Time              `json:"occurred_at"`
+    Tenant        string                 `json:"tenant,omitempty"`
+    Data          map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventUpgrade turns the payload of one version of an entity's change events into the next
+// version's, e.g. after renaming a field:
+//
+//     schemas.Evolve("Product", func(data map[string]interface{}) (map[string]interface{}, error) {
+//         data["title"] = data["name"]
+//         delete(data, "name")
+//         return data, nil
+//     })
+type EventUpgrade func(data map[string]interface{}) (map[string]interface{}, error)
+
+// EventSchemas is the registry of the payload versions of change events, by entity. Every entity
+// starts at version 1; each change to a model that breaks consumers, such as renaming or removing
+// a field or changing its type, registers the upgrade from the previous version with Evolve.
+// Adding a field needs no new version. Producers stamp events with the current version, and
+// consumers bring older events, e.g. replayed from a topic's history, up to it with Upgrade.
+type EventSchemas struct {
+    mu       sync.RWMutex
+    upgrades map[string][]EventUpgrade // upgrades[entity][i] goes from version i+1 to i+2.
+}
+
+// NewEventSchemas creates an empty registry, with every entity at version 1.
+func NewEventSchemas() *EventSchemas {
+    return &EventSchemas{upgrades: make(map[string][]EventUpgrade)}
+}
+
+// Evolve registers the upgrade from the current version of entity's payload to a new one, and
+// returns the new version.
+func (s *EventSchemas) Evolve(entity string, upgrade EventUpgrade) int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.upgrades[entity] = append(s.upgrades[entity], upgrade)
+    return len(s.upgrades[entity]) + 1
+}
+
+// Version returns the current version of entity's payload; 1 for a nil registry.
+func (s *EventSchemas) Version(entity string) int {
+    if s == nil {
+        return 1
+    }
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return len(s.upgrades[entity]) + 1
+}
+
+// Upgrade brings the payload of event to the current version of its entity. Events of a later
+// version than the registry knows fail with utils.ErrInvalidValue: the consumer must be deployed
+// with the producer's upgrades first.
+func (s *EventSchemas) Upgrade(event *VersionedChange) error {
+    var upgrades []EventUpgrade
+    if s != nil {
+        s.mu.RLock()
+        upgrades = s.upgrades[event.Entity]
+        s.mu.RUnlock()
+    }
+    if event.SchemaVersion < 1 {
+        event.SchemaVersion = 1
+    }
+    if event.SchemaVersion > len(upgrades)+1 {
+        return fmt.Errorf("%w: %s change event version %d is newer than the known version %d",
+            utils.ErrInvalidValue, event.Entity, event.SchemaVersion, len(upgrades)+1)
+    }
+    for _, upgrade := range upgrades[event.SchemaVersion-1:] {
+        if event.Data != nil {
+            data, err := upgrade(event.Data)
+            if err != nil {
+                return fmt.Errorf("upgrading %s change event to version %d: %w", event.Entity, event.SchemaVersion+1, err)
+            }
+            event.Data = data
+        }
+        event.SchemaVersion++
+    }
+    return nil
+}
+
+// NewVersionedChange returns the change event of a write of model, stamped with the current
+// version of its entity's payload and the tenant of ctx. Data is left empty for deletes.
+func (s *EventSchemas) NewVersionedChange(ctx context.Context, operation string, key interface{}, model interface{}) (*VersionedChange, error) {
+    if _, ok := webhookEvents[operation]; !ok {
+        return nil, fmt.Errorf("%w: change operation %q", utils.ErrInvalidValue, operation)
+    }
+    if key == nil {
+        var err error
+        if key, err = ModelKey(model); err != nil {
+            return nil, err
+        }
+    }
+    entity := modelName(model)
+    event := &VersionedChange{
+        ID:            auditEventID(),
+        Entity:        entity,
+        Key:           fmt.Sprint(key),
+        Operation:     operation,
+        SchemaVersion: s.Version(entity),
+        OccurredAt:    time.Now().UTC(),
+        Tenant:        TenantFromContext(ctx),
+    }
+    if operation != "Delete" {
+        data, err := json.Marshal(model)
+        if err != nil {
+            return nil, err
+        }
+        decoder := json.NewDecoder(bytes.NewReader(data))
+        decoder.UseNumber()
+        if err := decoder.Decode(&event.Data); err != nil {
+            return nil, err
+        }
+    }
+    return event, nil
+}
@@ -0,0 +1,505 @@
+package orm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "persistence-layer/adapters"
+    "persistence-layer/utils"
+    "reflect"
+    "sync"
+    "time"
+
+    "go.mongodb.org/mongo-driver/mongo"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// DefaultViewFlushInterval is how long changes to the sources of materialized views are collected
+// before the affected rows are recomputed.
+const DefaultViewFlushInterval = time.Second
+
+const (
+    // viewBatchSize is how many keys a statement refreshing a view deletes at most.
+    viewBatchSize = 1000
+    // maxViewPlaceholders bounds the values one upsert binds, under the 65535 of MySQL and Postgres.
+    maxViewPlaceholders = 60000
+)
+
+// ErrUnknownView is returned, wrapped with the name, for views never registered with a ViewMaintainer.
+var ErrUnknownView = errors.New("unknown view")
+
+// ViewSource is a model whose writes change a materialized view.
+type ViewSource struct {
+    Model interface{} // e.g. &models.Comment{}.
+    // Keys returns the keys of the view rows a write to record affects, e.g. the PostID of a
+    // comment. Deletes pass the model given to Delete, so it must carry the fields Keys reads. Nil
+    // uses the record's primary key.
+    Keys func(record interface{}) []interface{}
+}
+
+// MaterializedView is a derived read model, e.g. post_with_counts, stored as rows of a SQL table or
+// documents of a Mongo collection and kept up to date from the writes to its sources.
+type MaterializedView struct {
+    Backend string // BackendSQL or BackendMongo; defaults to BackendSQL.
+    Table   string // Table or collection holding the rows; defaults to the view's name.
+    Key     string // Column identifying a row, e.g. "post_id"; "_id" for Mongo views.
+    Sources []ViewSource
+    // Compute returns the current rows of the view with the given keys, or every row when keys is
+    // nil. Rows that keys name but Compute doesn't return are removed from the view.
+    Compute func(o *ORM, keys []interface{}) ([]map[string]interface{}, error)
+    // Every, when set, also rebuilds the whole view at this interval, e.g. for views over data
+    // written outside the ORM.
+    Every time.Duration
+}
+
+// ViewMaintainer keeps materialized views soft real-time: writes through an ORM whose Views is set,
+// and change events passed to Handle, mark the view rows they affect, and Run recomputes them every
+// FlushInterval, so a burst of writes to a record costs one refresh.
+//
+//     views := orm.NewViewMaintainer(o)
+//     err := views.Register("post_with_counts", orm.MaterializedView{
+//         Key:     "post_id",
+//         Sources: []orm.ViewSource{{Model: &models.Post{}}, {Model: &models.Comment{}, Keys: commentPost}},
+//         Compute: postWithCounts,
+//     })
+//     o.Views = views
+//     go views.Run(ctx)
+//
+// Pending changes are kept in memory, so those not yet flushed are lost with the process; rebuild
+// the views with Refresh after a crash, or set Every.
+type ViewMaintainer struct {
+    orm           *ORM
+    FlushInterval time.Duration
+
+    mu       sync.Mutex
+    views    map[string]*MaterializedView
+    bySource map[reflect.Type][]string         // View names by source type.
+    pending  map[string]map[string]interface{} // Keys to recompute by their string form, by view name.
+}
+
+// NewViewMaintainer creates a maintainer with no views, flushing every DefaultViewFlushInterval.
+func NewViewMaintainer(o *ORM) *ViewMaintainer {
+    return &ViewMaintainer{
+        orm:           o,
+        FlushInterval: DefaultViewFlushInterval,
+        views:         make(map[string]*MaterializedView),
+        bySource:      make(map[reflect.Type][]string),
+        pending:       make(map[string]map[string]interface{}),
+    }
+}
+
+// Register adds a view under name. Call it at startup, before Run.
+func (m *ViewMaintainer) Register(name string, view MaterializedView) error {
+    if view.Compute == nil {
+        return fmt.Errorf("view %s has no Compute function", name)
+    }
+    if view.Backend == "" {
+        view.Backend = BackendSQL
+    }
+    if view.Backend != BackendSQL && view.Backend != BackendMongo {
+        return fmt.Errorf("view %s: %w: backend %q", name, utils.ErrInvalidValue, view.Backend)
+    }
+    if view.Table == "" {
+        view.Table = name
+    }
+    if view.Key == "" {
+        if view.Backend != BackendMongo {
+            return fmt.Errorf("view %s has no Key column", name)
+        }
+        view.Key = "_id"
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.views[name] = &view
+    for _, source := range view.Sources {
+        t := indirectType(source.Model)
+        m.bySource[t] = append(m.bySource[t], name)
+    }
+    return nil
+}
+
+// Handle marks the view rows affected by a change captured from the database log. Pass it to
+// CDCConsumer.OnChange to maintain views from writes made outside the ORM.
+func (m *ViewMaintainer) Handle(ctx context.Context, e ChangeEvent) error {
+    if e.Model != nil {
+        m.changed(e.Model, e.Key)
+    }
+    if e.Before != nil {
+        m.changed(e.Before, e.Key)
+    }
+    return nil
+}
+
+// changed marks the rows of every view sourcing the type of model that a write to it affects.
+func (m *ViewMaintainer) changed(model interface{}, key interface{}) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, name := range m.bySource[indirectType(model)] {
+        view := m.views[name]
+        for _, source := range view.Sources {
+            if indirectType(source.Model) != indirectType(model) {
+                continue
+            }
+            keys := []interface{}{key}
+            if source.Keys != nil {
+                keys = source.Keys(model)
+            } else if key == nil {
+                k, err := ModelKey(model)
+                if err != nil {
+                    continue
+                }
+                keys = []interface{}{k}
+            }
+            m.mark(name, keys...)
+        }
+    }
+}
+
+// sources reports whether a view sources the type of model.
+func (m *ViewMaintainer) sources(model interface{}) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return len(m.bySource[indirectType(model)]) > 0
+}
+
+// viewBefore reads the state a record has before an update or delete, within tx for SQL records,
+// when it feeds a materialized view: the model given to Delete may carry no more than the key,
+// and an update may move the record to another view row, e.g. a comment to another post. It
+// returns the record's key, taken from model when key is nil, and its state, which is nil when no
+// view needs it.
+func (o *ORM) viewBefore(tx Transaction, policy Policy, key interface{}, model interface{}) (interface{}, interface{}, error) {
+    if o.Views == nil || !o.Views.sources(model) {
+        return key, nil, nil
+    }
+    if key == nil {
+        var err error
+        if key, err = ModelKey(model); err != nil {
+            return nil, nil, err
+        }
+    }
+    current := reflect.New(indirectType(model)).Interface()
+    var err error
+    if tx != nil {
+        store := transactionStore(tx)
+        if store == nil {
+            return nil, nil, errNoGormDB
+        }
+        err = store.ReadByKey(key, current)
+    } else if o.Mongo != nil {
+        err = o.Mongo.Read(policy.Collection, map[string]interface{}{"_id": key}, current)
+    } else {
+        return key, nil, nil
+    }
+    if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, mongo.ErrNoDocuments) {
+        // Nothing to mark; the write itself reports the missing record.
+        return key, nil, nil
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    return key, current, nil
+}
+
+// viewsChanged marks the view rows fed by the prior state of a record, read by viewBefore, once
+// its write commits.
+func (o *ORM) viewsChanged(before interface{}, key interface{}) {
+    if o.Views == nil || before == nil {
+        return
+    }
+    if o.tx == nil {
+        o.Views.changed(before, key)
+        return
+    }
+    views := o.Views
+    o.tx.afterTransaction(func(ok bool) {
+        if ok {
+            views.changed(before, key)
+        }
+    })
+}
+
+// Run flushes pending changes every FlushInterval and rebuilds views with Every set on their
+// schedule, until the context is cancelled.
+func (m *ViewMaintainer) Run(ctx context.Context) {
+    interval := m.FlushInterval
+    if interval <= 0 {
+        interval = DefaultViewFlushInterval
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    rebuilt := make(map[string]time.Time)
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        m.Flush()
+        m.mu.Lock()
+        var due []string
+        for name, view := range m.views {
+            if view.Every > 0 && time.Since(rebuilt[name]) >= view.Every {
+                due = append(due, name)
+            }
+        }
+        m.mu.Unlock()
+        for _, name := range due {
+            if err := m.Refresh(name); err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "view": name})
+                continue
+            }
+            rebuilt[name] = time.Now()
+        }
+    }
+}
+
+// Flush recomputes the view rows marked since the last flush. Rows whose refresh fails are marked
+// again for the next one.
+func (m *ViewMaintainer) Flush() {
+    m.mu.Lock()
+    pending := m.pending
+    m.pending = make(map[string]map[string]interface{})
+    m.mu.Unlock()
+    for name, set := range pending {
+        keys := make([]interface{}, 0, len(set))
+        for _, k := range set {
+            keys = append(keys, k)
+        }
+        if err := m.refresh(name, keys); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "view": name, "keys": len(keys)})
+            m.mu.Lock()
+            m.mark(name, keys...)
+            m.mu.Unlock()
+        }
+    }
+}
+
+// mark adds keys to the pending rows of a view. The caller holds m.mu.
+func (m *ViewMaintainer) mark(name string, keys ...interface{}) {
+    if m.pending[name] == nil {
+        m.pending[name] = make(map[string]interface{})
+    }
+    for _, k := range keys {
+        m.pending[name][fmt.Sprint(k)] = k
+    }
+}
+
+// Refresh rebuilds the whole view registered under name, removing rows Compute no longer returns.
+func (m *ViewMaintainer) Refresh(name string) error {
+    return m.refresh(name, nil)
+}
+
+// refresh recomputes the rows of a view with keys, or all of them when keys is nil.
+func (m *ViewMaintainer) refresh(name string, keys []interface{}) error {
+    view, err := m.view(name)
+    if err != nil {
+        return err
+    }
+    o := m.orm
+    return o.invoke("RefreshView", view.Backend, nil, name, func() error {
+        rows, err := view.Compute(o, keys)
+        if err != nil {
+            return err
+        }
+        if view.Backend == BackendMongo {
+            err = o.writeMongoView(view, keys, rows)
+        } else {
+            err = o.writeSQLView(view, keys, rows)
+        }
+        if err != nil {
+            return err
+        }
+        utils.LogInfo("View refreshed", map[string]interface{}{"view": name, "keys": len(keys), "rows": len(rows)})
+        return nil
+    })
+}
+
+// writeSQLView upserts rows into a SQL view and deletes the rows with keys that weren't computed,
+// in one transaction. A full rebuild replaces the table's contents. Statements are split to stay
+// within the placeholders a statement may bind.
+func (o *ORM) writeSQLView(view *MaterializedView, keys []interface{}, rows []map[string]interface{}) error {
+    if o.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    db := o.SQL.GetDB()
+    if db == nil {
+        return errNoGormDB
+    }
+    tx := db.Begin()
+    if tx.Error != nil {
+        return utils.HandleSQLError(tx.Error)
+    }
+    defer tx.Rollback()
+
+    if keys == nil {
+        if err := tx.Table(view.Table).Pluck(view.Key, &keys).Error; err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "table": view.Table})
+            return utils.HandleSQLError(err)
+        }
+    }
+    stale := staleViewKeys(view, keys, rows)
+    for start := 0; start < len(stale); start += viewBatchSize {
+        end := start + viewBatchSize
+        if end > len(stale) {
+            end = len(stale)
+        }
+        err := tx.Table(view.Table).Where(clause.IN{Column: clause.Column{Name: view.Key}, Values: stale[start:end]}).Delete(nil).Error
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "table": view.Table})
+            return utils.HandleSQLError(err)
+        }
+    }
+    if len(rows) > 0 {
+        batch := maxViewPlaceholders / len(rows[0])
+        if batch < 1 {
+            batch = 1
+        }
+        for start := 0; start < len(rows); start += batch {
+            end := start + batch
+            if end > len(rows) {
+                end = len(rows)
+            }
+            err := tx.Table(view.Table).Clauses(clause.OnConflict{
+                Columns:   []clause.Column{{Name: view.Key}},
+                UpdateAll: true,
+            }).Create(rows[start:end]).Error
+            if err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "table": view.Table})
+                return utils.HandleSQLError(err)
+            }
+        }
+    }
+    if err := tx.Commit().Error; err != nil {
+        return utils.HandleSQLError(err)
+    }
+    return nil
+}
+
+// writeMongoView replaces the documents of a Mongo view with keys by the computed rows. A full
+// rebuild replaces the collection's contents, removing the documents Compute no longer returns
+// when the store can list them (see adapters.DocumentPruner).
+func (o *ORM) writeMongoView(view *MaterializedView, keys []interface{}, rows []map[string]interface{}) error {
+    if o.Mongo == nil {
+        return backendDisabled(BackendMongo)
+    }
+    if keys == nil {
+        if pruner, ok := o.Mongo.(adapters.DocumentPruner); ok {
+            existing, err := pruner.FieldValues(view.Table, view.Key)
+            if err != nil {
+                utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "collection": view.Table})
+                return utils.HandleMongoError(err)
+            }
+            stale := staleViewKeys(view, existing, rows)
+            for start := 0; start < len(stale); start += viewBatchSize {
+                end := start + viewBatchSize
+                if end > len(stale) {
+                    end = len(stale)
+                }
+                if _, err := pruner.DeleteIn(view.Table, view.Key, stale[start:end]); err != nil {
+                    utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "collection": view.Table})
+                    return utils.HandleMongoError(err)
+                }
+            }
+        } else {
+            utils.LogWarn("View rebuilt without removing stale documents", map[string]interface{}{"collection": view.Table})
+        }
+    }
+    for _, key := range keys {
+        _, err := o.Mongo.Delete(view.Table, map[string]interface{}{view.Key: key})
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "collection": view.Table, "id": key})
+            return utils.HandleMongoError(err)
+        }
+    }
+    for _, row := range rows {
+        if keys == nil {
+            if _, err := o.Mongo.Delete(view.Table, map[string]interface{}{view.Key: row[view.Key]}); err != nil {
+                return utils.HandleMongoError(err)
+            }
+        }
+        if err := o.Mongo.Create(view.Table, row); err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RefreshView", "collection": view.Table, "id": row[view.Key]})
+            return utils.HandleMongoError(err)
+        }
+    }
+    return nil
+}
+
+// staleViewKeys returns the keys among keys that no computed row has.
+func staleViewKeys(view *MaterializedView, keys []interface{}, rows []map[string]interface{}) []interface{} {
+    computed := make(map[string]bool, len(rows))
+    for _, row := range rows {
+        computed[viewKey(row[view.Key])] = true
+    }
+    var stale []interface{}
+    for _, key := range keys {
+        if !computed[viewKey(key)] {
+            stale = append(stale, key)
+        }
+    }
+    return stale
+}
+
+// viewKey returns the string form of a key, whether computed or scanned from a driver as bytes.
+func viewKey(key interface{}) string {
+    if b, ok := key.([]byte); ok {
+        return string(b)
+    }
+    return fmt.Sprint(key)
+}
+
+// view returns the view registered under name.
+func (m *ViewMaintainer) view(name string) (*MaterializedView, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    view, ok := m.views[name]
+    if !ok {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownView, name)
+    }
+    return view, nil
+}
+
+// ReadView reads the rows of the materialized view name matching filter, column values by column
+// name, into dest. SQL views take a pointer to a struct or to a slice of them; Mongo views read one
+// document. It returns utils.ErrNotFound when no row matches a single-row dest.
+//
+//     var post PostWithCounts
+//     err := o.ReadView("post_with_counts", map[string]interface{}{"post_id": 42}, &post)
+func (o *ORM) ReadView(name string, filter map[string]interface{}, dest interface{}) error {
+    return o.invoke("ReadView", "", dest, name, func() error {
+        if o.Views == nil {
+            return fmt.Errorf("%w: %s", ErrUnknownView, name)
+        }
+        view, err := o.Views.view(name)
+        if err != nil {
+            return err
+        }
+        if view.Backend == BackendMongo {
+            if o.Mongo == nil {
+                return backendDisabled(BackendMongo)
+            }
+            if err := o.Mongo.Read(view.Table, filter, dest); err != nil {
+                return utils.HandleMongoError(err)
+            }
+            return nil
+        }
+
+        if o.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        db := o.reader().GetDB()
+        if db == nil {
+            return errNoGormDB
+        }
+        res := db.Table(view.Table).Where(filter).Find(dest)
+        if res.Error != nil {
+            utils.LogError(res.Error, map[string]interface{}{"operation": "ReadView", "view": name})
+            return utils.HandleSQLError(res.Error)
+        }
+        if res.RowsAffected == 0 && reflect.Indirect(reflect.ValueOf(dest)).Kind() != reflect.Slice {
+            return utils.ErrNotFound
+        }
+        return nil
+    })
+}
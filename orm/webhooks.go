@@ -0,0 +1,609 @@
+package orm
+
+import (
+    "bytes"
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "persistence-layer/types"
+    "persistence-layer/utils"
+    "reflect"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+)
+
+const (
+    defaultWebhookBatchSize   = 50
+    defaultWebhookMaxAttempts = 8
+    defaultWebhookBackoff     = 30 * time.Second
+    defaultWebhookMaxBackoff  = 6 * time.Hour
+)
+
+// Webhook events, named after the write that caused them.
+var webhookEvents = map[string]string{"Create": "created", "Update": "updated", "Delete": "deleted"}
+
+// sealedSecretPrefix marks a WebhookEndpoint.Secret stored encrypted.
+const sealedSecretPrefix = "enc:"
+
+// WebhookPolicy protects the webhook endpoints, set as ORM.Webhooks.
+type WebhookPolicy struct {
+    // SecretKey encrypts the endpoints' signing secrets at rest with AES-256-GCM, keyed by its
+    // SHA-256; RegisterWebhook requires it.
+    SecretKey []byte
+    // AllowPrivateNetworks lets endpoints resolve to loopback, private and link-local addresses,
+    // e.g. for receivers on the same network; otherwise the dispatcher refuses to connect to them.
+    AllowPrivateNetworks bool
+}
+
+// WebhookEndpoint is an HTTP endpoint notified of changes to the records of an entity, e.g. a
+// partner's URL receiving product updates.
+type WebhookEndpoint struct {
+    ID        uint64    `json:"id" gorm:"primaryKey"`
+    Entity    string    `json:"entity" gorm:"size:64;not null;index"` // Model name, e.g. "Product".
+    Events    string    `json:"events" gorm:"size:64"`                // Comma-separated, e.g. "created,updated"; empty for all.
+    URL       string    `json:"url" gorm:"size:2048;not null"`
+    Secret    string    `json:"-" gorm:"size:256;not null"` // Signs the payloads; generated when empty, stored encrypted.
+    Active    bool      `json:"active" gorm:"not null;default:true"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is a notification of one change to one endpoint, written in the transaction of
+// the write that caused it. It is kept after the last attempt, delivered or not, for inspection.
+type WebhookDelivery struct {
+    ID            uint64     `json:"id" gorm:"primaryKey"`
+    EndpointID    uint64     `json:"endpoint_id" gorm:"not null;index"`
+    Event         string     `json:"event" gorm:"size:64;not null"` // e.g. "product.updated".
+    RecordKey     string     `json:"record_key" gorm:"size:64;not null"`
+    Payload       types.JSON `json:"payload"`
+    Attempts      int        `json:"attempts"`
+    LastStatus    int        `json:"last_status"` // HTTP status of the last attempt; 0 when it got no response.
+    LastError     string     `json:"last_error" gorm:"type:text"`
+    NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+    DeliveredAt   *time.Time `json:"delivered_at"`
+    FailedAt      *time.Time `json:"failed_at"` // Set when MaxAttempts were used up.
+    CreatedAt     time.Time  `json:"created_at"`
+}
+
+// WebhookPayload is the JSON body posted to endpoints. Receivers bring the Data of older
+// SchemaVersions to the version they know with the upgrades registered in ORM.EventSchemas.
+type WebhookPayload struct {
+    ID            uint64      `json:"id"` // Delivery ID, the same across retries; receivers may dedupe by it.
+    Event         string      `json:"event"`
+    Entity        string      `json:"entity"`
+    Key           string      `json:"key"`
+    SchemaVersion int         `json:"schema_version"`
+    Data          interface{} `json:"data,omitempty"` // The record after the write; absent for deletes.
+    OccurredAt    time.Time   `json:"occurred_at"`
+}
+
+// EnableWebhooks creates or migrates the webhook tables and makes Create, Update and Delete of the
+// given models, e.g. &models.Product{}, record a delivery for every active endpoint registered for
+// the event, in the write's transaction. A WebhookDispatcher sends them. The models must live in the
+// default database, which holds the webhook tables, so call it at startup after SetPolicy.
+func (o *ORM) EnableWebhooks(models ...interface{}) error {
+    store, err := o.Database(DefaultDatabase)
+    if err != nil {
+        return err
+    }
+    if store.SQL == nil {
+        return backendDisabled(BackendSQL)
+    }
+    db := store.SQL.GetDB()
+    if db == nil {
+        return errNoGormDB
+    }
+    if err := migrating(db).AutoMigrate(&WebhookEndpoint{}, &WebhookDelivery{}); err != nil {
+        return err
+    }
+    if o.webhooked == nil {
+        o.webhooked = make(map[reflect.Type]bool)
+    }
+    for _, model := range models {
+        policy := o.PolicyFor(model)
+        if !policy.SQL || len(policy.Shards) > 0 || policy.Database != "" && policy.Database != DefaultDatabase {
+            return fmt.Errorf("webhooks for %s: records must be stored in the %s SQL database", modelName(model), DefaultDatabase)
+        }
+        o.webhooked[indirectType(model)] = true
+    }
+    return nil
+}
+
+// RegisterWebhook stores an endpoint, generating its secret when empty, and returns it with its ID
+// and secret set, e.g. to hand the secret to the partner. The secret is stored encrypted with
+// Webhooks.SecretKey. URLs naming a private address are refused unless
+// Webhooks.AllowPrivateNetworks; the dispatcher checks the addresses host names resolve to.
+//
+//     endpoint := &orm.WebhookEndpoint{Entity: "Product", Events: "updated", URL: "https://partner.example/hooks"}
+//     err := o.RegisterWebhook(endpoint)
+func (o *ORM) RegisterWebhook(endpoint *WebhookEndpoint) error {
+    return o.invoke("RegisterWebhook", BackendSQL, endpoint, "", func() error {
+        o, err := o.Database(DefaultDatabase)
+        if err != nil {
+            return err
+        }
+        if o.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        if len(o.Webhooks.SecretKey) == 0 {
+            return errors.New("webhooks: Webhooks.SecretKey is required to store the signing secret")
+        }
+        if err := o.checkWebhookURL(endpoint.URL); err != nil {
+            return err
+        }
+        for _, event := range splitEvents(endpoint.Events) {
+            if !isWebhookEvent(event) {
+                return fmt.Errorf("%w: webhook event %q", utils.ErrInvalidValue, event)
+            }
+        }
+        if endpoint.Secret == "" {
+            secret := make([]byte, 32)
+            if _, err := rand.Read(secret); err != nil {
+                return err
+            }
+            endpoint.Secret = hex.EncodeToString(secret)
+        }
+        secret := endpoint.Secret
+        sealed, err := sealWebhookSecret(o.Webhooks.SecretKey, secret)
+        if err != nil {
+            return err
+        }
+        endpoint.Active = true
+        endpoint.Secret = sealed
+        err = o.SQL.Create(endpoint)
+        endpoint.Secret = secret
+        if err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "RegisterWebhook", "entity": endpoint.Entity})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+}
+
+// checkWebhookURL rejects endpoint URLs other than http(s) ones, and those naming a private address
+// unless Webhooks.AllowPrivateNetworks.
+func (o *ORM) checkWebhookURL(raw string) error {
+    u, err := url.Parse(raw)
+    if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Hostname() == "" {
+        return fmt.Errorf("%w: webhook URL %q", utils.ErrInvalidValue, raw)
+    }
+    if o.Webhooks.AllowPrivateNetworks {
+        return nil
+    }
+    host := strings.ToLower(u.Hostname())
+    if ip := net.ParseIP(host); ip != nil && privateAddress(ip) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+        return fmt.Errorf("%w: webhook URL %q names a private address", utils.ErrInvalidValue, raw)
+    }
+    return nil
+}
+
+// cgnat is the shared address space of carrier-grade NAT, RFC 6598.
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// privateAddress reports whether ip is loopback, private, link-local (including the cloud metadata
+// address 169.254.169.254), unspecified or multicast, which webhooks must not reach by default.
+func privateAddress(ip net.IP) bool {
+    return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+        ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+// sealWebhookSecret encrypts a signing secret with AES-256-GCM under the SHA-256 of key.
+func sealWebhookSecret(key []byte, secret string) (string, error) {
+    aead, err := webhookCipher(key)
+    if err != nil {
+        return "", err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+    return sealedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openWebhookSecret decrypts a secret stored by sealWebhookSecret. Secrets stored in plaintext
+// before encryption was introduced are returned as they are.
+func openWebhookSecret(key []byte, stored string) (string, error) {
+    if !strings.HasPrefix(stored, sealedSecretPrefix) {
+        return stored, nil
+    }
+    aead, err := webhookCipher(key)
+    if err != nil {
+        return "", err
+    }
+    sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedSecretPrefix))
+    if err != nil || len(sealed) < aead.NonceSize() {
+        return "", errors.New("webhooks: malformed encrypted secret")
+    }
+    secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+    if err != nil {
+        return "", errors.New("webhooks: the secret does not decrypt with Webhooks.SecretKey")
+    }
+    return string(secret), nil
+}
+
+func webhookCipher(key []byte) (cipher.AEAD, error) {
+    if len(key) == 0 {
+        return nil, errors.New("webhooks: Webhooks.SecretKey is required to decrypt signing secrets")
+    }
+    sum := sha256.Sum256(key)
+    block, err := aes.NewCipher(sum[:])
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// WebhookDeliveries returns the latest deliveries to an endpoint, newest first, e.g. to show a
+// partner why notifications failed.
+func (o *ORM) WebhookDeliveries(endpointID uint64, limit int) ([]WebhookDelivery, error) {
+    var deliveries []WebhookDelivery
+    err := o.invoke("WebhookDeliveries", BackendSQL, &deliveries, "", func() error {
+        o, err := o.Database(DefaultDatabase)
+        if err != nil {
+            return err
+        }
+        if o.SQL == nil {
+            return backendDisabled(BackendSQL)
+        }
+        db := o.SQL.GetDB()
+        if db == nil {
+            return errNoGormDB
+        }
+        if err := db.Where("endpoint_id = ?", endpointID).Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+            utils.LogError(err, map[string]interface{}{"operation": "WebhookDeliveries", "endpoint": endpointID})
+            return utils.HandleSQLError(err)
+        }
+        return nil
+    })
+    return deliveries, err
+}
+
+// enqueueWebhooks records the deliveries of a write in tx when the model has webhooks enabled.
+func (o *ORM) enqueueWebhooks(tx Transaction, operation string, key interface{}, model interface{}) error {
+    if !o.webhooked[indirectType(model)] {
+        return nil
+    }
+    store := transactionStore(tx)
+    if store == nil || store.GetDB() == nil {
+        return errNoGormDB
+    }
+    db := store.GetDB()
+    entity := modelName(model)
+    event := webhookEvents[operation]
+    var endpoints []WebhookEndpoint
+    if err := db.Where("entity = ? AND active = ?", entity, true).Find(&endpoints).Error; err != nil || len(endpoints) == 0 {
+        return err
+    }
+    change, err := o.EventSchemas.NewVersionedChange(o.opContext(), operation, key, model)
+    if err != nil {
+        return err
+    }
+    payload := WebhookPayload{
+        Event:         strings.ToLower(entity) + "." + event,
+        Entity:        entity,
+        Key:           change.Key,
+        SchemaVersion: change.SchemaVersion,
+        OccurredAt:    change.OccurredAt,
+    }
+    if change.Data != nil {
+        payload.Data = change.Data
+    }
+    data, err := types.NewJSON(payload)
+    if err != nil {
+        return err
+    }
+    for _, endpoint := range endpoints {
+        if !endpoint.wants(event) {
+            continue
+        }
+        delivery := &WebhookDelivery{
+            EndpointID:    endpoint.ID,
+            Event:         payload.Event,
+            RecordKey:     payload.Key,
+            Payload:       data,
+            NextAttemptAt: payload.OccurredAt,
+        }
+        if err := db.Create(delivery).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// wants reports whether the endpoint subscribes to event, e.g. "updated".
+func (e WebhookEndpoint) wants(event string) bool {
+    events := splitEvents(e.Events)
+    if len(events) == 0 {
+        return true
+    }
+    for _, subscribed := range events {
+        if subscribed == event {
+            return true
+        }
+    }
+    return false
+}
+
+func splitEvents(events string) []string {
+    var out []string
+    for _, event := range strings.Split(events, ",") {
+        if event = strings.TrimSpace(event); event != "" {
+            out = append(out, event)
+        }
+    }
+    return out
+}
+
+func isWebhookEvent(event string) bool {
+    for _, known := range webhookEvents {
+        if event == known {
+            return true
+        }
+    }
+    return false
+}
+
+// WebhookDispatcher posts pending webhook deliveries. A delivery succeeds on a 2xx response;
+// otherwise it is retried with exponential backoff until MaxAttempts. Several dispatchers may run
+// against the same database: each batch is claimed with SKIP LOCKED like the outbox and leased for
+// Lease in a short transaction, then posted outside of it, and the outcome of each delivery is
+// recorded as soon as it is known. A delivery whose dispatcher died, or whose outcome could not be
+// recorded, is posted again once its lease expires, so receivers should dedupe by X-Webhook-Id.
+//
+// The dispatcher's client refuses to connect to private addresses unless
+// ORM.Webhooks.AllowPrivateNetworks, whatever a host name resolves to or redirects to.
+//
+// Each request carries the headers X-Webhook-Id, X-Webhook-Event, X-Webhook-Timestamp and
+// X-Webhook-Signature, "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>" keyed by
+// the endpoint's secret. Receivers should check it and reject old timestamps.
+type WebhookDispatcher struct {
+    orm         *ORM
+    Client      *http.Client
+    BatchSize   int           // Defaults to 50.
+    MaxAttempts int           // Defaults to 8.
+    Backoff     time.Duration // Wait before the first retry, doubled on each further one; defaults to 30s.
+    MaxBackoff  time.Duration // Defaults to 6h.
+    // Lease is how long a claimed delivery is reserved for this dispatcher; defaults to the time
+    // posting a whole batch may take, plus a minute.
+    Lease       time.Duration
+}
+
+// NewWebhookDispatcher creates a dispatcher over the webhook tables of o's default database with a
+// 10 second request timeout.
+func NewWebhookDispatcher(o *ORM) *WebhookDispatcher {
+    if db, err := o.Database(DefaultDatabase); err == nil {
+        o = db
+    }
+    d := &WebhookDispatcher{
+        orm:         o,
+        BatchSize:   defaultWebhookBatchSize,
+        MaxAttempts: defaultWebhookMaxAttempts,
+        Backoff:     defaultWebhookBackoff,
+        MaxBackoff:  defaultWebhookMaxBackoff,
+    }
+    dialer := &net.Dialer{Timeout: 10 * time.Second, Control: d.checkDial}
+    d.Client = &http.Client{
+        Timeout:   10 * time.Second,
+        Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second, MaxIdleConnsPerHost: 4},
+    }
+    return d
+}
+
+// checkDial refuses connections to private addresses, checked on the address actually dialed so a
+// host name resolving to one, or a redirect to one, is caught too.
+func (d *WebhookDispatcher) checkDial(network, address string, _ syscall.RawConn) error {
+    if d.orm.Webhooks.AllowPrivateNetworks {
+        return nil
+    }
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+    if ip := net.ParseIP(host); ip == nil || privateAddress(ip) {
+        return fmt.Errorf("webhooks: refusing to connect to private address %s", host)
+    }
+    return nil
+}
+
+// Run executes RunOnce every interval until the context is cancelled, draining due deliveries
+// between ticks.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        for {
+            n, err := d.RunOnce(ctx)
+            if err != nil || n < d.BatchSize {
+                break
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// RunOnce claims one batch of due deliveries, oldest first, leasing them, then attempts them and
+// records each outcome. It returns the number of deliveries claimed.
+func (d *WebhookDispatcher) RunOnce(ctx context.Context) (int, error) {
+    var deliveries []WebhookDelivery
+    var endpoints map[uint64]*WebhookEndpoint
+    err := d.orm.WithTransaction(ctx, func(txORM *ORM) error {
+        now := time.Now().UTC()
+        err := txORM.ClaimBatch(&deliveries, d.BatchSize, "delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now)
+        if err != nil || len(deliveries) == 0 {
+            return err
+        }
+        if endpoints, err = d.endpoints(txORM, deliveries); err != nil {
+            return err
+        }
+        for i := range deliveries {
+            deliveries[i].Attempts++
+            deliveries[i].NextAttemptAt = now.Add(d.lease())
+            if _, err := txORM.SQL.Update(&deliveries[i]); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        utils.LogError(err, map[string]interface{}{"operation": "Webhook Dispatch"})
+        return 0, err
+    }
+    for i := range deliveries {
+        if ctx.Err() != nil {
+            break // The rest are retried when their lease expires.
+        }
+        d.attempt(ctx, &deliveries[i], endpoints[deliveries[i].EndpointID])
+        d.record(&deliveries[i])
+    }
+    if len(deliveries) > 0 {
+        utils.LogInfo("Webhook deliveries attempted", map[string]interface{}{"count": len(deliveries)})
+    }
+    return len(deliveries), nil
+}
+
+// lease returns how long claimed deliveries are reserved.
+func (d *WebhookDispatcher) lease() time.Duration {
+    if d.Lease > 0 {
+        return d.Lease
+    }
+    return time.Duration(d.BatchSize)*d.Client.Timeout + time.Minute
+}
+
+// record stores the outcome of an attempt, retrying a few times: a delivery whose success is not
+// recorded is posted again when its lease expires.
+func (d *WebhookDispatcher) record(delivery *WebhookDelivery) {
+    var err error
+    for wait := 100 * time.Millisecond; ; wait *= 4 {
+        if _, err = d.orm.SQL.Update(delivery); err == nil {
+            return
+        }
+        if wait > 2*time.Second {
+            break
+        }
+        time.Sleep(wait)
+    }
+    utils.LogError(err, map[string]interface{}{"operation": "Webhook Delivery", "delivery": delivery.ID, "delivered": delivery.DeliveredAt != nil,
+        "note": "outcome not recorded; the delivery is attempted again when its lease expires"})
+}
+
+// endpoints loads the endpoints of deliveries by ID.
+func (d *WebhookDispatcher) endpoints(txORM *ORM, deliveries []WebhookDelivery) (map[uint64]*WebhookEndpoint, error) {
+    ids := make([]uint64, 0, len(deliveries))
+    for _, delivery := range deliveries {
+        ids = append(ids, delivery.EndpointID)
+    }
+    db := txORM.SQL.GetDB()
+    if db == nil {
+        return nil, errNoGormDB
+    }
+    var endpoints []WebhookEndpoint
+    if err := db.Where("id IN ?", ids).Find(&endpoints).Error; err != nil {
+        return nil, err
+    }
+    byID := make(map[uint64]*WebhookEndpoint, len(endpoints))
+    for i := range endpoints {
+        secret, err := openWebhookSecret(d.orm.Webhooks.SecretKey, endpoints[i].Secret)
+        if err != nil {
+            return nil, fmt.Errorf("endpoint %d: %w", endpoints[i].ID, err)
+        }
+        endpoints[i].Secret = secret
+        byID[endpoints[i].ID] = &endpoints[i]
+    }
+    return byID, nil
+}
+
+// attempt posts a delivery to its endpoint and records the outcome on it.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *WebhookDelivery, endpoint *WebhookEndpoint) {
+    now := time.Now().UTC()
+    if endpoint == nil || !endpoint.Active {
+        delivery.LastError = "endpoint removed or deactivated"
+        delivery.FailedAt = &now
+        return
+    }
+    status, err := d.post(ctx, delivery, endpoint)
+    delivery.LastStatus = status
+    if err == nil {
+        delivery.LastError = ""
+        delivery.DeliveredAt = &now
+        return
+    }
+    delivery.LastError = err.Error()
+    utils.LogError(err, map[string]interface{}{"operation": "Webhook Delivery", "delivery": delivery.ID, "endpoint": endpoint.ID, "attempts": delivery.Attempts})
+    if delivery.Attempts >= d.MaxAttempts {
+        delivery.FailedAt = &now
+        return
+    }
+    delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+}
+
+// post sends the signed payload of a delivery and returns the response status.
+func (d *WebhookDispatcher) post(ctx context.Context, delivery *WebhookDelivery, endpoint *WebhookEndpoint) (int, error) {
+    var payload WebhookPayload
+    if err := delivery.Payload.Decode(&payload); err != nil {
+        return 0, err
+    }
+    payload.ID = delivery.ID
+    body, err := types.NewJSON(payload)
+    if err != nil {
+        return 0, err
+    }
+    timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Webhook-Id", strconv.FormatUint(delivery.ID, 10))
+    req.Header.Set("X-Webhook-Event", delivery.Event)
+    req.Header.Set("X-Webhook-Timestamp", timestamp)
+    req.Header.Set("X-Webhook-Signature", SignWebhook(endpoint.Secret, timestamp, body))
+    resp, err := d.Client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // Lets the connection be reused.
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, errors.New("endpoint answered " + resp.Status)
+    }
+    return resp.StatusCode, nil
+}
+
+// backoff returns the wait before the retry following attempt n.
+func (d *WebhookDispatcher) backoff(n int) time.Duration {
+    wait := d.Backoff
+    for i := 1; i < n && wait < d.MaxBackoff; i++ {
+        wait *= 2
+    }
+    if wait > d.MaxBackoff {
+        wait = d.MaxBackoff
+    }
+    return wait
+}
+
+// SignWebhook returns the X-Webhook-Signature of body sent at timestamp, for receivers to compare
+// with hmac.Equal.
+func SignWebhook(secret, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(timestamp))
+    mac.Write([]byte{'.'})
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
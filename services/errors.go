@@ -0,0 +1,48 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "persistence-layer/utils"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// errorCodes maps the errors of the ORM to the gRPC codes the services answer with, so clients can
+// tell a missing record from a failing database.
+var errorCodes = []struct {
+    err  error
+    code codes.Code
+}{
+    {utils.ErrNotFound, codes.NotFound},
+    {utils.ErrAlreadyExists, codes.AlreadyExists},
+    {utils.ErrForeignKeyViolation, codes.FailedPrecondition},
+    {utils.ErrInvalidValue, codes.InvalidArgument},
+    {utils.ErrPermissionDenied, codes.PermissionDenied},
+    {utils.ErrVersionConflict, codes.Aborted},
+    {utils.ErrQuotaExceeded, codes.ResourceExhausted},
+    {utils.ErrStatementTimeout, codes.DeadlineExceeded},
+    {utils.ErrCircuitOpen, codes.Unavailable},
+    {utils.ErrBackendDisabled, codes.Unavailable},
+    {utils.ErrReadOnly, codes.Unavailable},
+    {context.DeadlineExceeded, codes.DeadlineExceeded},
+    {context.Canceled, codes.Canceled},
+}
+
+// statusError converts an error of the ORM into a gRPC status error. Errors that already carry a
+// status pass through; unknown errors become Internal.
+func statusError(err error) error {
+    if err == nil {
+        return nil
+    }
+    if _, ok := status.FromError(err); ok {
+        return err
+    }
+    for _, mapping := range errorCodes {
+        if errors.Is(err, mapping.err) {
+            return status.Error(mapping.code, err.Error())
+        }
+    }
+    return status.Error(codes.Internal, err.Error())
+}
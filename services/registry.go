@@ -0,0 +1,132 @@
+package services
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "persistence-layer/orm"
+    "sort"
+    "sync"
+
+    "google.golang.org/grpc"
+)
+
+// Service is a gRPC service served by the persistence layer.
+type Service interface {
+    Register(server *grpc.Server)
+}
+
+// Initializer is implemented by services that prepare before serving, e.g. by warming caches or
+// ensuring their Elasticsearch indexes exist. A failing Init aborts startup.
+type Initializer interface {
+    Init(ctx context.Context) error
+}
+
+// Shutdowner is implemented by services that release resources or flush state once the server
+// stopped taking calls.
+type Shutdowner interface {
+    Shutdown(ctx context.Context) error
+}
+
+// Factory builds a service on the server's ORM.
+type Factory func(o *orm.ORM) Service
+
+var (
+    registryMu sync.Mutex
+    registry   = make(map[string]Factory)
+)
+
+// Add registers the factory of the service name, e.g. "ProductService". The generated service
+// files call it from init, so a service is served as soon as its file is compiled in. Adding a
+// name twice panics.
+func Add(name string, factory Factory) {
+    registryMu.Lock()
+    defer registryMu.Unlock()
+    if _, ok := registry[name]; ok {
+        panic(fmt.Sprintf("services: %s registered twice", name))
+    }
+    registry[name] = factory
+}
+
+// namedService is a built service with the name it was added under.
+type namedService struct {
+    name    string
+    service Service
+}
+
+// Set is the registered services built on an ORM, sorted by name so they register, initialize and
+// shut down in the same order on every start.
+type Set struct {
+    services    []namedService
+    initialized int // Services whose Init succeeded, from the start of services.
+}
+
+// Build builds every registered service on o.
+func Build(o *orm.ORM) *Set {
+    registryMu.Lock()
+    defer registryMu.Unlock()
+    names := make([]string, 0, len(registry))
+    for name := range registry {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    s := &Set{services: make([]namedService, len(names))}
+    for i, name := range names {
+        s.services[i] = namedService{name: name, service: registry[name](o)}
+    }
+    return s
+}
+
+// Names returns the names of the services in order.
+func (s *Set) Names() []string {
+    names := make([]string, len(s.services))
+    for i, svc := range s.services {
+        names[i] = svc.name
+    }
+    return names
+}
+
+// Register registers every service with server.
+func (s *Set) Register(server *grpc.Server) {
+    for _, svc := range s.services {
+        svc.service.Register(server)
+        log.Printf("Registered service: %s", svc.name)
+    }
+}
+
+// Init runs the Init hooks in order, stopping at the first failure after shutting down the
+// services already initialized.
+func (s *Set) Init(ctx context.Context) error {
+    for i, svc := range s.services[s.initialized:] {
+        if initializer, ok := svc.service.(Initializer); ok {
+            if err := initializer.Init(ctx); err != nil {
+                s.initialized += i
+                if shutdownErr := s.Shutdown(ctx); shutdownErr != nil {
+                    log.Printf("Shutting down services after a failed Init: %v", shutdownErr)
+                }
+                return fmt.Errorf("initializing %s: %w", svc.name, err)
+            }
+        }
+    }
+    s.initialized = len(s.services)
+    return nil
+}
+
+// Shutdown runs the Shutdown hooks of the initialized services in reverse order. Every hook runs
+// even when an earlier one fails; the first error is returned.
+func (s *Set) Shutdown(ctx context.Context) error {
+    var first error
+    for i := s.initialized - 1; i >= 0; i-- {
+        svc := s.services[i]
+        if shutdowner, ok := svc.service.(Shutdowner); ok {
+            if err := shutdowner.Shutdown(ctx); err != nil {
+                log.Printf("Shutting down %s failed: %v", svc.name, err)
+                if first == nil {
+                    first = fmt.Errorf("shutting down %s: %w", svc.name, err)
+                }
+            }
+        }
+    }
+    s.initialized = 0
+    return first
+}
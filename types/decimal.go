@@ -0,0 +1,350 @@
+package types
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "strconv"
+    "strings"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/bsontype"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "gorm.io/gorm"
+    "gorm.io/gorm/schema"
+)
+
+// Default precision and scale of Decimal columns without precision/scale gorm tags.
+const (
+    DefaultDecimalPrecision = 19
+    DefaultDecimalScale     = 4
+)
+
+// MaxDecimalExponent bounds the exponent, and so the scale, ParseDecimal accepts: Decimal128's
+// range, well past any stored amount, so inputs such as "1e999999999" cannot make it allocate
+// a coefficient with a billion digits.
+const MaxDecimalExponent = 6176
+
+// Decimal is an exact decimal number for prices and other amounts that must not pick up float64
+// rounding errors. It is stored as DECIMAL in SQL, Decimal128 in Mongo and a string in JSON, which
+// Elasticsearch coerces into a scaled_float field (see DecimalMapping). The zero value is 0.
+//
+//     type Product struct {
+//         ...
+//         Price types.Decimal `json:"price" gorm:"precision:12;scale:2" bson:"price"`
+//     }
+//
+//     total := product.Price.Mul(types.NewDecimal(int64(qty), 0)).Round(2)
+//
+// Decimals are immutable; arithmetic returns new values.
+type Decimal struct {
+    coef  *big.Int
+    scale int32 // Number of digits after the decimal point.
+}
+
+// NewDecimal returns unscaled * 10^-scale, e.g. NewDecimal(1999, 2) is 19.99.
+func NewDecimal(unscaled int64, scale int32) Decimal {
+    if scale < 0 {
+        return Decimal{coef: new(big.Int).Mul(big.NewInt(unscaled), pow10(-scale))}
+    }
+    return Decimal{coef: big.NewInt(unscaled), scale: scale}
+}
+
+// ParseDecimal parses a decimal string such as "-12.50" or "1e-3". Numbers whose exponent or
+// scale is beyond MaxDecimalExponent are rejected.
+func ParseDecimal(s string) (Decimal, error) {
+    s = strings.TrimSpace(s)
+    mantissa, exp := s, int64(0)
+    if i := strings.IndexAny(s, "eE"); i >= 0 {
+        var err error
+        if exp, err = strconv.ParseInt(s[i+1:], 10, 32); err != nil {
+            return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+        }
+        mantissa = s[:i]
+    }
+    digits := mantissa
+    scale := int64(0)
+    if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+        digits = mantissa[:i] + mantissa[i+1:]
+        scale = int64(len(mantissa) - i - 1)
+    }
+    scale -= exp
+    if scale > MaxDecimalExponent || scale < -MaxDecimalExponent {
+        return Decimal{}, fmt.Errorf("decimal %q is out of range", s)
+    }
+    coef, ok := new(big.Int).SetString(digits, 10)
+    if !ok {
+        return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+    }
+    if scale < 0 {
+        return Decimal{coef: coef.Mul(coef, pow10(int32(-scale)))}, nil
+    }
+    return Decimal{coef: coef, scale: int32(scale)}, nil
+}
+
+// MustParseDecimal is ParseDecimal for constants; it panics on invalid input.
+func MustParseDecimal(s string) Decimal {
+    d, err := ParseDecimal(s)
+    if err != nil {
+        panic(err)
+    }
+    return d
+}
+
+// DecimalFromFloat converts f using its shortest exact representation, so 0.1 becomes 0.1 rather
+// than 0.1000000000000000055511151231257827.
+func DecimalFromFloat(f float64) Decimal {
+    d, _ := ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+    return d
+}
+
+func (d Decimal) coefficient() *big.Int {
+    if d.coef == nil {
+        return new(big.Int)
+    }
+    return d.coef
+}
+
+// rescale returns d's coefficient at a larger scale.
+func (d Decimal) rescale(scale int32) *big.Int {
+    c := d.coefficient()
+    if scale == d.scale {
+        return c
+    }
+    return new(big.Int).Mul(c, pow10(scale-d.scale))
+}
+
+// align returns the coefficients of d and other at their common scale.
+func (d Decimal) align(other Decimal) (*big.Int, *big.Int, int32) {
+    scale := d.scale
+    if other.scale > scale {
+        scale = other.scale
+    }
+    return d.rescale(scale), other.rescale(scale), scale
+}
+
+// Add returns d + other.
+func (d Decimal) Add(other Decimal) Decimal {
+    a, b, scale := d.align(other)
+    return Decimal{coef: new(big.Int).Add(a, b), scale: scale}
+}
+
+// Sub returns d - other.
+func (d Decimal) Sub(other Decimal) Decimal {
+    a, b, scale := d.align(other)
+    return Decimal{coef: new(big.Int).Sub(a, b), scale: scale}
+}
+
+// Mul returns d * other exactly; use Round to bring the result back to a currency's scale.
+func (d Decimal) Mul(other Decimal) Decimal {
+    return Decimal{coef: new(big.Int).Mul(d.coefficient(), other.coefficient()), scale: d.scale + other.scale}
+}
+
+// Div returns d / other rounded half away from zero to places digits. It panics when other is zero,
+// like integer division.
+func (d Decimal) Div(other Decimal, places int32) Decimal {
+    if other.Sign() == 0 {
+        panic("types: decimal division by zero")
+    }
+    // Compute one extra digit so the quotient can be rounded.
+    num := new(big.Int).Mul(d.coefficient(), pow10(places+1+other.scale))
+    den := new(big.Int).Mul(other.coefficient(), pow10(d.scale))
+    q := new(big.Int).Quo(num, den)
+    return Decimal{coef: q, scale: places + 1}.Round(places)
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+    return Decimal{coef: new(big.Int).Neg(d.coefficient()), scale: d.scale}
+}
+
+// Round rounds d half away from zero to places digits after the decimal point.
+func (d Decimal) Round(places int32) Decimal {
+    if places >= d.scale {
+        return Decimal{coef: d.rescale(places), scale: places}
+    }
+    factor := pow10(d.scale - places)
+    q, r := new(big.Int).QuoRem(d.coefficient(), factor, new(big.Int))
+    if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(factor) >= 0 {
+        if d.Sign() < 0 {
+            q.Sub(q, big.NewInt(1))
+        } else {
+            q.Add(q, big.NewInt(1))
+        }
+    }
+    return Decimal{coef: q, scale: places}
+}
+
+// Cmp returns -1, 0 or +1 as d is less than, equal to or greater than other.
+func (d Decimal) Cmp(other Decimal) int {
+    a, b, _ := d.align(other)
+    return a.Cmp(b)
+}
+
+// Equal reports whether d and other are the same number, regardless of scale.
+func (d Decimal) Equal(other Decimal) bool {
+    return d.Cmp(other) == 0
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d.
+func (d Decimal) Sign() int {
+    return d.coefficient().Sign()
+}
+
+// IsZero reports whether d is 0.
+func (d Decimal) IsZero() bool {
+    return d.Sign() == 0
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int32 {
+    return d.scale
+}
+
+// Float64 returns the nearest float64, for display or statistics only.
+func (d Decimal) Float64() float64 {
+    f, _ := strconv.ParseFloat(d.String(), 64)
+    return f
+}
+
+// String formats d with exactly Scale digits after the decimal point.
+func (d Decimal) String() string {
+    c := d.coefficient()
+    digits := new(big.Int).Abs(c).String()
+    sign := ""
+    if c.Sign() < 0 {
+        sign = "-"
+    }
+    if d.scale == 0 {
+        return sign + digits
+    }
+    if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+        digits = strings.Repeat("0", pad) + digits
+    }
+    point := len(digits) - int(d.scale)
+    return sign + digits[:point] + "." + digits[point:]
+}
+
+// Value stores d as a decimal string, which both MySQL and Postgres convert without loss.
+func (d Decimal) Value() (driver.Value, error) {
+    return d.String(), nil
+}
+
+// Scan reads a DECIMAL column from the database.
+func (d *Decimal) Scan(value interface{}) error {
+    var err error
+    switch v := value.(type) {
+    case nil:
+        *d = Decimal{}
+    case []byte:
+        *d, err = ParseDecimal(string(v))
+    case string:
+        *d, err = ParseDecimal(v)
+    case int64:
+        *d = NewDecimal(v, 0)
+    case float64:
+        *d = DecimalFromFloat(v)
+    default:
+        return fmt.Errorf("failed to scan decimal value: %v", value)
+    }
+    return err
+}
+
+// MarshalJSON encodes d as a string so JSON clients don't round it through a float.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+    return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts both strings and bare numbers.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+    s := string(data)
+    if s == "null" {
+        *d = Decimal{}
+        return nil
+    }
+    if unquoted, err := strconv.Unquote(s); err == nil {
+        s = unquoted
+    }
+    parsed, err := ParseDecimal(s)
+    if err != nil {
+        return err
+    }
+    *d = parsed
+    return nil
+}
+
+// MarshalBSONValue stores d as a Decimal128.
+func (d Decimal) MarshalBSONValue() (bsontype.Type, []byte, error) {
+    d128, err := primitive.ParseDecimal128(d.String())
+    if err != nil {
+        return 0, nil, err
+    }
+    return bson.MarshalValue(d128)
+}
+
+// UnmarshalBSONValue reads a Decimal128, or a double, integer or string written before the field
+// became a Decimal.
+func (d *Decimal) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+    raw := bson.RawValue{Type: t, Value: data}
+    switch t {
+    case bsontype.Decimal128:
+        coef, exp, err := raw.Decimal128().BigInt()
+        if err != nil {
+            return err
+        }
+        if exp > 0 {
+            *d = Decimal{coef: coef.Mul(coef, pow10(int32(exp)))}
+        } else {
+            *d = Decimal{coef: coef, scale: int32(-exp)}
+        }
+    case bsontype.Double:
+        *d = DecimalFromFloat(raw.Double())
+    case bsontype.Int32:
+        *d = NewDecimal(int64(raw.Int32()), 0)
+    case bsontype.Int64:
+        *d = NewDecimal(raw.Int64(), 0)
+    case bsontype.String:
+        parsed, err := ParseDecimal(raw.StringValue())
+        if err != nil {
+            return err
+        }
+        *d = parsed
+    case bsontype.Null:
+        *d = Decimal{}
+    default:
+        return fmt.Errorf("cannot decode BSON %s into a decimal", t)
+    }
+    return nil
+}
+
+// GormDataType reports the generic data type used by gorm's migrator.
+func (Decimal) GormDataType() string {
+    return "decimal"
+}
+
+// GormDBDataType maps the field to DECIMAL(precision, scale), taken from the field's precision and
+// scale tags or DefaultDecimalPrecision and DefaultDecimalScale.
+func (Decimal) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+    precision, scale := field.Precision, field.Scale
+    if precision == 0 {
+        precision = DefaultDecimalPrecision
+    }
+    if scale == 0 {
+        scale = DefaultDecimalScale
+    }
+    return fmt.Sprintf("DECIMAL(%d,%d)", precision, scale)
+}
+
+// DecimalMapping returns the Elasticsearch mapping for a Decimal field with scale digits after the
+// decimal point, e.g. "price": types.DecimalMapping(2) in an index's mapping properties.
+func DecimalMapping(scale int32) map[string]interface{} {
+    return map[string]interface{}{
+        "type":           "scaled_float",
+        "scaling_factor": pow10(scale).Int64(),
+    }
+}
+
+func pow10(n int32) *big.Int {
+    return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,154 @@
+package types
+
+import (
+    "sort"
+    "strings"
+)
+
+// Enum is implemented by enum field types. ORM.Create and ORM.Update reject records whose enum
+// fields hold a value for which Valid returns false.
+//
+//     type PostStatus string
+//
+//     const (
+//         PostStatusDraft     PostStatus = "draft"
+//         PostStatusPublished PostStatus = "published"
+//     )
+//
+//     var PostStatusEnum = types.NewStringEnum("PostStatus", "draft", "published")
+//
+//     func (s PostStatus) Valid() bool { return PostStatusEnum.Contains(string(s)) }
+//
+// The model generator emits all of the above for schema properties with an "enum" list.
+type Enum interface {
+    Valid() bool
+}
+
+// StringEnum is the set of allowed values of a string enum, in declaration order.
+type StringEnum struct {
+    name    string
+    values  []string
+    numbers map[string]int32
+}
+
+// NewStringEnum declares an enum called name, e.g. "PostStatus", with the given values.
+func NewStringEnum(name string, values ...string) *StringEnum {
+    e := &StringEnum{name: name, values: values, numbers: make(map[string]int32, len(values))}
+    for i, v := range values {
+        e.numbers[v] = int32(i + 1)
+    }
+    return e
+}
+
+// Name returns the enum's name.
+func (e *StringEnum) Name() string {
+    return e.name
+}
+
+// Values returns the allowed values in declaration order.
+func (e *StringEnum) Values() []string {
+    return append([]string(nil), e.values...)
+}
+
+// Contains reports whether v is an allowed value. The empty string is allowed so optional fields
+// can be left unset; mark the column not null to require a value.
+func (e *StringEnum) Contains(v string) bool {
+    if v == "" {
+        return true
+    }
+    _, ok := e.numbers[v]
+    return ok
+}
+
+// ToProto returns the number of v in the generated proto enum, where values are numbered from 1
+// in declaration order and 0 is <NAME>_UNSPECIFIED. Unknown values map to 0.
+func (e *StringEnum) ToProto(v string) int32 {
+    return e.numbers[v]
+}
+
+// FromProto returns the value numbered n in the generated proto enum, or "" for 0 and unknown
+// numbers.
+func (e *StringEnum) FromProto(n int32) string {
+    if n < 1 || int(n) > len(e.values) {
+        return ""
+    }
+    return e.values[n-1]
+}
+
+// ProtoName returns the proto enum constant for v, e.g. POST_STATUS_PUBLISHED.
+func (e *StringEnum) ProtoName(v string) string {
+    return protoConstant(e.name, v)
+}
+
+// IntEnum is the set of allowed values of an integer enum.
+type IntEnum struct {
+    name   string
+    values map[int64]string
+}
+
+// NewIntEnum declares an integer enum called name from each allowed value and its label, e.g.
+// NewIntEnum("Priority", map[int64]string{1: "low", 2: "normal", 3: "high"}).
+func NewIntEnum(name string, values map[int64]string) *IntEnum {
+    return &IntEnum{name: name, values: values}
+}
+
+// Name returns the enum's name.
+func (e *IntEnum) Name() string {
+    return e.name
+}
+
+// Values returns the allowed values in ascending order.
+func (e *IntEnum) Values() []int64 {
+    out := make([]int64, 0, len(e.values))
+    for v := range e.values {
+        out = append(out, v)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+    return out
+}
+
+// Contains reports whether v is an allowed value. Zero is allowed so optional fields can be left
+// unset, like the empty string of a StringEnum.
+func (e *IntEnum) Contains(v int64) bool {
+    if v == 0 {
+        return true
+    }
+    _, ok := e.values[v]
+    return ok
+}
+
+// Label returns the label of v, or "" when v isn't allowed.
+func (e *IntEnum) Label(v int64) string {
+    return e.values[v]
+}
+
+// ProtoName returns the proto enum constant for v, e.g. PRIORITY_HIGH. Integer enums keep their
+// own numbers in proto, so v converts to the generated type directly.
+func (e *IntEnum) ProtoName(v int64) string {
+    return protoConstant(e.name, e.values[v])
+}
+
+// protoConstant builds an UPPER_SNAKE proto enum constant prefixed with the enum name.
+func protoConstant(enum, value string) string {
+    return upperSnake(enum) + "_" + upperSnake(value)
+}
+
+func upperSnake(s string) string {
+    var b strings.Builder
+    for i, r := range s {
+        switch {
+        case r >= 'A' && r <= 'Z':
+            if i > 0 && s[i-1] >= 'a' && s[i-1] <= 'z' {
+                b.WriteByte('_')
+            }
+            b.WriteRune(r)
+        case r >= 'a' && r <= 'z':
+            b.WriteRune(r - 'a' + 'A')
+        case r >= '0' && r <= '9':
+            b.WriteRune(r)
+        default:
+            b.WriteByte('_')
+        }
+    }
+    return b.String()
+}
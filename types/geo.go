@@ -0,0 +1,124 @@
+package types
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/bsontype"
+    "gorm.io/gorm"
+    "gorm.io/gorm/schema"
+)
+
+// EarthRadiusMeters is the equatorial Earth radius MongoDB uses for spherical geometry, so
+// distances converted to radians for $centerSphere match its own. Elasticsearch's geo_distance
+// uses the mean radius, 6371008.8 m, so near a query's edge the two can disagree by about 0.1%.
+const EarthRadiusMeters = 6378100.0
+
+// GeoPoint is a WGS84 location. It is stored as a JSON column in SQL, as a GeoJSON point in
+// MongoDB (so a 2dsphere index can cover it) and indexed as a geo_point in Elasticsearch:
+//
+//     type Store struct {
+//         ...
+//         Location types.GeoPoint `json:"location" bson:"location"`
+//     }
+type GeoPoint struct {
+    Lat float64 `json:"lat"`
+    Lon float64 `json:"lon"`
+}
+
+// NewGeoPoint returns the point at lat, lon in degrees.
+func NewGeoPoint(lat, lon float64) GeoPoint {
+    return GeoPoint{Lat: lat, Lon: lon}
+}
+
+// Valid reports whether the point is on the globe: a latitude within ±90 and a longitude within
+// ±180 degrees.
+func (p GeoPoint) Valid() bool {
+    return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180
+}
+
+// Coordinates returns the point in GeoJSON order: longitude first.
+func (p GeoPoint) Coordinates() []float64 {
+    return []float64{p.Lon, p.Lat}
+}
+
+// DistanceTo returns the great-circle distance to other in meters.
+func (p GeoPoint) DistanceTo(other GeoPoint) float64 {
+    lat1, lat2 := p.Lat*math.Pi/180, other.Lat*math.Pi/180
+    dLat, dLon := lat2-lat1, (other.Lon-p.Lon)*math.Pi/180
+    h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+    return 2 * EarthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+// Within reports whether the point lies inside polygon, whose ring need not be closed.
+func (p GeoPoint) Within(polygon []GeoPoint) bool {
+    inside := false
+    for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+        a, b := polygon[i], polygon[j]
+        if (a.Lat > p.Lat) != (b.Lat > p.Lat) && p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+            inside = !inside
+        }
+    }
+    return inside
+}
+
+// Value stores the point as a JSON object.
+func (p GeoPoint) Value() (driver.Value, error) {
+    data, err := json.Marshal(p)
+    return string(data), err
+}
+
+// Scan reads a point stored by Value.
+func (p *GeoPoint) Scan(value interface{}) error {
+    switch v := value.(type) {
+    case nil:
+        *p = GeoPoint{}
+        return nil
+    case []byte:
+        return json.Unmarshal(v, p)
+    case string:
+        return json.Unmarshal([]byte(v), p)
+    }
+    return fmt.Errorf("failed to unmarshal GeoPoint value: %v", value)
+}
+
+// GormDataType reports the generic data type used by gorm's migrator.
+func (GeoPoint) GormDataType() string {
+    return "json"
+}
+
+// GormDBDataType picks JSONB on Postgres and JSON elsewhere.
+func (GeoPoint) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+    return jsonDataType(db)
+}
+
+// MarshalBSONValue writes the point as a GeoJSON Point.
+func (p GeoPoint) MarshalBSONValue() (bsontype.Type, []byte, error) {
+    return bson.MarshalValue(bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: p.Coordinates()}})
+}
+
+// UnmarshalBSONValue reads a GeoJSON Point.
+func (p *GeoPoint) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+    if t == bsontype.Null {
+        *p = GeoPoint{}
+        return nil
+    }
+    if t != bsontype.EmbeddedDocument {
+        return fmt.Errorf("cannot decode %v into a GeoPoint", t)
+    }
+    var doc struct {
+        Coordinates []float64 `bson:"coordinates"`
+    }
+    if err := bson.Unmarshal(data, &doc); err != nil {
+        return err
+    }
+    if len(doc.Coordinates) != 2 {
+        return errors.New("GeoJSON point must have two coordinates")
+    }
+    *p = GeoPoint{Lon: doc.Coordinates[0], Lat: doc.Coordinates[1]}
+    return nil
+}
@@ -0,0 +1,197 @@
+package types
+
+import (
+    "bytes"
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/schema"
+)
+
+// jsonDataType maps JSON fields to JSONB on Postgres and JSON elsewhere.
+func jsonDataType(db *gorm.DB) string {
+    if db.Dialector.Name() == "postgres" {
+        return "JSONB"
+    }
+    return "JSON"
+}
+
+// JSON is a column holding an arbitrary JSON document, stored as JSONB on Postgres and JSON on MySQL.
+// Decode it into a struct with Decode, or use JSONMap for free-form objects.
+type JSON json.RawMessage
+
+// NewJSON encodes value as a JSON column.
+func NewJSON(value interface{}) (JSON, error) {
+    data, err := json.Marshal(value)
+    return JSON(data), err
+}
+
+// Decode unmarshals the document into dest.
+func (j JSON) Decode(dest interface{}) error {
+    if len(j) == 0 {
+        return nil
+    }
+    return json.Unmarshal(j, dest)
+}
+
+// Value stores the document, or NULL when it is empty.
+func (j JSON) Value() (driver.Value, error) {
+    if len(j) == 0 {
+        return nil, nil
+    }
+    return string(j), nil
+}
+
+// Scan reads a JSON column from the database.
+func (j *JSON) Scan(value interface{}) error {
+    switch v := value.(type) {
+    case nil:
+        *j = nil
+    case []byte:
+        *j = append((*j)[:0], v...)
+    case string:
+        *j = JSON(v)
+    default:
+        return fmt.Errorf("failed to unmarshal JSON value: %v", value)
+    }
+    return nil
+}
+
+// MarshalJSON embeds the document as is.
+func (j JSON) MarshalJSON() ([]byte, error) {
+    if len(j) == 0 {
+        return []byte("null"), nil
+    }
+    return j, nil
+}
+
+// UnmarshalJSON keeps a copy of the raw document.
+func (j *JSON) UnmarshalJSON(data []byte) error {
+    if bytes.Equal(data, []byte("null")) {
+        *j = nil
+        return nil
+    }
+    *j = append((*j)[:0], data...)
+    return nil
+}
+
+// GormDataType reports the generic data type used by gorm's migrator.
+func (JSON) GormDataType() string {
+    return "json"
+}
+
+// GormDBDataType picks JSONB on Postgres and JSON on other dialects.
+func (JSON) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+    return jsonDataType(db)
+}
+
+// JSONMap is a JSON object column with typed accessors, e.g. flexible product attributes:
+//
+//     type Product struct {
+//         ...
+//         Attributes types.JSONMap `json:"attributes" bson:"attributes"`
+//     }
+//
+//     color, _ := product.Attributes.GetString("color")
+//     width, _ := product.Attributes.GetFloat("dimensions", "width")
+//
+// In Mongo it is stored as an embedded document.
+type JSONMap map[string]interface{}
+
+// Get returns the value at path, descending into nested objects.
+func (m JSONMap) Get(path ...string) (interface{}, bool) {
+    var current interface{} = map[string]interface{}(m)
+    for _, key := range path {
+        object, ok := current.(map[string]interface{})
+        if !ok {
+            return nil, false
+        }
+        if current, ok = object[key]; !ok {
+            return nil, false
+        }
+    }
+    return current, true
+}
+
+// GetString returns the string at path.
+func (m JSONMap) GetString(path ...string) (string, bool) {
+    v, ok := m.Get(path...)
+    s, isString := v.(string)
+    return s, ok && isString
+}
+
+// GetFloat returns the number at path. JSON numbers decode as float64.
+func (m JSONMap) GetFloat(path ...string) (float64, bool) {
+    v, ok := m.Get(path...)
+    switch n := v.(type) {
+    case float64:
+        return n, ok
+    case int:
+        return float64(n), ok
+    case int64:
+        return float64(n), ok
+    }
+    return 0, false
+}
+
+// GetBool returns the boolean at path.
+func (m JSONMap) GetBool(path ...string) (bool, bool) {
+    v, ok := m.Get(path...)
+    b, isBool := v.(bool)
+    return b, ok && isBool
+}
+
+// Set stores value at path, creating intermediate objects as needed.
+func (m JSONMap) Set(value interface{}, path ...string) {
+    if len(path) == 0 {
+        return
+    }
+    object := map[string]interface{}(m)
+    for _, key := range path[:len(path)-1] {
+        next, ok := object[key].(map[string]interface{})
+        if !ok {
+            next = map[string]interface{}{}
+            object[key] = next
+        }
+        object = next
+    }
+    object[path[len(path)-1]] = value
+}
+
+// Value stores the object as a JSON document.
+func (m JSONMap) Value() (driver.Value, error) {
+    if m == nil {
+        return nil, nil
+    }
+    data, err := json.Marshal(m)
+    return string(data), err
+}
+
+// Scan reads a JSON object column from the database.
+func (m *JSONMap) Scan(value interface{}) error {
+    var data []byte
+    switch v := value.(type) {
+    case nil:
+        *m = nil
+        return nil
+    case []byte:
+        data = v
+    case string:
+        data = []byte(v)
+    default:
+        return fmt.Errorf("failed to unmarshal JSON value: %v", value)
+    }
+    return json.Unmarshal(data, m)
+}
+
+// GormDataType reports the generic data type used by gorm's migrator.
+func (JSONMap) GormDataType() string {
+    return "json"
+}
+
+// GormDBDataType picks JSONB on Postgres and JSON on other dialects.
+func (JSONMap) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+    return jsonDataType(db)
+}
@@ -0,0 +1,89 @@
+package types
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/schema"
+)
+
+// LocalizedText holds the per-locale values of a translatable field in a single JSON column,
+// keyed by locale, e.g. {"en": "Chair", "de": "Stuhl", "pt-BR": "Cadeira"}.
+type LocalizedText map[string]string
+
+// Get returns the value for the first locale of chain that has one, or "" when none does. Build
+// the chain with LocaleChain to get the ORM's fallback rules.
+func (t LocalizedText) Get(chain ...string) (string, bool) {
+    for _, locale := range chain {
+        if v, ok := t[locale]; ok && v != "" {
+            return v, true
+        }
+    }
+    return "", false
+}
+
+// Value stores the translations as a JSON object.
+func (t LocalizedText) Value() (driver.Value, error) {
+    if t == nil {
+        return nil, nil
+    }
+    data, err := json.Marshal(t)
+    return string(data), err
+}
+
+// Scan reads the translations from a JSON column.
+func (t *LocalizedText) Scan(value interface{}) error {
+    var data []byte
+    switch v := value.(type) {
+    case nil:
+        *t = nil
+        return nil
+    case []byte:
+        data = v
+    case string:
+        data = []byte(v)
+    default:
+        return fmt.Errorf("failed to unmarshal JSON value: %v", value)
+    }
+    return json.Unmarshal(data, t)
+}
+
+// GormDataType reports the generic data type used by gorm's migrator.
+func (LocalizedText) GormDataType() string {
+    return "json"
+}
+
+// GormDBDataType picks JSONB on Postgres and JSON on other dialects.
+func (LocalizedText) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+    return jsonDataType(db)
+}
+
+// LocaleChain returns the locales to try for locale, most specific first: the locale itself, its
+// configured fallbacks, its base language ("pt" for "pt-BR") and finally defaultLocale, without
+// duplicates.
+func LocaleChain(locale string, fallbacks map[string][]string, defaultLocale string) []string {
+    var chain []string
+    seen := map[string]bool{}
+    add := func(l string) {
+        if l != "" && !seen[l] {
+            seen[l] = true
+            chain = append(chain, l)
+        }
+    }
+    add(locale)
+    for _, l := range fallbacks[locale] {
+        add(l)
+    }
+    if i := strings.IndexAny(locale, "-_"); i > 0 {
+        base := locale[:i]
+        add(base)
+        for _, l := range fallbacks[base] {
+            add(l)
+        }
+    }
+    add(defaultLocale)
+    return chain
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+    "math"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/schema"
+)
+
+// Vector is an embedding. It is stored as a JSON array in SQL and indexed as a dense_vector in
+// Elasticsearch; tag the field with its dimensions and similarity for kNN search:
+//
+//     Embedding types.Vector `json:"embedding" search:"dense_vector,dims=384,similarity=cosine"`
+type Vector []float32
+
+// Cosine returns the cosine similarity of v and other, or 0 when either is zero or their lengths
+// differ.
+func (v Vector) Cosine(other Vector) float64 {
+    if len(v) != len(other) {
+        return 0
+    }
+    var dot, a, b float64
+    for i := range v {
+        dot += float64(v[i]) * float64(other[i])
+        a += float64(v[i]) * float64(v[i])
+        b += float64(other[i]) * float64(other[i])
+    }
+    if a == 0 || b == 0 {
+        return 0
+    }
+    return dot / (math.Sqrt(a) * math.Sqrt(b))
+}
+
+// Value stores the vector as a JSON array, or NULL when it is empty.
+func (v Vector) Value() (driver.Value, error) {
+    if len(v) == 0 {
+        return nil, nil
+    }
+    data, err := json.Marshal([]float32(v))
+    return string(data), err
+}
+
+// Scan reads a vector stored by Value.
+func (v *Vector) Scan(value interface{}) error {
+    switch data := value.(type) {
+    case nil:
+        *v = nil
+        return nil
+    case []byte:
+        return json.Unmarshal(data, (*[]float32)(v))
+    case string:
+        return json.Unmarshal([]byte(data), (*[]float32)(v))
+    }
+    return fmt.Errorf("failed to unmarshal Vector value: %v", value)
+}
+
+// GormDataType reports the generic data type used by gorm's migrator.
+func (Vector) GormDataType() string {
+    return "json"
+}
+
+// GormDBDataType picks JSONB on Postgres and JSON elsewhere.
+func (Vector) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+    return jsonDataType(db)
+}
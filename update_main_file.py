@@ -3,17 +3,17 @@ import re
 
 # Define directories and the target Go file to update
 MODELS_DIR = "models"
-SERVICES_DIR = "services"
 TARGET_GO_FILE = "cmd/main.go"
 MODEL_PATTERN = re.compile(r'type (\w+) struct')
-SERVICE_PATTERN = re.compile(r'type (\w+ServiceServerImpl) struct')
+# Files in the models directory that hold embeddable types rather than tables
+NON_TABLE_FILES = {"base.go"}
 
 def find_model_structs():
     """Scan the models directory for Go files and extract model struct names."""
     model_structs = []
 
     for file_name in os.listdir(MODELS_DIR):
-        if file_name.endswith(".go"):
+        if file_name.endswith(".go") and file_name not in NON_TABLE_FILES:
             file_path = os.path.join(MODELS_DIR, file_name)
             
             with open(file_path, 'r') as file:
@@ -23,71 +23,30 @@ def find_model_structs():
 
     return model_structs
 
-def find_service_implementations():
-    """Scan the services directory for Go files and extract service implementation names."""
-    service_implementations = []
-
-    for file_name in os.listdir(SERVICES_DIR):
-        if file_name.endswith(".go"):
-            file_path = os.path.join(SERVICES_DIR, file_name)
-            
-            with open(file_path, 'r') as file:
-                content = file.read()
-                matches = SERVICE_PATTERN.findall(content)
-                service_implementations.extend(matches)
-
-    return service_implementations
-
-def update_main_go_file(models, services):
-    """Update the TARGET_GO_FILE with model auto-migrations and service implementations."""
+def update_main_go_file(models):
+    """Update the TARGET_GO_FILE with model auto-migrations. Services register themselves with
+    services.Add from the init functions of the generated service files."""
     with open(TARGET_GO_FILE, 'r') as file:
         content = file.read()
 
-    # Construct the new models array content for AutoMigrate
+    # Construct the new models array content for GetAllModels (used by AutoMigrate and the seed command)
     model_instances = [
         "&models." + model + "{}," for model in models
     ]
     new_models_content = "\n        ".join(model_instances)
 
-    # Define the replacement pattern for the AutoMigrate section
-    new_auto_migrate_content = (
-        "    // Run GORM auto-migration for your models here\n"
-        "    db := sqlAdapter.GetDB()\n"
-        "    err = db.AutoMigrate(\n"
+    new_models_function = (
+        "func GetAllModels() []interface{} {\n"
+        "    return []interface{}{\n"
         "        " + new_models_content + "\n"
-        "    )\n"
-        "    if err != nil {\n"
-        "        log.Fatalf(\"Failed to auto migrate models: %v\", err)\n"
         "    }\n"
-        "    log.Println(\"Auto migration completed successfully.\")"
+        "}"
     )
 
-    # Replace the AutoMigrate block using regex
+    # Replace the GetAllModels function using regex; the body contains braces, so match up to the closing lines
     content = re.sub(
-        r"// Run GORM auto-migration for your models here(.|\s)*?log.Println\(\"Auto migration completed successfully\.\"\)",
-        new_auto_migrate_content,
-        content,
-        flags=re.MULTILINE
-    )
-
-    # Construct the new services array content for GetAllServices
-    service_instances = [
-        "services.New" + service + "(ormLayer)," for service in services
-    ]
-    new_services_content = "\n        ".join(service_instances)
-
-    # Define the replacement pattern for the GetAllServices function
-    new_function_content = (
-        "func GetAllServices(ormLayer *orm.ORM) []RegisterableService {\n"
-        "    return []RegisterableService{\n"
-        "        " + new_services_content + "\n"
-        "    }"
-    )
-
-    # Replace the GetAllServices function content using regex
-    content = re.sub(
-        r"func GetAllServices\(.+?\) \[\]RegisterableService \{(.|\s)*?\}",
-        new_function_content,
+        r"func GetAllModels\(\) \[\]interface\{\} \{(.|\s)*?\n    \}\n\}",
+        lambda _: new_models_function,
         content,
         flags=re.MULTILINE
     )
@@ -100,22 +59,15 @@ def update_main_go_file(models, services):
     for model in models:
         print(f" - {model}")
 
-    print("\nUpdated with the following services for registration:")
-    for service in services:
-        print(f" - {service}")
-
 def main():
     # Step 1: Find all model structs in the models directory
     models = find_model_structs()
 
-    # Step 2: Find all service implementations in the services directory
-    services = find_service_implementations()
-
-    # Step 3: Update the cmd/main.go file with detected models and services
-    if models or services:
-        update_main_go_file(models, services)
+    # Step 2: Update the cmd/main.go file with detected models
+    if models:
+        update_main_go_file(models)
     else:
-        print("No models or service implementations found.")
+        print("No models found.")
 
 if __name__ == "__main__":
     main()
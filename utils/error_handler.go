@@ -1,21 +1,150 @@
 package utils
 
 import (
+    "context"
     "errors"
+    "regexp"
+
+    "github.com/go-sql-driver/mysql"
+    "github.com/jackc/pgx/v5/pgconn"
     "go.mongodb.org/mongo-driver/mongo"
     "gorm.io/gorm"
 )
 
 var (
-    ErrNotFound = errors.New("record not found")
-    ErrDatabase = errors.New("database error")
+    ErrNotFound            = errors.New("record not found")
+    ErrDatabase            = errors.New("database error")
+    ErrBackendDisabled     = errors.New("backend disabled")
+    ErrNotInTransaction    = errors.New("operation requires a transaction")
+    ErrQuotaExceeded       = errors.New("quota exceeded")
+    ErrInvalidValue        = errors.New("invalid value")
+    ErrCircuitOpen         = errors.New("circuit breaker open")
+    ErrReadOnly            = errors.New("database is read-only")
+    ErrStatementTimeout    = errors.New("statement timed out")
+    ErrPermissionDenied    = errors.New("permission denied")
+    ErrVersionConflict     = errors.New("version conflict")
+    ErrAlreadyExists       = errors.New("record already exists")
+    ErrForeignKeyViolation = errors.New("foreign key violation")
+    ErrCacheMiss           = errors.New("cache miss")
 )
 
+// ConstraintError reports a write rejected by a unique or foreign key constraint. It matches
+// ErrAlreadyExists or ErrForeignKeyViolation with errors.Is and keeps the driver error reachable
+// through errors.As.
+type ConstraintError struct {
+    Kind       error  // ErrAlreadyExists or ErrForeignKeyViolation.
+    Constraint string // Name of the violated constraint or unique key; empty when the driver didn't say.
+    cause      error
+}
+
+func (e *ConstraintError) Error() string {
+    if e.Constraint == "" {
+        return e.Kind.Error()
+    }
+    return e.Kind.Error() + " (constraint " + e.Constraint + ")"
+}
+
+func (e *ConstraintError) Unwrap() error        { return e.cause }
+func (e *ConstraintError) Is(target error) bool { return target == e.Kind }
+
+var (
+    mysqlDuplicateKey = regexp.MustCompile("for key '([^']+)'")
+    mysqlConstraint   = regexp.MustCompile("CONSTRAINT `([^`]+)`")
+)
+
+// databaseError reports as ErrDatabase, and as ErrStatementTimeout for statements that ran out of
+// time, while keeping the driver error reachable through errors.As, so callers such as the
+// transaction retry loop can still inspect the cause.
+type databaseError struct {
+    cause error
+}
+
+func (e *databaseError) Error() string { return ErrDatabase.Error() }
+func (e *databaseError) Unwrap() error { return e.cause }
+
+func (e *databaseError) Is(target error) bool {
+    return target == ErrDatabase || target == ErrStatementTimeout && IsStatementTimeout(e.cause)
+}
+
 func HandleSQLError(err error) error {
     if errors.Is(err, gorm.ErrRecordNotFound) {
         return ErrNotFound
     }
-    return ErrDatabase
+    if constraintErr := constraintViolation(err); constraintErr != nil {
+        return constraintErr
+    }
+    return &databaseError{cause: err}
+}
+
+// constraintViolation classifies unique violations (MySQL 1062, Postgres 23505) and foreign key
+// violations (MySQL 1451 and 1452, Postgres 23503) as a *ConstraintError, or returns nil.
+func constraintViolation(err error) *ConstraintError {
+    var myErr *mysql.MySQLError
+    if errors.As(err, &myErr) {
+        switch myErr.Number {
+        case 1062:
+            return &ConstraintError{Kind: ErrAlreadyExists, Constraint: submatch(mysqlDuplicateKey, myErr.Message), cause: err}
+        case 1451, 1452:
+            return &ConstraintError{Kind: ErrForeignKeyViolation, Constraint: submatch(mysqlConstraint, myErr.Message), cause: err}
+        }
+        return nil
+    }
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) {
+        switch pgErr.Code {
+        case "23505":
+            return &ConstraintError{Kind: ErrAlreadyExists, Constraint: pgErr.ConstraintName, cause: err}
+        case "23503":
+            return &ConstraintError{Kind: ErrForeignKeyViolation, Constraint: pgErr.ConstraintName, cause: err}
+        }
+        return nil
+    }
+    // Dialectors translating errors report neither the code nor the constraint.
+    switch {
+    case errors.Is(err, gorm.ErrDuplicatedKey):
+        return &ConstraintError{Kind: ErrAlreadyExists, cause: err}
+    case errors.Is(err, gorm.ErrForeignKeyViolated):
+        return &ConstraintError{Kind: ErrForeignKeyViolation, cause: err}
+    }
+    return nil
+}
+
+func submatch(re *regexp.Regexp, s string) string {
+    if m := re.FindStringSubmatch(s); m != nil {
+        return m[1]
+    }
+    return ""
+}
+
+// IsTransactionConflict reports whether err is a deadlock (MySQL 1213, Postgres 40P01) or a
+// serialization failure (Postgres 40001), after which re-running the whole transaction may succeed.
+func IsTransactionConflict(err error) bool {
+    var myErr *mysql.MySQLError
+    if errors.As(err, &myErr) {
+        return myErr.Number == 1213
+    }
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) {
+        return pgErr.Code == "40001" || pgErr.Code == "40P01"
+    }
+    return false
+}
+
+// IsStatementTimeout reports whether err ended a statement at its deadline: the context deadline
+// itself, MySQL's MAX_EXECUTION_TIME (3024) or a Postgres statement_timeout cancellation (57014).
+func IsStatementTimeout(err error) bool {
+    if errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var myErr *mysql.MySQLError
+    if errors.As(err, &myErr) {
+        return myErr.Number == 3024
+    }
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) {
+        return pgErr.Code == "57014"
+    }
+    return false
 }
 
 func HandleMongoError(err error) error {
@@ -0,0 +1,273 @@
+package utils
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "os"
+    "regexp"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "go.mongodb.org/mongo-driver/mongo"
+    "gorm.io/gorm"
+)
+
+// ErrorReporter receives the errors logged with LogError, e.g. to forward them to an alerting
+// pipeline. Report must not block.
+type ErrorReporter interface {
+    Report(err error, fields map[string]interface{})
+}
+
+var errorReporter atomic.Value // Holds reporterHolder.
+
+type reporterHolder struct{ reporter ErrorReporter }
+
+// SetErrorReporter makes LogError pass errors to reporter; nil stops reporting.
+func SetErrorReporter(reporter ErrorReporter) {
+    errorReporter.Store(reporterHolder{reporter})
+}
+
+// reportError passes err to the configured ErrorReporter, if any.
+func reportError(err error, fields map[string]interface{}) {
+    if holder, ok := errorReporter.Load().(reporterHolder); ok && holder.reporter != nil {
+        holder.reporter.Report(err, fields)
+    }
+}
+
+// DefaultScrubFields are the field names, matched case-insensitively as substrings, whose values
+// SentryReporter never sends.
+var DefaultScrubFields = []string{"password", "secret", "token", "authorization", "cookie", "email", "phone", "ssn", "card"}
+
+// DefaultExpectedErrors are the errors SentryReporter doesn't send: outcomes callers ask about
+// and handle, such as a missing record or a rejected value, rather than faults.
+var DefaultExpectedErrors = []error{
+    ErrNotFound, ErrAlreadyExists, ErrForeignKeyViolation, ErrInvalidValue, ErrPermissionDenied,
+    ErrVersionConflict, ErrQuotaExceeded, ErrCacheMiss, gorm.ErrRecordNotFound, mongo.ErrNoDocuments,
+    context.Canceled,
+}
+
+// SentryOptions configures a SentryReporter.
+type SentryOptions struct {
+    // DSN is the project's client key URL, e.g. "https://<key>@o1.ingest.sentry.io/42". Any
+    // service accepting Sentry's store API works.
+    DSN         string
+    Environment string // e.g. "production".
+    Release     string // e.g. the deployed version or commit.
+    // SampleRate is the share of errors sent, from 0 to 1; 0 sends none and nil all of them.
+    SampleRate *float64
+    // ScrubFields are added to DefaultScrubFields.
+    ScrubFields []string
+    // ExpectedErrors are added to DefaultExpectedErrors.
+    ExpectedErrors []error
+    // QueueSize bounds the events waiting to be sent; further events are dropped. Defaults to 100.
+    QueueSize int
+    Client    *http.Client
+}
+
+// SentryReporter sends errors to Sentry as events, tagged with the environment and release. Events
+// are sent in the background. Only scalar fields are sent: structured ones, such as models and
+// queries, are replaced by their type, fields whose names look like personal data or credentials
+// by "[scrubbed]", and quoted values and email addresses in the error text and string fields by
+// "?". Expected errors are not sent at all.
+type SentryReporter struct {
+    opts     SentryOptions
+    endpoint string
+    auth     string
+    scrub    []string
+    expected []error
+    events   chan map[string]interface{}
+    hostname string
+
+    mu      sync.Mutex
+    pending int           // Events queued or being sent.
+    idle    chan struct{} // Closed while pending is 0.
+}
+
+// NewSentryReporter parses opts.DSN and starts the sender.
+func NewSentryReporter(opts SentryOptions) (*SentryReporter, error) {
+    dsn, err := url.Parse(opts.DSN)
+    if err != nil {
+        return nil, fmt.Errorf("invalid sentry dsn: %w", err)
+    }
+    project := strings.Trim(dsn.Path, "/")
+    if dsn.User == nil || dsn.User.Username() == "" || project == "" || dsn.Host == "" {
+        return nil, fmt.Errorf("invalid sentry dsn: expected scheme://key@host/project")
+    }
+    if opts.QueueSize <= 0 {
+        opts.QueueSize = 100
+    }
+    if opts.Client == nil {
+        opts.Client = &http.Client{Timeout: 10 * time.Second}
+    }
+    prefix := ""
+    if i := strings.LastIndex(project, "/"); i >= 0 {
+        prefix, project = "/"+project[:i], project[i+1:]
+    }
+    r := &SentryReporter{
+        opts:     opts,
+        endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", dsn.Scheme, dsn.Host, prefix, project),
+        auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=persistence-layer/1.0, sentry_key=%s", dsn.User.Username()),
+        scrub:    append(append([]string{}, DefaultScrubFields...), opts.ScrubFields...),
+        expected: append(append([]error{}, DefaultExpectedErrors...), opts.ExpectedErrors...),
+        events:   make(chan map[string]interface{}, opts.QueueSize),
+        idle:     make(chan struct{}),
+    }
+    close(r.idle)
+    r.hostname, _ = os.Hostname()
+    go r.send()
+    return r, nil
+}
+
+// Report queues err as an event. The "operation" field becomes a tag, a "fingerprint" field
+// groups the event, and a "stack" field is sent as is; the other fields are sent as extra data.
+func (r *SentryReporter) Report(err error, fields map[string]interface{}) {
+    for _, expected := range r.expected {
+        if errors.Is(err, expected) {
+            return
+        }
+    }
+    if !sampled(r.opts.SampleRate) {
+        return
+    }
+    tags := map[string]string{}
+    extra := make(map[string]interface{}, len(fields))
+    var fingerprint []string
+    for k, v := range fields {
+        switch {
+        case r.scrubbed(k):
+            extra[k] = "[scrubbed]"
+        case k == "operation":
+            tags[k] = fmt.Sprint(v)
+        case k == "fingerprint":
+            fingerprint = []string{fmt.Sprint(v)}
+        default:
+            extra[k] = scrubValue(v)
+        }
+    }
+    id := make([]byte, 16)
+    _, _ = rand.Read(id)
+    event := map[string]interface{}{
+        "event_id":    hex.EncodeToString(id),
+        "timestamp":   time.Now().UTC().Format(time.RFC3339Nano),
+        "level":       "error",
+        "platform":    "go",
+        "server_name": r.hostname,
+        "environment": r.opts.Environment,
+        "release":     r.opts.Release,
+        "tags":        tags,
+        "extra":       extra,
+        "exception": map[string]interface{}{
+            "values": []map[string]interface{}{{"type": fmt.Sprintf("%T", err), "value": scrubText(err.Error())}},
+        },
+    }
+    if fingerprint != nil {
+        event["fingerprint"] = fingerprint
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    select {
+    case r.events <- event:
+        if r.pending++; r.pending == 1 {
+            r.idle = make(chan struct{})
+        }
+    default: // The queue is full; dropping beats blocking the caller.
+    }
+}
+
+var (
+    quotedText = regexp.MustCompile(`'[^']*'|"[^"]*"|` + "`[^`]*`")
+    emailText  = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+)
+
+// scrubText replaces the quoted values and email addresses of s, e.g. the duplicate key of a
+// constraint violation, by "?".
+func scrubText(s string) string {
+    return emailText.ReplaceAllString(quotedText.ReplaceAllString(s, "?"), "?")
+}
+
+// scrubValue returns v when it is a scalar safe to send, and a placeholder naming its type
+// otherwise, so records and queries never leave the process.
+func scrubValue(v interface{}) interface{} {
+    switch v := v.(type) {
+    case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Duration, time.Time:
+        return v
+    case string:
+        return scrubText(v)
+    case error:
+        return scrubText(v.Error())
+    }
+    return fmt.Sprintf("[omitted %T]", v)
+}
+
+// scrubbed reports whether the field named name may carry personal data or credentials.
+func (r *SentryReporter) scrubbed(name string) bool {
+    name = strings.ToLower(name)
+    for _, s := range r.scrub {
+        if strings.Contains(name, strings.ToLower(s)) {
+            return true
+        }
+    }
+    return false
+}
+
+// send posts the queued events. Failures are printed rather than logged, so they don't loop back
+// into the reporter.
+func (r *SentryReporter) send() {
+    for event := range r.events {
+        if err := r.post(event); err != nil {
+            fmt.Fprintf(os.Stderr, "error reporting failed: %v\n", err)
+        }
+        r.mu.Lock()
+        if r.pending--; r.pending == 0 {
+            close(r.idle)
+        }
+        r.mu.Unlock()
+    }
+}
+
+func (r *SentryReporter) post(event map[string]interface{}) error {
+    data, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequest(http.MethodPost, r.endpoint, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Sentry-Auth", r.auth)
+    resp, err := r.opts.Client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    _, _ = io.Copy(ioutil.Discard, resp.Body)
+    if resp.StatusCode/100 != 2 {
+        return fmt.Errorf("sentry returned %s", resp.Status)
+    }
+    return nil
+}
+
+// Flush waits until the queued events are sent or ctx ends, e.g. before the process exits.
+func (r *SentryReporter) Flush(ctx context.Context) error {
+    r.mu.Lock()
+    idle := r.idle
+    r.mu.Unlock()
+    select {
+    case <-idle:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+    "math/rand"
+    "sync"
+    "time"
+)
+
+// LogRule thins out the LogInfo entries of one message.
+type LogRule struct {
+    // SampleRate is the share of entries written, from 0 to 1; 0 writes none and nil all of them.
+    SampleRate *float64
+    // MaxPerSecond caps the entries written each second; 0 is unlimited. The next entry written
+    // after some were dropped carries their number in a "suppressed" field.
+    MaxPerSecond int
+}
+
+// LogSampling controls which LogInfo entries are written, so operations logged on every call don't
+// flood the output at high request rates. LogWarn and LogError are never sampled.
+type LogSampling struct {
+    // DisableInfo drops every LogInfo entry, such as those of successful ORM calls.
+    DisableInfo bool
+    // Default applies to the messages without a rule of their own in Messages.
+    Default LogRule
+    // Messages holds rules by message, e.g. "Record retrieved successfully".
+    Messages map[string]LogRule
+}
+
+var logSampling = struct {
+    sync.RWMutex
+    settings LogSampling
+    windows  map[string]*logWindow
+}{windows: make(map[string]*logWindow)}
+
+// logWindow counts the entries of a message in the current second.
+type logWindow struct {
+    mu         sync.Mutex
+    second     int64
+    written    int
+    suppressed int
+}
+
+// Rate returns a pointer to v, for the SampleRate of a LogRule or SentryOptions.
+func Rate(v float64) *float64 {
+    return &v
+}
+
+// sampled reports whether an entry is kept at rate: always when it is nil or at least 1, never
+// when it is 0 or less.
+func sampled(rate *float64) bool {
+    return rate == nil || *rate >= 1 || rand.Float64() < *rate
+}
+
+// SetLogSampling replaces the sampling settings of LogInfo.
+func SetLogSampling(settings LogSampling) {
+    logSampling.Lock()
+    defer logSampling.Unlock()
+    logSampling.settings = settings
+}
+
+// sampleInfo reports whether the LogInfo entry of message is written, and how many entries of it
+// the rate limit dropped since the last one written.
+func sampleInfo(message string) (bool, int) {
+    logSampling.RLock()
+    settings := logSampling.settings
+    window := logSampling.windows[message]
+    logSampling.RUnlock()
+
+    if settings.DisableInfo {
+        return false, 0
+    }
+    rule, ok := settings.Messages[message]
+    if !ok {
+        rule = settings.Default
+    }
+    if !sampled(rule.SampleRate) {
+        return false, 0
+    }
+    if rule.MaxPerSecond <= 0 {
+        return true, 0
+    }
+
+    if window == nil {
+        logSampling.Lock()
+        if window = logSampling.windows[message]; window == nil {
+            window = &logWindow{}
+            logSampling.windows[message] = window
+        }
+        logSampling.Unlock()
+    }
+    window.mu.Lock()
+    defer window.mu.Unlock()
+    if now := time.Now().Unix(); now != window.second {
+        window.second, window.written = now, 0
+    }
+    if window.written >= rule.MaxPerSecond {
+        window.suppressed++
+        return false, 0
+    }
+    window.written++
+    suppressed := window.suppressed
+    window.suppressed = 0
+    return true, suppressed
+}
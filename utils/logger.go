@@ -10,18 +10,49 @@ func InitLogger() {
     log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 }
 
+// SetLogLevel sets the global log level, e.g. "debug" or "info". An empty level leaves it unchanged.
+func SetLogLevel(level string) error {
+    if level == "" {
+        return nil
+    }
+    parsed, err := zerolog.ParseLevel(level)
+    if err != nil {
+        return err
+    }
+    zerolog.SetGlobalLevel(parsed)
+    return nil
+}
+
+// LogInfo logs message with fields at info level, as the settings of SetLogSampling allow.
 func LogInfo(message string, fields map[string]interface{}) {
+    write, suppressed := sampleInfo(message)
+    if !write {
+        return
+    }
     event := log.Info()
     for k, v := range fields {
         event = event.Interface(k, v)
     }
+    if suppressed > 0 {
+        event = event.Int("suppressed", suppressed)
+    }
     event.Msg(message)
 }
 
+// LogError logs err with fields and passes both to the ErrorReporter set with SetErrorReporter.
 func LogError(err error, fields map[string]interface{}) {
     event := log.Error().Err(err)
     for k, v := range fields {
         event = event.Interface(k, v)
     }
     event.Msg("Error occurred")
+    reportError(err, fields)
+}
+
+func LogWarn(message string, fields map[string]interface{}) {
+    event := log.Warn()
+    for k, v := range fields {
+        event = event.Interface(k, v)
+    }
+    event.Msg(message)
 }
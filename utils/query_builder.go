@@ -2,8 +2,10 @@ package utils
 
 import (
     "fmt"
+    "math"
+    "persistence-layer/types"
+    "regexp"
     "strings"
-    "reflect"
 )
 
 // QueryBuilder is a struct that helps to build dynamic queries.
@@ -13,6 +15,7 @@ type QueryBuilder struct {
     SortFields   []string
     Limit        int
     Offset       int
+    Preloads     []string
 }
 
 // NewQueryBuilder initializes a new QueryBuilder instance.
@@ -34,6 +37,12 @@ func (qb *QueryBuilder) Where(field string, value interface{}) *QueryBuilder {
     return qb
 }
 
+// WhereKey restricts the query to the record with the given primary key, which may be an integer
+// ID or a UUID string.
+func (qb *QueryBuilder) WhereKey(key interface{}) *QueryBuilder {
+    return qb.Where("id", key)
+}
+
 // WhereIn adds an IN condition to the query for multiple values.
 func (qb *QueryBuilder) WhereIn(field string, values []interface{}) *QueryBuilder {
     qb.Conditions[field] = map[string]interface{}{"$in": values}
@@ -52,12 +61,101 @@ func (qb *QueryBuilder) WhereBetween(field string, from, to interface{}) *QueryB
     return qb
 }
 
-// Sort specifies the fields to sort by. Prefix with "-" for descending order.
+// WhereJSON adds an equality condition on a path inside a JSON column, written in the Postgres
+// operator syntax, e.g. WhereJSON("attributes->>'color'", "red") or
+// WhereJSON("attributes->'size'->>'unit'", "cm"). For MongoDB the path becomes "attributes.color".
+// The MySQL form "attributes->>'$.color'" is accepted as well. ->> yields text, so SQL compares
+// against the value's string form. Paths are interpolated into SQL, so ones that aren't plain JSON
+// paths fail Validate with ErrInvalidValue.
+func (qb *QueryBuilder) WhereJSON(path string, value interface{}) *QueryBuilder {
+    qb.Conditions[path] = map[string]interface{}{"$json": value}
+    return qb
+}
+
+// WhereGeoDistance restricts a types.GeoPoint field to points within meters of center. MongoDB
+// answers it with $geoWithin/$centerSphere and Elasticsearch with geo_distance. SQL has no portable
+// geo predicate, so ValidateSQL rejects geo conditions. A center off the globe or a distance that
+// isn't positive fails Validate with ErrInvalidValue.
+func (qb *QueryBuilder) WhereGeoDistance(field string, center types.GeoPoint, meters float64) *QueryBuilder {
+    qb.Conditions[field] = map[string]interface{}{"$geoDistance": geoDistance{center: center, meters: meters}}
+    return qb
+}
+
+// WhereGeoWithin restricts a types.GeoPoint field to points inside the polygon with the given
+// vertices, e.g. a delivery zone. The ring is closed automatically. Fewer than three distinct
+// vertices, or vertices off the globe, fail Validate with ErrInvalidValue.
+func (qb *QueryBuilder) WhereGeoWithin(field string, polygon ...types.GeoPoint) *QueryBuilder {
+    qb.Conditions[field] = map[string]interface{}{"$geoWithin": polygon}
+    return qb
+}
+
+type geoDistance struct {
+    center types.GeoPoint
+    meters float64
+}
+
+// validGeo reports why a geo condition's operand can't be queried, or "" when it can.
+func validGeo(operator string, operand interface{}) string {
+    switch operator {
+    case "$geoDistance":
+        d, ok := operand.(geoDistance)
+        if !ok {
+            return "malformed distance condition"
+        }
+        if !d.center.Valid() {
+            return fmt.Sprintf("center %v is off the globe", d.center)
+        }
+        if !(d.meters > 0) || math.IsInf(d.meters, 1) {
+            return fmt.Sprintf("distance %g is not a positive number of meters", d.meters)
+        }
+    case "$geoWithin":
+        polygon, ok := operand.([]types.GeoPoint)
+        if !ok {
+            return "malformed polygon condition"
+        }
+        distinct := make(map[types.GeoPoint]bool, len(polygon))
+        for _, p := range polygon {
+            if !p.Valid() {
+                return fmt.Sprintf("vertex %v is off the globe", p)
+            }
+            distinct[p] = true
+        }
+        if len(distinct) < 3 {
+            return "a polygon needs at least three distinct vertices"
+        }
+    }
+    return ""
+}
+
+// geoRing returns the GeoJSON coordinates of polygon, closing the ring.
+func geoRing(polygon []types.GeoPoint) [][]float64 {
+    ring := make([][]float64, 0, len(polygon)+1)
+    for _, p := range polygon {
+        ring = append(ring, p.Coordinates())
+    }
+    if len(polygon) > 0 && polygon[0] != polygon[len(polygon)-1] {
+        ring = append(ring, polygon[0].Coordinates())
+    }
+    return ring
+}
+
+// Sort specifies the fields to sort by. Prefix with "-" for descending order. Fields are
+// interpolated into queries, so ones that aren't plain identifiers, e.g. "name; DROP TABLE", are
+// dropped; ORM.SearchSQL also rejects fields that aren't columns of the model and appends the
+// primary key, so that pages are stable.
 func (qb *QueryBuilder) Sort(fields ...string) *QueryBuilder {
     qb.SortFields = fields
     return qb
 }
 
+// Preload names associations, e.g. "Comments" or "Comments.Author", to load into the results of
+// ORM.SearchSQL. Each association costs one extra query for the whole result set rather than one
+// per row.
+func (qb *QueryBuilder) Preload(associations ...string) *QueryBuilder {
+    qb.Preloads = append(qb.Preloads, associations...)
+    return qb
+}
+
 // SetLimit sets the number of records to return.
 func (qb *QueryBuilder) SetLimit(limit int) *QueryBuilder {
     qb.Limit = limit
@@ -70,6 +168,47 @@ func (qb *QueryBuilder) SetOffset(offset int) *QueryBuilder {
     return qb
 }
 
+// Validate reports conditions the builder cannot translate, wrapping ErrInvalidValue. ORM.SearchSQL
+// calls ValidateSQL; call Validate before ToMongoFilter or ToESQuery, which skip such conditions.
+func (qb *QueryBuilder) Validate() error {
+    for field, value := range qb.Conditions {
+        v, ok := value.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        if _, ok := v["$json"]; ok && !jsonPathPattern.MatchString(field) {
+            return fmt.Errorf("%w: %q is not a JSON column path", ErrInvalidValue, field)
+        }
+        for _, operator := range []string{"$geoDistance", "$geoWithin"} {
+            if operand, ok := v[operator]; ok {
+                if reason := validGeo(operator, operand); reason != "" {
+                    return fmt.Errorf("%w: %s on %q: %s", ErrInvalidValue, operator, field, reason)
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// ValidateSQL is Validate for ToSQL, which also rejects the geo conditions SQL cannot express.
+func (qb *QueryBuilder) ValidateSQL() error {
+    if err := qb.Validate(); err != nil {
+        return err
+    }
+    for field, value := range qb.Conditions {
+        v, ok := value.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        for _, operator := range []string{"$geoDistance", "$geoWithin"} {
+            if _, ok := v[operator]; ok {
+                return fmt.Errorf("%w: %s on %q is not supported in SQL", ErrInvalidValue, operator, field)
+            }
+        }
+    }
+    return nil
+}
+
 // ToSQL converts the QueryBuilder into a SQL WHERE clause and parameters.
 func (qb *QueryBuilder) ToSQL() (string, []interface{}) {
     var conditions []string
@@ -95,6 +234,13 @@ func (qb *QueryBuilder) ToSQL() (string, []interface{}) {
                 conditions = append(conditions, fmt.Sprintf("%s LIKE $%d", field, counter))
                 params = append(params, likeVal)
                 counter++
+            } else if jsonVal, ok := v["$json"]; ok {
+                if !jsonPathPattern.MatchString(field) {
+                    continue
+                }
+                conditions = append(conditions, fmt.Sprintf("%s = $%d", field, counter))
+                params = append(params, fmt.Sprint(jsonVal))
+                counter++
             }
         default:
             conditions = append(conditions, fmt.Sprintf("%s = $%d", field, counter))
@@ -109,8 +255,8 @@ func (qb *QueryBuilder) ToSQL() (string, []interface{}) {
     }
 
     orderBy := ""
-    if len(qb.SortFields) > 0 {
-        orderBy = "ORDER BY " + qb.buildSortClause()
+    if sorts := qb.buildSortClause(); sorts != "" {
+        orderBy = "ORDER BY " + sorts
     }
 
     limitClause := ""
@@ -130,6 +276,9 @@ func (qb *QueryBuilder) ToSQL() (string, []interface{}) {
 func (qb *QueryBuilder) buildSortClause() string {
     var sorts []string
     for _, field := range qb.SortFields {
+        if !sortFieldPattern.MatchString(field) {
+            continue
+        }
         if strings.HasPrefix(field, "-") {
             sorts = append(sorts, fmt.Sprintf("%s DESC", strings.TrimPrefix(field, "-")))
         } else {
@@ -151,6 +300,18 @@ func (qb *QueryBuilder) ToMongoFilter() map[string]interface{} {
                 filter[field] = map[string]interface{}{"$gte": betweenVals.([]interface{})[0], "$lte": betweenVals.([]interface{})[1]}
             } else if likeVal, ok := v["$like"]; ok {
                 filter[field] = map[string]interface{}{"$regex": likeVal, "$options": "i"}
+            } else if jsonVal, ok := v["$json"]; ok {
+                if path, ok := jsonPathToDotted(field); ok {
+                    filter[path] = jsonVal
+                }
+            } else if d, ok := v["$geoDistance"].(geoDistance); ok {
+                filter[field] = map[string]interface{}{"$geoWithin": map[string]interface{}{
+                    "$centerSphere": []interface{}{d.center.Coordinates(), d.meters / types.EarthRadiusMeters},
+                }}
+            } else if polygon, ok := v["$geoWithin"].([]types.GeoPoint); ok {
+                filter[field] = map[string]interface{}{"$geoWithin": map[string]interface{}{
+                    "$geometry": map[string]interface{}{"type": "Polygon", "coordinates": [][][]float64{geoRing(polygon)}},
+                }}
             }
         default:
             filter[field] = value
@@ -159,10 +320,80 @@ func (qb *QueryBuilder) ToMongoFilter() map[string]interface{} {
     return filter
 }
 
+// ToESQuery converts the conditions into an Elasticsearch bool filter, for the "query" of
+// ORM.Search. Equality becomes term, WhereIn terms, WhereBetween range, WhereLike wildcard and the
+// geo conditions geo_distance and geo_shape. Match exact values against keyword fields.
+func (qb *QueryBuilder) ToESQuery() map[string]interface{} {
+    filters := []interface{}{}
+    for field, value := range qb.Conditions {
+        switch v := value.(type) {
+        case map[string]interface{}:
+            if inVals, ok := v["$in"]; ok {
+                filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{field: inVals}})
+            } else if betweenVals, ok := v["$between"]; ok {
+                bounds := betweenVals.([]interface{})
+                filters = append(filters, map[string]interface{}{"range": map[string]interface{}{field: map[string]interface{}{"gte": bounds[0], "lte": bounds[1]}}})
+            } else if jsonVal, ok := v["$json"]; ok {
+                if path, ok := jsonPathToDotted(field); ok {
+                    filters = append(filters, map[string]interface{}{"term": map[string]interface{}{path: jsonVal}})
+                }
+            } else if d, ok := v["$geoDistance"].(geoDistance); ok {
+                filters = append(filters, map[string]interface{}{"geo_distance": map[string]interface{}{
+                    "distance": fmt.Sprintf("%gm", d.meters),
+                    field:      map[string]interface{}{"lat": d.center.Lat, "lon": d.center.Lon},
+                }})
+            } else if polygon, ok := v["$geoWithin"].([]types.GeoPoint); ok {
+                filters = append(filters, map[string]interface{}{"geo_shape": map[string]interface{}{
+                    field: map[string]interface{}{"shape": map[string]interface{}{"type": "polygon", "coordinates": [][][]float64{geoRing(polygon)}}},
+                }})
+            }
+        case map[string]string:
+            if likeVal, ok := v["$like"]; ok {
+                pattern := strings.NewReplacer("%", "*", "_", "?").Replace(likeVal)
+                filters = append(filters, map[string]interface{}{"wildcard": map[string]interface{}{field: pattern}})
+            }
+        default:
+            filters = append(filters, map[string]interface{}{"term": map[string]interface{}{field: value}})
+        }
+    }
+    return map[string]interface{}{"bool": map[string]interface{}{"filter": filters}}
+}
+
+// sortFieldPattern matches a sort field, a column name optionally qualified by its table and
+// prefixed with "-".
+var sortFieldPattern = regexp.MustCompile(`^-?[A-Za-z_]\w*(\.[A-Za-z_]\w*)?$`)
+
+// jsonPathPattern matches a JSON column path such as attributes->>'color' or attributes->>'$.color'.
+// Paths are interpolated into SQL, so anything else fails Validate.
+var jsonPathPattern = regexp.MustCompile(`^\w+(\s*->>?\s*('[\w$.\[\]]+'|\d+))+$`)
+
+var jsonPathSegment = regexp.MustCompile(`->>?\s*('([^']*)'|(\d+))`)
+
+// jsonPathToDotted converts a JSON column path into MongoDB dot notation, e.g.
+// attributes->'size'->>'unit' and attributes->>'$.size.unit' both become attributes.size.unit.
+func jsonPathToDotted(path string) (string, bool) {
+    if !jsonPathPattern.MatchString(path) {
+        return "", false
+    }
+    parts := []string{strings.TrimSpace(path[:strings.Index(path, "-")])}
+    for _, m := range jsonPathSegment.FindAllStringSubmatch(path, -1) {
+        segment := m[2] + m[3]
+        segment = strings.TrimPrefix(strings.TrimPrefix(segment, "$"), ".")
+        segment = strings.NewReplacer("[", ".", "]", "").Replace(segment)
+        if segment != "" {
+            parts = append(parts, segment)
+        }
+    }
+    return strings.Join(parts, "."), true
+}
+
 // GetMongoSort converts the QueryBuilder into a MongoDB sort specification.
 func (qb *QueryBuilder) GetMongoSort() map[string]int {
     sortSpec := make(map[string]int)
     for _, field := range qb.SortFields {
+        if !sortFieldPattern.MatchString(field) {
+            continue
+        }
         if strings.HasPrefix(field, "-") {
             sortSpec[strings.TrimPrefix(field, "-")] = -1
         } else {
@@ -0,0 +1,110 @@
+package utils
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "sync"
+
+    "github.com/rs/zerolog"
+)
+
+// ParamLogMode controls how SQL parameter values are written to logs.
+type ParamLogMode string
+
+const (
+    // ParamLogRedact replaces every value with its type, e.g. "[redacted string]". This is the default.
+    ParamLogRedact ParamLogMode = "redact"
+    // ParamLogHash replaces every value with a keyed hash so equal values can be correlated across
+    // log lines without revealing them.
+    ParamLogHash ParamLogMode = "hash"
+    // ParamLogDebug logs raw values, but only while the global log level is debug or lower;
+    // at higher levels values are redacted as with ParamLogRedact.
+    ParamLogDebug ParamLogMode = "debug"
+)
+
+var paramLogging = struct {
+    sync.RWMutex
+    mode    ParamLogMode
+    hashKey []byte
+}{mode: ParamLogRedact}
+
+// SetParamLogMode selects how RedactParams renders values. hashKey keys the HMAC used by
+// ParamLogHash and is required with it; without it short values such as emails could be recovered
+// by brute force.
+func SetParamLogMode(mode ParamLogMode, hashKey string) error {
+    switch mode {
+    case "":
+        mode = ParamLogRedact
+    case ParamLogHash:
+        if hashKey == "" {
+            return fmt.Errorf("SQL parameter log mode %q requires a hash key", mode)
+        }
+    case ParamLogRedact, ParamLogDebug:
+    default:
+        return fmt.Errorf("unknown SQL parameter log mode %q", mode)
+    }
+    paramLogging.Lock()
+    defer paramLogging.Unlock()
+    paramLogging.mode = mode
+    paramLogging.hashKey = []byte(hashKey)
+    return nil
+}
+
+// RedactParams returns a copy of params that is safe to log under the configured ParamLogMode.
+func RedactParams(params []interface{}) []interface{} {
+    paramLogging.RLock()
+    mode, key := paramLogging.mode, paramLogging.hashKey
+    paramLogging.RUnlock()
+
+    if logsRawParams(mode) {
+        return params
+    }
+    out := make([]interface{}, len(params))
+    for i, param := range params {
+        if param == nil {
+            continue
+        }
+        if mode == ParamLogHash {
+            mac := hmac.New(sha256.New, key)
+            fmt.Fprintf(mac, "%v", param)
+            out[i] = "sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+            continue
+        }
+        out[i] = fmt.Sprintf("[redacted %T]", param)
+    }
+    return out
+}
+
+// RedactError returns err with the quoted values and email addresses of its message, e.g. the
+// duplicate key of a constraint violation, replaced by "?", unless the ParamLogMode logs raw
+// values. The result still wraps err for errors.Is and errors.As.
+func RedactError(err error) error {
+    paramLogging.RLock()
+    mode := paramLogging.mode
+    paramLogging.RUnlock()
+    if err == nil || logsRawParams(mode) {
+        return err
+    }
+    return &redactedError{message: scrubText(err.Error()), err: err}
+}
+
+// logsRawParams reports whether values are logged as they are: under ParamLogDebug at debug level.
+func logsRawParams(mode ParamLogMode) bool {
+    return mode == ParamLogDebug && zerolog.GlobalLevel() <= zerolog.DebugLevel
+}
+
+// redactedError is an error whose message is safe to log.
+type redactedError struct {
+    message string
+    err     error
+}
+
+func (e *redactedError) Error() string {
+    return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+    return e.err
+}
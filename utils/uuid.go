@@ -0,0 +1,45 @@
+package utils
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "regexp"
+    "time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// NewUUIDv7 returns a random RFC 9562 version 7 UUID. Its leading 48 bits are the Unix time in
+// milliseconds, so keys generated later sort later and inserts stay append-only in B-tree indexes.
+func NewUUIDv7() string {
+    var b [16]byte
+    if _, err := rand.Read(b[6:]); err != nil {
+        panic(err)
+    }
+    ms := uint64(time.Now().UnixMilli())
+    b[0] = byte(ms >> 40)
+    b[1] = byte(ms >> 32)
+    b[2] = byte(ms >> 24)
+    b[3] = byte(ms >> 16)
+    b[4] = byte(ms >> 8)
+    b[5] = byte(ms)
+    b[6] = b[6]&0x0f | 0x70 // version 7
+    b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
+
+    var s [36]byte
+    hex.Encode(s[0:8], b[0:4])
+    s[8] = '-'
+    hex.Encode(s[9:13], b[4:6])
+    s[13] = '-'
+    hex.Encode(s[14:18], b[6:8])
+    s[18] = '-'
+    hex.Encode(s[19:23], b[8:10])
+    s[23] = '-'
+    hex.Encode(s[24:], b[10:])
+    return string(s[:])
+}
+
+// IsUUID reports whether s is a lowercase hyphenated UUID.
+func IsUUID(s string) bool {
+    return uuidPattern.MatchString(s)
+}